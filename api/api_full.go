@@ -46,6 +46,10 @@ type ChainIO interface {
 
 const LookbackNoLimit = abi.ChainEpoch(-1)
 
+// ChainReadObjManyLimit is the maximum number of objects read per
+// ChainReadObjMany call.
+const ChainReadObjManyLimit = 1024
+
 //                       MODIFYING THE API INTERFACE
 //
 // NOTE: This is the V1 (Unstable) API - to add methods to the V0 (Stable) API
@@ -121,6 +125,12 @@ type FullNode interface {
 	// blockstore and returns raw bytes.
 	ChainReadObj(context.Context, cid.Cid) ([]byte, error) //perm:read
 
+	// ChainReadObjMany reads the ipld nodes referenced by the specified CIDs
+	// from chain blockstore and returns their raw bytes, in the same order,
+	// sparing a round trip per node when reading many. It fails if any of
+	// them is missing. At most 1024 CIDs can be read per call.
+	ChainReadObjMany(context.Context, []cid.Cid) ([][]byte, error) //perm:read
+
 	// ChainDeleteObj deletes node referenced by the given CID
 	ChainDeleteObj(context.Context, cid.Cid) error //perm:admin
 
@@ -754,6 +764,17 @@ type FullNode interface {
 	PaychVoucherList(context.Context, address.Address) ([]*paych.SignedVoucher, error)                                  //perm:write
 	PaychVoucherSubmit(context.Context, address.Address, *paych.SignedVoucher, []byte, []byte) (cid.Cid, error)         //perm:sign
 
+	// MethodGroup: Tvx
+	// The Tvx methods extract test vectors in-process, for tvx. They're only
+	// available when enabled with Tvx.EnableExtract in the node's config.
+
+	// TvxExtractMessage extracts a message test vector from the message with
+	// the supplied CID. The returned channel streams the progress events of the
+	// extraction, then the vector, JSON-encoded, in chunks (events of kind
+	// "vector"), and is closed when done. If the extraction fails, the last
+	// event carries the error.
+	TvxExtractMessage(ctx context.Context, msg cid.Cid, opts TvxExtractOpts) (<-chan TvxExtractEvent, error) //perm:admin
+
 	// MethodGroup: Node
 	// These methods are general node management and status commands
 
@@ -1252,3 +1273,41 @@ type PruneOpts struct {
 	MovingGC    bool
 	RetainState int64
 }
+
+// TvxExtractOpts are the options of an in-process test vector extraction.
+// They mirror the flags of tvx extract.
+type TvxExtractOpts struct {
+	// ID is the identifier to name the vector with; if empty, one is generated.
+	ID string
+	// Block is the block the message was included in, if known, to avoid
+	// scanning the chain; cid.Undef if unknown.
+	Block cid.Cid
+	// Retain is the state retention policy: accessed-cids (default) or
+	// accessed-actors.
+	Retain string
+	// Precursor is the precursor selection mode: participants (default) or
+	// all.
+	Precursor string
+
+	EmbedPrecursors  bool
+	IgnorePrecursors bool
+	MaxPrecursors    int
+
+	Selectors          []string
+	Hints              []string
+	RecordSyscalls     bool
+	IgnoreSanityChecks bool
+	Force              bool
+}
+
+// TvxExtractEvent is an event of an in-process test vector extraction.
+type TvxExtractEvent struct {
+	// Event is the kind of event: a progress event of the extraction, "vector"
+	// for a chunk of the vector, or "error" if the extraction failed.
+	Event  string
+	Fields map[string]interface{} `json:",omitempty"`
+	// Data is the chunk of the JSON-encoded vector, in "vector" events.
+	Data []byte `json:",omitempty"`
+	// Error is the error the extraction failed with, in "error" events.
+	Error string `json:",omitempty"`
+}
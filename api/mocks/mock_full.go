@@ -421,6 +421,21 @@ func (mr *MockFullNodeMockRecorder) ChainReadObj(arg0, arg1 interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChainReadObj", reflect.TypeOf((*MockFullNode)(nil).ChainReadObj), arg0, arg1)
 }
 
+// ChainReadObjMany mocks base method.
+func (m *MockFullNode) ChainReadObjMany(arg0 context.Context, arg1 []cid.Cid) ([][]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChainReadObjMany", arg0, arg1)
+	ret0, _ := ret[0].([][]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ChainReadObjMany indicates an expected call of ChainReadObjMany.
+func (mr *MockFullNodeMockRecorder) ChainReadObjMany(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChainReadObjMany", reflect.TypeOf((*MockFullNode)(nil).ChainReadObjMany), arg0, arg1)
+}
+
 // ChainSetHead mocks base method.
 func (m *MockFullNode) ChainSetHead(arg0 context.Context, arg1 types.TipSetKey) error {
 	m.ctrl.T.Helper()
@@ -3348,6 +3363,21 @@ func (mr *MockFullNodeMockRecorder) SyncValidateTipset(arg0, arg1 interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SyncValidateTipset", reflect.TypeOf((*MockFullNode)(nil).SyncValidateTipset), arg0, arg1)
 }
 
+// TvxExtractMessage mocks base method.
+func (m *MockFullNode) TvxExtractMessage(arg0 context.Context, arg1 cid.Cid, arg2 api.TvxExtractOpts) (<-chan api.TvxExtractEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TvxExtractMessage", arg0, arg1, arg2)
+	ret0, _ := ret[0].(<-chan api.TvxExtractEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TvxExtractMessage indicates an expected call of TvxExtractMessage.
+func (mr *MockFullNodeMockRecorder) TvxExtractMessage(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TvxExtractMessage", reflect.TypeOf((*MockFullNode)(nil).TvxExtractMessage), arg0, arg1, arg2)
+}
+
 // Version mocks base method.
 func (m *MockFullNode) Version(arg0 context.Context) (api.APIVersion, error) {
 	m.ctrl.T.Helper()
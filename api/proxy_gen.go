@@ -154,6 +154,8 @@ type FullNodeStruct struct {
 
 		ChainReadObj func(p0 context.Context, p1 cid.Cid) ([]byte, error) `perm:"read"`
 
+		ChainReadObjMany func(p0 context.Context, p1 []cid.Cid) ([][]byte, error) `perm:"read"`
+
 		ChainSetHead func(p0 context.Context, p1 types.TipSetKey) error `perm:"admin"`
 
 		ChainStatObj func(p0 context.Context, p1 cid.Cid, p2 cid.Cid) (ObjStat, error) `perm:"read"`
@@ -484,6 +486,8 @@ type FullNodeStruct struct {
 
 		SyncValidateTipset func(p0 context.Context, p1 types.TipSetKey) (bool, error) `perm:"read"`
 
+		TvxExtractMessage func(p0 context.Context, p1 cid.Cid, p2 TvxExtractOpts) (<-chan TvxExtractEvent, error) `perm:"admin"`
+
 		WalletBalance func(p0 context.Context, p1 address.Address) (types.BigInt, error) `perm:"read"`
 
 		WalletDefaultAddress func(p0 context.Context) (address.Address, error) `perm:"write"`
@@ -548,6 +552,8 @@ type GatewayStruct struct {
 
 		ChainReadObj func(p0 context.Context, p1 cid.Cid) ([]byte, error) ``
 
+		ChainReadObjMany func(p0 context.Context, p1 []cid.Cid) ([][]byte, error) ``
+
 		Discover func(p0 context.Context) (apitypes.OpenRPCDocument, error) ``
 
 		GasEstimateMessageGas func(p0 context.Context, p1 *types.Message, p2 *MessageSendSpec, p3 types.TipSetKey) (*types.Message, error) ``
@@ -1443,6 +1449,17 @@ func (s *FullNodeStub) ChainReadObj(p0 context.Context, p1 cid.Cid) ([]byte, err
 	return *new([]byte), ErrNotSupported
 }
 
+func (s *FullNodeStruct) ChainReadObjMany(p0 context.Context, p1 []cid.Cid) ([][]byte, error) {
+	if s.Internal.ChainReadObjMany == nil {
+		return *new([][]byte), ErrNotSupported
+	}
+	return s.Internal.ChainReadObjMany(p0, p1)
+}
+
+func (s *FullNodeStub) ChainReadObjMany(p0 context.Context, p1 []cid.Cid) ([][]byte, error) {
+	return *new([][]byte), ErrNotSupported
+}
+
 func (s *FullNodeStruct) ChainSetHead(p0 context.Context, p1 types.TipSetKey) error {
 	if s.Internal.ChainSetHead == nil {
 		return ErrNotSupported
@@ -3258,6 +3275,17 @@ func (s *FullNodeStub) SyncValidateTipset(p0 context.Context, p1 types.TipSetKey
 	return false, ErrNotSupported
 }
 
+func (s *FullNodeStruct) TvxExtractMessage(p0 context.Context, p1 cid.Cid, p2 TvxExtractOpts) (<-chan TvxExtractEvent, error) {
+	if s.Internal.TvxExtractMessage == nil {
+		return nil, ErrNotSupported
+	}
+	return s.Internal.TvxExtractMessage(p0, p1, p2)
+}
+
+func (s *FullNodeStub) TvxExtractMessage(p0 context.Context, p1 cid.Cid, p2 TvxExtractOpts) (<-chan TvxExtractEvent, error) {
+	return nil, ErrNotSupported
+}
+
 func (s *FullNodeStruct) WalletBalance(p0 context.Context, p1 address.Address) (types.BigInt, error) {
 	if s.Internal.WalletBalance == nil {
 		return *new(types.BigInt), ErrNotSupported
@@ -3555,6 +3583,17 @@ func (s *GatewayStub) ChainReadObj(p0 context.Context, p1 cid.Cid) ([]byte, erro
 	return *new([]byte), ErrNotSupported
 }
 
+func (s *GatewayStruct) ChainReadObjMany(p0 context.Context, p1 []cid.Cid) ([][]byte, error) {
+	if s.Internal.ChainReadObjMany == nil {
+		return *new([][]byte), ErrNotSupported
+	}
+	return s.Internal.ChainReadObjMany(p0, p1)
+}
+
+func (s *GatewayStub) ChainReadObjMany(p0 context.Context, p1 []cid.Cid) ([][]byte, error) {
+	return *new([][]byte), ErrNotSupported
+}
+
 func (s *GatewayStruct) Discover(p0 context.Context) (apitypes.OpenRPCDocument, error) {
 	if s.Internal.Discover == nil {
 		return *new(apitypes.OpenRPCDocument), ErrNotSupported
@@ -111,6 +111,12 @@ type FullNode interface {
 	// blockstore and returns raw bytes.
 	ChainReadObj(context.Context, cid.Cid) ([]byte, error) //perm:read
 
+	// ChainReadObjMany reads the ipld nodes referenced by the specified CIDs
+	// from chain blockstore and returns their raw bytes, in the same order,
+	// sparing a round trip per node when reading many. It fails if any of
+	// them is missing. At most 1024 CIDs can be read per call.
+	ChainReadObjMany(context.Context, []cid.Cid) ([][]byte, error) //perm:read
+
 	// ChainDeleteObj deletes node referenced by the given CID
 	ChainDeleteObj(context.Context, cid.Cid) error //perm:admin
 
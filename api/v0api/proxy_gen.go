@@ -82,6 +82,8 @@ type FullNodeStruct struct {
 
 		ChainReadObj func(p0 context.Context, p1 cid.Cid) ([]byte, error) `perm:"read"`
 
+		ChainReadObjMany func(p0 context.Context, p1 []cid.Cid) ([][]byte, error) `perm:"read"`
+
 		ChainSetHead func(p0 context.Context, p1 types.TipSetKey) error `perm:"admin"`
 
 		ChainStatObj func(p0 context.Context, p1 cid.Cid, p2 cid.Cid) (api.ObjStat, error) `perm:"read"`
@@ -444,6 +446,8 @@ type GatewayStruct struct {
 
 		ChainReadObj func(p0 context.Context, p1 cid.Cid) ([]byte, error) ``
 
+		ChainReadObjMany func(p0 context.Context, p1 []cid.Cid) ([][]byte, error) ``
+
 		GasEstimateMessageGas func(p0 context.Context, p1 *types.Message, p2 *api.MessageSendSpec, p3 types.TipSetKey) (*types.Message, error) ``
 
 		MpoolPush func(p0 context.Context, p1 *types.SignedMessage) (cid.Cid, error) ``
@@ -726,6 +730,17 @@ func (s *FullNodeStub) ChainReadObj(p0 context.Context, p1 cid.Cid) ([]byte, err
 	return *new([]byte), ErrNotSupported
 }
 
+func (s *FullNodeStruct) ChainReadObjMany(p0 context.Context, p1 []cid.Cid) ([][]byte, error) {
+	if s.Internal.ChainReadObjMany == nil {
+		return *new([][]byte), ErrNotSupported
+	}
+	return s.Internal.ChainReadObjMany(p0, p1)
+}
+
+func (s *FullNodeStub) ChainReadObjMany(p0 context.Context, p1 []cid.Cid) ([][]byte, error) {
+	return *new([][]byte), ErrNotSupported
+}
+
 func (s *FullNodeStruct) ChainSetHead(p0 context.Context, p1 types.TipSetKey) error {
 	if s.Internal.ChainSetHead == nil {
 		return ErrNotSupported
@@ -2662,6 +2677,17 @@ func (s *GatewayStub) ChainReadObj(p0 context.Context, p1 cid.Cid) ([]byte, erro
 	return *new([]byte), ErrNotSupported
 }
 
+func (s *GatewayStruct) ChainReadObjMany(p0 context.Context, p1 []cid.Cid) ([][]byte, error) {
+	if s.Internal.ChainReadObjMany == nil {
+		return *new([][]byte), ErrNotSupported
+	}
+	return s.Internal.ChainReadObjMany(p0, p1)
+}
+
+func (s *GatewayStub) ChainReadObjMany(p0 context.Context, p1 []cid.Cid) ([][]byte, error) {
+	return *new([][]byte), ErrNotSupported
+}
+
 func (s *GatewayStruct) GasEstimateMessageGas(p0 context.Context, p1 *types.Message, p2 *api.MessageSendSpec, p3 types.TipSetKey) (*types.Message, error) {
 	if s.Internal.GasEstimateMessageGas == nil {
 		return nil, ErrNotSupported
@@ -410,6 +410,21 @@ func (mr *MockFullNodeMockRecorder) ChainReadObj(arg0, arg1 interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChainReadObj", reflect.TypeOf((*MockFullNode)(nil).ChainReadObj), arg0, arg1)
 }
 
+// ChainReadObjMany mocks base method.
+func (m *MockFullNode) ChainReadObjMany(arg0 context.Context, arg1 []cid.Cid) ([][]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChainReadObjMany", arg0, arg1)
+	ret0, _ := ret[0].([][]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ChainReadObjMany indicates an expected call of ChainReadObjMany.
+func (mr *MockFullNodeMockRecorder) ChainReadObjMany(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChainReadObjMany", reflect.TypeOf((*MockFullNode)(nil).ChainReadObjMany), arg0, arg1)
+}
+
 // ChainSetHead mocks base method.
 func (m *MockFullNode) ChainSetHead(arg0 context.Context, arg1 types.TipSetKey) error {
 	m.ctrl.T.Helper()
@@ -34,6 +34,16 @@ func NewBuffered(base Blockstore) *BufferedBlockstore {
 	return bs
 }
 
+// NewUnbuffered returns a BufferedBlockstore writing through to the base
+// blockstore, as NewBuffered does when LOTUS_DISABLE_VM_BUF is set, without
+// the process-wide side effect of the environment variable.
+func NewUnbuffered(base Blockstore) *BufferedBlockstore {
+	return &BufferedBlockstore{
+		read:  base,
+		write: base,
+	}
+}
+
 func NewTieredBstore(r Blockstore, w Blockstore) *BufferedBlockstore {
 	return &BufferedBlockstore{
 		read:  r,
@@ -0,0 +1,42 @@
+// stm: #unit
+package blockstore
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferedBlockstore_Put(t *testing.T) {
+	ctx := context.Background()
+	base := NewMemory()
+
+	buf := NewBuffered(base)
+	require.NoError(t, buf.Put(ctx, b0))
+
+	has, err := buf.Has(ctx, b0.Cid())
+	require.NoError(t, err)
+	require.True(t, has)
+
+	has, err = base.Has(ctx, b0.Cid())
+	require.NoError(t, err)
+	require.False(t, has, "write buffered by NewBuffered reached the base blockstore")
+}
+
+func TestUnbufferedBlockstore_Put(t *testing.T) {
+	ctx := context.Background()
+	base := NewMemory()
+
+	buf := NewUnbuffered(base)
+	require.NoError(t, buf.Put(ctx, b0))
+	require.NoError(t, buf.PutMany(ctx, []blocks.Block{b1, b2}))
+
+	for _, b := range []blocks.Block{b0, b1, b2} {
+		has, err := base.Has(ctx, b.Cid())
+		require.NoError(t, err)
+		require.True(t, has, "write through NewUnbuffered didn't reach the base blockstore")
+	}
+	require.Equal(t, base, buf.Read())
+}
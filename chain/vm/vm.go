@@ -226,6 +226,10 @@ type VMOpts struct {
 	LookbackState  LookbackStateGetter
 	TipSetGetter   TipSetGetter
 	Tracing        bool
+	// UnbufferedWrites writes the state of the legacy VM through to Bstore as
+	// it's modified, rather than buffering it until the VM is flushed. The FVM
+	// ignores it.
+	UnbufferedWrites bool
 }
 
 func NewLegacyVM(ctx context.Context, opts *VMOpts) (*LegacyVM, error) {
@@ -234,6 +238,9 @@ func NewLegacyVM(ctx context.Context, opts *VMOpts) (*LegacyVM, error) {
 	}
 
 	buf := blockstore.NewBuffered(opts.Bstore)
+	if opts.UnbufferedWrites {
+		buf = blockstore.NewUnbuffered(opts.Bstore)
+	}
 	cst := cbor.NewCborStore(buf)
 	state, err := state.LoadStateTree(cst, opts.StateBase)
 	if err != nil {
@@ -0,0 +1,69 @@
+// stm: #unit
+package vm
+
+import (
+	"context"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestLegacyVMUnbufferedWrites(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name       string
+		unbuffered bool
+	}{
+		{name: "buffered", unbuffered: false},
+		{name: "unbuffered", unbuffered: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			base := blockstore.NewMemory()
+			st, err := state.NewStateTree(cbor.NewCborStore(base), types.StateTreeVersion4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			root, err := st.Flush(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			vm, err := NewLegacyVM(ctx, &VMOpts{
+				StateBase: root,
+				Bstore:    base,
+				CircSupplyCalc: func(context.Context, abi.ChainEpoch, *state.StateTree) (abi.TokenAmount, error) {
+					return big.Zero(), nil
+				},
+				NetworkVersion:   network.Version15,
+				BaseFee:          big.Zero(),
+				UnbufferedWrites: tc.unbuffered,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := cbg.CborInt(42)
+			c, err := vm.ActorStore(ctx).Put(ctx, &v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			has, err := base.Has(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if has != tc.unbuffered {
+				t.Fatalf("expected the write to reach the base blockstore: %t; got: %t", tc.unbuffered, has)
+			}
+		})
+	}
+}
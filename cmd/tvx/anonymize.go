@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	"github.com/urfave/cli/v2"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/account"
+	init_ "github.com/filecoin-project/lotus/chain/actors/builtin/init"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+var anonymizeFlags struct {
+	file  string
+	out   string
+	id    string
+	salt  string
+	force bool
+}
+
+var anonymizeCmd = &cli.Command{
+	Name: "anonymize",
+	Description: `rewrite the wallet addresses in a message-class test vector to synthetic addresses.
+
+   Every secp256k1 and BLS address referenced by the messages of the vector, or
+   occurring in the state it retains, is mapped to a synthetic address of the
+   same protocol, derived deterministically from the original address and the
+   supplied salt. The senders and receivers of the messages, the init actor
+   address map and the state of account actors are rewritten. ID addresses,
+   actor (f2) and delegated (f4) addresses are preserved, as execution depends
+   on them. Message parameters are not rewritten.
+
+   The rewritten vector is re-executed to compute its postconditions. If the
+   exit code or return value of any message differs from the original vector,
+   anonymization is aborted, unless --force is supplied. Gas used is expected to
+   differ slightly, as the rewritten state trees are laid out differently.
+
+   References to the original chain (message, inclusion and execution tipsets)
+   and diagnostics are dropped from the vector.
+
+   Without a secret salt, anyone can confirm whether a known address appears
+   in the vector by deriving its synthetic address.`,
+	Action: runAnonymize,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "file",
+			Usage:       "test vector to anonymize",
+			TakesFile:   true,
+			Required:    true,
+			Destination: &anonymizeFlags.file,
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Usage:       "file to write the anonymized vector to; if empty or '-', the vector is written to stdout",
+			Destination: &anonymizeFlags.out,
+		},
+		&cli.StringFlag{
+			Name:        "id",
+			Usage:       "identifier of the anonymized vector; defaults to anonymized-<CID of the first rewritten message>",
+			Destination: &anonymizeFlags.id,
+		},
+		&cli.StringFlag{
+			Name:        "salt",
+			Usage:       "secret salt the synthetic addresses are derived with",
+			EnvVars:     []string{"TVX_ANONYMIZE_SALT"},
+			Destination: &anonymizeFlags.salt,
+		},
+		&cli.BoolFlag{
+			Name:        "force",
+			Usage:       "emit the anonymized vector even if anonymization changes the exit codes or return values of its messages",
+			Destination: &anonymizeFlags.force,
+		},
+	},
+}
+
+func runAnonymize(_ *cli.Context) error {
+	ctx := context.Background()
+
+	tv, err := readVector(anonymizeFlags.file)
+	if err != nil {
+		return err
+	}
+	if tv.Class != schema.ClassMessage {
+		return fmt.Errorf("vector %s is of class %s; only message vectors can be anonymized", anonymizeFlags.file, tv.Class)
+	}
+	if tv.Selector[conformance.SelectorEpochCron] == "true" {
+		// cron touches actors beyond the participants of the messages, whose
+		// states aren't anonymized.
+		return fmt.Errorf("vector %s applies cron between its messages; it can't be anonymized", anonymizeFlags.file)
+	}
+	if anonymizeFlags.salt == "" {
+		log.Println("no salt supplied; synthetic addresses can be confirmed by deriving them from known addresses")
+	}
+
+	anon, err := anonymizeVector(ctx, tv, newAddressMapper(anonymizeFlags.salt), anonymizeFlags.force)
+	if err != nil {
+		return err
+	}
+	if anonymizeFlags.id != "" {
+		anon.Meta.ID = anonymizeFlags.id
+	}
+	return writeVector(anon, anonymizeFlags.out)
+}
+
+// addressMapper maps secp256k1 and BLS addresses to synthetic addresses of
+// the same protocol, derived from the HMAC-SHA512 of the original address
+// under a salt.
+type addressMapper struct {
+	salt   []byte
+	mapped map[address.Address]address.Address
+}
+
+func newAddressMapper(salt string) *addressMapper {
+	return &addressMapper{salt: []byte(salt), mapped: make(map[address.Address]address.Address)}
+}
+
+// anonymizable returns whether the address is rewritten by the mapper.
+func anonymizable(a address.Address) bool {
+	return a.Protocol() == address.SECP256K1 || a.Protocol() == address.BLS
+}
+
+// mapAddress returns the synthetic address of a, or a itself if it's not
+// anonymizable.
+func (m *addressMapper) mapAddress(a address.Address) (address.Address, error) {
+	if !anonymizable(a) {
+		return a, nil
+	}
+	if s, ok := m.mapped[a]; ok {
+		return s, nil
+	}
+
+	h := hmac.New(sha512.New, m.salt)
+	_, _ = h.Write(a.Bytes())
+	sum := h.Sum(nil)
+
+	var (
+		s   address.Address
+		err error
+	)
+	switch a.Protocol() {
+	case address.SECP256K1:
+		s, err = address.NewSecp256k1Address(sum)
+	case address.BLS:
+		s, err = address.NewBLSAddress(sum[:address.BlsPublicKeyBytes])
+	}
+	if err != nil {
+		return address.Undef, fmt.Errorf("failed to derive synthetic address for %s: %w", a, err)
+	}
+	m.mapped[a] = s
+	return s, nil
+}
+
+// anonymizeVector returns a copy of the message vector, with its addresses
+// rewritten through the mapper and its postconditions recomputed.
+func anonymizeVector(ctx context.Context, tv *schema.TestVector, m *addressMapper, force bool) (*schema.TestVector, error) {
+	bs, err := conformance.LoadBlockstore(tv.CAR)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vector CAR: %w", err)
+	}
+	cst := cbor.NewCborStore(bs)
+
+	// find the addresses occurring in the retained state.
+	found, err := scanAddresses(ctx, bs)
+	if err != nil {
+		return nil, err
+	}
+
+	// rewrite the senders and receivers of the messages.
+	var (
+		msgs  = make([]*types.Message, 0, len(tv.ApplyMessages))
+		apply = make([]schema.Message, 0, len(tv.ApplyMessages))
+	)
+	for i, am := range tv.ApplyMessages {
+		msg, _, err := conformance.DecodeVectorMessage(am.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode message %d: %w", i, err)
+		}
+		found[msg.From], found[msg.To] = struct{}{}, struct{}{}
+		for a := range found {
+			if anonymizable(a) && bytes.Contains(msg.Params, a.Bytes()) {
+				log.Printf("parameters of message %d reference address %s, and are not rewritten", i, a)
+			}
+		}
+		if msg.From, err = m.mapAddress(msg.From); err != nil {
+			return nil, err
+		}
+		if msg.To, err = m.mapAddress(msg.To); err != nil {
+			return nil, err
+		}
+		b, err := msg.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+		apply = append(apply, schema.Message{Bytes: b, EpochOffset: am.EpochOffset})
+	}
+
+	preroot, err := anonymizeState(ctx, cst, tv.Pre.StateTree.RootCID, found, m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymize pre-state: %w", err)
+	}
+	log.Printf("anonymized %d addresses; pre-state root: %s", len(m.mapped), preroot)
+
+	// re-execute the messages on the anonymized pre-state.
+	var (
+		variant   = tv.Pre.Variants[0]
+		epoch     = abi.ChainEpoch(variant.Epoch)
+		root      = preroot
+		r         = new(reportingReporter)
+		driver    = conformance.NewDriver(ctx, tv.Selector, conformance.DriverOpts{DisableVMFlush: true})
+		receipts  = make([]*schema.Receipt, 0, len(msgs))
+		diverging []string
+		execErr   error
+	)
+	err = recoverFatal(func() {
+		for i, msg := range msgs {
+			if tv.ApplyMessages[i].EpochOffset != nil {
+				epoch += abi.ChainEpoch(*tv.ApplyMessages[i].EpochOffset)
+			}
+			params := conformance.ExecuteMessageParams{
+				Preroot:        root,
+				Epoch:          epoch,
+				Message:        msg,
+				BaseFee:        conformance.BaseFeeOrDefault(tv.Pre.BaseFee),
+				CircSupply:     conformance.CircSupplyOrDefault(tv.Pre.CircSupply),
+				Rand:           conformance.NewReplayingRand(r, tv.Randomness),
+				NetworkVersion: network.Version(variant.NetworkVersion),
+			}
+			var (
+				ret *vm.ApplyRet
+				err error
+			)
+			if tv.Selector[conformance.SelectorImplicitMessages] == "true" {
+				ret, root, err = driver.ExecuteImplicitMessage(bs, params)
+			} else {
+				ret, root, err = driver.ExecuteMessage(bs, params)
+			}
+			if err != nil {
+				execErr = fmt.Errorf("failed to execute message %d: %w", i, err)
+				return
+			}
+
+			expected := tv.Post.Receipts[i]
+			if int64(ret.ExitCode) != expected.ExitCode || !bytes.Equal(ret.Return, expected.ReturnValue) {
+				diverging = append(diverging, fmt.Sprintf("message %d: expected exit code %d and return %x, got %d and %x",
+					i, expected.ExitCode, expected.ReturnValue, ret.ExitCode, ret.Return))
+			} else if ret.GasUsed != expected.GasUsed {
+				log.Printf("gas used by message %d changed from %d to %d", i, expected.GasUsed, ret.GasUsed)
+			}
+			receipts = append(receipts, &schema.Receipt{
+				ExitCode:    int64(ret.ExitCode),
+				ReturnValue: ret.Return,
+				GasUsed:     ret.GasUsed,
+			})
+		}
+	})
+	if err == nil {
+		err = execErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(diverging) > 0 {
+		if !force {
+			return nil, fmt.Errorf("anonymization changed the outcome of the vector:\n%s", strings.Join(diverging, "\n"))
+		}
+		log.Printf("anonymization changed the outcome of the vector; proceeding anyway:\n%s", strings.Join(diverging, "\n"))
+	}
+
+	// write the CAR, including the anonymized states and the blocks
+	// referenced by selectors, e.g. syscall recordings.
+	roots := []cid.Cid{preroot, root}
+	if s, ok := tv.Selector[conformance.SelectorRecordedSyscalls]; ok {
+		c, err := cid.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s selector: %w", conformance.SelectorRecordedSyscalls, err)
+		}
+		roots = append(roots, c)
+	}
+	carBytes, err := extractor.EncodeCAR(func(w io.Writer) error {
+		return writeSparseCAR(ctx, w, bs, roots...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	carWritten(len(carBytes))
+
+	anon := *tv
+	anon.CAR = carBytes
+	anon.ApplyMessages = apply
+	anon.Diagnostics = nil
+
+	pre := *tv.Pre
+	pre.StateTree = &schema.StateTree{RootCID: preroot}
+	anon.Pre = &pre
+
+	post := *tv.Post
+	post.StateTree = &schema.StateTree{RootCID: root}
+	post.Receipts = receipts
+	anon.Post = &post
+
+	meta := *tv.Meta
+	meta.ID = fmt.Sprintf("anonymized-%s", msgs[0].Cid())
+	meta.Gen = nil
+	for _, g := range tv.Meta.Gen {
+		switch {
+		case strings.HasPrefix(g.Source, "message:"),
+			strings.HasPrefix(g.Source, "inclusion_tipset:"),
+			strings.HasPrefix(g.Source, "execution_tipset:"),
+			strings.HasPrefix(g.Source, "precursor:"),
+			g.Source == extractor.PrecursorOrderSource,
+			strings.HasPrefix(g.Source, "msig_proposal:"),
+			strings.HasPrefix(g.Source, "paych:"),
+			strings.HasPrefix(g.Source, "paych_message:"),
+			// the lookback headers aren't carried over to the anonymized CAR.
+			strings.HasPrefix(g.Source, conformance.LookbackSourcePrefix),
+			g.Source == GenSigner, g.Source == GenSignature:
+			continue
+		}
+		meta.Gen = append(meta.Gen, g)
+	}
+	meta.Tags = append(append([]string(nil), tv.Meta.Tags...), "anonymized")
+	anon.Meta = &meta
+
+	return &anon, nil
+}
+
+// scanAddresses returns the non-ID addresses occurring as byte strings in the
+// CBOR blocks of the blockstore.
+func scanAddresses(ctx context.Context, bs blockstore.Blockstore) (map[address.Address]struct{}, error) {
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[address.Address]struct{})
+	var scan func(v interface{})
+	scan = func(v interface{}) {
+		switch v := v.(type) {
+		case []byte:
+			if a, err := address.NewFromBytes(v); err == nil && a.Protocol() != address.ID {
+				found[a] = struct{}{}
+			}
+		case []interface{}:
+			for _, e := range v {
+				scan(e)
+			}
+		case map[interface{}]interface{}:
+			for _, e := range v {
+				scan(e)
+			}
+		case map[string]interface{}:
+			for _, e := range v {
+				scan(e)
+			}
+		}
+	}
+
+	for c := range keys {
+		if c.Prefix().Codec != cid.DagCBOR {
+			continue
+		}
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := cbor.DecodeInto(blk.RawData(), &v); err != nil {
+			continue
+		}
+		scan(v)
+	}
+	return found, nil
+}
+
+// anonymizeState rewrites the init actor address map, and the state of the
+// account actors, of the state tree at root. The address map is rebuilt with
+// the entries of the supplied addresses only, as the retained map is usually
+// sparse.
+func anonymizeState(ctx context.Context, cst cbor.IpldStore, root cid.Cid, addrs map[address.Address]struct{}, m *addressMapper) (cid.Cid, error) {
+	store := adt.WrapStore(ctx, cst)
+	st, err := state.LoadStateTree(cst, root)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	initActor, err := st.GetActor(init_.Address)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to load init actor: %w", err)
+	}
+	initState, err := init_.Load(store, initActor)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// an empty address map of the right version.
+	empty, err := init_.MakeState(store, initState.ActorVersion(), "")
+	if err != nil {
+		return cid.Undef, err
+	}
+	amap, err := empty.AddressMap()
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	ids := make(map[address.Address]abi.ActorID)
+	for a := range addrs {
+		if a.Protocol() == address.ID {
+			continue
+		}
+		// entries whose path isn't retained were not accessed, and are dropped.
+		resolved, ok, err := initState.ResolveAddress(a)
+		if err != nil || !ok {
+			continue
+		}
+		id, err := address.IDFromAddress(resolved)
+		if err != nil {
+			return cid.Undef, err
+		}
+		s, err := m.mapAddress(a)
+		if err != nil {
+			return cid.Undef, err
+		}
+		v := cbg.CborInt(id)
+		if err := amap.Put(abi.AddrKey(s), &v); err != nil {
+			return cid.Undef, err
+		}
+		ids[a] = abi.ActorID(id)
+	}
+
+	mroot, err := amap.Root()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := initState.SetAddressMap(mroot); err != nil {
+		return cid.Undef, err
+	}
+	head, err := store.Put(ctx, initState)
+	if err != nil {
+		return cid.Undef, err
+	}
+	initActor.Head = head
+	if err := st.SetActor(init_.Address, initActor); err != nil {
+		return cid.Undef, err
+	}
+
+	// rewrite the public key addresses of account actors.
+	for a, id := range ids {
+		if !anonymizable(a) {
+			continue
+		}
+		idAddr, _ := address.NewIDAddress(uint64(id))
+		act, err := st.GetActor(idAddr)
+		if err != nil || !builtin.IsAccountActor(act.Code) {
+			continue
+		}
+		acct, err := account.Load(store, act)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if pk, err := acct.PubkeyAddress(); err != nil || pk != a {
+			continue
+		}
+		s, _ := m.mapAddress(a)
+		anon, err := account.MakeState(store, acct.ActorVersion(), s)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if act.Head, err = store.Put(ctx, anon.GetState()); err != nil {
+			return cid.Undef, err
+		}
+		if err := st.SetActor(idAddr, act); err != nil {
+			return cid.Undef, err
+		}
+	}
+
+	return st.Flush(ctx)
+}
+
+// writeSparseCAR writes the DAGs under the roots as a CAR, skipping the
+// blocks missing from the blockstore, as retained state trees are sparse.
+func writeSparseCAR(ctx context.Context, w io.Writer, bs blockstore.Blockstore, roots ...cid.Cid) error {
+	walk := func(nd format.Node) (out []*format.Link, err error) {
+		for _, link := range nd.Links() {
+			if link.Cid.Prefix().Codec == cid.FilCommitmentSealed || link.Cid.Prefix().Codec == cid.FilCommitmentUnsealed {
+				continue
+			}
+			if has, err := bs.Has(ctx, link.Cid); err != nil {
+				return nil, err
+			} else if has {
+				out = append(out, link)
+			}
+		}
+		return out, nil
+	}
+	dserv := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	return car.WriteCarWithWalker(ctx, dserv, roots, w, walk)
+}
@@ -0,0 +1,42 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+)
+
+func TestAddressMapper(t *testing.T) {
+	secp, err := address.NewSecp256k1Address([]byte("pubkey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bls, err := address.NewBLSAddress(make([]byte, address.BlsPublicKeyBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := address.NewIDAddress(1000)
+
+	m := newAddressMapper("salt")
+	for _, a := range []address.Address{secp, bls} {
+		s, err := m.mapAddress(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s == a || s.Protocol() != a.Protocol() {
+			t.Fatalf("expected synthetic address of protocol %d for %s, got %s", a.Protocol(), a, s)
+		}
+		// synthetic addresses are deterministic, and depend on the salt.
+		if again, _ := newAddressMapper("salt").mapAddress(a); again != s {
+			t.Fatalf("expected deterministic synthetic address for %s", a)
+		}
+		if other, _ := newAddressMapper("other").mapAddress(a); other == s {
+			t.Fatalf("expected synthetic address for %s to depend on the salt", a)
+		}
+	}
+
+	if s, _ := m.mapAddress(id); s != id {
+		t.Fatalf("expected ID address to be preserved, got %s", s)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// assertFlags controls the strictness of receipt assertions, both when
+// performing sanity checks during extraction, and when executing vectors.
+var assertFlags conformance.AssertOpts
+
+// assertCmdFlags are the flags that populate assertFlags, shared by the
+// extract and exec commands.
+var assertCmdFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:        "assert-exit-code-only",
+		Usage:       "only compare exit codes when asserting receipts",
+		Destination: &assertFlags.ExitCodeOnly,
+	},
+	&cli.Float64Flag{
+		Name:        "assert-gas-tolerance",
+		Usage:       "tolerated delta of gas used when asserting receipts, as a percentage of the expected gas used",
+		Destination: &assertFlags.GasTolerance,
+	},
+	&cli.BoolFlag{
+		Name:        "assert-ignore-return",
+		Usage:       "do not compare return values when asserting receipts",
+		Destination: &assertFlags.IgnoreReturn,
+	},
+	&cli.BoolFlag{
+		Name:        "assert-ignore-traces",
+		Usage:       "do not compare the call trees of messages of vectors that record them",
+		Destination: &assertFlags.IgnoreTraces,
+	},
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-isatty"
+
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// batchDisplayWidth is the width the lines of the live display are truncated
+// to, so that they don't wrap, which would break the redraws.
+const batchDisplayWidth = 100
+
+// batchDisplay displays the progress of the concurrent extractions of a
+// batch. When its output is a terminal, the display is live: a bar of the
+// overall progress and a line per worker, redrawn in place every second, with
+// the logs written through it interleaved above. Otherwise, it displays
+// nothing, and the progress is followed through the logs.
+type batchDisplay struct {
+	out  io.Writer
+	live bool
+
+	lk        sync.Mutex
+	total     int
+	succeeded int
+	failed    int
+	skipped   int
+	workers   []workerStatus
+	// lines is the number of lines of the last draw, to clear on redraw.
+	lines int
+}
+
+// workerStatus is the status of the extraction a worker is running; the
+// zero value is that of an idle worker.
+type workerStatus struct {
+	id        string
+	precursor string
+	// event is the last progress event of the extraction.
+	event   string
+	started time.Time
+}
+
+func newBatchDisplay(out io.Writer, workers, total int) *batchDisplay {
+	d := &batchDisplay{out: out, total: total, workers: make([]workerStatus, workers)}
+	if f, ok := out.(*os.File); ok {
+		d.live = isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+	}
+	return d
+}
+
+// start records that the worker started extracting the vector, with the
+// precursor selection mode.
+func (d *batchDisplay) start(w int, id, precursor string) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.workers[w] = workerStatus{id: id, precursor: precursor, started: time.Now()}
+}
+
+// event records a progress event of the extraction the worker is running.
+func (d *batchDisplay) event(w int, event string, kvs ...interface{}) {
+	var index, total, to interface{}
+	for i := 0; i+1 < len(kvs); i += 2 {
+		switch kvs[i] {
+		case "index":
+			index = kvs[i+1]
+		case "total":
+			total = kvs[i+1]
+		case "to":
+			to = kvs[i+1]
+		}
+	}
+	if i, ok := index.(int); ok && total != nil {
+		event = fmt.Sprintf("%s %d/%v", event, i+1, total)
+	}
+
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.workers[w].event = event
+	// the precursors of the extraction were escalated.
+	if p, ok := to.(string); ok && event == extractor.EventPrecursorsEscalated {
+		d.workers[w].precursor = p
+	}
+}
+
+// done records the outcome of the extraction of the worker, which is idle
+// from then on.
+func (d *batchDisplay) done(w int, res extractManyResult) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	switch {
+	case res.skipped:
+		d.skipped++
+	case res.err != nil:
+		d.failed++
+	default:
+		d.succeeded++
+	}
+	d.workers[w] = workerStatus{}
+}
+
+// Write writes log output above the live display.
+func (d *batchDisplay) Write(p []byte) (int, error) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	if !d.live {
+		return d.out.Write(p)
+	}
+	d.clear()
+	n, err := d.out.Write(p)
+	d.draw()
+	return n, err
+}
+
+// run redraws the live display every second, until the returned function is
+// called, which draws it a last time, and leaves it in place.
+func (d *batchDisplay) run() (stop func()) {
+	if !d.live {
+		return func() {}
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				d.redraw()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		d.redraw()
+		d.lk.Lock()
+		defer d.lk.Unlock()
+		d.live = false
+	}
+}
+
+func (d *batchDisplay) redraw() {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.clear()
+	d.draw()
+}
+
+// clear moves the cursor up to the first line of the last draw, and clears
+// the screen from there.
+func (d *batchDisplay) clear() {
+	if d.lines > 0 {
+		_, _ = fmt.Fprintf(d.out, "\x1b[%dA\x1b[J", d.lines)
+	}
+	d.lines = 0
+}
+
+func (d *batchDisplay) draw() {
+	lines := d.render(time.Now())
+	for _, l := range lines {
+		_, _ = fmt.Fprintln(d.out, l)
+	}
+	d.lines = len(lines)
+}
+
+// render renders the lines of the display at the time.
+func (d *batchDisplay) render(now time.Time) []string {
+	const barWidth = 30
+	processed := d.succeeded + d.failed + d.skipped
+	filled := barWidth
+	if d.total > 0 {
+		filled = barWidth * processed / d.total
+	}
+	lines := []string{fmt.Sprintf("[%s%s] %d/%d: %d succeeded, %d failed, %d skipped",
+		strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		processed, d.total, d.succeeded, d.failed, d.skipped)}
+
+	for i, w := range d.workers {
+		l := fmt.Sprintf("  worker %d: idle", i+1)
+		if w.id != "" {
+			l = fmt.Sprintf("  worker %d: %s (%s) %s", i+1, w.id, w.precursor, now.Sub(w.started).Truncate(time.Second))
+			if w.event != "" {
+				l += ", " + w.event
+			}
+		}
+		if len(l) > batchDisplayWidth {
+			l = l[:batchDisplayWidth-3] + "..."
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+const (
+	benchModeCold = "cold"
+	benchModeWarm = "warm"
+	benchModeBoth = "both"
+)
+
+var benchFlags struct {
+	file       string
+	iterations int
+	mode       string
+}
+
+var benchCmd = &cli.Command{
+	Name: "bench",
+	Description: "execute one or many test vectors repeatedly, reporting wall time, allocations and gas throughput; " +
+		"in cold mode, the vector CAR is loaded into a fresh blockstore on every iteration; " +
+		"in warm mode, the blockstore is loaded once (in an untimed warm-up iteration) and reused",
+	Action: runBench,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "file",
+			Usage:       "input vector file, or directory or archive of vector files",
+			TakesFile:   true,
+			Required:    true,
+			Destination: &benchFlags.file,
+		},
+		&cli.IntFlag{
+			Name:        "iterations",
+			Aliases:     []string{"n"},
+			Usage:       "number of timed executions of each vector",
+			Value:       10,
+			Destination: &benchFlags.iterations,
+		},
+		&cli.StringFlag{
+			Name:        "mode",
+			Usage:       "blockstore mode; values: 'cold', 'warm', 'both'",
+			Value:       benchModeBoth,
+			Destination: &benchFlags.mode,
+		},
+	}, profileCmdFlags...),
+}
+
+// benchResult holds the measurements of the executions of a vector in a
+// blockstore mode.
+type benchResult struct {
+	vector     string
+	mode       string
+	iterations int
+	total      time.Duration
+	min        time.Duration
+	mallocs    uint64
+	bytes      uint64
+	gas        int64
+}
+
+func runBench(_ *cli.Context) error {
+	if benchFlags.iterations < 1 {
+		return fmt.Errorf("iterations must be at least 1")
+	}
+
+	var modes []string
+	switch benchFlags.mode {
+	case benchModeCold, benchModeWarm:
+		modes = []string{benchFlags.mode}
+	case benchModeBoth:
+		modes = []string{benchModeCold, benchModeWarm}
+	default:
+		return fmt.Errorf("unknown bench mode: %s", benchFlags.mode)
+	}
+
+	// tally the gas used by top-level messages, implicit messages included.
+	var gas int64
+	opts := conformance.ExecuteOpts{DriverOpts: conformance.DriverOpts{Hooks: &conformance.DriverHooks{
+		OnSubcall: func(depth int, trace *types.ExecutionTrace) {
+			if depth == 0 && trace.MsgRct != nil {
+				gas += trace.MsgRct.GasUsed
+			}
+		},
+	}}}
+
+	stopProfiling, err := startProfiling()
+	defer stopProfiling()
+	if err != nil {
+		return err
+	}
+
+	var results []benchResult
+	err = walkVectorFiles(benchFlags.file, func(path string, content []byte) error {
+		var tv schema.TestVector
+		if err := json.Unmarshal(content, &tv); err != nil {
+			return fmt.Errorf("failed to decode test vector %s: %w", path, err)
+		}
+		for _, mode := range modes {
+			log.Printf("benchmarking vector %s (%s, %d iterations)", path, mode, benchFlags.iterations)
+			res, err := benchVector(&tv, mode, benchFlags.iterations, &gas, opts)
+			if err != nil {
+				return fmt.Errorf("failed to benchmark vector %s: %w", path, err)
+			}
+			res.vector = path
+			results = append(results, res)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	printBenchResults(os.Stdout, results)
+	return nil
+}
+
+// benchVector executes the vector the supplied number of times in the
+// supplied blockstore mode, with the supplied options.
+func benchVector(tv *schema.TestVector, mode string, iterations int, gas *int64, opts conformance.ExecuteOpts) (benchResult, error) {
+	opts.ReuseBlockstores = mode == benchModeWarm
+	defer conformance.ResetReusedBlockstores()
+
+	// silence the execution logs; failures are surfaced through the reporter.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+
+	res := benchResult{mode: mode, iterations: iterations}
+
+	exec := func() error {
+		r := new(conformance.LogReporter)
+		if _, err := executeTestVector(context.Background(), r, *tv, opts); err != nil {
+			return err
+		}
+		if r.Failed() {
+			return fmt.Errorf("vector execution failed; run it with 'tvx exec' for details")
+		}
+		return nil
+	}
+
+	if mode == benchModeWarm {
+		// warm up the blockstore.
+		if err := exec(); err != nil {
+			return res, err
+		}
+	}
+
+	var before, after runtime.MemStats
+	for i := 0; i < iterations; i++ {
+		*gas = 0
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		start := time.Now()
+		if err := exec(); err != nil {
+			return res, err
+		}
+		took := time.Since(start)
+
+		runtime.ReadMemStats(&after)
+
+		res.total += took
+		if res.min == 0 || took < res.min {
+			res.min = took
+		}
+		res.mallocs += after.Mallocs - before.Mallocs
+		res.bytes += after.TotalAlloc - before.TotalAlloc
+		res.gas += *gas
+	}
+	return res, nil
+}
+
+func printBenchResults(w io.Writer, results []benchResult) {
+	tw := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "vector\tmode\titerations\tmean\tmin\tallocs/op\tbytes/op\tgas/op\tgas/s")
+	for _, r := range results {
+		n := uint64(r.iterations)
+		mean := r.total / time.Duration(r.iterations)
+		var gasPerSec float64
+		if r.total > 0 {
+			gasPerSec = float64(r.gas) / r.total.Seconds()
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%d\t%d\t%d\t%.0f\n",
+			r.vector, r.mode, r.iterations, mean, r.min, r.mallocs/n, r.bytes/n, r.gas/int64(r.iterations), gasPerSec)
+	}
+	_ = tw.Flush()
+}
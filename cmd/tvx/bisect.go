@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+var bisectFlags struct {
+	actor       string
+	method      int64
+	from        int64
+	to          int64
+	maxMessages int
+	outdir      string
+}
+
+var bisectCmd = &cli.Command{
+	Name: "bisect",
+	Description: "binary-search an epoch range for the first epoch at which the local execution of messages diverges " +
+		"from their on-chain receipts. At every probed epoch, messages matching the actor and method filters are " +
+		"extracted and executed locally, as tvx extract does. Epochs without matching messages are skipped over. " +
+		"The search assumes that, once local execution diverges, it keeps diverging for the rest of the range",
+	Action: runBisect,
+	Before: initialize,
+	After:  destroy,
+	Flags: []cli.Flag{
+		&repoFlag,
+		&apiFlag,
+		&rpcQPSFlag,
+		&rpcConcurrencyFlag,
+		&cli.StringFlag{
+			Name:        "actor",
+			Usage:       "only probe messages sent to actors of this code name (e.g. storageminer, or fil/9/storageminer); if empty, all actors",
+			Destination: &bisectFlags.actor,
+		},
+		&cli.Int64Flag{
+			Name:        "method",
+			Usage:       "only probe messages invoking this method number; if negative, all methods",
+			Value:       -1,
+			Destination: &bisectFlags.method,
+		},
+		&cli.Int64Flag{
+			Name:        "from",
+			Usage:       "first epoch of the range; local execution is expected to match the chain here",
+			Required:    true,
+			Destination: &bisectFlags.from,
+		},
+		&cli.Int64Flag{
+			Name:        "to",
+			Usage:       "last epoch of the range; it must include matching messages, whose local execution is expected to diverge from the chain",
+			Required:    true,
+			Destination: &bisectFlags.to,
+		},
+		&cli.IntFlag{
+			Name:        "max-messages",
+			Usage:       "maximum number of matching messages to execute at every probed epoch",
+			Value:       5,
+			Destination: &bisectFlags.maxMessages,
+		},
+		&cli.StringFlag{
+			Name:        "outdir",
+			Usage:       "directory to write the vectors of diverging messages to; if empty, vectors are discarded",
+			TakesFile:   true,
+			Destination: &bisectFlags.outdir,
+		},
+	},
+}
+
+// bisectProbe is the outcome of probing an epoch.
+type bisectProbe struct {
+	// epoch is the inclusion epoch of the probed messages.
+	epoch abi.ChainEpoch
+	// diverged is true if any of the probed messages diverged.
+	diverged bool
+	// vectors are the vectors of the diverging messages.
+	vectors []string
+}
+
+func runBisect(_ *cli.Context) error {
+	ctx := context.Background()
+
+	lo, hi := abi.ChainEpoch(bisectFlags.from), abi.ChainEpoch(bisectFlags.to)
+	if lo >= hi {
+		return fmt.Errorf("invalid epoch range [%d, %d]", lo, hi)
+	}
+
+	dir := bisectFlags.outdir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "tvx-bisect")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(tmp) //nolint:errcheck
+		dir = tmp
+	}
+
+	// verify the ends of the range.
+	first, ok, err := probeEpoch(ctx, dir, lo, hi)
+	if err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("no matching messages found in epoch range [%d, %d]", lo, hi)
+	} else if first.diverged {
+		log.Println(color.HiRedString("local execution already diverges at epoch %d, the first probed epoch of the range", first.epoch))
+		printDivergence(first)
+		return nil
+	}
+	lo = first.epoch
+
+	last, ok, err := probeEpoch(ctx, dir, hi, hi+1)
+	if err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("no matching messages found at epoch %d, the end of the range", hi)
+	} else if !last.diverged {
+		return fmt.Errorf("local execution doesn't diverge at epoch %d; nothing to bisect", hi)
+	}
+
+	// invariant: local execution matches at lo, and diverges at last.epoch.
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		log.Printf("bisecting: last matching epoch %d, first diverging epoch %d; probing %d", lo, last.epoch, mid)
+
+		p, ok, err := probeEpoch(ctx, dir, mid, hi)
+		switch {
+		case err != nil:
+			return err
+		case !ok:
+			// no matching messages in [mid, hi).
+			hi = mid
+		case p.diverged:
+			hi, last = mid, p
+		default:
+			lo = p.epoch
+		}
+	}
+
+	log.Println(color.HiRedString("local execution matches the chain at epoch %d and first diverges at epoch %d", lo, last.epoch))
+	printDivergence(last)
+	return nil
+}
+
+func printDivergence(p *bisectProbe) {
+	if bisectFlags.outdir == "" {
+		return
+	}
+	log.Println("vectors of diverging messages:")
+	for _, v := range p.vectors {
+		log.Println(v)
+	}
+}
+
+// probeEpoch extracts and executes the messages matching the filters in the
+// first tipset including any, starting at epoch from (inclusive) and until
+// epoch until (exclusive). It returns false if no such tipset was found.
+func probeEpoch(ctx context.Context, dir string, from, until abi.ChainEpoch) (*bisectProbe, bool, error) {
+	var p *bisectProbe
+	err := forEachInclusionTipset(ctx, from, until, func(incTs, _ *types.TipSet, msgs []api.Message) (bool, error) {
+		matching := filterBisectMessages(ctx, incTs, msgs)
+		if len(matching) == 0 {
+			return false, nil
+		}
+
+		p = &bisectProbe{epoch: incTs.Height()}
+		for _, m := range matching {
+			id := fmt.Sprintf("bisect-%d-%s", incTs.Height(), m.Cid)
+			file := filepath.Join(dir, id+".json")
+			err := doExtractMessage(extractOpts{
+				id:        id,
+				block:     incTs.Cids()[0].String(),
+				class:     "message",
+				cid:       m.Cid.String(),
+				file:      file,
+				retain:    "accessed-cids",
+				precursor: extractor.PrecursorSelectParticipants,
+				force:     true,
+			})
+			if err != nil {
+				log.Println(color.YellowString("failed to extract message %s; skipping: %s", m.Cid, err))
+				continue
+			}
+			tv, err := readVector(file)
+			if err != nil {
+				return true, err
+			}
+			if tv.Diagnostics != nil && tv.Diagnostics.Format == extractor.DiagnosticsReceiptMismatch {
+				p.diverged = true
+				p.vectors = append(p.vectors, file)
+			} else if err := os.Remove(file); err != nil {
+				return true, fmt.Errorf("failed to remove vector: %w", err)
+			}
+		}
+		log.Printf("probed %d messages at epoch %d; diverged: %t", len(matching), p.epoch, p.diverged)
+		return true, nil
+	})
+	return p, p != nil, err
+}
+
+// filterBisectMessages returns the messages matching the actor and method
+// filters, up to the configured maximum.
+func filterBisectMessages(ctx context.Context, incTs *types.TipSet, msgs []api.Message) []api.Message {
+	var ret []api.Message
+	for _, m := range msgs {
+		if len(ret) >= bisectFlags.maxMessages {
+			break
+		}
+		if bisectFlags.method >= 0 && m.Message.Method != abi.MethodNum(bisectFlags.method) {
+			continue
+		}
+		if bisectFlags.actor != "" {
+			act, err := FullAPI.StateGetActor(ctx, m.Message.To, incTs.Key())
+			if err != nil {
+				// the recipient may not exist yet.
+				continue
+			}
+			name := builtin.ActorNameByCode(act.Code)
+			if name != bisectFlags.actor && !strings.HasSuffix(name, "/"+bisectFlags.actor) {
+				continue
+			}
+		}
+		ret = append(ret, m)
+	}
+	return ret
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/cmd/tvx/builder"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+var buildFlags struct {
+	out  string
+	keys string
+	tags cli.StringSlice
+}
+
+var buildCmd = &cli.Command{
+	Name: "build",
+	Description: `build a synthetic message vector from a JSON template, without a chain.
+
+   The template declares the actors of the pre-state (accounts and multisigs,
+   with their balances) and the messages to apply, from and to actors named
+   in the template or addresses; the postconditions are computed by executing
+   the messages. For example:
+
+     {
+       "id": "transfer-to-new-account",
+       "network_version": 18,
+       "actors": [{"name": "alice", "balance": "100"}],
+       "messages": [{"from": "alice", "to": "f1...", "value": "1"}]
+     }
+
+   The genesis state the pre-state is built from is synthetic by default.
+   Set "genesis" to model that of a network, with the "mainnet" or
+   "calibnet" preset, or to start from a Lotus genesis template (as created
+   by lotus-seed genesis new), whose path is relative to the template file,
+   with a custom verified registry root key:
+
+     "genesis": {
+       "preset": "calibnet",
+       "template": "genesis.json",
+       "verifreg_root_key": {"name": "root", "type": "multisig",
+         "signers": ["alice"], "threshold": 1}
+     }
+
+   The keys of the accounts declared without an address are derived from
+   their names and the "seed" of the template (its id by default), recorded
+   in the vector metadata, so that builds are reproducible across runs and
+   machines; --keys exports them. With "sign": true, the messages are
+   embedded signed by those keys, and the vector requires the signatures to
+   be verified, as tvx exec --verify-signatures does for all vectors.
+
+   With "class": "message-validity", the messages are signed, and submitted
+   to the message pool instead of applied: the vector records whether each is
+   admitted, or the reason it's rejected, at the epoch and basefee of the
+   template.
+
+   Actors of type "puppet" install the puppet actor of the conformance
+   suite, which sends the messages it's asked to, at the ID "address" they
+   declare, with the hex-encoded CBOR "state" they declare, if any. Their
+   vectors carry the puppet_actor selector, so that runners lacking support
+   for it skip them, and execute on the legacy VM.
+
+   Tags declared in the "tags" of the template, and with --tag, are recorded
+   in the vector metadata.
+
+   With "gas_outcomes": true, the split of the gas fees of the messages (the
+   basefee and overestimation burns, the miner tip and penalty, and the
+   refund) is recorded in the expected_gas_outcomes selector, and asserted
+   on execution besides the receipts. "fee_scenarios" build a vector per
+   combination of basefee, fee cap, premium and gas limit, overriding those
+   of the template and all its messages, to exercise the fee market:
+
+     "fee_scenarios": [
+       {"name": "premium-capped", "basefee": "100", "gas_fee_cap": "150",
+        "gas_premium": "80"},
+       {"name": "below-basefee", "basefee": "200", "gas_fee_cap": "150"}
+     ]
+
+   The vectors are named after the template and the scenario, record their
+   gas outcomes, and are tagged fee-market; --out is then a directory to
+   write them to, and they're written to stdout a vector per line without.
+
+   Refer to the builder package for all the fields.`,
+	ArgsUsage: "<template file>",
+	Action:    runBuild,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "out",
+			Usage:       "file to write the vector to, or directory to write those of fee scenarios to; stdout if not supplied",
+			TakesFile:   true,
+			Destination: &buildFlags.out,
+		},
+		&cli.StringFlag{
+			Name: "keys",
+			Usage: "file to write the derived keys of the accounts to, as a JSON object of their addresses and keys by name, " +
+				"the keys being in the format of lotus wallet export",
+			TakesFile:   true,
+			Destination: &buildFlags.keys,
+		},
+		&cli.StringSliceFlag{
+			Name:        "tag",
+			Usage:       "tag to record in the vector metadata, besides those of the template; can be repeated. See tvx extract --tag",
+			Destination: &buildFlags.tags,
+		},
+	},
+}
+
+func runBuild(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected a single template file; got %d arguments", c.NArg())
+	}
+	data, err := os.ReadFile(c.Args().First())
+	if err != nil {
+		return fmt.Errorf("failed to read template: %w", err)
+	}
+	var tmpl builder.Template
+	if err := json.Unmarshal(data, &tmpl); err != nil {
+		return fmt.Errorf("failed to decode template: %w", err)
+	}
+	tags := buildFlags.tags.Value()
+	if err := conformance.ValidateTags(tags); err != nil {
+		return err
+	}
+	if g := tmpl.Genesis; g != nil && g.Template != "" && !filepath.IsAbs(g.Template) {
+		g.Template = filepath.Join(filepath.Dir(c.Args().First()), g.Template)
+	}
+
+	// the genesis state builder prints to stdout, which is reserved for the
+	// vector.
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	var vectors []*schema.TestVector
+	if len(tmpl.FeeScenarios) > 0 {
+		vectors, err = builder.BuildFeeScenarios(context.Background(), &tmpl)
+	} else {
+		var vector *schema.TestVector
+		vector, err = builder.Build(context.Background(), &tmpl)
+		vectors = append(vectors, vector)
+	}
+	os.Stdout = stdout
+	if err != nil {
+		return err
+	}
+	if buildFlags.keys != "" {
+		if err := writeBuildKeys(&tmpl, buildFlags.keys); err != nil {
+			return err
+		}
+	}
+	for _, vector := range vectors {
+		vector.Meta.Tags = conformance.MergeTags(vector.Meta.Tags, tags...)
+		log.Printf("built vector %s: pre-state %s, post-state %s", vector.Meta.ID,
+			vector.Pre.StateTree.RootCID, vector.Post.StateTree.RootCID)
+	}
+	if len(tmpl.FeeScenarios) == 0 {
+		return writeVector(vectors[0], buildFlags.out)
+	}
+	return writeVectors(buildFlags.out, vectors...)
+}
+
+// buildKey is a derived key, as exported by tvx build --keys.
+type buildKey struct {
+	Address string `json:"address"`
+	// Key is the hex-encoded JSON of the key info, as lotus wallet export
+	// prints it, and lotus wallet import reads it.
+	Key string `json:"key"`
+}
+
+func writeBuildKeys(tmpl *builder.Template, path string) error {
+	keys, err := tmpl.Keys()
+	if err != nil {
+		return err
+	}
+	out := make(map[string]buildKey, len(keys))
+	for name, k := range keys {
+		ki, err := json.Marshal(k.KeyInfo)
+		if err != nil {
+			return err
+		}
+		out[name] = buildKey{Address: k.Address.String(), Key: hex.EncodeToString(ki)}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the keys are for tests only, but keys nonetheless.
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write keys: %w", err)
+	}
+	return nil
+}
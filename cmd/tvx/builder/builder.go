@@ -0,0 +1,901 @@
+// Package builder builds synthetic test vectors from templates: the actors of
+// the pre-state are declared with their balances, the messages to apply are
+// enqueued, and the postconditions are computed by the conformance driver.
+// It powers tvx build, and lets tests author vectors without a chain to
+// extract them from.
+package builder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipld/go-car"
+	mh "github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	init_ "github.com/filecoin-project/lotus/chain/actors/builtin/init"
+	"github.com/filecoin-project/lotus/chain/gen/genesis"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet/key"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+	"github.com/filecoin-project/lotus/conformance/puppet"
+	gtypes "github.com/filecoin-project/lotus/genesis"
+	"github.com/filecoin-project/lotus/lib/sigs"
+)
+
+// Actor types of templates.
+const (
+	TypeAccount  = "account"
+	TypeMultisig = "multisig"
+	// TypePuppet is the puppet actor of the conformance puppet package, for
+	// vectors exercising send and call edge cases; its vectors carry
+	// conformance.SelectorPuppetActor.
+	TypePuppet = "puppet"
+)
+
+// DefaultGasLimit is the gas limit of the messages of templates that don't
+// set one.
+const DefaultGasLimit = 1_000_000_000
+
+// Template describes a synthetic message vector.
+type Template struct {
+	// ID is the identifier of the vector.
+	ID string `json:"id"`
+	// Description is the description of the vector.
+	Description string `json:"description,omitempty"`
+	// Tags are the tags of the vector; see conformance.ValidateTag.
+	Tags []string `json:"tags,omitempty"`
+	// Class is the class of the vector: message (the default), or
+	// message-validity, whose messages are signed and submitted to the
+	// message pool instead of applied, with their admission outcomes
+	// recorded; the epoch is that of the head they're validated at.
+	Class schema.Class `json:"class,omitempty"`
+	// NetworkVersion is the network version to build the pre-state and apply
+	// the messages with; it defaults to that of the genesis template.
+	NetworkVersion *uint `json:"network_version,omitempty"`
+	// Genesis configures the genesis state the pre-state is built from; a
+	// synthetic genesis if nil.
+	Genesis *Genesis `json:"genesis,omitempty"`
+	// Seed is the seed of the wallet deriving the keys of the accounts
+	// declared without an address; it defaults to the ID.
+	Seed string `json:"seed,omitempty"`
+	// Epoch is the epoch to apply the first message at.
+	Epoch int64 `json:"epoch,omitempty"`
+	// BaseFee and CircSupply, in attoFIL, default to those of the driver.
+	BaseFee    string `json:"basefee,omitempty"`
+	CircSupply string `json:"circ_supply,omitempty"`
+	// Selector is the selector of the vector.
+	Selector schema.Selector `json:"selector,omitempty"`
+	// Sign, if true, embeds the messages signed by the keys of their senders,
+	// which must be accounts declared without an address, and requires the
+	// signatures to be verified through the selector.
+	Sign bool `json:"sign,omitempty"`
+	// Actors are the actors to create in the pre-state, besides the singleton
+	// actors.
+	Actors []Actor `json:"actors"`
+	// Messages are the messages to apply, in order.
+	Messages []Message `json:"messages"`
+	// GasOutcomes, if true, records the split of the gas fees of the messages
+	// in the vector, which then asserts it besides their receipts; see
+	// conformance.SelectorExpectedGasOutcomes.
+	GasOutcomes bool `json:"gas_outcomes,omitempty"`
+	// FeeScenarios, if any, are the combinations of basefee and gas fields
+	// to build the vector with, a vector per scenario; see
+	// BuildFeeScenarios.
+	FeeScenarios []FeeScenario `json:"fee_scenarios,omitempty"`
+}
+
+// FeeScenario is a combination of basefee and gas fields of a fee market
+// scenario, overriding those of the template and its messages; empty fields
+// keep them. Amounts are in attoFIL.
+type FeeScenario struct {
+	// Name identifies the scenario; its vector is that of the template,
+	// suffixed with it.
+	Name       string `json:"name"`
+	BaseFee    string `json:"basefee,omitempty"`
+	GasFeeCap  string `json:"gas_fee_cap,omitempty"`
+	GasPremium string `json:"gas_premium,omitempty"`
+	GasLimit   int64  `json:"gas_limit,omitempty"`
+}
+
+// FeeMarketTag is the tag of the vectors of fee scenarios.
+const FeeMarketTag = "fee-market"
+
+// Actor is an actor of the pre-state, which messages refer to by name.
+type Actor struct {
+	Name string `json:"name"`
+	// Type is account (the default), multisig or puppet.
+	Type string `json:"type,omitempty"`
+	// Address is the key address of an account; if empty, the key of the
+	// account is derived from its name by the wallet of the template. For a
+	// puppet, it's required, and is the ID address to install it at.
+	Address string `json:"address,omitempty"`
+	// State is the hex-encoded head block of the state of a puppet; it
+	// defaults to the empty puppet state.
+	State string `json:"state,omitempty"`
+	// KeyType is the type of the derived key of an account: secp256k1 (the
+	// default) or bls.
+	KeyType string `json:"key_type,omitempty"`
+	// Balance is the balance of the actor, in FIL, or in attoFIL with the
+	// attofil suffix.
+	Balance string `json:"balance,omitempty"`
+	// Signers are the names of the accounts signing for a multisig, and
+	// Threshold the number of approvals it requires.
+	Signers   []string `json:"signers,omitempty"`
+	Threshold int      `json:"threshold,omitempty"`
+}
+
+// Message is a message to apply. The sender and receiver are the names of
+// actors of the template, or addresses.
+type Message struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Value is in FIL, or in attoFIL with the attofil suffix.
+	Value  string `json:"value,omitempty"`
+	Method uint64 `json:"method,omitempty"`
+	// Params are the hex-encoded params.
+	Params string `json:"params,omitempty"`
+	// Nonce defaults to the nonce following that of the previous message of
+	// the sender.
+	Nonce *uint64 `json:"nonce,omitempty"`
+	// GasLimit defaults to DefaultGasLimit, GasFeeCap to the basefee, and
+	// GasPremium to zero; the fee cap and premium are in attoFIL.
+	GasLimit   int64  `json:"gas_limit,omitempty"`
+	GasFeeCap  string `json:"gas_fee_cap,omitempty"`
+	GasPremium string `json:"gas_premium,omitempty"`
+	// EpochOffset is the number of epochs to advance by before applying the
+	// message.
+	EpochOffset int64 `json:"epoch_offset,omitempty"`
+}
+
+// Build builds the vector described by the template. The pre-state holds the
+// singleton actors of a genesis state at the network version of the template,
+// the actors of the genesis template if any, and its actors, accounts first:
+// accounts are assigned IDs from 100, in the order they're declared, followed
+// by multisigs. Builds are deterministic.
+func Build(ctx context.Context, tmpl *Template) (*schema.TestVector, error) {
+	if tmpl.ID == "" {
+		return nil, fmt.Errorf("template has no id")
+	}
+	if len(tmpl.FeeScenarios) > 0 {
+		return nil, fmt.Errorf("template has fee scenarios, which build several vectors")
+	}
+	class := tmpl.Class
+	switch class {
+	case "":
+		class = schema.ClassMessage
+	case schema.ClassMessage, conformance.ClassMessageValidity:
+	default:
+		return nil, fmt.Errorf("unsupported vector class %s", class)
+	}
+	if err := conformance.ValidateTags(tmpl.Tags); err != nil {
+		return nil, err
+	}
+	sign := tmpl.Sign || class == conformance.ClassMessageValidity
+	for i, m := range tmpl.Messages {
+		if class == conformance.ClassMessageValidity && m.EpochOffset != 0 {
+			return nil, fmt.Errorf("message %d: message-validity vectors don't support epoch offsets", i)
+		}
+	}
+
+	base, err := loadGenesis(tmpl.Genesis, tmpl.NetworkVersion)
+	if err != nil {
+		return nil, err
+	}
+	nv := base.template.NetworkVersion
+
+	basefee, err := attoFIL(tmpl.BaseFee, conformance.DefaultBaseFee)
+	if err != nil {
+		return nil, fmt.Errorf("invalid basefee: %w", err)
+	}
+	circSupply, err := attoFIL(tmpl.CircSupply, conformance.DefaultCirculatingSupply)
+	if err != nil {
+		return nil, fmt.Errorf("invalid circ_supply: %w", err)
+	}
+
+	var rootKey *Actor
+	if tmpl.Genesis != nil {
+		rootKey = tmpl.Genesis.VerifregRootKey
+	}
+	restoreBundle, err := useBundle(base.bundle)
+	if err != nil {
+		return nil, err
+	}
+	bs := blockstore.NewMemory()
+	preroot, addrs, err := buildPreState(ctx, bs, tmpl.wallet(), base.template, tmpl.Actors, rootKey)
+	restoreBundle()
+	if err != nil {
+		return nil, err
+	}
+	var signers map[address.Address]*key.Key
+	if sign {
+		keys, err := tmpl.Keys()
+		if err != nil {
+			return nil, err
+		}
+		signers = make(map[address.Address]*key.Key, len(keys))
+		for _, k := range keys {
+			signers[k.Address] = k
+		}
+	}
+	msgs, err := buildMessages(tmpl.Messages, addrs, basefee, signers)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := templateDigest(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	selector := schema.Selector{}
+	for k, v := range tmpl.Selector {
+		selector[k] = v
+	}
+	codename := extractor.GetProtocolCodename(abi.ChainEpoch(tmpl.Epoch))
+	if _, ok := selector[schema.SelectorMinProtocolVersion]; !ok {
+		selector[schema.SelectorMinProtocolVersion] = codename
+	}
+	if sign && class == schema.ClassMessage {
+		selector[conformance.SelectorVerifySignatures] = "true"
+	}
+	for _, a := range tmpl.Actors {
+		if a.Type == TypePuppet {
+			selector[conformance.SelectorPuppetActor] = "true"
+			break
+		}
+	}
+
+	gen := []schema.GenerationData{
+		{Source: "template:" + digest},
+		{Source: "wallet_seed:" + tmpl.seed()},
+	}
+	if tmpl.Genesis != nil && tmpl.Genesis.Preset != "" {
+		gen = append(gen, schema.GenerationData{Source: "genesis_preset:" + tmpl.Genesis.Preset})
+	}
+	if base.digest != "" {
+		gen = append(gen, schema.GenerationData{Source: "genesis_template:" + base.digest})
+	}
+	gen = append(gen, schema.GenerationData{Source: "github.com/filecoin-project/lotus", Version: build.UserVersion()})
+
+	vector := &schema.TestVector{
+		Class: class,
+		Meta: &schema.Metadata{
+			ID:   tmpl.ID,
+			Desc: tmpl.Description,
+			Gen:  gen,
+			Tags: tmpl.Tags,
+		},
+		Selector: selector,
+		Pre: &schema.Preconditions{
+			Variants: []schema.Variant{
+				{ID: codename, Epoch: tmpl.Epoch, NetworkVersion: uint(nv)},
+			},
+			CircSupply: circSupply.Int,
+			BaseFee:    basefee.Int,
+			StateTree:  &schema.StateTree{RootCID: preroot},
+		},
+		ApplyMessages: msgs,
+	}
+	if vector.CAR, err = encodeCAR(ctx, bs, preroot); err != nil {
+		return nil, err
+	}
+
+	if class == conformance.ClassMessageValidity {
+		outcomes, err := conformance.ComputeMessageValidityOutcomes(vector, &vector.Pre.Variants[0], conformance.ExecuteOpts{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to submit the messages: %w", err)
+		}
+		selector[conformance.SelectorExpectedAdmissions] = strings.Join(outcomes, ",")
+		vector.Post = &schema.Postconditions{StateTree: &schema.StateTree{RootCID: preroot}}
+		return vector, nil
+	}
+
+	post, outcomes, pbs, err := conformance.ComputeMessageVectorOutcomes(vector, &vector.Pre.Variants[0], conformance.ExecuteOpts{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply the messages: %w", err)
+	}
+	vector.Post = post
+	if tmpl.GasOutcomes {
+		data, err := json.Marshal(outcomes)
+		if err != nil {
+			return nil, err
+		}
+		selector[conformance.SelectorExpectedGasOutcomes] = string(data)
+	}
+	if vector.CAR, err = encodeCAR(ctx, pbs, preroot, post.StateTree.RootCID); err != nil {
+		return nil, err
+	}
+	return vector, nil
+}
+
+// BuildFeeScenarios builds a vector per fee scenario of the template, in
+// order: that of the template with the basefee and the gas fields of all its
+// messages overridden by those of the scenario, identified by the ID of the
+// template suffixed with the name of the scenario. The vectors record the
+// split of the gas fees of their messages, which they assert, and are tagged
+// FeeMarketTag. The keys of the accounts are those of the template in all of
+// them.
+func BuildFeeScenarios(ctx context.Context, tmpl *Template) ([]*schema.TestVector, error) {
+	if len(tmpl.FeeScenarios) == 0 {
+		return nil, fmt.Errorf("template has no fee scenarios")
+	}
+	seen := make(map[string]struct{}, len(tmpl.FeeScenarios))
+	vectors := make([]*schema.TestVector, 0, len(tmpl.FeeScenarios))
+	for i, sc := range tmpl.FeeScenarios {
+		if sc.Name == "" {
+			return nil, fmt.Errorf("fee scenario %d has no name", i)
+		}
+		if _, ok := seen[sc.Name]; ok {
+			return nil, fmt.Errorf("duplicate fee scenario %s", sc.Name)
+		}
+		seen[sc.Name] = struct{}{}
+
+		vector, err := Build(ctx, tmpl.withFeeScenario(sc))
+		if err != nil {
+			return nil, fmt.Errorf("fee scenario %s: %w", sc.Name, err)
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, nil
+}
+
+// withFeeScenario returns a copy of the template with the fee scenario
+// applied, keeping its seed.
+func (t *Template) withFeeScenario(sc FeeScenario) *Template {
+	ret := *t
+	ret.ID = t.ID + "-" + sc.Name
+	ret.Seed = t.seed()
+	ret.FeeScenarios = nil
+	ret.GasOutcomes = true
+	ret.Tags = conformance.MergeTags(append([]string(nil), t.Tags...), FeeMarketTag)
+	if sc.BaseFee != "" {
+		ret.BaseFee = sc.BaseFee
+	}
+	ret.Messages = make([]Message, len(t.Messages))
+	for i, m := range t.Messages {
+		if sc.GasFeeCap != "" {
+			m.GasFeeCap = sc.GasFeeCap
+		}
+		if sc.GasPremium != "" {
+			m.GasPremium = sc.GasPremium
+		}
+		if sc.GasLimit != 0 {
+			m.GasLimit = sc.GasLimit
+		}
+		ret.Messages[i] = m
+	}
+	return &ret
+}
+
+func (t *Template) seed() string {
+	if t.Seed != "" {
+		return t.Seed
+	}
+	return t.ID
+}
+
+func (t *Template) wallet() *Wallet { return NewWallet(t.seed()) }
+
+// Keys returns the keys of the accounts of the template declared without an
+// address, by name, derived by its wallet: those messages are sent from in
+// the vector, which can be signed with them.
+func (t *Template) Keys() (map[string]*key.Key, error) {
+	accounts := t.Actors
+	if t.Genesis != nil && t.Genesis.VerifregRootKey != nil {
+		accounts = append(append([]Actor(nil), accounts...), *t.Genesis.VerifregRootKey)
+	}
+	w := t.wallet()
+	keys := make(map[string]*key.Key)
+	for _, a := range accounts {
+		if (a.Type != "" && a.Type != TypeAccount) || a.Address != "" {
+			continue
+		}
+		k, err := accountKey(w, a)
+		if err != nil {
+			return nil, err
+		}
+		keys[a.Name] = k
+	}
+	return keys, nil
+}
+
+// buildPreState builds the pre-state with the actors, and the root key of the
+// verified registry if not nil, on top of the base genesis template in bs,
+// returning its root, and the addresses of the actors by name: the key
+// addresses of accounts, and the ID addresses of multisigs and puppets.
+func buildPreState(ctx context.Context, bs blockstore.Blockstore, w *Wallet, base gtypes.Template, actors []Actor, rootKey *Actor) (cid.Cid, map[string]address.Address, error) {
+	gtmpl, addrs, err := genesisTemplate(w, base, actors, rootKey)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	st, _, err := genesis.MakeInitialStateTree(ctx, bs, gtmpl)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("failed to build the pre-state: %w", err)
+	}
+
+	// genesis creates the first verifier with a random key; replace it with
+	// a fixed one for builds to be deterministic.
+	av, err := actorstypes.VersionForNetwork(base.NetworkVersion)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	verifier, err := address.NewBLSAddress(fixedKey("verifier", address.BlsPublicKeyBytes))
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	vact, err := genesis.MakeAccountActor(ctx, cbornode.NewCborStore(bs), av, verifier, big.Zero())
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("failed to create the first verifier: %w", err)
+	}
+	verifierID, err := address.NewIDAddress(81)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if err := st.SetActor(verifierID, vact); err != nil {
+		return cid.Undef, nil, fmt.Errorf("failed to set the first verifier: %w", err)
+	}
+
+	// multisigs have no key address; they're assigned the IDs following
+	// those of all accounts and signers, in order, those of the base first.
+	var msigIDs []address.Address
+	err = st.ForEach(func(addr address.Address, act *types.Actor) error {
+		if id, _ := address.IDFromAddress(addr); id >= genesis.AccountStart && builtin.IsMultisigActor(act.Code) {
+			msigIDs = append(msigIDs, addr)
+		}
+		return nil
+	})
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("failed to walk the pre-state: %w", err)
+	}
+	sort.Slice(msigIDs, func(i, j int) bool {
+		a, _ := address.IDFromAddress(msigIDs[i])
+		b, _ := address.IDFromAddress(msigIDs[j])
+		return a < b
+	})
+	for _, a := range base.Accounts {
+		if a.Type == gtypes.TMultisig && len(msigIDs) > 0 {
+			msigIDs = msigIDs[1:]
+		}
+	}
+	for _, a := range actors {
+		if a.Type == TypeMultisig {
+			if len(msigIDs) == 0 {
+				return cid.Undef, nil, fmt.Errorf("multisig %s not found in the pre-state", a.Name)
+			}
+			addrs[a.Name], msigIDs = msigIDs[0], msigIDs[1:]
+		}
+	}
+
+	for _, a := range actors {
+		if a.Type == TypePuppet {
+			if err := installPuppet(ctx, st, bs, a, addrs[a.Name]); err != nil {
+				return cid.Undef, nil, err
+			}
+		}
+	}
+
+	root, err := st.Flush(ctx)
+	if err != nil {
+		return cid.Undef, nil, fmt.Errorf("failed to flush the pre-state: %w", err)
+	}
+	return root, addrs, nil
+}
+
+// installPuppet installs the puppet actor at the ID address, which must not be
+// assigned, with its state and balance. The balance isn't debited from any
+// actor. If the ID follows those assigned, the next ID of the init actor is
+// moved past it, so that the actors created by messages don't collide with
+// the puppet.
+func installPuppet(ctx context.Context, st *state.StateTree, bs blockstore.Blockstore, a Actor, addr address.Address) error {
+	if _, err := st.GetActor(addr); err == nil {
+		return fmt.Errorf("puppet %s: address %s is already assigned", a.Name, addr)
+	}
+	balance := big.Zero()
+	if a.Balance != "" {
+		fil, err := types.ParseFIL(a.Balance)
+		if err != nil {
+			return fmt.Errorf("puppet %s: invalid balance: %w", a.Name, err)
+		}
+		balance = abi.TokenAmount(fil)
+	}
+
+	var head []byte
+	if a.State != "" {
+		var err error
+		if head, err = hex.DecodeString(a.State); err != nil {
+			return fmt.Errorf("puppet %s: invalid state: %w", a.Name, err)
+		}
+	} else {
+		var buf bytes.Buffer
+		if err := (&puppet.State{}).MarshalCBOR(&buf); err != nil {
+			return err
+		}
+		head = buf.Bytes()
+	}
+	blk, err := cbornode.Decode(head, mh.SHA2_256, -1)
+	if err != nil {
+		return fmt.Errorf("puppet %s: state is not CBOR: %w", a.Name, err)
+	}
+	if err := bs.Put(ctx, blk); err != nil {
+		return fmt.Errorf("puppet %s: failed to store state: %w", a.Name, err)
+	}
+
+	// the init actor assigns the IDs following the highest one assigned.
+	var last uint64
+	err = st.ForEach(func(addr address.Address, _ *types.Actor) error {
+		if id, err := address.IDFromAddress(addr); err == nil && id > last {
+			last = id
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk the pre-state: %w", err)
+	}
+	if id, _ := address.IDFromAddress(addr); id > last {
+		iact, err := st.GetActor(init_.Address)
+		if err != nil {
+			return fmt.Errorf("failed to load the init actor: %w", err)
+		}
+		store := adt.WrapStore(ctx, cbornode.NewCborStore(bs))
+		ist, err := init_.Load(store, iact)
+		if err != nil {
+			return fmt.Errorf("failed to load the init actor state: %w", err)
+		}
+		if err := ist.SetNextID(abi.ActorID(id + 1)); err != nil {
+			return err
+		}
+		if iact.Head, err = store.Put(ctx, ist); err != nil {
+			return fmt.Errorf("failed to store the init actor state: %w", err)
+		}
+		if err := st.SetActor(init_.Address, iact); err != nil {
+			return err
+		}
+	}
+
+	return st.SetActor(addr, &types.Actor{
+		Code:    puppet.PuppetActorCodeCID,
+		Head:    blk.Cid(),
+		Balance: balance,
+	})
+}
+
+// genesisTemplate returns the genesis template creating the actors, and the
+// root key of the verified registry if not nil, on top of those of the base
+// template, with the keys of accounts derived by the wallet, along with the addresses of the actors by name: the key
+// addresses of accounts, and the ID addresses of the root key and puppets.
+// Multisigs, which are assigned ID addresses by genesis, are mapped to
+// address.Undef. Puppets are left out of the template.
+func genesisTemplate(w *Wallet, base gtypes.Template, actors []Actor, rootKey *Actor) (gtypes.Template, map[string]address.Address, error) {
+	tmpl := base
+	tmpl.Accounts = append([]gtypes.Actor(nil), base.Accounts...)
+
+	// the key addresses of the base accounts and signers can't be declared
+	// again.
+	owners := make(map[address.Address]struct{})
+	for _, a := range base.Accounts {
+		var (
+			ameta gtypes.AccountMeta
+			mmeta gtypes.MultisigMeta
+		)
+		switch {
+		case a.Type == gtypes.TAccount && json.Unmarshal(a.Meta, &ameta) == nil:
+			owners[ameta.Owner] = struct{}{}
+		case a.Type == gtypes.TMultisig && json.Unmarshal(a.Meta, &mmeta) == nil:
+			for _, s := range mmeta.Signers {
+				owners[s] = struct{}{}
+			}
+		}
+	}
+
+	all := actors
+	if rootKey != nil {
+		all = append(append([]Actor(nil), actors...), *rootKey)
+	}
+	isRoot := func(i int) bool { return rootKey != nil && i == len(all)-1 }
+
+	addrs := make(map[string]address.Address, len(all))
+	gacts := make([]gtypes.Actor, len(all))
+	for i, a := range all {
+		if a.Name == "" {
+			return tmpl, nil, fmt.Errorf("actor with no name")
+		}
+		if _, ok := addrs[a.Name]; ok {
+			return tmpl, nil, fmt.Errorf("actor %s declared twice", a.Name)
+		}
+		balance := big.Zero()
+		if a.Balance != "" {
+			fil, err := types.ParseFIL(a.Balance)
+			if err != nil {
+				return tmpl, nil, fmt.Errorf("actor %s: invalid balance: %w", a.Name, err)
+			}
+			balance = abi.TokenAmount(fil)
+		}
+
+		switch a.Type {
+		case "", TypeAccount:
+			owner, err := accountAddress(w, a)
+			if err != nil {
+				return tmpl, nil, err
+			}
+			if _, ok := owners[owner]; ok {
+				return tmpl, nil, fmt.Errorf("actor %s: account %s is already declared", a.Name, owner)
+			}
+			owners[owner] = struct{}{}
+			addrs[a.Name] = owner
+			gacts[i] = accountActor(owner, balance)
+		case TypeMultisig:
+			if a.Address != "" {
+				return tmpl, nil, fmt.Errorf("actor %s: multisigs can't have an address", a.Name)
+			}
+			addrs[a.Name] = address.Undef
+			gacts[i] = gtypes.Actor{Type: gtypes.TMultisig, Balance: balance}
+		case TypePuppet:
+			// puppets aren't created by genesis, but installed into the
+			// pre-state at their ID address.
+			if isRoot(i) {
+				return tmpl, nil, fmt.Errorf("actor %s: the root key can't be a puppet", a.Name)
+			}
+			id, err := address.NewFromString(a.Address)
+			if err != nil || id.Protocol() != address.ID {
+				return tmpl, nil, fmt.Errorf("actor %s: puppets require an ID address, got %q", a.Name, a.Address)
+			}
+			addrs[a.Name] = id
+		default:
+			return tmpl, nil, fmt.Errorf("actor %s: unsupported type %q", a.Name, a.Type)
+		}
+	}
+
+	// the signers of multisigs are accounts declared anywhere.
+	for i, a := range all {
+		if a.Type != TypeMultisig {
+			continue
+		}
+		if len(a.Signers) == 0 || a.Threshold < 1 || a.Threshold > len(a.Signers) {
+			return tmpl, nil, fmt.Errorf("multisig %s: invalid threshold %d of %d signers", a.Name, a.Threshold, len(a.Signers))
+		}
+		meta := gtypes.MultisigMeta{Threshold: a.Threshold}
+		for _, s := range a.Signers {
+			addr, ok := addrs[s]
+			if !ok || (addr.Protocol() != address.SECP256K1 && addr.Protocol() != address.BLS) {
+				return tmpl, nil, fmt.Errorf("multisig %s: signer %s is not an account of the template", a.Name, s)
+			}
+			meta.Signers = append(meta.Signers, addr)
+		}
+		gacts[i].Meta = meta.ActorMeta()
+	}
+
+	// accounts come first, for the signers of multisigs to be assigned IDs
+	// once; genesis places the root key at its own ID, without registering
+	// its key address.
+	var msigs []gtypes.Actor
+	for i, a := range gacts {
+		switch {
+		case isRoot(i):
+			tmpl.VerifregRootKey = a
+			addrs[all[i].Name] = builtin.RootVerifierAddress
+		case all[i].Type == TypePuppet:
+		case a.Type == gtypes.TMultisig:
+			msigs = append(msigs, a)
+		default:
+			tmpl.Accounts = append(tmpl.Accounts, a)
+		}
+	}
+	tmpl.Accounts = append(tmpl.Accounts, msigs...)
+	return tmpl, addrs, nil
+}
+
+// accountAddress returns the key address of the account: its address if set,
+// or that of its key, derived by the wallet.
+func accountAddress(w *Wallet, a Actor) (address.Address, error) {
+	if a.Address == "" {
+		k, err := accountKey(w, a)
+		if err != nil {
+			return address.Undef, err
+		}
+		return k.Address, nil
+	}
+	owner, err := address.NewFromString(a.Address)
+	if err != nil {
+		return address.Undef, fmt.Errorf("actor %s: invalid address: %w", a.Name, err)
+	}
+	if p := owner.Protocol(); p != address.SECP256K1 && p != address.BLS {
+		return address.Undef, fmt.Errorf("actor %s: address %s is not a key address", a.Name, owner)
+	}
+	return owner, nil
+}
+
+// accountKey returns the key of the account, derived by the wallet.
+func accountKey(w *Wallet, a Actor) (*key.Key, error) {
+	typ := types.KTSecp256k1
+	if a.KeyType != "" {
+		typ = types.KeyType(a.KeyType)
+	}
+	k, err := w.Key(a.Name, typ)
+	if err != nil {
+		return nil, fmt.Errorf("actor %s: failed to derive key: %w", a.Name, err)
+	}
+	return k, nil
+}
+
+func accountActor(owner address.Address, balance abi.TokenAmount) gtypes.Actor {
+	return gtypes.Actor{
+		Type:    gtypes.TAccount,
+		Balance: balance,
+		Meta:    (&gtypes.AccountMeta{Owner: owner}).ActorMeta(),
+	}
+}
+
+// buildMessages returns the messages to apply, serialized; signed by the keys
+// of their senders if signers isn't nil.
+func buildMessages(tmsgs []Message, addrs map[string]address.Address, basefee abi.TokenAmount, signers map[address.Address]*key.Key) ([]schema.Message, error) {
+	resolve := func(s string) (address.Address, error) {
+		if addr, ok := addrs[s]; ok {
+			return addr, nil
+		}
+		addr, err := address.NewFromString(s)
+		if err != nil {
+			return address.Undef, fmt.Errorf("%s is neither an actor of the template nor an address", s)
+		}
+		return addr, nil
+	}
+
+	nonces := make(map[address.Address]uint64)
+	msgs := make([]schema.Message, 0, len(tmsgs))
+	for i, m := range tmsgs {
+		from, err := resolve(m.From)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: invalid sender: %w", i, err)
+		}
+		to, err := resolve(m.To)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: invalid receiver: %w", i, err)
+		}
+		msg := &types.Message{
+			Version:    0,
+			From:       from,
+			To:         to,
+			Nonce:      nonces[from],
+			Value:      big.Zero(),
+			Method:     abi.MethodNum(m.Method),
+			GasLimit:   m.GasLimit,
+			GasPremium: big.Zero(),
+		}
+		if m.Nonce != nil {
+			msg.Nonce = *m.Nonce
+		}
+		nonces[from] = msg.Nonce + 1
+		if msg.GasLimit == 0 {
+			msg.GasLimit = DefaultGasLimit
+		}
+		if m.Value != "" {
+			fil, err := types.ParseFIL(m.Value)
+			if err != nil {
+				return nil, fmt.Errorf("message %d: invalid value: %w", i, err)
+			}
+			msg.Value = abi.TokenAmount(fil)
+		}
+		if msg.Params, err = hex.DecodeString(m.Params); err != nil {
+			return nil, fmt.Errorf("message %d: invalid params: %w", i, err)
+		}
+		if msg.GasFeeCap, err = attoFIL(m.GasFeeCap, basefee); err != nil {
+			return nil, fmt.Errorf("message %d: invalid gas_fee_cap: %w", i, err)
+		}
+		if msg.GasPremium, err = attoFIL(m.GasPremium, big.Zero()); err != nil {
+			return nil, fmt.Errorf("message %d: invalid gas_premium: %w", i, err)
+		}
+
+		b, err := msg.Serialize()
+		if err != nil {
+			return nil, fmt.Errorf("message %d: failed to serialize: %w", i, err)
+		}
+		if signers != nil {
+			if b, err = signMessage(msg, signers[from]); err != nil {
+				return nil, fmt.Errorf("message %d: %w", i, err)
+			}
+		}
+		sm := schema.Message{Bytes: b}
+		if m.EpochOffset != 0 {
+			offset := m.EpochOffset
+			sm.EpochOffset = &offset
+		}
+		msgs = append(msgs, sm)
+	}
+	return msgs, nil
+}
+
+// signMessage returns the message signed by k, serialized.
+func signMessage(msg *types.Message, k *key.Key) ([]byte, error) {
+	if k == nil {
+		return nil, fmt.Errorf("can't sign: no key for sender %s, which must be an account declared without an address", msg.From)
+	}
+	sig, err := sigs.Sign(key.ActSigType(k.Type), k.PrivateKey, msg.Cid().Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	smsg := &types.SignedMessage{Message: *msg, Signature: *sig}
+	b, err := smsg.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize signed message: %w", err)
+	}
+	return b, nil
+}
+
+// attoFIL parses the attoFIL amount, or returns def if empty.
+func attoFIL(s string, def abi.TokenAmount) (abi.TokenAmount, error) {
+	if s == "" {
+		return def, nil
+	}
+	return big.FromString(s)
+}
+
+// fixedKey returns a public key of n bytes derived from the seed.
+func fixedKey(seed string, n int) []byte {
+	var key []byte
+	for i := 0; len(key) < n; i++ {
+		h := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", seed, i)))
+		key = append(key, h[:]...)
+	}
+	return key[:n]
+}
+
+// fixedAddress returns the secp256k1 address derived from the seed.
+func fixedAddress(seed string) address.Address {
+	addr, err := address.NewSecp256k1Address(fixedKey(seed, 65))
+	if err != nil {
+		panic(err) // keys of any length are hashed.
+	}
+	return addr
+}
+
+// templateDigest returns the hex-encoded SHA-256 digest of the template, to
+// record the provenance of the vector.
+func templateDigest(tmpl *Template) (string, error) {
+	b, err := json.Marshal(tmpl)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// encodeCAR returns the gzipped CAR of the state trees at the roots. The
+// code of the built-in actors isn't included, as drivers ship it.
+func encodeCAR(ctx context.Context, bs blockstore.Blockstore, roots ...cid.Cid) ([]byte, error) {
+	walk := func(nd format.Node) (out []*format.Link, err error) {
+		for _, link := range nd.Links() {
+			if link.Cid.Prefix().Codec == cid.Raw {
+				continue
+			}
+			out = append(out, link)
+		}
+		return out, nil
+	}
+	dserv := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	return extractor.EncodeCAR(func(w io.Writer) error {
+		return car.WriteCarWithWalker(ctx, dserv, roots, w, walk)
+	})
+}
@@ -0,0 +1,154 @@
+// stm: #unit
+package builder
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	gtypes "github.com/filecoin-project/lotus/genesis"
+)
+
+func TestGenesisTemplate(t *testing.T) {
+	actors := []Actor{
+		{Name: "msig", Type: TypeMultisig, Balance: "5", Signers: []string{"alice", "bob"}, Threshold: 2},
+		{Name: "alice", Balance: "100"},
+		{Name: "bob", Balance: "10 attofil"},
+		{Name: "puppet", Type: TypePuppet, Address: "f01000"},
+	}
+	tmpl, addrs, err := genesisTemplate(NewWallet("test"), gtypes.Template{NetworkVersion: 18}, actors, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmpl.Accounts) != 3 {
+		t.Fatalf("expected 3 genesis actors, got %d", len(tmpl.Accounts))
+	}
+	// accounts come first, in order, followed by multisigs.
+	for i, typ := range []gtypes.ActorType{gtypes.TAccount, gtypes.TAccount, gtypes.TMultisig} {
+		if tmpl.Accounts[i].Type != typ {
+			t.Errorf("genesis actor %d: expected type %s, got %s", i, typ, tmpl.Accounts[i].Type)
+		}
+	}
+	alice, err := NewWallet("test").Key("alice", types.KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addrs["alice"] != alice.Address || addrs["alice"] == addrs["bob"] {
+		t.Errorf("unexpected account addresses: %v", addrs)
+	}
+	// puppets are installed after genesis, at their address.
+	if addrs["puppet"].String() != "f01000" {
+		t.Errorf("unexpected puppet address: %s", addrs["puppet"])
+	}
+	if !tmpl.Accounts[1].Balance.Equals(abi.NewTokenAmount(10)) {
+		t.Errorf("unexpected balance of bob: %s", tmpl.Accounts[1].Balance)
+	}
+
+	var meta gtypes.MultisigMeta
+	if err := json.Unmarshal(tmpl.Accounts[2].Meta, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Threshold != 2 || len(meta.Signers) != 2 || meta.Signers[0] != addrs["alice"] || meta.Signers[1] != addrs["bob"] {
+		t.Errorf("unexpected multisig meta: %+v", meta)
+	}
+
+	for _, bad := range [][]Actor{
+		{{Name: "alice"}, {Name: "alice"}},
+		{{Name: "msig", Type: TypeMultisig, Signers: []string{"carol"}, Threshold: 1}},
+		{{Name: "msig", Type: TypeMultisig, Signers: []string{"alice"}, Threshold: 2}, {Name: "alice"}},
+		{{Name: "miner", Type: "miner"}},
+		{{Name: "puppet", Type: TypePuppet}},
+		{{Name: "puppet", Type: TypePuppet, Address: "f1abjxfbp274xpdqcpuaykwkfb43omjotacm2p3za"}},
+	} {
+		if _, _, err := genesisTemplate(NewWallet("test"), gtypes.Template{NetworkVersion: 18}, bad, nil); err == nil {
+			t.Errorf("expected an error for %+v", bad)
+		}
+	}
+}
+
+func TestBuildMessages(t *testing.T) {
+	alice := fixedAddress("alice")
+	to, err := address.NewIDAddress(1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := map[string]address.Address{"alice": alice}
+	five := uint64(5)
+	msgs, err := buildMessages([]Message{
+		{From: "alice", To: "f01234", Value: "1"},
+		{From: "alice", To: "f01234", Method: 2, Params: "8100", Nonce: &five},
+		{From: "alice", To: "f01234", EpochOffset: 3},
+	}, addrs, abi.NewTokenAmount(100), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []*types.Message
+	for _, m := range msgs {
+		msg, err := types.DecodeMessage(m.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded = append(decoded, msg)
+	}
+	if decoded[0].From != alice || decoded[0].To != to || !decoded[0].Value.Equals(big.NewInt(1e18)) {
+		t.Errorf("unexpected message 0: %+v", decoded[0])
+	}
+	if decoded[0].GasLimit != DefaultGasLimit || !decoded[0].GasFeeCap.Equals(abi.NewTokenAmount(100)) {
+		t.Errorf("unexpected gas of message 0: %+v", decoded[0])
+	}
+	// nonces follow those set explicitly.
+	for i, nonce := range []uint64{0, 5, 6} {
+		if decoded[i].Nonce != nonce {
+			t.Errorf("message %d: expected nonce %d, got %d", i, nonce, decoded[i].Nonce)
+		}
+	}
+	if len(decoded[1].Params) != 2 || decoded[1].Method != 2 {
+		t.Errorf("unexpected message 1: %+v", decoded[1])
+	}
+	if msgs[0].EpochOffset != nil || msgs[2].EpochOffset == nil || *msgs[2].EpochOffset != 3 {
+		t.Error("unexpected epoch offsets")
+	}
+
+	if _, err := buildMessages([]Message{{From: "bob", To: "alice"}}, addrs, abi.NewTokenAmount(100), nil); err == nil {
+		t.Error("expected an error for an unknown sender")
+	}
+}
+
+func TestWithFeeScenario(t *testing.T) {
+	tmpl := &Template{
+		ID:      "transfer",
+		Tags:    []string{"send"},
+		BaseFee: "100",
+		Messages: []Message{
+			{From: "alice", To: "bob", GasPremium: "1"},
+			{From: "alice", To: "bob", GasFeeCap: "200", GasLimit: 5000},
+		},
+		FeeScenarios: []FeeScenario{{Name: "capped", GasFeeCap: "150", GasLimit: 10000}},
+	}
+	sc := tmpl.withFeeScenario(tmpl.FeeScenarios[0])
+	if sc.ID != "transfer-capped" || sc.seed() != "transfer" {
+		t.Errorf("unexpected id %s or seed %s", sc.ID, sc.seed())
+	}
+	if len(sc.FeeScenarios) != 0 || !sc.GasOutcomes {
+		t.Errorf("expected a single vector recording gas outcomes")
+	}
+	if len(sc.Tags) != 2 || sc.Tags[1] != FeeMarketTag || len(tmpl.Tags) != 1 {
+		t.Errorf("unexpected tags %v of scenario, %v of template", sc.Tags, tmpl.Tags)
+	}
+	if sc.BaseFee != "100" {
+		t.Errorf("expected the basefee of the template, got %s", sc.BaseFee)
+	}
+	for i, m := range sc.Messages {
+		if m.GasFeeCap != "150" || m.GasLimit != 10000 {
+			t.Errorf("message %d: fee cap %s and gas limit %d not overridden", i, m.GasFeeCap, m.GasLimit)
+		}
+	}
+	if sc.Messages[0].GasPremium != "1" || tmpl.Messages[1].GasFeeCap != "200" {
+		t.Errorf("unexpected premium %s, or template modified", sc.Messages[0].GasPremium)
+	}
+}
@@ -0,0 +1,143 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/build"
+	gtypes "github.com/filecoin-project/lotus/genesis"
+)
+
+// Genesis presets.
+const (
+	PresetMainnet  = "mainnet"
+	PresetCalibnet = "calibnet"
+)
+
+// genesisPresets are the network names and built-in actors bundles of the
+// genesis presets.
+var genesisPresets = map[string]struct{ networkName, bundle string }{
+	PresetMainnet:  {"testnetnet", "mainnet"},
+	PresetCalibnet: {"calibrationnet", "calibrationnet"},
+}
+
+// Genesis configures the genesis state the pre-state of a template is built
+// from; later settings override earlier ones: the preset, then the genesis
+// template, then the explicit settings.
+type Genesis struct {
+	// Preset models the genesis of a network, mainnet or calibnet: its
+	// network name and built-in actors bundle.
+	Preset string `json:"preset,omitempty"`
+	// Template is the path of a Lotus genesis template, e.g. as created by
+	// lotus-seed genesis new, whose network version, accounts, multisigs,
+	// verified registry root key and remainder account are created before the
+	// actors of the template. Genesis miners aren't supported.
+	Template string `json:"template,omitempty"`
+	// NetworkName is the name of the network.
+	NetworkName string `json:"network_name,omitempty"`
+	// Bundle is the network of the built-in actors bundle to create the
+	// actors with, e.g. mainnet, calibrationnet or devnet.
+	Bundle string `json:"bundle,omitempty"`
+	// VerifregRootKey is the root key of the verified registry, at f080: an
+	// account or a multisig, which messages refer to by name as any actor of
+	// the template.
+	VerifregRootKey *Actor `json:"verifreg_root_key,omitempty"`
+}
+
+// genesisBase is the base genesis template the pre-state of a template is
+// built from, with the built-in actors bundle to use, if not the current one.
+type genesisBase struct {
+	template gtypes.Template
+	bundle   string
+	// digest is the hex-encoded SHA-256 digest of the genesis template file,
+	// if any.
+	digest string
+}
+
+// loadGenesis returns the base genesis template of the configuration, at the
+// network version of the template if set.
+func loadGenesis(g *Genesis, nv *uint) (*genesisBase, error) {
+	base := &genesisBase{
+		template: gtypes.Template{
+			NetworkName:      "synthetic",
+			VerifregRootKey:  accountActor(fixedAddress("verifreg-root"), big.Zero()),
+			RemainderAccount: accountActor(fixedAddress("remainder"), big.Zero()),
+		},
+	}
+	if g == nil {
+		g = new(Genesis)
+	}
+
+	if g.Preset != "" {
+		p, ok := genesisPresets[g.Preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown genesis preset %q; expected %s or %s", g.Preset, PresetMainnet, PresetCalibnet)
+		}
+		base.template.NetworkName, base.bundle = p.networkName, p.bundle
+	}
+
+	var hasNV bool
+	if g.Template != "" {
+		data, err := os.ReadFile(g.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read genesis template: %w", err)
+		}
+		var t gtypes.Template
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, fmt.Errorf("failed to decode genesis template %s: %w", g.Template, err)
+		}
+		if len(t.Miners) > 0 {
+			return nil, fmt.Errorf("genesis template %s has miners, which aren't supported", g.Template)
+		}
+		base.template.NetworkVersion, hasNV = t.NetworkVersion, true
+		base.template.Accounts = t.Accounts
+		if t.NetworkName != "" {
+			base.template.NetworkName = t.NetworkName
+		}
+		if t.VerifregRootKey.Type != "" {
+			base.template.VerifregRootKey = t.VerifregRootKey
+		}
+		if t.RemainderAccount.Type != "" {
+			base.template.RemainderAccount = t.RemainderAccount
+		}
+		h := sha256.Sum256(data)
+		base.digest = hex.EncodeToString(h[:])
+	}
+
+	if g.NetworkName != "" {
+		base.template.NetworkName = g.NetworkName
+	}
+	if g.Bundle != "" {
+		base.bundle = g.Bundle
+	}
+	if nv != nil {
+		base.template.NetworkVersion, hasNV = network.Version(*nv), true
+	}
+	if !hasNV {
+		return nil, fmt.Errorf("template has no network_version, nor a genesis template to take it from")
+	}
+	return base, nil
+}
+
+// useBundle switches to the built-in actors bundle of the network, if not
+// empty, returning the function restoring the current one.
+func useBundle(netw string) (restore func(), err error) {
+	restore = func() {}
+	if netw == "" || netw == build.NetworkBundle {
+		return restore, nil
+	}
+	prev := build.NetworkBundle
+	if err := build.UseNetworkBundle(netw); err != nil {
+		return restore, fmt.Errorf("failed to switch to the %s bundle: %w", netw, err)
+	}
+	return func() {
+		// the previous bundle was loaded successfully before.
+		_ = build.UseNetworkBundle(prev)
+	}, nil
+}
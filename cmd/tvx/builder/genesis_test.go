@@ -0,0 +1,86 @@
+// stm: #unit
+package builder
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	gtypes "github.com/filecoin-project/lotus/genesis"
+)
+
+func TestLoadGenesis(t *testing.T) {
+	nv := uint(17)
+	base, err := loadGenesis(&Genesis{Preset: PresetCalibnet}, &nv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.template.NetworkName != "calibrationnet" || base.bundle != "calibrationnet" || base.template.NetworkVersion != 17 {
+		t.Errorf("unexpected calibnet base: %+v", base)
+	}
+
+	if _, err := loadGenesis(nil, nil); err == nil {
+		t.Error("expected an error without a network version")
+	}
+	if _, err := loadGenesis(&Genesis{Preset: "devnet"}, &nv); err == nil {
+		t.Error("expected an error for an unknown preset")
+	}
+
+	// the genesis template supplies the network version and accounts, and
+	// explicit settings override it.
+	owner := fixedAddress("genesis-account")
+	gt := gtypes.Template{
+		NetworkVersion: 16,
+		NetworkName:    "custom",
+		Accounts:       []gtypes.Actor{accountActor(owner, abi.NewTokenAmount(1))},
+	}
+	data, err := json.Marshal(gt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	base, err = loadGenesis(&Genesis{Preset: PresetMainnet, Template: path, Bundle: "devnet"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.template.NetworkVersion != 16 || base.template.NetworkName != "custom" || base.bundle != "devnet" || base.digest == "" {
+		t.Errorf("unexpected base: %+v", base)
+	}
+
+	// the accounts of the genesis template can't be declared again.
+	if _, _, err := genesisTemplate(NewWallet("test"), base.template, []Actor{{Name: "dup", Address: owner.String()}}, nil); err == nil {
+		t.Error("expected an error for an account of the genesis template")
+	}
+}
+
+func TestVerifregRootKey(t *testing.T) {
+	actors := []Actor{{Name: "alice"}, {Name: "bob"}}
+	root := &Actor{Name: "root", Type: TypeMultisig, Signers: []string{"alice", "bob"}, Threshold: 1}
+	tmpl, addrs, err := genesisTemplate(NewWallet("test"), gtypes.Template{NetworkVersion: 18}, actors, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmpl.Accounts) != 2 || tmpl.VerifregRootKey.Type != gtypes.TMultisig {
+		t.Fatalf("unexpected genesis template: %+v", tmpl)
+	}
+	if addrs["root"] != builtin.RootVerifierAddress {
+		t.Errorf("expected the root key at %s, got %s", builtin.RootVerifierAddress, addrs["root"])
+	}
+
+	// the root key can't sign for multisigs.
+	actors = append(actors, Actor{Name: "msig", Type: TypeMultisig, Signers: []string{"root"}, Threshold: 1})
+	if _, _, err := genesisTemplate(NewWallet("test"), gtypes.Template{NetworkVersion: 18}, actors, &Actor{Name: "root"}); err == nil {
+		t.Error("expected an error for the root key signing for a multisig")
+	}
+	if _, ok := addrs["alice"]; !ok || addrs["alice"].Protocol() != address.SECP256K1 {
+		t.Errorf("unexpected address of alice: %s", addrs["alice"])
+	}
+}
@@ -0,0 +1,56 @@
+// stm: #unit
+package builder
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/lotus/chain/wallet/key"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/lib/sigs"
+)
+
+func TestSignMessages(t *testing.T) {
+	tmpl := &Template{ID: "signed", Actors: []Actor{{Name: "alice"}, {Name: "bob", Address: fixedAddress("bob").String()}}}
+	keys, err := tmpl.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := keys["alice"]
+	if alice == nil || len(keys) != 1 {
+		t.Fatalf("expected the key of alice only, got %v", keys)
+	}
+	addrs := map[string]address.Address{"alice": alice.Address, "bob": fixedAddress("bob")}
+	signers := map[address.Address]*key.Key{alice.Address: alice}
+
+	msgs, err := buildMessages([]Message{{From: "alice", To: "bob", Value: "1"}}, addrs, abi.NewTokenAmount(100), signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, sig, err := conformance.DecodeVectorMessage(msgs[0].Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig == nil || sig.Type != crypto.SigTypeSecp256k1 {
+		t.Fatalf("expected a secp256k1 signature, got %v", sig)
+	}
+	if err := sigs.Verify(sig, alice.Address, msg.Cid().Bytes()); err != nil {
+		t.Errorf("invalid signature: %s", err)
+	}
+
+	// unsigned messages decode without a signature.
+	if msgs, err = buildMessages([]Message{{From: "alice", To: "bob"}}, addrs, abi.NewTokenAmount(100), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, sig, err := conformance.DecodeVectorMessage(msgs[0].Bytes); err != nil || sig != nil {
+		t.Errorf("expected an unsigned message, got %v, %v", sig, err)
+	}
+
+	// bob has no key to sign with.
+	if _, err := buildMessages([]Message{{From: "bob", To: "alice"}}, addrs, abi.NewTokenAmount(100), signers); err == nil {
+		t.Error("expected an error for a sender without a key")
+	}
+}
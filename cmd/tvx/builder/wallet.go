@@ -0,0 +1,67 @@
+package builder
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/wallet/key"
+	_ "github.com/filecoin-project/lotus/lib/sigs/bls"  // enable bls signatures
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp" // enable secp signatures
+)
+
+// Orders of the groups of secp256k1 and BLS12-381 private keys.
+var (
+	secp256k1Order, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	bls12381Order, _  = new(big.Int).SetString("73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001", 16)
+)
+
+// Wallet derives the keys of the accounts of synthetic vectors from a seed,
+// for them to be reproducible across runs and machines: the private key of
+// an account is derived from the seed, the name of the account and its key
+// type with HMAC-SHA256. The keys are for tests only, as anyone knowing the
+// seed can derive them.
+type Wallet struct {
+	seed []byte
+}
+
+// NewWallet returns the wallet deriving keys from the seed.
+func NewWallet(seed string) *Wallet {
+	return &Wallet{seed: []byte(seed)}
+}
+
+// Key returns the key of the account of the supplied name and key type,
+// secp256k1 or bls.
+func (w *Wallet) Key(name string, typ types.KeyType) (*key.Key, error) {
+	var order *big.Int
+	switch typ {
+	case types.KTSecp256k1:
+		order = secp256k1Order
+	case types.KTBLS:
+		order = bls12381Order
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", typ)
+	}
+
+	// draw until the scalar is a valid private key, which is all but certain
+	// on the first draw.
+	var k *big.Int
+	for i := 0; k == nil; i++ {
+		mac := hmac.New(sha256.New, w.seed)
+		_, _ = fmt.Fprintf(mac, "%s/%s/%d", typ, name, i)
+		if c := new(big.Int).SetBytes(mac.Sum(nil)); c.Sign() > 0 && c.Cmp(order) < 0 {
+			k = c
+		}
+	}
+
+	priv := k.FillBytes(make([]byte, 32))
+	if typ == types.KTBLS {
+		// BLS private keys are serialized little-endian.
+		for i, j := 0, len(priv)-1; i < j; i, j = i+1, j-1 {
+			priv[i], priv[j] = priv[j], priv[i]
+		}
+	}
+	return key.NewKey(types.KeyInfo{Type: typ, PrivateKey: priv})
+}
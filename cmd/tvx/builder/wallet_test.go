@@ -0,0 +1,47 @@
+// stm: #unit
+package builder
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestWalletKeys(t *testing.T) {
+	for _, typ := range []types.KeyType{types.KTSecp256k1, types.KTBLS} {
+		k1, err := NewWallet("seed").Key("alice", typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k2, err := NewWallet("seed").Key("alice", typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k1.Address != k2.Address || !bytes.Equal(k1.PrivateKey, k2.PrivateKey) {
+			t.Errorf("%s: keys derived from the same seed differ", typ)
+		}
+		for _, other := range []struct{ seed, name string }{{"seed", "bob"}, {"other", "alice"}} {
+			k, err := NewWallet(other.seed).Key(other.name, typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.Address == k1.Address {
+				t.Errorf("%s: key of %s with seed %s collides", typ, other.name, other.seed)
+			}
+		}
+	}
+
+	k, err := NewWallet("seed").Key("alice", types.KTBLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Address.Protocol() != address.BLS {
+		t.Errorf("expected a BLS address, got %s", k.Address)
+	}
+	if _, err := NewWallet("seed").Key("alice", types.KeyType("ed25519")); err == nil {
+		t.Error("expected an error for an unsupported key type")
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	levelds "github.com/ipfs/go-ds-leveldb"
+	"github.com/mitchellh/go-homedir"
+	ldbopts "github.com/syndtr/goleveldb/leveldb/opt"
+
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors"
+)
+
+// DefaultResultCachePath is the default location of the execution result
+// cache. It is expanded with mitchellh/go-homedir.
+const DefaultResultCachePath = "~/.tvx/cache"
+
+// cachedResult is the outcome of a vector execution, as recorded in the
+// result cache.
+type cachedResult struct {
+	Passed     bool      `json:"passed"`
+	Diffs      []string  `json:"diffs,omitempty"`
+	ExecutedAt time.Time `json:"executed_at"`
+}
+
+// resultCache caches vector execution results in a local LevelDB database,
+// keyed by the vector content hash, the actors versions the vector runs
+// against, the driver (Lotus) version, or the remote executor, and the
+// settings of the run affecting the results, so that incremental corpus runs
+// only re-execute vectors that changed.
+type resultCache struct {
+	ds ds.Batching
+	// refresh, when true, skips cache lookups, but still records results.
+	refresh bool
+}
+
+func openResultCache(path string, refresh bool) (*resultCache, error) {
+	dstore, err := openLevelDatastore(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open result cache: %w", err)
+	}
+	return &resultCache{ds: dstore, refresh: refresh}, nil
+}
+
+// openLevelDatastore opens the LevelDB datastore at the supplied path, which
+// is expanded with mitchellh/go-homedir, creating it if it doesn't exist.
+func openLevelDatastore(path string) (ds.Batching, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand path: %w", err)
+	}
+	if err := ensureDir(path); err != nil {
+		return nil, err
+	}
+	dstore, err := levelds.NewDatastore(path, &levelds.Options{
+		Compression: ldbopts.NoCompression,
+		Strict:      ldbopts.StrictAll,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open datastore at %s: %w", path, err)
+	}
+	return dstore, nil
+}
+
+func (c *resultCache) Close() error {
+	return c.ds.Close()
+}
+
+// key returns the cache key of the vector with the supplied content, labeled
+// as tvx exec does.
+func (c *resultCache) key(label string, tv *schema.TestVector, content []byte) ds.Key {
+	h := sha256.New()
+	_, _ = h.Write(content)
+	if execExecutor != nil {
+		_, _ = fmt.Fprintf(h, "\x00executor:%s@%s", execExecutor.url, execExecutor.info)
+	} else {
+		_, _ = fmt.Fprintf(h, "\x00driver:%s", build.UserVersion())
+	}
+	for _, v := range tv.Pre.Variants {
+		_, _ = fmt.Fprintf(h, "\x00actors:%s", actorsVersionFor(network.Version(v.NetworkVersion)))
+	}
+	for _, s := range execResultSettings(label, tv) {
+		_, _ = fmt.Fprintf(h, "\x00%s", s)
+	}
+	return ds.NewKey(hex.EncodeToString(h.Sum(nil)))
+}
+
+// execResultSettings returns the settings of tvx exec the result of the
+// vector depends on, in name:value form: the execution options, the
+// assertion options, and the gas baseline the vector is checked against.
+// Settings that only affect how vectors are executed, e.g. --spill, aren't
+// included.
+func execResultSettings(label string, tv *schema.TestVector) []string {
+	opts := execVectorOpts
+	settings := []string{
+		fmt.Sprintf("verify-signatures:%t", opts.VerifySignatures),
+		fmt.Sprintf("network:%s", opts.Network),
+		fmt.Sprintf("lenient-requirements:%t", opts.LenientRequirements),
+		fmt.Sprintf("fetch-proof-params:%t", opts.FetchProofParams),
+		fmt.Sprintf("diff-on-fail:%t", opts.SemanticStateDiffs),
+		fmt.Sprintf("chaos-actor:%t", execFlags.chaosActor),
+		fmt.Sprintf("fallback-blockstore:%t", execFlags.fallbackBlockstore),
+		fmt.Sprintf("runs-per-vector:%d", execFlags.runsPerVector),
+		fmt.Sprintf("assert:exit-code-only=%t,ignore-gas=%t,gas-tolerance=%g,ignore-return=%t,ignore-traces=%t",
+			opts.Assert.ExitCodeOnly, opts.Assert.IgnoreGas, opts.Assert.GasTolerance, opts.Assert.IgnoreReturn, opts.Assert.IgnoreTraces),
+	}
+	if l := opts.Limits; l != nil {
+		settings = append(settings, fmt.Sprintf("limits:max-gas=%d,max-steps=%d", l.MaxGasLimit, l.MaxSteps))
+	}
+	if o := opts.MessageOverrides; !o.IsZero() {
+		settings = append(settings, fmt.Sprintf("message-overrides:%s", o))
+	}
+	if b := execGasBaseline; b != nil {
+		id := label
+		if tv.Meta != nil && tv.Meta.ID != "" {
+			id = tv.Meta.ID
+		}
+		b.lk.Lock()
+		gas, ok := b.baseline[id]
+		b.lk.Unlock()
+		settings = append(settings, fmt.Sprintf("gas-baseline:budget=%g,warn=%t,gas=%d,found=%t", b.budget, b.warn, gas, ok))
+	}
+	return settings
+}
+
+// actorsVersionFor identifies the actors the supplied network version runs,
+// including the manifest of the actors bundle, if one is loaded.
+func actorsVersionFor(nv network.Version) string {
+	av, err := actorstypes.VersionForNetwork(nv)
+	if err != nil {
+		return "unknown"
+	}
+	if mf, ok := actors.GetManifest(av); ok {
+		return fmt.Sprintf("v%d@%s", av, mf)
+	}
+	return fmt.Sprintf("v%d", av)
+}
+
+// get returns the cached result for the key, if any.
+func (c *resultCache) get(ctx context.Context, key ds.Key) (*cachedResult, bool, error) {
+	if c.refresh {
+		return nil, false, nil
+	}
+	b, err := c.ds.Get(ctx, key)
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to read result cache: %w", err)
+	}
+	var res cachedResult
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, false, fmt.Errorf("failed to decode cached result: %w", err)
+	}
+	return &res, true, nil
+}
+
+// put records the result for the key.
+func (c *resultCache) put(ctx context.Context, key ds.Key, res *cachedResult) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	if err := c.ds.Put(ctx, key, b); err != nil {
+		return fmt.Errorf("failed to write result cache: %w", err)
+	}
+	return nil
+}
+
+// execCache is the result cache used by tvx exec, if enabled.
+var execCache *resultCache
+
+// lookupCachedResult looks up the result of the vector, labeled as tvx exec
+// does, in the exec result cache, returning the key to record the result of
+// a new execution under. It returns a nil key if the cache is disabled.
+func lookupCachedResult(label string, tv *schema.TestVector, content []byte) (key *ds.Key, res *cachedResult, err error) {
+	if execCache == nil {
+		return nil, nil, nil
+	}
+	k := execCache.key(label, tv, content)
+	res, ok, err := execCache.get(context.TODO(), k)
+	if err != nil || !ok {
+		return &k, nil, err
+	}
+	return &k, res, nil
+}
+
+// recordResult records the result of an execution in the exec result cache,
+// if enabled.
+func recordResult(key *ds.Key, passed bool, diffs []string) error {
+	if execCache == nil || key == nil {
+		return nil
+	}
+	return execCache.put(context.TODO(), *key, &cachedResult{
+		Passed:     passed,
+		Diffs:      diffs,
+		ExecutedAt: time.Now(),
+	})
+}
+
+func (r *cachedResult) String() string {
+	status := "passed"
+	if !r.Passed {
+		status = "failed"
+		if len(r.Diffs) > 0 {
+			status += "; diffs:\n" + strings.Join(r.Diffs, "\n")
+		}
+	}
+	return fmt.Sprintf("%s (executed at %s)", status, r.ExecutedAt.Format(time.RFC3339))
+}
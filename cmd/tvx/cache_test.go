@@ -0,0 +1,56 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestResultCacheKeySettings(t *testing.T) {
+	defer func() {
+		execVectorOpts, execFlags.chaosActor, execFlags.fallbackBlockstore, execGasBaseline = conformance.ExecuteOpts{}, false, false, nil
+	}()
+
+	c, err := openResultCache(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close() //nolint:errcheck
+
+	tv := &schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta:  &schema.Metadata{ID: "msg-1"},
+		Pre:   &schema.Preconditions{Variants: []schema.Variant{{ID: "nv16", NetworkVersion: 16}}},
+	}
+	content := []byte(`{"class":"message"}`)
+	base := c.key("msg-1.json", tv, content)
+	if c.key("msg-1.json", tv, content) != base {
+		t.Fatal("expected the key to be stable")
+	}
+
+	for _, s := range []struct {
+		name  string
+		apply func()
+	}{
+		{"verify-signatures", func() { execVectorOpts.VerifySignatures = true }},
+		{"network", func() { execVectorOpts.Network = "calibrationnet" }},
+		{"lenient-requirements", func() { execVectorOpts.LenientRequirements = true }},
+		{"limits", func() { execVectorOpts.Limits = &conformance.ExecutionLimits{MaxSteps: 10} }},
+		{"assert", func() { execVectorOpts.Assert.ExitCodeOnly = true }},
+		{"diff-on-fail", func() { execVectorOpts.SemanticStateDiffs = true }},
+		{"chaos-actor", func() { execFlags.chaosActor = true }},
+		{"fallback-blockstore", func() { execFlags.fallbackBlockstore = true }},
+		{"gas-baseline", func() {
+			execGasBaseline = &gasBaseline{budget: 1, baseline: map[string]int64{"msg-1": 100}}
+		}},
+	} {
+		execVectorOpts, execFlags.chaosActor, execFlags.fallbackBlockstore, execGasBaseline = conformance.ExecuteOpts{}, false, false, nil
+		s.apply()
+		if c.key("msg-1.json", tv, content) == base {
+			t.Errorf("expected the key to change with %s", s.name)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multicodec"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+var carFlags struct {
+	out string
+	car string
+}
+
+var carOutFlag = cli.StringFlag{
+	Name:        "out",
+	Usage:       "file to write the CAR to; stdout if not supplied",
+	TakesFile:   true,
+	Destination: &carFlags.out,
+}
+
+var carCmd = &cli.Command{
+	Name: "car",
+	Description: `manipulate the CARs embedded in test vectors, which hold their state.
+
+   The CARs are read from vector files, or from CAR files, optionally
+   gzip-compressed, where a CAR is expected.
+
+   The vectors of a corpus extracted at the same epochs duplicate the same
+   state blocks, e.g. HAMT nodes, across their CARs. car share moves the
+   blocks of vectors into a block store shared by the corpus, deduplicated,
+   leaving the vectors with CARs carrying their roots only, and the CID of
+   the index of their blocks in the store; car materialize makes them
+   self-contained again. tvx exec executes shared vectors with the store
+   supplied with --shared-blocks. Signatures verify against materialized
+   vectors.
+
+   car compact re-encodes the pre-state tree of hand-maintained vectors with
+   the smaller HAMT layout of a more recent state tree version, validating
+   that their execution results are unchanged.`,
+	Subcommands: []*cli.Command{
+		{
+			Name:        "dump",
+			Description: "write the CAR embedded in a vector to a file, uncompressed",
+			ArgsUsage:   "<vector file>",
+			Action:      runCarDump,
+			Flags:       []cli.Flag{&carOutFlag},
+		},
+		{
+			Name:        "ls",
+			Description: "list the roots of a CAR, and its blocks with their codecs and sizes",
+			ArgsUsage:   "<vector or CAR file>",
+			Action:      runCarLs,
+		},
+		{
+			Name: "merge",
+			Description: "merge the CARs of several vectors or CAR files into a single CAR, with the roots of all, " +
+				"in the order supplied, and their blocks deduplicated",
+			ArgsUsage: "<vector or CAR file>...",
+			Action:    runCarMerge,
+			Flags:     []cli.Flag{&carOutFlag},
+		},
+		{
+			Name: "embed",
+			Description: "replace the CAR embedded in a vector, e.g. with a modified dump, warning about the state roots " +
+				"of the vector missing from it. The signature of the vector, if any, is dropped, as the vector changes",
+			ArgsUsage: "<vector file>",
+			Action:    runCarEmbed,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "car",
+					Usage:       "CAR file to embed, optionally gzip-compressed",
+					Required:    true,
+					TakesFile:   true,
+					Destination: &carFlags.car,
+				},
+				&cli.StringFlag{
+					Name:        "out",
+					Usage:       "file to write the vector to; the vector is rewritten in place if not supplied",
+					TakesFile:   true,
+					Destination: &carFlags.out,
+				},
+			},
+		},
+		{
+			Name: "share",
+			Description: "move the blocks of the CARs of vectors into a block store shared by their corpus, deduplicated, " +
+				"rewriting the vectors in place with CARs carrying their roots only. Vectors already shared are left as they are",
+			ArgsUsage: "<vector file or dir>...",
+			Action:    runCarShare,
+			Flags:     []cli.Flag{&sharedBlocksStoreFlag},
+		},
+		{
+			Name:        "materialize",
+			Description: "rewrite shared vectors in place with self-contained CARs, holding their blocks from the shared block store",
+			ArgsUsage:   "<vector file or dir>...",
+			Action:      runCarMaterialize,
+			Flags:       []cli.Flag{&sharedBlocksStoreFlag},
+		},
+		carCompactCmd,
+	},
+}
+
+// readCAR returns the uncompressed CAR embedded in the vector file, or held
+// in the CAR file, at path.
+func readCAR(path string) ([]byte, error) {
+	var data []byte
+	if isVectorFile(path) {
+		tv, err := readVector(path)
+		if err != nil {
+			return nil, err
+		}
+		data = tv.CAR
+	} else {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read CAR: %w", err)
+		}
+	}
+	return gunzipCAR(data)
+}
+
+// gunzipCAR returns the CAR, decompressed if gzip-compressed, as embedded in
+// vectors.
+func gunzipCAR(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate gzipped CAR: %w", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate gzipped CAR: %w", err)
+	}
+	return out, nil
+}
+
+// carOutput returns the writer of --out, or stdout.
+func carOutput() (io.WriteCloser, error) {
+	if carFlags.out == "" || carFlags.out == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(carFlags.out)
+}
+
+func runCarDump(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected a single vector file; got %d arguments", c.NArg())
+	}
+	if !isVectorFile(c.Args().First()) {
+		return fmt.Errorf("%s is not a vector file", c.Args().First())
+	}
+	data, err := readCAR(c.Args().First())
+	if err != nil {
+		return err
+	}
+	w, err := carOutput()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// carBlockStat is a block of a CAR, as listed by car ls.
+type carBlockStat struct {
+	cid   cid.Cid
+	codec string
+	size  int
+}
+
+// listCAR returns the roots and blocks of the CAR, in order.
+func listCAR(data []byte) ([]cid.Cid, []carBlockStat, error) {
+	cr, err := car.NewCarReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CAR: %w", err)
+	}
+	var stats []carBlockStat
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			return cr.Header.Roots, stats, nil
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to read CAR: %w", err)
+		}
+		stats = append(stats, carBlockStat{
+			cid:   blk.Cid(),
+			codec: multicodec.Code(blk.Cid().Prefix().Codec).String(),
+			size:  len(blk.RawData()),
+		})
+	}
+}
+
+func runCarLs(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected a single vector or CAR file; got %d arguments", c.NArg())
+	}
+	data, err := readCAR(c.Args().First())
+	if err != nil {
+		return err
+	}
+	roots, stats, err := listCAR(data)
+	if err != nil {
+		return err
+	}
+	return printCARListing(os.Stdout, roots, stats)
+}
+
+func printCARListing(w io.Writer, roots []cid.Cid, stats []carBlockStat) error {
+	tw := tabwriter.NewWriter(w, 4, 2, 2, ' ', 0)
+	for _, r := range roots {
+		_, _ = fmt.Fprintf(tw, "root\t%s\t\n", r)
+	}
+	var (
+		total    int
+		byCodec  = make(map[string]int)
+		perCodec = make(map[string]int)
+	)
+	for _, s := range stats {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t\n", s.cid, s.codec, s.size)
+		total += s.size
+		byCodec[s.codec] += s.size
+		perCodec[s.codec]++
+	}
+	codecs := make([]string, 0, len(byCodec))
+	for codec := range byCodec {
+		codecs = append(codecs, codec)
+	}
+	sort.Strings(codecs)
+	for _, codec := range codecs {
+		_, _ = fmt.Fprintf(tw, "total %s\t%d blocks\t%d\t\n", codec, perCodec[codec], byCodec[codec])
+	}
+	_, _ = fmt.Fprintf(tw, "total\t%d blocks\t%d\t\n", len(stats), total)
+	return tw.Flush()
+}
+
+// mergeCARs writes a CAR with the roots of all the CARs, deduplicated and in
+// order, and their blocks, deduplicated.
+func mergeCARs(w io.Writer, cars ...[]byte) error {
+	var (
+		roots []cid.Cid
+		seen  = cid.NewSet()
+		blks  = make(map[cid.Cid][]byte)
+		order []cid.Cid
+	)
+	for _, data := range cars {
+		cr, err := car.NewCarReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to read CAR: %w", err)
+		}
+		for _, r := range cr.Header.Roots {
+			if seen.Visit(r) {
+				roots = append(roots, r)
+			}
+		}
+		for {
+			blk, err := cr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("failed to read CAR: %w", err)
+			}
+			if _, ok := blks[blk.Cid()]; !ok {
+				blks[blk.Cid()] = blk.RawData()
+				order = append(order, blk.Cid())
+			}
+		}
+	}
+
+	if err := car.WriteHeader(&car.CarHeader{Roots: roots, Version: 1}, w); err != nil {
+		return fmt.Errorf("failed to write CAR header: %w", err)
+	}
+	for _, c := range order {
+		if err := util.LdWrite(w, c.Bytes(), blks[c]); err != nil {
+			return fmt.Errorf("failed to write CAR block: %w", err)
+		}
+	}
+	return nil
+}
+
+func runCarMerge(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no vector or CAR files supplied")
+	}
+	cars := make([][]byte, 0, c.NArg())
+	for _, path := range c.Args().Slice() {
+		data, err := readCAR(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		cars = append(cars, data)
+	}
+	w, err := carOutput()
+	if err != nil {
+		return err
+	}
+	if err := mergeCARs(w, cars...); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// embedCAR replaces the CAR of the vector, returning the state roots of the
+// vector missing from the new CAR. The signature of the vector is dropped.
+func embedCAR(tv *schema.TestVector, data []byte) ([]cid.Cid, error) {
+	_, stats, err := listCAR(data)
+	if err != nil {
+		return nil, err
+	}
+	if tv.CAR, err = extractor.EncodeCAR(func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	has := cid.NewSet()
+	for _, s := range stats {
+		has.Add(s.cid)
+	}
+	var roots []cid.Cid
+	if tv.Pre != nil && tv.Pre.StateTree != nil {
+		roots = append(roots, tv.Pre.StateTree.RootCID)
+	}
+	if tv.Post != nil && tv.Post.StateTree != nil {
+		roots = append(roots, tv.Post.StateTree.RootCID)
+	}
+	var missing []cid.Cid
+	for _, root := range roots {
+		if root.Defined() && !has.Has(root) {
+			missing = append(missing, root)
+		}
+	}
+
+	if tv.Meta != nil {
+		gen := tv.Meta.Gen[:0]
+		for _, g := range tv.Meta.Gen {
+			if g.Source != GenSigner && g.Source != GenSignature {
+				gen = append(gen, g)
+			}
+		}
+		tv.Meta.Gen = gen
+	}
+	return missing, nil
+}
+
+func runCarEmbed(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected a single vector file; got %d arguments", c.NArg())
+	}
+	path := c.Args().First()
+	tv, err := readVector(path)
+	if err != nil {
+		return err
+	}
+	data, err := readCAR(carFlags.car)
+	if err != nil {
+		return err
+	}
+	missing, err := embedCAR(tv, data)
+	if err != nil {
+		return err
+	}
+	for _, root := range missing {
+		log.Printf("warning: state root %s of the vector is missing from the CAR", root)
+	}
+
+	out := carFlags.out
+	if out == "" {
+		out = path
+	}
+	return writeVector(tv, out)
+}
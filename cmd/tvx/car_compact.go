@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+	"github.com/urfave/cli/v2"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// maxCompactStateTreeVersion is the most recent state tree version vectors
+// are compacted to. Version 5 changes the encoding of actors, and not only
+// the layout of the tree.
+const maxCompactStateTreeVersion = types.StateTreeVersion4
+
+var carCompactFlags struct {
+	version uint64
+}
+
+var carCompactCmd = &cli.Command{
+	Name: "compact",
+	Description: `re-encode the pre-state tree of vectors with the layout of a more recent state tree version, rewriting them in place.
+
+   The actors of a state tree are held in a HAMT whose parameters depend on
+   the version of the tree: version 0 trees, those of vectors extracted before
+   actors v2, use a bitwidth of 8, and later ones a bitwidth of 5 and, from
+   version 2, a more compact node format. Hand-maintained vectors of older
+   trees thus carry larger HAMT nodes than they need to. The HAMTs and AMTs of
+   the states of actors are parameterized by the actors code, and are left as
+   they are.
+
+   Only vectors embedding their whole pre-state tree can be compacted, as
+   those built or maintained by hand usually do; the sparse state of
+   extracted vectors can't be re-encoded. The vectors are re-executed, and are
+   only rewritten if the receipts of all of their variants are unchanged,
+   their post-state roots being updated. Vectors of trees already at the
+   version are left as they are, as are shared vectors, which are to be
+   materialized first. The signature of the vectors rewritten, if any, is
+   dropped.`,
+	ArgsUsage: "<vector file or dir>...",
+	Action:    runCarCompact,
+	Flags: []cli.Flag{
+		&cli.Uint64Flag{
+			Name:        "state-tree-version",
+			Usage:       fmt.Sprintf("state tree version to re-encode to, between 1 and %d", maxCompactStateTreeVersion),
+			Value:       uint64(maxCompactStateTreeVersion),
+			Destination: &carCompactFlags.version,
+		},
+	},
+}
+
+func runCarCompact(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no vector files or directories supplied")
+	}
+	target := types.StateTreeVersion(carCompactFlags.version)
+	if target < types.StateTreeVersion1 || target > maxCompactStateTreeVersion {
+		return fmt.Errorf("--state-tree-version must be between 1 and %d", maxCompactStateTreeVersion)
+	}
+
+	var before, after int
+	compacted, err := rewriteVectorFiles(c.Args().Slice(), func(path string, tv *schema.TestVector) (bool, error) {
+		size := len(tv.CAR)
+		ok, err := compactVector(c.Context, tv, target)
+		if ok {
+			before, after = before+size, after+len(tv.CAR)
+		}
+		return ok, err
+	})
+	log.Printf("compacted %d vectors to state tree version %d: CARs shrunk from %d to %d bytes", compacted, target, before, after)
+	return err
+}
+
+// compactVector re-encodes the pre-state tree of the vector to the state tree
+// version, replacing its blocks in the CAR, and updates the post-state root
+// once the variants are re-executed with unchanged receipts. It returns
+// whether the vector was changed.
+func compactVector(ctx context.Context, tv *schema.TestVector, target types.StateTreeVersion) (bool, error) {
+	if _, ok := tv.Selector[conformance.SelectorSharedBlocks]; ok {
+		return false, fmt.Errorf("the blocks of the vector are shared; materialize it first")
+	}
+	switch tv.Class {
+	case schema.ClassMessage, schema.ClassTipset:
+	default:
+		return false, fmt.Errorf("%w: %s", conformance.ErrUnsupportedClass, tv.Class)
+	}
+	if tv.Pre == nil || tv.Pre.StateTree == nil || len(tv.Pre.Variants) == 0 {
+		return false, fmt.Errorf("vector has no pre-state or variants")
+	}
+	if tv.Post == nil {
+		return false, fmt.Errorf("vector has no postconditions")
+	}
+
+	data, err := gunzipCAR(tv.CAR)
+	if err != nil {
+		return false, err
+	}
+	bs, err := conformance.LoadBlockstore(tv.CAR)
+	if err != nil {
+		return false, err
+	}
+	preroot := tv.Pre.StateTree.RootCID
+	compacted, err := compactStateTree(ctx, bs, preroot, target)
+	if err != nil {
+		return false, err
+	}
+	if compacted == nil {
+		return false, nil
+	}
+
+	out := *tv
+	if out.CAR, err = extractor.EncodeCAR(func(w io.Writer) error {
+		return rewriteCAR(w, data, bs, compacted)
+	}); err != nil {
+		return false, err
+	}
+	pre := *tv.Pre
+	pre.StateTree = &schema.StateTree{RootCID: compacted.root}
+	out.Pre = &pre
+
+	// the receipts of all variants must be unchanged; their post-state root
+	// is that of the re-encoded tree.
+	var post *schema.Postconditions
+	for i := range out.Pre.Variants {
+		v := &out.Pre.Variants[i]
+		var p *schema.Postconditions
+		switch out.Class {
+		case schema.ClassMessage:
+			p, _, err = conformance.ComputeMessageVectorPostconditions(&out, v, conformance.ExecuteOpts{})
+		case schema.ClassTipset:
+			p, err = conformance.ComputeTipsetVectorPostconditions(&out, v, conformance.ExecuteOpts{})
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to execute variant %s: %w", v.ID, err)
+		}
+		expected := *tv.Post
+		expected.StateTree = p.StateTree
+		if !samePostconditions(&expected, p) {
+			return false, fmt.Errorf("re-encoding the state tree changes the receipts of variant %s", v.ID)
+		}
+		if post != nil && !samePostconditions(post, p) {
+			return false, fmt.Errorf("variants %s and %s result in different post-state roots", out.Pre.Variants[0].ID, v.ID)
+		}
+		post = p
+	}
+	newPost := *tv.Post
+	newPost.StateTree = post.StateTree
+	out.Post = &newPost
+
+	for i := range out.Pre.Variants {
+		v := &out.Pre.Variants[i]
+		r := new(reportingReporter)
+		var diffs []string
+		ferr := recoverFatal(func() { diffs, err = conformance.ExecuteVariant(ctx, r, &out, v, conformance.ExecuteOpts{}) })
+		switch {
+		case ferr != nil:
+			return false, fmt.Errorf("compacted variant %s failed: %w", v.ID, ferr)
+		case err != nil:
+			return false, fmt.Errorf("compacted variant %s failed: %w", v.ID, err)
+		case r.Failed():
+			return false, fmt.Errorf("compacted variant %s failed: %v %v", v.ID, r.failures, diffs)
+		}
+	}
+
+	if out.Meta == nil {
+		out.Meta = new(schema.Metadata)
+	}
+	meta := *out.Meta
+	meta.Gen = nil
+	for _, g := range out.Meta.Gen {
+		if g.Source != GenSigner && g.Source != GenSignature {
+			meta.Gen = append(meta.Gen, g)
+		}
+	}
+	meta.Gen = append(meta.Gen, schema.GenerationData{
+		Source:  fmt.Sprintf("compacted:statetree-v%d", target),
+		Version: build.UserVersion(),
+	})
+	out.Meta = &meta
+
+	log.Printf("re-encoded state tree %s (version %d) as %s (version %d): %d blocks replaced by %d",
+		preroot, compacted.from, compacted.root, target, compacted.removed.Len(), len(compacted.added))
+	*tv = out
+	return true, nil
+}
+
+// compactedStateTree is a state tree re-encoded to another version.
+type compactedStateTree struct {
+	from types.StateTreeVersion
+	// old and root are the roots of the tree, before and after.
+	old, root cid.Cid
+	// removed are the blocks of the old tree no longer referenced, and added
+	// those of the new tree, in the order they are reached.
+	removed *cid.Set
+	added   []cid.Cid
+}
+
+// compactStateTree re-encodes the state tree at root, in the blockstore, to
+// the state tree version, writing the new tree to the blockstore. It returns
+// nil if the tree is already at the version, or a more recent one. The whole
+// tree must be in the blockstore.
+func compactStateTree(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, target types.StateTreeVersion) (*compactedStateTree, error) {
+	cst := cbor.NewCborStore(bs)
+	old, err := state.LoadStateTree(cst, root)
+	if err != nil {
+		return nil, err
+	}
+	if old.Version() >= target {
+		return nil, nil
+	}
+
+	tree, err := state.NewStateTree(cst, target)
+	if err != nil {
+		return nil, err
+	}
+	// the heads and code of actors aren't nodes of the tree.
+	leaves := cid.NewSet()
+	err = old.ForEach(func(addr address.Address, act *types.Actor) error {
+		leaves.Add(act.Head)
+		leaves.Add(act.Code)
+		return tree.SetActor(addr, act)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy the actors of state tree %s; only vectors embedding their whole state tree can be compacted: %w", root, err)
+	}
+	newRoot, err := tree.Flush(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to flush state tree: %w", err)
+	}
+
+	oldNodes, err := stateTreeNodes(ctx, bs, root, leaves)
+	if err != nil {
+		return nil, err
+	}
+	newNodes, err := stateTreeNodes(ctx, bs, newRoot, leaves)
+	if err != nil {
+		return nil, err
+	}
+	compacted := &compactedStateTree{from: old.Version(), old: root, root: newRoot, removed: cid.NewSet()}
+	for _, c := range oldNodes {
+		compacted.removed.Add(c)
+	}
+	for _, c := range newNodes {
+		if compacted.removed.Has(c) {
+			// shared by both trees, e.g. the info of the tree.
+			compacted.removed.Remove(c)
+			continue
+		}
+		compacted.added = append(compacted.added, c)
+	}
+	return compacted, nil
+}
+
+// stateTreeNodes returns the blocks of the state tree at root, i.e. its root
+// object, info and the nodes of its actors HAMT, excluding the leaves.
+func stateTreeNodes(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, leaves *cid.Set) ([]cid.Cid, error) {
+	var (
+		nodes []cid.Cid
+		seen  = cid.NewSet()
+		queue = []cid.Cid{root}
+	)
+	seen.Add(root)
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get state tree node %s: %w", c, err)
+		}
+		nodes = append(nodes, c)
+		err = cbg.ScanForLinks(bytes.NewReader(blk.RawData()), func(link cid.Cid) {
+			if leaves.Has(link) || link.Prefix().MhType == multihash.IDENTITY || !seen.Visit(link) {
+				return
+			}
+			queue = append(queue, link)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan state tree node %s: %w", c, err)
+		}
+	}
+	return nodes, nil
+}
+
+// rewriteCAR writes the CAR with the blocks of the compacted state tree
+// replacing those of the old one, and its root replacing the old root among
+// the roots of the CAR.
+func rewriteCAR(w io.Writer, data []byte, bs blockstore.Blockstore, compacted *compactedStateTree) error {
+	cr, err := car.NewCarReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to read CAR: %w", err)
+	}
+	roots := make([]cid.Cid, len(cr.Header.Roots))
+	for i, r := range cr.Header.Roots {
+		if r.Equals(compacted.old) {
+			r = compacted.root
+		}
+		roots[i] = r
+	}
+	if err := car.WriteHeader(&car.CarHeader{Roots: roots, Version: 1}, w); err != nil {
+		return err
+	}
+
+	written := cid.NewSet()
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read CAR: %w", err)
+		}
+		if compacted.removed.Has(blk.Cid()) || !written.Visit(blk.Cid()) {
+			continue
+		}
+		if err := util.LdWrite(w, blk.Cid().Bytes(), blk.RawData()); err != nil {
+			return err
+		}
+	}
+	for _, c := range compacted.added {
+		if !written.Visit(c) {
+			continue
+		}
+		blk, err := bs.Get(context.TODO(), c)
+		if err != nil {
+			return fmt.Errorf("failed to get state tree node %s: %w", c, err)
+		}
+		if err := util.LdWrite(w, c.Bytes(), blk.RawData()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// mkCAR returns a CAR of the objects, rooted at the first one.
+func mkCAR(t *testing.T, objs ...interface{}) ([]byte, []cid.Cid) {
+	var (
+		buf  bytes.Buffer
+		cids []cid.Cid
+		nds  []*cbornode.Node
+	)
+	for _, o := range objs {
+		nd, err := cbornode.WrapObject(o, multihash.BLAKE2B_MIN+31, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nds = append(nds, nd)
+		cids = append(cids, nd.Cid())
+	}
+	if err := car.WriteHeader(&car.CarHeader{Roots: cids[:1], Version: 1}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range nds {
+		if err := util.LdWrite(&buf, nd.Cid().Bytes(), nd.RawData()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes(), cids
+}
+
+func TestMergeCARs(t *testing.T) {
+	a, ac := mkCAR(t, "a", "shared")
+	b, bc := mkCAR(t, "b", "shared")
+
+	var merged bytes.Buffer
+	if err := mergeCARs(&merged, a, b, a); err != nil {
+		t.Fatal(err)
+	}
+	roots, stats, err := listCAR(merged.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || roots[0] != ac[0] || roots[1] != bc[0] {
+		t.Errorf("unexpected roots: %v", roots)
+	}
+	if len(stats) != 3 {
+		t.Errorf("expected 3 deduplicated blocks; got %d", len(stats))
+	}
+	for _, s := range stats {
+		if s.codec != "dag-cbor" || s.size == 0 {
+			t.Errorf("unexpected block listing: %+v", s)
+		}
+	}
+
+	var out strings.Builder
+	if err := printCARListing(&out, roots, stats); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`total dag-cbor\s+3 blocks`).MatchString(out.String()) {
+		t.Errorf("unexpected listing:\n%s", out.String())
+	}
+}
+
+func TestEmbedCAR(t *testing.T) {
+	data, cids := mkCAR(t, "state")
+	_, other := mkCAR(t, "other")
+
+	tv := &schema.TestVector{
+		Pre:  &schema.Preconditions{StateTree: &schema.StateTree{RootCID: cids[0]}},
+		Post: &schema.Postconditions{StateTree: &schema.StateTree{RootCID: other[0]}},
+		Meta: &schema.Metadata{Gen: []schema.GenerationData{{Source: "tvx"}, {Source: GenSigner}, {Source: GenSignature}}},
+	}
+	missing, err := embedCAR(tv, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0] != other[0] {
+		t.Errorf("unexpected missing roots: %v", missing)
+	}
+	if len(tv.Meta.Gen) != 1 {
+		t.Errorf("signature not dropped: %v", tv.Meta.Gen)
+	}
+
+	embedded, err := gunzipCAR(tv.CAR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, stats, err := listCAR(embedded); err != nil || len(stats) != 1 || stats[0].cid != cids[0] {
+		t.Errorf("unexpected embedded CAR: %v, %v", stats, err)
+	}
+}
+
+func TestSavePostStateCAR(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	var roots []cid.Cid
+	for _, o := range []interface{}{"actual", "expected"} {
+		nd, err := cbornode.WrapObject(o, multihash.BLAKE2B_MIN+31, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.Put(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, nd.Cid())
+	}
+
+	dir := t.TempDir()
+	tv := &schema.TestVector{
+		Meta: &schema.Metadata{ID: "msg/1"},
+		Post: &schema.Postconditions{StateTree: &schema.StateTree{RootCID: roots[1]}},
+	}
+	if err := savePostStateCAR(dir, tv, &schema.Variant{ID: "nv16"}, bs, roots[0]); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "msg_1.nv16.post.car"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, stats, err := listCAR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != roots[0] || got[1] != roots[1] || len(stats) != 2 {
+		t.Errorf("unexpected post-state CAR: roots %v, %d blocks", got, len(stats))
+	}
+}
+
+func TestCompactStateTree(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	cst := cbornode.NewCborStore(bs)
+
+	code, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.IDENTITY}.Sum([]byte("fil/1/account"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := state.NewStateTree(cst, types.StateTreeVersion0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actors := make(map[address.Address]types.Actor)
+	for i := uint64(0); i < 100; i++ {
+		head, err := cst.Put(ctx, []uint64{i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, _ := address.NewIDAddress(100 + i)
+		act := types.Actor{Code: code, Head: head, Nonce: i, Balance: types.NewInt(i)}
+		if err := old.SetActor(addr, &act); err != nil {
+			t.Fatal(err)
+		}
+		actors[addr] = act
+	}
+	root, err := old.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a CAR of the whole tree, i.e. its nodes and the heads of its actors.
+	var buf bytes.Buffer
+	if err := writeSparseCAR(ctx, &buf, bs, root); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	compacted, err := compactStateTree(ctx, bs, root, types.StateTreeVersion4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted == nil || compacted.root == root || compacted.from != types.StateTreeVersion0 {
+		t.Fatalf("expected the tree to be re-encoded; got %+v", compacted)
+	}
+	for _, act := range actors {
+		if compacted.removed.Has(act.Head) {
+			t.Fatalf("head %s of an actor removed from the state", act.Head)
+		}
+	}
+	if again, err := compactStateTree(ctx, bs, compacted.root, types.StateTreeVersion4); err != nil || again != nil {
+		t.Fatalf("expected trees at the version to be left as they are; got %+v, %v", again, err)
+	}
+
+	var out bytes.Buffer
+	if err := rewriteCAR(&out, data, bs, compacted); err != nil {
+		t.Fatal(err)
+	}
+	roots, stats, err := listCAR(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0] != compacted.root {
+		t.Fatalf("expected the CAR to be rooted at the compacted tree; got %v", roots)
+	}
+	if len(out.Bytes()) >= len(data) {
+		t.Errorf("expected the CAR to shrink from %d bytes; got %d", len(data), len(out.Bytes()))
+	}
+
+	// the rewritten CAR holds the whole tree, and the same actors.
+	rewritten := blockstore.NewMemory()
+	for _, s := range stats {
+		blk, err := bs.Get(ctx, s.cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rewritten.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := state.LoadStateTree(cbornode.NewCborStore(rewritten), compacted.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Version() != types.StateTreeVersion4 {
+		t.Fatalf("expected state tree version 4; got %d", tree.Version())
+	}
+	var n int
+	err = tree.ForEach(func(addr address.Address, act *types.Actor) error {
+		n++
+		if expected := actors[addr]; !act.Head.Equals(expected.Head) || act.Nonce != expected.Nonce {
+			t.Errorf("actor %s differs after compaction", addr)
+		}
+		_, err := rewritten.Get(ctx, act.Head)
+		return err
+	})
+	if err != nil || n != len(actors) {
+		t.Fatalf("expected %d actors; got %d, %v", len(actors), n, err)
+	}
+}
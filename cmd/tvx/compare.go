@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+var compareFlags struct {
+	file            string
+	actorsA         cli.StringSlice
+	actorsB         cli.StringSlice
+	configs         cli.StringSlice
+	executorTimeout time.Duration
+	matrixJSON      string
+	matrixHTML      string
+}
+
+var compareCmd = &cli.Command{
+	Name: "compare",
+	Description: "execute one or many test vectors under two configurations of built-in actors bundles, " +
+		"reporting the receipts and state roots that diverge. Each configuration is a list of <actors version>=<bundle path> " +
+		"overrides (e.g. v10=./builtin-actors.car); an empty configuration runs the embedded bundles. " +
+		"Bundles only affect FVM executions (nv16 onwards); to compare two driver builds, run 'tvx exec' with each and diff the reports. " +
+		"With --config, vectors are executed under any number of named configurations, bundle overrides or the execution endpoints of other implementations, " +
+		"and compared with the first; --matrix-json and --matrix-html write the vector by configuration matrix of the outcomes (status, gas used and divergences)",
+	Action: runCompare,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "file",
+			Usage:       "input vector file, or directory or archive of vector files",
+			TakesFile:   true,
+			Required:    true,
+			Destination: &compareFlags.file,
+		},
+		&cli.StringSliceFlag{
+			Name:        "actors-a",
+			Usage:       "actors bundle override of configuration A, in <actors version>=<bundle path> form; can be repeated",
+			Destination: &compareFlags.actorsA,
+		},
+		&cli.StringSliceFlag{
+			Name:        "actors-b",
+			Usage:       "actors bundle override of configuration B, in <actors version>=<bundle path> form; can be repeated",
+			Destination: &compareFlags.actorsB,
+		},
+		&cli.StringSliceFlag{
+			Name: "config",
+			Usage: "named configuration to execute vectors under, in <name>=<spec> form, where spec is the base URL of an execution endpoint (see tvx exec --executor), " +
+				"a comma-separated list of <actors version>=<bundle path> overrides, or empty for the embedded bundles; can be repeated, " +
+				"and the first is the baseline the others are compared with. Incompatible with --actors-a and --actors-b",
+			Destination: &compareFlags.configs,
+		},
+		&cli.DurationFlag{
+			Name:        "executor-timeout",
+			Usage:       "timeout of the requests to the execution endpoints of the configurations",
+			Value:       5 * time.Minute,
+			Destination: &compareFlags.executorTimeout,
+		},
+		&cli.StringFlag{
+			Name:        "matrix-json",
+			Usage:       "write the compatibility matrix of the run, the outcome of every vector variant under every configuration, to the specified file as JSON",
+			Destination: &compareFlags.matrixJSON,
+		},
+		&cli.StringFlag{
+			Name:        "matrix-html",
+			Usage:       "write the compatibility matrix of the run to the specified file as HTML; see --matrix-json",
+			Destination: &compareFlags.matrixHTML,
+		},
+	},
+}
+
+// bundleConfig maps actors versions to the bundles overriding them.
+type bundleConfig map[int]string
+
+func parseBundleConfig(overrides []string) (bundleConfig, error) {
+	cfg := make(bundleConfig, len(overrides))
+	for _, o := range overrides {
+		v, path, ok := strings.Cut(o, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("invalid actors bundle override %q; expected <actors version>=<bundle path>", o)
+		}
+		av, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid actors version in override %q: %w", o, err)
+		}
+		cfg[av] = path
+	}
+	return cfg, nil
+}
+
+// apply points the debug bundles of the FVM at the bundles, for vectors
+// executed with conformance.DriverOpts.DebugBundles to run them.
+func (c bundleConfig) apply() {
+	for _, av := range actors.Versions {
+		_ = os.Unsetenv(fmt.Sprintf("LOTUS_FVM_DEBUG_BUNDLE_V%d", av))
+	}
+	for av, path := range c {
+		_ = os.Setenv(fmt.Sprintf("LOTUS_FVM_DEBUG_BUNDLE_V%d", av), path)
+	}
+}
+
+// variantOutcome is the outcome of the execution of a vector variant.
+// Failed is whether it disagreed with the postconditions of the vector, and
+// unsupported whether the executor doesn't support the vector.
+type variantOutcome struct {
+	receipts    []types.MessageReceipt
+	root        cid.Cid
+	err         error
+	failed      bool
+	unsupported bool
+}
+
+// gasUsed returns the gas used by the messages of the variant.
+func (o *variantOutcome) gasUsed() int64 {
+	var gas int64
+	for _, r := range o.receipts {
+		gas += r.GasUsed
+	}
+	return gas
+}
+
+// compareConfigs returns the configurations to compare: those of --config,
+// or A and B, of --actors-a and --actors-b.
+func compareConfigs() ([]*compareConfig, error) {
+	if specs := compareFlags.configs.Value(); len(specs) > 0 {
+		if len(compareFlags.actorsA.Value()) > 0 || len(compareFlags.actorsB.Value()) > 0 {
+			return nil, fmt.Errorf("--config is incompatible with --actors-a and --actors-b")
+		}
+		if len(specs) < 2 {
+			return nil, fmt.Errorf("at least two configurations are required to compare")
+		}
+		var configs []*compareConfig
+		names := make(map[string]bool, len(specs))
+		for _, s := range specs {
+			cfg, err := parseCompareConfig(s, compareFlags.executorTimeout)
+			if err != nil {
+				return nil, err
+			}
+			if names[cfg.name] {
+				return nil, fmt.Errorf("duplicate configuration %s", cfg.name)
+			}
+			names[cfg.name] = true
+			configs = append(configs, cfg)
+		}
+		return configs, nil
+	}
+
+	cfgA, err := parseBundleConfig(compareFlags.actorsA.Value())
+	if err != nil {
+		return nil, err
+	}
+	cfgB, err := parseBundleConfig(compareFlags.actorsB.Value())
+	if err != nil {
+		return nil, err
+	}
+	return []*compareConfig{{name: "A", bundles: cfgA}, {name: "B", bundles: cfgB}}, nil
+}
+
+func runCompare(_ *cli.Context) error {
+	configs, err := compareConfigs()
+	if err != nil {
+		return err
+	}
+	for _, cfg := range configs {
+		log.Printf("configuration %s: %s", cfg.name, cfg.describe())
+	}
+
+	defer (&compareConfig{}).apply()
+
+	matrix := newCompareMatrix(configs)
+	var compared, diverged int
+	err = walkVectorFiles(compareFlags.file, func(path string, content []byte) error {
+		var tv schema.TestVector
+		if err := json.Unmarshal(content, &tv); err != nil {
+			return fmt.Errorf("failed to decode test vector %s: %w", path, err)
+		}
+		id := ""
+		if tv.Meta != nil {
+			id = tv.Meta.ID
+		}
+
+		for _, v := range tv.Pre.Variants {
+			v := v
+			outcomes := make([]*variantOutcome, 0, len(configs))
+			for _, cfg := range configs {
+				cfg.apply()
+				outcomes = append(outcomes, executeForComparison(&tv, &v, cfg.executeOpts()))
+			}
+
+			compared++
+			row := matrix.add(path, id, v.ID, outcomes)
+			if !row.Diverges() {
+				log.Println(color.GreenString("✅ %s (variant %s) matches", path, v.ID))
+				continue
+			}
+			diverged++
+			log.Println(color.HiRedString("❌ %s (variant %s) diverges:", path, v.ID))
+			for _, cell := range row.Cells {
+				for _, d := range cell.Divergence {
+					log.Printf("\t%s", d)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := matrix.write(compareFlags.matrixJSON, compareFlags.matrixHTML); err != nil {
+		return err
+	}
+
+	log.Printf("compared %d variants; %d diverged", compared, diverged)
+	if diverged > 0 {
+		return fmt.Errorf("%d variants diverged", diverged)
+	}
+	return nil
+}
+
+// executeForComparison executes the vector variant, collecting the receipts
+// of the top-level messages and the final state root, regardless of the
+// expectations of the vector, with the supplied options.
+func executeForComparison(tv *schema.TestVector, v *schema.Variant, opts conformance.ExecuteOpts) *variantOutcome {
+	out := new(variantOutcome)
+	opts.Hooks = &conformance.DriverHooks{
+		OnSubcall: func(depth int, trace *types.ExecutionTrace) {
+			if depth == 0 && trace.MsgRct != nil {
+				out.receipts = append(out.receipts, *trace.MsgRct)
+			}
+		},
+		OnStateRoot: func(root cid.Cid) {
+			out.root = root
+		},
+	}
+
+	// silence the execution logs; assertion failures are expected when the
+	// configurations diverge from the vector.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+
+	r := new(reportingReporter)
+	var supported bool
+	if ferr := recoverFatal(func() { _, supported, out.err = executeVariant(context.Background(), r, tv, v, opts) }); ferr != nil {
+		out.err, supported = ferr, true
+	}
+	out.failed, out.unsupported = r.Failed(), !supported
+	return out
+}
+
+// compareOutcomes returns a report of the divergences between the outcomes
+// under the named configurations, or an empty string if they match.
+func compareOutcomes(a, b *variantOutcome, nameA, nameB string) string {
+	var buf bytes.Buffer
+	if (a.err == nil) != (b.err == nil) {
+		fmt.Fprintf(&buf, "\texecution error: %s: %v; %s: %v\n", nameA, a.err, nameB, b.err)
+	}
+	if len(a.receipts) != len(b.receipts) {
+		fmt.Fprintf(&buf, "\treceipt count: %s: %d; %s: %d\n", nameA, len(a.receipts), nameB, len(b.receipts))
+	}
+	for i := 0; i < len(a.receipts) && i < len(b.receipts); i++ {
+		ra, rb := a.receipts[i], b.receipts[i]
+		if ra.ExitCode != rb.ExitCode {
+			fmt.Fprintf(&buf, "\treceipt %d exit code: %s: %s; %s: %s\n", i, nameA, ra.ExitCode, nameB, rb.ExitCode)
+		}
+		if ra.GasUsed != rb.GasUsed {
+			fmt.Fprintf(&buf, "\treceipt %d gas used: %s: %d; %s: %d (%+d)\n", i, nameA, ra.GasUsed, nameB, rb.GasUsed, rb.GasUsed-ra.GasUsed)
+		}
+		if !bytes.Equal(ra.Return, rb.Return) {
+			fmt.Fprintf(&buf, "\treceipt %d return: %s: %x; %s: %x\n", i, nameA, ra.Return, nameB, rb.Return)
+		}
+	}
+	// remote executors may not return the post state root.
+	if a.root.Defined() && b.root.Defined() && a.root != b.root {
+		fmt.Fprintf(&buf, "\tpost state root: %s: %s; %s: %s\n", nameA, a.root, nameB, b.root)
+	}
+	return buf.String()
+}
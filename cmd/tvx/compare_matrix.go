@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// compareConfig is an executor configuration tvx compare runs vectors under:
+// Lotus, with built-in actors bundles overriding the embedded ones, if any, or
+// another implementation, through its execution endpoint.
+type compareConfig struct {
+	name    string
+	bundles bundleConfig
+	remote  *remoteExecutor
+}
+
+// parseCompareConfig parses a configuration in <name>=<spec> form. The spec
+// is the base URL of an execution endpoint if it starts with http:// or
+// https://, a comma-separated list of <actors version>=<bundle path>
+// overrides otherwise, or empty for the embedded bundles.
+func parseCompareConfig(s string, timeout time.Duration) (*compareConfig, error) {
+	name, spec, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid configuration %q; expected <name>=<spec>", s)
+	}
+	cfg := &compareConfig{name: name}
+	switch {
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		remote, err := newRemoteExecutor(spec, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("configuration %s: %w", name, err)
+		}
+		cfg.remote = remote
+	case spec != "":
+		bundles, err := parseBundleConfig(strings.Split(spec, ","))
+		if err != nil {
+			return nil, fmt.Errorf("configuration %s: %w", name, err)
+		}
+		cfg.bundles = bundles
+	}
+	return cfg, nil
+}
+
+// apply configures the execution of vectors under the configuration.
+func (c *compareConfig) apply() {
+	c.bundles.apply()
+	execExecutor = c.remote
+}
+
+// executeOpts returns the options vectors are executed with under the
+// configuration; apply must be called first.
+func (c *compareConfig) executeOpts() conformance.ExecuteOpts {
+	return conformance.ExecuteOpts{DriverOpts: conformance.DriverOpts{DebugBundles: len(c.bundles) > 0}}
+}
+
+// describe returns what the configuration executes vectors with.
+func (c *compareConfig) describe() string {
+	if c.remote != nil {
+		return fmt.Sprintf("%s at %s", c.remote.info, c.remote.url)
+	}
+	if len(c.bundles) == 0 {
+		return "lotus, embedded bundles"
+	}
+	return "lotus, bundles " + strings.Join(sortedBundles(c.bundles), ", ")
+}
+
+// sortedBundles returns the overrides of the bundle configuration in
+// <actors version>=<bundle path> form, by actors version.
+func sortedBundles(c bundleConfig) []string {
+	versions := make([]int, 0, len(c))
+	for av := range c {
+		versions = append(versions, av)
+	}
+	sort.Ints(versions)
+	out := make([]string, 0, len(c))
+	for _, av := range versions {
+		out = append(out, fmt.Sprintf("v%d=%s", av, c[av]))
+	}
+	return out
+}
+
+// Statuses of the cells of the compatibility matrix.
+const (
+	matrixPassed      = "passed"
+	matrixFailed      = "failed"
+	matrixUnsupported = "unsupported"
+)
+
+// compareMatrix is the compatibility matrix of a tvx compare run: the outcome
+// of every vector variant under every configuration, and whether it diverges
+// from that under the first one, the baseline.
+type compareMatrix struct {
+	Generated time.Time           `json:"generated"`
+	Executors []matrixExecutor    `json:"executors"`
+	Rows      []*compareMatrixRow `json:"rows"`
+}
+
+// matrixExecutor identifies a configuration of the matrix.
+type matrixExecutor struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// compareMatrixRow holds the outcomes of a vector variant, a cell per
+// configuration, in order.
+type compareMatrixRow struct {
+	Vector  string       `json:"vector"`
+	ID      string       `json:"id,omitempty"`
+	Variant string       `json:"variant"`
+	Cells   []matrixCell `json:"cells"`
+}
+
+// matrixCell is the outcome of a vector variant under a configuration: its
+// status against the postconditions of the vector, the gas used by its
+// messages, and how it diverges from that under the baseline, if it does.
+type matrixCell struct {
+	Status     string   `json:"status"`
+	GasUsed    int64    `json:"gas_used"`
+	Error      string   `json:"error,omitempty"`
+	Divergence []string `json:"divergence,omitempty"`
+}
+
+// Diverges returns whether the outcome of the variant differs across the
+// configurations.
+func (r *compareMatrixRow) Diverges() bool {
+	for _, c := range r.Cells {
+		if len(c.Divergence) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func newCompareMatrix(configs []*compareConfig) *compareMatrix {
+	m := &compareMatrix{Generated: time.Now().UTC()}
+	for _, c := range configs {
+		m.Executors = append(m.Executors, matrixExecutor{Name: c.name, Description: c.describe()})
+	}
+	return m
+}
+
+// add records the outcomes of the variant under the configurations, in
+// order, and returns the row, with the divergences from the baseline.
+func (m *compareMatrix) add(vector, id, variant string, outcomes []*variantOutcome) *compareMatrixRow {
+	row := &compareMatrixRow{Vector: vector, ID: id, Variant: variant}
+	base := outcomes[0]
+	for i, o := range outcomes {
+		cell := matrixCell{Status: matrixPassed, GasUsed: o.gasUsed()}
+		switch {
+		case o.unsupported:
+			cell.Status = matrixUnsupported
+		case o.err != nil || o.failed:
+			cell.Status = matrixFailed
+		}
+		if o.err != nil {
+			cell.Error = o.err.Error()
+		}
+		if i > 0 && !o.unsupported && !base.unsupported {
+			report := compareOutcomes(base, o, m.Executors[0].Name, m.Executors[i].Name)
+			for _, l := range strings.Split(strings.TrimRight(report, "\n"), "\n") {
+				if l = strings.TrimSpace(l); l != "" {
+					cell.Divergence = append(cell.Divergence, l)
+				}
+			}
+		}
+		row.Cells = append(row.Cells, cell)
+	}
+	m.Rows = append(m.Rows, row)
+	return row
+}
+
+// write writes the matrix as JSON to jsonPath, and as HTML to htmlPath, if
+// set.
+func (m *compareMatrix) write(jsonPath, htmlPath string) error {
+	for _, out := range []struct {
+		path  string
+		write func(w io.Writer) error
+	}{
+		{jsonPath, func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(m)
+		}},
+		{htmlPath, func(w io.Writer) error { return matrixHTMLTemplate.Execute(w, m) }},
+	} {
+		if out.path == "" {
+			continue
+		}
+		f, err := os.Create(out.path)
+		if err != nil {
+			return fmt.Errorf("failed to create matrix %s: %w", out.path, err)
+		}
+		if err := out.write(f); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to write matrix %s: %w", out.path, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		log.Printf("wrote compatibility matrix to %s", out.path)
+	}
+	return nil
+}
+
+var matrixHTMLTemplate = htmltemplate.Must(htmltemplate.New("matrix").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Compatibility matrix</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+.passed { color: #1a7f37; }
+.failed { color: #cf222e; }
+.unsupported { color: #6e7781; }
+.diverges { background: #fff8c5; }
+ul { margin: 0.3em 0 0 0; padding-left: 1.2em; font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>Compatibility matrix</h1>
+<p>Generated at {{.Generated.Format "2006-01-02T15:04:05Z07:00"}}. Divergences are from the baseline, {{(index .Executors 0).Name}}.</p>
+<table>
+<tr><th>executor</th><th>configuration</th></tr>
+{{- range .Executors}}
+<tr><td>{{.Name}}</td><td>{{.Description}}</td></tr>
+{{- end}}
+</table>
+<table>
+<tr><th>vector</th><th>variant</th>{{range .Executors}}<th>{{.Name}}</th>{{end}}</tr>
+{{- range .Rows}}
+<tr>
+<td>{{.Vector}}</td>
+<td>{{.Variant}}</td>
+{{- range .Cells}}
+<td{{if .Divergence}} class="diverges"{{end}}><span class="{{.Status}}">{{.Status}}</span>, gas {{.GasUsed}}
+{{- if or .Error .Divergence}}
+<ul>{{with .Error}}<li>{{.}}</li>{{end}}{{range .Divergence}}<li>{{.}}</li>{{end}}</ul>
+{{- end}}</td>
+{{- end}}
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,73 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestParseCompareConfig(t *testing.T) {
+	cfg, err := parseCompareConfig("nightly=v10=./a.car,v9=./b.car", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.name != "nightly" || cfg.remote != nil || cfg.bundles[10] != "./a.car" || cfg.bundles[9] != "./b.car" {
+		t.Errorf("unexpected configuration: %+v", cfg)
+	}
+	if d := cfg.describe(); d != "lotus, bundles v9=./b.car, v10=./a.car" {
+		t.Errorf("unexpected description: %s", d)
+	}
+
+	if cfg, err = parseCompareConfig("lotus=", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.bundles) != 0 || cfg.describe() != "lotus, embedded bundles" {
+		t.Errorf("expected the embedded bundles; got %+v", cfg)
+	}
+
+	for _, s := range []string{"nightly", "=v10=./a.car", "nightly=v10"} {
+		if _, err := parseCompareConfig(s, time.Minute); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestCompareMatrix(t *testing.T) {
+	m := newCompareMatrix([]*compareConfig{{name: "lotus"}, {name: "nightly"}, {name: "other"}})
+	ok := func(gas int64) *variantOutcome {
+		return &variantOutcome{receipts: []types.MessageReceipt{{ExitCode: exitcode.Ok, GasUsed: gas}}}
+	}
+
+	row := m.add("a.json", "a", "v1", []*variantOutcome{ok(100), ok(100), {unsupported: true, err: errors.New("unsupported")}})
+	if row.Diverges() {
+		t.Errorf("expected no divergence; got %+v", row.Cells)
+	}
+	if s := row.Cells[2].Status; s != matrixUnsupported {
+		t.Errorf("expected the vector to be unsupported by the last executor; got %s", s)
+	}
+
+	diverging := ok(120)
+	diverging.failed = true
+	row = m.add("b.json", "b", "v1", []*variantOutcome{ok(100), diverging, ok(100)})
+	if !row.Diverges() || len(row.Cells[1].Divergence) != 1 || len(row.Cells[2].Divergence) != 0 {
+		t.Fatalf("expected the second executor alone to diverge; got %+v", row.Cells)
+	}
+	if c := row.Cells[1]; c.Status != matrixFailed || c.GasUsed != 120 || !strings.Contains(c.Divergence[0], "lotus: 100; nightly: 120") {
+		t.Errorf("unexpected cell: %+v", c)
+	}
+
+	var buf bytes.Buffer
+	if err := matrixHTMLTemplate.Execute(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	if html := buf.String(); !strings.Contains(html, `class="diverges"`) || !strings.Contains(html, "<th>nightly</th>") {
+		t.Errorf("unexpected HTML matrix:\n%s", html)
+	}
+}
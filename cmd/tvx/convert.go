@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// The versions of the vector schema tvx convert converts between.
+const (
+	// vectorSchemaV1 is the layout of the vectors predating variants: the
+	// preconditions carry the epoch of the vector, and messages and tipsets
+	// their absolute epochs. The network version is implied by the epoch.
+	vectorSchemaV1 = 1
+	// vectorSchemaV2 is the current layout: the preconditions carry the
+	// variants of the vector, with their epochs and network versions, and
+	// messages and tipsets their epochs as offsets from that of the variant.
+	vectorSchemaV2 = 2
+
+	latestVectorSchema = vectorSchemaV2
+)
+
+// vectorDoc is the JSON document of a vector, decoded generically, so that
+// vectors of all schema versions can be read.
+type vectorDoc = map[string]interface{}
+
+// vectorSchemaUpgrades upgrade vectors of the schema version of their index
+// plus one to the next; vectorSchemaDowngrades downgrade vectors of the schema
+// version of their index plus two to the previous.
+var (
+	vectorSchemaUpgrades   = []func(doc vectorDoc, opts convertOpts) error{upgradeVectorV1}
+	vectorSchemaDowngrades = []func(doc vectorDoc, opts convertOpts) error{downgradeVectorV2}
+)
+
+type convertOpts struct {
+	// variant is the ID of the variant retained when downgrading vectors of
+	// several variants to a schema version without variants.
+	variant string
+}
+
+var convertFlags struct {
+	to      string
+	out     string
+	variant string
+}
+
+var convertCmd = &cli.Command{
+	Name: "convert",
+	Description: `convert test vectors between versions of the vector schema.
+
+   Schema versions:
+
+     v1  the layout predating variants: the preconditions carry the epoch of
+         the vector, and messages and tipsets their absolute epochs; the
+         network version is implied by the epoch.
+     v2  the current layout: the preconditions carry the variants of the
+         vector, with their epochs and network versions, and messages and
+         tipsets their epochs as offsets from that of the variant.
+
+   The schema version of each vector is inferred from its layout. Upgrading
+   fills the fields the newer version requires with the values the older one
+   implied: v1 vectors get a single variant, named after the protocol codename
+   of their epoch, at the network version of the default upgrade schedule of
+   this build. Downgrading fails for vectors that can't be expressed in the
+   older version, e.g. v2 vectors whose network version isn't that of their
+   epoch; vectors of several variants are downgraded to the one selected with
+   --variant. The schema version is recorded in the generation metadata, as
+   schema:<version>.
+
+   Vectors are rewritten in place, unless --out is supplied, in which case they
+   are written to that directory under their original file names, encoded as
+   their names call for. Vectors already of the target version are left
+   untouched.`,
+	ArgsUsage: "<vector file or dir>...",
+	Action:    runConvert,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "to-schema",
+			Usage:       "schema version to convert vectors to; values: v1, v2",
+			Value:       fmt.Sprintf("v%d", latestVectorSchema),
+			Destination: &convertFlags.to,
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Usage:       "directory to write the converted vectors to; if empty, vectors are rewritten in place",
+			Destination: &convertFlags.out,
+		},
+		&cli.StringFlag{
+			Name:        "variant",
+			Usage:       "ID of the variant to retain when downgrading vectors of several variants to v1",
+			Destination: &convertFlags.variant,
+		},
+	},
+}
+
+func runConvert(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no vector files or directories supplied")
+	}
+	to, err := parseVectorSchemaVersion(convertFlags.to)
+	if err != nil {
+		return err
+	}
+	if convertFlags.out != "" {
+		if err := ensureDir(convertFlags.out); err != nil {
+			return err
+		}
+	}
+	opts := convertOpts{variant: convertFlags.variant}
+
+	var converted, skipped int
+	for _, root := range c.Args().Slice() {
+		err := walkVectorFiles(root, func(path string, content []byte) error {
+			out := path
+			if convertFlags.out != "" {
+				out = filepath.Join(convertFlags.out, filepath.Base(path))
+			} else if inVectorArchive(path) {
+				log.Printf("skipping vector %s: vectors in archives are converted with --out", path)
+				skipped++
+				return nil
+			}
+
+			data, from, err := convertVector(content, to, opts)
+			if err != nil {
+				return fmt.Errorf("failed to convert vector %s: %w", path, err)
+			}
+			if from == to && out == path {
+				skipped++
+				return nil
+			}
+			if data, err = encodeVectorFile(out, data); err != nil {
+				return fmt.Errorf("failed to encode vector %s: %w", path, err)
+			}
+			if err := os.WriteFile(out, data, 0644); err != nil {
+				return fmt.Errorf("failed to write vector %s: %w", out, err)
+			}
+			log.Printf("converted vector %s from v%d to v%d: %s", path, from, to, out)
+			converted++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("converted %d vectors; skipped %d", converted, skipped)
+	return nil
+}
+
+// parseVectorSchemaVersion parses a schema version, e.g. "v2".
+func parseVectorSchemaVersion(s string) (int, error) {
+	v, err := strconv.Atoi(strings.TrimPrefix(s, "v"))
+	if err != nil || v < vectorSchemaV1 || v > latestVectorSchema {
+		return 0, fmt.Errorf("unknown schema version %q; values: v1 to v%d", s, latestVectorSchema)
+	}
+	return v, nil
+}
+
+// vectorSchemaVersion infers the schema version of the vector from its
+// layout.
+func vectorSchemaVersion(doc vectorDoc) (int, error) {
+	pre, ok := doc["preconditions"].(vectorDoc)
+	if !ok {
+		return 0, fmt.Errorf("vector has no preconditions")
+	}
+	if _, ok := pre["variants"]; ok {
+		return vectorSchemaV2, nil
+	}
+	if _, ok := pre["epoch"]; ok {
+		return vectorSchemaV1, nil
+	}
+	return 0, fmt.Errorf("unable to infer the schema version: the preconditions carry neither variants nor an epoch")
+}
+
+// convertVector converts the JSON document of a vector to the schema version,
+// a step at a time, and returns the converted document and the version of the
+// vector. Documents of the latest version are normalized through the schema
+// types, which validates them.
+func convertVector(content []byte, to int, opts convertOpts) ([]byte, int, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber() // epochs and amounts must survive the round trip.
+	var doc vectorDoc
+	if err := dec.Decode(&doc); err != nil {
+		return nil, 0, err
+	}
+	from, err := vectorSchemaVersion(doc)
+	if err != nil {
+		return nil, 0, err
+	}
+	for v := from; v < to; v++ {
+		if err := vectorSchemaUpgrades[v-1](doc, opts); err != nil {
+			return nil, from, fmt.Errorf("failed to upgrade from v%d to v%d: %w", v, v+1, err)
+		}
+	}
+	for v := from; v > to; v-- {
+		if err := vectorSchemaDowngrades[v-2](doc, opts); err != nil {
+			return nil, from, fmt.Errorf("failed to downgrade from v%d to v%d: %w", v, v-1, err)
+		}
+	}
+	if from != to {
+		if err := setSchemaGen(doc, to); err != nil {
+			return nil, from, err
+		}
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, from, err
+	}
+	if to == latestVectorSchema {
+		var tv schema.TestVector
+		if err := json.Unmarshal(data, &tv); err != nil {
+			return nil, from, fmt.Errorf("converted vector is invalid: %w", err)
+		}
+		if data, err = json.MarshalIndent(&tv, "", "  "); err != nil {
+			return nil, from, err
+		}
+	}
+	return append(data, '\n'), from, nil
+}
+
+// setSchemaGen records the schema version in the generation metadata of the
+// vector, replacing that recorded before.
+func setSchemaGen(doc vectorDoc, version int) error {
+	meta, ok := doc["_meta"].(vectorDoc)
+	if !ok {
+		return fmt.Errorf("vector has no metadata")
+	}
+	gen, _ := meta["gen"].([]interface{})
+	kept := make([]interface{}, 0, len(gen)+1)
+	for _, g := range gen {
+		if g, ok := g.(vectorDoc); ok {
+			if src, _ := g["source"].(string); strings.HasPrefix(src, "schema:") {
+				continue
+			}
+		}
+		kept = append(kept, g)
+	}
+	meta["gen"] = append(kept, vectorDoc{"source": fmt.Sprintf("schema:v%d", version)})
+	return nil
+}
+
+// upgradeVectorV1 upgrades a v1 vector to v2: its epoch becomes its single
+// variant, and the epochs of its messages and tipsets offsets from it.
+func upgradeVectorV1(doc vectorDoc, _ convertOpts) error {
+	pre := doc["preconditions"].(vectorDoc)
+	epoch, err := jsonInt(pre["epoch"])
+	if err != nil {
+		return fmt.Errorf("invalid epoch: %w", err)
+	}
+	delete(pre, "epoch")
+	pre["variants"] = []interface{}{vectorDoc{
+		"id":    extractor.GetProtocolCodename(abi.ChainEpoch(epoch)),
+		"epoch": epoch,
+		"nv":    uint(conformance.DefaultNetworkVersionAt(abi.ChainEpoch(epoch))),
+	}}
+
+	// epochs of messages are optional, and default to that of the vector.
+	err = rewriteEpochs(doc, "apply_messages", "epoch", "epoch_offset", false, func(e int64) int64 { return e - epoch })
+	if err != nil {
+		return err
+	}
+	return rewriteEpochs(doc, "apply_tipsets", "epoch", "epoch_offset", true, func(e int64) int64 { return e - epoch })
+}
+
+// downgradeVectorV2 downgrades a v2 vector to v1: the epoch of its variant
+// becomes that of the vector, and the offsets of its messages and tipsets
+// absolute epochs. Vectors of several variants are downgraded to that
+// selected in the options, and the network version of the variant must be
+// that of its epoch, as implied in v1.
+func downgradeVectorV2(doc vectorDoc, opts convertOpts) error {
+	pre := doc["preconditions"].(vectorDoc)
+	variants, _ := pre["variants"].([]interface{})
+	var variant vectorDoc
+	switch {
+	case opts.variant != "":
+		for _, v := range variants {
+			if v, ok := v.(vectorDoc); ok && v["id"] == opts.variant {
+				variant = v
+			}
+		}
+		if variant == nil {
+			return fmt.Errorf("vector has no variant %s", opts.variant)
+		}
+	case len(variants) == 1:
+		variant, _ = variants[0].(vectorDoc)
+	default:
+		return fmt.Errorf("vector has %d variants, and v1 vectors a single epoch; select one with --variant", len(variants))
+	}
+	if variant == nil {
+		return fmt.Errorf("invalid variant")
+	}
+
+	epoch, err := jsonInt(variant["epoch"])
+	if err != nil {
+		return fmt.Errorf("invalid variant epoch: %w", err)
+	}
+	nv, err := jsonInt(variant["nv"])
+	if err != nil {
+		return fmt.Errorf("invalid variant network version: %w", err)
+	}
+	if implied := conformance.DefaultNetworkVersionAt(abi.ChainEpoch(epoch)); int64(implied) != nv {
+		return fmt.Errorf("variant %v runs network version %d at epoch %d, where v1 vectors run network version %d", variant["id"], nv, epoch, implied)
+	}
+	delete(pre, "variants")
+	pre["epoch"] = epoch
+
+	err = rewriteEpochs(doc, "apply_messages", "epoch_offset", "epoch", false, func(o int64) int64 { return epoch + o })
+	if err != nil {
+		return err
+	}
+	return rewriteEpochs(doc, "apply_tipsets", "epoch_offset", "epoch", true, func(o int64) int64 { return epoch + o })
+}
+
+// rewriteEpochs replaces the from field of the elements of the list field of
+// the vector by the to field, of the value mapped by fn. The from field is
+// required if so.
+func rewriteEpochs(doc vectorDoc, list, from, to string, required bool, fn func(int64) int64) error {
+	elems, _ := doc[list].([]interface{})
+	for i, e := range elems {
+		e, ok := e.(vectorDoc)
+		if !ok {
+			return fmt.Errorf("invalid element %d of %s", i, list)
+		}
+		v, ok := e[from]
+		if !ok || v == nil {
+			if required {
+				return fmt.Errorf("element %d of %s has no %s", i, list, from)
+			}
+			continue
+		}
+		n, err := jsonInt(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s of element %d of %s: %w", from, i, list, err)
+		}
+		delete(e, from)
+		e[to] = fn(n)
+	}
+	return nil
+}
+
+// jsonInt returns the integer of a JSON number, as decoded with UseNumber.
+func jsonInt(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Int64()
+	case int64:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("expected an integer, got %v", v)
+	}
+}
@@ -0,0 +1,120 @@
+// stm: #unit
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestConvertVectorSchema(t *testing.T) {
+	root, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}.Sum([]byte("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := []byte(fmt.Sprintf(`{
+		"class": "tipset",
+		"_meta": {"id": "legacy", "gen": [{"source": "github.com/filecoin-project/lotus", "version": "1.0.0"}]},
+		"car": "",
+		"preconditions": {"epoch": 10, "state_tree": {"root_cid": {"/": %q}}, "basefee": "100"},
+		"apply_messages": [{"bytes": "", "epoch": 12}, {"bytes": ""}],
+		"apply_tipsets": [{"epoch": 11, "basefee": "100"}],
+		"postconditions": {"state_tree": {"root_cid": {"/": %q}}, "receipts": []}
+	}`, root, root))
+
+	up, from, err := convertVector(v1, vectorSchemaV2, convertOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != vectorSchemaV1 {
+		t.Fatalf("expected a v1 vector, got v%d", from)
+	}
+	var tv schema.TestVector
+	if err := json.Unmarshal(up, &tv); err != nil {
+		t.Fatal(err)
+	}
+	want := []schema.Variant{{ID: "genesis", Epoch: 10, NetworkVersion: uint(conformance.DefaultNetworkVersionAt(10))}}
+	if !reflect.DeepEqual(tv.Pre.Variants, want) {
+		t.Errorf("expected variants %v, got %v", want, tv.Pre.Variants)
+	}
+	if o := tv.ApplyMessages[0].EpochOffset; o == nil || *o != 2 {
+		t.Errorf("expected epoch offset 2 for message 0, got %v", o)
+	}
+	if o := tv.ApplyMessages[1].EpochOffset; o != nil {
+		t.Errorf("expected no epoch offset for message 1, got %d", *o)
+	}
+	if o := tv.ApplyTipsets[0].EpochOffset; o != 1 {
+		t.Errorf("expected epoch offset 1 for tipset 0, got %d", o)
+	}
+	if g := tv.Meta.Gen[len(tv.Meta.Gen)-1]; g.Source != "schema:v2" {
+		t.Errorf("expected the schema version in the generation metadata, got %v", tv.Meta.Gen)
+	}
+
+	down, from, err := convertVector(up, vectorSchemaV1, convertOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != vectorSchemaV2 {
+		t.Fatalf("expected a v2 vector, got v%d", from)
+	}
+	var doc struct {
+		Meta struct {
+			Gen []schema.GenerationData `json:"gen"`
+		} `json:"_meta"`
+		Pre           map[string]json.RawMessage   `json:"preconditions"`
+		ApplyMessages []map[string]json.RawMessage `json:"apply_messages"`
+		ApplyTipsets  []map[string]json.RawMessage `json:"apply_tipsets"`
+	}
+	if err := json.Unmarshal(down, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		field map[string]json.RawMessage
+		want  string
+	}{
+		{doc.Pre, "10"},
+		{doc.ApplyMessages[0], "12"},
+		{doc.ApplyMessages[1], ""},
+		{doc.ApplyTipsets[0], "11"},
+	} {
+		if got := string(c.field["epoch"]); got != c.want {
+			t.Errorf("expected epoch %q, got %q", c.want, got)
+		}
+	}
+	if _, ok := doc.Pre["variants"]; ok {
+		t.Errorf("expected no variants in a v1 vector")
+	}
+	wantGen := []schema.GenerationData{{Source: "github.com/filecoin-project/lotus", Version: "1.0.0"}, {Source: "schema:v1"}}
+	if !reflect.DeepEqual(doc.Meta.Gen, wantGen) {
+		t.Errorf("expected generation metadata %v, got %v", wantGen, doc.Meta.Gen)
+	}
+}
+
+func TestDowngradeVectorVariants(t *testing.T) {
+	nv := conformance.DefaultNetworkVersionAt(10)
+	v2 := []byte(fmt.Sprintf(`{
+		"class": "message",
+		"_meta": {"id": "variants", "gen": []},
+		"preconditions": {"variants": [{"id": "a", "epoch": 10, "nv": %d}, {"id": "b", "epoch": 10, "nv": %d}]}
+	}`, nv, nv+1))
+
+	if _, _, err := convertVector(v2, vectorSchemaV1, convertOpts{}); err == nil || !strings.Contains(err.Error(), "2 variants") {
+		t.Errorf("expected the downgrade of several variants to fail, got %v", err)
+	}
+	if _, _, err := convertVector(v2, vectorSchemaV1, convertOpts{variant: "a"}); err != nil {
+		t.Errorf("failed to downgrade the selected variant: %s", err)
+	}
+	// v1 vectors run the network version of their epoch.
+	if _, _, err := convertVector(v2, vectorSchemaV1, convertOpts{variant: "b"}); err == nil || !strings.Contains(err.Error(), "network version") {
+		t.Errorf("expected the downgrade of another network version to fail, got %v", err)
+	}
+}
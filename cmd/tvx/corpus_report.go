@@ -0,0 +1,572 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+const (
+	vectorStatusPassed = "passed"
+	vectorStatusFailed = "failed"
+	vectorStatusCached = "cached"
+	// vectorStatusTimedOut is the status of the vectors whose execution
+	// exceeded --per-vector-timeout.
+	vectorStatusTimedOut = "timed-out"
+	// vectorStatusExpectedFailure and vectorStatusSkipped are the statuses
+	// of the vectors annotated as known to be broken, which failed as
+	// expected, or were skipped; see conformance.Annotation. Annotated
+	// vectors passing unexpectedly are failed.
+	vectorStatusExpectedFailure = "expected-failure"
+	vectorStatusSkipped         = "skipped"
+)
+
+// The ways of grouping the vectors of a corpus report.
+const (
+	// reportGroupByMethod groups vectors by the actor and method they
+	// exercise; see vectorReport.Group.
+	reportGroupByMethod = "method"
+	// reportGroupByTag groups vectors by tag; vectors appear in the groups
+	// of all their tags, and untagged ones in reportUntagged.
+	reportGroupByTag = "tag"
+
+	reportUntagged = "untagged"
+)
+
+// vectorReport is the outcome of the execution of a vector, as presented in a
+// corpus report.
+type vectorReport struct {
+	Path  string `json:"path"`
+	ID    string `json:"id"`
+	Class string `json:"class"`
+	// Group is the actor and method exercised by the vector, used to group
+	// vectors in the report.
+	Group string `json:"group"`
+	// Tags are the tags of the vector.
+	Tags   []string `json:"tags,omitempty"`
+	Status string   `json:"status"`
+	// Failures holds the failed assertions.
+	Failures []string `json:"failures,omitempty"`
+	// Diffs holds the state diffs of vectors with wrong post state roots.
+	Diffs []string `json:"diffs,omitempty"`
+	// Stack holds the stack trace of the panic the execution of the vector
+	// raised, if any.
+	Stack string `json:"stack,omitempty"`
+	// GasExpected and GasActual are the total gas used by the messages of
+	// message-class vectors, as recorded in the vector and as observed.
+	HasGas      bool  `json:"has_gas,omitempty"`
+	GasExpected int64 `json:"gas_expected,omitempty"`
+	GasActual   int64 `json:"gas_actual,omitempty"`
+	// Signature is the root cause signature of failed and timed out vectors,
+	// by which the report triages them; see failureSignature.
+	Signature string `json:"signature,omitempty"`
+}
+
+func (v *vectorReport) GasDelta() int64 {
+	return v.GasActual - v.GasExpected
+}
+
+// corpusReport collects the outcomes of the vectors executed in a corpus run.
+type corpusReport struct {
+	Generated time.Time `json:"generated"`
+	// GroupBy is how the vectors are grouped: reportGroupByMethod (the
+	// default, if empty) or reportGroupByTag.
+	GroupBy string `json:"group_by,omitempty"`
+	// Shard is the shard of the corpus the run executed, if sharded.
+	Shard *vectorShard `json:"shard,omitempty"`
+	// MessageOverrides describes the message overrides the run applied, if
+	// any; its results are then non-canonical.
+	MessageOverrides string          `json:"message_overrides,omitempty"`
+	Vectors          []*vectorReport `json:"vectors"`
+}
+
+// execReport is the corpus report of tvx exec, if requested.
+var execReport *corpusReport
+
+// reportGroup is a group of vectors in a corpus report.
+type reportGroup struct {
+	Name             string
+	Passed           int
+	Failed           int
+	Cached           int
+	TimedOut         int
+	ExpectedFailures int
+	Skipped          int
+	Vectors          []*vectorReport
+}
+
+// Groups returns the vectors of the report grouped as requested by GroupBy,
+// sorted by name.
+func (c *corpusReport) Groups() []*reportGroup {
+	byName := make(map[string]*reportGroup)
+	for _, v := range c.Vectors {
+		for _, name := range c.groupsOf(v) {
+			g, ok := byName[name]
+			if !ok {
+				g = &reportGroup{Name: name}
+				byName[name] = g
+			}
+			g.add(v)
+		}
+	}
+
+	groups := make([]*reportGroup, 0, len(byName))
+	for _, g := range byName {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// groupsOf returns the names of the groups the vector appears in.
+func (c *corpusReport) groupsOf(v *vectorReport) []string {
+	if c.GroupBy != reportGroupByTag {
+		return []string{v.Group}
+	}
+	if len(v.Tags) == 0 {
+		return []string{reportUntagged}
+	}
+	return v.Tags
+}
+
+func (g *reportGroup) add(v *vectorReport) {
+	switch v.Status {
+	case vectorStatusPassed:
+		g.Passed++
+	case vectorStatusFailed:
+		g.Failed++
+	case vectorStatusTimedOut:
+		g.TimedOut++
+	case vectorStatusExpectedFailure:
+		g.ExpectedFailures++
+	case vectorStatusSkipped:
+		g.Skipped++
+	default:
+		g.Cached++
+	}
+	g.Vectors = append(g.Vectors, v)
+}
+
+// Totals returns the number of passed, failed, timed out, cached, expected to
+// fail and skipped vectors. Vectors in several groups are counted once.
+func (c *corpusReport) Totals() reportGroup {
+	var t reportGroup
+	for _, v := range c.Vectors {
+		t.add(v)
+	}
+	t.Vectors = nil
+	return t
+}
+
+// errFatalAssertion is the panic value with which reportingReporter aborts
+// the execution of a vector on fatal failures.
+type errFatalAssertion struct{ msg string }
+
+// reportingReporter is a conformance.Reporter that records failed assertions
+// for the corpus report. Unlike conformance.LogReporter, fatal failures abort
+// only the execution of the vector, so that the corpus run can proceed.
+type reportingReporter struct {
+	conformance.LogReporter
+	failed   int32
+	failures []string
+}
+
+func (r *reportingReporter) Failed() bool {
+	return atomic.LoadInt32(&r.failed) == 1
+}
+
+func (r *reportingReporter) Errorf(format string, args ...interface{}) {
+	atomic.StoreInt32(&r.failed, 1)
+	msg := fmt.Sprintf(format, args...)
+	r.failures = append(r.failures, msg)
+	log.Println(color.HiRedString("❌ " + msg))
+}
+
+func (r *reportingReporter) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	panic(errFatalAssertion{msg: fmt.Sprintf(format, args...)})
+}
+
+func (r *reportingReporter) FailNow() {
+	atomic.StoreInt32(&r.failed, 1)
+	panic(errFatalAssertion{msg: "execution aborted"})
+}
+
+// recoverFatal runs f, recovering from the fatal failures reported through
+// a reportingReporter, which it returns as errors.
+func recoverFatal(f func()) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			fatal, ok := p.(errFatalAssertion)
+			if !ok {
+				panic(p)
+			}
+			err = fmt.Errorf("fatal failure: %s", fatal.msg)
+		}
+	}()
+	f()
+	return nil
+}
+
+// executeAndReport executes the vector, recording its outcome in the corpus
+// report. Fatal failures are reported, and returned as errors. gas returns
+// the gas used by the top-level messages applied so far.
+func executeAndReport(path string, tv schema.TestVector, gas func() []int64, opts conformance.ExecuteOpts) (diffs []string, passed bool, err error) {
+	vr := &vectorReport{
+		Path:  path,
+		ID:    tv.Meta.ID,
+		Class: string(tv.Class),
+		Group: vectorGroup(&tv),
+		Tags:  vectorTags(&tv),
+	}
+	execReport.Vectors = append(execReport.Vectors, vr)
+	execUI.startedVector(path)
+
+	// keep the first mismatched message, to triage the failure.
+	var mismatch *conformance.ReceiptMismatch
+	opts.Assert.OnMismatch = func(m *conformance.ReceiptMismatch) {
+		if mismatch == nil {
+			mismatch = m
+		}
+	}
+
+	r := new(reportingReporter)
+	diffs, err = executeGuarded(r, tv, opts)
+
+	var (
+		perr *errVectorPanic
+		terr *errVectorTimeout
+	)
+	if errors.As(err, &terr) {
+		// the execution was interrupted partway, or is stuck still recording
+		// failures and gas.
+		vr.Status, vr.Failures = vectorStatusTimedOut, []string{err.Error()}
+		vr.Signature = failureSignature(err, nil, nil)
+		return nil, false, err
+	}
+	if errors.As(err, &perr) {
+		vr.Stack = string(perr.stack)
+		r.failures = append(r.failures, perr.Error())
+	}
+
+	vr.Status = vectorStatusPassed
+	if err != nil || r.Failed() {
+		vr.Status = vectorStatusFailed
+	}
+	vr.Failures = r.failures
+	if err != nil && len(r.failures) == 0 {
+		vr.Failures = []string{err.Error()}
+	}
+	vr.Diffs = diffs
+	if vr.Status == vectorStatusFailed {
+		vr.Signature = failureSignature(err, mismatch, diffs)
+	}
+	if a := execSuppressions.Annotation(path, &tv); a != nil && a.Kind == conformance.AnnotationExpectedFail {
+		if vr.Status == vectorStatusPassed {
+			vr.Status = vectorStatusFailed
+			vr.Failures = []string{fmt.Sprintf("vector %s, but passed; remove the annotation", a)}
+			vr.Signature = "unexpected pass"
+		} else {
+			vr.Status, vr.Signature = vectorStatusExpectedFailure, ""
+			vr.Failures = append([]string{"vector " + a.String()}, vr.Failures...)
+		}
+	}
+
+	if gas := gas(); tv.Class == schema.ClassMessage && tv.Post != nil && len(gas) == len(tv.Post.Receipts)*len(tv.Pre.Variants) {
+		vr.HasGas = true
+		for _, rct := range tv.Post.Receipts {
+			vr.GasExpected += rct.GasUsed
+		}
+		// report the first variant.
+		for _, g := range gas[:len(tv.Post.Receipts)] {
+			vr.GasActual += g
+		}
+	}
+
+	// the outcome of the execution, regardless of the annotations, is
+	// cached.
+	return diffs, err == nil && !r.Failed(), err
+}
+
+// reportSkipped records a vector skipped as annotated.
+func reportSkipped(path string, tv schema.TestVector, a *conformance.Annotation) {
+	if execReport == nil {
+		return
+	}
+	vr := &vectorReport{
+		Path:     path,
+		Class:    string(tv.Class),
+		Group:    vectorGroup(&tv),
+		Tags:     vectorTags(&tv),
+		Status:   vectorStatusSkipped,
+		Failures: []string{"vector " + a.String()},
+	}
+	if tv.Meta != nil {
+		vr.ID = tv.Meta.ID
+	}
+	execReport.Vectors = append(execReport.Vectors, vr)
+	execUI.finishedVector(vr)
+}
+
+// reportCached records a vector skipped thanks to the result cache.
+func reportCached(path string, tv schema.TestVector, cached *cachedResult) {
+	if execReport == nil {
+		return
+	}
+	vr := &vectorReport{
+		Path:   path,
+		ID:     tv.Meta.ID,
+		Class:  string(tv.Class),
+		Group:  vectorGroup(&tv),
+		Tags:   vectorTags(&tv),
+		Status: vectorStatusCached,
+		Diffs:  cached.Diffs,
+	}
+	if !cached.Passed {
+		vr.Failures = []string{"failed in a previous run: " + cached.String()}
+	}
+	execReport.Vectors = append(execReport.Vectors, vr)
+	execUI.finishedVector(vr)
+}
+
+// vectorGroup returns the actor and method exercised by the first message of
+// the vector, resolving the actor name from the precondition state if
+// possible.
+func vectorGroup(tv *schema.TestVector) string {
+	if tv.Class != schema.ClassMessage || len(tv.ApplyMessages) == 0 {
+		return string(tv.Class)
+	}
+
+	msg, _, err := conformance.DecodeVectorMessage(tv.ApplyMessages[0].Bytes)
+	if err != nil {
+		return "undecodable message"
+	}
+
+	actor := msg.To.String()
+	if bs, err := conformance.LoadBlockstore(tv.CAR); err == nil {
+		st, err := state.LoadStateTree(cbornode.NewCborStore(bs), tv.Pre.StateTree.RootCID)
+		if err == nil {
+			if act, err := st.GetActor(msg.To); err == nil {
+				actor = builtin.ActorNameByCode(act.Code)
+			}
+		}
+	}
+	return fmt.Sprintf("%s.%d", actor, msg.Method)
+}
+
+// vectorTags returns the tags of the vector.
+func vectorTags(tv *schema.TestVector) []string {
+	if tv.Meta == nil {
+		return nil
+	}
+	return tv.Meta.Tags
+}
+
+// writeReports writes the corpus report to the requested files.
+func writeReports(c *corpusReport, htmlPath, mdPath, jsonPath string) error {
+	if c == nil {
+		return nil
+	}
+	for _, out := range []struct {
+		path  string
+		write func(w io.Writer, c *corpusReport) error
+	}{
+		{htmlPath, writeHTMLReport},
+		{mdPath, writeMarkdownReport},
+		{jsonPath, writeJSONReport},
+	} {
+		if out.path == "" {
+			continue
+		}
+		f, err := os.Create(out.path)
+		if err != nil {
+			return fmt.Errorf("failed to create report %s: %w", out.path, err)
+		}
+		if err := out.write(f, c); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to write report %s: %w", out.path, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		log.Printf("wrote report to %s", out.path)
+	}
+	return nil
+}
+
+// writeJSONReport writes the report as JSON, for tvx merge-reports to
+// combine with those of other shards.
+func writeJSONReport(w io.Writer, c *corpusReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// readJSONReport reads a report written by writeJSONReport.
+func readJSONReport(path string) (*corpusReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := new(corpusReport)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to decode report %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func writeMarkdownReport(w io.Writer, c *corpusReport) error {
+	var b strings.Builder
+	t := c.Totals()
+	fmt.Fprintf(&b, "# Test vector report\n\n")
+	if c.Shard != nil {
+		fmt.Fprintf(&b, "Shard %s of the corpus. ", c.Shard)
+	}
+	if c.MessageOverrides != "" {
+		fmt.Fprintf(&b, "**Non-canonical**: message overrides applied (`%s`). ", c.MessageOverrides)
+	}
+	fmt.Fprintf(&b, "Generated at %s. **%d** passed, **%d** failed, **%d** timed out, **%d** cached.", c.Generated.Format(time.RFC3339), t.Passed, t.Failed, t.TimedOut, t.Cached)
+	if t.ExpectedFailures > 0 || t.Skipped > 0 {
+		fmt.Fprintf(&b, " Known broken: **%d** expected failures, **%d** skipped.", t.ExpectedFailures, t.Skipped)
+	}
+	b.WriteString("\n\n")
+
+	if clusters := c.Triage(); len(clusters) > 0 {
+		fmt.Fprintf(&b, "## Triage\n\n")
+		fmt.Fprintf(&b, "%d root causes.\n\n", len(clusters))
+		fmt.Fprintf(&b, "| vectors | signature |\n")
+		fmt.Fprintf(&b, "|---|---|\n")
+		for _, cl := range clusters {
+			fmt.Fprintf(&b, "| %d | `%s` |\n", len(cl.Vectors), cl.Signature)
+		}
+		b.WriteString("\n")
+	}
+
+	for _, g := range c.Groups() {
+		fmt.Fprintf(&b, "## %s\n\n", g.Name)
+		fmt.Fprintf(&b, "%d passed, %d failed, %d timed out, %d cached.", g.Passed, g.Failed, g.TimedOut, g.Cached)
+		if g.ExpectedFailures > 0 || g.Skipped > 0 {
+			fmt.Fprintf(&b, " Known broken: %d expected failures, %d skipped.", g.ExpectedFailures, g.Skipped)
+		}
+		b.WriteString("\n\n")
+		fmt.Fprintf(&b, "| status | vector | class | tags | gas expected | gas actual | gas delta |\n")
+		fmt.Fprintf(&b, "|---|---|---|---|---|---|---|\n")
+		for _, v := range g.Vectors {
+			gasE, gasA, gasD := "n/a", "n/a", "n/a"
+			if v.HasGas {
+				gasE, gasA, gasD = fmt.Sprint(v.GasExpected), fmt.Sprint(v.GasActual), fmt.Sprintf("%+d", v.GasDelta())
+			}
+			fmt.Fprintf(&b, "| %s | `%s` | %s | %s | %s | %s | %s |\n", v.Status, v.Path, v.Class, strings.Join(v.Tags, ", "), gasE, gasA, gasD)
+		}
+		b.WriteString("\n")
+
+		for _, v := range g.Vectors {
+			if len(v.Failures) == 0 && len(v.Diffs) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "<details><summary><code>%s</code></summary>\n\n", v.Path)
+			for _, f := range v.Failures {
+				fmt.Fprintf(&b, "- %s\n", f)
+			}
+			for _, d := range v.Diffs {
+				fmt.Fprintf(&b, "\n```\n%s\n```\n", d)
+			}
+			if v.Stack != "" {
+				fmt.Fprintf(&b, "\n```\n%s\n```\n", v.Stack)
+			}
+			b.WriteString("\n</details>\n\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var htmlReportTemplate = htmltemplate.Must(htmltemplate.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Test vector report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.passed { color: #1a7f37; }
+.failed { color: #cf222e; }
+.timed-out { color: #9a6700; }
+.cached, .skipped { color: #6e7781; }
+.expected-failure { color: #8250df; }
+pre { background: #f6f8fa; padding: 1em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>Test vector report</h1>
+<p>{{with .Shard}}Shard {{.}} of the corpus. {{end}}{{with .MessageOverrides}}<strong>Non-canonical</strong>: message overrides applied (<code>{{.}}</code>). {{end}}Generated at {{.Generated.Format "2006-01-02T15:04:05Z07:00"}}.
+{{with .Totals}}<span class="passed">{{.Passed}} passed</span>, <span class="failed">{{.Failed}} failed</span>, <span class="timed-out">{{.TimedOut}} timed out</span>, <span class="cached">{{.Cached}} cached</span>.{{if or .ExpectedFailures .Skipped}} Known broken: <span class="expected-failure">{{.ExpectedFailures}} expected failures</span>, <span class="skipped">{{.Skipped}} skipped</span>.{{end}}{{end}}</p>
+{{- with .Triage}}
+<h2>Triage</h2>
+<p>{{len .}} root causes.</p>
+<table>
+<tr><th>vectors</th><th>signature</th></tr>
+{{- range .}}
+<tr><td>{{len .Vectors}}</td><td><code>{{.Signature}}</code></td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- range .Groups}}
+<h2>{{.Name}}</h2>
+<p><span class="passed">{{.Passed}} passed</span>, <span class="failed">{{.Failed}} failed</span>, <span class="timed-out">{{.TimedOut}} timed out</span>, <span class="cached">{{.Cached}} cached</span>.{{if or .ExpectedFailures .Skipped}} Known broken: <span class="expected-failure">{{.ExpectedFailures}} expected failures</span>, <span class="skipped">{{.Skipped}} skipped</span>.{{end}}</p>
+<table>
+<tr><th>status</th><th>vector</th><th>class</th><th>tags</th><th>gas expected</th><th>gas actual</th><th>gas delta</th></tr>
+{{- range .Vectors}}
+<tr>
+<td class="{{.Status}}">{{.Status}}</td>
+<td>{{if or .Failures .Diffs}}<a href="#{{.Path}}">{{.Path}}</a>{{else}}{{.Path}}{{end}}</td>
+<td>{{.Class}}</td>
+<td>{{range $i, $t := .Tags}}{{if $i}}, {{end}}{{$t}}{{end}}</td>
+{{- if .HasGas}}
+<td>{{.GasExpected}}</td><td>{{.GasActual}}</td><td>{{.GasDelta}}</td>
+{{- else}}
+<td>n/a</td><td>n/a</td><td>n/a</td>
+{{- end}}
+</tr>
+{{- end}}
+</table>
+{{- range .Vectors}}
+{{- if or .Failures .Diffs}}
+<details id="{{.Path}}"><summary><code>{{.Path}}</code></summary>
+<ul>{{range .Failures}}<li>{{.}}</li>{{end}}</ul>
+{{- range .Diffs}}
+<pre>{{.}}</pre>
+{{- end}}
+{{- with .Stack}}
+<pre>{{.}}</pre>
+{{- end}}
+</details>
+{{- end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+func writeHTMLReport(w io.Writer, c *corpusReport) error {
+	return htmlReportTemplate.Execute(w, c)
+}
@@ -0,0 +1,83 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCorpusReport(t *testing.T) {
+	report := &corpusReport{
+		Generated: time.Unix(0, 0),
+		Vectors: []*vectorReport{
+			{Path: "a.json", Class: "message", Group: "storageminer.5", Status: vectorStatusPassed, HasGas: true, GasExpected: 100, GasActual: 120},
+			{Path: "b.json", Class: "message", Group: "storageminer.5", Status: vectorStatusFailed, Failures: []string{"wrong exit code"}},
+			{Path: "c.json", Class: "tipset", Group: "tipset", Status: vectorStatusCached},
+			{Path: "d.json", Class: "tipset", Group: "tipset", Status: vectorStatusTimedOut, Failures: []string{"execution timed out after 1m0s"}},
+		},
+	}
+
+	groups := report.Groups()
+	if len(groups) != 2 || groups[0].Name != "storageminer.5" || groups[0].Passed != 1 || groups[0].Failed != 1 {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+	if tot := report.Totals(); tot.Passed != 1 || tot.Failed != 1 || tot.Cached != 1 || tot.TimedOut != 1 {
+		t.Fatalf("unexpected totals: %+v", tot)
+	}
+
+	var md bytes.Buffer
+	if err := writeMarkdownReport(&md, report); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"## storageminer.5", "| +20 |", "- wrong exit code", "| timed-out | `d.json` |"} {
+		if !strings.Contains(md.String(), s) {
+			t.Fatalf("markdown report doesn't contain %q:\n%s", s, md.String())
+		}
+	}
+
+	var html bytes.Buffer
+	if err := writeHTMLReport(&html, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), "<li>wrong exit code</li>") {
+		t.Fatalf("html report doesn't contain the failed assertion:\n%s", html.String())
+	}
+
+	// grouped by tag, vectors appear in the groups of all their tags, and
+	// are counted once in the totals.
+	report.GroupBy = reportGroupByTag
+	report.Vectors[0].Tags = []string{"slow", "regression/issue-1234"}
+	report.Vectors[1].Tags = []string{"slow"}
+	var names []string
+	for _, g := range report.Groups() {
+		names = append(names, fmt.Sprintf("%s:%d", g.Name, len(g.Vectors)))
+	}
+	if expected := []string{"regression/issue-1234:1", "slow:2", "untagged:2"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected groups %v, got %v", expected, names)
+	}
+	if tot := report.Totals(); tot.Passed != 1 || tot.Failed != 1 || tot.Cached != 1 || tot.TimedOut != 1 {
+		t.Fatalf("unexpected totals: %+v", tot)
+	}
+}
+
+func TestSandboxed(t *testing.T) {
+	perr := sandboxed(func() { panic("malformed vector") })
+	if perr == nil || !strings.Contains(perr.Error(), "malformed vector") {
+		t.Fatalf("panic not converted into an error: %v", perr)
+	}
+	if !strings.Contains(string(perr.stack), "TestSandboxed") {
+		t.Errorf("stack trace doesn't point at the panic:\n%s", perr.stack)
+	}
+
+	// fatal failures of the reporter are left to recoverFatal.
+	err := recoverFatal(func() {
+		_ = sandboxed(func() { new(reportingReporter).Fatalf("wrong exit code") })
+	})
+	if err == nil || !strings.Contains(err.Error(), "wrong exit code") {
+		t.Errorf("fatal failure not recovered by recoverFatal: %v", err)
+	}
+}
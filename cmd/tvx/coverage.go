@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/go-state-types/manifest"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// Exit code classes of the coverage matrix.
+const (
+	ExitClassOk   = "ok"
+	ExitClassUser = "user"
+	ExitClassSys  = "sys"
+)
+
+var coverageFlags struct {
+	actorsVersion int
+	classes       cli.StringSlice
+	all           bool
+}
+
+var coverageCmd = &cli.Command{
+	Name: "coverage",
+	Description: `report the methods of the built-in actors that aren't exercised by the vectors of a corpus.
+
+   The methods of every built-in actor of the actors version are enumerated,
+   and cross-referenced with the messages of the vectors in the files and
+   directories supplied as arguments (the current directory if none), by the
+   exit code class of their receipts:
+
+     ok     successful execution (exit code 0)
+     user   errors raised by the actor (exit code 16 and above)
+     sys    system errors, e.g. out of gas (exit codes 1 to 15)
+
+   The uncovered (actor, method, exit code class) combinations are printed to
+   stdout; --all prints the covered ones as well, along with the number of
+   vectors covering them. Only messages whose receipts are recorded, i.e. the
+   messages of message-class vectors, are counted.`,
+	ArgsUsage: "[<vector file or dir>...]",
+	Action:    runCoverage,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:        "actors-version",
+			Usage:       "version of the built-in actors whose methods are enumerated",
+			Value:       int(actors.LatestVersion),
+			Destination: &coverageFlags.actorsVersion,
+		},
+		&cli.StringSliceFlag{
+			Name:        "exit-class",
+			Usage:       "exit code class to report coverage of: ok, user or sys; can be repeated",
+			Value:       cli.NewStringSlice(ExitClassOk, ExitClassUser),
+			Destination: &coverageFlags.classes,
+		},
+		&cli.BoolFlag{
+			Name:        "all",
+			Usage:       "print covered combinations as well",
+			Destination: &coverageFlags.all,
+		},
+	},
+}
+
+// exitClass returns the class of the exit code in the coverage matrix.
+func exitClass(code int64) string {
+	switch {
+	case code == int64(exitcode.Ok):
+		return ExitClassOk
+	case code < int64(exitcode.FirstActorErrorCode):
+		return ExitClassSys
+	default:
+		return ExitClassUser
+	}
+}
+
+// coverageKey identifies a cell of the coverage matrix.
+type coverageKey struct {
+	actor  string
+	method abi.MethodNum
+	class  string
+}
+
+// coverageEntry is a cell of the coverage matrix.
+type coverageEntry struct {
+	coverageKey
+	methodName string
+	vectors    int
+}
+
+// coverageMatrix counts the vectors exercising each method of the built-in
+// actors of an actors version, by exit code class.
+type coverageMatrix struct {
+	entries map[coverageKey]*coverageEntry
+}
+
+// newCoverageMatrix enumerates the methods of the built-in actors of the
+// actors version.
+func newCoverageMatrix(av actorstypes.Version, classes []string) (*coverageMatrix, error) {
+	m := &coverageMatrix{entries: make(map[coverageKey]*coverageEntry)}
+	registry := filcns.NewActorRegistry()
+	for _, key := range manifest.GetBuiltinActorsKeys(av) {
+		code, ok := actors.GetActorCodeID(av, key)
+		if !ok {
+			return nil, fmt.Errorf("no code for actor %s of actors version %d", key, av)
+		}
+		for num, meth := range registry.Methods[code] {
+			for _, class := range classes {
+				k := coverageKey{actor: extractor.ActorName(code), method: num, class: class}
+				m.entries[k] = &coverageEntry{coverageKey: k, methodName: meth.Name}
+			}
+		}
+	}
+	if len(m.entries) == 0 {
+		return nil, fmt.Errorf("no methods registered for actors version %d", av)
+	}
+	return m, nil
+}
+
+// record records the messages of a vector, whose receivers and exit codes are
+// known, in the matrix. Messages to actors of other actors versions are
+// ignored.
+func (m *coverageMatrix) record(msgs []searchedMessage) {
+	seen := make(map[coverageKey]struct{})
+	for _, msg := range msgs {
+		if !msg.hasExit {
+			continue
+		}
+		k := coverageKey{actor: msg.actor, method: msg.method, class: exitClass(msg.exitCode)}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		if e, ok := m.entries[k]; ok {
+			e.vectors++
+		}
+	}
+}
+
+// sorted returns the entries of the matrix sorted by actor, method and class.
+func (m *coverageMatrix) sorted() []*coverageEntry {
+	entries := make([]*coverageEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.actor != b.actor {
+			return a.actor < b.actor
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.class < b.class
+	})
+	return entries
+}
+
+func runCoverage(c *cli.Context) error {
+	classes := coverageFlags.classes.Value()
+	for _, class := range classes {
+		switch class {
+		case ExitClassOk, ExitClassUser, ExitClassSys:
+		default:
+			return fmt.Errorf("unknown exit code class %q; expected one of: %s", class, strings.Join([]string{ExitClassOk, ExitClassUser, ExitClassSys}, ", "))
+		}
+	}
+
+	matrix, err := newCoverageMatrix(actorstypes.Version(coverageFlags.actorsVersion), classes)
+	if err != nil {
+		return err
+	}
+
+	var vectors int
+	err = walkVectors(c.Args().Slice(), func(_ string, tv *schema.TestVector) error {
+		vectors++
+		matrix.record(searchedMessages(tv, true))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 4, 2, 2, ' ', 0)
+	var covered int
+	entries := matrix.sorted()
+	for _, e := range entries {
+		if e.vectors > 0 {
+			covered++
+		}
+		switch {
+		case e.vectors == 0:
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\t%s\tuncovered\n", e.actor, e.method, e.methodName, e.class)
+		case coverageFlags.all:
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%d vectors\n", e.actor, e.method, e.methodName, e.class, e.vectors)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	log.Printf("%d vectors cover %d of %d (actor, method, exit code class) combinations of actors v%d (%.1f%%)",
+		vectors, covered, len(entries), coverageFlags.actorsVersion, 100*float64(covered)/float64(len(entries)))
+	return nil
+}
@@ -0,0 +1,30 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+)
+
+func TestCoverageMatrix(t *testing.T) {
+	for code, expected := range map[int64]string{0: ExitClassOk, 7: ExitClassSys, 16: ExitClassUser, 33: ExitClassUser} {
+		if got := exitClass(code); got != expected {
+			t.Errorf("exit code %d: expected class %s, got %s", code, expected, got)
+		}
+	}
+
+	ok := coverageKey{actor: "fil/10/storageminer", method: 5, class: ExitClassOk}
+	user := coverageKey{actor: "fil/10/storageminer", method: 5, class: ExitClassUser}
+	m := &coverageMatrix{entries: map[coverageKey]*coverageEntry{
+		ok:   {coverageKey: ok},
+		user: {coverageKey: user},
+	}}
+	m.record([]searchedMessage{
+		{actor: "fil/10/storageminer", method: 5, exitCode: 0, hasExit: true},
+		{actor: "fil/10/storageminer", method: 5, exitCode: 0, hasExit: true},
+		{actor: "fil/9/storageminer", method: 5, exitCode: 18, hasExit: true},
+		{actor: "fil/10/storageminer", method: 5},
+	})
+	if m.entries[ok].vectors != 1 || m.entries[user].vectors != 0 {
+		t.Fatalf("unexpected coverage: ok=%d user=%d", m.entries[ok].vectors, m.entries[user].vectors)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/bits"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+var dedupeFlags struct {
+	shallow bool
+	remove  bool
+}
+
+var dedupeCmd = &cli.Command{
+	Name: "dedupe",
+	Description: `flag the message vectors of a corpus that are behaviorally redundant, keeping the smallest representative of each behavior.
+
+   The behavioral fingerprint of a message vector is made of, for each of its
+   messages: the type of the receiver actor, the method, the exit code and gas
+   used recorded in its receipt, and the shape of its call tree (the method
+   and exit code of every subcall, by depth). Gas used is bucketed by powers
+   of two, so that vectors whose gas differ slightly share a fingerprint.
+
+   The call trees are obtained by executing the first variant of every vector
+   in the files and directories supplied as arguments (the current directory
+   if none); --shallow skips the execution, and leaves the call trees out of
+   the fingerprints. Vectors failing to execute are kept.
+
+   Vectors sharing a fingerprint are printed to stdout by group, with the
+   smallest vector file of each group kept as its representative. --remove
+   deletes the redundant vector files. Vectors of other classes are ignored.`,
+	ArgsUsage: "[<vector file or dir>...]",
+	Action:    runDedupe,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "shallow",
+			Usage:       "don't execute the vectors, and fingerprint them without their call trees",
+			Destination: &dedupeFlags.shallow,
+		},
+		&cli.BoolFlag{
+			Name:        "remove",
+			Usage:       "delete the redundant vector files",
+			Destination: &dedupeFlags.remove,
+		},
+	},
+}
+
+// tracedCall is a call of the call tree of a message.
+type tracedCall struct {
+	depth    int
+	method   abi.MethodNum
+	exitCode exitcode.ExitCode
+}
+
+// fingerprintedMessage is the behavior of a message of a vector.
+type fingerprintedMessage struct {
+	actor    string
+	method   abi.MethodNum
+	exitCode int64
+	gasUsed  int64
+	// calls is the call tree of the message, in depth-first order, if
+	// executed.
+	calls []tracedCall
+}
+
+// gasBucket returns the power of two bucket of the gas used.
+func gasBucket(gas int64) int {
+	if gas <= 0 {
+		return 0
+	}
+	return bits.Len64(uint64(gas))
+}
+
+func (m *fingerprintedMessage) String() string {
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "%s.%d=%d/gas:%d", m.actor, m.method, m.exitCode, gasBucket(m.gasUsed))
+	if m.calls != nil {
+		sb.WriteString("/calls:")
+		for i, c := range m.calls {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(&sb, "%d.%d=%d", c.depth, c.method, c.exitCode)
+		}
+	}
+	return sb.String()
+}
+
+// behavioralFingerprint returns the fingerprint of the messages of a vector.
+func behavioralFingerprint(msgs []fingerprintedMessage) string {
+	parts := make([]string, len(msgs))
+	for i := range msgs {
+		parts[i] = msgs[i].String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// fingerprintedMessages returns the behavior of the messages of the message
+// vector. The call trees are obtained by executing the vector, unless
+// shallow.
+func fingerprintedMessages(tv *schema.TestVector, shallow bool) ([]fingerprintedMessage, error) {
+	if tv.Post == nil || len(tv.Post.Receipts) != len(tv.ApplyMessages) {
+		return nil, fmt.Errorf("receipts don't match messages")
+	}
+	searched := searchedMessages(tv, true)
+	if len(searched) != len(tv.ApplyMessages) {
+		return nil, fmt.Errorf("failed to decode messages")
+	}
+	msgs := make([]fingerprintedMessage, len(searched))
+	for i, m := range searched {
+		msgs[i] = fingerprintedMessage{
+			actor:    m.actor,
+			method:   m.method,
+			exitCode: tv.Post.Receipts[i].ExitCode,
+			gasUsed:  tv.Post.Receipts[i].GasUsed,
+		}
+	}
+	if shallow {
+		return msgs, nil
+	}
+
+	trees, err := traceCallTrees(tv)
+	if err != nil {
+		return nil, err
+	}
+	if len(trees) != len(msgs) {
+		return nil, fmt.Errorf("executed %d messages, expected %d", len(trees), len(msgs))
+	}
+	for i := range msgs {
+		msgs[i].calls = trees[i]
+	}
+	return msgs, nil
+}
+
+// traceCallTrees executes the first variant of the vector, and returns the
+// call trees of the explicit messages applied.
+func traceCallTrees(tv *schema.TestVector) ([][]tracedCall, error) {
+	if tv.Pre == nil || len(tv.Pre.Variants) == 0 {
+		return nil, fmt.Errorf("no variants")
+	}
+
+	var (
+		trees    [][]tracedCall
+		implicit bool
+	)
+	hooks := &conformance.DriverHooks{
+		OnMessageStart: func(_ *types.Message, imp bool) {
+			implicit = imp
+			if !imp {
+				trees = append(trees, []tracedCall{})
+			}
+		},
+		OnSubcall: func(depth int, trace *types.ExecutionTrace) {
+			if implicit || len(trees) == 0 || trace.Msg == nil || trace.MsgRct == nil {
+				return
+			}
+			last := len(trees) - 1
+			trees[last] = append(trees[last], tracedCall{depth: depth, method: trace.Msg.Method, exitCode: trace.MsgRct.ExitCode})
+		},
+	}
+
+	// silence the execution logs; only the call trees matter.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+
+	v := tv.Pre.Variants[0]
+	r := new(reportingReporter)
+	if err := recoverFatal(func() {
+		_, _, _ = executeVariant(context.Background(), r, tv, &v, conformance.ExecuteOpts{DriverOpts: conformance.DriverOpts{Hooks: hooks}})
+	}); err != nil {
+		return nil, err
+	}
+	return trees, nil
+}
+
+// dedupeEntry is a fingerprinted vector file.
+type dedupeEntry struct {
+	path        string
+	size        int64
+	fingerprint string
+}
+
+// dedupeGroup is a group of vectors sharing a fingerprint.
+type dedupeGroup struct {
+	fingerprint string
+	// kept is the representative of the group.
+	kept      dedupeEntry
+	redundant []dedupeEntry
+}
+
+// dedupeGroups groups the entries by fingerprint, and returns the groups with
+// redundant vectors, sorted by fingerprint. The smallest vector of each group,
+// then the first by path, is its representative.
+func dedupeGroups(entries []dedupeEntry) []dedupeGroup {
+	byFingerprint := make(map[string][]dedupeEntry)
+	for _, e := range entries {
+		byFingerprint[e.fingerprint] = append(byFingerprint[e.fingerprint], e)
+	}
+
+	var groups []dedupeGroup
+	for fp, es := range byFingerprint {
+		if len(es) < 2 {
+			continue
+		}
+		sort.Slice(es, func(i, j int) bool {
+			if es[i].size != es[j].size {
+				return es[i].size < es[j].size
+			}
+			return es[i].path < es[j].path
+		})
+		groups = append(groups, dedupeGroup{fingerprint: fp, kept: es[0], redundant: es[1:]})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].fingerprint < groups[j].fingerprint
+	})
+	return groups
+}
+
+func runDedupe(c *cli.Context) error {
+	var (
+		entries []dedupeEntry
+		ignored int
+	)
+	err := walkVectors(c.Args().Slice(), func(path string, tv *schema.TestVector) error {
+		if tv.Class != schema.ClassMessage {
+			ignored++
+			return nil
+		}
+		var size int64
+		if inVectorArchive(path) {
+			// sized by their JSON encoding, as their compressed size isn't known.
+			b, err := json.Marshal(tv)
+			if err != nil {
+				return err
+			}
+			size = int64(len(b))
+		} else {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			size = fi.Size()
+		}
+		msgs, err := fingerprintedMessages(tv, dedupeFlags.shallow)
+		if err != nil {
+			log.Printf("failed to fingerprint %s; keeping: %s", path, err)
+			return nil
+		}
+		entries = append(entries, dedupeEntry{path: path, size: size, fingerprint: behavioralFingerprint(msgs)})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	groups := dedupeGroups(entries)
+	tw := tabwriter.NewWriter(os.Stdout, 4, 2, 2, ' ', 0)
+	var redundant int
+	for _, g := range groups {
+		_, _ = fmt.Fprintf(tw, "%s\n", g.fingerprint)
+		_, _ = fmt.Fprintf(tw, "  keep\t%s\t%d bytes\t\n", g.kept.path, g.kept.size)
+		for _, e := range g.redundant {
+			_, _ = fmt.Fprintf(tw, "  redundant\t%s\t%d bytes\t\n", e.path, e.size)
+		}
+		redundant += len(g.redundant)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	var removed int
+	if dedupeFlags.remove {
+		for _, g := range groups {
+			for _, e := range g.redundant {
+				if inVectorArchive(e.path) {
+					log.Printf("not removing %s: vectors in archives can't be removed", e.path)
+					continue
+				}
+				if err := os.Remove(e.path); err != nil {
+					return fmt.Errorf("failed to remove %s: %w", e.path, err)
+				}
+				removed++
+			}
+		}
+	}
+
+	log.Printf("%d of %d message vectors are redundant, in %d groups; %d vectors of other classes ignored", redundant, len(entries), len(groups), ignored)
+	if dedupeFlags.remove {
+		log.Printf("removed %d redundant vectors", removed)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+)
+
+func TestBehavioralFingerprint(t *testing.T) {
+	a := fingerprintedMessage{actor: "fil/8/storagemarket", method: 4, gasUsed: 1500, calls: []tracedCall{{depth: 0, method: 4}, {depth: 1, method: 3}}}
+	b := a
+	b.gasUsed = 1900 // same bucket
+	if behavioralFingerprint([]fingerprintedMessage{a}) != behavioralFingerprint([]fingerprintedMessage{b}) {
+		t.Error("expected gas in the same bucket to share a fingerprint")
+	}
+
+	b.gasUsed = 3000
+	if behavioralFingerprint([]fingerprintedMessage{a}) == behavioralFingerprint([]fingerprintedMessage{b}) {
+		t.Error("expected gas in another bucket to differ")
+	}
+
+	c := a
+	c.calls = []tracedCall{{depth: 0, method: 4}, {depth: 1, method: 3, exitCode: 16}}
+	if behavioralFingerprint([]fingerprintedMessage{a}) == behavioralFingerprint([]fingerprintedMessage{c}) {
+		t.Error("expected call trees with other exit codes to differ")
+	}
+
+	if s := behavioralFingerprint([]fingerprintedMessage{a}); s != "fil/8/storagemarket.4=0/gas:11/calls:0.4=0,1.3=0" {
+		t.Errorf("unexpected fingerprint: %s", s)
+	}
+}
+
+func TestDedupeGroups(t *testing.T) {
+	groups := dedupeGroups([]dedupeEntry{
+		{path: "b.json", size: 100, fingerprint: "x"},
+		{path: "a.json", size: 100, fingerprint: "x"},
+		{path: "c.json", size: 50, fingerprint: "x"},
+		{path: "d.json", size: 10, fingerprint: "y"},
+	})
+	if len(groups) != 1 {
+		t.Fatalf("expected 1 group, got %d", len(groups))
+	}
+	g := groups[0]
+	if g.kept.path != "c.json" {
+		t.Errorf("expected the smallest vector to be kept, got %s", g.kept.path)
+	}
+	if len(g.redundant) != 2 || g.redundant[0].path != "a.json" || g.redundant[1].path != "b.json" {
+		t.Errorf("unexpected redundant vectors: %v", g.redundant)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/beacon"
+	"github.com/filecoin-project/lotus/chain/beacon/drand"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/stmgr"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/node/impl/full"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/node/repo"
+	"github.com/filecoin-project/lotus/storage/sealer/ffiwrapper"
+)
+
+// repoDirect is whether FullAPI is backed by the chainstore of the Lotus repo,
+// opened directly, rather than by a JSON-RPC client of a running node.
+var repoDirect bool
+
+var repoDirectFlag = cli.BoolFlag{
+	Name: "repo-direct",
+	Usage: "open the blockstore and chainstore of the Lotus repo supplied with --repo read-only, instead of connecting " +
+		"to a running node; the daemon must not be running, as it locks the repo",
+	Destination: &repoDirect,
+}
+
+// directNode is a full node API backed by the chainstore and state manager of
+// a Lotus repo opened directly, without a running daemon. Only the chain and
+// state methods tvx relies on are supported; the others return
+// api.ErrNotSupported.
+type directNode struct {
+	api.FullNodeStub
+
+	chain *full.ChainAPI
+	state *full.StateAPI
+}
+
+var _ v1api.FullNode = (*directNode)(nil)
+
+// openRepoDirect opens the Lotus repo at the path read-only, and returns a
+// full node API backed by its chainstore, along with the closer releasing the
+// repo.
+//
+// The repo is never written to: the state computed while replaying and
+// calling messages is written to an in-memory blockstore layered over the
+// repo blockstore, and discarded with it when the repo is closed.
+func openRepoDirect(ctx context.Context, path string) (v0api.FullNode, jsonrpc.ClientCloser, error) {
+	r, err := repo.NewFS(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open repo %s: %w", path, err)
+	}
+	if exists, err := r.Exists(); err != nil {
+		return nil, nil, err
+	} else if !exists {
+		return nil, nil, fmt.Errorf("lotus repo %s doesn't exist", path)
+	}
+
+	lr, err := r.LockRO(repo.FullNode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lock repo %s; is the daemon running? %w", path, err)
+	}
+
+	var closers []func()
+	closer := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	closers = append(closers, func() { _ = lr.Close() })
+
+	rbs, err := lr.Blockstore(ctx, repo.UniversalBlockstore)
+	if err != nil {
+		closer()
+		return nil, nil, fmt.Errorf("failed to open blockstore: %w", err)
+	}
+	closers = append(closers, func() {
+		if c, ok := rbs.(io.Closer); ok {
+			_ = c.Close()
+		}
+	})
+
+	mds, err := lr.Datastore(ctx, "/metadata")
+	if err != nil {
+		closer()
+		return nil, nil, fmt.Errorf("failed to open metadata datastore: %w", err)
+	}
+
+	// the repo is opened read-only, so the state computed through it is kept
+	// in memory, layered over the repo blockstore.
+	bs := blockstore.NewTieredBstore(rbs, blockstore.NewMemorySync())
+	cs := store.NewChainStore(bs, bs, mds, filcns.Weight, nil)
+	closers = append(closers, func() { _ = cs.Close() })
+	if err := cs.Load(ctx); err != nil {
+		closer()
+		return nil, nil, fmt.Errorf("failed to load chainstore: %w", err)
+	}
+
+	genesis, err := cs.GetGenesis(ctx)
+	if err != nil {
+		closer()
+		return nil, nil, fmt.Errorf("failed to load genesis: %w", err)
+	}
+	// the drand beacons are only used to map epochs to rounds; their entries
+	// are read from the chain.
+	var schedule beacon.Schedule
+	for _, dc := range build.DrandConfigSchedule() {
+		bc, err := drand.NewDrandBeacon(genesis.Timestamp, build.BlockDelaySecs, nil, dc.Config)
+		if err != nil {
+			closer()
+			return nil, nil, fmt.Errorf("failed to create drand beacon: %w", err)
+		}
+		schedule = append(schedule, beacon.BeaconPoint{Start: dc.Start, Beacon: bc})
+	}
+
+	sm, err := stmgr.NewStateManager(cs, filcns.NewTipSetExecutor(), vm.Syscalls(ffiwrapper.ProofVerifier), filcns.DefaultUpgradeSchedule(), schedule)
+	if err != nil {
+		closer()
+		return nil, nil, err
+	}
+
+	n := &directNode{
+		chain: &full.ChainAPI{
+			ChainModuleAPI:    &full.ChainModule{Chain: cs, ExposedBlockstore: bs},
+			Chain:             cs,
+			ExposedBlockstore: bs,
+		},
+		state: &full.StateAPI{
+			StateModuleAPI: &full.StateModule{StateManager: sm, Chain: cs},
+			StateManager:   sm,
+			Chain:          cs,
+			Beacon:         schedule,
+		},
+	}
+	return &v0api.WrapperV1Full{FullNode: n}, closer, nil
+}
+
+func (n *directNode) Version(context.Context) (api.APIVersion, error) {
+	return api.APIVersion{
+		Version:    build.UserVersion() + " (direct)",
+		APIVersion: api.FullAPIVersion1,
+		BlockDelay: build.BlockDelaySecs,
+	}, nil
+}
+
+func (n *directNode) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return n.chain.ChainHead(ctx)
+}
+
+func (n *directNode) ChainGetTipSet(ctx context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	return n.chain.ChainGetTipSet(ctx, tsk)
+}
+
+func (n *directNode) ChainGetTipSetByHeight(ctx context.Context, h abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
+	return n.chain.ChainGetTipSetByHeight(ctx, h, tsk)
+}
+
+func (n *directNode) ChainGetBlock(ctx context.Context, c cid.Cid) (*types.BlockHeader, error) {
+	return n.chain.ChainGetBlock(ctx, c)
+}
+
+func (n *directNode) ChainGetBlockMessages(ctx context.Context, c cid.Cid) (*api.BlockMessages, error) {
+	return n.chain.ChainGetBlockMessages(ctx, c)
+}
+
+func (n *directNode) ChainGetMessage(ctx context.Context, c cid.Cid) (*types.Message, error) {
+	return n.chain.ChainGetMessage(ctx, c)
+}
+
+func (n *directNode) ChainGetParentMessages(ctx context.Context, c cid.Cid) ([]api.Message, error) {
+	return n.chain.ChainGetParentMessages(ctx, c)
+}
+
+func (n *directNode) ChainGetParentReceipts(ctx context.Context, c cid.Cid) ([]*types.MessageReceipt, error) {
+	return n.chain.ChainGetParentReceipts(ctx, c)
+}
+
+func (n *directNode) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) {
+	return n.chain.ChainReadObj(ctx, c)
+}
+
+func (n *directNode) ChainHasObj(ctx context.Context, c cid.Cid) (bool, error) {
+	return n.chain.ChainHasObj(ctx, c)
+}
+
+func (n *directNode) StateNetworkName(ctx context.Context) (dtypes.NetworkName, error) {
+	return n.state.StateNetworkName(ctx)
+}
+
+func (n *directNode) StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error) {
+	return n.state.StateNetworkVersion(ctx, tsk)
+}
+
+func (n *directNode) StateVMCirculatingSupplyInternal(ctx context.Context, tsk types.TipSetKey) (api.CirculatingSupply, error) {
+	return n.state.StateVMCirculatingSupplyInternal(ctx, tsk)
+}
+
+func (n *directNode) StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	return n.state.StateLookupID(ctx, addr, tsk)
+}
+
+func (n *directNode) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	return n.state.StateGetActor(ctx, addr, tsk)
+}
+
+func (n *directNode) StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error) {
+	return n.state.StateSearchMsg(ctx, from, msg, limit, allowReplaced)
+}
+
+func (n *directNode) StateReplay(ctx context.Context, tsk types.TipSetKey, c cid.Cid) (*api.InvocResult, error) {
+	return n.state.StateReplay(ctx, tsk, c)
+}
+
+func (n *directNode) StateCall(ctx context.Context, msg *types.Message, tsk types.TipSetKey) (*api.InvocResult, error) {
+	return n.state.StateCall(ctx, msg, tsk)
+}
+
+func (n *directNode) StateGetRandomnessFromTickets(ctx context.Context, pers crypto.DomainSeparationTag, epoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error) {
+	return n.state.StateGetRandomnessFromTickets(ctx, pers, epoch, entropy, tsk)
+}
+
+func (n *directNode) StateGetRandomnessFromBeacon(ctx context.Context, pers crypto.DomainSeparationTag, epoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error) {
+	return n.state.StateGetRandomnessFromBeacon(ctx, pers, epoch, entropy, tsk)
+}
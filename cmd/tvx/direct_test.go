@@ -0,0 +1,178 @@
+// stm: #unit
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/node/repo"
+)
+
+// newDirectTestRepo initializes a Lotus repo holding nothing but a genesis
+// block, enough for openRepoDirect to open it, and returns its path and the
+// genesis block.
+func newDirectTestRepo(t *testing.T) (string, *types.BlockHeader) {
+	ctx := context.Background()
+	path := t.TempDir()
+
+	r, err := repo.NewFS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Init(repo.FullNode); err != nil {
+		t.Fatal(err)
+	}
+	lr, err := r.Lock(repo.FullNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close() //nolint:errcheck
+
+	bs, err := lr.Blockstore(ctx, repo.UniversalBlockstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mds, err := lr.Datastore(ctx, "/metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := mock.MkBlock(nil, 1, 1)
+	genesis.Timestamp = 1
+	blk, err := genesis.ToStorageBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	if err := mds.Put(ctx, datastore.NewKey("0"), genesis.Cid().Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return path, genesis
+}
+
+// repoHas returns whether the blockstore of the Lotus repo at the path holds
+// the block.
+func repoHas(t *testing.T, path string, blk blocks.Block) bool {
+	ctx := context.Background()
+	r, err := repo.NewFS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr, err := r.LockRO(repo.FullNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Close() //nolint:errcheck
+
+	bs, err := lr.Blockstore(ctx, repo.UniversalBlockstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	has, err := bs.Has(ctx, blk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return has
+}
+
+func TestRepoDirectLeavesRepoUntouched(t *testing.T) {
+	ctx := context.Background()
+	path, _ := newDirectTestRepo(t)
+
+	full, closer, err := openRepoDirect(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := full.(*v0api.WrapperV1Full).FullNode.(*directNode)
+
+	// the state computed while replaying and calling messages is written to
+	// the exposed blockstore.
+	blk := blocks.NewBlock([]byte("computed state"))
+	if err := n.chain.ExposedBlockstore.Put(ctx, blk); err != nil {
+		t.Fatalf("failed to write through the repo opened directly: %s", err)
+	}
+	if has, err := n.chain.ExposedBlockstore.Has(ctx, blk.Cid()); err != nil {
+		t.Fatal(err)
+	} else if !has {
+		t.Fatal("block written through the repo opened directly isn't readable back")
+	}
+	closer()
+
+	if repoHas(t, path, blk) {
+		t.Fatal("block written through the repo opened directly was written to the repo")
+	}
+}
+
+// datastoreDigest returns the digests of the files of the datastores of the
+// Lotus repo at the path, by path relative to the repo.
+func datastoreDigest(t *testing.T, path string) map[string][sha256.Size]byte {
+	digest := make(map[string][sha256.Size]byte)
+	err := filepath.WalkDir(filepath.Join(path, "datastore"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		digest[rel] = sha256.Sum256(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func TestRepoDirectReadOnly(t *testing.T) {
+	ctx := context.Background()
+	path, genesis := newDirectTestRepo(t)
+	before := datastoreDigest(t, path)
+
+	full, closer, err := openRepoDirect(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := full.ChainGetBlock(ctx, genesis.Cid())
+	if err != nil {
+		closer()
+		t.Fatalf("failed to read the genesis block through the repo opened directly: %s", err)
+	}
+	if blk.Cid() != genesis.Cid() {
+		closer()
+		t.Fatalf("expected genesis block %s; got: %s", genesis.Cid(), blk.Cid())
+	}
+	// the repo is opened read-only; writes must not fail, nor reach it.
+	n := full.(*v0api.WrapperV1Full).FullNode.(*directNode)
+	if err := n.chain.ExposedBlockstore.Put(ctx, blocks.NewBlock([]byte("computed state"))); err != nil {
+		closer()
+		t.Fatalf("failed to write through the repo opened directly: %s", err)
+	}
+	closer()
+
+	after := datastoreDigest(t, path)
+	if len(after) != len(before) {
+		t.Fatalf("expected %d datastore files after opening the repo directly; got: %d", len(before), len(after))
+	}
+	for p, d := range before {
+		if after[p] != d {
+			t.Errorf("datastore file %s was modified by opening the repo directly", p)
+		}
+	}
+}
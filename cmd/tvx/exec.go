@@ -2,25 +2,32 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"strings"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/fatih/color"
+	"github.com/ipfs/go-cid"
 	cbornode "github.com/ipfs/go-ipld-cbor"
 	"github.com/urfave/cli/v2"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/test-vectors/schema"
 
 	"github.com/filecoin-project/lotus/blockstore"
 	"github.com/filecoin-project/lotus/chain/state"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
 	"github.com/filecoin-project/lotus/conformance"
 )
 
@@ -29,6 +36,52 @@ var execFlags struct {
 	out                string
 	driverOpts         cli.StringSlice
 	fallbackBlockstore bool
+	chaosActor         bool
+	cached             bool
+	noCache            bool
+	cacheDir           string
+	reportHTML         string
+	reportMD           string
+	reportGroupBy      string
+	reportJSON         string
+	shard              string
+	gasBaseline        string
+	updateGasBaseline  bool
+	gasBudget          float64
+	gasBudgetWarn      bool
+	tags               cli.StringSlice
+	skipTags           cli.StringSlice
+	spill              bool
+	spillDir           string
+	memoryBudget       string
+	perVectorTimeout   time.Duration
+	maxGas             int64
+	maxSteps           int
+	triage             bool
+	savePostCAR        string
+	diffOnFail         bool
+	verifySignatures   bool
+	network            string
+	lenientRequire     bool
+	fetchProofParams   bool
+	executor           string
+	executorTimeout    time.Duration
+	updateGolden       bool
+	manifest           string
+	stdin              bool
+	sharedBlocks       string
+	stdinFormat        string
+	nonceOffset        int64
+	gasLimit           int64
+	gasFeeCap          string
+	gasPremium         string
+	tui                bool
+	metricsOut         string
+	traceOut           string
+	suppressions       string
+	coverDir           string
+	runsPerVector      int
+	reproDir           string
 }
 
 const (
@@ -37,13 +90,16 @@ const (
 
 var execCmd = &cli.Command{
 	Name:        "exec",
-	Description: "execute one or many test vectors against Lotus; supplied as a single JSON file, a directory, or a ndjson stdin stream",
+	Description: "execute one or many test vectors against Lotus; supplied as a single vector file, a directory or archive of vector files, a manifest listing vector files, or a ndjson stdin stream",
 	Action:      runExec,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&repoFlag,
+		&apiFlag,
+		&rpcQPSFlag,
+		&rpcConcurrencyFlag,
 		&cli.StringFlag{
 			Name:        "file",
-			Usage:       "input file or directory; if not supplied, the vector will be read from stdin",
+			Usage:       "input vector file, or directory or archive of vector files; if not supplied, the vector will be read from stdin",
 			TakesFile:   true,
 			Destination: &execFlags.file,
 		},
@@ -54,18 +110,378 @@ var execCmd = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:        "out",
-			Usage:       "output directory where to save the results, only used when the input is a directory",
+			Usage:       "output directory where to save the results, only used when the input is a directory or archive",
 			Destination: &execFlags.out,
 		},
+		&cli.BoolFlag{
+			Name:        "chaos-actor",
+			Usage:       "register the chaos actor when executing vectors, even if they don't declare it in their selector; use this to run hand-crafted vectors",
+			Destination: &execFlags.chaosActor,
+		},
+		&cli.BoolFlag{
+			Name:        "cached",
+			Usage:       "cache execution results, and skip vectors whose content, actors and driver versions match a cached result; only applies to directory and stdin modes",
+			Destination: &execFlags.cached,
+		},
+		&cli.BoolFlag{
+			Name:        "no-cache",
+			Usage:       "re-execute all vectors regardless of cached results, refreshing the result cache",
+			Destination: &execFlags.noCache,
+		},
+		&cli.StringFlag{
+			Name:        "cache-dir",
+			Usage:       "location of the execution result cache",
+			Value:       DefaultResultCachePath,
+			TakesFile:   true,
+			Destination: &execFlags.cacheDir,
+		},
+		&cli.StringFlag{
+			Name:        "report-html",
+			Usage:       "write an HTML report of the run to the specified file, with per-vector status, gas deltas, failed assertions and state diffs, grouped by actor and method",
+			TakesFile:   true,
+			Destination: &execFlags.reportHTML,
+		},
+		&cli.StringFlag{
+			Name:        "report-md",
+			Usage:       "write a Markdown report of the run to the specified file; see --report-html",
+			TakesFile:   true,
+			Destination: &execFlags.reportMD,
+		},
+		&cli.StringFlag{
+			Name:        "report-json",
+			Usage:       "write a JSON report of the run to the specified file, for tvx merge-reports to combine with those of other shards; see --report-html",
+			TakesFile:   true,
+			Destination: &execFlags.reportJSON,
+		},
+		&cli.StringFlag{
+			Name: "shard",
+			Usage: "execute only the i-th of n shards of the vectors, as i/n (e.g. 2/4), vectors being assigned to shards by a stable hash of their IDs; " +
+				"use this to split a corpus across CI workers, and combine their --report-json reports with tvx merge-reports. Only applies to directory and stdin modes",
+			Destination: &execFlags.shard,
+		},
+		&cli.StringFlag{
+			Name: "gas-baseline",
+			Usage: "JSON file of the gas used by every vector, by ID, as recorded by --update-gas-baseline; the drift of the gas used by the vectors " +
+				"from it is printed as a histogram at the end of the run, and enforced with --gas-budget. Vectors missing from it, and cached ones, aren't checked",
+			TakesFile:   true,
+			Destination: &execFlags.gasBaseline,
+		},
+		&cli.BoolFlag{
+			Name:        "update-gas-baseline",
+			Usage:       "record the gas used by the executed vectors into the --gas-baseline file, creating it if needed, e.g. on releases",
+			Destination: &execFlags.updateGasBaseline,
+		},
+		&cli.Float64Flag{
+			Name: "gas-budget",
+			Usage: "tolerated drift of the gas used by a vector from the --gas-baseline, as a percentage of the baseline; vectors drifting beyond it fail, " +
+				"even if their receipts match. 0 for no limit",
+			Destination: &execFlags.gasBudget,
+		},
+		&cli.BoolFlag{
+			Name:        "gas-budget-warn",
+			Usage:       "warn of the vectors drifting beyond the --gas-budget, rather than failing them",
+			Destination: &execFlags.gasBudgetWarn,
+		},
+		&cli.StringFlag{
+			Name: "report-group-by",
+			Usage: "how to group the vectors of the reports; values: 'method' (the actor and method exercised, or the class of non-message vectors), " +
+				"'tag' (a group per tag, vectors appearing in the groups of all their tags, and untagged ones in their own)",
+			Value:       reportGroupByMethod,
+			Destination: &execFlags.reportGroupBy,
+		},
+		&cli.StringSliceFlag{
+			Name: "tag",
+			Usage: "execute only the vectors carrying the tag, or a tag under it (e.g. 'regression' selects 'regression/issue-1234'); " +
+				"can be repeated, to require all; only applies to directory and stdin modes",
+			Destination: &execFlags.tags,
+		},
+		&cli.StringSliceFlag{
+			Name:        "skip-tag",
+			Usage:       "skip the vectors carrying the tag, or a tag under it; can be repeated; only applies to directory and stdin modes",
+			Destination: &execFlags.skipTags,
+		},
+		&cli.BoolFlag{
+			Name:        "spill",
+			Usage:       "execute tipset, blockseq and migration vectors against a temporary on-disk blockstore, rather than an in-memory one; use this for vectors whose state exceeds the available memory",
+			Destination: &execFlags.spill,
+		},
+		&cli.StringFlag{
+			Name:        "spill-dir",
+			Usage:       "directory to create the temporary blockstores of --spill in; defaults to the directory for temporary files",
+			TakesFile:   true,
+			Destination: &execFlags.spillDir,
+		},
+		&cli.StringFlag{
+			Name:        "memory-budget",
+			Usage:       "approximate memory the temporary blockstores of --spill may use for their caches and memtables, e.g. 512MiB; defaults to that of badger",
+			Destination: &execFlags.memoryBudget,
+		},
 		&cli.StringSliceFlag{
 			Name:        "driver-opt",
 			Usage:       "comma-separated list of driver options (EXPERIMENTAL; will change), supported: 'save-balances=<dst>', 'pipeline-basefee' (unimplemented); only available in single-file mode",
 			Destination: &execFlags.driverOpts,
 		},
-	},
+		&cli.DurationFlag{
+			Name:        "per-vector-timeout",
+			Usage:       "maximum duration of the execution of a vector, e.g. 2m; vectors exceeding it are interrupted before their next message, reported as timed out, and the run proceeds, unless one doesn't stop within 30s, e.g. as a single message loops, which stops the run; 0 for no limit",
+			Destination: &execFlags.perVectorTimeout,
+		},
+		&cli.Int64Flag{
+			Name:        "max-gas",
+			Usage:       "maximum gas limit of the messages of a vector; vectors exceeding it fail without applying the message; 0 for no limit",
+			Destination: &execFlags.maxGas,
+		},
+		&cli.IntFlag{
+			Name:        "max-steps",
+			Usage:       "maximum number of messages, implicit ones included, a vector may apply; vectors exceeding it fail; 0 for no limit",
+			Destination: &execFlags.maxSteps,
+		},
+		&cli.BoolFlag{
+			Name:        "triage",
+			Usage:       "cluster the failed vectors of the run by root cause signature (exit code flip, diverging sub-call, return value or gas delta of the first mismatched message), and print the clusters at the end",
+			Destination: &execFlags.triage,
+		},
+		&cli.StringFlag{
+			Name: "save-post-car",
+			Usage: "directory to write the post-state of every executed variant to, as a CAR named <vector id>.<variant id>.post.car, " +
+				"rooted at the resulting post state root, then the expected one, for inspection with statediff tooling",
+			TakesFile:   true,
+			Destination: &execFlags.savePostCAR,
+		},
+		&cli.BoolFlag{
+			Name: "diff-on-fail",
+			Usage: "on a wrong post state root, print the semantic diff of the expected and actual post states, per actor, and per state field where decodable, " +
+				"instead of the 3-way diffs of the external statediff tool",
+			Destination: &execFlags.diffOnFail,
+		},
+		&cli.BoolFlag{
+			Name: "verify-signatures",
+			Usage: "verify the signatures of the messages of all vectors before applying them, secp256k1 ones individually and BLS ones in aggregate per block; " +
+				"vectors of unsigned messages fail. Vectors of signed messages may require it through their selector regardless",
+			Destination: &execFlags.verifySignatures,
+		},
+		&cli.StringFlag{
+			Name: "network",
+			Usage: "name of the network the runner is configured for, e.g. mainnet or calibrationnet; vectors declaring another network in their selector fail, " +
+				"and those declaring none run regardless",
+			Destination: &execFlags.network,
+		},
+		&cli.BoolFlag{
+			Name: "lenient-requirements",
+			Usage: "execute vectors requiring a later lotus version, or an actors bundle this build doesn't embed, with a warning, rather than failing them; " +
+				"their results may then diverge, e.g. in gas",
+			Destination: &execFlags.lenientRequire,
+		},
+		&cli.BoolFlag{
+			Name: "fetch-proof-params",
+			Usage: "fetch the proof parameters vectors verifying proofs for real require (see the proof_params selector) when missing, " +
+				"into the directory of FIL_PROOFS_PARAMETER_CACHE; vectors whose parameters are missing are skipped otherwise",
+			Destination: &execFlags.fetchProofParams,
+		},
+		&cli.StringFlag{
+			Name: "executor",
+			Usage: "base URL of the execution endpoint of another implementation to dispatch vectors to, rather than executing them in Lotus; " +
+				"its results are asserted against the postconditions of the vectors. The endpoint serves the remote execution protocol: see tvx --help",
+			Destination: &execFlags.executor,
+		},
+		&cli.DurationFlag{
+			Name:        "executor-timeout",
+			Usage:       "timeout of the requests to the executor",
+			Value:       5 * time.Minute,
+			Destination: &execFlags.executorTimeout,
+		},
+		&cli.StringFlag{
+			Name:        "manifest",
+			Usage:       "manifest listing the vector files to execute, as written by tvx sample; executed as a directory is, and mutually exclusive with --file",
+			TakesFile:   true,
+			Destination: &execFlags.manifest,
+		},
+		&cli.BoolFlag{
+			Name: "update-golden",
+			Usage: "rather than asserting the postconditions of the vectors, re-execute them, and rewrite their expected receipts, receipts roots and post state roots in place, " +
+				"recording the rebaseline in their metadata; for intentional behavior changes. Only message and tipset vectors are supported",
+			Destination: &execFlags.updateGolden,
+		},
+		&cli.StringFlag{
+			Name:        "shared-blocks",
+			Usage:       "block store shared by the corpus, as written by tvx car share, to materialize the vectors whose blocks it holds with before executing them",
+			TakesFile:   true,
+			Destination: &execFlags.sharedBlocks,
+		},
+		&cli.BoolFlag{
+			Name: "stdin",
+			Usage: "serve as an execution oracle to another process: read vectors from stdin, and write the result of each, with the receipts and post state roots of its variants, to stdout, " +
+				"in the order they were read; logs go to stderr. See tvx --help",
+			Destination: &execFlags.stdin,
+		},
+		&cli.StringFlag{
+			Name:        "stdin-format",
+			Usage:       "format of the vectors read, and results written, with --stdin: json, one document per line, or cbor, each encoding prefixed by its length as an unsigned varint",
+			Value:       stdioFormatJSON,
+			Destination: &execFlags.stdinFormat,
+		},
+		&cli.StringFlag{
+			Name: "metrics-out",
+			Usage: "CSV file to write the execution metrics of the vectors executed to, a row per vector: messages applied, gas used, wall time, CAR size, " +
+				"actors touched and result; Parquet isn't supported",
+			TakesFile:   true,
+			Destination: &execFlags.metricsOut,
+		},
+		&cli.StringFlag{
+			Name: "trace-out",
+			Usage: "directory to write the execution traces of the vectors executed to, a JSON file per vector: the call tree of every message " +
+				"applied, with its gas charges",
+			TakesFile:   true,
+			Destination: &execFlags.traceOut,
+		},
+		&cli.StringFlag{
+			Name: "cover-dir",
+			Usage: "directory to write the code coverage of the actors exercised by the vectors executed to, as standard cover profiles: one per vector, " +
+				"and corpus.cover for the whole run, for go tool cover; requires tvx to be built with go build -cover -covermode=atomic " +
+				"-coverpkg=github.com/filecoin-project/specs-actors/..., and only covers the Go actors of the legacy VM",
+			TakesFile:   true,
+			Destination: &execFlags.coverDir,
+		},
+		&cli.IntFlag{
+			Name: "runs-per-vector",
+			Usage: "re-execute each vector this many times, against fresh blockstores, failing those whose post state roots, receipts or call trees differ " +
+				"across runs, e.g. through map iteration order in actors",
+			Value:       1,
+			Destination: &execFlags.runsPerVector,
+		},
+		&cli.StringFlag{
+			Name: "repro-dir",
+			Usage: "directory to write a minimal reproduction of every vector failing to, a directory each, holding the vector and a standalone Go test " +
+				"executing it through the conformance driver with the settings of the run, ready to drop into a checkout of lotus or a bug report",
+			TakesFile:   true,
+			Destination: &execFlags.reproDir,
+		},
+		&cli.StringFlag{
+			Name: "suppressions",
+			Usage: "file annotating known-broken vectors by ID or path pattern, a line each, as '<pattern> skip|fail [reason]', besides the annotations the vectors carry " +
+				"through the 'skip' and 'expected' selectors; skipped vectors and expected failures are reported separately, and don't fail the run",
+			TakesFile:   true,
+			Destination: &execFlags.suppressions,
+		},
+		&cli.BoolFlag{
+			Name: "tui",
+			Usage: "display the run in an interactive terminal UI: a live table of the vectors executed, with their statuses, durations and gas deltas, " +
+				"and the failed assertions and state diffs of the vector selected; the logs are displayed below the table",
+			Destination: &execFlags.tui,
+		},
+		&cli.Int64Flag{
+			Name:        "override-nonce-offset",
+			Usage:       "add the offset, possibly negative, to the nonces of the explicit messages applied; results are non-canonical (see tvx --help)",
+			Destination: &execFlags.nonceOffset,
+		},
+		&cli.Int64Flag{
+			Name:        "override-gas-limit",
+			Usage:       "replace the gas limits of the explicit messages applied; results are non-canonical (see tvx --help)",
+			Destination: &execFlags.gasLimit,
+		},
+		&cli.StringFlag{
+			Name:        "override-gas-fee-cap",
+			Usage:       "replace the gas fee caps of the explicit messages applied, in attoFIL; results are non-canonical (see tvx --help)",
+			Destination: &execFlags.gasFeeCap,
+		},
+		&cli.StringFlag{
+			Name:        "override-gas-premium",
+			Usage:       "replace the gas premiums of the explicit messages applied, in attoFIL; results are non-canonical (see tvx --help)",
+			Destination: &execFlags.gasPremium,
+		},
+	}, append(assertCmdFlags, profileCmdFlags...)...),
 }
 
-func runExec(c *cli.Context) error {
+// execVectorOpts are the options tvx exec executes vectors with, set from
+// its flags; execVector sets the hooks of every vector on a copy.
+var execVectorOpts conformance.ExecuteOpts
+
+func runExec(c *cli.Context) (err error) {
+	defer func() { execVectorOpts = conformance.ExecuteOpts{} }()
+	execVectorOpts.Assert = assertFlags
+	execVectorOpts.VerifySignatures = execFlags.verifySignatures
+	execVectorOpts.Network = execFlags.network
+	execVectorOpts.LenientRequirements = execFlags.lenientRequire
+	execVectorOpts.FetchProofParams = execFlags.fetchProofParams
+	if execFlags.maxGas > 0 || execFlags.maxSteps > 0 {
+		execVectorOpts.Limits = &conformance.ExecutionLimits{
+			MaxGasLimit: execFlags.maxGas,
+			MaxSteps:    execFlags.maxSteps,
+		}
+	}
+
+	stopProfiling, err := startProfiling()
+	defer stopProfiling()
+	if err != nil {
+		return err
+	}
+
+	overrides, err := parseMessageOverrides()
+	if err != nil {
+		return err
+	}
+	if !overrides.IsZero() {
+		if execFlags.updateGolden || execFlags.cached || execFlags.noCache || execFlags.updateGasBaseline || execFlags.executor != "" || execFlags.stdin || execFlags.reproDir != "" {
+			return fmt.Errorf("the message overrides are incompatible with --update-golden, the result cache, --update-gas-baseline, --executor, --stdin and --repro-dir, as their results are non-canonical")
+		}
+		log.Println(color.YellowString("WARNING: applying message overrides (%s); the results are NON-CANONICAL, and only tell whether the vectors behave as expected with them", overrides))
+		execVectorOpts.MessageOverrides = overrides
+	}
+
+	if execFlags.updateGolden && (execFlags.executor != "" || execFlags.gasBaseline != "" || execFlags.cached ||
+		execFlags.reportHTML != "" || execFlags.reportMD != "" || execFlags.reportJSON != "" || execFlags.triage) {
+		return fmt.Errorf("--update-golden is incompatible with --executor, --gas-baseline, --cached, the reports and --triage")
+	}
+
+	if execFlags.stdin && (execFlags.file != "" || execFlags.manifest != "" || execFlags.updateGolden || execFlags.executor != "" || execFlags.cached ||
+		execFlags.gasBaseline != "" || execFlags.reportHTML != "" || execFlags.reportMD != "" || execFlags.reportJSON != "" || execFlags.triage ||
+		execFlags.shard != "" || len(execFlags.tags.Value()) > 0 || len(execFlags.skipTags.Value()) > 0 || execFlags.suppressions != "") {
+		// every vector read gets a result: none is filtered out, cached or
+		// reported otherwise.
+		return fmt.Errorf("--stdin is incompatible with --file, --manifest, --update-golden, --executor, --cached, --gas-baseline, the reports, --triage, --shard, the tag filters and --suppressions")
+	}
+
+	if execFlags.tui && (execFlags.stdin || execFlags.updateGolden) {
+		return fmt.Errorf("--tui is incompatible with --stdin and --update-golden")
+	}
+	if execFlags.metricsOut != "" && (execFlags.stdin || execFlags.updateGolden) {
+		return fmt.Errorf("--metrics-out is incompatible with --stdin and --update-golden")
+	}
+	if execFlags.traceOut != "" && (execFlags.stdin || execFlags.updateGolden) {
+		return fmt.Errorf("--trace-out is incompatible with --stdin and --update-golden")
+	}
+	if execFlags.coverDir != "" && (execFlags.stdin || execFlags.updateGolden || execFlags.executor != "") {
+		return fmt.Errorf("--cover-dir is incompatible with --stdin, --update-golden and --executor")
+	}
+	if execFlags.runsPerVector < 1 {
+		return fmt.Errorf("--runs-per-vector must be at least 1")
+	}
+	if execFlags.runsPerVector > 1 && (execFlags.stdin || execFlags.updateGolden || execFlags.executor != "" || execFlags.cached) {
+		// vectors served from the cache aren't executed.
+		return fmt.Errorf("--runs-per-vector is incompatible with --stdin, --update-golden, --executor and --cached")
+	}
+	if execFlags.reproDir != "" && (execFlags.stdin || execFlags.updateGolden || execFlags.executor != "") {
+		return fmt.Errorf("--repro-dir is incompatible with --stdin, --update-golden and --executor")
+	}
+
+	if execFlags.executor != "" {
+		if execFlags.fallbackBlockstore || execFlags.spill || execFlags.savePostCAR != "" || execFlags.diffOnFail || execFlags.traceOut != "" {
+			return fmt.Errorf("--fallback-blockstore, --spill, --save-post-car, --diff-on-fail and --trace-out require local execution, and are incompatible with --executor")
+		}
+		if execExecutor, err = newRemoteExecutor(execFlags.executor, execFlags.executorTimeout); err != nil {
+			return err
+		}
+		defer func() { execExecutor = nil }()
+		log.Printf("executing vectors with %s at %s", execExecutor.info, execExecutor.url)
+	}
+
+	if execFlags.sharedBlocks != "" {
+		if execSharedBlocks, err = openSharedBlocks(execFlags.sharedBlocks, false); err != nil {
+			return err
+		}
+		defer func() { execSharedBlocks = nil }()
+	}
+
 	if execFlags.fallbackBlockstore {
 		if err := initialize(c); err != nil {
 			return fmt.Errorf("fallback blockstore was enabled, but could not resolve lotus API endpoint: %w", err)
@@ -74,6 +490,181 @@ func runExec(c *cli.Context) error {
 		conformance.FallbackBlockstoreGetter = FullAPI
 	}
 
+	if execFlags.spill {
+		opts := &conformance.SpillOpts{Dir: execFlags.spillDir}
+		if execFlags.memoryBudget != "" {
+			if opts.MemoryBudget, err = units.RAMInBytes(execFlags.memoryBudget); err != nil {
+				return fmt.Errorf("invalid --memory-budget: %w", err)
+			}
+		}
+		execVectorOpts.SpillBlockstores = opts
+	} else if execFlags.spillDir != "" || execFlags.memoryBudget != "" {
+		return fmt.Errorf("--spill-dir and --memory-budget require --spill")
+	}
+
+	if execFlags.diffOnFail {
+		execVectorOpts.SemanticStateDiffs = true
+	}
+
+	if dir := execFlags.savePostCAR; dir != "" {
+		if err := ensureDir(dir); err != nil {
+			return err
+		}
+		execVectorOpts.OnPostState = func(tv *schema.TestVector, v *schema.Variant, bs blockstore.Blockstore, root cid.Cid) {
+			if err := savePostStateCAR(dir, tv, v, bs, root); err != nil {
+				log.Printf("failed to save the post-state of vector %s (variant %s): %s", tv.Meta.ID, v.ID, err)
+			}
+		}
+	}
+
+	if execFlags.cached || execFlags.noCache {
+		if execFlags.cached && execFlags.noCache {
+			return fmt.Errorf("--cached and --no-cache are mutually exclusive")
+		}
+		if execCache, err = openResultCache(execFlags.cacheDir, execFlags.noCache); err != nil {
+			return err
+		}
+		defer execCache.Close() //nolint:errcheck
+	}
+
+	switch execFlags.reportGroupBy {
+	case reportGroupByMethod, reportGroupByTag:
+	default:
+		return fmt.Errorf("invalid --report-group-by %q; values: %s, %s", execFlags.reportGroupBy, reportGroupByMethod, reportGroupByTag)
+	}
+	if err := conformance.ValidateTags(append(execFlags.tags.Value(), execFlags.skipTags.Value()...)); err != nil {
+		return err
+	}
+
+	if execFlags.gasBaseline != "" {
+		if execGasBaseline, err = openGasBaseline(execFlags.gasBaseline, execFlags.gasBudget, execFlags.gasBudgetWarn, execFlags.updateGasBaseline); err != nil {
+			return err
+		}
+		defer func() {
+			if gerr := execGasBaseline.finish(os.Stdout); gerr != nil && err == nil {
+				err = gerr
+			}
+			execGasBaseline = nil
+		}()
+	} else if execFlags.updateGasBaseline || execFlags.gasBudget != 0 || execFlags.gasBudgetWarn {
+		return fmt.Errorf("--update-gas-baseline, --gas-budget and --gas-budget-warn require --gas-baseline")
+	}
+
+	if execFlags.metricsOut != "" {
+		if execMetrics, err = openMetricsWriter(execFlags.metricsOut); err != nil {
+			return err
+		}
+		defer func() {
+			if merr := execMetrics.Close(); merr != nil && err == nil {
+				err = merr
+			}
+			execMetrics = nil
+		}()
+	}
+
+	if execFlags.traceOut != "" {
+		if execTraces, err = openTraceWriter(execFlags.traceOut); err != nil {
+			return err
+		}
+		// gas charges are only traced by the legacy VM with detailed tracing.
+		tracing := vm.EnableDetailedTracing
+		vm.EnableDetailedTracing = true
+		defer func() {
+			if terr := execTraces.Close(); terr != nil && err == nil {
+				err = terr
+			}
+			execTraces = nil
+			vm.EnableDetailedTracing = tracing
+		}()
+	}
+
+	if execFlags.coverDir != "" {
+		if execCoverage, err = openCoverageWriter(execFlags.coverDir); err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := execCoverage.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+			execCoverage = nil
+		}()
+	}
+
+	if execFlags.reproDir != "" {
+		if execRepro, err = openReproWriter(execFlags.reproDir); err != nil {
+			return err
+		}
+		defer func() {
+			if rerr := execRepro.Close(); rerr != nil && err == nil {
+				err = rerr
+			}
+			execRepro = nil
+		}()
+	}
+
+	if execFlags.suppressions != "" {
+		if execSuppressions, err = conformance.LoadSuppressions(execFlags.suppressions); err != nil {
+			return err
+		}
+		defer func() { execSuppressions = nil }()
+	}
+
+	if execFlags.shard != "" {
+		if execShard, err = parseShard(execFlags.shard); err != nil {
+			return err
+		}
+		defer func() { execShard = nil }()
+	}
+
+	if execFlags.updateGolden {
+		return updateGolden(execFlags.file)
+	}
+
+	if execFlags.stdin {
+		return execVectorsStdio(os.Stdin, os.Stdout, execFlags.stdinFormat)
+	}
+
+	if execFlags.reportHTML != "" || execFlags.reportMD != "" || execFlags.reportJSON != "" || execFlags.triage || execFlags.tui {
+		execReport = &corpusReport{Generated: time.Now(), GroupBy: execFlags.reportGroupBy, Shard: execShard}
+		if !overrides.IsZero() {
+			execReport.MessageOverrides = overrides.String()
+		}
+		defer func() {
+			if rerr := writeReports(execReport, execFlags.reportHTML, execFlags.reportMD, execFlags.reportJSON); rerr != nil && err == nil {
+				err = rerr
+			}
+			if execFlags.triage {
+				if terr := writeTriage(os.Stdout, execReport); terr != nil && err == nil {
+					err = terr
+				}
+			}
+		}()
+	}
+
+	// the UI is quit before the triage is printed.
+	if execFlags.tui {
+		if execUI, err = startExecTUI(); err != nil {
+			return err
+		}
+		defer func() {
+			execUI.wait()
+			execUI = nil
+		}()
+	}
+
+	if execFlags.manifest != "" {
+		if execFlags.file != "" {
+			return fmt.Errorf("--manifest and --file are mutually exclusive")
+		}
+		if execFlags.out == "" {
+			return fmt.Errorf("no output directory provided")
+		}
+		if err := ensureDir(execFlags.out); err != nil {
+			return err
+		}
+		return execVectorManifest(execFlags.manifest, execFlags.out)
+	}
+
 	path := execFlags.file
 	if path == "" {
 		return execVectorsStdin()
@@ -84,7 +675,7 @@ func runExec(c *cli.Context) error {
 		return err
 	}
 
-	if fi.IsDir() {
+	if fi.IsDir() || isVectorArchive(path) {
 		// we're in directory mode; ensure the out directory exists.
 		outdir := execFlags.out
 		if outdir == "" {
@@ -101,10 +692,39 @@ func runExec(c *cli.Context) error {
 		return err
 	}
 
-	_, err = execVectorFile(new(conformance.LogReporter), path)
+	_, _, err = execVectorFile(path)
 	return err
 }
 
+// parseMessageOverrides parses the message overrides of the flags; nil if
+// none are supplied.
+func parseMessageOverrides() (*conformance.MessageOverrides, error) {
+	o := &conformance.MessageOverrides{
+		NonceOffset: execFlags.nonceOffset,
+		GasLimit:    execFlags.gasLimit,
+	}
+	for _, f := range []struct {
+		name, value string
+		dst         **abi.TokenAmount
+	}{
+		{"--override-gas-fee-cap", execFlags.gasFeeCap, &o.GasFeeCap},
+		{"--override-gas-premium", execFlags.gasPremium, &o.GasPremium},
+	} {
+		if f.value == "" {
+			continue
+		}
+		v, err := types.BigFromString(f.value)
+		if err != nil || v.Sign() < 0 {
+			return nil, fmt.Errorf("invalid %s %q: expected a non-negative amount of attoFIL", f.name, f.value)
+		}
+		*f.dst = &v
+	}
+	if o.IsZero() {
+		return nil, nil
+	}
+	return o, nil
+}
+
 func processTipsetOpts() error {
 	for _, opt := range execFlags.driverOpts.Value() {
 		switch ss := strings.Split(opt, "="); {
@@ -137,15 +757,57 @@ func processTipsetOpts() error {
 }
 
 func execVectorDir(path string, outdir string) error {
-	return filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+	return walkVectorFiles(path, execDirVector(outdir))
+}
+
+// execVectorManifest executes the vectors listed in the manifest, as
+// execVectorDir does those of a directory.
+func execVectorManifest(manifest string, outdir string) error {
+	paths, err := readVectorManifest(manifest)
+	if err != nil {
+		return err
+	}
+	log.Printf("executing the %d vectors listed in manifest %s", len(paths), manifest)
+	for _, p := range paths {
+		if err := walkVectorFiles(p, execDirVector(outdir)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execDirVector returns the function executing the vectors of a directory,
+// sending the output of each to its own file in outdir.
+func execDirVector(outdir string) func(path string, content []byte) error {
+	return func(path string, content []byte) error {
+		var tv schema.TestVector
+		if err := json.Unmarshal(content, &tv); err != nil {
+			log.Printf("failed to decode test vector %s: %s; skipping", path, err)
+			return nil
+		}
+		if !execShard.contains(&tv, path) {
+			return nil
+		}
+		if reason := tagSkipReason(&tv); reason != "" {
+			log.Printf("skipping vector %s: %s", path, reason)
+			return nil
+		}
+		if skipAnnotated(path, tv) {
+			return nil
+		}
+
+		key, cached, err := lookupCachedResult(path, &tv, content)
 		if err != nil {
-			return fmt.Errorf("failed while visiting path %s: %w", path, err)
+			return err
 		}
-		if d.IsDir() || !strings.HasSuffix(path, "json") {
+		if cached != nil {
+			log.Printf("skipping vector %s; cached result: %s", path, cached)
+			reportCached(path, tv, cached)
 			return nil
 		}
+
 		// Create an output file to capture the output from the run of the vector.
-		outfile := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".out"
+		outfile := vectorBaseName(path) + ".out"
 		outpath := filepath.Join(outdir, outfile)
 		outw, err := os.Create(outpath)
 		if err != nil {
@@ -155,22 +817,67 @@ func execVectorDir(path string, outdir string) error {
 		log.Printf("processing vector %s; sending output to %s", path, outpath)
 
 		// Actually run the vector.
-		log.SetOutput(io.MultiWriter(os.Stderr, outw)) // tee the output.
-		_, _ = execVectorFile(new(conformance.LogReporter), path)
-		log.SetOutput(os.Stderr)
+		log.SetOutput(io.MultiWriter(logOutput, outw)) // tee the output.
+		diffs, passed, err := execVector(path, tv)
+		log.SetOutput(logOutput)
 		_ = outw.Close()
 
-		return nil
-	})
+		// timed out vectors are not cached, so that they're retried.
+		var terr *errVectorTimeout
+		if errors.As(err, &terr) {
+			if terr.stuck {
+				return err
+			}
+			return nil
+		}
+		return recordResult(key, passed, diffs)
+	}
 }
 
 func execVectorsStdin() error {
-	r := new(conformance.LogReporter)
 	for dec := json.NewDecoder(os.Stdin); ; {
 		var tv schema.TestVector
 		switch err := dec.Decode(&tv); err {
 		case nil:
-			if _, err = executeTestVector(r, tv); err != nil {
+			if !execShard.contains(&tv, tv.Meta.ID) {
+				continue
+			}
+			if reason := tagSkipReason(&tv); reason != "" {
+				log.Printf("skipping vector %s: %s", tv.Meta.ID, reason)
+				continue
+			}
+			if skipAnnotated(tv.Meta.ID, tv) {
+				continue
+			}
+			content, err := json.Marshal(tv)
+			if err != nil {
+				return err
+			}
+			key, cached, err := lookupCachedResult(tv.Meta.ID, &tv, content)
+			if err != nil {
+				return err
+			}
+			if cached != nil {
+				log.Printf("skipping vector %s; cached result: %s", tv.Meta.ID, cached)
+				reportCached(tv.Meta.ID, tv, cached)
+				continue
+			}
+			diffs, passed, err := execVector(tv.Meta.ID, tv)
+			var (
+				perr *errVectorPanic
+				terr *errVectorTimeout
+			)
+			if errors.As(err, &terr) {
+				if terr.stuck {
+					return err
+				}
+				// not cached, so that it's retried.
+				continue
+			}
+			if err := recordResult(key, passed, diffs); err != nil {
+				return err
+			}
+			if err != nil && !errors.As(err, &perr) {
 				return err
 			}
 		case io.EOF:
@@ -183,30 +890,318 @@ func execVectorsStdin() error {
 	}
 }
 
-func execVectorFile(r conformance.Reporter, path string) (diffs []string, error error) {
-	file, err := os.Open(path)
+// execShard is the shard of the vectors executed in directory and stdin
+// modes, if sharded.
+var execShard *vectorShard
+
+// tagSkipReason returns why the vector is filtered out by --tag and
+// --skip-tag, or "" if it's selected.
+func tagSkipReason(tv *schema.TestVector) string {
+	return conformance.TagFilterReason(tv, execFlags.tags.Value(), execFlags.skipTags.Value())
+}
+
+// execSuppressions are the suppressions of tvx exec, if supplied with
+// --suppressions.
+var execSuppressions *conformance.Suppressions
+
+// skipAnnotated returns whether the vector is annotated to be skipped,
+// through its own annotations or the suppressions, is light, or requires
+// proof parameters that are missing, in which case it's reported as skipped.
+func skipAnnotated(label string, tv schema.TestVector) bool {
+	a := execSuppressions.Annotation(label, &tv)
+	if conformance.IsLightVector(&tv) {
+		a = &conformance.Annotation{Kind: conformance.AnnotationSkip, Reason: conformance.ErrLightVector.Error()}
+	}
+	if err := conformance.EnsureProofParams(context.TODO(), &tv, execVectorOpts.FetchProofParams); errors.Is(err, conformance.ErrProofParamsMissing) {
+		a = &conformance.Annotation{Kind: conformance.AnnotationSkip, Reason: err.Error()}
+	}
+	if a == nil || a.Kind != conformance.AnnotationSkip {
+		return false
+	}
+	log.Printf("skipping vector %s: %s", label, a)
+	reportSkipped(label, tv, a)
+	return true
+}
+
+func execVectorFile(path string) (diffs []string, passed bool, err error) {
+	tv, err := readVector(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open test vector: %w", err)
+		return nil, false, err
+	}
+	if skipAnnotated(path, *tv) {
+		return nil, true, nil
+	}
+	return execVector(path, *tv)
+}
+
+// execVector executes the vector, recording its outcome in the corpus report,
+// if one was requested. It returns whether the vector passed, and any error
+// that prevented its execution.
+func execVector(label string, tv schema.TestVector) (diffs []string, passed bool, err error) {
+	if err := materializeShared(&tv); err != nil {
+		log.Println(color.HiRedString("❌ %s", err))
+		return nil, false, err
+	}
+
+	// collect the gas used by top-level messages, for the report, the gas
+	// baseline and the metrics, the actors touched, for the metrics, and the
+	// traces of the messages, for the trace files.
+	var (
+		gas     []int64
+		touched = make(map[address.Address]struct{})
+		traces  []*messageTrace
+		opts    = execVectorOpts
+	)
+	if execReport != nil || execGasBaseline != nil || execMetrics != nil || execTraces != nil {
+		hooks := &conformance.DriverHooks{
+			OnSubcall: func(depth int, trace *types.ExecutionTrace) {
+				if depth == 0 && trace.MsgRct != nil {
+					gas = append(gas, trace.MsgRct.GasUsed)
+				}
+				if execMetrics != nil && trace.Msg != nil {
+					touched[trace.Msg.From], touched[trace.Msg.To] = struct{}{}, struct{}{}
+				}
+				// the top-level call holds the call tree of the message.
+				if n := len(traces); depth == 0 && n > 0 && traces[n-1].Trace == nil {
+					traces[n-1].Trace = trace
+				}
+			},
+		}
+		if execTraces != nil {
+			hooks.OnMessageStart = func(_ *types.Message, implicit bool) {
+				traces = append(traces, &messageTrace{Implicit: implicit})
+			}
+		}
+		opts.Hooks = hooks
+	}
+
+	if execTraces != nil {
+		defer func() {
+			result := vectorStatusPassed
+			if !passed {
+				result = vectorStatusFailed
+			}
+			msgs := traces
+			// the executions of timed out vectors are interrupted partway,
+			// or stuck still tracing messages.
+			if terr := (*errVectorTimeout)(nil); errors.As(err, &terr) {
+				result, msgs = vectorStatusTimedOut, nil
+			}
+			execTraces.record(label, &tv, result, msgs)
+		}()
+	}
+
+	if execCoverage != nil {
+		defer execCoverage.record(label, &tv)
+	}
+
+	var failures []string
+	if execRepro != nil {
+		defer func() {
+			// known failures are tracked already.
+			if a := execSuppressions.Annotation(label, &tv); passed || (a != nil && a.Kind == conformance.AnnotationExpectedFail) {
+				return
+			}
+			if execReport != nil && len(execReport.Vectors) > 0 {
+				failures = append([]string(nil), execReport.Vectors[len(execReport.Vectors)-1].Failures...)
+			}
+			if err != nil {
+				failures = append(failures, err.Error())
+			}
+			execRepro.record(label, tv, failures)
+		}()
+	}
+
+	if execMetrics != nil {
+		start := time.Now()
+		defer func() {
+			m := &vectorMetrics{
+				path:     label,
+				class:    string(tv.Class),
+				result:   vectorStatusPassed,
+				wallTime: time.Since(start),
+				carBytes: len(tv.CAR),
+			}
+			if tv.Meta != nil {
+				m.id = tv.Meta.ID
+			}
+			if !passed {
+				m.result = vectorStatusFailed
+			}
+			// the executions of timed out vectors are interrupted partway,
+			// or stuck still tracing messages.
+			if terr := (*errVectorTimeout)(nil); errors.As(err, &terr) {
+				m.result = vectorStatusTimedOut
+			} else {
+				m.messages, m.actorsTouched = len(gas), len(touched)
+				for _, g := range gas {
+					m.gasUsed += g
+				}
+			}
+			execMetrics.record(m)
+		}()
+	}
+
+	if execReport != nil {
+		diffs, passed, err = executeAndReport(label, tv, func() []int64 { return gas }, opts)
+	} else {
+		r := new(reportingReporter)
+		diffs, err = executeGuarded(r, tv, opts)
+		// the executions of timed out vectors are interrupted partway, or
+		// stuck still reporting.
+		if terr := (*errVectorTimeout)(nil); !errors.As(err, &terr) {
+			failures = r.failures
+		}
+		var perr *errVectorPanic
+		switch {
+		case errors.As(err, &perr):
+			log.Println(color.HiRedString("❌ %s\n%s", perr, perr.stack))
+			return nil, false, perr
+		case err != nil:
+			log.Println(color.HiRedString("❌ %s", err))
+		}
+		passed = err == nil && !r.Failed()
+		if a := execSuppressions.Annotation(label, &tv); a != nil && a.Kind == conformance.AnnotationExpectedFail {
+			if passed {
+				log.Println(color.HiRedString("❌ vector %s %s, but passed; remove the annotation", label, a))
+			} else {
+				log.Println(color.YellowString("vector %s %s, and failed", label, a))
+			}
+		}
+	}
+
+	// vectors that failed to execute used partial gas.
+	if err == nil {
+		id := label
+		if tv.Meta != nil && tv.Meta.ID != "" {
+			id = tv.Meta.ID
+		}
+		passed = execGasBaseline.observe(id, gas, passed)
+	}
+	if err == nil && execFlags.runsPerVector > 1 {
+		passed = observeDeterminism(label, tv, execFlags.runsPerVector, passed)
+	}
+	if execUI != nil {
+		// once the gas baseline may have failed it.
+		execUI.finishedVector(execReport.Vectors[len(execReport.Vectors)-1])
+	}
+	return diffs, passed, err
+}
+
+// vectorStopGrace is how long a timed out execution is waited for to stop,
+// once interrupted.
+const vectorStopGrace = 30 * time.Second
+
+// executeGuarded executes the vector as executeTestVector does, converting
+// the panics it raises as sandboxed does, and the fatal failures reported
+// through r as recoverFatal does. With --per-vector-timeout, it returns an
+// errVectorTimeout when the execution exceeds the timeout: the execution is
+// interrupted before its next message, and waited for, so that it restores
+// the process-wide settings it altered, e.g. the network and the actors
+// bundle, before the next vector executes. If it doesn't stop within
+// vectorStopGrace, e.g. as a single message loops, the error is marked
+// stuck, and the run must stop.
+func executeGuarded(r *reportingReporter, tv schema.TestVector, opts conformance.ExecuteOpts) (diffs []string, err error) {
+	ctx := context.Background()
+	timeout := execFlags.perVectorTimeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	var tv schema.TestVector
-	if err = json.NewDecoder(file).Decode(&tv); err != nil {
-		return nil, fmt.Errorf("failed to decode test vector: %w", err)
+	type result struct {
+		diffs []string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var res result
+		ferr := recoverFatal(func() {
+			if perr := sandboxed(func() { res.diffs, res.err = executeTestVector(ctx, r, tv, opts) }); perr != nil {
+				res.err = perr
+			}
+		})
+		if ferr != nil {
+			res.err = ferr
+		}
+		done <- res
+	}()
+
+	select {
+	case res := <-done:
+		return res.diffs, res.err
+	case <-ctx.Done():
+	}
+	t := time.NewTimer(vectorStopGrace)
+	defer t.Stop()
+	select {
+	case <-done:
+		return nil, &errVectorTimeout{timeout: timeout}
+	case <-t.C:
+		return nil, &errVectorTimeout{timeout: timeout, stuck: true}
+	}
+}
+
+// errVectorTimeout is the error returned when the execution of a vector
+// exceeds --per-vector-timeout. stuck is set if the execution didn't stop
+// once interrupted: it may then still alter the process-wide settings the
+// following vectors execute with, and the run must stop.
+type errVectorTimeout struct {
+	timeout time.Duration
+	stuck   bool
+}
+
+func (e *errVectorTimeout) Error() string {
+	if e.stuck {
+		return fmt.Sprintf("execution timed out after %s, and didn't stop within %s once interrupted; stopping the run", e.timeout, vectorStopGrace)
 	}
-	return executeTestVector(r, tv)
+	return fmt.Sprintf("execution timed out after %s", e.timeout)
+}
+
+// errVectorPanic is the error the panics raised while executing a vector are
+// converted into, so that a malformed or adversarial vector fails on its
+// own, rather than aborting the whole run.
+type errVectorPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *errVectorPanic) Error() string {
+	return fmt.Sprintf("execution panicked: %v", e.value)
+}
+
+// sandboxed runs f, converting the panics it raises into an errVectorPanic
+// carrying the stack trace. The fatal failures reported through a
+// reportingReporter are panicked again, for recoverFatal to handle.
+func sandboxed(f func()) (err *errVectorPanic) {
+	defer func() {
+		if p := recover(); p != nil {
+			if fatal, ok := p.(errFatalAssertion); ok {
+				panic(fatal)
+			}
+			err = &errVectorPanic{value: p, stack: debug.Stack()}
+		}
+	}()
+	f()
+	return nil
 }
 
-func executeTestVector(r conformance.Reporter, tv schema.TestVector) (diffs []string, err error) {
+func executeTestVector(ctx context.Context, r conformance.Reporter, tv schema.TestVector, opts conformance.ExecuteOpts) (diffs []string, err error) {
 	log.Println("executing test vector:", tv.Meta.ID)
 
+	if execFlags.chaosActor {
+		if tv.Selector == nil {
+			tv.Selector = make(schema.Selector)
+		}
+		tv.Selector[schema.SelectorChaosActor] = "true"
+	}
+
 	for _, v := range tv.Pre.Variants {
-		switch class, v := tv.Class, v; class {
-		case "message":
-			diffs, err = conformance.ExecuteMessageVector(r, &tv, &v)
-		case "tipset":
-			diffs, err = conformance.ExecuteTipsetVector(r, &tv, &v)
-		default:
-			return nil, fmt.Errorf("test vector class %s not supported", class)
+		v := v
+		var supported bool
+		if diffs, supported, err = executeVariant(ctx, r, &tv, &v, opts); !supported {
+			return nil, err
 		}
 
 		if r.Failed() {
@@ -218,3 +1213,51 @@ func executeTestVector(r conformance.Reporter, tv schema.TestVector) (diffs []st
 
 	return diffs, err
 }
+
+// executeVariant executes a variant of the test vector with the runner for
+// its class, or with the remote executor, if any, with the options, until ctx
+// is done. It returns false if the class is not supported.
+func executeVariant(ctx context.Context, r conformance.Reporter, tv *schema.TestVector, v *schema.Variant, opts conformance.ExecuteOpts) (diffs []string, supported bool, err error) {
+	if execExecutor != nil {
+		diffs, err = execExecutor.executeVariant(ctx, r, tv, v, opts)
+	} else {
+		diffs, err = conformance.ExecuteVariant(ctx, r, tv, v, opts)
+	}
+	if errors.Is(err, conformance.ErrUnsupportedClass) {
+		return nil, false, err
+	}
+	return diffs, true, err
+}
+
+// savePostStateCAR writes the post-state of the vector variant to a CAR in
+// dir, rooted at the resulting post state root, then at the expected one if
+// it differs. As the post-state is usually sparse, the blocks missing from
+// the blockstore are skipped.
+func savePostStateCAR(dir string, tv *schema.TestVector, v *schema.Variant, bs blockstore.Blockstore, root cid.Cid) error {
+	roots := []cid.Cid{root}
+	if tv.Post != nil && tv.Post.StateTree != nil {
+		if expected := tv.Post.StateTree.RootCID; expected.Defined() && expected != root {
+			roots = append(roots, expected)
+		}
+	}
+
+	id := "vector"
+	if tv.Meta != nil && tv.Meta.ID != "" {
+		id = tv.Meta.ID
+	}
+	name := strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(fmt.Sprintf("%s.%s.post.car", id, v.ID))
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeSparseCAR(context.Background(), f, bs, roots...); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("saved the post-state of variant %s to %s", v.ID, path)
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// corpusCoverProfile is the name of the cover profile of the whole corpus,
+// in the coverage directory.
+const corpusCoverProfile = "corpus.cover"
+
+// errCoverageUnsupported is returned when the coverage of actors is requested
+// from a tvx binary that doesn't collect it.
+var errCoverageUnsupported = errors.New("tvx doesn't collect coverage; build it with Go 1.20 or later, " +
+	"with go build -cover -covermode=atomic -coverpkg=github.com/filecoin-project/specs-actors/...")
+
+// coverageWriter writes the code coverage of the actors exercised by each
+// vector of a run, as tvx exec --cover-dir: a cover profile per vector, and
+// one of the whole corpus, merging them, in the standard format of go test
+// -coverprofile, for go tool cover to render. Only the actors compiled into
+// tvx with coverage instrumentation (go build -cover -coverpkg) are covered,
+// i.e. the Go actors of the legacy VM, applying messages before nv16; the
+// Wasm actors of the FVM aren't.
+//
+// The counters of tvx are snapshotted and cleared after each vector, and the
+// snapshots are converted into profiles with go tool covdata when the run
+// completes. Vectors whose results are served from the cache aren't
+// executed, and aren't covered; the executions of timed out vectors are
+// covered up to their interruption.
+type coverageWriter struct {
+	dir string
+	// names are the names of the vectors covered, to tell vectors with the
+	// same ID apart, and snapshots their counter snapshots, in order.
+	names     map[string]int
+	snapshots []string
+	err       error
+}
+
+// execCoverage is the coverage writer of tvx exec, if requested with
+// --cover-dir.
+var execCoverage *coverageWriter
+
+// openCoverageWriter creates the coverage directory, if missing, and clears
+// the counters accumulated so far, e.g. by initialization. It fails if tvx
+// isn't instrumented for coverage.
+func openCoverageWriter(dir string) (*coverageWriter, error) {
+	if err := clearCoverageCounters(); err != nil {
+		return nil, err
+	}
+	if err := ensureDir(filepath.Join(dir, "vectors")); err != nil {
+		return nil, err
+	}
+	return &coverageWriter{dir: dir, names: make(map[string]int)}, nil
+}
+
+// record snapshots the counters of the vector executed last, and clears
+// them. Failures to snapshot are returned by Close, so that the run proceeds.
+func (w *coverageWriter) record(path string, tv *schema.TestVector) {
+	if w == nil || w.err != nil {
+		return
+	}
+	snapshot := filepath.Join(w.dir, "vectors", vectorFileName(w.names, path, tv))
+	if err := writeCoverageCounters(snapshot); err != nil {
+		w.err = fmt.Errorf("failed to write coverage of vector %s: %w", path, err)
+		return
+	}
+	w.snapshots = append(w.snapshots, snapshot)
+}
+
+// Close converts the counter snapshots into the cover profiles of the
+// vectors, and merges those into that of the corpus, logging the share of
+// the statements instrumented the corpus covers. It returns the first failure
+// to write the coverage, if any.
+func (w *coverageWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.snapshots) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		log.Printf("go not found; convert the coverage counters in %s into cover profiles with go tool covdata textfmt", filepath.Join(w.dir, "vectors"))
+		return nil
+	}
+
+	corpus := make(coverProfile)
+	for _, snapshot := range w.snapshots {
+		profile := snapshot + ".cover"
+		out, err := exec.Command("go", "tool", "covdata", "textfmt", "-i="+snapshot, "-o="+profile).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to convert coverage counters %s: %w: %s", snapshot, err, out)
+		}
+		p, err := readCoverProfile(profile)
+		if err != nil {
+			return err
+		}
+		corpus.merge(p)
+		// the profile supersedes the snapshot.
+		if err := os.RemoveAll(snapshot); err != nil {
+			return fmt.Errorf("failed to remove coverage counters %s: %w", snapshot, err)
+		}
+	}
+
+	f, err := os.Create(filepath.Join(w.dir, corpusCoverProfile))
+	if err != nil {
+		return fmt.Errorf("failed to write coverage: %w", err)
+	}
+	if err := corpus.write(f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write coverage: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write coverage: %w", err)
+	}
+
+	covered, total := corpus.statements()
+	pct := 0.0
+	if total > 0 {
+		pct = 100 * float64(covered) / float64(total)
+	}
+	log.Printf("the %d vectors executed cover %d of %d actor statements (%.1f%%); cover profiles written to %s",
+		len(w.snapshots), covered, total, pct, w.dir)
+	return nil
+}
+
+// coverProfile is a cover profile, in the text format of go test
+// -coverprofile: the statement counts of blocks, keyed by block, i.e.
+// "file:startLine.startCol,endLine.endCol".
+type coverProfile map[string]*coverBlock
+
+type coverBlock struct {
+	statements int
+	count      int64
+}
+
+// readCoverProfile reads the cover profile at the path.
+func readCoverProfile(path string) (coverProfile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cover profile: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+	p, err := parseCoverProfile(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cover profile %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// parseCoverProfile parses a cover profile. Blocks listed several times, as
+// in the profiles of several packages, are merged.
+func parseCoverProfile(r io.Reader) (coverProfile, error) {
+	p := make(coverProfile)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		// file:startLine.startCol,endLine.endCol statements count
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected <block> <statements> <count>", n)
+		}
+		statements, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid statement count: %w", n, err)
+		}
+		count, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid count: %w", n, err)
+		}
+		p.add(fields[0], &coverBlock{statements: statements, count: count})
+	}
+	return p, sc.Err()
+}
+
+func (p coverProfile) add(block string, b *coverBlock) {
+	if cur, ok := p[block]; ok {
+		cur.count += b.count
+		return
+	}
+	p[block] = &coverBlock{statements: b.statements, count: b.count}
+}
+
+// merge adds the counts of the other profile to those of the profile.
+func (p coverProfile) merge(other coverProfile) {
+	for block, b := range other {
+		p.add(block, b)
+	}
+}
+
+// statements returns the number of statements the profile covers, and
+// instruments.
+func (p coverProfile) statements() (covered, total int) {
+	for _, b := range p {
+		total += b.statements
+		if b.count > 0 {
+			covered += b.statements
+		}
+	}
+	return covered, total
+}
+
+// write writes the profile in atomic mode, the counters of tvx being atomic.
+func (p coverProfile) write(w io.Writer) error {
+	blocks := make([]string, 0, len(p))
+	for block := range p {
+		blocks = append(blocks, block)
+	}
+	sort.Strings(blocks)
+
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintln(bw, "mode: atomic")
+	for _, block := range blocks {
+		_, _ = fmt.Fprintf(bw, "%s %d %d\n", block, p[block].statements, p[block].count)
+	}
+	return bw.Flush()
+}
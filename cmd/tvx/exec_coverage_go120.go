@@ -0,0 +1,33 @@
+//go:build go1.20
+// +build go1.20
+
+package main
+
+import (
+	"fmt"
+	"runtime/coverage"
+)
+
+// clearCoverageCounters clears the coverage counters of tvx. It fails if tvx
+// isn't instrumented for coverage, in atomic mode.
+func clearCoverageCounters() error {
+	if err := coverage.ClearCounters(); err != nil {
+		return fmt.Errorf("%w: %s", errCoverageUnsupported, err)
+	}
+	return nil
+}
+
+// writeCoverageCounters writes the coverage metadata and counters of tvx to
+// the directory, for go tool covdata, and clears the counters.
+func writeCoverageCounters(dir string) error {
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+	if err := coverage.WriteMetaDir(dir); err != nil {
+		return err
+	}
+	if err := coverage.WriteCountersDir(dir); err != nil {
+		return err
+	}
+	return coverage.ClearCounters()
+}
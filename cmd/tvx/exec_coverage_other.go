@@ -0,0 +1,14 @@
+//go:build !go1.20
+// +build !go1.20
+
+package main
+
+// clearCoverageCounters fails, as the coverage of binaries requires Go 1.20.
+func clearCoverageCounters() error {
+	return errCoverageUnsupported
+}
+
+// writeCoverageCounters fails, as the coverage of binaries requires Go 1.20.
+func writeCoverageCounters(string) error {
+	return errCoverageUnsupported
+}
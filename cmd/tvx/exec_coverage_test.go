@@ -0,0 +1,55 @@
+// stm: #unit
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestCoverProfileMerge(t *testing.T) {
+	var none *coverageWriter
+	none.record("a.json", &schema.TestVector{}) // no-op.
+
+	a, err := parseCoverProfile(strings.NewReader(`mode: atomic
+actors/builtin/miner/miner_actor.go:10.2,12.3 2 1
+actors/builtin/miner/miner_actor.go:14.2,15.3 1 0
+actors/builtin/market/market_actor.go:20.2,25.3 4 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := parseCoverProfile(strings.NewReader(`mode: atomic
+actors/builtin/miner/miner_actor.go:10.2,12.3 2 3
+actors/builtin/miner/miner_actor.go:14.2,15.3 1 0
+actors/builtin/market/market_actor.go:20.2,25.3 4 5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if covered, total := a.statements(); covered != 2 || total != 7 {
+		t.Errorf("expected 2 of 7 statements covered; got %d of %d", covered, total)
+	}
+
+	a.merge(b)
+	if covered, total := a.statements(); covered != 6 || total != 7 {
+		t.Errorf("expected 6 of 7 statements covered once merged; got %d of %d", covered, total)
+	}
+	var out strings.Builder
+	if err := a.write(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `mode: atomic
+actors/builtin/market/market_actor.go:20.2,25.3 4 5
+actors/builtin/miner/miner_actor.go:10.2,12.3 2 4
+actors/builtin/miner/miner_actor.go:14.2,15.3 1 0
+`
+	if out.String() != expected {
+		t.Errorf("unexpected merged profile:\n%s", out.String())
+	}
+
+	if _, err := parseCoverProfile(strings.NewReader("mode: atomic\nfile.go:1.1,2.2 x 1\n")); err == nil {
+		t.Error("expected an invalid statement count to fail")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/fatih/color"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// vectorRun is the outcome of an execution of a vector variant, as compared
+// across the runs of tvx exec --runs-per-vector: its postconditions, and the
+// call trees of the messages applied, implicit messages included.
+type vectorRun struct {
+	post  *schema.Postconditions
+	trees []conformance.CallTree
+}
+
+// observeDeterminism executes the vector runs times, as tvx exec
+// --runs-per-vector, and fails it if the outcomes of the runs differ, logging
+// and reporting the differences. It returns whether the vector passed.
+func observeDeterminism(label string, tv schema.TestVector, runs int, passed bool) bool {
+	var (
+		differences []string
+		err         error
+	)
+	if ferr := recoverFatal(func() {
+		if perr := sandboxed(func() { differences, err = checkDeterminism(&tv, runs) }); perr != nil {
+			err = perr
+		}
+	}); ferr != nil {
+		err = ferr
+	}
+
+	var failures []string
+	switch {
+	case err != nil:
+		failures = []string{fmt.Sprintf("failed to repeat the execution: %s", err)}
+	case len(differences) > 0:
+		for _, d := range differences {
+			failures = append(failures, "nondeterminism: "+d)
+		}
+	default:
+		log.Printf("the %d runs of vector %s are identical", runs, label)
+		return passed
+	}
+
+	for _, f := range failures {
+		log.Println(color.HiRedString("❌ %s: %s", label, f))
+	}
+	if execReport != nil && len(execReport.Vectors) > 0 {
+		vr := execReport.Vectors[len(execReport.Vectors)-1]
+		vr.Status, vr.Failures = vectorStatusFailed, append(vr.Failures, failures...)
+		if vr.Signature == "" {
+			vr.Signature = "nondeterminism"
+		}
+	}
+	return false
+}
+
+// checkDeterminism executes the variants of the vector runs times, each
+// against a fresh blockstore, and returns the differences between the
+// outcomes of the runs and those of the first.
+func checkDeterminism(tv *schema.TestVector, runs int) ([]string, error) {
+	var differences []string
+	for i := range tv.Pre.Variants {
+		v := &tv.Pre.Variants[i]
+		first, err := runVariant(tv, v)
+		if err != nil {
+			return nil, fmt.Errorf("variant %s: %w", v.ID, err)
+		}
+		for n := 2; n <= runs; n++ {
+			run, err := runVariant(tv, v)
+			if err != nil {
+				return nil, fmt.Errorf("variant %s, run %d: %w", v.ID, n, err)
+			}
+			for _, d := range runDifferences(first, run) {
+				differences = append(differences, fmt.Sprintf("variant %s, run %d differs from the first: %s", v.ID, n, d))
+			}
+		}
+	}
+	return differences, nil
+}
+
+// runVariant executes the variant against a fresh blockstore, with the
+// options of tvx exec, capturing its outcome. The hooks of the execution of
+// the vector are replaced, so that the metrics, traces and gas of the vector
+// only account for its first execution.
+func runVariant(tv *schema.TestVector, v *schema.Variant) (*vectorRun, error) {
+	run := new(vectorRun)
+	opts := execVectorOpts
+	opts.Hooks = &conformance.DriverHooks{
+		OnSubcall: func(depth int, trace *types.ExecutionTrace) {
+			if depth == 0 {
+				run.trees = append(run.trees, conformance.NormalizeTrace(trace))
+			}
+		},
+	}
+
+	var err error
+	switch tv.Class {
+	case schema.ClassMessage, conformance.ClassConsensusFault:
+		run.post, _, err = conformance.ComputeMessageVectorPostconditions(tv, v, opts)
+	case schema.ClassTipset:
+		run.post, err = conformance.ComputeTipsetVectorPostconditions(tv, v, opts)
+	default:
+		return nil, fmt.Errorf("%w: %s", conformance.ErrUnsupportedClass, tv.Class)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// runDifferences returns the differences between the outcomes of two runs.
+func runDifferences(a, b *vectorRun) []string {
+	var differences []string
+	if ra, rb := a.post.StateTree.RootCID, b.post.StateTree.RootCID; !ra.Equals(rb) {
+		differences = append(differences, fmt.Sprintf("post state root %s, not %s", rb, ra))
+	}
+
+	if len(a.post.Receipts) != len(b.post.Receipts) {
+		differences = append(differences, fmt.Sprintf("%d receipts, not %d", len(b.post.Receipts), len(a.post.Receipts)))
+	} else {
+		for i, ra := range a.post.Receipts {
+			rb := b.post.Receipts[i]
+			if ra.ExitCode != rb.ExitCode || ra.GasUsed != rb.GasUsed || !bytes.Equal(ra.ReturnValue, rb.ReturnValue) {
+				differences = append(differences, fmt.Sprintf("receipt %d: exit code %d, gas used %d and return %x, not %d, %d and %x",
+					i, rb.ExitCode, rb.GasUsed, rb.ReturnValue, ra.ExitCode, ra.GasUsed, ra.ReturnValue))
+			}
+		}
+	}
+
+	if len(a.post.ReceiptsRoots) != len(b.post.ReceiptsRoots) {
+		differences = append(differences, fmt.Sprintf("%d receipts roots, not %d", len(b.post.ReceiptsRoots), len(a.post.ReceiptsRoots)))
+	} else {
+		for i, ra := range a.post.ReceiptsRoots {
+			if rb := b.post.ReceiptsRoots[i]; !ra.Equals(rb) {
+				differences = append(differences, fmt.Sprintf("receipts root %d: %s, not %s", i, rb, ra))
+			}
+		}
+	}
+
+	if len(a.trees) != len(b.trees) {
+		differences = append(differences, fmt.Sprintf("%d messages traced, not %d", len(b.trees), len(a.trees)))
+	} else {
+		for i := range a.trees {
+			if d := conformance.DiffCallTrees(&a.trees[i], &b.trees[i]); d != "" {
+				differences = append(differences, fmt.Sprintf("call tree of message %d: %s", i, d))
+			}
+		}
+	}
+	return differences
+}
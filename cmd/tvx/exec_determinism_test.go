@@ -0,0 +1,53 @@
+// stm: #unit
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestRunDifferences(t *testing.T) {
+	root := func(s string) cid.Cid {
+		c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}.Sum([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	to, _ := address.NewIDAddress(1000)
+	run := func(post string, gas int64) *vectorRun {
+		return &vectorRun{
+			post: &schema.Postconditions{
+				StateTree: &schema.StateTree{RootCID: root(post)},
+				Receipts:  []*schema.Receipt{{ExitCode: 0, GasUsed: gas}},
+			},
+			trees: []conformance.CallTree{{To: to, Subcalls: []conformance.CallTree{{To: to, Method: 2}, {To: to, Method: 3}}}},
+		}
+	}
+	perm := func(r *vectorRun) *vectorRun {
+		subcalls := r.trees[0].Subcalls
+		subcalls[0], subcalls[1] = subcalls[1], subcalls[0]
+		return r
+	}
+
+	if d := runDifferences(run("a", 100), run("a", 100)); len(d) != 0 {
+		t.Fatalf("expected identical runs; got %q", d)
+	}
+	d := runDifferences(run("a", 100), perm(run("b", 200)))
+	if len(d) != 3 {
+		t.Fatalf("expected the post root, receipt and call tree to differ; got %q", d)
+	}
+	for i, want := range []string{"post state root", "receipt 0", "call tree of message 0"} {
+		if !strings.HasPrefix(d[i], want) {
+			t.Errorf("expected difference %d to be about the %s; got %q", i, want, d[i])
+		}
+	}
+}
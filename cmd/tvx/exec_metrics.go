@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// vectorMetricsHeader is the header of the metrics written by tvx exec
+// --metrics-out.
+var vectorMetricsHeader = []string{"path", "id", "class", "result", "messages", "gas_used", "wall_time_ms", "car_bytes", "actors_touched"}
+
+// vectorMetrics are the execution metrics of a vector, as written by tvx exec
+// --metrics-out.
+type vectorMetrics struct {
+	path  string
+	id    string
+	class string
+	// result is passed, failed or timed-out.
+	result string
+	// messages is the number of messages applied, and gasUsed the gas they
+	// used, over all variants; implicit messages included.
+	messages int
+	gasUsed  int64
+	wallTime time.Duration
+	// carBytes is the size of the CAR of the vector, as embedded.
+	carBytes int
+	// actorsTouched is the number of distinct addresses sending or
+	// receiving the calls of the messages, subcalls included.
+	actorsTouched int
+}
+
+func (m *vectorMetrics) row() []string {
+	return []string{
+		m.path,
+		m.id,
+		m.class,
+		m.result,
+		strconv.Itoa(m.messages),
+		strconv.FormatInt(m.gasUsed, 10),
+		strconv.FormatInt(m.wallTime.Milliseconds(), 10),
+		strconv.Itoa(m.carBytes),
+		strconv.Itoa(m.actorsTouched),
+	}
+}
+
+// metricsWriter writes the execution metrics of the vectors of a run to a
+// CSV file, a row per vector, as they're executed, for analysis in notebooks
+// and spreadsheets. Vectors whose results are served from the cache aren't
+// executed, and aren't written.
+type metricsWriter struct {
+	f   *os.File
+	w   *csv.Writer
+	err error
+}
+
+// execMetrics is the metrics writer of tvx exec, if requested with
+// --metrics-out.
+var execMetrics *metricsWriter
+
+// openMetricsWriter creates the metrics file at path, and writes its header.
+func openMetricsWriter(path string) (*metricsWriter, error) {
+	if strings.EqualFold(filepath.Ext(path), ".parquet") {
+		return nil, fmt.Errorf("can't write metrics to %s: Parquet isn't supported; write CSV, and convert it with your tools of choice", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metrics file: %w", err)
+	}
+	m := &metricsWriter{f: f, w: csv.NewWriter(f)}
+	if err := m.w.Write(vectorMetricsHeader); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return m, nil
+}
+
+// record writes the metrics of a vector. Failures to write are returned by
+// Close, so that the run proceeds.
+func (m *metricsWriter) record(vm *vectorMetrics) {
+	if m == nil || m.err != nil {
+		return
+	}
+	if err := m.w.Write(vm.row()); err != nil {
+		m.err = fmt.Errorf("failed to write metrics: %w", err)
+		return
+	}
+	m.w.Flush()
+	if err := m.w.Error(); err != nil {
+		m.err = fmt.Errorf("failed to write metrics: %w", err)
+	}
+}
+
+// Close closes the metrics file, and returns the first failure to write it,
+// if any.
+func (m *metricsWriter) Close() error {
+	m.w.Flush()
+	if err := m.f.Close(); err != nil && m.err == nil {
+		m.err = fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return m.err
+}
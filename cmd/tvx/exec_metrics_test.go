@@ -0,0 +1,55 @@
+// stm: #unit
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := openMetricsWriter(filepath.Join(dir, "metrics.parquet")); err == nil {
+		t.Error("expected Parquet to be refused")
+	}
+
+	path := filepath.Join(dir, "metrics.csv")
+	m, err := openMetricsWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.record(&vectorMetrics{
+		path:          "a.json",
+		id:            "a",
+		class:         "message",
+		result:        vectorStatusPassed,
+		messages:      2,
+		gasUsed:       1234,
+		wallTime:      1500 * time.Millisecond,
+		carBytes:      4096,
+		actorsTouched: 3,
+	})
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		vectorMetricsHeader,
+		{"a.json", "a", "message", "passed", "2", "1234", "1500", "4096", "3"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("unexpected metrics: %v", records)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// reproWriter writes a minimal reproduction of each vector failing in a run,
+// as tvx exec --repro-dir: a directory per vector, holding the vector as
+// executed, and a standalone Go test executing it through the conformance
+// driver, with the settings of the run, e.g. --verify-signatures. The
+// directory is ready to be dropped into a checkout of lotus, or attached to
+// a bug report.
+type reproWriter struct {
+	dir   string
+	names map[string]int
+	err   error
+}
+
+// execRepro is the reproduction writer of tvx exec, if requested with
+// --repro-dir.
+var execRepro *reproWriter
+
+// reproVectorFile is the name of the vector file of a reproduction, embedded
+// by its test.
+const reproVectorFile = "vector.json"
+
+func openReproWriter(dir string) (*reproWriter, error) {
+	if err := ensureDir(dir); err != nil {
+		return nil, err
+	}
+	return &reproWriter{dir: dir, names: make(map[string]int)}, nil
+}
+
+// record writes the reproduction of a failed vector. Failures to write are
+// returned by Close, so that the run proceeds.
+func (w *reproWriter) record(path string, tv schema.TestVector, failures []string) {
+	if w == nil || w.err != nil {
+		return
+	}
+	dir := filepath.Join(w.dir, vectorFileName(w.names, path, &tv))
+	if err := writeRepro(dir, path, tv, failures); err != nil {
+		w.err = fmt.Errorf("failed to write the reproduction of vector %s: %w", path, err)
+	}
+}
+
+// Close returns the first failure to write a reproduction, if any.
+func (w *reproWriter) Close() error {
+	return w.err
+}
+
+// writeRepro writes the reproduction of the vector at path to dir.
+func writeRepro(dir, path string, tv schema.TestVector, failures []string) error {
+	if err := ensureDir(dir); err != nil {
+		return err
+	}
+	// the settings of the run carried by the vector.
+	if execFlags.chaosActor {
+		sel := make(schema.Selector, len(tv.Selector)+1)
+		for k, v := range tv.Selector {
+			sel[k] = v
+		}
+		sel[schema.SelectorChaosActor] = "true"
+		tv.Selector = sel
+	}
+	data, err := json.MarshalIndent(&tv, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, reproVectorFile), data, 0644); err != nil {
+		return err
+	}
+
+	src, err := reproTest(path, &tv, failures)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "repro_test.go"), src, 0644)
+}
+
+// reproTest returns the source of the test reproducing the failures of the
+// vector, with the settings of the run.
+func reproTest(path string, tv *schema.TestVector, failures []string) ([]byte, error) {
+	params := struct {
+		Path, ID, Version, VectorFile string
+		Failures                      []string
+		VerifySignatures              bool
+		Network                       string
+		Limits                        *conformance.ExecutionLimits
+	}{
+		Path:             filepath.ToSlash(path),
+		Version:          build.UserVersion(),
+		VectorFile:       reproVectorFile,
+		VerifySignatures: execVectorOpts.VerifySignatures,
+		Network:          execVectorOpts.Network,
+		Limits:           execVectorOpts.Limits,
+	}
+	if tv.Meta != nil {
+		params.ID = tv.Meta.ID
+	}
+	for _, f := range failures {
+		params.Failures = append(params.Failures, strings.Split(strings.TrimRight(f, "\n"), "\n")...)
+	}
+
+	var buf bytes.Buffer
+	if err := reproTemplate.Execute(&buf, params); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format the reproduction: %w", err)
+	}
+	return src, nil
+}
+
+var reproTemplate = template.Must(template.New("repro").Parse(`// Code generated by tvx exec --repro-dir. DO NOT EDIT.
+
+// Package repro reproduces the failure of test vector {{ printf "%q" .ID }}
+// ({{ .Path }}), as observed with lotus {{ .Version }}:
+//
+{{- range .Failures }}
+//	{{ . }}
+{{- end }}
+//
+// Drop this directory into a checkout of lotus, e.g. as conformance/repro,
+// and run go test ./conformance/repro.
+package repro
+
+import (
+	_ "embed"
+	"encoding/json"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+//go:embed {{ .VectorFile }}
+var vector []byte
+
+func TestRepro(t *testing.T) {
+	var tv schema.TestVector
+	if err := json.Unmarshal(vector, &tv); err != nil {
+		t.Fatal(err)
+	}
+
+	var opts conformance.ExecuteOpts
+{{- if .VerifySignatures }}
+	opts.VerifySignatures = true
+{{- end }}
+{{- if .Network }}
+	opts.Network = {{ printf "%q" .Network }}
+{{- end }}
+{{- with .Limits }}
+	opts.Limits = &conformance.ExecutionLimits{MaxGasLimit: {{ .MaxGasLimit }}, MaxSteps: {{ .MaxSteps }}}
+{{- end }}
+
+	for _, v := range tv.Pre.Variants {
+		v := v
+		t.Run(v.ID, func(t *testing.T) {
+			if _, err := conformance.ExecuteVariant(t, &tv, &v, opts); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+`))
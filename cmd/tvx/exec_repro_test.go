@@ -0,0 +1,65 @@
+// stm: #unit
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestReproWriter(t *testing.T) {
+	var none *reproWriter
+	none.record("a.json", schema.TestVector{}, nil) // no-op.
+
+	execVectorOpts.VerifySignatures = true
+	defer func() { execVectorOpts = conformance.ExecuteOpts{} }()
+
+	w, err := openReproWriter(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv := schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta:  &schema.Metadata{ID: "msg-1"},
+		Pre:   &schema.Preconditions{Variants: []schema.Variant{{ID: "nv10"}}},
+	}
+	w.record("corpus/msg-1.json", tv, []string{"wrong exit code: expected 0, got 16", "state diff:\n  t01000: balance"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(w.dir, "msg-1")
+	data, err := os.ReadFile(filepath.Join(dir, reproVectorFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written schema.TestVector
+	if err := json.Unmarshal(data, &written); err != nil || written.Meta.ID != "msg-1" {
+		t.Fatalf("expected the vector to be written; got %+v, %v", written.Meta, err)
+	}
+
+	src, err := os.ReadFile(filepath.Join(dir, "repro_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`test vector "msg-1"`,
+		"//\twrong exit code: expected 0, got 16\n//\tstate diff:\n//\t  t01000: balance\n",
+		"//go:embed vector.json\n",
+		"opts.VerifySignatures = true",
+		"conformance.ExecuteVariant(t, &tv, &v, opts)",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("expected the reproduction to contain %q:\n%s", want, src)
+		}
+	}
+	if strings.Contains(string(src), "opts.Limits") {
+		t.Errorf("expected no limits to be set:\n%s", src)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// vectorTraces are the execution traces of a vector, as written by tvx exec
+// --trace-out.
+type vectorTraces struct {
+	Path string `json:"path"`
+	ID   string `json:"id,omitempty"`
+	// Result is passed, failed or timed-out.
+	Result string `json:"result"`
+	// Messages are the messages applied, implicit messages included, in the
+	// order applied, over all variants. The messages of timed out vectors
+	// aren't written, as their executions are interrupted partway.
+	Messages []*messageTrace `json:"messages"`
+}
+
+// messageTrace is the execution trace of a message: its call tree, with the
+// gas charges of every call.
+type messageTrace struct {
+	Implicit bool                  `json:"implicit"`
+	Trace    *types.ExecutionTrace `json:"trace"`
+}
+
+// traceWriter writes the execution traces of the vectors of a run to
+// sidecar JSON files in a directory, a file per vector, for failures reported
+// by CI to be debugged offline. Vectors whose results are served from the
+// cache aren't executed, and aren't written.
+type traceWriter struct {
+	dir string
+	// names are the file names written, to tell vectors with the same ID
+	// apart.
+	names map[string]int
+	err   error
+}
+
+// execTraces is the trace writer of tvx exec, if requested with --trace-out.
+var execTraces *traceWriter
+
+// openTraceWriter creates the trace directory, if missing.
+func openTraceWriter(dir string) (*traceWriter, error) {
+	if err := ensureDir(dir); err != nil {
+		return nil, err
+	}
+	return &traceWriter{dir: dir, names: make(map[string]int)}, nil
+}
+
+// fileName returns the name of the trace file of the vector; see
+// vectorFileName.
+func (w *traceWriter) fileName(path string, tv *schema.TestVector) string {
+	return vectorFileName(w.names, path, tv) + ".trace.json"
+}
+
+// vectorFileName returns the name of the files of the vector, after its ID,
+// or the base name of its path if it has none. names counts the names
+// returned, to tell vectors with the same ID apart.
+func vectorFileName(names map[string]int, path string, tv *schema.TestVector) string {
+	name := filepath.Base(path)
+	if tv.Meta != nil && tv.Meta.ID != "" {
+		name = tv.Meta.ID
+	}
+	name = strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(name)
+	names[name]++
+	if n := names[name]; n > 1 {
+		name += "." + strconv.Itoa(n)
+	}
+	return name
+}
+
+// record writes the traces of a vector. Failures to write are returned by
+// Close, so that the run proceeds.
+func (w *traceWriter) record(path string, tv *schema.TestVector, result string, msgs []*messageTrace) {
+	if w == nil || w.err != nil {
+		return
+	}
+	vt := &vectorTraces{Path: path, Result: result, Messages: msgs}
+	if tv.Meta != nil {
+		vt.ID = tv.Meta.ID
+	}
+	b, err := json.MarshalIndent(vt, "", "\t")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(w.dir, w.fileName(path, tv)), b, 0644)
+	}
+	if err != nil {
+		w.err = fmt.Errorf("failed to write traces: %w", err)
+	}
+}
+
+// Close returns the first failure to write the traces, if any.
+func (w *traceWriter) Close() error {
+	return w.err
+}
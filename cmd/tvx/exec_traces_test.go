@@ -0,0 +1,61 @@
+// stm: #unit
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestTraceWriter(t *testing.T) {
+	var none *traceWriter
+	none.record("a.json", &schema.TestVector{}, vectorStatusPassed, nil) // no-op.
+
+	dir := t.TempDir()
+	w, err := openTraceWriter(filepath.Join(dir, "traces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv := &schema.TestVector{Meta: &schema.Metadata{ID: "msg/a"}}
+	msgs := []*messageTrace{
+		{Trace: &types.ExecutionTrace{
+			MsgRct:     &types.MessageReceipt{ExitCode: exitcode.Ok, GasUsed: 100},
+			GasCharges: []*types.GasTrace{{Name: "OnChainMessage", TotalGas: 38}},
+		}},
+		{Implicit: true},
+	}
+	w.record("corpus/a.json", tv, vectorStatusFailed, msgs)
+	w.record("corpus/b.json", tv, vectorStatusPassed, nil)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "traces", "msg_a.trace.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vt vectorTraces
+	if err := json.Unmarshal(b, &vt); err != nil {
+		t.Fatal(err)
+	}
+	if vt.Path != "corpus/a.json" || vt.ID != "msg/a" || vt.Result != vectorStatusFailed || len(vt.Messages) != 2 {
+		t.Fatalf("unexpected traces: %+v", vt)
+	}
+	if tr := vt.Messages[0].Trace; tr == nil || tr.MsgRct.GasUsed != 100 || len(tr.GasCharges) != 1 || tr.GasCharges[0].TotalGas != 38 {
+		t.Errorf("unexpected trace of the first message: %+v", tr)
+	}
+	if !vt.Messages[1].Implicit {
+		t.Error("expected the second message to be implicit")
+	}
+
+	// vectors with the same ID are told apart.
+	if _, err := os.Stat(filepath.Join(dir, "traces", "msg_a.2.trace.json")); err != nil {
+		t.Errorf("expected the traces of the second vector to be written: %s", err)
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// execTUILogLines is the number of log lines the terminal UI keeps.
+const execTUILogLines = 1000
+
+// ansiEscape matches the ANSI escape sequences of colored log lines, which
+// the terminal UI strips.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// execTUI is the interactive terminal UI of tvx exec --tui. It displays a
+// live table of the vectors executed, with their statuses, durations and gas
+// deltas, and the logs of the run below it; the failed assertions, state
+// diffs and stack trace of a vector are displayed by selecting it. It
+// observes the corpus report of the run, and the logs written through it.
+//
+// Quitting the UI while vectors are executing leaves the run to proceed with
+// plain logs; once the run is complete, the UI stays up until quit, for the
+// vectors to be inspected.
+type execTUI struct {
+	screen tcell.Screen
+
+	lk   sync.Mutex
+	rows []execTUIRow
+	// current is the vector being executed, since started.
+	current string
+	started time.Time
+	done    bool
+	logs    []string
+	// partial is the incomplete last line written.
+	partial []byte
+
+	// failedOnly lists the failed and timed out vectors only; sel is the
+	// selected vector among those listed, and top the first displayed.
+	failedOnly bool
+	sel, top   int
+	// detail is the row of the vector displayed, if drilled down, from line
+	// detailTop.
+	detail    int
+	inDetail  bool
+	detailTop int
+
+	// abort is set when the UI is quit with ctrl-c, which aborts the run.
+	abort  bool
+	closed bool
+	// quit is closed when the UI is quit.
+	quit chan struct{}
+}
+
+// execTUIRow is a vector executed, as displayed in the table.
+type execTUIRow struct {
+	vectorReport
+	duration time.Duration
+}
+
+// execUI is the terminal UI of tvx exec, if requested with --tui.
+var execUI *execTUI
+
+func newExecTUI(screen tcell.Screen) *execTUI {
+	return &execTUI{screen: screen, quit: make(chan struct{})}
+}
+
+// startExecTUI takes over the terminal with the UI, and routes the logs of
+// tvx to it.
+func startExecTUI() (*execTUI, error) {
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open terminal UI: %w", err)
+	}
+	if err := screen.Init(); err != nil {
+		return nil, fmt.Errorf("failed to open terminal UI: %w", err)
+	}
+	t := newExecTUI(screen)
+	log.SetOutput(t)
+	go t.loop()
+	go t.tick()
+	return t, nil
+}
+
+// loop handles the events of the screen, redrawing it after each, until the
+// UI is quit.
+func (t *execTUI) loop() {
+	for {
+		ev := t.screen.PollEvent()
+		if ev == nil {
+			return
+		}
+		t.lk.Lock()
+		if t.closed {
+			t.lk.Unlock()
+			return
+		}
+		switch ev := ev.(type) {
+		case *tcell.EventKey:
+			if t.handleKey(ev) {
+				t.close()
+				t.lk.Unlock()
+				if t.abort {
+					os.Exit(130)
+				}
+				return
+			}
+		case *tcell.EventResize:
+			t.screen.Sync()
+		case *tcell.EventInterrupt:
+			// ticks resync the whole screen, so that stray writes to the
+			// terminal, e.g. by Lotus subsystems logging to stderr, are
+			// overdrawn.
+			if ev.Data() != nil {
+				t.screen.Sync()
+			}
+		}
+		t.draw()
+		t.lk.Unlock()
+	}
+}
+
+// tick redraws the screen every second, until the UI is quit.
+func (t *execTUI) tick() {
+	tk := time.NewTicker(time.Second)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			_ = t.screen.PostEvent(tcell.NewEventInterrupt(true))
+		case <-t.quit:
+			return
+		}
+	}
+}
+
+// redraw requests the screen to be redrawn.
+func (t *execTUI) redraw() {
+	_ = t.screen.PostEvent(tcell.NewEventInterrupt(nil))
+}
+
+// close restores the terminal and the logs. It's called with the lock held.
+func (t *execTUI) close() {
+	if t.closed {
+		return
+	}
+	t.closed = true
+	t.screen.Fini()
+	log.SetOutput(logOutput)
+	close(t.quit)
+}
+
+// wait marks the run complete, and blocks until the UI is quit; it returns
+// right away if it was quit already.
+func (t *execTUI) wait() {
+	if t == nil {
+		return
+	}
+	t.lk.Lock()
+	t.done, t.current = true, ""
+	t.lk.Unlock()
+	t.redraw()
+	<-t.quit
+}
+
+// startedVector records that the vector at the path started executing.
+func (t *execTUI) startedVector(path string) {
+	if t == nil {
+		return
+	}
+	t.lk.Lock()
+	t.current, t.started = path, time.Now()
+	t.lk.Unlock()
+	t.redraw()
+}
+
+// finishedVector records the outcome of a vector executed, or cached.
+func (t *execTUI) finishedVector(vr *vectorReport) {
+	if t == nil {
+		return
+	}
+	t.lk.Lock()
+	row := execTUIRow{vectorReport: *vr}
+	if t.current == vr.Path {
+		row.duration, t.current = time.Since(t.started), ""
+	}
+	// the selection follows the vectors executed, unless moved up.
+	follow := t.sel >= len(t.listed())-1
+	t.rows = append(t.rows, row)
+	if follow {
+		t.sel = len(t.listed()) - 1
+		if t.sel < 0 {
+			t.sel = 0
+		}
+	}
+	t.lk.Unlock()
+	t.redraw()
+}
+
+// Write writes log output to the log pane of the UI, or to the logs once
+// it's quit.
+func (t *execTUI) Write(p []byte) (int, error) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	if t.closed {
+		return logOutput.Write(p)
+	}
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.logs = append(t.logs, ansiEscape.ReplaceAllString(string(t.partial[:i]), ""))
+		t.partial = t.partial[i+1:]
+	}
+	if n := len(t.logs); n > execTUILogLines {
+		t.logs = append(t.logs[:0], t.logs[n-execTUILogLines:]...)
+	}
+	t.redraw()
+	return len(p), nil
+}
+
+// listed returns the indexes of the rows listed in the table.
+func (t *execTUI) listed() []int {
+	ret := make([]int, 0, len(t.rows))
+	for i, r := range t.rows {
+		if !t.failedOnly || r.Status == vectorStatusFailed || r.Status == vectorStatusTimedOut {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// handleKey handles a key press, and returns whether the UI is quit.
+func (t *execTUI) handleKey(ev *tcell.EventKey) (quit bool) {
+	_, h := t.screen.Size()
+	page := h / 2
+	var move int
+	switch ev.Key() {
+	case tcell.KeyCtrlC:
+		t.abort = true
+		return true
+	case tcell.KeyUp:
+		move = -1
+	case tcell.KeyDown:
+		move = 1
+	case tcell.KeyPgUp:
+		move = -page
+	case tcell.KeyPgDn:
+		move = page
+	case tcell.KeyHome:
+		move = math.MinInt32
+	case tcell.KeyEnd:
+		move = math.MaxInt32
+	case tcell.KeyEnter, tcell.KeyRight:
+		if rows := t.listed(); !t.inDetail && t.sel < len(rows) {
+			t.detail, t.inDetail, t.detailTop = rows[t.sel], true, 0
+		}
+	case tcell.KeyEscape, tcell.KeyLeft, tcell.KeyBackspace, tcell.KeyBackspace2:
+		t.inDetail = false
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case 'q':
+			if !t.inDetail {
+				return true
+			}
+			t.inDetail = false
+		case 'f':
+			if !t.inDetail {
+				t.failedOnly, t.sel, t.top = !t.failedOnly, 0, 0
+			}
+		case 'k':
+			move = -1
+		case 'j':
+			move = 1
+		}
+	}
+	if t.inDetail {
+		t.detailTop = clampInt(t.detailTop+move, 0, len(t.detailLines(t.rows[t.detail]))-1)
+	} else {
+		t.sel = clampInt(t.sel+move, 0, len(t.listed())-1)
+	}
+	return false
+}
+
+// clampInt clamps v to [lo, hi], and to lo if hi < lo.
+func clampInt(v, lo, hi int) int {
+	if v > hi {
+		v = hi
+	}
+	if v < lo {
+		v = lo
+	}
+	return v
+}
+
+// draw draws the screen. It's called with the lock held.
+func (t *execTUI) draw() {
+	t.screen.Clear()
+	w, h := t.screen.Size()
+	if t.inDetail {
+		t.drawDetail(w, h)
+	} else {
+		t.drawTable(w, h)
+	}
+	t.screen.Show()
+}
+
+// drawTable draws the summary of the run, the table of vectors, and the log
+// pane.
+func (t *execTUI) drawTable(w, h int) {
+	var passed, failed, timedOut, cached int
+	for _, r := range t.rows {
+		switch r.Status {
+		case vectorStatusPassed:
+			passed++
+		case vectorStatusFailed:
+			failed++
+		case vectorStatusTimedOut:
+			timedOut++
+		case vectorStatusCached:
+			cached++
+		}
+	}
+	summary := fmt.Sprintf("tvx exec: %d passed, %d failed, %d timed out, %d cached", passed, failed, timedOut, cached)
+	switch {
+	case t.done:
+		summary += "; run complete"
+	case t.current != "":
+		summary += fmt.Sprintf("; executing %s (%s)", t.current, time.Since(t.started).Truncate(time.Second))
+	}
+	drawText(t.screen, 0, 0, w, tcell.StyleDefault.Bold(true), summary)
+
+	logH := 0
+	if h >= 12 {
+		logH = h / 4
+	}
+	tableH := h - 3
+	if logH > 0 {
+		tableH -= logH + 1
+	}
+	drawText(t.screen, 0, 1, w, tcell.StyleDefault.Underline(true), fmt.Sprintf("%-9s %10s %12s  %s", "STATUS", "DURATION", "GAS DELTA", "VECTOR"))
+
+	rows := t.listed()
+	if t.sel < t.top {
+		t.top = t.sel
+	}
+	if t.sel >= t.top+tableH {
+		t.top = t.sel - tableH + 1
+	}
+	for y, i := 2, t.top; y < 2+tableH && i < len(rows); y, i = y+1, i+1 {
+		r := t.rows[rows[i]]
+		style := statusStyle(r.Status)
+		if i == t.sel {
+			style = style.Reverse(true)
+		}
+		duration, delta := "-", "-"
+		if r.duration > 0 {
+			duration = r.duration.Round(time.Millisecond).String()
+		}
+		if r.HasGas {
+			delta = fmt.Sprintf("%+d", r.GasDelta())
+		}
+		drawText(t.screen, 0, y, w, style, fmt.Sprintf("%-9s %10s %12s  %s", r.Status, duration, delta, rowLabel(&r.vectorReport)))
+	}
+
+	if logH > 0 {
+		y := 2 + tableH
+		drawText(t.screen, 0, y, w, tcell.StyleDefault.Dim(true), strings.Repeat("─", w))
+		logs := t.logs
+		if len(logs) > logH {
+			logs = logs[len(logs)-logH:]
+		}
+		for i, l := range logs {
+			drawText(t.screen, 0, y+1+i, w, tcell.StyleDefault, l)
+		}
+	}
+
+	help := "↑/↓ select  enter details  f failed only  q quit  ctrl-c abort"
+	if t.failedOnly {
+		help = "↑/↓ select  enter details  f all vectors  q quit  ctrl-c abort"
+	}
+	drawText(t.screen, 0, h-1, w, tcell.StyleDefault.Reverse(true), help+strings.Repeat(" ", w))
+}
+
+// drawDetail draws the outcome of the vector drilled down into.
+func (t *execTUI) drawDetail(w, h int) {
+	var lines []string
+	for _, l := range t.detailLines(t.rows[t.detail]) {
+		lines = append(lines, wrapText(l, w)...)
+	}
+	if t.detailTop > len(lines)-1 {
+		t.detailTop = len(lines) - 1
+	}
+	for y, i := 0, t.detailTop; y < h-1 && i < len(lines); y, i = y+1, i+1 {
+		drawText(t.screen, 0, y, w, tcell.StyleDefault, lines[i])
+	}
+	drawText(t.screen, 0, h-1, w, tcell.StyleDefault.Reverse(true), "↑/↓ scroll  esc back"+strings.Repeat(" ", w))
+}
+
+// detailLines returns the lines describing the outcome of the vector.
+func (t *execTUI) detailLines(r execTUIRow) []string {
+	lines := []string{
+		"vector:    " + rowLabel(&r.vectorReport),
+		"path:      " + r.Path,
+		"status:    " + r.Status,
+	}
+	if r.duration > 0 {
+		lines = append(lines, "duration:  "+r.duration.Round(time.Millisecond).String())
+	}
+	if r.HasGas {
+		lines = append(lines, fmt.Sprintf("gas:       expected %d, actual %d (%+d)", r.GasExpected, r.GasActual, r.GasDelta()))
+	}
+	if r.Signature != "" {
+		lines = append(lines, "signature: "+r.Signature)
+	}
+	section := func(title string, content []string) {
+		if len(content) == 0 {
+			return
+		}
+		lines = append(lines, "", title+":")
+		for _, c := range content {
+			for _, l := range strings.Split(strings.TrimRight(c, "\n"), "\n") {
+				lines = append(lines, "  "+ansiEscape.ReplaceAllString(l, ""))
+			}
+		}
+	}
+	section("failures", r.Failures)
+	section("state diffs", r.Diffs)
+	if r.Stack != "" {
+		section("stack", []string{r.Stack})
+	}
+	return lines
+}
+
+// rowLabel returns the label of the vector in the table: its ID, or its
+// path if it has none.
+func rowLabel(vr *vectorReport) string {
+	if vr.ID != "" {
+		return vr.ID
+	}
+	return vr.Path
+}
+
+// statusStyle returns the style of the rows of vectors of the status.
+func statusStyle(status string) tcell.Style {
+	switch status {
+	case vectorStatusPassed:
+		return tcell.StyleDefault.Foreground(tcell.ColorGreen)
+	case vectorStatusFailed:
+		return tcell.StyleDefault.Foreground(tcell.ColorRed)
+	case vectorStatusTimedOut:
+		return tcell.StyleDefault.Foreground(tcell.ColorYellow)
+	default:
+		return tcell.StyleDefault.Foreground(tcell.ColorGray)
+	}
+}
+
+// drawText draws the text at the position, clipped to the width.
+func drawText(s tcell.Screen, x, y, w int, style tcell.Style, text string) {
+	for _, r := range text {
+		rw := runewidth.RuneWidth(r)
+		if rw == 0 {
+			continue
+		}
+		if x+rw > w {
+			return
+		}
+		s.SetContent(x, y, r, nil, style)
+		x += rw
+	}
+}
+
+// wrapText wraps the line to the width.
+func wrapText(line string, w int) []string {
+	if w <= 0 || runewidth.StringWidth(line) <= w {
+		return []string{line}
+	}
+	var (
+		ret   []string
+		cur   strings.Builder
+		width int
+	)
+	for _, r := range line {
+		rw := runewidth.RuneWidth(r)
+		if width+rw > w {
+			ret = append(ret, cur.String())
+			cur.Reset()
+			width = 0
+		}
+		cur.WriteRune(r)
+		width += rw
+	}
+	return append(ret, cur.String())
+}
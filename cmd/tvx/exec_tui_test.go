@@ -0,0 +1,72 @@
+// stm: #unit
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestExecTUI(t *testing.T) {
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer screen.Fini()
+	screen.SetSize(100, 30)
+
+	ui := newExecTUI(screen)
+	ui.startedVector("a.json")
+	ui.finishedVector(&vectorReport{Path: "a.json", ID: "vector-a", Status: vectorStatusPassed, HasGas: true, GasExpected: 100, GasActual: 150})
+	ui.finishedVector(&vectorReport{Path: "b.json", ID: "vector-b", Status: vectorStatusFailed, Failures: []string{"wrong exit code\nexpected 0, got 16"}})
+	_, _ = ui.Write([]byte("\x1b[31mexecuting test vector: vector-c\x1b[0m\npartial"))
+
+	ui.draw()
+	text := screenText(screen)
+	for _, s := range []string{"1 passed, 1 failed", "vector-a", "+50", "vector-b", "executing test vector: vector-c"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("expected the table to display %q:\n%s", s, text)
+		}
+	}
+	if strings.Contains(text, "partial") || strings.Contains(text, "\x1b") {
+		t.Errorf("expected complete log lines only, without escapes:\n%s", text)
+	}
+
+	// the selection follows the last vector; drill down into it.
+	if ui.handleKey(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)) {
+		t.Fatal("unexpected quit")
+	}
+	ui.draw()
+	if text := screenText(screen); !strings.Contains(text, "vector:    vector-b") || !strings.Contains(text, "  expected 0, got 16") {
+		t.Errorf("expected the failures of vector-b:\n%s", text)
+	}
+
+	// back, and list the failed vectors only.
+	ui.handleKey(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone))
+	ui.handleKey(tcell.NewEventKey(tcell.KeyRune, 'f', tcell.ModNone))
+	ui.draw()
+	if text := screenText(screen); strings.Contains(text, "vector-a") || !strings.Contains(text, "vector-b") {
+		t.Errorf("expected failed vectors only:\n%s", text)
+	}
+
+	if !ui.handleKey(tcell.NewEventKey(tcell.KeyRune, 'q', tcell.ModNone)) {
+		t.Error("expected q to quit")
+	}
+}
+
+func screenText(screen tcell.SimulationScreen) string {
+	cells, w, _ := screen.GetContents()
+	var b strings.Builder
+	for i, c := range cells {
+		if i > 0 && i%w == 0 {
+			b.WriteByte('\n')
+		}
+		if len(c.Runes) > 0 {
+			b.WriteRune(c.Runes[0])
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// The exit codes of tvx, telling the causes of failed extractions apart for
+// scripts driving batch runs. Other failures exit with exitFailure.
+const (
+	exitFailure              = 1
+	exitMessageNotFound      = 3
+	exitTipsetUnavailable    = 4
+	exitRetentionUnsupported = 5
+	exitSanityCheckFailed    = 6
+	exitCARTooLarge          = 7
+	exitSelfCheckFailed      = 8
+)
+
+// exitCode returns the exit code of tvx when a command fails with the error.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, extractor.ErrMessageNotFound):
+		return exitMessageNotFound
+	case errors.Is(err, extractor.ErrTipsetUnavailable):
+		return exitTipsetUnavailable
+	case errors.Is(err, extractor.ErrRetentionUnsupported):
+		return exitRetentionUnsupported
+	case errors.Is(err, extractor.ErrSanityCheckFailed):
+		return exitSanityCheckFailed
+	case errors.Is(err, extractor.ErrCARTooLarge):
+		return exitCARTooLarge
+	case errors.Is(err, extractor.ErrSelfCheckFailed):
+		return exitSelfCheckFailed
+	default:
+		return exitFailure
+	}
+}
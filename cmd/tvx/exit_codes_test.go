@@ -0,0 +1,34 @@
+// stm: #unit
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{errors.New("boom"), exitFailure},
+		{fmt.Errorf("failed to resolve message: %w", extractor.ErrMessageNotFound), exitMessageNotFound},
+		{fmt.Errorf("wrapped: %w", extractor.ErrTipsetUnavailable), exitTipsetUnavailable},
+		{extractor.ErrRetentionUnsupported, exitRetentionUnsupported},
+		{fmt.Errorf("wrapped: %w", &extractor.SanityCheckError{Field: "exit_code"}), exitSanityCheckFailed},
+		{extractor.ErrCARTooLarge, exitCARTooLarge},
+		{fmt.Errorf("wrapped: %w", extractor.ErrSelfCheckFailed), exitSelfCheckFailed},
+	} {
+		if code := exitCode(tc.err); code != tc.code {
+			t.Errorf("%v: expected exit code %d, got %d", tc.err, tc.code, code)
+		}
+	}
+
+	var serr *extractor.SanityCheckError
+	if err := fmt.Errorf("wrapped: %w", &extractor.SanityCheckError{Field: "gas_used"}); !errors.As(err, &serr) || serr.Field != "gas_used" {
+		t.Errorf("expected the field of the sanity check error to be recovered; got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// parseExplorerURL extracts the message CID, and the CID of the block that
+// included it, if present, from the URL of a message in a block explorer,
+// such as:
+//
+//	https://filfox.info/en/message/<cid>
+//	https://filscan.io/tipset/message-detail?cid=<cid>
+//	https://beryx.zondax.ch/v1/search/fil/mainnet/transactions/<cid>
+//
+// The message CID is taken from the cid or hash query parameters or, failing
+// that, from the last path segment that parses as a CID. The block CID is
+// taken from the block or blockCid query parameters.
+func parseExplorerURL(raw string) (msg cid.Cid, block cid.Cid, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return cid.Undef, cid.Undef, fmt.Errorf("invalid explorer URL %s: %w", raw, err)
+	}
+
+	q := u.Query()
+	for _, p := range []string{"block", "blockCid"} {
+		if v := q.Get(p); v != "" {
+			if block, err = cid.Decode(v); err != nil {
+				return cid.Undef, cid.Undef, fmt.Errorf("invalid block CID in explorer URL %s: %w", raw, err)
+			}
+			break
+		}
+	}
+
+	for _, p := range []string{"cid", "hash"} {
+		if v := q.Get(p); v != "" {
+			if msg, err = cid.Decode(v); err != nil {
+				return cid.Undef, cid.Undef, fmt.Errorf("invalid message CID in explorer URL %s: %w", raw, err)
+			}
+			return msg, block, nil
+		}
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if c, err := cid.Decode(segments[i]); err == nil {
+			return c, block, nil
+		}
+	}
+	return cid.Undef, cid.Undef, fmt.Errorf("no message CID found in explorer URL %s", raw)
+}
+
+// isURL returns whether the supplied message identifier is a URL.
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
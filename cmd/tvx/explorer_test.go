@@ -0,0 +1,39 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+)
+
+func TestParseExplorerURL(t *testing.T) {
+	const (
+		msg   = "bafy2bzacebpxw3yiaxzy2bako62akig46x3imji7fewszen6fryiz6nymu2b2"
+		block = "bafy2bzacebthpxzlk7zhlkz3jfzl4qw7mdoswcxlf3rkof3b4mbxfj3qzfk7w"
+	)
+
+	for _, tc := range []struct {
+		url       string
+		withBlock bool
+	}{
+		{url: "https://filfox.info/en/message/" + msg},
+		{url: "https://filfox.info/en/message/" + msg + "?t=1"},
+		{url: "https://filscan.io/tipset/message-detail?cid=" + msg},
+		{url: "https://beryx.zondax.ch/v1/search/fil/mainnet/transactions/" + msg},
+		{url: "https://example.com/message/" + msg + "?block=" + block, withBlock: true},
+	} {
+		m, b, err := parseExplorerURL(tc.url)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.url, err)
+		}
+		if m.String() != msg {
+			t.Fatalf("%s: unexpected message CID %s", tc.url, m)
+		}
+		if tc.withBlock != b.Defined() || (tc.withBlock && b.String() != block) {
+			t.Fatalf("%s: unexpected block CID %s", tc.url, b)
+		}
+	}
+
+	if _, _, err := parseExplorerURL("https://filfox.info/en/address/f01234"); err == nil {
+		t.Fatal("expected error for URL without a message CID")
+	}
+}
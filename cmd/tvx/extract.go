@@ -1,21 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/docker/go-units"
+	"github.com/ipfs/go-cid"
 	"github.com/urfave/cli/v2"
 
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/test-vectors/schema"
-)
 
-const (
-	PrecursorSelectAll          = "all"
-	PrecursorSelectParticipants = "participants"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
 )
 
 type extractOpts struct {
@@ -23,15 +29,74 @@ type extractOpts struct {
 	block              string
 	class              string
 	cid                string
+	url                string
 	tsk                string
 	file               string
 	retain             string
+	retainActors       []address.Address
+	profile            string
 	precursor          string
+	implicit           string
+	miner              string
+	epoch              int64
+	to                 string
+	method             int64
+	fromEpoch          int64
+	toEpoch            int64
+	exitCodes          string
+	index              string
+	progressJSON       string
+	signKey            string
+	signWallet         string
+	selectors          []string
+	hints              []string
+	tags               []string
+	mockSyscalls       string
+	recordSyscalls     bool
+	recordTraces       bool
+	recordTime         bool
 	ignoreSanityChecks bool
+	force              bool
 	squash             bool
+	stateCompute       bool
+	embedPrecursors    bool
+	ignorePrecursors   bool
+	escalatePrecursors bool
+	warmup             bool
+	preRoot            string
+	fromMpool          bool
+	msgFile            string
+	light              bool
+	maxPrecursors      int
+	precursorLookback  int
+	msigLookback       int64
+	paych              string
+	paychLookback      int64
+	serverSide         bool
+	circSupply         string
+	dryRun             bool
+	preflight          string
+	confidence         int64
+	height             int64
+	epochsAgo          int64
+	msgIndex           int
+	maxCARSize         int64
+	// message is the message read from msgFile, if any.
+	message *types.Message
+	// onEvent, if not nil, receives the progress events of the extraction,
+	// besides the progress event stream.
+	onEvent func(event string, kvs ...interface{})
 }
 
-var extractFlags extractOpts
+var (
+	extractFlags        extractOpts
+	extractSelectors    cli.StringSlice
+	extractHints        cli.StringSlice
+	extractTags         cli.StringSlice
+	extractRetainActors cli.StringSlice
+	extractMaxCARSize   string
+	extractPostProcess  cli.StringSlice
+)
 
 var extractCmd = &cli.Command{
 	Name:        "extract",
@@ -39,18 +104,27 @@ var extractCmd = &cli.Command{
 	Action:      runExtract,
 	Before:      initialize,
 	After:       destroy,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&repoFlag,
+		&apiFlag,
+		&rpcQPSFlag,
+		&rpcConcurrencyFlag,
+		&repoDirectFlag,
+		&prefetchFlag,
+		&selfCheckFlag,
 		&cli.StringFlag{
-			Name:        "class",
-			Usage:       "class of vector to extract; values: 'message', 'tipset'",
+			Name: "class",
+			Usage: "class of vector to extract; values: 'message', 'tipset', 'blockseq', 'implicit', 'migration', 'msig-flow', 'paych-flow', 'consensus-fault'; " +
+				"'msig-flow' extracts a message vector applying the multisig proposal approved by the message supplied with --cid, then the approval; " +
+				"'paych-flow' extracts a message vector applying the messages sent to the payment channel supplied with --paych; " +
+				"'consensus-fault' extracts the ReportConsensusFault message supplied with --cid or --msg-file, with the block headers it reports, asserting the slashing of the miner",
 			Value:       "message",
 			Destination: &extractFlags.class,
 		},
 		&cli.StringFlag{
-			Name:        "id",
-			Usage:       "identifier to name this test vector with",
-			Value:       "(undefined)",
+			Name: "id",
+			Usage: "identifier to name this test vector with; if omitted, an identifier is generated from the receiver's actor code, " +
+				"method, exit code and message CID, e.g. fil_8_storageminer-SubmitWindowedPoSt-ok-5xkfnbq2",
 			Destination: &extractFlags.id,
 		},
 		&cli.StringFlag{
@@ -65,18 +139,42 @@ var extractCmd = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:        "cid",
-			Usage:       "message CID to generate test vector from",
+			Usage:       "message CID to generate test vector from, or the URL of the message in a block explorer (filfox, filscan, beryx)",
 			Destination: &extractFlags.cid,
 		},
+		&cli.StringFlag{
+			Name:        "url",
+			Usage:       "URL of the message to generate test vector from in a block explorer (filfox, filscan, beryx); the block is also taken from the URL, if present",
+			Destination: &extractFlags.url,
+		},
 		&cli.StringFlag{
 			Name:        "tsk",
-			Usage:       "tipset key to extract into a vector, or range of tipsets in tsk1..tsk2 form",
+			Usage:       "tipset key to extract into a vector, or range of tipsets in tsk1..tsk2 form; for implicit messages, the tipset whose execution emits them",
 			Destination: &extractFlags.tsk,
 		},
+		&cli.Int64Flag{
+			Name: "height",
+			Usage: "height of the tipset to extract, or for 'message' vectors, of the tipset including the message selected with --msg-index, " +
+				"instead of --tsk or --cid; if a null round, the preceding tipset is selected",
+			Destination: &extractFlags.height,
+		},
+		&cli.Int64Flag{
+			Name:        "epochs-ago",
+			Usage:       "like --height, relative to the head of the node, e.g. 2880 for a day ago, for scripted harvests",
+			Destination: &extractFlags.epochsAgo,
+		},
+		&cli.IntFlag{
+			Name:        "msg-index",
+			Usage:       "with --height or --epochs-ago, the position of the message to extract among the messages of the tipset, in execution order, from 0",
+			Value:       -1,
+			Destination: &extractFlags.msgIndex,
+		},
 		&cli.StringFlag{
-			Name:        "out",
-			Aliases:     []string{"o"},
-			Usage:       "file to write test vector to, or directory to write the batch to",
+			Name:    "out",
+			Aliases: []string{"o"},
+			Usage: "file to write test vector to, or directory to write the batch to; if empty or '-', the vector is written to stdout, and batches are streamed as a vector per line, for tvx exec to consume from a pipe. " +
+				"Vectors can be published to shared storage instead, with ipfs://[host:port] (local IPFS node), s3://bucket/prefix/ (AWS_* credentials), " +
+				"or w3s:// (web3.storage, WEB3_STORAGE_TOKEN); the resulting CID or URL is printed to stdout",
 			Destination: &extractFlags.file,
 		},
 		&cli.StringFlag{
@@ -85,47 +183,593 @@ var extractCmd = &cli.Command{
 			Value:       "accessed-cids",
 			Destination: &extractFlags.retain,
 		},
+		&cli.StringSliceFlag{
+			Name: "retain-actor",
+			Usage: "when extracting 'message' vectors, address of an actor whose entry and state head to retain in the pre-state besides those " +
+				"the message accesses; can be repeated",
+			Destination: &extractRetainActors,
+		},
+		&cli.StringFlag{
+			Name: "profile",
+			Usage: "preset of the retention, precursor selection, retained actors and syscall recording settings for the messages of an actor family; " +
+				"values: " + strings.Join(extractProfileNames(), ", ") + ". Flags set explicitly take precedence, and --retain-actor adds to the actors of the profile",
+			Destination: &extractFlags.profile,
+		},
 		&cli.StringFlag{
 			Name: "precursor-select",
 			Usage: "precursors to apply; values: 'all', 'participants'; 'all' selects all preceding " +
 				"messages in the canonicalised tipset, 'participants' selects only preceding messages from the same " +
 				"participants. Usually, 'participants' is a good tradeoff and gives you sufficient accuracy. If the receipt sanity " +
-				"check fails due to gas reasons, switch to 'all', as previous messages in the tipset may have " +
-				"affected state in a disruptive way",
+				"check fails due to gas reasons, previous messages in the tipset may have affected state in a disruptive way; " +
+				"the extraction is then retried with 'all', see --escalate-precursors",
 			Value:       "participants",
 			Destination: &extractFlags.precursor,
 		},
+		&cli.BoolFlag{
+			Name: "embed-precursors",
+			Usage: "apply the precursors as messages of the vector, with their receipts, instead of squashing them " +
+				"into its pre-state; requires 'accessed-cids' state retention",
+			Destination: &extractFlags.embedPrecursors,
+		},
+		&cli.BoolFlag{
+			Name: "ignore-precursors",
+			Usage: "apply no precursors; the state may drift from that on chain, so expect to supply --hint or " +
+				"--ignore-sanity-checks, and the message to fail nonce validation if its sender has preceding messages in the tipset",
+			Destination: &extractFlags.ignorePrecursors,
+		},
+		&cli.BoolFlag{
+			Name: "escalate-precursors",
+			Usage: "message class only: when the receipt sanity check fails on gas, extract the vector again with the 'all' precursor selection mode, " +
+				"then with every preceding message of the tipset, uncapped by --max-precursors, before giving up; the configuration used is logged, " +
+				"and recorded in the vector metadata",
+			Value:       true,
+			Destination: &extractFlags.escalatePrecursors,
+		},
+		&cli.BoolFlag{
+			Name: "warmup",
+			Usage: "message class only: replay the message on the node first, and fetch the state its trace predicts the extraction to read in bulk, " +
+				"ahead of the execution: the state of the actors involved, and the lookback tipsets of the proofs verified",
+			Destination: &extractFlags.warmup,
+		},
+		&cli.StringFlag{
+			Name: "pre-root",
+			Usage: "message class only: CID of a state root to apply the message on, fetched through the node, instead of the parent state of its inclusion tipset, " +
+				"to construct what-if vectors on historical state; neither precursors nor null round crons are applied, and the receipt on chain isn't sanity checked against",
+			Destination: &extractFlags.preRoot,
+		},
+		&cli.BoolFlag{
+			Name: "from-mpool",
+			Usage: "message class only: take the message of --cid from the message pool of the node, pending, rather than from the chain, and apply it speculatively on the state of the head, " +
+				"after the messages of the head tipset and the pending messages of its sender with lower nonces; the vector is tagged 'speculative'. Requires 'accessed-cids' state retention",
+			Destination: &extractFlags.fromMpool,
+		},
+		&cli.StringFlag{
+			Name: "msg-file",
+			Usage: "message and consensus-fault classes only: file holding a message not on chain, e.g. produced by a wallet or SDK under test, or a report of synthesized headers, to apply instead of that of --cid: " +
+				"a message or signed message, JSON- or raw CBOR-encoded. It's applied speculatively on the state of the tipset of --tsk, --height or --epochs-ago, " +
+				"or the head, after the messages of the tipset; the vector is tagged 'speculative'. Requires 'accessed-cids' state retention",
+			TakesFile:   true,
+			Destination: &extractFlags.msgFile,
+		},
+		&cli.BoolFlag{
+			Name: "light",
+			Usage: "message class only: emit a light vector, carrying the message, the epoch, basefee and circulating supply it was applied with, and its receipt on chain, but no state, " +
+				"for implementations replaying it against their own synced chain. The message isn't executed, so light vectors are cheap to extract in bulk; tvx exec skips them",
+			Destination: &extractFlags.light,
+		},
+		&cli.IntFlag{
+			Name: "max-precursors",
+			Usage: "maximum number of precursors to apply, besides those of the sender of the message, which are always " +
+				"applied to preserve its nonce sequence; the ones closest to the message are kept; 0 applies all",
+			Destination: &extractFlags.maxPrecursors,
+		},
+		&cli.IntFlag{
+			Name: "precursor-lookback",
+			Usage: "message class with --pre-root only: number of tipsets before the inclusion tipset to search, along with it, for the messages of the sender " +
+				"missing from the pre-state, when its nonce there isn't that the message follows; those found are applied as precursors first, " +
+				"and the decision is recorded in the vector metadata; 0 searches none",
+			Destination: &extractFlags.precursorLookback,
+		},
+		&cli.StringFlag{
+			Name:        "implicit",
+			Usage:       "implicit message to extract when using the 'implicit' class; values: 'cron', 'reward'",
+			Value:       extractor.ImplicitCron,
+			Destination: &extractFlags.implicit,
+		},
+		&cli.StringFlag{
+			Name:        "miner",
+			Usage:       "when extracting an implicit 'reward' message, the miner whose block reward to extract; defaults to the miner of the first block",
+			Destination: &extractFlags.miner,
+		},
+		&cli.Int64Flag{
+			Name:        "epoch",
+			Usage:       "when extracting a 'migration' vector, the epoch of the network upgrade whose migration to extract",
+			Destination: &extractFlags.epoch,
+		},
+		&cli.Int64Flag{
+			Name:        "msig-lookback",
+			Usage:       "when extracting a 'msig-flow' vector, the number of epochs before the approval to search for the proposal in",
+			Value:       builtin.EpochsInDay * 7,
+			Destination: &extractFlags.msigLookback,
+		},
+		&cli.StringFlag{
+			Name:        "paych",
+			Usage:       "when extracting a 'paych-flow' vector, the address of the payment channel whose lifecycle to extract",
+			Destination: &extractFlags.paych,
+		},
+		&cli.Int64Flag{
+			Name:        "paych-lookback",
+			Usage:       "when extracting a 'paych-flow' vector, the number of epochs before the head to search for messages to the payment channel in",
+			Value:       builtin.EpochsInDay * 7,
+			Destination: &extractFlags.paychLookback,
+		},
+		&cli.StringFlag{
+			Name:        "to",
+			Usage:       "when extracting 'message' vectors by filter, only extract messages sent to this address",
+			Destination: &extractFlags.to,
+		},
+		&cli.Int64Flag{
+			Name:        "method",
+			Usage:       "when extracting 'message' vectors by filter, only extract messages invoking this method number; if negative, all methods",
+			Value:       -1,
+			Destination: &extractFlags.method,
+		},
+		&cli.Int64Flag{
+			Name:        "from-epoch",
+			Usage:       "when extracting 'message' vectors by filter, the first inclusion epoch to scan; supplying it without --cid enables filter mode",
+			Destination: &extractFlags.fromEpoch,
+		},
+		&cli.Int64Flag{
+			Name:        "to-epoch",
+			Usage:       "when extracting 'message' vectors by filter, the last inclusion epoch to scan (inclusive)",
+			Destination: &extractFlags.toEpoch,
+		},
+		&cli.StringFlag{
+			Name:        "exit-codes",
+			Usage:       "when extracting 'message' vectors by filter, only extract messages that exited with one of these comma-separated exit codes (e.g. 5,7,16)",
+			Destination: &extractFlags.exitCodes,
+		},
+		&cli.StringFlag{
+			Name:        "index",
+			Usage:       "location of a message index built with 'tvx index build'; if set, it's consulted to locate messages and scan epoch ranges without querying the chain",
+			TakesFile:   true,
+			Destination: &extractFlags.index,
+		},
+		&cli.StringFlag{
+			Name: "progress-json",
+			Usage: "file or named pipe to emit machine-readable progress events to, as newline-delimited JSON; " +
+				"events: message_resolved, precursor_applied, message_applied, tipset_applied, migration_applied, blocks_fetched, car_written, vector_written",
+			TakesFile:   true,
+			Destination: &extractFlags.progressJSON,
+		},
+		&cli.StringSliceFlag{
+			Name:        "selector",
+			Usage:       "selector to add to the vector, in key=value form; can be repeated. Selectors for features detected during extraction are added automatically",
+			Destination: &extractSelectors,
+		},
+		&cli.StringFlag{
+			Name: "circ-supply",
+			Usage: "circulating supply mode to replay the vector with, recorded in the vector; values: 'fixed' (the circulating supply " +
+				"of the preconditions throughout), 'recompute' (recomputed from the state tree as messages are applied, for sequences " +
+				"that burn or lock funds across epochs, e.g. 'paych-flow' or embedded precursors)",
+			Value:       conformance.CircSupplyFixed,
+			Destination: &extractFlags.circSupply,
+		},
+		&cli.StringFlag{
+			Name: "mock-syscalls",
+			Usage: "comma-separated list of syscalls to mock, recorded in the vector so that it's replayed with the same mocks; " +
+				"values: 'proofs' (all proof verifications pass), 'signatures' (all signature verifications pass). Only effective before nv16",
+			Destination: &extractFlags.mockSyscalls,
+		},
+		&cli.BoolFlag{
+			Name: "record-syscalls",
+			Usage: "when extracting 'message' or 'implicit' vectors, record the outcomes of the verification syscalls " +
+				"(signatures, seals, PoSts, consensus faults) into the vector, so that they're replayed deterministically. Only effective before nv16",
+			Destination: &extractFlags.recordSyscalls,
+		},
+		&cli.BoolFlag{
+			Name: "record-traces",
+			Usage: "when extracting 'message' vectors, record the call trees of the messages (sub-call receivers, methods and exit codes) " +
+				"into the vector, so that they're asserted along with the receipts",
+			Destination: &extractFlags.recordTraces,
+		},
+		&cli.BoolFlag{
+			Name: "record-extraction-time",
+			Usage: "record the time vectors are extracted at in their metadata, for tvx provenance to list; " +
+				"off by default, as vectors extracted repeatedly then differ",
+			Destination: &extractFlags.recordTime,
+		},
+		&cli.StringFlag{
+			Name: "preflight",
+			Usage: "pre-flight checks that the node is synced past the execution tipset, and that the latter is final (see --confidence); " +
+				"values: 'warn' (log failed checks and proceed), 'refuse' (abort on failed checks), 'off'. Outcomes are recorded in the vector metadata",
+			Value:       extractor.PreflightWarn,
+			Destination: &extractFlags.preflight,
+		},
+		&cli.Int64Flag{
+			Name:        "confidence",
+			Usage:       "number of epochs the execution tipset must be behind the head of the node to be deemed final by the pre-flight checks",
+			Value:       int64(extractor.DefaultConfidence),
+			Destination: &extractFlags.confidence,
+		},
+		&cli.StringSliceFlag{
+			Name: "hint",
+			Usage: "hint to record in the vector; can be repeated. Standard hints: 'incorrect', 'negate', 'incorrect-gas'. " +
+				"With 'incorrect-gas', a receipt sanity check that fails only on gas used doesn't abort extraction; " +
+				"with 'incorrect', no failed sanity check aborts extraction",
+			Destination: &extractHints,
+		},
+		&cli.StringSliceFlag{
+			Name: "tag",
+			Usage: "tag to record in the vector metadata, for curating corpora, e.g. 'slow' or 'regression/issue-1234'; can be repeated. " +
+				"Tags are paths of segments of lowercase letters, digits, '-', '_' and '.', separated by '/'",
+			Destination: &extractTags,
+		},
 		&cli.BoolFlag{
 			Name:        "ignore-sanity-checks",
 			Usage:       "generate vector even if sanity checks fail",
 			Value:       false,
 			Destination: &extractFlags.ignoreSanityChecks,
 		},
+		&cli.BoolFlag{
+			Name:    "force",
+			Aliases: []string{"lenient"},
+			Usage: "emit the vector documenting the locally observed behaviour even if sanity checks fail; " +
+				"on receipt mismatch, both the observed and the on-chain receipts are recorded in the vector diagnostics",
+			Destination: &extractFlags.force,
+		},
+		&cli.StringFlag{
+			Name: "sign-key",
+			Usage: "sign the vector with the ed25519 key in the file, hex-encoded, recording the signer and signature in its metadata; " +
+				"verify with tvx verify-signature",
+			TakesFile:   true,
+			Destination: &extractFlags.signKey,
+		},
+		&cli.StringFlag{
+			Name:        "sign-wallet",
+			Usage:       "sign the vector with the key of the supplied address in the node's wallet; requires a token with sign permission",
+			Destination: &extractFlags.signWallet,
+		},
+		&cli.StringSliceFlag{
+			Name: "post-process",
+			Usage: "post-process every vector before it's written or published, to enforce local corpus policies; repeatable, applied in order. " +
+				"Paths ending in .so are Go plugins exporting PostProcess, a func(*schema.TestVector) (*schema.TestVector, error) returning the vector to write, or nil to reject it. " +
+				"Others are shell commands, fed the vector as JSON on stdin, writing the vector to write on stdout, or nothing to reject it. " +
+				"Renamed vectors (i.e. of another ID) are written to files named after their new ID",
+			Destination: &extractPostProcess,
+		},
+		&cli.IntFlag{
+			Name: "state-stats",
+			Usage: "report the size of the state retained with 'accessed-cids' retention, by node kind (HAMT, AMT, other), " +
+				"and the supplied number of largest retained objects, to stderr",
+			Destination: &stateStatsTop,
+		},
+		&cli.BoolFlag{
+			Name: "server-side",
+			Usage: "extract the 'message' vector in the node, through the TvxExtractMessage API method, instead of fetching the state " +
+				"it accesses object by object; the node must enable it with Tvx.EnableExtract in its config",
+			Destination: &extractFlags.serverSide,
+		},
 		&cli.BoolFlag{
 			Name:        "squash",
 			Usage:       "when extracting a tipset range, squash all tipsets into a single vector",
 			Value:       false,
 			Destination: &extractFlags.squash,
 		},
-	},
+		&cli.BoolFlag{
+			Name: "state-compute",
+			Usage: "tipset and blockseq classes only: record the execution of the tipsets by the node, through StateCompute, rather than the local one: " +
+				"their receipts, receipts roots, post state roots, and the call trees of their messages. The tipsets are still executed locally to gather " +
+				"the state they access; divergences from the node are logged, and recorded in the vector metadata",
+			Destination: &extractFlags.stateCompute,
+		},
+		&cli.StringFlag{
+			Name: "max-car-size",
+			Usage: "maximum size of the state a vector may retain, as its uncompressed CAR, e.g. 64MiB; extractions exceeding it fail, " +
+				"with suggestions to retain less state. The compressed and uncompressed sizes of the CAR are recorded in the vector metadata regardless",
+			Destination: &extractMaxCARSize,
+		},
+		&cli.IntFlag{
+			Name: "repeat",
+			Usage: "extract the vector this many times, and verify that the extractions are identical, listing the fields, and CAR blocks, that differ; " +
+				"with many --api endpoints, the extractions rotate across them. The first extraction is written",
+			Value:       1,
+			Destination: &extractRepeat,
+		},
+		&cli.DurationFlag{
+			Name:        "reextract-interval",
+			Usage:       "with --repeat, how long to wait between extractions, e.g. for the message to reach a greater confidence depth",
+			Destination: &extractRepeatInterval,
+		},
+		&cli.BoolFlag{
+			Name: "dry-run",
+			Usage: "resolve the message, tipsets and precursors of a 'message', 'tipset' or 'blockseq' extraction, and estimate the size of " +
+				"the state it would retain, then print the plan without applying messages or writing anything",
+			Destination: &extractFlags.dryRun,
+		},
+	}, assertCmdFlags...),
+}
+
+func runExtract(c *cli.Context) error {
+	mocks, err := mockSyscallsSelector(extractFlags.mockSyscalls)
+	if err != nil {
+		return err
+	}
+	circSupply, err := circSupplySelector(extractFlags.circSupply)
+	if err != nil {
+		return err
+	}
+	extractFlags.selectors = append(append(extractSelectors.Value(), mocks...), circSupply...)
+	extractFlags.hints = extractHints.Value()
+	extractFlags.tags = extractTags.Value()
+	if err := conformance.ValidateTags(extractFlags.tags); err != nil {
+		return err
+	}
+	for _, a := range extractRetainActors.Value() {
+		addr, err := address.NewFromString(a)
+		if err != nil {
+			return fmt.Errorf("invalid actor address %s: %w", a, err)
+		}
+		extractFlags.retainActors = append(extractFlags.retainActors, addr)
+	}
+	if extractMaxCARSize != "" {
+		if extractFlags.maxCARSize, err = units.RAMInBytes(extractMaxCARSize); err != nil {
+			return fmt.Errorf("invalid --max-car-size %s: %w", extractMaxCARSize, err)
+		}
+	}
+	if extractFlags.msgIndex >= 0 && !extractFlags.hasRelativeTarget() {
+		return fmt.Errorf("--msg-index requires --height or --epochs-ago")
+	}
+	if extractFlags.msgFile != "" {
+		switch {
+		case extractFlags.class != string(schema.ClassMessage) && extractFlags.class != extractor.ClassConsensusFault:
+			return fmt.Errorf("--msg-file only applies to message and consensus-fault vectors")
+		case extractFlags.cid != "" || extractFlags.url != "" || extractFlags.fromMpool || extractFlags.fromEpoch != 0:
+			return fmt.Errorf("--msg-file is mutually exclusive with --cid, --url, --from-mpool and --from-epoch")
+		case extractFlags.dryRun || extractFlags.serverSide:
+			return fmt.Errorf("--msg-file is incompatible with --dry-run and --server-side")
+		}
+		if extractFlags.message, err = readMessageFile(extractFlags.msgFile); err != nil {
+			return err
+		}
+		log.Printf("supplied message %s: %s -> %s, method %d, nonce %d", extractFlags.message.Cid(), extractFlags.message.From,
+			extractFlags.message.To, extractFlags.message.Method, extractFlags.message.Nonce)
+	}
+	if err := applyExtractProfile(extractFlags.profile, &extractFlags, c.IsSet); err != nil {
+		return err
+	}
+	if extractFlags.progressJSON != "" {
+		if progress, err = openProgress(extractFlags.progressJSON); err != nil {
+			return err
+		}
+		defer progress.Close() //nolint:errcheck
+	}
+	if extractFlags.index != "" {
+		if msgIndex, err = openMessageIndex(extractFlags.index); err != nil {
+			return err
+		}
+		defer msgIndex.Close() //nolint:errcheck
+	}
+	switch {
+	case extractFlags.signKey != "" && extractFlags.signWallet != "":
+		return fmt.Errorf("--sign-key and --sign-wallet are mutually exclusive")
+	case extractFlags.signKey != "":
+		if signer, err = loadEd25519Signer(extractFlags.signKey); err != nil {
+			return err
+		}
+	case extractFlags.signWallet != "":
+		addr, err := address.NewFromString(extractFlags.signWallet)
+		if err != nil {
+			return fmt.Errorf("invalid wallet address %s: %w", extractFlags.signWallet, err)
+		}
+		signer = &walletSigner{addr: addr}
+	}
+	for _, spec := range extractPostProcess.Value() {
+		p, err := openPostProcessor(spec)
+		if err != nil {
+			return err
+		}
+		postProcessors = append(postProcessors, p)
+	}
+	if extractFlags.dryRun {
+		return doExtractPlan(extractFlags)
+	}
+	if extractFlags.serverSide {
+		return doExtractServerSide(c, extractFlags)
+	}
+	if extractRepeat > 1 {
+		return doExtractRepeated(c, extractFlags)
+	}
+	return doExtract(extractFlags)
+}
+
+// doExtract extracts a vector of the class requested in the options.
+func doExtract(opts extractOpts) (err error) {
+	recordExtraction(opts.class, OutcomeAttempted)
+	defer func() {
+		if err != nil {
+			recordExtraction(opts.class, OutcomeFailed)
+		} else {
+			recordExtraction(opts.class, OutcomeSucceeded)
+		}
+	}()
+
+	if err := opts.resolveURL(); err != nil {
+		return err
+	}
+	if err := opts.resolveRelativeTarget(context.Background()); err != nil {
+		return err
+	}
+	if opts.class == string(schema.ClassMessage) && opts.cid == "" && opts.message == nil && opts.fromEpoch != 0 {
+		return doExtractFilteredMessages(opts)
+	}
+
+	xopts := opts.extractorOptions()
+	vectors, err := extractor.ExtractAll(context.Background(), FullAPI, xopts)
+	if err != nil {
+		return err
+	}
+	if xopts.Multiple() {
+		return writeVectors(opts.file, vectors...)
+	}
+	return writeVector(vectors[0], opts.file)
+}
+
+// resolveURL resolves the message CID, and the block if known, from the
+// explorer URL of the options, if any; a URL supplied through --cid is
+// accepted too.
+func (o *extractOpts) resolveURL() error {
+	if o.url == "" && isURL(o.cid) {
+		o.url, o.cid = o.cid, ""
+	}
+	if o.url == "" {
+		return nil
+	}
+	if o.cid != "" {
+		return fmt.Errorf("--cid and --url are mutually exclusive")
+	}
+	msg, block, err := parseExplorerURL(o.url)
+	if err != nil {
+		return err
+	}
+	o.cid = msg.String()
+	if block.Defined() && o.block == "" {
+		o.block = block.String()
+	}
+	log.Printf("message CID from explorer URL: %s", msg)
+	return nil
 }
 
-func runExtract(_ *cli.Context) error {
-	switch extractFlags.class {
-	case "message":
-		return doExtractMessage(extractFlags)
-	case "tipset":
-		return doExtractTipset(extractFlags)
-	default:
-		return fmt.Errorf("unsupported vector class")
+// doExtractMessage extracts a message vector, as requested in the options,
+// and writes it.
+func doExtractMessage(opts extractOpts) error {
+	opts.class = string(schema.ClassMessage)
+	vector, err := extractor.Extract(context.Background(), FullAPI, opts.extractorOptions())
+	if err != nil {
+		return err
 	}
+	return writeVector(vector, opts.file)
+}
+
+// extractorOptions returns the options of the extraction requested in the
+// options, hooked into the progress events, metrics, message index and batch
+// stores of the command.
+func (o extractOpts) extractorOptions() extractor.Options {
+	hooks := extractor.Hooks{
+		OnEvent:         progress.emit,
+		OnSanityCheck:   recordSanityCheck,
+		OnCARWritten:    carWritten,
+		OnStateRetained: reportStateStats,
+	}
+	if o.onEvent != nil {
+		hooks.OnEvent = func(event string, kvs ...interface{}) {
+			progress.emit(event, kvs...)
+			o.onEvent(event, kvs...)
+		}
+	}
+	if msgIndex != nil {
+		hooks.LocateMessage = func(ctx context.Context, c cid.Cid) (types.TipSetKey, bool, error) {
+			m, ok, err := msgIndex.lookup(ctx, c)
+			if err != nil || !ok {
+				return types.EmptyTSK, ok, err
+			}
+			return m.ExecutionTipset, true, nil
+		}
+	}
+	if batchStores != nil {
+		hooks.Stores = extractionStores
+	}
+	return extractor.Options{
+		Class:              o.class,
+		ID:                 o.id,
+		CID:                o.cid,
+		Block:              o.block,
+		TSK:                o.tsk,
+		Retain:             o.retain,
+		RetainActors:       o.retainActors,
+		Precursor:          o.precursor,
+		EmbedPrecursors:    o.embedPrecursors,
+		IgnorePrecursors:   o.ignorePrecursors,
+		EscalatePrecursors: o.escalatePrecursors,
+		Warmup:             o.warmup,
+		PreRoot:            o.preRoot,
+		FromMpool:          o.fromMpool,
+		Message:            o.message,
+		Light:              o.light,
+		MaxPrecursors:      o.maxPrecursors,
+		PrecursorLookback:  o.precursorLookback,
+		Implicit:           o.implicit,
+		Miner:              o.miner,
+		Epoch:              o.epoch,
+		Squash:             o.squash,
+		StateCompute:       o.stateCompute,
+		MsigLookback:       o.msigLookback,
+		Paych:              o.paych,
+		PaychLookback:      o.paychLookback,
+		Selectors:          o.selectors,
+		Hints:              o.hints,
+		Tags:               o.tags,
+		RecordSyscalls:     o.recordSyscalls,
+		RecordTraces:       o.recordTraces,
+		RecordTime:         o.recordTime,
+		Assert:             assertFlags,
+		IgnoreSanityChecks: o.ignoreSanityChecks,
+		Force:              o.force,
+		Prefetch:           prefetchLinks,
+		Preflight:          o.preflightMode(),
+		Confidence:         abi.ChainEpoch(o.confidence),
+		MaxCARSize:         o.maxCARSize,
+		SelfCheck:          selfCheck,
+		Hooks:              hooks,
+	}
+}
+
+// preflightMode returns the pre-flight check mode of the extraction.
+func (o extractOpts) preflightMode() string {
+	if o.preflight == "off" {
+		return extractor.PreflightOff
+	}
+	return o.preflight
 }
 
 // writeVector writes the vector into the specified file, or to stdout if
-// file is empty.
+// file is empty or "-". If file is a sink URL, the vector is published to the
+// sink instead; see openSink. The vector is post-processed first, and not
+// written if rejected, if post-processors are enabled, then signed if a
+// signer is.
 func writeVector(vector *schema.TestVector, file string) (err error) {
+	if len(postProcessors) > 0 {
+		id := vector.Meta.ID
+		if vector, err = postProcessVector(vector); err != nil || vector == nil {
+			return err
+		}
+		file = renamedVectorFile(file, id, vector.Meta.ID)
+	}
+	if signer != nil {
+		if err := signVector(context.TODO(), signer, vector); err != nil {
+			return fmt.Errorf("failed to sign vector: %w", err)
+		}
+	}
+
+	if isSink(file) {
+		if strings.HasSuffix(file, "/") || strings.Count(file, "/") == 2 {
+			file = outputPath(file, vector.Meta.ID+".json")
+		}
+		data, err := json.MarshalIndent(&vector, "", "  ")
+		if err != nil {
+			return err
+		}
+		loc, err := publishVector(file, data)
+		if err != nil {
+			return err
+		}
+		log.Printf("published test vector: %s", loc)
+		progress.emit(EventVectorWritten, "id", vector.Meta.ID, "file", loc)
+		return nil
+	}
+
 	output := io.WriteCloser(os.Stdout)
-	if file := file; file != "" {
+	if file := file; file != "" && file != "-" {
 		dir := filepath.Dir(file)
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("unable to create directory %s: %w", dir, err)
@@ -137,23 +781,45 @@ func writeVector(vector *schema.TestVector, file string) (err error) {
 		defer output.Close() //nolint:errcheck
 		defer log.Printf("wrote test vector to file: %s", file)
 	}
+	defer func() {
+		if err == nil {
+			progress.emit(EventVectorWritten, "id", vector.Meta.ID, "file", file)
+		}
+	}()
 
-	enc := json.NewEncoder(output)
-	enc.SetIndent("", "  ")
-	return enc.Encode(&vector)
+	data, err := json.MarshalIndent(&vector, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = encodeVectorFile(file, append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to encode vector: %w", err)
+	}
+	_, err = output.Write(data)
+	return err
 }
 
 // writeVectors writes each vector to a different file under the specified
-// directory.
+// directory, or streams them to stdout if it's empty or "-"; see
+// streamVector.
 func writeVectors(dir string, vectors ...*schema.TestVector) error {
+	if isStdout(dir) {
+		for _, v := range vectors {
+			if err := streamVector(os.Stdout, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	// verify the output directory exists.
-	if err := ensureDir(dir); err != nil {
-		return err
+	if !isSink(dir) {
+		if err := ensureDir(dir); err != nil {
+			return err
+		}
 	}
 	// write each vector to its file.
 	for _, v := range vectors {
 		id := v.Meta.ID
-		path := filepath.Join(dir, fmt.Sprintf("%s.json", id))
+		path := outputPath(dir, fmt.Sprintf("%s.json", id))
 		if err := writeVector(v, path); err != nil {
 			return err
 		}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// doExtractFilteredMessages scans the messages included in the epoch range
+// [opts.fromEpoch, opts.toEpoch], and extracts a vector for every message
+// matching the recipient, method and exit code filters into the opts.file
+// directory, or streams them to stdout if it's "-", each as soon as it's
+// extracted; see streamVector.
+func doExtractFilteredMessages(opts extractOpts) error {
+	ctx := context.Background()
+
+	if opts.file == "" {
+		return fmt.Errorf("an output directory, or '-' to stream the vectors to stdout, is required when extracting messages by filter")
+	}
+	stream := isStdout(opts.file)
+	from, to := abi.ChainEpoch(opts.fromEpoch), abi.ChainEpoch(opts.toEpoch)
+	if to < from {
+		return fmt.Errorf("invalid epoch range [%d, %d]", from, to)
+	}
+
+	var (
+		recipient, recipientID address.Address
+		err                    error
+	)
+	if opts.to != "" {
+		if recipient, err = address.NewFromString(opts.to); err != nil {
+			return fmt.Errorf("invalid recipient address %s: %w", opts.to, err)
+		}
+		if recipientID, err = FullAPI.StateLookupID(ctx, recipient, types.EmptyTSK); err != nil {
+			return fmt.Errorf("failed to resolve recipient address %s: %w", recipient, err)
+		}
+	}
+
+	exitCodes, err := parseExitCodes(opts.exitCodes)
+	if err != nil {
+		return err
+	}
+
+	// matches returns whether the message matches the recipient and method
+	// filters.
+	matches := func(incTs *types.TipSet, msg *types.Message) bool {
+		if opts.method >= 0 && msg.Method != abi.MethodNum(opts.method) {
+			return false
+		}
+		if opts.to == "" || msg.To == recipient || msg.To == recipientID {
+			return true
+		}
+		if msg.To.Protocol() == address.ID {
+			return false
+		}
+		id, err := FullAPI.StateLookupID(ctx, msg.To, incTs.Key())
+		return err == nil && id == recipientID
+	}
+
+	matchesExitCode := func(code exitcode.ExitCode) bool {
+		_, ok := exitCodes[code]
+		return len(exitCodes) == 0 || ok
+	}
+
+	var candidates []extractCandidate
+	covered := false
+	if msgIndex != nil {
+		if covered, err = msgIndex.covers(ctx, from, to); err != nil {
+			return err
+		} else if !covered {
+			extractLog.Warnw("message index doesn't cover epoch range; scanning the chain", "from", from, "to", to)
+		}
+	}
+
+	if covered {
+		for e := from; e <= to; e++ {
+			msgs, err := msgIndex.messagesAt(ctx, e)
+			if err != nil {
+				return err
+			}
+			for _, m := range msgs {
+				if opts.method >= 0 && m.Method != abi.MethodNum(opts.method) {
+					continue
+				}
+				if opts.to != "" && m.To != recipient && m.ToID != recipientID {
+					continue
+				}
+				if matchesExitCode(m.ExitCode) {
+					// the message is located through the index.
+					candidates = append(candidates, extractCandidate{cid: m.Cid, epoch: e})
+				}
+			}
+		}
+	} else {
+		err = forEachInclusionTipset(ctx, from, to+1, func(incTs, execTs *types.TipSet, msgs []api.Message) (bool, error) {
+			var (
+				rcpts []*types.MessageReceipt
+				err   error
+			)
+			if len(exitCodes) > 0 {
+				if rcpts, err = FullAPI.ChainGetParentReceipts(ctx, execTs.Blocks()[0].Cid()); err != nil {
+					return true, fmt.Errorf("failed to get receipts of messages included at epoch %d: %w", incTs.Height(), err)
+				}
+			}
+			for i, m := range msgs {
+				if matches(incTs, m.Message) && (len(exitCodes) == 0 || matchesExitCode(rcpts[i].ExitCode)) {
+					candidates = append(candidates, extractCandidate{cid: m.Cid, epoch: incTs.Height(), block: incTs.Cids()[0].String()})
+				}
+			}
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var (
+		generated []string
+		merr      = new(multierror.Error)
+	)
+	for _, c := range candidates {
+		o := opts
+		o.id = fmt.Sprintf("ext-%d-%s", c.epoch, c.cid)
+		o.cid = c.cid.String()
+		o.block = c.block
+		extract := doExtractMessage
+		if stream {
+			o.file, extract = "-", streamExtractMessage
+		} else {
+			o.file = outputPath(opts.file, o.id+".json")
+		}
+
+		extractLog.Infow("extracting message", "cid", c.cid, "epoch", c.epoch)
+		if err := extract(o); err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("failed to extract vector for message %s: %w", c.cid, err))
+			continue
+		}
+		generated = append(generated, o.file)
+	}
+
+	extractLog.Infow("extracted vectors from epoch range", "count", len(generated), "from", from, "to", to)
+	return merr.ErrorOrNil()
+}
+
+// extractCandidate is a message matching the extraction filters.
+type extractCandidate struct {
+	cid   cid.Cid
+	epoch abi.ChainEpoch
+	// block is a block of the inclusion tipset, if known.
+	block string
+}
+
+// parseExitCodes parses a comma-separated list of exit codes into a set. It
+// returns a nil set if the list is empty.
+func parseExitCodes(list string) (map[exitcode.ExitCode]struct{}, error) {
+	if list == "" {
+		return nil, nil
+	}
+	ret := make(map[exitcode.ExitCode]struct{})
+	for _, s := range strings.Split(list, ",") {
+		code, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit code %q: %w", s, err)
+		}
+		ret[exitcode.ExitCode(code)] = struct{}{}
+	}
+	return ret, nil
+}
+
+// forEachInclusionTipset invokes cb with every non-null tipset at epochs in
+// [from, until), along with the tipset that executes it and the messages it
+// includes, in canonical order. Iteration stops early if cb returns true.
+func forEachInclusionTipset(ctx context.Context, from, until abi.ChainEpoch, cb func(incTs, execTs *types.TipSet, msgs []api.Message) (bool, error)) error {
+	for e := from; e < until; {
+		// the first non-null tipset at or after e.
+		incTs, err := extractor.FindExecutionTipset(ctx, FullAPI, e-1)
+		if err != nil {
+			return err
+		}
+		if incTs.Height() >= until {
+			return nil
+		}
+		execTs, err := extractor.FindExecutionTipset(ctx, FullAPI, incTs.Height())
+		if err != nil {
+			return err
+		}
+
+		msgs, err := FullAPI.ChainGetParentMessages(ctx, execTs.Blocks()[0].Cid())
+		if err != nil {
+			return fmt.Errorf("failed to get messages included at epoch %d: %w", incTs.Height(), err)
+		}
+		if stop, err := cb(incTs, execTs, msgs); err != nil || stop {
+			return err
+		}
+		e = incTs.Height() + 1
+	}
+	return nil
+}
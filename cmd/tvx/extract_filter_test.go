@@ -0,0 +1,30 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/exitcode"
+)
+
+func TestParseExitCodes(t *testing.T) {
+	codes, err := parseExitCodes("5, 7,16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 3 {
+		t.Fatalf("unexpected exit codes: %v", codes)
+	}
+	for _, c := range []exitcode.ExitCode{exitcode.ExitCode(5), exitcode.SysErrOutOfGas, exitcode.ErrIllegalArgument} {
+		if _, ok := codes[c]; !ok {
+			t.Fatalf("exit code %s missing from %v", c, codes)
+		}
+	}
+
+	if codes, err := parseExitCodes(""); err != nil || codes != nil {
+		t.Fatalf("expected no exit codes; got %v (err: %v)", codes, err)
+	}
+	if _, err := parseExitCodes("5,foo"); err == nil {
+		t.Fatal("expected error for invalid exit code")
+	}
+}
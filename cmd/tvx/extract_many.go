@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,23 +11,86 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/fatih/color"
 	"github.com/hashicorp/go-multierror"
 	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
 	"github.com/multiformats/go-multihash"
 	"github.com/urfave/cli/v2"
 
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/exitcode"
 
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
 	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
 )
 
 var extractManyFlags struct {
-	in      string
-	outdir  string
-	batchId string
+	in           string
+	outdir       string
+	batchId      string
+	shareWindow  int64
+	workers      int
+	failures     string
+	skipExisting bool
+	light        bool
+	tags         cli.StringSlice
+}
+
+// batchStores are the stores shared by the extractions of a batch, so that
+// the state fetched from the node for a vector is reused by the following
+// ones. They're nil outside of batches.
+var batchStores *sharedStores
+
+// sharedStores is a set of proxying extractor.Stores, and the state surgeon over them,
+// shared by the extractions of messages in a window of epochs.
+type sharedStores struct {
+	window abi.ChainEpoch
+
+	// lk guards the fields below, as concurrent extractions share the stores.
+	lk sync.Mutex
+
+	stores  *extractor.Stores
+	surgeon *extractor.StateSurgeon
+	// epoch is the epoch of the first extraction using the stores.
+	epoch abi.ChainEpoch
+	// reused counts the extractions that used the stores after the first.
+	reused int
+}
+
+// extractionStores returns the stores, and the state surgeon over them, to
+// extract a vector at the epoch with. Within a batch, they're those of the
+// preceding extractions, unless the first of these was more than the sharing
+// window of epochs away, in which case they're replaced, so that memory
+// doesn't grow unbounded. Outside of batches, they're fresh.
+func extractionStores(ctx context.Context, epoch abi.ChainEpoch) (*extractor.Stores, *extractor.StateSurgeon) {
+	b := batchStores
+	if b == nil || b.window <= 0 {
+		pst := extractor.NewProxyingStores(ctx, FullAPI, prefetchLinks)
+		return pst, extractor.NewSurgeon(ctx, FullAPI, pst)
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	distance := epoch - b.epoch
+	if distance < 0 {
+		distance = -distance
+	}
+	if b.stores == nil || distance > b.window {
+		if b.stores != nil {
+			log.Printf("epoch %d outside of the sharing window of the batch stores (from epoch %d, reused %d times); replacing them", epoch, b.epoch, b.reused)
+		}
+		b.stores = extractor.NewProxyingStores(ctx, FullAPI, prefetchLinks)
+		b.surgeon = extractor.NewSurgeon(ctx, FullAPI, b.stores)
+		b.epoch, b.reused = epoch, 0
+	} else {
+		b.reused++
+	}
+	return b.stores, b.surgeon
 }
 
 var extractManyCmd = &cli.Command{
@@ -42,12 +107,35 @@ var extractManyCmd = &cli.Command{
    The first row MUST be a header row. At the bare minimum, those seven fields
    must appear, in the order specified. Extra fields are accepted, but always
    after these compulsory seven.
+
+   The state fetched from the node is shared by the extractions of messages
+   whose heights are within --share-window epochs of each other, so sorting
+   the rows by height maximizes reuse. That state is shared by the --workers
+   concurrent extractions too.
+
+   Messages are extracted with the 'participants' precursor selection mode,
+   escalated to the 'all' mode, then to every preceding message of the
+   tipset, while the receipt sanity check fails on gas; those that fail
+   otherwise are retried with the 'all' mode. When stderr is a terminal, the
+   extraction each worker is running is displayed live, along with the overall
+   progress. Once done, the numbers of extractions that succeeded, failed and
+   were skipped (with --skip-existing) are reported, along with the reasons of
+   the failures. With --failures, the rows of the failed extractions are
+   written to a CSV file, with the header of the input and their reasons in
+   an extra failure_reason field, which can be supplied as the input of the
+   next run.
 `,
 	Action: runExtractMany,
 	Before: initialize,
 	After:  destroy,
 	Flags: []cli.Flag{
 		&repoFlag,
+		&apiFlag,
+		&rpcQPSFlag,
+		&rpcConcurrencyFlag,
+		&prefetchFlag,
+		&selfCheckFlag,
+		&repoDirectFlag,
 		&cli.StringFlag{
 			Name:        "batch-id",
 			Usage:       "batch id; a four-digit left-zero-padded sequential number (e.g. 0041)",
@@ -64,23 +152,66 @@ var extractManyCmd = &cli.Command{
 			Usage:       "output directory",
 			Destination: &extractManyFlags.outdir,
 		},
+		&cli.Int64Flag{
+			Name:        "share-window",
+			Usage:       "window of epochs within which extractions share the state fetched from the node; 0 disables sharing",
+			Value:       builtin.EpochsInDay,
+			Destination: &extractManyFlags.shareWindow,
+		},
+		&cli.IntFlag{
+			Name:        "workers",
+			Usage:       "number of messages to extract concurrently",
+			Value:       1,
+			Destination: &extractManyFlags.workers,
+		},
+		&cli.StringFlag{
+			Name:        "failures",
+			Usage:       "CSV file to write the rows of the failed extractions to, with their reasons, to be supplied as the input of the next run",
+			TakesFile:   true,
+			Destination: &extractManyFlags.failures,
+		},
+		&cli.BoolFlag{
+			Name:        "skip-existing",
+			Usage:       "skip the messages whose vector files exist already, e.g. when resuming a batch",
+			Destination: &extractManyFlags.skipExisting,
+		},
+		&cli.BoolFlag{
+			Name:        "light",
+			Usage:       "emit light vectors, carrying no state, but the messages and their receipts on chain; see tvx extract --light",
+			Destination: &extractManyFlags.light,
+		},
+		&cli.StringSliceFlag{
+			Name:        "tag",
+			Usage:       "tag to record in the metadata of every vector of the batch; can be repeated. See tvx extract --tag",
+			Destination: &extractManyFlags.tags,
+		},
 	},
 }
 
+// extractManyJob is the extraction of the message of a row of the input of
+// tvx extract-many.
+type extractManyJob struct {
+	row  []string
+	opts extractOpts
+}
+
+// extractManyResult is the outcome of an extraction job.
+type extractManyResult struct {
+	job *extractManyJob
+	// err is the error the extraction failed with, if it did.
+	err error
+	// skipped is set if the vector of the job exists already.
+	skipped bool
+}
+
 func runExtractMany(c *cli.Context) error {
-	// LOTUS_DISABLE_VM_BUF disables what's called "VM state tree buffering",
-	// which stashes write operations in a BufferedBlockstore
-	// (https://github.com/filecoin-project/lotus/blob/b7a4dbb07fd8332b4492313a617e3458f8003b2a/lib/bufbstore/buf_bstore.go#L21)
-	// such that they're not written until the VM is actually flushed.
-	//
-	// For some reason, the standard behaviour was not working for me (raulk),
-	// and disabling it (such that the state transformations are written immediately
-	// to the blockstore) worked.
-	_ = os.Setenv("LOTUS_DISABLE_VM_BUF", "iknowitsabadidea")
+	batchStores = &sharedStores{window: abi.ChainEpoch(extractManyFlags.shareWindow)}
+	defer func() { batchStores = nil }()
 
 	var (
-		in     = extractManyFlags.in
-		outdir = extractManyFlags.outdir
+		in      = extractManyFlags.in
+		outdir  = extractManyFlags.outdir
+		workers = extractManyFlags.workers
 	)
 
 	if in == "" {
@@ -91,44 +222,95 @@ func runExtractMany(c *cli.Context) error {
 		return fmt.Errorf("output dir not provided")
 	}
 
+	if workers < 1 {
+		return fmt.Errorf("invalid number of workers: %d", workers)
+	}
+
+	tags := extractManyFlags.tags.Value()
+	if err := conformance.ValidateTags(tags); err != nil {
+		return err
+	}
+
 	// Open the CSV file for reading.
 	f, err := os.Open(in)
 	if err != nil {
 		return fmt.Errorf("could not open file %s: %w", in, err)
 	}
+	defer f.Close() //nolint:errcheck
 
 	// Ensure the output directory exists.
 	if err := os.MkdirAll(outdir, 0755); err != nil {
 		return fmt.Errorf("could not create output dir %s: %w", outdir, err)
 	}
 
+	header, jobs, err := readExtractManyJobs(f, extractManyFlags.batchId, outdir)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		job.opts.tags = tags
+	}
+
+	display := newBatchDisplay(logOutput, workers, len(jobs))
+	if display.live {
+		// the display replaces the progress logs of the extractions, and
+		// interleaves the logs of tvx with its redraws.
+		if !logFlags.verbose {
+			_ = logging.SetLogLevel("tvx/extract", "warn")
+		}
+		log.SetOutput(display)
+		defer log.SetOutput(logOutput)
+	}
+	stop := display.run()
+
+	results := make([]extractManyResult, len(jobs))
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := range idx {
+				results[i] = runExtractManyJob(w, jobs[i], display)
+			}
+		}(w)
+	}
+	for i := range jobs {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+	stop()
+
+	return summarizeExtractMany(header, results, extractManyFlags.failures)
+}
+
+// readExtractManyJobs reads the header and the extraction jobs of the rows of
+// the CSV input, whose vectors are written under outdir.
+func readExtractManyJobs(r io.Reader, batchID, outdir string) ([]string, []*extractManyJob, error) {
 	// Create a CSV reader and validate the header row.
-	reader := csv.NewReader(f)
-	if header, err := reader.Read(); err != nil {
-		return fmt.Errorf("failed to read header from csv: %w", err)
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read header from csv: %w", err)
 	} else if l := len(header); l < 7 {
-		return fmt.Errorf("insufficient number of fields: %d", l)
+		return nil, nil, fmt.Errorf("insufficient number of fields: %d", l)
 	} else if f := header[0]; f != "message_cid" {
-		return fmt.Errorf("csv sanity check failed: expected first field in header to be 'message_cid'; was: %s", f)
+		return nil, nil, fmt.Errorf("csv sanity check failed: expected first field in header to be 'message_cid'; was: %s", f)
 	} else {
 		log.Println(color.GreenString("csv sanity check succeeded; header contains fields: %v", header))
 	}
 
 	codeCidBuilder := cid.V1Builder{Codec: cid.Raw, MhType: multihash.IDENTITY}
 
-	var (
-		generated []string
-		merr      = new(multierror.Error)
-		retry     []extractOpts // to retry with 'canonical' precursor selection mode
-	)
-
-	// Read each row and extract the requested message.
+	// Read each row and compute the extraction of the requested message.
+	var jobs []*extractManyJob
 	for {
 		row, err := reader.Read()
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return fmt.Errorf("failed to read row: %w", err)
+			return nil, nil, fmt.Errorf("failed to read row: %w", err)
 		}
 		var (
 			mcid         = row[0]
@@ -146,23 +328,23 @@ func runExtractMany(c *cli.Context) error {
 
 		// Parse the exit code.
 		if exit, err = strconv.Atoi(exitcodestr); err != nil {
-			return fmt.Errorf("invalid exitcode number: %d", exit)
+			return nil, nil, fmt.Errorf("invalid exitcode number: %d", exit)
 		}
 		// Parse the method number.
 		if methodnum, err = strconv.Atoi(methodnumstr); err != nil {
-			return fmt.Errorf("invalid method number: %s", methodnumstr)
+			return nil, nil, fmt.Errorf("invalid method number: %s", methodnumstr)
 		}
 
 		codeCid, err := codeCidBuilder.Sum([]byte(actorcode))
 		if err != nil {
-			return fmt.Errorf("failed to compute actor code CID")
+			return nil, nil, fmt.Errorf("failed to compute actor code CID")
 		}
 
 		// Lookup the method in actor method table.
 		if m, ok := filcns.NewActorRegistry().Methods[codeCid]; !ok {
-			return fmt.Errorf("unrecognized actor: %s", actorcode)
+			return nil, nil, fmt.Errorf("unrecognized actor: %s", actorcode)
 		} else if methodnum >= len(m) {
-			return fmt.Errorf("unrecognized method number for actor %s: %d", actorcode, methodnum)
+			return nil, nil, fmt.Errorf("unrecognized method number for actor %s: %d", actorcode, methodnum)
 		} else {
 			methodname = m[abi.MethodNum(methodnum)].Name
 		}
@@ -174,62 +356,146 @@ func runExtractMany(c *cli.Context) error {
 		actorcodename := strings.ReplaceAll(actorcode, "/", "_")
 
 		// Compute the ID of the vector.
-		id := fmt.Sprintf("ext-%s-%s-%s-%s-%s", extractManyFlags.batchId, actorcodename, methodname, exitcodename, seq)
+		id := fmt.Sprintf("ext-%s-%s-%s-%s-%s", batchID, actorcodename, methodname, exitcodename, seq)
 		// Vector filename, using a base of outdir.
 		file := filepath.Join(outdir, actorcodename, methodname, exitcodename, id) + ".json"
 
-		log.Println(color.YellowString("processing message cid with 'participants' precursor mode: %s", id))
-
-		opts := extractOpts{
-			id:        id,
-			block:     block,
-			class:     "message",
-			cid:       mcid,
-			file:      file,
-			retain:    "accessed-cids",
-			precursor: PrecursorSelectParticipants,
-		}
+		jobs = append(jobs, &extractManyJob{
+			row: row,
+			opts: extractOpts{
+				id:        id,
+				block:     block,
+				class:     "message",
+				cid:       mcid,
+				file:      file,
+				retain:    "accessed-cids",
+				precursor: extractor.PrecursorSelectParticipants,
+				light:     extractManyFlags.light,
+				// sanity check failures on gas are retried with more
+				// precursors by the extractor itself.
+				escalatePrecursors: true,
+			},
+		})
+	}
+	return header, jobs, nil
+}
 
-		if err := doExtractMessage(opts); err != nil {
-			log.Println(color.RedString("failed to extract vector for message %s: %s; queuing for 'all' precursor selection", mcid, err))
-			retry = append(retry, opts)
-			continue
+// runExtractManyJob runs the extraction job on the worker, with the
+// 'participants' precursor selection mode first, and the 'all' mode if that
+// fails for any reason but a sanity check, on which the extractor escalates
+// the precursors itself. Light vectors apply no precursors, so they're not
+// retried.
+func runExtractManyJob(w int, job *extractManyJob, display *batchDisplay) (res extractManyResult) {
+	res.job = job
+	defer func() { display.done(w, res) }()
+
+	opts := job.opts
+	if extractManyFlags.skipExisting {
+		if _, err := os.Stat(opts.file); err == nil {
+			res.skipped = true
+			return res
 		}
+	}
+	opts.onEvent = func(event string, kvs ...interface{}) { display.event(w, event, kvs...) }
 
-		log.Println(color.MagentaString("generated file: %s", file))
-
-		generated = append(generated, file)
+	display.start(w, opts.id, opts.precursor)
+	if res.err = doExtractMessage(opts); res.err == nil {
+		log.Println(color.MagentaString("generated file: %s", opts.file))
+		return res
+	}
+	if opts.light || errors.Is(res.err, extractor.ErrSanityCheckFailed) {
+		res.err = fmt.Errorf("failed to extract vector for message %s: %w", opts.cid, res.err)
+		return res
 	}
+	log.Println(color.RedString("failed to extract vector for message %s: %s; retrying with 'all' precursor selection", opts.cid, res.err))
 
-	log.Printf("extractions to try with 'all' precursor selection mode: %d", len(retry))
+	opts.precursor = extractor.PrecursorSelectAll
+	display.start(w, opts.id, opts.precursor)
+	if res.err = doExtractMessage(opts); res.err != nil {
+		res.err = fmt.Errorf("failed to extract vector for message %s: %w", opts.cid, res.err)
+		return res
+	}
+	log.Println(color.MagentaString("generated file: %s", opts.file))
+	return res
+}
 
-	for _, r := range retry {
-		log.Printf("retrying %s: %s", r.cid, r.id)
+// failureReasonField is the field of the failures file of tvx extract-many
+// holding the reason of the failures, after those of the input.
+const failureReasonField = "failure_reason"
 
-		r.precursor = PrecursorSelectAll
-		if err := doExtractMessage(r); err != nil {
-			merr = multierror.Append(merr, fmt.Errorf("failed to extract vector for message %s: %w", r.cid, err))
-			continue
+// summarizeExtractMany logs the summary of the outcomes of the extractions,
+// and writes the rows of the failed ones to the failures file, if supplied,
+// with the header of the input, so that it can be fed back in. It returns the
+// errors of the failed extractions.
+func summarizeExtractMany(header []string, results []extractManyResult, failures string) error {
+	var (
+		merr      = new(multierror.Error)
+		succeeded int
+		skipped   int
+		failed    []extractManyResult
+	)
+	for _, r := range results {
+		switch {
+		case r.skipped:
+			skipped++
+		case r.err != nil:
+			failed = append(failed, r)
+			merr = multierror.Append(merr, r.err)
+		default:
+			succeeded++
 		}
+	}
 
-		log.Println(color.MagentaString("generated file: %s", r.file))
-		generated = append(generated, r.file)
+	log.Printf("extracted %d messages: %d succeeded, %d failed, %d skipped", len(results), succeeded, len(failed), skipped)
+	for _, r := range failed {
+		log.Println(color.RedString("  %s: %s", r.job.opts.id, r.err))
 	}
 
-	if len(generated) == 0 {
-		log.Println("no files generated")
-	} else {
-		log.Println("files generated:")
-		for _, g := range generated {
-			log.Println(g)
+	if failures != "" {
+		if err := writeExtractManyFailures(failures, header, failed); err != nil {
+			return err
 		}
+		log.Printf("wrote %d failed rows to %s", len(failed), failures)
 	}
 
 	if merr.ErrorOrNil() != nil {
-		log.Println(color.YellowString("done processing with errors: %v", merr))
+		log.Println(color.YellowString("done processing with errors"))
 	} else {
 		log.Println(color.GreenString("done processing with no errors"))
 	}
-
 	return merr.ErrorOrNil()
 }
+
+// writeExtractManyFailures writes the rows of the failed extractions to the
+// file, as CSV, with the header of the input, and their reasons in the
+// failureReasonField field, replacing those of a previous run.
+func writeExtractManyFailures(path string, header []string, failed []extractManyResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create failures file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	fields := len(header)
+	if header[fields-1] == failureReasonField {
+		fields--
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write(append(header[:fields:fields], failureReasonField)); err != nil {
+		return err
+	}
+	for _, r := range failed {
+		row := r.job.row
+		if len(row) > fields {
+			row = row[:fields]
+		}
+		if err := w.Write(append(row[:len(row):len(row)], r.err.Error())); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write failures file: %w", err)
+	}
+	return f.Close()
+}
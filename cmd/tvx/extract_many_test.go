@@ -0,0 +1,85 @@
+// stm: #unit
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtractManyFailuresFile(t *testing.T) {
+	header := []string{"message_cid", "receiver_code", "method_num", "exit_code", "height", "block_cid", "seq"}
+	rows := [][]string{
+		{"bafy1", "fil/1/account", "0", "0", "10", "bafyblock", "1"},
+		{"bafy2", "fil/1/account", "0", "0", "11", "bafyblock", "2"},
+	}
+	failed := []extractManyResult{
+		{job: &extractManyJob{row: rows[1]}, err: errors.New("boom, with a comma")},
+	}
+
+	read := func(path string) [][]string {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close() //nolint:errcheck
+		records, err := csv.NewReader(f).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+
+	path := filepath.Join(t.TempDir(), "failures.csv")
+	if err := writeExtractManyFailures(path, header, failed); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		append(append([]string{}, header...), failureReasonField),
+		append(append([]string{}, rows[1]...), "boom, with a comma"),
+	}
+	got := read(path)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected failures %v, got %v", want, got)
+	}
+
+	// the failures file of a run fed back in replaces the previous reasons.
+	failed[0].job.row = got[1]
+	failed[0].err = errors.New("boom again")
+	if err := writeExtractManyFailures(path, got[0], failed); err != nil {
+		t.Fatal(err)
+	}
+	want[1][len(want[1])-1] = "boom again"
+	if got := read(path); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected failures %v, got %v", want, got)
+	}
+}
+
+func TestBatchDisplayRender(t *testing.T) {
+	d := newBatchDisplay(nil, 2, 4)
+	now := time.Now()
+	d.start(0, "ext-0001-fil_1_account-Send-Ok-1", "participants")
+	d.workers[0].started = now.Add(-3 * time.Second)
+	d.event(0, "precursor_applied", "index", 1, "total", 5, "cid", "bafy")
+	d.done(1, extractManyResult{})
+	d.done(1, extractManyResult{err: errors.New("boom")})
+
+	lines := d.render(now)
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d: %v", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], "2/4: 1 succeeded, 1 failed, 0 skipped") {
+		t.Errorf("unexpected progress line: %s", lines[0])
+	}
+	if want := "  worker 1: ext-0001-fil_1_account-Send-Ok-1 (participants) 3s, precursor_applied 2/5"; lines[1] != want {
+		t.Errorf("expected worker line %q, got %q", want, lines[1])
+	}
+	if want := "  worker 2: idle"; lines[2] != want {
+		t.Errorf("expected worker line %q, got %q", want, lines[2])
+	}
+}
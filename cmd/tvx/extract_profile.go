@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/datacap"
+	init_ "github.com/filecoin-project/lotus/chain/actors/builtin/init"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/market"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/power"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/reward"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/verifreg"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// extractProfile bundles the extraction settings that yield replayable
+// vectors for the messages of an actor family, as selected by tvx extract
+// --profile.
+type extractProfile struct {
+	// retain is the state retention policy.
+	retain string
+	// precursor is the precursor selection mode.
+	precursor string
+	// retainActors are the actors to retain besides those accessed.
+	retainActors []address.Address
+	// recordSyscalls records the outcomes of the verification syscalls.
+	recordSyscalls bool
+}
+
+// extractProfiles are the profiles of tvx extract, by actor family.
+//
+// Miner, market and power messages interact with the messages of other
+// parties in the tipset through the power and market actors, so all
+// precursors are applied; the outcomes of the proof and signature
+// verifications of miner, market and payment channel messages are recorded,
+// for the vectors to replay without proof parameters or the signers' keys.
+var extractProfiles = map[string]extractProfile{
+	"miner": {
+		retain:         "accessed-cids",
+		precursor:      extractor.PrecursorSelectAll,
+		retainActors:   []address.Address{power.Address, reward.Address, market.Address, builtin.BurntFundsActorAddr},
+		recordSyscalls: true,
+	},
+	"market": {
+		retain:         "accessed-cids",
+		precursor:      extractor.PrecursorSelectAll,
+		retainActors:   []address.Address{power.Address, reward.Address, verifreg.Address, datacap.Address},
+		recordSyscalls: true,
+	},
+	"msig": {
+		retain:       "accessed-cids",
+		precursor:    extractor.PrecursorSelectParticipants,
+		retainActors: []address.Address{init_.Address},
+	},
+	"paych": {
+		retain:         "accessed-cids",
+		precursor:      extractor.PrecursorSelectParticipants,
+		retainActors:   []address.Address{init_.Address},
+		recordSyscalls: true,
+	},
+	"power": {
+		retain:       "accessed-cids",
+		precursor:    extractor.PrecursorSelectAll,
+		retainActors: []address.Address{init_.Address, reward.Address, builtin.CronActorAddr},
+	},
+}
+
+// extractProfileNames returns the names of the profiles, sorted.
+func extractProfileNames() []string {
+	names := make([]string, 0, len(extractProfiles))
+	for name := range extractProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyExtractProfile applies the named profile to the options, but for the
+// settings set explicitly, as reported by isSet for the flag names; the
+// actors the profile retains are added to those requested.
+func applyExtractProfile(name string, opts *extractOpts, isSet func(flag string) bool) error {
+	if name == "" {
+		return nil
+	}
+	p, ok := extractProfiles[name]
+	if !ok {
+		return fmt.Errorf("unknown extraction profile %q; values: %s", name, strings.Join(extractProfileNames(), ", "))
+	}
+	if !isSet("state-retain") {
+		opts.retain = p.retain
+	}
+	if !isSet("precursor-select") {
+		opts.precursor = p.precursor
+	}
+	if !isSet("record-syscalls") {
+		opts.recordSyscalls = p.recordSyscalls
+	}
+	opts.retainActors = append(opts.retainActors, p.retainActors...)
+	return nil
+}
@@ -0,0 +1,40 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/power"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+func TestApplyExtractProfile(t *testing.T) {
+	none := func(string) bool { return false }
+
+	opts := extractOpts{retain: "accessed-actors", precursor: extractor.PrecursorSelectParticipants}
+	if err := applyExtractProfile("miner", &opts, none); err != nil {
+		t.Fatal(err)
+	}
+	if opts.retain != "accessed-cids" || opts.precursor != extractor.PrecursorSelectAll || !opts.recordSyscalls {
+		t.Errorf("unexpected options of the miner profile: %+v", opts)
+	}
+	if len(opts.retainActors) == 0 || opts.retainActors[0] != power.Address {
+		t.Errorf("unexpected retained actors: %v", opts.retainActors)
+	}
+
+	// flags set explicitly take precedence.
+	opts = extractOpts{retain: "accessed-actors", precursor: extractor.PrecursorSelectParticipants}
+	if err := applyExtractProfile("power", &opts, func(flag string) bool { return flag == "precursor-select" }); err != nil {
+		t.Fatal(err)
+	}
+	if opts.retain != "accessed-cids" || opts.precursor != extractor.PrecursorSelectParticipants {
+		t.Errorf("unexpected options of the power profile: %+v", opts)
+	}
+
+	if err := applyExtractProfile("", &opts, none); err != nil {
+		t.Errorf("unexpected error without a profile: %s", err)
+	}
+	if err := applyExtractProfile("reward", &opts, none); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
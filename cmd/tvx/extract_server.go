@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// doExtractServerSide extracts a message vector in the node, through the
+// TvxExtractMessage API method, rather than fetching the state it accesses
+// object by object, and writes it. The node must enable the method with
+// Tvx.EnableExtract in its config.
+func doExtractServerSide(c *cli.Context, opts extractOpts) error {
+	switch {
+	case opts.class != string(schema.ClassMessage):
+		return fmt.Errorf("--server-side only supports the 'message' class")
+	case opts.cid == "" || opts.url != "" || isURL(opts.cid):
+		return fmt.Errorf("--server-side requires a message CID supplied with --cid")
+	case repoDirect:
+		return fmt.Errorf("--server-side and --repo-direct are mutually exclusive")
+	}
+	msg, err := cid.Decode(opts.cid)
+	if err != nil {
+		return fmt.Errorf("failed to parse message CID %s: %w", opts.cid, err)
+	}
+	if len(opts.retainActors) > 0 {
+		log.Printf("extraction on the node retains the accessed state only; ignoring the %d actors to retain", len(opts.retainActors))
+	}
+	if opts.precursorLookback > 0 {
+		log.Printf("extraction on the node applies the precursors of the inclusion tipset only; ignoring --precursor-lookback")
+	}
+	xopts := api.TvxExtractOpts{
+		ID:                 opts.id,
+		Retain:             opts.retain,
+		Precursor:          opts.precursor,
+		EmbedPrecursors:    opts.embedPrecursors,
+		IgnorePrecursors:   opts.ignorePrecursors,
+		MaxPrecursors:      opts.maxPrecursors,
+		Selectors:          opts.selectors,
+		Hints:              opts.hints,
+		RecordSyscalls:     opts.recordSyscalls,
+		IgnoreSanityChecks: opts.ignoreSanityChecks,
+		Force:              opts.force,
+	}
+	if opts.block != "" {
+		if xopts.Block, err = cid.Decode(opts.block); err != nil {
+			return fmt.Errorf("failed to parse block CID %s: %w", opts.block, err)
+		}
+	}
+
+	node, closer, err := lcli.GetFullNodeAPIV1(c)
+	if err != nil {
+		return fmt.Errorf("failed to locate Lotus node; err: %w", err)
+	}
+	defer closer()
+
+	events, err := node.TvxExtractMessage(c.Context, msg, xopts)
+	if err != nil {
+		return fmt.Errorf("failed to start server-side extraction: %w", err)
+	}
+	var data bytes.Buffer
+	for e := range events {
+		switch e.Event {
+		case "vector":
+			data.Write(e.Data)
+		case "error":
+			return fmt.Errorf("server-side extraction failed: %s", e.Error)
+		default:
+			kvs := make([]interface{}, 0, 2*len(e.Fields))
+			for k, v := range e.Fields {
+				kvs = append(kvs, k, v)
+			}
+			progress.emit(e.Event, kvs...)
+		}
+	}
+	if data.Len() == 0 {
+		return fmt.Errorf("server-side extraction ended without a vector")
+	}
+
+	var vector schema.TestVector
+	if err := json.Unmarshal(data.Bytes(), &vector); err != nil {
+		return fmt.Errorf("failed to decode vector: %w", err)
+	}
+	// the node doesn't take tags; they're added to the vector here.
+	if vector.Meta != nil {
+		vector.Meta.Tags = conformance.MergeTags(vector.Meta.Tags, opts.tags...)
+	}
+	return writeVector(&vector, opts.file)
+}
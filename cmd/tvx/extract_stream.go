@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// isStdout returns whether the output designates stdout: empty, or "-".
+func isStdout(output string) bool {
+	return output == "" || output == "-"
+}
+
+// streamVector writes the vector to w as a single line of JSON, the format in
+// which extractions yielding several vectors write them to stdout. tvx exec
+// and tvx exec --stdin execute each vector of the stream as soon as it's
+// written, so that vectors are verified while the extraction proceeds,
+// without an intermediate directory:
+//
+//	tvx extract --from-epoch 100 --to-epoch 200 -o - | tvx exec
+//
+// The vector is post-processed and signed first, as by writeVector.
+func streamVector(w io.Writer, vector *schema.TestVector) (err error) {
+	if len(postProcessors) > 0 {
+		if vector, err = postProcessVector(vector); err != nil || vector == nil {
+			return err
+		}
+	}
+	if signer != nil {
+		if err := signVector(context.TODO(), signer, vector); err != nil {
+			return fmt.Errorf("failed to sign vector: %w", err)
+		}
+	}
+	data, err := json.Marshal(vector)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	progress.emit(EventVectorWritten, "id", vector.Meta.ID, "file", "-")
+	return nil
+}
+
+// streamExtractMessage extracts a message vector, as requested in the
+// options, and streams it to stdout; see streamVector.
+func streamExtractMessage(opts extractOpts) error {
+	opts.class = string(schema.ClassMessage)
+	vector, err := extractor.Extract(context.Background(), FullAPI, opts.extractorOptions())
+	if err != nil {
+		return err
+	}
+	return streamVector(os.Stdout, vector)
+}
@@ -0,0 +1,56 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestStreamVector(t *testing.T) {
+	var buf bytes.Buffer
+	for _, id := range []string{"a", "b"} {
+		tv := &schema.TestVector{
+			Class: schema.ClassMessage,
+			Meta:  &schema.Metadata{ID: id, Comment: "multi\nline"},
+		}
+		if err := streamVector(&buf, tv); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a vector per line.
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines; got %d: %q", len(lines), buf.String())
+	}
+
+	// consumed as tvx exec reads stdin.
+	var ids []string
+	for dec := json.NewDecoder(&buf); ; {
+		var tv schema.TestVector
+		err := dec.Decode(&tv)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, tv.Meta.ID)
+	}
+	if strings.Join(ids, ",") != "a,b" {
+		t.Errorf("expected vectors a, b; got %v", ids)
+	}
+
+	for _, out := range []string{"", "-"} {
+		if !isStdout(out) {
+			t.Errorf("expected %q to designate stdout", out)
+		}
+	}
+	if isStdout("vectors/") {
+		t.Error("expected a directory not to designate stdout")
+	}
+}
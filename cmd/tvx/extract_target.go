@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// hasRelativeTarget returns whether the options address the target of the
+// extraction by height, rather than by CID or tipset key.
+func (o *extractOpts) hasRelativeTarget() bool {
+	return o.height != 0 || o.epochsAgo != 0
+}
+
+// resolveRelativeTarget resolves the message CID and block, or the tipset
+// key, of an extraction addressed by height, with --height or --epochs-ago,
+// and for message vectors, the position of the message among the messages of
+// the inclusion tipset, with --msg-index. Scripted jobs, e.g. daily harvests,
+// thus extract vectors without knowing CIDs in advance.
+func (o *extractOpts) resolveRelativeTarget(ctx context.Context) error {
+	if !o.hasRelativeTarget() {
+		return nil
+	}
+	if o.cid != "" || o.url != "" || o.tsk != "" || o.block != "" {
+		return fmt.Errorf("--height, --epochs-ago and --msg-index are mutually exclusive with --cid, --url, --tsk and --block")
+	}
+
+	head, err := FullAPI.ChainHead(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get chain head: %w", err)
+	}
+	h, err := targetHeight(head.Height(), o.height, o.epochsAgo)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case o.message != nil:
+		// the message supplied is applied on the tipset at the height.
+		if o.msgIndex >= 0 {
+			return fmt.Errorf("--msg-index doesn't apply to messages supplied with --msg-file")
+		}
+		o.tsk = fmt.Sprintf("@%d", h)
+		log.Printf("applying the supplied message on the tipset at epoch %d", h)
+
+	case o.class == string(schema.ClassMessage):
+		if o.msgIndex < 0 {
+			return fmt.Errorf("message vectors addressed by height require --msg-index")
+		}
+		// if h is a null round, the messages of the preceding tipset are
+		// selected from.
+		incTs, err := FullAPI.ChainGetTipSetByHeight(ctx, h, head.Key())
+		if err != nil {
+			return fmt.Errorf("%w: failed to get tipset at epoch %d: %s", extractor.ErrTipsetUnavailable, h, err)
+		}
+		if incTs.Height() != h {
+			log.Printf("epoch %d is a null round; selecting from the messages of the tipset at epoch %d", h, incTs.Height())
+		}
+		execTs, err := extractor.FindExecutionTipset(ctx, FullAPI, incTs.Height())
+		if err != nil {
+			return err
+		}
+		msgs, err := FullAPI.ChainGetParentMessages(ctx, execTs.Blocks()[0].Cid())
+		if err != nil {
+			return fmt.Errorf("failed to get messages included at epoch %d: %w", incTs.Height(), err)
+		}
+		if o.msgIndex >= len(msgs) {
+			return fmt.Errorf("%w: --msg-index %d out of range; the tipset at epoch %d includes %d messages", extractor.ErrMessageNotFound, o.msgIndex, incTs.Height(), len(msgs))
+		}
+		o.cid = msgs[o.msgIndex].Cid.String()
+		o.block = incTs.Cids()[0].String()
+		log.Printf("message %d of the tipset at epoch %d: %s", o.msgIndex, incTs.Height(), o.cid)
+
+	case o.class == string(schema.ClassTipset), o.class == string(schema.ClassBlockSeq), o.class == extractor.ClassImplicit:
+		if o.msgIndex >= 0 {
+			return fmt.Errorf("--msg-index only applies to message vectors")
+		}
+		o.tsk = fmt.Sprintf("@%d", h)
+		log.Printf("extracting the tipset at epoch %d", h)
+
+	default:
+		return fmt.Errorf("%s vectors can't be addressed by height", o.class)
+	}
+	return nil
+}
+
+// targetHeight returns the height addressed by --height or --epochs-ago,
+// which are mutually exclusive, given that of the head.
+func targetHeight(head abi.ChainEpoch, height, epochsAgo int64) (abi.ChainEpoch, error) {
+	switch {
+	case height != 0 && epochsAgo != 0:
+		return 0, fmt.Errorf("--height and --epochs-ago are mutually exclusive")
+	case height < 0 || epochsAgo < 0:
+		return 0, fmt.Errorf("--height and --epochs-ago must be positive")
+	case epochsAgo != 0:
+		h := head - abi.ChainEpoch(epochsAgo)
+		if h < 0 {
+			return 0, fmt.Errorf("--epochs-ago %d precedes genesis (head at epoch %d)", epochsAgo, head)
+		}
+		return h, nil
+	default:
+		if abi.ChainEpoch(height) > head {
+			return 0, fmt.Errorf("%w: epoch %d not reached yet (head at epoch %d)", extractor.ErrTipsetUnavailable, height, head)
+		}
+		return abi.ChainEpoch(height), nil
+	}
+}
+
+// readMessageFile reads the message supplied with --msg-file, not on chain:
+// a message or a signed message, JSON-encoded, as the Lotus API and wallets
+// encode them, or CBOR-encoded, raw. The signature of signed messages is
+// dropped, as vectors apply unsigned messages.
+func readMessageFile(path string) (*types.Message, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message file: %w", err)
+	}
+	msg, err := decodeMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message file %s: %w", path, err)
+	}
+	return msg, nil
+}
+
+// decodeMessage decodes a message, or a signed message, in JSON or CBOR.
+func decodeMessage(data []byte) (*types.Message, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var signed struct {
+			Message *types.Message
+		}
+		if err := json.Unmarshal(trimmed, &signed); err != nil {
+			return nil, err
+		}
+		if signed.Message != nil {
+			return signed.Message, nil
+		}
+		var msg types.Message
+		if err := json.Unmarshal(trimmed, &msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	}
+
+	msg, err := types.DecodeMessage(data)
+	if err == nil {
+		return msg, nil
+	}
+	signed, serr := types.DecodeSignedMessage(data)
+	if serr != nil {
+		return nil, fmt.Errorf("neither a JSON nor a CBOR message: %w", err)
+	}
+	return &signed.Message, nil
+}
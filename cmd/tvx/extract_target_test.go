@@ -0,0 +1,91 @@
+// stm: #unit
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+func TestTargetHeight(t *testing.T) {
+	for _, tc := range []struct {
+		height, epochsAgo int64
+		expected          abi.ChainEpoch
+	}{
+		{height: 1000, expected: 1000},
+		{height: 5000, expected: 5000},
+		{epochsAgo: 2880, expected: 2120},
+		{epochsAgo: 5000, expected: 0},
+	} {
+		h, err := targetHeight(5000, tc.height, tc.epochsAgo)
+		if err != nil {
+			t.Errorf("height %d, epochs ago %d: %s", tc.height, tc.epochsAgo, err)
+			continue
+		}
+		if h != tc.expected {
+			t.Errorf("height %d, epochs ago %d: expected %d; got %d", tc.height, tc.epochsAgo, tc.expected, h)
+		}
+	}
+
+	if _, err := targetHeight(5000, 1000, 2880); err == nil {
+		t.Error("expected --height and --epochs-ago to be mutually exclusive")
+	}
+	if _, err := targetHeight(5000, 0, 5001); err == nil {
+		t.Error("expected an epoch preceding genesis to fail")
+	}
+	if _, err := targetHeight(5000, 5001, 0); !errors.Is(err, extractor.ErrTipsetUnavailable) {
+		t.Errorf("expected an epoch not reached yet to be unavailable; got %v", err)
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	to, _ := address.NewIDAddress(1000)
+	from, _ := address.NewIDAddress(1001)
+	msg := &types.Message{To: to, From: from, Nonce: 7, Value: big.NewInt(10), GasLimit: 1000, GasFeeCap: big.NewInt(1), GasPremium: big.NewInt(1), Method: 2}
+
+	encoded, err := msg.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := &types.SignedMessage{Message: *msg, Signature: crypto.Signature{Type: crypto.SigTypeSecp256k1, Data: []byte{1}}}
+	encodedSigned, err := signed.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asJSON, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedJSON, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{
+		"cbor":        encoded,
+		"signed cbor": encodedSigned,
+		"json":        asJSON,
+		"signed json": signedJSON,
+	} {
+		got, err := decodeMessage(data)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if got.Cid() != msg.Cid() {
+			t.Errorf("%s: expected message %s; got %s", name, msg.Cid(), got.Cid())
+		}
+	}
+
+	if _, err := decodeMessage([]byte("not a message")); err == nil {
+		t.Error("expected garbage to fail to decode")
+	}
+}
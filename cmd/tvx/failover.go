@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-jsonrpc"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/client"
+	"github.com/filecoin-project/lotus/api/v0api"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+)
+
+var apiFlag = cli.StringSliceFlag{
+	Name: "api",
+	Usage: "JSON-RPC API endpoint of a full node, in [token:]multiaddr or [token:]URL form, overriding FULLNODE_API_INFO and --repo; " +
+		"can be repeated, to fail over across endpoints when one is unreachable or rate-limits, and to spread block fetches across them",
+}
+
+// failoverCooldown is how long an endpoint is skipped for after it failed,
+// unless all other endpoints failed too.
+const failoverCooldown = 30 * time.Second
+
+// balancedMethods are the methods whose calls are spread across endpoints in
+// round-robin order: those fetching immutable, content-addressed data, which
+// any endpoint serves identically. Other calls go to the first available
+// endpoint, so that they observe a consistent chain.
+var balancedMethods = map[string]bool{
+	"ChainReadObj":          true,
+	"ChainReadObjMany":      true,
+	"ChainHasObj":           true,
+	"ChainStatObj":          true,
+	"ChainGetBlock":         true,
+	"ChainGetBlockMessages": true,
+	"ChainGetMessage":       true,
+}
+
+// failoverErrors are the errors on which calls fail over to the next
+// endpoint: transport errors, rate limiting included, as opposed to errors
+// returned by the node.
+var failoverErrors = []error{new(jsonrpc.RPCConnectionError), new(jsonrpc.ErrClient)}
+
+// endpoint is a full node endpoint of a failoverAPI.
+type endpoint struct {
+	addr string
+	api  v0api.FullNode
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func (e *endpoint) available(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !now.Before(e.downUntil)
+}
+
+func (e *endpoint) markDown(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.downUntil = now.Add(failoverCooldown)
+}
+
+// failoverAPI dispatches the calls of a full node API across endpoints.
+type failoverAPI struct {
+	endpoints []*endpoint
+	// next is the round-robin cursor of the balanced methods.
+	next uint32
+}
+
+// newFailoverAPI returns a full node API failing over across the endpoints,
+// tried in the order supplied.
+func newFailoverAPI(endpoints []*endpoint) v0api.FullNode {
+	f := &failoverAPI{endpoints: endpoints}
+	ins := make([]v0api.FullNode, len(endpoints))
+	for i, e := range endpoints {
+		ins[i] = e.api
+	}
+	return proxyFullNode(ins, f.call)
+}
+
+// proxyFullNode returns a full node API dispatching every call through
+// dispatch, with the name of the method, its implementations by the supplied
+// APIs, and the arguments of the call.
+func proxyFullNode(ins []v0api.FullNode, dispatch func(method string, fns []reflect.Value, args []reflect.Value) []reflect.Value) v0api.FullNode {
+	var out v0api.FullNodeStruct
+	for _, internal := range api.GetInternalStructs(&out) {
+		rv := reflect.ValueOf(internal).Elem()
+		for i := 0; i < rv.NumField(); i++ {
+			name := rv.Type().Field(i).Name
+			fns := make([]reflect.Value, len(ins))
+			for j, in := range ins {
+				fns[j] = reflect.ValueOf(in).MethodByName(name)
+			}
+			rv.Field(i).Set(reflect.MakeFunc(rv.Field(i).Type(), func(args []reflect.Value) []reflect.Value {
+				return dispatch(name, fns, args)
+			}))
+		}
+	}
+	return &out
+}
+
+// order returns the order in which to try the endpoints for a call:
+// available endpoints first, starting with the first one, or the next one in
+// round-robin order for balanced methods, then those cooling down.
+func (f *failoverAPI) order(balanced bool) []int {
+	var (
+		n     = len(f.endpoints)
+		start = 0
+		now   = time.Now()
+		up    = make([]int, 0, n)
+		down  []int
+	)
+	if balanced {
+		start = int(atomic.AddUint32(&f.next, 1) % uint32(n))
+	}
+	for k := 0; k < n; k++ {
+		i := (start + k) % n
+		if f.endpoints[i].available(now) {
+			up = append(up, i)
+		} else {
+			down = append(down, i)
+		}
+	}
+	return append(up, down...)
+}
+
+func (f *failoverAPI) call(method string, fns []reflect.Value, args []reflect.Value) []reflect.Value {
+	var results []reflect.Value
+	for k, i := range f.order(balancedMethods[method]) {
+		results = fns[i].Call(args)
+		err, _ := results[len(results)-1].Interface().(error)
+		if err == nil || !api.ErrorIsIn(err, failoverErrors) {
+			return results
+		}
+		if ctx, ok := args[0].Interface().(context.Context); ok && ctx.Err() != nil {
+			return results
+		}
+		f.endpoints[i].markDown(time.Now())
+		if k < len(f.endpoints)-1 {
+			extractLog.Warnw("endpoint failed; failing over", "endpoint", f.endpoints[i].addr, "method", method, "error", err)
+		}
+	}
+	return results
+}
+
+// dialEndpoints connects to the full node endpoints, in [token:]multiaddr or
+// [token:]URL form, returning an API failing over across those reachable.
+func dialEndpoints(ctx context.Context, specs []string) (v0api.FullNode, jsonrpc.ClientCloser, error) {
+	var (
+		endpoints []*endpoint
+		closers   []jsonrpc.ClientCloser
+	)
+	for _, spec := range specs {
+		info := cliutil.ParseApiInfo(spec)
+		addr, err := info.DialArgs("v0")
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid API endpoint %s: %w", info.Addr, err)
+		}
+		fapi, closer, err := client.NewFullNodeRPCV0(ctx, addr, info.AuthHeader())
+		if err != nil {
+			extractLog.Warnw("failed to connect to endpoint; skipping", "endpoint", addr, "error", err)
+			continue
+		}
+		endpoints = append(endpoints, &endpoint{addr: addr, api: fapi})
+		closers = append(closers, closer)
+	}
+
+	closer := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	switch len(endpoints) {
+	case 0:
+		return nil, nil, fmt.Errorf("failed to connect to any of the %d API endpoints", len(specs))
+	case 1:
+		return endpoints[0].api, closer, nil
+	default:
+		return newFailoverAPI(endpoints), closer, nil
+	}
+}
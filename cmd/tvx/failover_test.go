@@ -0,0 +1,68 @@
+// stm: #unit
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/lotus/api/client"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// countingAPI counts the calls it serves.
+type countingAPI struct {
+	v0api.FullNode
+
+	reads, heads int
+}
+
+func (a *countingAPI) ChainReadObj(context.Context, cid.Cid) ([]byte, error) {
+	a.reads++
+	return []byte{0x80}, nil
+}
+
+func (a *countingAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	a.heads++
+	return nil, fmt.Errorf("no head")
+}
+
+func TestFailoverAPI(t *testing.T) {
+	ctx := context.Background()
+
+	// nothing listens on the discard port.
+	unreachable, closer, err := client.NewFullNodeRPCV0(ctx, "http://127.0.0.1:9/rpc/v0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	a, b := new(countingAPI), new(countingAPI)
+	fapi := newFailoverAPI([]*endpoint{
+		{addr: "unreachable", api: unreachable},
+		{addr: "a", api: a},
+		{addr: "b", api: b},
+	})
+
+	// fails over from the unreachable endpoint, which then cools down.
+	for i := 0; i < 4; i++ {
+		if _, err := fapi.ChainReadObj(ctx, cid.Undef); err != nil {
+			t.Fatalf("call %d didn't fail over: %s", i, err)
+		}
+	}
+	if a.reads == 0 || b.reads == 0 || a.reads+b.reads != 4 {
+		t.Errorf("block fetches not spread across endpoints: %d and %d", a.reads, b.reads)
+	}
+
+	// errors returned by the node aren't failed over, and unbalanced calls
+	// go to the first available endpoint.
+	if _, err := fapi.ChainHead(ctx); err == nil {
+		t.Fatal("expected the error of the node")
+	}
+	if a.heads != 1 || b.heads != 0 {
+		t.Errorf("unexpected dispatch of unbalanced calls: %d and %d", a.heads, b.heads)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// gasBaselineVersion is the version of the format of gas baseline files.
+const gasBaselineVersion = 1
+
+// gasBaselineFile is the format of gas baseline files: the gas used by every
+// vector, by ID, as the total gas used by the top-level messages it applies,
+// implicit ones included, across its variants.
+type gasBaselineFile struct {
+	Version int              `json:"version"`
+	Vectors map[string]int64 `json:"vectors"`
+}
+
+// gasDriftBuckets are the buckets of the gas drift histogram, by their
+// bounds, in percent of the baseline gas, away from 0: drifts fall in the
+// first bucket whose bound they don't exceed, and unchanged vectors in a
+// bucket of their own.
+var gasDriftBuckets = []float64{1, 5, 10, math.Inf(1)}
+
+// gasBaseline records the gas used by the vectors executed by tvx exec, and
+// checks it against a baseline recorded by a previous run, so that gas creep
+// between releases is caught even when receipts match, e.g. within the
+// tolerance of --assert-gas-tolerance.
+type gasBaseline struct {
+	path string
+	// budget is the tolerated drift from the baseline, in percent; 0
+	// disables enforcement.
+	budget float64
+	// warn only warns of drifts beyond the budget, rather than failing the
+	// vectors.
+	warn bool
+	// update writes the observed gas into the baseline file at the end of the
+	// run.
+	update bool
+
+	lk       sync.Mutex
+	baseline map[string]int64
+	observed map[string]int64
+	// drifts are the drifts of the vectors found in the baseline, in
+	// percent, for the histogram.
+	drifts []float64
+	// exceeded is the number of vectors whose drift exceeded the budget.
+	exceeded int
+}
+
+// execGasBaseline is the gas baseline of tvx exec, if requested.
+var execGasBaseline *gasBaseline
+
+// openGasBaseline loads the baseline at path. A missing file is an empty
+// baseline when updating it, and an error otherwise.
+func openGasBaseline(path string, budget float64, warn, update bool) (*gasBaseline, error) {
+	if budget < 0 {
+		return nil, fmt.Errorf("invalid gas budget %.2f%%; must be positive", budget)
+	}
+	b := &gasBaseline{
+		path:     path,
+		budget:   budget,
+		warn:     warn,
+		update:   update,
+		baseline: make(map[string]int64),
+		observed: make(map[string]int64),
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && update:
+		log.Printf("gas baseline %s doesn't exist; it will be created", path)
+		return b, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read gas baseline: %w", err)
+	}
+	var f gasBaselineFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to decode gas baseline %s: %w", path, err)
+	}
+	if f.Version != gasBaselineVersion {
+		return nil, fmt.Errorf("unsupported gas baseline version %d; expected %d", f.Version, gasBaselineVersion)
+	}
+	for id, gas := range f.Vectors {
+		b.baseline[id] = gas
+	}
+	return b, nil
+}
+
+// check records the gas used by the vector, and returns a description of its
+// drift from the baseline if it exceeds the budget, or "" otherwise; vectors
+// missing from the baseline pass.
+func (b *gasBaseline) check(id string, gas []int64) string {
+	var total int64
+	for _, g := range gas {
+		total += g
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	b.observed[id] = total
+	expected, ok := b.baseline[id]
+	if !ok || expected == 0 {
+		return ""
+	}
+	drift := 100 * float64(total-expected) / float64(expected)
+	b.drifts = append(b.drifts, drift)
+	if b.budget == 0 || math.Abs(drift) <= b.budget {
+		return ""
+	}
+	b.exceeded++
+	return fmt.Sprintf("gas used drifted %s from the baseline, beyond the budget of %.2f%%; baseline: %d, observed: %d",
+		percentDelta(expected, total), b.budget, expected, total)
+}
+
+// observe checks the gas used by the vector executed by tvx exec against the
+// baseline, if any, and returns whether the vector still passes.
+func (b *gasBaseline) observe(id string, gas []int64, passed bool) bool {
+	if b == nil {
+		return passed
+	}
+	msg := b.check(id, gas)
+	if msg == "" {
+		return passed
+	}
+	if b.warn {
+		log.Println(color.YellowString("⚠️ %s: %s", id, msg))
+		return passed
+	}
+	log.Println(color.HiRedString("❌ %s: %s", id, msg))
+	if execReport != nil && len(execReport.Vectors) > 0 {
+		vr := execReport.Vectors[len(execReport.Vectors)-1]
+		vr.Status, vr.Failures = vectorStatusFailed, append(vr.Failures, msg)
+		if vr.Signature == "" {
+			vr.Signature = "gas budget"
+		}
+	}
+	return false
+}
+
+// finish writes the histogram of the drifts from the baseline, and the
+// updated baseline, if requested.
+func (b *gasBaseline) finish(w io.Writer) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+	writeGasDriftHistogram(w, b.drifts, len(b.observed), b.budget, b.exceeded)
+	if !b.update {
+		return nil
+	}
+
+	f := gasBaselineFile{Version: gasBaselineVersion, Vectors: make(map[string]int64, len(b.baseline))}
+	for id, gas := range b.baseline {
+		f.Vectors[id] = gas
+	}
+	for id, gas := range b.observed {
+		f.Vectors[id] = gas
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(b.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write gas baseline: %w", err)
+	}
+	log.Printf("recorded the gas used by %d vectors into the gas baseline %s", len(b.observed), b.path)
+	return nil
+}
+
+// writeGasDriftHistogram writes the histogram of the drifts, in percent, of
+// the vectors found in the baseline, out of those executed.
+func writeGasDriftHistogram(w io.Writer, drifts []float64, executed int, budget float64, exceeded int) {
+	_, _ = fmt.Fprintf(w, "gas drift from the baseline of %d of %d vectors:\n", len(drifts), executed)
+	if len(drifts) == 0 {
+		return
+	}
+
+	// the buckets of decreases, the unchanged one, then those of increases.
+	n := len(gasDriftBuckets)
+	counts := make([]int, 2*n+1)
+	for _, d := range drifts {
+		i := sort.SearchFloat64s(gasDriftBuckets, math.Abs(d))
+		switch {
+		case d < 0:
+			counts[n-1-i]++
+		case d == 0:
+			counts[n]++
+		default:
+			counts[n+1+i]++
+		}
+	}
+	pct := func(sign string, v float64) string {
+		if v == 0 {
+			return "0%"
+		}
+		return fmt.Sprintf("%s%g%%", sign, v)
+	}
+	labels := make([]string, 2*n+1)
+	labels[n] = "unchanged"
+	for i, bound := range gasDriftBuckets {
+		var lower float64
+		if i > 0 {
+			lower = gasDriftBuckets[i-1]
+		}
+		if math.IsInf(bound, 1) {
+			labels[n-1-i], labels[n+1+i] = "< "+pct("-", lower), "> "+pct("+", lower)
+		} else {
+			labels[n-1-i], labels[n+1+i] = pct("-", bound)+" .. "+pct("-", lower), pct("+", lower)+" .. "+pct("+", bound)
+		}
+	}
+
+	var max int
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+	const barWidth = 40
+	for i, c := range counts {
+		_, _ = fmt.Fprintf(w, "  %14s  %5d  %s\n", labels[i], c, strings.Repeat("#", (c*barWidth+max-1)/max))
+	}
+	if budget > 0 {
+		_, _ = fmt.Fprintf(w, "%d vectors drifted beyond the budget of %.2f%%\n", exceeded, budget)
+	}
+}
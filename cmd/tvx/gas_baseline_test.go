@@ -0,0 +1,61 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGasBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gas.json")
+	if _, err := openGasBaseline(path, 5, false, false); err == nil {
+		t.Fatal("expected error for a missing baseline without --update-gas-baseline")
+	}
+
+	// record a baseline.
+	b, err := openGasBaseline(path, 5, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, gas := range map[string][]int64{"a": {600, 400}, "b": {1000}, "c": {1000}, "d": {1000}} {
+		if msg := b.check(id, gas); msg != "" {
+			t.Fatalf("unexpected drift of %s without a baseline: %s", id, msg)
+		}
+	}
+	var out bytes.Buffer
+	if err := b.finish(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	// check against it.
+	if b, err = openGasBaseline(path, 5, false, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		id       string
+		gas      int64
+		exceeded bool
+	}{
+		{"a", 1000, false},
+		{"b", 1040, false},
+		{"c", 1060, true},
+		{"d", 900, true},
+		{"new", 5000, false},
+	} {
+		if msg := b.check(c.id, []int64{c.gas}); (msg != "") != c.exceeded {
+			t.Errorf("%s: expected the budget exceeded: %t, got %q", c.id, c.exceeded, msg)
+		}
+	}
+
+	out.Reset()
+	if err := b.finish(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"of 4 of 5 vectors", "unchanged      1  #", "+1% .. +5%      1  #", "+5% .. +10%      1  #", "< -10%", "-10% .. -5%      1  #", "2 vectors drifted beyond the budget of 5.00%"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("histogram doesn't contain %q:\n%s", s, out.String())
+		}
+	}
+}
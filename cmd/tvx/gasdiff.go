@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+var gasDiffFlags struct {
+	nvA     uint
+	nvB     uint
+	charges bool
+}
+
+var gasDiffCmd = &cli.Command{
+	Name: "gas-diff",
+	Description: `execute test vectors under the gas schedules of two network versions, and report the gas deltas per vector, and in aggregate, broken down by gas charge name.
+
+   Every variant of the vectors in the files and directories supplied as
+   arguments (the current directory if none) is executed with its network
+   version replaced by --nv-a and --nv-b, regardless of the expectations of the
+   vector. The built-in actors the state of a vector was created with are
+   unchanged, so the network versions should share them (e.g. nv12 and nv13),
+   or the vectors fail to execute under one of them.
+
+   Gas charges are traced in detail while executing; gas used is that of the
+   receipts of the messages applied.`,
+	ArgsUsage: "[<vector file or dir>...]",
+	Action:    runGasDiff,
+	Flags: []cli.Flag{
+		&cli.UintFlag{
+			Name:        "nv-a",
+			Usage:       "network version whose gas schedule is the baseline",
+			Required:    true,
+			Destination: &gasDiffFlags.nvA,
+		},
+		&cli.UintFlag{
+			Name:        "nv-b",
+			Usage:       "network version whose gas schedule is compared with the baseline",
+			Required:    true,
+			Destination: &gasDiffFlags.nvB,
+		},
+		&cli.BoolFlag{
+			Name:        "charges",
+			Usage:       "report the deltas of each vector by gas charge name, besides the aggregate ones",
+			Destination: &gasDiffFlags.charges,
+		},
+	},
+}
+
+// gasProfile is the gas used executing a vector variant, in total and by gas
+// charge name.
+type gasProfile struct {
+	used    int64
+	charges map[string]int64
+	err     error
+}
+
+// add adds the gas of the other profile to this one.
+func (p *gasProfile) add(o *gasProfile) {
+	p.used += o.used
+	for name, gas := range o.charges {
+		p.charges[name] += gas
+	}
+}
+
+func newGasProfile() *gasProfile {
+	return &gasProfile{charges: make(map[string]int64)}
+}
+
+// chargeDelta is the difference in gas charged under a name between two gas
+// profiles.
+type chargeDelta struct {
+	name string
+	a, b int64
+}
+
+// diffCharges returns the charges whose gas differ between the profiles,
+// sorted by decreasing absolute delta, then by name.
+func diffCharges(a, b *gasProfile) []chargeDelta {
+	names := make(map[string]struct{})
+	for name := range a.charges {
+		names[name] = struct{}{}
+	}
+	for name := range b.charges {
+		names[name] = struct{}{}
+	}
+
+	var deltas []chargeDelta
+	for name := range names {
+		if d := (chargeDelta{name: name, a: a.charges[name], b: b.charges[name]}); d.a != d.b {
+			deltas = append(deltas, d)
+		}
+	}
+	abs := func(x int64) int64 {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		di, dj := abs(deltas[i].b-deltas[i].a), abs(deltas[j].b-deltas[j].a)
+		if di != dj {
+			return di > dj
+		}
+		return deltas[i].name < deltas[j].name
+	})
+	return deltas
+}
+
+// percentDelta formats the relative change from a to b.
+func percentDelta(a, b int64) string {
+	if a == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.2f%%", 100*float64(b-a)/float64(a))
+}
+
+func runGasDiff(c *cli.Context) error {
+	if gasDiffFlags.nvA == gasDiffFlags.nvB {
+		return fmt.Errorf("--nv-a and --nv-b must differ")
+	}
+
+	// gas charges are only traced by the legacy VM with detailed tracing.
+	tracing := vm.EnableDetailedTracing
+	vm.EnableDetailedTracing = true
+	defer func() { vm.EnableDetailedTracing = tracing }()
+
+	var (
+		totalA, totalB = newGasProfile(), newGasProfile()
+		variants       int
+		failed         int
+		tw             = tabwriter.NewWriter(os.Stdout, 4, 2, 2, ' ', 0)
+	)
+	_, _ = fmt.Fprintf(tw, "vector\tvariant\tnv%d\tnv%d\tdelta\t\t\n", gasDiffFlags.nvA, gasDiffFlags.nvB)
+
+	err := walkVectors(c.Args().Slice(), func(path string, tv *schema.TestVector) error {
+		switch tv.Class {
+		case schema.ClassMessage, schema.ClassTipset, schema.ClassBlockSeq:
+		default:
+			// no messages, hence no gas, in other classes.
+			return nil
+		}
+		for _, v := range tv.Pre.Variants {
+			a := profileGas(tv, v, network.Version(gasDiffFlags.nvA))
+			b := profileGas(tv, v, network.Version(gasDiffFlags.nvB))
+			if a.err != nil || b.err != nil {
+				failed++
+				log.Printf("failed to execute %s (variant %s): nv%d: %v; nv%d: %v", path, v.ID, gasDiffFlags.nvA, a.err, gasDiffFlags.nvB, b.err)
+				continue
+			}
+			variants++
+			totalA.add(a)
+			totalB.add(b)
+
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%+d\t%s\t\n", path, v.ID, a.used, b.used, b.used-a.used, percentDelta(a.used, b.used))
+			if gasDiffFlags.charges {
+				for _, d := range diffCharges(a, b) {
+					_, _ = fmt.Fprintf(tw, "\t%s\t%d\t%d\t%+d\t%s\t\n", d.name, d.a, d.b, d.b-d.a, percentDelta(d.a, d.b))
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(tw, "TOTAL\t%d variants\t%d\t%d\t%+d\t%s\t\n", variants, totalA.used, totalB.used, totalB.used-totalA.used, percentDelta(totalA.used, totalB.used))
+	for _, d := range diffCharges(totalA, totalB) {
+		_, _ = fmt.Fprintf(tw, "\t%s\t%d\t%d\t%+d\t%s\t\n", d.name, d.a, d.b, d.b-d.a, percentDelta(d.a, d.b))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d variants failed to execute", failed)
+	}
+	return nil
+}
+
+// profileGas executes the vector variant under the network version, and
+// returns the gas used, regardless of the expectations of the vector.
+func profileGas(tv *schema.TestVector, v schema.Variant, nv network.Version) *gasProfile {
+	p := newGasProfile()
+	v.NetworkVersion = uint(nv)
+
+	hooks := &conformance.DriverHooks{
+		OnSubcall: func(depth int, trace *types.ExecutionTrace) {
+			if depth == 0 && trace.MsgRct != nil {
+				p.used += trace.MsgRct.GasUsed
+			}
+		},
+		OnGasCharge: func(_ int, _ *types.Message, charge *types.GasTrace) {
+			p.charges[charge.Name] += charge.TotalGas
+		},
+	}
+
+	// silence the execution logs; assertion failures are expected, as the
+	// vector records the gas used under its own network version.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+
+	// only fatal failures prevent the comparison; failed assertions of
+	// receipts and state roots are expected.
+	r := new(reportingReporter)
+	p.err = recoverFatal(func() {
+		_, _, _ = executeVariant(context.Background(), r, tv, &v, conformance.ExecuteOpts{DriverOpts: conformance.DriverOpts{Hooks: hooks}})
+	})
+	return p
+}
@@ -0,0 +1,33 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+)
+
+func TestDiffCharges(t *testing.T) {
+	a := &gasProfile{charges: map[string]int64{"OnChainMessage": 100, "OnIpldGet": 50, "OnMethodInvocation": 10}}
+	b := &gasProfile{charges: map[string]int64{"OnChainMessage": 100, "OnIpldGet": 20, "OnMethodInvocation": 40, "OnHashing": 30}}
+
+	deltas := diffCharges(a, b)
+	expected := []chargeDelta{
+		{name: "OnHashing", a: 0, b: 30},
+		{name: "OnIpldGet", a: 50, b: 20},
+		{name: "OnMethodInvocation", a: 10, b: 40},
+	}
+	if len(deltas) != len(expected) {
+		t.Fatalf("expected %d deltas, got %v", len(expected), deltas)
+	}
+	for i := range expected {
+		if deltas[i] != expected[i] {
+			t.Errorf("delta %d: expected %v, got %v", i, expected[i], deltas[i])
+		}
+	}
+
+	if s := percentDelta(200, 150); s != "-25.00%" {
+		t.Errorf("unexpected percent delta: %s", s)
+	}
+	if s := percentDelta(0, 150); s != "n/a" {
+		t.Errorf("unexpected percent delta: %s", s)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// updateGolden rebaselines the vector files at path, rewriting them in place;
+// see rebaselineVector. Vectors in archives are skipped, as they can't be
+// rewritten in place.
+func updateGolden(path string) error {
+	if path == "" {
+		return fmt.Errorf("--update-golden rewrites vector files in place, and requires --file")
+	}
+	if isVectorArchive(path) {
+		return fmt.Errorf("--update-golden can't rewrite the vectors of archive %s in place; extract it first", path)
+	}
+
+	var updated, unchanged, failed int
+	err := walkVectorFiles(path, func(path string, content []byte) error {
+		if _, err := os.Stat(path); err != nil {
+			log.Printf("skipping vector %s: not a file that can be rewritten in place", path)
+			return nil
+		}
+		var tv schema.TestVector
+		if err := json.Unmarshal(content, &tv); err != nil {
+			log.Printf("failed to decode test vector %s: %s; skipping", path, err)
+			return nil
+		}
+		if !execShard.contains(&tv, path) {
+			return nil
+		}
+		if reason := tagSkipReason(&tv); reason != "" {
+			log.Printf("skipping vector %s: %s", path, reason)
+			return nil
+		}
+
+		changed, err := rebaselineVector(&tv, time.Now())
+		switch {
+		case err != nil:
+			failed++
+			log.Printf("failed to rebaseline vector %s: %s", path, err)
+			return nil
+		case !changed:
+			unchanged++
+			return nil
+		}
+		updated++
+		log.Printf("rebaselined vector %s", path)
+		return writeVector(&tv, path)
+	})
+	log.Printf("rebaselined %d vectors; %d unchanged, %d failed", updated, unchanged, failed)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to rebaseline %d vectors", failed)
+	}
+	return nil
+}
+
+// rebaselineVector re-executes the variants of the vector, and rewrites its
+// expected receipts, receipts roots and post state root with the results,
+// recording the rebaseline, with the version of the driver, in its
+// generation metadata. The variants must agree on the results, as the vector
+// holds a single set of postconditions. The incorrect-gas hint is dropped, as
+// the gas recorded is now that of the driver. It returns whether the
+// postconditions changed.
+//
+// Only message and tipset vectors are supported. The CAR isn't rewritten: the
+// blocks of the new post-state aren't embedded, nor are the postconditions
+// stored in it, so vectors asserting actor postconditions or call trees are
+// refused, and must be re-extracted.
+func rebaselineVector(tv *schema.TestVector, now time.Time) (bool, error) {
+	for _, sel := range []string{conformance.SelectorActorPostconditions, conformance.SelectorExpectedTraces} {
+		if _, ok := tv.Selector[sel]; ok {
+			return false, fmt.Errorf("the %s of the vector can't be rebaselined; re-extract it instead", sel)
+		}
+	}
+	if tv.Pre == nil || len(tv.Pre.Variants) == 0 {
+		return false, fmt.Errorf("vector has no variants")
+	}
+
+	var (
+		post    *schema.Postconditions
+		variant string
+	)
+	for i := range tv.Pre.Variants {
+		v := &tv.Pre.Variants[i]
+		var (
+			p   *schema.Postconditions
+			err error
+		)
+		switch tv.Class {
+		case schema.ClassMessage, conformance.ClassConsensusFault:
+			p, _, err = conformance.ComputeMessageVectorPostconditions(tv, v, execVectorOpts)
+		case schema.ClassTipset:
+			p, err = conformance.ComputeTipsetVectorPostconditions(tv, v, execVectorOpts)
+		default:
+			return false, fmt.Errorf("%w: %s", conformance.ErrUnsupportedClass, tv.Class)
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to execute variant %s: %w", v.ID, err)
+		}
+		if post != nil && !samePostconditions(post, p) {
+			return false, fmt.Errorf("variants %s and %s result in different postconditions", variant, v.ID)
+		}
+		post, variant = p, v.ID
+	}
+
+	if tv.Post != nil && samePostconditions(tv.Post, post) {
+		return false, nil
+	}
+	if tv.Post == nil {
+		tv.Post = new(schema.Postconditions)
+	}
+	tv.Post.StateTree = post.StateTree
+	tv.Post.Receipts = post.Receipts
+	tv.Post.ReceiptsRoots = post.ReceiptsRoots
+
+	hints := tv.Hints[:0]
+	for _, h := range tv.Hints {
+		if h != conformance.HintIncorrectGas {
+			hints = append(hints, h)
+		}
+	}
+	tv.Hints = hints
+	if len(tv.Hints) == 0 {
+		tv.Hints = nil
+	}
+	if tv.Meta == nil {
+		tv.Meta = new(schema.Metadata)
+	}
+	tv.Meta.Gen = append(tv.Meta.Gen, schema.GenerationData{
+		Source:  "rebaselined:" + now.UTC().Format("2006-01-02"),
+		Version: build.UserVersion(),
+	})
+	return true, nil
+}
+
+// samePostconditions returns whether the postconditions hold the same
+// receipts, receipts roots and post state root.
+func samePostconditions(a, b *schema.Postconditions) bool {
+	if (a.StateTree == nil) != (b.StateTree == nil) {
+		return false
+	}
+	if a.StateTree != nil && !a.StateTree.RootCID.Equals(b.StateTree.RootCID) {
+		return false
+	}
+	if len(a.Receipts) != len(b.Receipts) || len(a.ReceiptsRoots) != len(b.ReceiptsRoots) {
+		return false
+	}
+	for i, r := range a.Receipts {
+		o := b.Receipts[i]
+		if r == nil || o == nil {
+			if r != o {
+				return false
+			}
+			continue
+		}
+		if r.ExitCode != o.ExitCode || r.GasUsed != o.GasUsed || !bytes.Equal(r.ReturnValue, o.ReturnValue) {
+			return false
+		}
+	}
+	for i, c := range a.ReceiptsRoots {
+		if !c.Equals(b.ReceiptsRoots[i]) {
+			return false
+		}
+	}
+	return true
+}
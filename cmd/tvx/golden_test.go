@@ -0,0 +1,55 @@
+// stm: #unit
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestSamePostconditions(t *testing.T) {
+	root := cid.MustParse("bafy2bzacecnamqgqmifpluoeldx7zzglxcljo6oja4vrmtj7432rphldpdmm2")
+	post := func(gas int64, ret []byte) *schema.Postconditions {
+		return &schema.Postconditions{
+			StateTree: &schema.StateTree{RootCID: root},
+			Receipts:  []*schema.Receipt{{ExitCode: 0, ReturnValue: ret, GasUsed: gas}},
+		}
+	}
+	if !samePostconditions(post(10, []byte{1}), post(10, []byte{1})) {
+		t.Error("identical postconditions differ")
+	}
+	if samePostconditions(post(10, []byte{1}), post(11, []byte{1})) {
+		t.Error("postconditions with different gas are the same")
+	}
+	if samePostconditions(post(10, []byte{1}), post(10, nil)) {
+		t.Error("postconditions with different return values are the same")
+	}
+	other := post(10, []byte{1})
+	other.ReceiptsRoots = []cid.Cid{root}
+	if samePostconditions(post(10, []byte{1}), other) {
+		t.Error("postconditions with different receipts roots are the same")
+	}
+}
+
+func TestRebaselineVectorRefused(t *testing.T) {
+	variants := &schema.Preconditions{Variants: []schema.Variant{{ID: "test"}}}
+	tv := &schema.TestVector{
+		Class:    schema.ClassMessage,
+		Selector: schema.Selector{conformance.SelectorActorPostconditions: "bafy"},
+		Pre:      variants,
+	}
+	if _, err := rebaselineVector(tv, time.Now()); err == nil {
+		t.Error("rebaselined a vector asserting actor postconditions")
+	}
+
+	tv = &schema.TestVector{Class: conformance.ClassMigration, Pre: variants}
+	if _, err := rebaselineVector(tv, time.Now()); !errors.Is(err, conformance.ErrUnsupportedClass) {
+		t.Errorf("expected an unsupported class error, got %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// The edge cases of plain sends covered by tvx harvest-sends.
+const (
+	// sendNonexistentRecipient sends to an address no actor exists at, which
+	// creates an account for key addresses, and fails for ID addresses.
+	sendNonexistentRecipient = "nonexistent_recipient"
+	// sendIDRecipient sends to an ID address.
+	sendIDRecipient = "id_recipient"
+	// sendPubkeyRecipient sends to a secp256k1 or BLS address.
+	sendPubkeyRecipient = "pubkey_recipient"
+	// sendZeroValue sends no funds.
+	sendZeroValue = "zero_value"
+	// sendSelf sends to the sender itself.
+	sendSelf = "self_send"
+)
+
+// sendCases are the edge cases of plain sends, in the order they're
+// reported.
+var sendCases = []string{sendNonexistentRecipient, sendIDRecipient, sendPubkeyRecipient, sendZeroValue, sendSelf}
+
+var harvestSendsFlags struct {
+	from, to  int64
+	perCase   int
+	out       string
+	shared    string
+	retain    string
+	keepGoing bool
+}
+
+var harvestSendsCmd = &cli.Command{
+	Name: "harvest-sends",
+	Description: `extract a corpus of plain value transfers (method 0) covering their edge cases.
+
+   The messages included in the epochs [--from-epoch, --to-epoch] are swept
+   for plain sends, classified by the edge cases they exercise: sends to
+   addresses no actor exists at (nonexistent_recipient), to ID addresses
+   (id_recipient), to secp256k1 and BLS addresses (pubkey_recipient), of no
+   funds (zero_value), and to the sender itself (self_send). Up to
+   --per-case sends of each case are extracted into the --out directory,
+   tagged 'send/<case>' for every case they exercise, so that tvx exec and
+   tvx search select them by case.
+
+   The corpus is compact: the blocks of the vectors, largely the same
+   accounts and state tree nodes, are moved into a single shared block store,
+   --shared-blocks, which tvx exec --shared-blocks executes them with (see
+   tvx car share).`,
+	Action: runHarvestSends,
+	Before: initialize,
+	After:  destroy,
+	Flags: []cli.Flag{
+		&repoFlag,
+		&apiFlag,
+		&rpcQPSFlag,
+		&rpcConcurrencyFlag,
+		&repoDirectFlag,
+		&selfCheckFlag,
+		&cli.Int64Flag{
+			Name:        "from-epoch",
+			Usage:       "first epoch to sweep for sends",
+			Required:    true,
+			Destination: &harvestSendsFlags.from,
+		},
+		&cli.Int64Flag{
+			Name:        "to-epoch",
+			Usage:       "last epoch to sweep for sends, inclusive",
+			Required:    true,
+			Destination: &harvestSendsFlags.to,
+		},
+		&cli.IntFlag{
+			Name:        "per-case",
+			Usage:       "maximum number of sends to extract per edge case",
+			Value:       5,
+			Destination: &harvestSendsFlags.perCase,
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Usage:       "directory to write the vectors to",
+			Required:    true,
+			Destination: &harvestSendsFlags.out,
+		},
+		&cli.StringFlag{
+			Name:        "shared-blocks",
+			Usage:       "shared block store to move the blocks of the vectors into: a .car or .car.gz file, or a directory; defaults to blocks.car.gz in --out",
+			TakesFile:   true,
+			Destination: &harvestSendsFlags.shared,
+		},
+		&cli.StringFlag{
+			Name:        "state-retain",
+			Usage:       "state retention policy; values: 'accessed-cids', 'accessed-actors'",
+			Value:       "accessed-cids",
+			Destination: &harvestSendsFlags.retain,
+		},
+		&cli.BoolFlag{
+			Name:        "keep-going",
+			Usage:       "continue extracting the remaining sends when one fails",
+			Destination: &harvestSendsFlags.keepGoing,
+		},
+	},
+}
+
+// sendCandidate is a plain send, and the edge cases it exercises.
+type sendCandidate struct {
+	extractCandidate
+	cases []string
+}
+
+func runHarvestSends(c *cli.Context) (err error) {
+	ctx := context.Background()
+	flags := harvestSendsFlags
+	from, to := abi.ChainEpoch(flags.from), abi.ChainEpoch(flags.to)
+	if from < 0 || to < from {
+		return fmt.Errorf("invalid epoch range [%d, %d]", from, to)
+	}
+	if flags.perCase <= 0 {
+		return fmt.Errorf("--per-case must be positive")
+	}
+	if err := ensureDir(flags.out); err != nil {
+		return err
+	}
+	if flags.shared == "" {
+		flags.shared = filepath.Join(flags.out, "blocks.car.gz")
+	}
+
+	var candidates []sendCandidate
+	err = forEachInclusionTipset(ctx, from, to+1, func(incTs, _ *types.TipSet, msgs []api.Message) (bool, error) {
+		for _, m := range msgs {
+			if m.Message.Method != builtin.MethodSend {
+				continue
+			}
+			cases, err := classifySendAt(ctx, m.Message, incTs.Key())
+			if err != nil {
+				return true, fmt.Errorf("failed to classify send %s: %w", m.Cid, err)
+			}
+			candidates = append(candidates, sendCandidate{
+				extractCandidate: extractCandidate{cid: m.Cid, epoch: incTs.Height(), block: incTs.Cids()[0].String()},
+				cases:            cases,
+			})
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	selected, covered := selectSends(candidates, flags.perCase)
+	log.Printf("extracting %d of %d sends included in epochs [%d, %d]", len(selected), len(candidates), from, to)
+	for _, k := range sendCases {
+		if covered[k] == 0 {
+			log.Printf("no sends exercising %s in the epoch range", k)
+		}
+	}
+
+	var (
+		paths []string
+		merr  = new(multierror.Error)
+	)
+	for _, cand := range selected {
+		id := fmt.Sprintf("send-%d-%s", cand.epoch, cand.cid)
+		tags := make([]string, 0, len(cand.cases))
+		for _, k := range cand.cases {
+			tags = append(tags, "send/"+k)
+		}
+		opts := extractOpts{
+			id:        id,
+			block:     cand.block,
+			class:     string(schema.ClassMessage),
+			cid:       cand.cid.String(),
+			file:      outputPath(flags.out, id+".json"),
+			retain:    flags.retain,
+			precursor: extractor.PrecursorSelectParticipants,
+			tags:      tags,
+		}
+		if err := doExtractMessage(opts); err != nil {
+			err = fmt.Errorf("failed to extract vector for send %s: %w", cand.cid, err)
+			if !flags.keepGoing {
+				return err
+			}
+			log.Print(err)
+			merr = multierror.Append(merr, err)
+			continue
+		}
+		paths = append(paths, opts.file)
+	}
+	if len(paths) == 0 {
+		return merr.ErrorOrNil()
+	}
+
+	// the vectors share the bulk of their state.
+	store, err := openSharedBlocks(flags.shared, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := store.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+	var total, added int
+	if _, err := rewriteVectorFiles(paths, func(path string, tv *schema.TestVector) (bool, error) {
+		t, a, err := shareVector(tv, store)
+		total, added = total+t, added+a
+		return err == nil, err
+	}); err != nil {
+		return err
+	}
+
+	log.Printf("extracted %d sends into %s, sharing %d blocks in %s, %d deduplicated; failed %d",
+		len(paths), flags.out, added, flags.shared, total-added, merr.Len())
+	return merr.ErrorOrNil()
+}
+
+// classifySendAt returns the edge cases the send exercises, resolving its
+// sender and recipient in the state the tipset is applied on.
+func classifySendAt(ctx context.Context, msg *types.Message, tsk types.TipSetKey) ([]string, error) {
+	senderID, err := FullAPI.StateLookupID(ctx, msg.From, tsk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve sender %s: %w", msg.From, err)
+	}
+	var (
+		recipientID = msg.To
+		exists      = true
+	)
+	if msg.To.Protocol() == address.ID {
+		_, err = FullAPI.StateGetActor(ctx, msg.To, tsk)
+	} else {
+		recipientID, err = FullAPI.StateLookupID(ctx, msg.To, tsk)
+	}
+	switch {
+	case isActorNotFound(err):
+		recipientID, exists = address.Undef, false
+	case err != nil:
+		return nil, fmt.Errorf("failed to resolve recipient %s: %w", msg.To, err)
+	}
+	return classifySend(msg, senderID, recipientID, exists), nil
+}
+
+// isActorNotFound returns whether the error of a node reports a missing
+// actor, either typed, or as the message of the error of the state tree.
+func isActorNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	var nf *api.ErrActorNotFound
+	return errors.As(err, &nf) || strings.Contains(err.Error(), types.ErrActorNotFound.Error())
+}
+
+// classifySend returns the edge cases the send exercises, given the ID
+// addresses of its sender and recipient, and whether the latter exists.
+func classifySend(msg *types.Message, senderID, recipientID address.Address, exists bool) []string {
+	var cases []string
+	if !exists {
+		cases = append(cases, sendNonexistentRecipient)
+	}
+	switch msg.To.Protocol() {
+	case address.ID:
+		cases = append(cases, sendIDRecipient)
+	case address.SECP256K1, address.BLS:
+		cases = append(cases, sendPubkeyRecipient)
+	}
+	if msg.Value.IsZero() {
+		cases = append(cases, sendZeroValue)
+	}
+	if msg.From == msg.To || (exists && senderID == recipientID) {
+		cases = append(cases, sendSelf)
+	}
+	return cases
+}
+
+// selectSends selects the sends to extract, in order: those exercising a
+// case fewer than perCase of the sends selected before exercise. It returns
+// them, with the number selected per case. Rare cases are covered first, so
+// that sends exercising several cases aren't selected for common ones only.
+func selectSends(candidates []sendCandidate, perCase int) ([]sendCandidate, map[string]int) {
+	frequency := make(map[string]int)
+	for _, cand := range candidates {
+		for _, k := range cand.cases {
+			frequency[k]++
+		}
+	}
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	// the rarity of a send is that of its rarest case.
+	rarity := func(cand sendCandidate) int {
+		r := len(candidates) + 1
+		for _, k := range cand.cases {
+			if frequency[k] < r {
+				r = frequency[k]
+			}
+		}
+		return r
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return rarity(candidates[order[i]]) < rarity(candidates[order[j]])
+	})
+
+	covered := make(map[string]int)
+	chosen := make([]bool, len(candidates))
+	for _, i := range order {
+		for _, k := range candidates[i].cases {
+			if covered[k] < perCase {
+				chosen[i] = true
+				break
+			}
+		}
+		if chosen[i] {
+			for _, k := range candidates[i].cases {
+				covered[k]++
+			}
+		}
+	}
+
+	var selected []sendCandidate
+	for i, cand := range candidates {
+		if chosen[i] {
+			selected = append(selected, cand)
+		}
+	}
+	return selected, covered
+}
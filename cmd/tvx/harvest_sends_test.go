@@ -0,0 +1,77 @@
+// stm: #unit
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestClassifySend(t *testing.T) {
+	sender, _ := address.NewIDAddress(1000)
+	recipient, _ := address.NewIDAddress(1001)
+	key, err := address.NewSecp256k1Address([]byte("pubkey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		msg      *types.Message
+		to       address.Address
+		exists   bool
+		expected []string
+	}{
+		{"id", &types.Message{From: key, To: recipient, Value: types.NewInt(1)}, recipient, true, []string{sendIDRecipient}},
+		{"new account", &types.Message{From: key, To: key, Value: types.NewInt(0)}, address.Undef, false,
+			[]string{sendNonexistentRecipient, sendPubkeyRecipient, sendZeroValue, sendSelf}},
+		{"self by id", &types.Message{From: key, To: sender, Value: types.NewInt(1)}, sender, true, []string{sendIDRecipient, sendSelf}},
+	} {
+		if cases := classifySend(tc.msg, sender, tc.to, tc.exists); !reflect.DeepEqual(cases, tc.expected) {
+			t.Errorf("%s: expected cases %v; got %v", tc.name, tc.expected, cases)
+		}
+	}
+}
+
+func TestSelectSends(t *testing.T) {
+	send := func(cases ...string) sendCandidate {
+		return sendCandidate{cases: cases}
+	}
+	candidates := []sendCandidate{
+		send(sendIDRecipient),
+		send(sendIDRecipient),
+		send(sendIDRecipient, sendZeroValue),
+		send(sendIDRecipient),
+	}
+	// the rare zero-value send is selected first, covering an ID send too.
+	selected, covered := selectSends(candidates, 1)
+	if len(selected) != 1 || !reflect.DeepEqual(selected[0].cases, []string{sendIDRecipient, sendZeroValue}) {
+		t.Errorf("expected the zero-value send only; got %v", selected)
+	}
+	if covered[sendIDRecipient] != 1 || covered[sendZeroValue] != 1 {
+		t.Errorf("unexpected coverage: %v", covered)
+	}
+	if selected, _ := selectSends(candidates, 2); len(selected) != 2 {
+		t.Errorf("expected 2 sends; got %d", len(selected))
+	}
+}
+
+func TestIsActorNotFound(t *testing.T) {
+	for _, err := range []error{
+		fmt.Errorf("lookup: %w", &api.ErrActorNotFound{}),
+		errors.New("load actor: actor not found"),
+	} {
+		if !isActorNotFound(err) {
+			t.Errorf("expected %q to report a missing actor", err)
+		}
+	}
+	if isActorNotFound(nil) || isActorNotFound(errors.New("connection refused")) {
+		t.Error("expected other errors not to report a missing actor")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+var harvestUpgradeFlags struct {
+	nv        uint64
+	before    int64
+	after     int64
+	sample    int
+	out       string
+	retain    string
+	precursor string
+	keepGoing bool
+}
+
+var harvestUpgradeCmd = &cli.Command{
+	Name: "harvest-upgrade",
+	Description: `extract message vectors from the epochs around a network upgrade.
+
+   The epoch at which the connected network upgraded to the network version
+   supplied with --nv is located by binary search over the chain. The messages
+   included in the --before epochs preceding it and the --after epochs from it
+   onwards are sampled, up to --sample messages on each side, spread evenly
+   across the window, and extracted into the --out directory.
+
+   Vectors are tagged 'upgrade/nv<N>/before' or 'upgrade/nv<N>/after', so that
+   tvx exec and tvx search select them by side of the upgrade. The connected
+   node must retain the state at the epochs of the windows.`,
+	Action: runHarvestUpgrade,
+	Before: initialize,
+	After:  destroy,
+	Flags: []cli.Flag{
+		&repoFlag,
+		&apiFlag,
+		&rpcQPSFlag,
+		&rpcConcurrencyFlag,
+		&repoDirectFlag,
+		&selfCheckFlag,
+		&cli.Uint64Flag{
+			Name:        "nv",
+			Usage:       "network version of the upgrade",
+			Required:    true,
+			Destination: &harvestUpgradeFlags.nv,
+		},
+		&cli.Int64Flag{
+			Name:        "before",
+			Usage:       "number of epochs preceding the upgrade to sample messages from",
+			Value:       10,
+			Destination: &harvestUpgradeFlags.before,
+		},
+		&cli.Int64Flag{
+			Name:        "after",
+			Usage:       "number of epochs from the upgrade onwards to sample messages from",
+			Value:       10,
+			Destination: &harvestUpgradeFlags.after,
+		},
+		&cli.IntFlag{
+			Name:        "sample",
+			Usage:       "maximum number of messages to extract on each side of the upgrade",
+			Value:       10,
+			Destination: &harvestUpgradeFlags.sample,
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Usage:       "directory to write the vectors to",
+			Required:    true,
+			Destination: &harvestUpgradeFlags.out,
+		},
+		&cli.StringFlag{
+			Name:        "state-retain",
+			Usage:       "state retention policy; values: 'accessed-cids', 'accessed-actors'",
+			Value:       "accessed-cids",
+			Destination: &harvestUpgradeFlags.retain,
+		},
+		&cli.StringFlag{
+			Name:        "precursor-select",
+			Usage:       "precursors to apply; values: 'all', 'participants'",
+			Value:       extractor.PrecursorSelectParticipants,
+			Destination: &harvestUpgradeFlags.precursor,
+		},
+		&cli.BoolFlag{
+			Name:        "keep-going",
+			Usage:       "continue extracting the remaining messages when one fails",
+			Destination: &harvestUpgradeFlags.keepGoing,
+		},
+	},
+}
+
+func runHarvestUpgrade(c *cli.Context) error {
+	ctx := context.Background()
+	flags := harvestUpgradeFlags
+	if flags.before <= 0 || flags.after <= 0 {
+		return fmt.Errorf("--before and --after must be positive")
+	}
+	if flags.sample <= 0 {
+		return fmt.Errorf("--sample must be positive")
+	}
+	if err := ensureDir(flags.out); err != nil {
+		return err
+	}
+
+	nv := network.Version(flags.nv)
+	upgrade, err := findUpgradeEpoch(ctx, FullAPI, nv)
+	if err != nil {
+		return err
+	}
+	log.Printf("network upgraded to version %d at epoch %d", nv, upgrade)
+
+	sides := []struct {
+		name        string
+		from, until abi.ChainEpoch
+	}{
+		{"before", upgrade - abi.ChainEpoch(flags.before), upgrade},
+		{"after", upgrade, upgrade + abi.ChainEpoch(flags.after)},
+	}
+
+	var (
+		extracted int
+		merr      = new(multierror.Error)
+	)
+	for _, side := range sides {
+		from := side.from
+		if from < 0 {
+			from = 0
+		}
+		var candidates []extractCandidate
+		err := forEachInclusionTipset(ctx, from, side.until, func(incTs, _ *types.TipSet, msgs []api.Message) (bool, error) {
+			for _, m := range msgs {
+				candidates = append(candidates, extractCandidate{cid: m.Cid, epoch: incTs.Height(), block: incTs.Cids()[0].String()})
+			}
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		selected := sampleCandidates(candidates, flags.sample)
+		log.Printf("extracting %d of %d messages included in epochs [%d, %d) %s the upgrade", len(selected), len(candidates), from, side.until, side.name)
+
+		tag := fmt.Sprintf("upgrade/nv%d/%s", nv, side.name)
+		for _, cand := range selected {
+			id := fmt.Sprintf("nv%d-%s-%d-%s", nv, side.name, cand.epoch, cand.cid)
+			opts := extractOpts{
+				id:        id,
+				block:     cand.block,
+				class:     "message",
+				cid:       cand.cid.String(),
+				file:      outputPath(flags.out, id+".json"),
+				retain:    flags.retain,
+				precursor: flags.precursor,
+				tags:      []string{tag},
+			}
+			if err := doExtractMessage(opts); err != nil {
+				err = fmt.Errorf("failed to extract vector for message %s: %w", cand.cid, err)
+				if !flags.keepGoing {
+					return err
+				}
+				log.Print(err)
+				merr = multierror.Append(merr, err)
+				continue
+			}
+			extracted++
+		}
+	}
+
+	log.Printf("extracted %d vectors around the upgrade to network version %d; failed %d", extracted, nv, merr.Len())
+	return merr.ErrorOrNil()
+}
+
+// upgradeChainAPI is the subset of the full node API findUpgradeEpoch uses.
+type upgradeChainAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	ChainGetTipSetByHeight(context.Context, abi.ChainEpoch, types.TipSetKey) (*types.TipSet, error)
+	StateNetworkVersion(context.Context, types.TipSetKey) (network.Version, error)
+}
+
+// findUpgradeEpoch returns the epoch of the first tipset of the chain at
+// network version nv or later, binary-searching the chain between genesis and
+// the head. It fails if the chain hasn't reached nv yet, or started at nv or
+// later, in which case there's no upgrade to harvest.
+func findUpgradeEpoch(ctx context.Context, node upgradeChainAPI, nv network.Version) (abi.ChainEpoch, error) {
+	head, err := node.ChainHead(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get chain head: %w", err)
+	}
+
+	// reached returns whether the tipset at or preceding epoch e (if null) is
+	// at nv or later.
+	var serr error
+	reached := func(e abi.ChainEpoch) bool {
+		if serr != nil {
+			return false
+		}
+		ts, err := node.ChainGetTipSetByHeight(ctx, e, head.Key())
+		if err != nil {
+			serr = fmt.Errorf("failed to get tipset at epoch %d: %w", e, err)
+			return false
+		}
+		v, err := node.StateNetworkVersion(ctx, ts.Key())
+		if err != nil {
+			serr = fmt.Errorf("failed to get network version at epoch %d: %w", e, err)
+			return false
+		}
+		return v >= nv
+	}
+
+	if !reached(head.Height()) {
+		if serr != nil {
+			return 0, serr
+		}
+		return 0, fmt.Errorf("chain hasn't upgraded to network version %d yet (head at epoch %d)", nv, head.Height())
+	}
+	if reached(0) {
+		return 0, fmt.Errorf("chain started at network version %d or later; no upgrade to harvest", nv)
+	}
+	// reached is monotonic: null rounds take the version of the preceding
+	// tipset, so the first epoch reached is that of a tipset.
+	e := abi.ChainEpoch(sort.Search(int(head.Height()), func(i int) bool {
+		return reached(abi.ChainEpoch(i) + 1)
+	})) + 1
+	if serr != nil {
+		return 0, serr
+	}
+	return e, nil
+}
+
+// sampleCandidates returns at most n of the candidates, spread evenly across
+// them, in order.
+func sampleCandidates(candidates []extractCandidate, n int) []extractCandidate {
+	if len(candidates) <= n {
+		return candidates
+	}
+	ret := make([]extractCandidate, 0, n)
+	for i := 0; i < n; i++ {
+		ret = append(ret, candidates[i*len(candidates)/n])
+	}
+	return ret
+}
@@ -0,0 +1,79 @@
+// stm: #unit
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+)
+
+// upgradeChain is a chain that upgrades from network version 12 to 13 at the
+// upgrade epoch.
+type upgradeChain struct {
+	chain   []*types.TipSet
+	upgrade abi.ChainEpoch
+}
+
+func newUpgradeChain(head, upgrade abi.ChainEpoch) *upgradeChain {
+	chain := []*types.TipSet{mock.TipSet(mock.MkBlock(nil, 1, 0))}
+	for len(chain) <= int(head) {
+		chain = append(chain, mock.TipSet(mock.MkBlock(chain[len(chain)-1], 1, 0)))
+	}
+	return &upgradeChain{chain: chain, upgrade: upgrade}
+}
+
+func (c *upgradeChain) ChainHead(context.Context) (*types.TipSet, error) {
+	return c.chain[len(c.chain)-1], nil
+}
+
+func (c *upgradeChain) ChainGetTipSetByHeight(_ context.Context, e abi.ChainEpoch, _ types.TipSetKey) (*types.TipSet, error) {
+	return c.chain[e], nil
+}
+
+func (c *upgradeChain) StateNetworkVersion(_ context.Context, tsk types.TipSetKey) (network.Version, error) {
+	for _, ts := range c.chain {
+		if ts.Key() == tsk && ts.Height() >= c.upgrade {
+			return network.Version13, nil
+		}
+	}
+	return network.Version12, nil
+}
+
+func TestFindUpgradeEpoch(t *testing.T) {
+	ctx := context.Background()
+	for _, upgrade := range []abi.ChainEpoch{1, 7, 20} {
+		e, err := findUpgradeEpoch(ctx, newUpgradeChain(20, upgrade), network.Version13)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e != upgrade {
+			t.Errorf("expected upgrade epoch %d; got %d", upgrade, e)
+		}
+	}
+
+	if _, err := findUpgradeEpoch(ctx, newUpgradeChain(20, 7), network.Version14); err == nil {
+		t.Error("expected a version not reached yet to fail")
+	}
+	if _, err := findUpgradeEpoch(ctx, newUpgradeChain(20, 7), network.Version12); err == nil {
+		t.Error("expected a version of genesis to fail")
+	}
+}
+
+func TestSampleCandidates(t *testing.T) {
+	var candidates []extractCandidate
+	for e := abi.ChainEpoch(0); e < 10; e++ {
+		candidates = append(candidates, extractCandidate{epoch: e})
+	}
+	if s := sampleCandidates(candidates, 20); len(s) != 10 {
+		t.Errorf("expected all candidates; got %d", len(s))
+	}
+	s := sampleCandidates(candidates, 3)
+	if len(s) != 3 || s[0].epoch != 0 || s[1].epoch != 3 || s[2].epoch != 6 {
+		t.Errorf("unexpected sample: %v", s)
+	}
+}
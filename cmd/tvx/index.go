@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// DefaultMessageIndexPath is the default location of the message index. It
+// is expanded with mitchellh/go-homedir.
+const DefaultMessageIndexPath = "~/.tvx/index"
+
+var indexFlags struct {
+	dir  string
+	from int64
+	to   int64
+}
+
+var indexCmd = &cli.Command{
+	Name:        "index",
+	Description: "manage a local message index, consulted by tvx extract to locate messages without scanning the chain over the JSON-RPC API",
+	Subcommands: []*cli.Command{
+		{
+			Name: "build",
+			Description: "index the messages included in the epoch range, recording their inclusion and execution tipsets, " +
+				"sender, receiver, method and exit code. Epochs already indexed are re-indexed",
+			Action: runIndexBuild,
+			Before: initialize,
+			After:  destroy,
+			Flags: []cli.Flag{
+				&repoFlag,
+				&apiFlag,
+				&rpcQPSFlag,
+				&rpcConcurrencyFlag,
+				&indexDirFlag,
+				&cli.Int64Flag{
+					Name:        "from",
+					Usage:       "first inclusion epoch to index",
+					Required:    true,
+					Destination: &indexFlags.from,
+				},
+				&cli.Int64Flag{
+					Name:        "to",
+					Usage:       "last inclusion epoch to index (inclusive)",
+					Required:    true,
+					Destination: &indexFlags.to,
+				},
+			},
+		},
+	},
+}
+
+var indexDirFlag = cli.StringFlag{
+	Name:        "index-dir",
+	Usage:       "location of the message index",
+	Value:       DefaultMessageIndexPath,
+	TakesFile:   true,
+	Destination: &indexFlags.dir,
+}
+
+// indexedMessage is a message, as recorded in the message index.
+type indexedMessage struct {
+	Cid             cid.Cid           `json:"cid"`
+	Epoch           abi.ChainEpoch    `json:"epoch"`
+	InclusionTipset types.TipSetKey   `json:"inclusion_tipset"`
+	ExecutionTipset types.TipSetKey   `json:"execution_tipset"`
+	From            address.Address   `json:"from"`
+	To              address.Address   `json:"to"`
+	ToID            address.Address   `json:"to_id"`
+	Method          abi.MethodNum     `json:"method"`
+	ExitCode        exitcode.ExitCode `json:"exit_code"`
+}
+
+// messageIndex indexes messages by CID and inclusion epoch in a local
+// LevelDB database, as the result cache does; the lookups by CID and by
+// epoch are served by its ordered keys. Messages are stored under
+// /msgs/<cid>, and referenced in canonical order from /epochs/<epoch>/<index>;
+// indexed epochs, including null rounds, are marked under /covered/<epoch>.
+type messageIndex struct {
+	ds ds.Batching
+}
+
+func openMessageIndex(path string) (*messageIndex, error) {
+	dstore, err := openLevelDatastore(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message index: %w", err)
+	}
+	return &messageIndex{ds: dstore}, nil
+}
+
+func (ix *messageIndex) Close() error {
+	return ix.ds.Close()
+}
+
+func epochKey(prefix string, epoch abi.ChainEpoch) ds.Key {
+	return ds.NewKey(fmt.Sprintf("/%s/%012d", prefix, epoch))
+}
+
+// put records the messages included at an epoch, replacing those recorded
+// for it by a previous build, e.g. before a reorg, and marks the epochs from
+// covered until the inclusion epoch as indexed.
+func (ix *messageIndex) put(ctx context.Context, covered abi.ChainEpoch, msgs []*indexedMessage, inclusion abi.ChainEpoch) error {
+	b, err := ix.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	if err := ix.deleteEpoch(ctx, b, inclusion); err != nil {
+		return err
+	}
+	for i, m := range msgs {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("failed to encode indexed message: %w", err)
+		}
+		if err := b.Put(ctx, ds.NewKey("/msgs/"+m.Cid.String()), data); err != nil {
+			return err
+		}
+		if err := b.Put(ctx, epochKey("epochs", inclusion).ChildString(fmt.Sprintf("%06d", i)), []byte(m.Cid.String())); err != nil {
+			return err
+		}
+	}
+	for e := covered; e <= inclusion; e++ {
+		if err := b.Put(ctx, epochKey("covered", e), nil); err != nil {
+			return err
+		}
+	}
+	if err := b.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to write message index: %w", err)
+	}
+	return nil
+}
+
+// deleteEpoch deletes the references to the messages recorded at the
+// inclusion epoch through the batch, and the messages themselves, unless
+// they're recorded at another epoch since; those still included at the
+// epoch are recorded again by put, in the same batch.
+func (ix *messageIndex) deleteEpoch(ctx context.Context, b ds.Batch, inclusion abi.ChainEpoch) error {
+	res, err := ix.ds.Query(ctx, query.Query{Prefix: epochKey("epochs", inclusion).String()})
+	if err != nil {
+		return fmt.Errorf("failed to query message index: %w", err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return fmt.Errorf("failed to query message index: %w", err)
+	}
+	for _, e := range entries {
+		if err := b.Delete(ctx, ds.NewKey(e.Key)); err != nil {
+			return err
+		}
+		c, err := cid.Decode(string(e.Value))
+		if err != nil {
+			return fmt.Errorf("invalid CID in message index: %w", err)
+		}
+		if m, ok, err := ix.lookup(ctx, c); err != nil {
+			return err
+		} else if ok && m.Epoch == inclusion {
+			if err := b.Delete(ctx, ds.NewKey("/msgs/"+c.String())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookup returns the indexed message with the supplied CID, if any.
+func (ix *messageIndex) lookup(ctx context.Context, c cid.Cid) (*indexedMessage, bool, error) {
+	data, err := ix.ds.Get(ctx, ds.NewKey("/msgs/"+c.String()))
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to read message index: %w", err)
+	}
+	var m indexedMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, false, fmt.Errorf("failed to decode indexed message: %w", err)
+	}
+	return &m, true, nil
+}
+
+// covers returns whether all epochs in [from, to] are indexed.
+func (ix *messageIndex) covers(ctx context.Context, from, to abi.ChainEpoch) (bool, error) {
+	for e := from; e <= to; e++ {
+		if has, err := ix.ds.Has(ctx, epochKey("covered", e)); err != nil || !has {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// messagesAt returns the indexed messages included at the epoch, in
+// canonical order.
+func (ix *messageIndex) messagesAt(ctx context.Context, epoch abi.ChainEpoch) ([]*indexedMessage, error) {
+	res, err := ix.ds.Query(ctx, query.Query{
+		Prefix: epochKey("epochs", epoch).String(),
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message index: %w", err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message index: %w", err)
+	}
+
+	ret := make([]*indexedMessage, 0, len(entries))
+	for _, e := range entries {
+		c, err := cid.Decode(string(e.Value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CID in message index: %w", err)
+		}
+		m, ok, err := ix.lookup(ctx, c)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fmt.Errorf("message %s referenced at epoch %d is missing from the index", c, epoch)
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+func runIndexBuild(_ *cli.Context) error {
+	ctx := context.Background()
+
+	from, to := abi.ChainEpoch(indexFlags.from), abi.ChainEpoch(indexFlags.to)
+	if to < from {
+		return fmt.Errorf("invalid epoch range [%d, %d]", from, to)
+	}
+
+	ix, err := openMessageIndex(indexFlags.dir)
+	if err != nil {
+		return err
+	}
+	defer ix.Close() //nolint:errcheck
+
+	var (
+		ids     = make(map[address.Address]address.Address)
+		covered = from
+		count   int
+	)
+	err = forEachInclusionTipset(ctx, from, to+1, func(incTs, execTs *types.TipSet, msgs []api.Message) (bool, error) {
+		rcpts, err := FullAPI.ChainGetParentReceipts(ctx, execTs.Blocks()[0].Cid())
+		if err != nil {
+			return true, fmt.Errorf("failed to get receipts of messages included at epoch %d: %w", incTs.Height(), err)
+		}
+
+		indexed := make([]*indexedMessage, 0, len(msgs))
+		for i, m := range msgs {
+			toID := resolveReceiverID(ctx, FullAPI, ids, m.Message.To, incTs, execTs)
+			indexed = append(indexed, &indexedMessage{
+				Cid:             m.Cid,
+				Epoch:           incTs.Height(),
+				InclusionTipset: incTs.Key(),
+				ExecutionTipset: execTs.Key(),
+				From:            m.Message.From,
+				To:              m.Message.To,
+				ToID:            toID,
+				Method:          m.Message.Method,
+				ExitCode:        rcpts[i].ExitCode,
+			})
+		}
+
+		if err := ix.put(ctx, covered, indexed, incTs.Height()); err != nil {
+			return true, err
+		}
+		covered = incTs.Height() + 1
+		count += len(indexed)
+		log.Printf("indexed %d messages included at epoch %d", len(indexed), incTs.Height())
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("indexed %d messages in epoch range [%d, %d]", count, from, covered-1)
+	return nil
+}
+
+// resolveReceiverID resolves the ID address of the receiver of a message
+// included in incTs, and executed in execTs, caching it in ids. A receiver
+// created by the message, or one before it in incTs, is only resolvable in
+// the state execTs is based on. Receivers that can't be resolved are
+// address.Undef, and aren't cached, so that later messages to them resolve
+// them once they're created.
+func resolveReceiverID(ctx context.Context, node v0api.FullNode, ids map[address.Address]address.Address, to address.Address, incTs, execTs *types.TipSet) address.Address {
+	if id, ok := ids[to]; ok {
+		return id
+	}
+	id, err := node.StateLookupID(ctx, to, incTs.Key())
+	if err != nil {
+		if id, err = node.StateLookupID(ctx, to, execTs.Key()); err != nil {
+			return address.Undef
+		}
+	}
+	ids[to] = id
+	return id
+}
+
+// msgIndex is the message index consulted by tvx extract, if enabled.
+var msgIndex *messageIndex
@@ -0,0 +1,108 @@
+// stm: #unit
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+)
+
+func indexTestMessage(nonce uint64, epoch abi.ChainEpoch) *indexedMessage {
+	m := mock.UnsignedMessage(mock.Address(100), mock.Address(101), nonce)
+	return &indexedMessage{Cid: m.Cid(), Epoch: epoch, From: m.From, To: m.To}
+}
+
+func TestMessageIndexReindexEpoch(t *testing.T) {
+	ctx := context.Background()
+	ix, err := openMessageIndex(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close() //nolint:errcheck
+
+	kept, dropped := indexTestMessage(0, 10), indexTestMessage(1, 10)
+	if err := ix.put(ctx, 10, []*indexedMessage{kept, dropped}, 10); err != nil {
+		t.Fatal(err)
+	}
+	// re-indexing the epoch, e.g. after a reorg, replaces its messages.
+	if err := ix.put(ctx, 10, []*indexedMessage{kept}, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := ix.messagesAt(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Cid != kept.Cid {
+		t.Fatalf("expected only message %s at the re-indexed epoch; got: %d messages", kept.Cid, len(msgs))
+	}
+	if _, ok, err := ix.lookup(ctx, kept.Cid); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatalf("message %s still included at the re-indexed epoch isn't indexed", kept.Cid)
+	}
+	if _, ok, err := ix.lookup(ctx, dropped.Cid); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatalf("message %s no longer included at the re-indexed epoch is still indexed", dropped.Cid)
+	}
+}
+
+// lookupIDNode resolves the addresses in ids in the state of the tipsets
+// from the epoch they're created at, in since, on.
+type lookupIDNode struct {
+	v0api.FullNode
+
+	ids     map[address.Address]address.Address
+	since   map[address.Address]abi.ChainEpoch
+	heights map[types.TipSetKey]abi.ChainEpoch
+	calls   int
+}
+
+func (n *lookupIDNode) StateLookupID(_ context.Context, a address.Address, tsk types.TipSetKey) (address.Address, error) {
+	n.calls++
+	id, ok := n.ids[a]
+	if !ok || n.since[a] > n.heights[tsk] {
+		return address.Undef, fmt.Errorf("actor %s not found", a)
+	}
+	return id, nil
+}
+
+func TestResolveReceiverID(t *testing.T) {
+	ctx := context.Background()
+	incTs := mock.TipSet(mock.MkBlock(nil, 1, 1))
+	execTs := mock.TipSet(mock.MkBlock(incTs, 1, 2))
+	created, missing := mock.Address(200), mock.Address(201)
+	node := &lookupIDNode{
+		ids:     map[address.Address]address.Address{created: mock.Address(1000)},
+		since:   map[address.Address]abi.ChainEpoch{created: execTs.Height()},
+		heights: map[types.TipSetKey]abi.ChainEpoch{incTs.Key(): incTs.Height(), execTs.Key(): execTs.Height()},
+	}
+	ids := make(map[address.Address]address.Address)
+
+	// the receiver created by the message is resolved in the execution tipset.
+	if id := resolveReceiverID(ctx, node, ids, created, incTs, execTs); id != mock.Address(1000) {
+		t.Fatalf("expected receiver created in the inclusion tipset to resolve to %s; got: %s", mock.Address(1000), id)
+	}
+	calls := node.calls
+	if id := resolveReceiverID(ctx, node, ids, created, incTs, execTs); id != mock.Address(1000) {
+		t.Fatalf("expected cached receiver to resolve to %s; got: %s", mock.Address(1000), id)
+	}
+	if node.calls != calls {
+		t.Fatal("resolved receiver was looked up again")
+	}
+
+	if id := resolveReceiverID(ctx, node, ids, missing, incTs, execTs); id != address.Undef {
+		t.Fatalf("expected unresolvable receiver to be undefined; got: %s", id)
+	}
+	if _, ok := ids[missing]; ok {
+		t.Fatal("unresolvable receiver was cached")
+	}
+}
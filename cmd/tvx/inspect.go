@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+var inspectFlags struct {
+	noActors bool
+	states   cli.StringSlice
+}
+
+var inspectCmd = &cli.Command{
+	Name: "inspect",
+	Description: `pretty-print a test vector: its metadata and preconditions, its messages
+   with their params decoded, its receipts, and the actors in its pre-state.
+
+   The params and return values of messages are decoded against the code of
+   their receivers in the pre-state, and printed as JSON; they're printed in
+   hex when the receiver or method is unknown, e.g. for actors created by
+   preceding messages. With 'accessed-cids' state retention, the pre-state
+   only holds the actors the messages accessed; those are listed then.
+
+   The states of actors of the pre-state are decoded against the schemas of
+   their codes, of any actors version, and printed as JSON with --state.
+
+   The vector is read from the supplied file, or from stdin if '-'.`,
+	ArgsUsage: "<vector file>",
+	Action:    runInspect,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "no-actors",
+			Usage:       "don't list the actors in the pre-state",
+			Destination: &inspectFlags.noActors,
+		},
+		&cli.StringSliceFlag{
+			Name:        "state",
+			Usage:       "address of an actor of the pre-state to print the decoded state of; repeatable",
+			Destination: &inspectFlags.states,
+		},
+	},
+}
+
+func runInspect(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected a single vector file; got %d arguments", c.NArg())
+	}
+
+	var r io.Reader = os.Stdin
+	if path := c.Args().First(); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open test vector: %w", err)
+		}
+		defer f.Close() //nolint:errcheck
+		r = f
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read test vector: %w", err)
+	}
+	content, err := vectorJSON(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode test vector: %w", err)
+	}
+	var tv schema.TestVector
+	if err := json.Unmarshal(content, &tv); err != nil {
+		return fmt.Errorf("failed to decode test vector: %w", err)
+	}
+	var states []address.Address
+	for _, s := range inspectFlags.states.Value() {
+		addr, err := address.NewFromString(s)
+		if err != nil {
+			return fmt.Errorf("invalid actor address %q: %w", s, err)
+		}
+		states = append(states, addr)
+	}
+	return inspectVector(os.Stdout, &tv, !inspectFlags.noActors, states...)
+}
+
+// inspectVector writes the vector in human-readable form, with the decoded
+// states of the supplied actors of the pre-state.
+func inspectVector(w io.Writer, tv *schema.TestVector, actors bool, states ...address.Address) error {
+	tw := tabwriter.NewWriter(w, 4, 2, 2, ' ', 0)
+	section := func(name string) {
+		_ = tw.Flush()
+		_, _ = fmt.Fprintf(w, "\n== %s\n", name)
+	}
+
+	_, _ = fmt.Fprintf(tw, "class:\t%s\n", tv.Class)
+	if m := tv.Meta; m != nil {
+		_, _ = fmt.Fprintf(tw, "id:\t%s\n", m.ID)
+		if m.Version != "" {
+			_, _ = fmt.Fprintf(tw, "version:\t%s\n", m.Version)
+		}
+		if m.Desc != "" {
+			_, _ = fmt.Fprintf(tw, "description:\t%s\n", m.Desc)
+		}
+		if m.Comment != "" {
+			_, _ = fmt.Fprintf(tw, "comment:\t%s\n", m.Comment)
+		}
+		if len(m.Tags) > 0 {
+			_, _ = fmt.Fprintf(tw, "tags:\t%s\n", strings.Join(m.Tags, ", "))
+		}
+		for _, g := range m.Gen {
+			_, _ = fmt.Fprintf(tw, "gen:\t%s\t%s\n", g.Source, g.Version)
+		}
+	}
+	if len(tv.Hints) > 0 {
+		_, _ = fmt.Fprintf(tw, "hints:\t%s\n", strings.Join(tv.Hints, ", "))
+	}
+	keys := make([]string, 0, len(tv.Selector))
+	for k := range tv.Selector {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(tw, "selector:\t%s=%s\n", k, tv.Selector[k])
+	}
+	_, _ = fmt.Fprintf(tw, "car:\t%d bytes\n", len(tv.CAR))
+	_, _ = fmt.Fprintf(tw, "randomness:\t%d entries\n", len(tv.Randomness))
+
+	// the pre-state, to resolve the codes of receivers against.
+	var (
+		bs blockstore.Blockstore
+		st *state.StateTree
+	)
+	if tv.Pre != nil {
+		section("preconditions")
+		for _, v := range tv.Pre.Variants {
+			_, _ = fmt.Fprintf(tw, "variant:\t%s\tepoch %d\tnv%d\n", v.ID, v.Epoch, v.NetworkVersion)
+		}
+		if tv.Pre.CircSupply != nil {
+			_, _ = fmt.Fprintf(tw, "circulating supply:\t%s\n", types.FIL(types.BigInt{Int: tv.Pre.CircSupply}))
+		}
+		if tv.Pre.BaseFee != nil {
+			_, _ = fmt.Fprintf(tw, "base fee:\t%s attoFIL\n", tv.Pre.BaseFee)
+		}
+		if tv.Pre.StateTree != nil {
+			_, _ = fmt.Fprintf(tw, "state root:\t%s\n", tv.Pre.StateTree.RootCID)
+			var err error
+			if bs, err = conformance.LoadBlockstore(tv.CAR); err != nil {
+				_, _ = fmt.Fprintf(tw, "\t(failed to load the CAR: %s)\n", err)
+			} else if st, err = state.LoadStateTree(cbornode.NewCborStore(bs), tv.Pre.StateTree.RootCID); err != nil {
+				_, _ = fmt.Fprintf(tw, "\t(failed to load the state tree: %s)\n", err)
+			}
+		}
+	}
+
+	var receipts []*schema.Receipt
+	if tv.Post != nil {
+		receipts = tv.Post.Receipts
+	}
+	var i int
+	if len(tv.ApplyMessages) > 0 {
+		section("messages")
+		for _, m := range tv.ApplyMessages {
+			offset := ""
+			if m.EpochOffset != nil {
+				offset = fmt.Sprintf(" (epoch offset %d)", *m.EpochOffset)
+			}
+			_, _ = fmt.Fprintf(tw, "#%d%s\n", i, offset)
+			inspectMessage(tw, st, m.Bytes, receiptAt(receipts, i))
+			i++
+		}
+	}
+	for t, ts := range tv.ApplyTipsets {
+		section(fmt.Sprintf("tipset %d (epoch offset %d, base fee %s attoFIL)", t, ts.EpochOffset, ts.BaseFee))
+		for _, b := range ts.Blocks {
+			_, _ = fmt.Fprintf(tw, "block:\tminer %s\twin count %d\t%d messages\n", b.MinerAddr, b.WinCount, len(b.Messages))
+			for _, m := range b.Messages {
+				_, _ = fmt.Fprintf(tw, "#%d\n", i)
+				// the receipts of tipset vectors follow the order messages are
+				// applied in, deduplicated across blocks; they're listed with
+				// the postconditions.
+				inspectMessage(tw, st, m, nil)
+				i++
+			}
+		}
+	}
+
+	if tv.Post != nil {
+		section("postconditions")
+		if tv.Post.StateTree != nil {
+			_, _ = fmt.Fprintf(tw, "state root:\t%s\n", tv.Post.StateTree.RootCID)
+		}
+		if len(tv.ApplyTipsets) > 0 {
+			for j, rct := range receipts {
+				_, _ = fmt.Fprintf(tw, "receipt #%d:\texit code %d\tgas used %d\n", j, rct.ExitCode, rct.GasUsed)
+			}
+		}
+		for j, root := range tv.Post.ReceiptsRoots {
+			_, _ = fmt.Fprintf(tw, "receipts root #%d:\t%s\n", j, root)
+		}
+		if len(tv.Post.ApplyMessageFailures) > 0 {
+			_, _ = fmt.Fprintf(tw, "apply failures:\t%v\n", tv.Post.ApplyMessageFailures)
+		}
+	}
+
+	if actors && st != nil {
+		section("pre-state actors")
+		inspectActors(tw, st, tv)
+	}
+	if len(states) > 0 && st != nil {
+		section("pre-state actor states")
+		_ = tw.Flush()
+		inspectActorStates(w, bs, st, states)
+	}
+	return tw.Flush()
+}
+
+// inspectActorStates writes the states of the actors of the state tree,
+// decoded against the schemas of their codes, as JSON.
+func inspectActorStates(w io.Writer, bs blockstore.Blockstore, st *state.StateTree, addrs []address.Address) {
+	for _, addr := range addrs {
+		act, err := st.GetActor(addr)
+		if err != nil {
+			_, _ = fmt.Fprintf(w, "%s:\t(not in the pre-state)\n", addr)
+			continue
+		}
+		name := extractor.ActorName(act.Code)
+		if s, ok := conformance.ActorStates.Lookup(act.Code); ok {
+			name = fmt.Sprintf("%s (v%d)", s.Name, s.Version)
+		}
+		decoded, err := conformance.ActorStates.DecodeState(context.Background(), bs, act)
+		if err != nil {
+			_, _ = fmt.Fprintf(w, "%s %s:\t(failed to decode state: %s)\n", addr, name, err)
+			continue
+		}
+		j, err := json.MarshalIndent(decoded, "", "  ")
+		if err != nil {
+			_, _ = fmt.Fprintf(w, "%s %s:\t(failed to encode state: %s)\n", addr, name, err)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s %s:\n%s\n", addr, name, j)
+	}
+}
+
+// receiptAt returns the i-th receipt, or nil.
+func receiptAt(receipts []*schema.Receipt, i int) *schema.Receipt {
+	if i < len(receipts) {
+		return receipts[i]
+	}
+	return nil
+}
+
+// inspectMessage writes the serialized message, with its params and the
+// return value of its receipt decoded against the code of its receiver in
+// the state tree, if known.
+func inspectMessage(w io.Writer, st *state.StateTree, b []byte, rct *schema.Receipt) {
+	msg, _, err := conformance.DecodeVectorMessage(b)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "\t(failed to decode message: %s)\n", err)
+		return
+	}
+	code := receiverCode(st, msg.To)
+	_, _ = fmt.Fprintf(w, "cid:\t%s\n", msg.Cid())
+	_, _ = fmt.Fprintf(w, "from:\t%s (nonce %d)\n", msg.From, msg.Nonce)
+	_, _ = fmt.Fprintf(w, "to:\t%s (%s)\n", msg.To, extractor.ActorName(code))
+	_, _ = fmt.Fprintf(w, "method:\t%d (%s)\n", msg.Method, extractor.MethodName(code, msg.Method))
+	_, _ = fmt.Fprintf(w, "value:\t%s\n", types.FIL(msg.Value))
+	_, _ = fmt.Fprintf(w, "gas:\tlimit %d, fee cap %s, premium %s\n", msg.GasLimit, msg.GasFeeCap, msg.GasPremium)
+	if len(msg.Params) > 0 {
+		_, _ = fmt.Fprintf(w, "params:\t%s\n", decodeMethodValue(code, msg.Method, msg.Params, true))
+	}
+	if rct != nil {
+		_, _ = fmt.Fprintf(w, "receipt:\texit code %d, gas used %d\n", rct.ExitCode, rct.GasUsed)
+		if len(rct.ReturnValue) > 0 {
+			_, _ = fmt.Fprintf(w, "return:\t%s\n", decodeMethodValue(code, msg.Method, rct.ReturnValue, false))
+		}
+	}
+}
+
+// receiverCode returns the code of the actor in the state tree, or cid.Undef
+// if unknown.
+func receiverCode(st *state.StateTree, addr address.Address) cid.Cid {
+	if st == nil {
+		return cid.Undef
+	}
+	act, err := st.GetActor(addr)
+	if err != nil {
+		return cid.Undef
+	}
+	return act.Code
+}
+
+// decodeMethodValue decodes the params, or the return value, of the method
+// of the actor code, and returns them as indented JSON; it returns them in
+// hex if they can't be decoded.
+func decodeMethodValue(code cid.Cid, method abi.MethodNum, b []byte, params bool) string {
+	j, ok := conformance.DecodeMethodValue(code, method, b, params)
+	if !ok {
+		return "0x" + hex.EncodeToString(b)
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, j, "\t", "  "); err != nil {
+		return string(j)
+	}
+	return indented.String()
+}
+
+// inspectActors writes the actors of the state tree. If the state tree is
+// partial, as retained with 'accessed-cids', the actors sending and
+// receiving the messages of the vector are written instead, if present.
+func inspectActors(w io.Writer, st *state.StateTree, tv *schema.TestVector) {
+	write := func(addr address.Address, act *types.Actor) {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\tnonce %d\thead %s\n", addr, extractor.ActorName(act.Code), types.FIL(act.Balance), act.Nonce, act.Head)
+	}
+
+	type entry struct {
+		addr address.Address
+		act  types.Actor
+	}
+	var all []entry
+	err := st.ForEach(func(addr address.Address, act *types.Actor) error {
+		all = append(all, entry{addr, *act})
+		return nil
+	})
+	if err == nil {
+		for _, e := range all {
+			write(e.addr, &e.act)
+		}
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "(partial state tree; listing the participants of the messages)\n")
+	seen := make(map[address.Address]struct{})
+	for _, msg := range vectorMessages(tv) {
+		for _, addr := range []address.Address{msg.From, msg.To} {
+			if _, ok := seen[addr]; ok {
+				continue
+			}
+			seen[addr] = struct{}{}
+			act, err := st.GetActor(addr)
+			if err != nil {
+				_, _ = fmt.Fprintf(w, "%s\t(not in the pre-state)\n", addr)
+				continue
+			}
+			write(addr, act)
+		}
+	}
+}
+
+// vectorMessages returns the messages of the vector that decode.
+func vectorMessages(tv *schema.TestVector) []*types.Message {
+	var msgs []*types.Message
+	add := func(b []byte) {
+		if msg, _, err := conformance.DecodeVectorMessage(b); err == nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	for _, m := range tv.ApplyMessages {
+		add(m.Bytes)
+	}
+	for _, ts := range tv.ApplyTipsets {
+		for _, b := range ts.Blocks {
+			for _, m := range b.Messages {
+				add(m)
+			}
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,57 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestInspectVector(t *testing.T) {
+	from, _ := address.NewIDAddress(100)
+	to, _ := address.NewIDAddress(101)
+	msg := &types.Message{
+		From:       from,
+		To:         to,
+		Method:     2,
+		Nonce:      7,
+		Value:      abi.NewTokenAmount(1),
+		GasFeeCap:  abi.NewTokenAmount(0),
+		GasPremium: abi.NewTokenAmount(0),
+		Params:     []byte{0x81, 0x01},
+	}
+	b, err := msg.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv := &schema.TestVector{
+		Class:         schema.ClassMessage,
+		Meta:          &schema.Metadata{ID: "test-vector", Gen: []schema.GenerationData{{Source: "network:mainnet"}}},
+		ApplyMessages: []schema.Message{{Bytes: b}},
+		Post:          &schema.Postconditions{Receipts: []*schema.Receipt{{ExitCode: 16, GasUsed: 1234}}},
+	}
+
+	var out bytes.Buffer
+	if err := inspectVector(&out, tv, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"test-vector", "network:mainnet", msg.Cid().String(), "nonce 7", "exit code 16, gas used 1234", "0x8101"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("inspection doesn't contain %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestDecodeMethodValueUnknown(t *testing.T) {
+	if s := decodeMethodValue(cid.Undef, 2, []byte{0xde, 0xad}, true); s != "0xdead" {
+		t.Errorf("expected undecodable params in hex; got %s", s)
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"sort"
 
+	logging "github.com/ipfs/go-log/v2"
 	"github.com/urfave/cli/v2"
 
 	"github.com/filecoin-project/go-jsonrpc"
@@ -34,17 +36,174 @@ var repoFlag = cli.StringFlag{
 	TakesFile: true,
 }
 
+// prefetchLinks is the maximum number of linked blocks to prefetch, in a
+// single ChainReadObjMany call, along with every block fetched from the node.
+var prefetchLinks int
+
+var prefetchFlag = cli.IntFlag{
+	Name: "prefetch",
+	Usage: "maximum number of linked blocks to prefetch from the node in a single ChainReadObjMany call along with every block fetched, " +
+		"sparing a round trip per node when walking state; 0 disables prefetching, and values above 1024, the most ChainReadObjMany reads per call, are capped. " +
+		"Nodes that don't support ChainReadObjMany fall back to fetching blocks one by one",
+	Value:       32,
+	Destination: &prefetchLinks,
+}
+
+// selfCheck is whether extracted vectors are executed from scratch, from the
+// blocks of their CARs alone, before they're written.
+var selfCheck bool
+
+var selfCheckFlag = cli.BoolFlag{
+	Name: "self-check",
+	Usage: "execute every extracted vector from scratch, against a fresh blockstore holding only the blocks of its CAR, " +
+		"before writing it, failing the extraction if the CAR misses blocks",
+	Value:       true,
+	Destination: &selfCheck,
+}
+
+// logOutput is where tvx logs go. Logs never go to stdout, which is reserved
+// for data (e.g. vectors), so that tvx composes in shell pipelines.
+var logOutput io.Writer = os.Stderr
+
+var logFlags struct {
+	quiet   bool
+	verbose bool
+}
+
 func main() {
 	app := &cli.App{
 		Name: "tvx",
-		Description: `tvx is a tool for extracting and executing test vectors. It has four subcommands.
+		Description: `tvx is a tool for extracting and executing test vectors. It has twenty-six subcommands.
 
    tvx extract extracts a test vector from a live network. It requires access to
-   a Filecoin client that exposes the standard JSON-RPC API endpoint. Only
-   message class test vectors are supported at this time.
+   a Filecoin client that exposes the standard JSON-RPC API endpoint. Message,
+   tipset, blockseq (full tipsets with their headers), implicit message (cron
+   and reward), and network upgrade state migration vectors are supported, as
+   well as multisig flows (a proposal and the approval executing it),
+   payment channel lifecycles (the messages sent to a channel) and consensus
+   fault reports (with the block headers proving the fault, and the slashing
+   of the miner asserted). With --repeat, the vector is extracted several
+   times, possibly from different nodes, and the fields that differ between
+   the extractions are flagged as nondeterministic. With --from-mpool, a message still pending in the
+   message pool of the node is applied speculatively on the head, and the
+   vector is tagged 'speculative'; with --msg-file, so is a message read from
+   a file, e.g. one produced by a wallet or SDK under test, never broadcast.
+   With --light, the vector carries no state, but the message, the epoch,
+   basefee and circulating supply it was applied with, and its receipt on
+   chain, for implementations replaying it against their own synced chain;
+   light vectors are cheap to extract in bulk, and tvx exec skips them.
+   With --state-compute, tipset vectors record the execution of the node,
+   through StateCompute, rather than the local one, which only gathers the
+   state the tipsets access, sidestepping the quirks of the local VM.
+   Messages whose receipt sanity check fails on gas are extracted again with
+   more precursors, up to every preceding message of the tipset, and the
+   configuration used is recorded; --escalate-precursors=false disables this.
+   With --pre-root, and --precursor-lookback N, the messages of the sender
+   missing from the pre-state are searched for in the inclusion tipset and
+   the N preceding ones, and applied as precursors, recording the decision
+   in the vector metadata.
+   With --warmup, the message is replayed on the node first, and the state
+   its trace predicts the extraction to read is fetched in bulk, up front,
+   rather than block by block as the local execution stalls on it.
+   Local corpus policies are enforced with --post-process, which hands every
+   vector to a Go plugin or an external command before it's written, to
+   rewrite, annotate, rename or reject it.
+   For scripted harvests, the target can be addressed by height, with
+   --height or --epochs-ago, and messages by their position within the
+   tipset, with --msg-index. Before it's written, every vector is executed
+   from scratch, from the blocks of its CAR alone, to catch CARs missing
+   blocks the extraction happened to have cached; --self-check=false skips
+   this. Extractions yielding several vectors, i.e. ranges of tipsets and
+   messages filtered by epoch, stream them to stdout with -o -, a vector per
+   line, for tvx exec to execute each as soon as it's extracted, without an
+   intermediate directory: tvx extract --from-epoch 100 --to-epoch 200 -o - |
+   tvx exec.
 
    tvx exec executes test vectors against Lotus. Either you can supply one in a
-   file, or many as an ndjson stdin stream.
+   file, many in a directory or archive, or many as an ndjson stdin stream.
+   Large corpora can be split across CI workers with --shard, and the reports
+   of the shards combined with tvx merge-reports. Gas creep between releases
+   is caught by checking the gas used by vectors against a baseline, with
+   --gas-baseline and --gas-budget. When a behavior change is intentional,
+   --update-golden rebaselines the receipts and post state roots of the
+   vectors in place, rather than re-extracting them from the chain. With
+   --tui, the run is displayed in an interactive terminal UI: a live table of
+   the vectors, their statuses, durations and gas deltas, in which the
+   failures of a vector are displayed by selecting it. --metrics-out writes
+   the execution metrics of the vectors (messages, gas used, wall time, CAR
+   size, actors touched and result) to a CSV file, for analysis in notebooks.
+   --trace-out writes the execution traces of the vectors, the call trees of
+   their messages with their gas charges, to a JSON file per vector, for
+   failures reported by CI to be debugged offline. --cover-dir writes the
+   code coverage of the actors each vector exercises, and that of the whole
+   run, as standard cover profiles, to tell whether more vectors cover more
+   actor code; tvx must be built with coverage instrumentation for it.
+   --runs-per-vector re-executes each vector several times, against fresh
+   blockstores, and fails those whose post state roots, receipts or call
+   trees differ across runs, catching nondeterminism in actors. --repro-dir
+   writes a minimal reproduction of every failing vector: the vector, and a
+   standalone Go test executing it through the conformance driver, ready to
+   drop into a checkout of lotus or attach to a bug report.
+
+   During triage, tvx exec --override-nonce-offset, --override-gas-limit,
+   --override-gas-fee-cap and --override-gas-premium tweak the explicit
+   messages of the vectors at execution time, to answer e.g. "does this still
+   fail with a higher gas limit?" without editing them. Their results are
+   non-canonical: the messages applied aren't those of the vectors, so
+   receipts roots and post state roots are expected to differ, and reports
+   are marked accordingly.
+
+   tvx exec can also dispatch vectors to the execution endpoint of another
+   implementation with --executor, asserting its results against the
+   postconditions of the vectors, for differential testing. The endpoint
+   serves GET /v1/info, returning the name and version of the implementation,
+   and POST /v1/execute, taking a vector and the ID of a variant, and
+   returning the receipts of the messages applied, the receipts roots of the
+   tipsets applied, and the post state root, as JSON; status 501 marks
+   vectors it doesn't support.
+
+   Extracted vectors require the version of Lotus they were extracted with,
+   or a later one, and the built-in actors bundle of their network version,
+   through their selector: older builds, and builds not embedding the bundle,
+   fail them, rather than reporting confusing gas mismatches. tvx exec
+   --lenient-requirements executes them regardless, with a warning.
+
+   Vectors verifying proofs for real, rather than replaying the outcomes of
+   their recorded syscalls, also require the proof parameters (verification
+   keys, and the SRS of aggregate proofs) through the proof_params selector.
+   tvx exec skips them if the parameters are missing from the directory of
+   FIL_PROOFS_PARAMETER_CACHE, and fetches them with --fetch-proof-params.
+
+   Conversely, tvx exec --stdin serves Lotus as an execution oracle to the
+   harnesses of other implementations, and to fuzzers, run as a subprocess:
+   it reads vectors from stdin, and writes the result of each to stdout as
+   soon as it's executed, with the same receipts, receipts roots and post
+   state roots per variant. With --stdin-format cbor, vectors and results are
+   CBOR, each prefixed by its length as an unsigned varint, rather than JSON
+   lines.
+
+   Commands reading vector files accept JSON and CBOR vectors, optionally
+   gzip-compressed (.json, .json.gz, .cbor, .cbor.gz), and directories and tar
+   or zip archives of those, searched recursively. Vectors written to files
+   with these extensions are encoded accordingly.
+
+   The vectors of a corpus can share a deduplicated block store, a CAR file
+   or a directory, rather than each embedding the state it shares with the
+   others: tvx car share moves their blocks into it, and tvx car materialize
+   makes them self-contained again. tvx exec executes them with the store
+   supplied with --shared-blocks.
+
+   Known-broken vectors are annotated, so that they don't fail regression runs
+   while fixes are pending: vectors carrying the 'skip' selector, set to the
+   reason, are skipped, and those carrying the 'expected' selector, set to
+   'fail', are expected to fail, and reported as failed when they pass
+   instead. tvx exec --suppressions annotates vectors from a file, without
+   editing them. The reports list both separately.
+
+   Vectors can be labeled with tags, e.g. 'slow' or 'regression/issue-1234',
+   with --tag at extraction and build time. tvx exec and tvx search filter
+   vectors by tag, a tag selecting those under it too, and tvx exec groups
+   its reports by tag with --report-group-by tag.
 
    tvx extract-many performs a batch extraction of many messages, supplied in a
    CSV file. Refer to the help of that subcommand for more info.
@@ -53,6 +212,96 @@ func main() {
    epoch, reporting the result on stderr and writing a test vector on stdout
    or into the specified file.
 
+   tvx bench executes test vectors repeatedly, with cold or warm blockstores,
+   reporting wall time, allocations and gas throughput.
+
+   tvx soak loops a corpus of test vectors for a duration, sampling heap and
+   goroutine counts between iterations, and fails on their monotonic growth,
+   to catch memory the driver leaks per execution.
+
+   tvx compare executes test vectors under two configurations of built-in
+   actors bundles, reporting the receipts and state roots that diverge. With
+   --config, any number of configurations are compared, including the
+   execution endpoints of other implementations, and --matrix-json and
+   --matrix-html write the vector by configuration matrix of the outcomes,
+   for release managers to see which implementation diverges where.
+
+   tvx replay replays the messages of a test vector against a live node
+   through StateCall (or StateReplay), comparing the node's results with the
+   postconditions of the vector. tvx extract records the positions of the
+   message of a vector and its precursors among the messages of the inclusion
+   tipset; tvx replay --check-precursor-order cross-checks them against the
+   node, to tell which ordering a vector was generated with.
+
+   tvx bisect binary-searches an epoch range for the first epoch at which the
+   local execution of messages diverges from their on-chain receipts.
+
+   tvx index builds a local message index over an epoch range, which tvx
+   extract consults to locate messages without scanning the chain.
+
+   tvx serve serves vector extraction over an HTTP API, for remote clients
+   (e.g. CI runners) that don't have access to a Lotus node.
+
+   tvx verify-signature verifies the provenance signatures of vectors, signed
+   by tvx extract with an ed25519 key or a key of the node's wallet.
+
+   tvx anonymize rewrites the wallet addresses in a message vector, and in the
+   state it retains, to synthetic addresses, so that the vector can be
+   published without revealing them.
+
+   tvx search lists the vectors of a corpus that match criteria on their
+   class, actors, methods, exit codes, network versions, tags and selectors.
+
+   tvx coverage reports the methods of the built-in actors of an actors
+   version that the vectors of a corpus don't exercise, by exit code class.
+
+   tvx sample selects a representative subset of a corpus, stratified by
+   class, actor, method and exit code, as a manifest that tvx exec runs with
+   --manifest, e.g. as a fast smoke suite.
+
+   tvx refresh regenerates existing vectors from the chain, with the current
+   schema, state retention and metadata conventions.
+
+   tvx harvest-upgrade extracts a sample of the messages included in the
+   epochs immediately before and after a network upgrade, located on the
+   connected network, tagging the vectors by side of the upgrade.
+
+   tvx harvest-sends sweeps an epoch range for plain value transfers, and
+   extracts those covering their edge cases, e.g. sends to nonexistent
+   accounts, of no funds, or to the sender itself, into a compact corpus
+   sharing its blocks.
+
+   tvx gas-diff executes vectors under the gas schedules of two network
+   versions, reporting the gas deltas per vector and by gas charge name.
+
+   tvx dedupe flags the message vectors of a corpus that are behaviorally
+   redundant, by receiver, method, exit code, call tree and gas, keeping the
+   smallest representative of each behavior.
+
+   tvx car dumps, lists, merges and re-embeds the CARs embedded in vectors,
+   which hold their state, and compacts the state trees of hand-maintained
+   vectors by re-encoding them with a more recent HAMT layout.
+
+   tvx build builds a synthetic message vector from a JSON template declaring
+   the actors of the pre-state and the messages to apply, computing its
+   postconditions by executing them, or a message-validity vector, recording
+   whether the message pool admits its signed messages. Fee scenarios in the
+   template build a vector per combination of basefee, fee cap and premium,
+   asserting the miner tip, burns and refund of each message.
+
+   tvx convert upgrades and downgrades vectors between versions of the vector
+   schema, filling the fields newer versions require, so that corpora survive
+   schema changes.
+
+   tvx portability flags the vectors whose behavior depends on the network
+   they were extracted from, e.g. through the address prefixes of their
+   postconditions, and with --fix, restricts them to that network, or
+   normalizes their addresses to the network they declare.
+
+   tvx provenance generates the provenance manifest of a corpus, listing the
+   network, messages, Lotus version, extraction time and signature of each
+   vector, as recorded in its metadata, for publication alongside it.
+
    SETTING THE JSON-RPC API ENDPOINT
 
    You can set the JSON-RPC API endpoint through one of the following methods.
@@ -69,13 +318,81 @@ func main() {
       API endpoint string if the location is a Lotus repo.
 
    tvx will apply these methods in the same order of precedence they're listed.
+
+   Alternatively, pass the endpoint with --api, which takes precedence. --api
+   can be repeated: calls then fail over to the next endpoint when one is
+   unreachable or rate-limits, and block fetches are spread across all of
+   them, e.g. to extract batches from several public endpoints. To spare
+   shared nodes, cap the rate and concurrency of the calls tvx makes with
+   --rpc-qps and --rpc-concurrency.
+
+   Alternatively, tvx extract, extract-many and refresh can open the
+   blockstore and chainstore of a Lotus repo directly, without a running
+   daemon, with --repo-direct (e.g. for archival repos). The daemon must not
+   be running, as it locks the repo.
+
+   EXIT CODES
+
+   tvx exits with 0 on success, and 1 on failure, except for extractions
+   failing for the following causes, so that scripts driving batch runs can
+   tell them apart: 3 if the message isn't found, 4 if a tipset it requires is
+   unavailable, 5 if the state retention requested is unsupported, 6 if a
+   sanity check against the chain failed, 7 if the CAR exceeds --max-car-size,
+   and 8 if the vector doesn't execute from its CAR alone (see --self-check).
+
+   LOGGING
+
+   tvx writes all logs to stderr, and only data (e.g. vectors) to stdout, so
+   that it composes in shell pipelines. Use --quiet to only log errors, and
+   --verbose to log debug messages of tvx and Lotus subsystems. tvx extract can
+   additionally emit machine-readable progress events, as newline-delimited
+   JSON, to the file or named pipe supplied with --progress-json.
 `,
-		Usage: "tvx is a tool for extracting and executing test vectors",
+		Usage:  "tvx is a tool for extracting and executing test vectors",
+		Before: configureLogging,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:        "quiet",
+				Aliases:     []string{"q"},
+				Usage:       "only log errors",
+				Destination: &logFlags.quiet,
+			},
+			&cli.BoolFlag{
+				Name:        "verbose",
+				Aliases:     []string{"v"},
+				Usage:       "log debug messages of tvx and Lotus subsystems",
+				Destination: &logFlags.verbose,
+			},
+		},
 		Commands: []*cli.Command{
 			extractCmd,
 			execCmd,
 			extractManyCmd,
 			simulateCmd,
+			benchCmd,
+			compareCmd,
+			replayCmd,
+			bisectCmd,
+			indexCmd,
+			serveCmd,
+			verifySignatureCmd,
+			anonymizeCmd,
+			searchCmd,
+			coverageCmd,
+			refreshCmd,
+			harvestUpgradeCmd,
+			harvestSendsCmd,
+			gasDiffCmd,
+			dedupeCmd,
+			inspectCmd,
+			carCmd,
+			buildCmd,
+			convertCmd,
+			mergeReportsCmd,
+			soakCmd,
+			sampleCmd,
+			portabilityCmd,
+			provenanceCmd,
 		},
 	}
 
@@ -85,27 +402,56 @@ func main() {
 	}
 
 	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// configureLogging routes all logs to stderr, at the verbosity requested.
+func configureLogging(_ *cli.Context) error {
+	if logFlags.quiet && logFlags.verbose {
+		return fmt.Errorf("--quiet and --verbose are mutually exclusive")
 	}
+
+	cfg := logging.GetConfig()
+	cfg.Stderr, cfg.Stdout = true, false
+	switch {
+	case logFlags.quiet:
+		cfg.Level = logging.LevelError
+		logOutput = io.Discard
+	case logFlags.verbose:
+		cfg.Level = logging.LevelDebug
+	}
+	logging.SetupLogging(cfg)
+	if !logFlags.quiet && !logFlags.verbose {
+		// the progress of extractions is logged at info level.
+		_ = logging.SetLogLevel("tvx/extract", "info")
+	}
+	log.SetOutput(logOutput)
+	return nil
 }
 
 func initialize(c *cli.Context) error {
-	// LOTUS_DISABLE_VM_BUF disables what's called "VM state tree buffering",
-	// which stashes write operations in a BufferedBlockstore
-	// (https://github.com/filecoin-project/lotus/blob/b7a4dbb07fd8332b4492313a617e3458f8003b2a/lib/bufbstore/buf_bstore.go#L21)
-	// such that they're not written until the VM is actually flushed.
-	//
-	// For some reason, the standard behaviour was not working for me (raulk),
-	// and disabling it (such that the state transformations are written immediately
-	// to the blockstore) worked.
-	_ = os.Setenv("LOTUS_DISABLE_VM_BUF", "iknowitsabadidea")
+	var err error
+	if repoDirect {
+		if FullAPI, Closer, err = openRepoDirect(c.Context, c.String("repo")); err != nil {
+			err = fmt.Errorf("failed to open Lotus repo directly; err: %w", err)
+		}
+		return err
+	}
 
 	// Make the API client.
-	var err error
-	if FullAPI, Closer, err = lcli.GetFullNodeAPI(c); err != nil {
-		err = fmt.Errorf("failed to locate Lotus node; err: %w", err)
+	if specs := c.StringSlice("api"); len(specs) > 0 {
+		if FullAPI, Closer, err = dialEndpoints(c.Context, specs); err != nil {
+			return fmt.Errorf("failed to connect to the supplied API endpoints; err: %w", err)
+		}
+	} else if FullAPI, Closer, err = lcli.GetFullNodeAPI(c); err != nil {
+		return fmt.Errorf("failed to locate Lotus node; err: %w", err)
 	}
-	return err
+
+	// spare shared nodes, if requested.
+	FullAPI = throttleFullNode(FullAPI, c.Float64("rpc-qps"), c.Int("rpc-concurrency"))
+	return nil
 }
 
 func destroy(_ *cli.Context) error {
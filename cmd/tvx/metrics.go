@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+// Tags
+var (
+	// Outcome tags extraction and sanity check measurements with their
+	// outcome.
+	Outcome, _ = tag.NewKey("outcome")
+	// VectorClass tags extraction measurements with the class of the vector.
+	VectorClass, _ = tag.NewKey("class")
+)
+
+// Outcomes of extractions and sanity checks.
+const (
+	OutcomeAttempted = "attempted"
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// Measures
+var (
+	Extractions  = stats.Int64("tvx/extractions", "Number of vector extractions, by outcome", stats.UnitDimensionless)
+	CARSize      = stats.Int64("tvx/car_size", "Size of the compressed CARs embedded in extracted vectors", stats.UnitBytes)
+	SanityChecks = stats.Int64("tvx/sanity_checks", "Number of extraction sanity checks, by outcome", stats.UnitDimensionless)
+)
+
+// Views
+var (
+	ExtractionsView = &view.View{
+		Measure:     Extractions,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Outcome, VectorClass},
+	}
+	CARSizeView = &view.View{
+		Measure:     CARSize,
+		Aggregation: view.Distribution(1<<10, 4<<10, 16<<10, 64<<10, 256<<10, 1<<20, 4<<20, 16<<20, 64<<20, 256<<20, 1<<30),
+	}
+	SanityChecksView = &view.View{
+		Measure:     SanityChecks,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Outcome},
+	}
+)
+
+// DefaultViews are the views exported by tvx serve, including the latency of
+// the requests to the backing node.
+var DefaultViews = []*view.View{
+	ExtractionsView,
+	CARSizeView,
+	SanityChecksView,
+	metrics.APIRequestDurationView,
+}
+
+// recordExtraction records an extraction outcome for a vector of the class.
+func recordExtraction(class, outcome string) {
+	ctx, _ := tag.New(context.Background(), tag.Upsert(Outcome, outcome), tag.Upsert(VectorClass, class))
+	stats.Record(ctx, Extractions.M(1))
+}
+
+// recordSanityCheck records the outcome of an extraction sanity check.
+func recordSanityCheck(passed bool) {
+	outcome := OutcomeSucceeded
+	if !passed {
+		outcome = OutcomeFailed
+	}
+	ctx, _ := tag.New(context.Background(), tag.Upsert(Outcome, outcome))
+	stats.Record(ctx, SanityChecks.M(1))
+}
+
+// carWritten records the size of a compressed CAR written into a vector, and
+// emits the corresponding progress event.
+func carWritten(size int) {
+	stats.Record(context.Background(), CARSize.M(int64(size)))
+	progress.emit(EventCARWritten, "bytes", size)
+}
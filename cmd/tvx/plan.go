@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// doExtractPlan resolves the plan of the extraction requested in the
+// options, and prints it to stdout, without extracting anything.
+func doExtractPlan(opts extractOpts) error {
+	if err := opts.resolveURL(); err != nil {
+		return err
+	}
+	if err := opts.resolveRelativeTarget(context.Background()); err != nil {
+		return err
+	}
+	plan, err := extractor.PlanExtraction(context.Background(), FullAPI, opts.extractorOptions())
+	if err != nil {
+		return err
+	}
+	return writePlan(os.Stdout, plan)
+}
+
+// writePlan writes the plan in human-readable form.
+func writePlan(w io.Writer, plan *extractor.Plan) error {
+	tw := tabwriter.NewWriter(w, 4, 2, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "class:\t%s\n", plan.Class)
+	_, _ = fmt.Fprintf(tw, "vectors:\t%d\n", plan.Vectors)
+	_, _ = fmt.Fprintf(tw, "base epoch:\t%d\n", plan.Epoch)
+	_, _ = fmt.Fprintf(tw, "network version:\t%d\n", plan.NetworkVersion)
+	_, _ = fmt.Fprintf(tw, "state retention:\t%s\n", plan.Retain)
+
+	if plan.Message.Defined() {
+		_, _ = fmt.Fprintf(tw, "message:\t%s\n", plan.Message)
+		_, _ = fmt.Fprintf(tw, "inclusion tipset:\t%s\n", plan.InclusionTipset)
+		_, _ = fmt.Fprintf(tw, "execution tipset:\t%s\n", plan.ExecutionTipset)
+		if len(plan.NullRounds) > 0 {
+			_, _ = fmt.Fprintf(tw, "null rounds:\t%v\n", plan.NullRounds)
+		}
+		applied := "squashed"
+		if plan.EmbedPrecursors {
+			applied = "embedded"
+		}
+		_, _ = fmt.Fprintf(tw, "precursors:\t%d (%s, %s); %d skipped\n", len(plan.Precursors), plan.Precursor, applied, plan.SkippedPrecursors)
+		for _, c := range plan.Precursors {
+			_, _ = fmt.Fprintf(tw, "\t%s\n", c)
+		}
+	}
+	for i, ts := range plan.Tipsets {
+		label := ""
+		if i == 0 {
+			label = "tipsets:"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\t%d messages\n", label, ts.Epoch, ts.Key, ts.Messages)
+	}
+
+	_, _ = fmt.Fprintf(tw, "actors involved:\t%d (%d not in the base state)\n", len(plan.Actors), plan.UnresolvedActors)
+	_, _ = fmt.Fprintf(tw, "estimated state:\tat most %s in %d blocks\n", units.BytesSize(float64(plan.StateSize)), plan.StateBlocks)
+	return tw.Flush()
+}
@@ -0,0 +1,40 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+func TestWritePlan(t *testing.T) {
+	mcid, err := cid.Decode("bafy2bzacebpxw3yiaxzy2bako62akig46x3imji7fewszen6fryiz6nymu2b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &extractor.Plan{
+		Class:             "message",
+		Vectors:           1,
+		Retain:            "accessed-cids",
+		Message:           mcid,
+		Precursor:         extractor.PrecursorSelectAll,
+		Precursors:        []cid.Cid{mcid},
+		SkippedPrecursors: 2,
+		StateSize:         2048,
+		StateBlocks:       10,
+	}
+
+	var b bytes.Buffer
+	if err := writePlan(&b, plan); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"message:", mcid.String(), "1 (all, squashed); 2 skipped", "at most 2KiB in 10 blocks"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("plan doesn't contain %q:\n%s", s, b.String())
+		}
+	}
+}
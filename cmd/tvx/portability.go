@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+var portabilityFlags struct {
+	fix     bool
+	network string
+}
+
+var portabilityCmd = &cli.Command{
+	Name: "portability",
+	Description: `flag test vectors whose behavior depends on the network they were extracted from.
+
+   Addresses are formatted with the prefix of the address network in effect
+   (f for mainnet, t for all other networks), which is that of the network a
+   vector declares through the 'network' selector, or else that of the
+   runner. The values of a vector holding formatted addresses, i.e. the
+   addresses asserted by its actor postconditions, and its recorded outcomes
+   of the signature and batch seal verification syscalls, which are keyed by
+   the addresses of their inputs, thus only match on runners of the network
+   they were produced on, unless the vector declares it: a calibnet vector
+   declaring no network silently misbehaves on a runner configured for
+   mainnet. Vectors declaring a network are flagged for address strings
+   formatted for the address network of another.
+
+   With --fix, the problems are fixed in place: vectors declaring no network
+   get the 'network' selector, set to the network supplied with --network,
+   restricting them to runners of that network, and the asserted addresses of
+   vectors declaring a network are rewritten with its prefix. The actors of
+   the state of a vector, genesis actors included, are embedded in its CAR,
+   and don't depend on the network.
+
+   The command fails if problems remain, e.g. in CI over a corpus.`,
+	ArgsUsage: "<vector file or dir>...",
+	Action:    runPortability,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "fix",
+			Usage:       "fix the problems found, rewriting vectors in place",
+			Destination: &portabilityFlags.fix,
+		},
+		&cli.StringFlag{
+			Name:        "network",
+			Usage:       "name of the network to restrict vectors declaring no network to, with --fix, e.g. 'calibrationnet'",
+			Destination: &portabilityFlags.network,
+		},
+	},
+}
+
+func runPortability(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no vector files or directories supplied")
+	}
+	ctx := context.Background()
+
+	var checked, fixed, unportable int
+	err := walkVectors(c.Args().Slice(), func(path string, tv *schema.TestVector) error {
+		checked++
+		problems, err := checkVectorPortability(ctx, tv)
+		if err != nil {
+			return fmt.Errorf("failed to check vector %s: %w", path, err)
+		}
+		if len(problems) == 0 {
+			return nil
+		}
+		if portabilityFlags.fix {
+			if inVectorArchive(path) {
+				log.Printf("not fixing vector %s: vectors in archives aren't rewritten", path)
+			} else if ok, err := fixVectorPortability(tv, portabilityFlags.network); err != nil {
+				return fmt.Errorf("failed to fix vector %s: %w", path, err)
+			} else if ok {
+				if problems, err = checkVectorPortability(ctx, tv); err != nil {
+					return fmt.Errorf("failed to check vector %s: %w", path, err)
+				}
+				if err := writeVector(tv, path); err != nil {
+					return fmt.Errorf("failed to write vector %s: %w", path, err)
+				}
+				fixed++
+			}
+		}
+		for _, p := range problems {
+			log.Printf("%s: %s", path, p)
+		}
+		if len(problems) > 0 {
+			unportable++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("checked %d vectors; fixed %d; %d not portable", checked, fixed, unportable)
+	if unportable > 0 {
+		return fmt.Errorf("%d vectors depend on the network they were extracted from", unportable)
+	}
+	return nil
+}
+
+// checkVectorPortability returns the portability problems of the vector; see
+// conformance.CheckPortability.
+func checkVectorPortability(ctx context.Context, tv *schema.TestVector) ([]string, error) {
+	bs, err := conformance.LoadBlockstore(tv.CAR)
+	if err != nil {
+		return nil, err
+	}
+	return conformance.CheckPortability(ctx, bs, tv)
+}
+
+// fixVectorPortability restricts the vector to the network, if it declares
+// none and one is supplied, and normalizes its asserted addresses to the
+// network it declares. It returns whether the vector was changed.
+func fixVectorPortability(tv *schema.TestVector, network string) (bool, error) {
+	var changed bool
+	if _, ok := tv.Selector[conformance.SelectorNetwork]; !ok {
+		if network == "" {
+			return false, nil
+		}
+		if tv.Selector == nil {
+			tv.Selector = make(schema.Selector)
+		}
+		tv.Selector[conformance.SelectorNetwork] = network
+		changed = true
+	}
+	n, err := conformance.NormalizeAddressPrefixes(tv)
+	if err != nil {
+		return false, err
+	}
+	return changed || n > 0, nil
+}
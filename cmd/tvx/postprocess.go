@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"plugin"
+	"strings"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// EventVectorRejected is the progress event emitted when a post-processor
+// rejects a vector, which isn't written.
+const EventVectorRejected = "vector_rejected"
+
+// PostProcessSymbol is the symbol Go plugins supplied to tvx extract
+// --post-process export: a function of type PostProcessFunc.
+const PostProcessSymbol = "PostProcess"
+
+// PostProcessFunc post-processes a vector before it's written: it returns
+// the vector to write, rewritten, annotated or renamed (through its ID) as
+// required, or nil to reject it.
+type PostProcessFunc = func(tv *schema.TestVector) (*schema.TestVector, error)
+
+// vectorPostProcessor post-processes the vectors written by tvx extract.
+type vectorPostProcessor interface {
+	// name identifies the post-processor in logs.
+	name() string
+	process(tv *schema.TestVector) (*schema.TestVector, error)
+}
+
+// postProcessors post-process the vectors written by tvx extract, in order,
+// if supplied with --post-process.
+var postProcessors []vectorPostProcessor
+
+// openPostProcessor opens the post-processor of the spec: the path of a Go
+// plugin if it ends in .so, and a command, run through the shell, otherwise.
+func openPostProcessor(spec string) (vectorPostProcessor, error) {
+	if strings.HasSuffix(spec, ".so") {
+		return openPluginPostProcessor(spec)
+	}
+	return &commandPostProcessor{command: spec}, nil
+}
+
+// pluginPostProcessor post-processes vectors with the PostProcess function
+// of a Go plugin. Plugins must be built with the same version of Go, and of
+// the test-vectors schema, as tvx.
+type pluginPostProcessor struct {
+	path string
+	fn   PostProcessFunc
+}
+
+func openPluginPostProcessor(path string) (*pluginPostProcessor, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open post-processing plugin: %w", err)
+	}
+	sym, err := p.Lookup(PostProcessSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("post-processing plugin %s: %w", path, err)
+	}
+	// exported functions are looked up as such, and variables as pointers.
+	switch fn := sym.(type) {
+	case PostProcessFunc:
+		return &pluginPostProcessor{path: path, fn: fn}, nil
+	case *PostProcessFunc:
+		return &pluginPostProcessor{path: path, fn: *fn}, nil
+	default:
+		return nil, fmt.Errorf("post-processing plugin %s: %s is a %T, not a func(*schema.TestVector) (*schema.TestVector, error)", path, PostProcessSymbol, sym)
+	}
+}
+
+func (p *pluginPostProcessor) name() string { return p.path }
+
+func (p *pluginPostProcessor) process(tv *schema.TestVector) (*schema.TestVector, error) {
+	return p.fn(tv)
+}
+
+// commandPostProcessor post-processes vectors with an external command, run
+// through the shell once per vector: it's fed the vector as JSON on stdin,
+// and writes the vector to write on stdout, or nothing to reject it. It
+// fails the write by exiting with a non-zero status; its stderr is logged.
+type commandPostProcessor struct {
+	command string
+}
+
+func (p *commandPostProcessor) name() string { return p.command }
+
+func (p *commandPostProcessor) process(tv *schema.TestVector) (*schema.TestVector, error) {
+	in, err := json.Marshal(tv)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", p.command)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &out
+	cmd.Stderr = logOutput
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(out.Bytes())) == 0 {
+		return nil, nil
+	}
+	var ret schema.TestVector
+	if err := json.Unmarshal(out.Bytes(), &ret); err != nil {
+		return nil, fmt.Errorf("invalid vector written: %w", err)
+	}
+	return &ret, nil
+}
+
+// postProcessVector applies the post-processors to the vector, and returns
+// the vector to write, or nil if a post-processor rejected it.
+func postProcessVector(tv *schema.TestVector) (*schema.TestVector, error) {
+	id := tv.Meta.ID
+	for _, p := range postProcessors {
+		ret, err := p.process(tv)
+		if err != nil {
+			return nil, fmt.Errorf("post-processor %s failed on vector %s: %w", p.name(), id, err)
+		}
+		if ret == nil {
+			extractLog.Infow("vector rejected by post-processor", "id", id, "post_processor", p.name())
+			progress.emit(EventVectorRejected, "id", id, "post_processor", p.name())
+			return nil, nil
+		}
+		if ret.Meta == nil || ret.Meta.ID == "" {
+			return nil, fmt.Errorf("post-processor %s stripped the ID of vector %s", p.name(), id)
+		}
+		tv = ret
+	}
+	return tv, nil
+}
+
+// renamedVectorFile returns the file to write a vector renamed from the ID
+// from to another to: the file is renamed likewise if it's named after the
+// ID, whatever its extensions, and left as is otherwise.
+func renamedVectorFile(file, from, to string) string {
+	if from == to || isStdout(file) {
+		return file
+	}
+	dir, base := filepath.Split(file)
+	if !strings.HasPrefix(base, from+".") {
+		return file
+	}
+	return dir + to + strings.TrimPrefix(base, from)
+}
@@ -0,0 +1,60 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestPostProcessVector(t *testing.T) {
+	defer func() { postProcessors = nil }()
+
+	tv := func() *schema.TestVector {
+		return &schema.TestVector{Class: schema.ClassMessage, Meta: &schema.Metadata{ID: "a"}}
+	}
+
+	// rewrites, then renames, in order.
+	postProcessors = []vectorPostProcessor{
+		&commandPostProcessor{command: `sed 's/"comment":"[^"]*"/"comment":"reviewed"/'`},
+		&commandPostProcessor{command: `sed 's/"id":"a"/"id":"policy-a"/'`},
+	}
+	in := tv()
+	in.Meta.Comment = "raw"
+	out, err := postProcessVector(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out == nil || out.Meta.ID != "policy-a" || out.Meta.Comment != "reviewed" {
+		t.Fatalf("expected the vector to be rewritten and renamed; got %+v", out)
+	}
+
+	// nothing written rejects the vector.
+	postProcessors = []vectorPostProcessor{&commandPostProcessor{command: "cat >/dev/null"}}
+	if out, err := postProcessVector(tv()); err != nil || out != nil {
+		t.Errorf("expected the vector to be rejected; got %v, %v", out, err)
+	}
+
+	// a non-zero status fails the write.
+	postProcessors = []vectorPostProcessor{&commandPostProcessor{command: "exit 3"}}
+	if _, err := postProcessVector(tv()); err == nil {
+		t.Error("expected a failing post-processor to fail the write")
+	}
+}
+
+func TestRenamedVectorFile(t *testing.T) {
+	for _, tc := range []struct {
+		file, expected string
+	}{
+		{"out/a.json", "out/b.json"},
+		{"out/a.cbor.gz", "out/b.cbor.gz"},
+		{"s3://bucket/prefix/a.json", "s3://bucket/prefix/b.json"},
+		{"out/vector.json", "out/vector.json"},
+		{"out/ab.json", "out/ab.json"},
+		{"-", "-"},
+	} {
+		if file := renamedVectorFile(tc.file, "a", "b"); file != tc.expected {
+			t.Errorf("%s: expected %s; got %s", tc.file, tc.expected, file)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+
+	"github.com/urfave/cli/v2"
+)
+
+var profileFlags struct {
+	cpuprofile string
+	memprofile string
+	trace      string
+}
+
+// profileCmdFlags are the profiling flags shared by the commands that execute
+// vectors.
+var profileCmdFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:        "cpuprofile",
+		Usage:       "write a pprof CPU profile of the execution to the specified file",
+		TakesFile:   true,
+		Destination: &profileFlags.cpuprofile,
+	},
+	&cli.StringFlag{
+		Name:        "memprofile",
+		Usage:       "write a pprof heap profile to the specified file once execution finishes",
+		TakesFile:   true,
+		Destination: &profileFlags.memprofile,
+	},
+	&cli.StringFlag{
+		Name:        "trace",
+		Usage:       "write a runtime execution trace to the specified file, for 'go tool trace'",
+		TakesFile:   true,
+		Destination: &profileFlags.trace,
+	},
+}
+
+// startProfiling starts the profiles requested through the profiling flags.
+// The returned function stops them, and writes the heap profile; it must be
+// called once execution finishes, even if starting the profiles failed.
+func startProfiling() (stop func(), err error) {
+	var stops []func()
+	stop = func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+	}
+
+	if path := profileFlags.cpuprofile; path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return stop, fmt.Errorf("failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			_ = f.Close()
+			return stop, fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			_ = f.Close()
+			log.Printf("wrote CPU profile to %s", path)
+		})
+	}
+
+	if path := profileFlags.trace; path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return stop, fmt.Errorf("failed to create execution trace: %w", err)
+		}
+		if err := trace.Start(f); err != nil {
+			_ = f.Close()
+			return stop, fmt.Errorf("failed to start execution trace: %w", err)
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			_ = f.Close()
+			log.Printf("wrote execution trace to %s", path)
+		})
+	}
+
+	if path := profileFlags.memprofile; path != "" {
+		stops = append(stops, func() {
+			f, err := os.Create(path)
+			if err != nil {
+				log.Printf("failed to create heap profile: %s", err)
+				return
+			}
+			defer f.Close() //nolint:errcheck
+
+			runtime.GC() // get up-to-date statistics.
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				log.Printf("failed to write heap profile: %s", err)
+				return
+			}
+			log.Printf("wrote heap profile to %s", path)
+		})
+	}
+
+	return stop, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+// extractLog is the leveled, structured logger of the extraction commands.
+var extractLog = logging.Logger("tvx/extract")
+
+// Progress events emitted by the extraction commands, besides those emitted
+// by the extractions themselves (see the extractor package).
+const (
+	EventCARWritten    = "car_written"
+	EventVectorWritten = "vector_written"
+)
+
+// progressEvent is a machine-readable progress event, emitted as a line of
+// JSON to the --progress-json stream.
+type progressEvent struct {
+	Time   time.Time              `json:"time"`
+	Event  string                 `json:"event"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// progressWriter writes progress events as newline-delimited JSON. A nil
+// progressWriter discards all events.
+type progressWriter struct {
+	lk  sync.Mutex
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+// progress is the progress event stream, if enabled with --progress-json.
+var progress *progressWriter
+
+// openProgress opens the progress event stream at path, which may be a
+// regular file or a named pipe.
+func openProgress(path string) (*progressWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open progress stream %s: %w", path, err)
+	}
+	return &progressWriter{w: f, enc: json.NewEncoder(f)}, nil
+}
+
+// emit emits an event with the supplied fields, in key-value pairs.
+func (p *progressWriter) emit(event string, kvs ...interface{}) {
+	if p == nil {
+		return
+	}
+	e := progressEvent{Time: time.Now(), Event: event}
+	if len(kvs) > 0 {
+		e.Fields = make(map[string]interface{}, len(kvs)/2)
+		for i := 0; i+1 < len(kvs); i += 2 {
+			e.Fields[fmt.Sprint(kvs[i])] = kvs[i+1]
+		}
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if err := p.enc.Encode(&e); err != nil {
+		extractLog.Warnw("failed to emit progress event", "event", event, "error", err)
+	}
+}
+
+func (p *progressWriter) Close() error {
+	if p == nil {
+		return nil
+	}
+	return p.w.Close()
+}
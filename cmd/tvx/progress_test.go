@@ -0,0 +1,46 @@
+// stm: #unit
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+func TestProgressEvents(t *testing.T) {
+	// a nil stream discards events.
+	var nilp *progressWriter
+	nilp.emit(EventCARWritten, "bytes", 1)
+
+	path := filepath.Join(t.TempDir(), "progress.ndjson")
+	p, err := openProgress(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.emit(extractor.EventPrecursorApplied, "index", 0, "total", 2)
+	p.emit(EventCARWritten, "bytes", 1024)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 events; got %d:\n%s", len(lines), b)
+	}
+
+	var e progressEvent
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Event != EventCARWritten || e.Fields["bytes"] != float64(1024) {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+}
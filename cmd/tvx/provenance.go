@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// lotusGenSource is the source of the generation data entry recording the
+// version of Lotus a vector was generated with.
+const lotusGenSource = "github.com/filecoin-project/lotus"
+
+var provenanceFlags struct {
+	out    string
+	verify bool
+}
+
+var provenanceCmd = &cli.Command{
+	Name: "provenance",
+	Description: `generate the provenance manifest of a corpus, for publication alongside it.
+
+   The manifest is a JSON document listing, for every vector of the
+   directories, archives and files supplied, its path, ID, class and SHA-256
+   digest, and its provenance, as recorded in its metadata and selector: the
+   network it was extracted from, the messages and tipsets it was extracted
+   from, the version of Lotus that generated it, the time it was extracted
+   at (if recorded, see tvx extract --record-extraction-time), and its signer and signature, if signed (see tvx extract --sign-key).
+   Fields a vector doesn't record are omitted, e.g. the messages of built
+   vectors. With --verify-signatures, signatures are verified, and their
+   validity recorded.`,
+	ArgsUsage: "<vector file or dir>...",
+	Action:    runProvenance,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Usage:       "file to write the manifest to; stdout if not supplied",
+			TakesFile:   true,
+			Destination: &provenanceFlags.out,
+		},
+		&cli.BoolFlag{
+			Name:        "verify-signatures",
+			Usage:       "verify the signatures of signed vectors, recording whether they're valid",
+			Destination: &provenanceFlags.verify,
+		},
+	},
+}
+
+// provenanceManifest is the provenance manifest of a corpus.
+type provenanceManifest struct {
+	Generated time.Time `json:"generated"`
+	// Generator is the version of Lotus generating the manifest.
+	Generator string             `json:"generator"`
+	Vectors   []vectorProvenance `json:"vectors"`
+}
+
+// vectorProvenance is the provenance of a vector of a corpus.
+type vectorProvenance struct {
+	Path   string       `json:"path"`
+	ID     string       `json:"id"`
+	Class  schema.Class `json:"class"`
+	SHA256 string       `json:"sha256"`
+	// Network is the name of the network the vector was extracted from.
+	Network  string   `json:"network,omitempty"`
+	Messages []string `json:"messages,omitempty"`
+	Tipsets  []string `json:"tipsets,omitempty"`
+	// LotusVersion is the version of Lotus that generated the vector.
+	LotusVersion string `json:"lotus_version,omitempty"`
+	ExtractedAt  string `json:"extracted_at,omitempty"`
+	Signer       string `json:"signer,omitempty"`
+	Signature    string `json:"signature,omitempty"`
+	// SignatureValid is recorded for signed vectors if signatures are
+	// verified.
+	SignatureValid *bool `json:"signature_valid,omitempty"`
+}
+
+func runProvenance(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no vector files or directories supplied")
+	}
+	manifest := provenanceManifest{Generated: time.Now().UTC(), Generator: build.UserVersion()}
+	var signed, invalid, undated int
+	for _, root := range c.Args().Slice() {
+		err := walkVectorFiles(root, func(path string, content []byte) error {
+			var tv schema.TestVector
+			if err := json.Unmarshal(content, &tv); err != nil || tv.Class == "" {
+				log.Printf("failed to decode test vector %s; skipping", path)
+				return nil
+			}
+			p := vectorProvenanceOf(path, content, &tv)
+			if p.Signature != "" {
+				signed++
+				if provenanceFlags.verify {
+					_, err := verifyVector(&tv)
+					valid := err == nil
+					p.SignatureValid = &valid
+					if !valid {
+						invalid++
+						log.Printf("vector %s: %s", path, err)
+					}
+				}
+			}
+			if p.ExtractedAt == "" {
+				undated++
+			}
+			manifest.Vectors = append(manifest.Vectors, p)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if provenanceFlags.out == "" {
+		_, err = os.Stdout.Write(data)
+	} else if err = os.WriteFile(provenanceFlags.out, data, 0644); err != nil {
+		err = fmt.Errorf("failed to write provenance manifest: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("recorded the provenance of %d vectors: %d signed, %d with invalid signatures; %d without an extraction time",
+		len(manifest.Vectors), signed, invalid, undated)
+	return nil
+}
+
+// vectorProvenanceOf returns the provenance of the vector, decoded from the
+// content of its file at the path.
+func vectorProvenanceOf(path string, content []byte, tv *schema.TestVector) vectorProvenance {
+	digest := sha256.Sum256(content)
+	p := vectorProvenance{
+		Path:    path,
+		Class:   tv.Class,
+		SHA256:  hex.EncodeToString(digest[:]),
+		Network: tv.Selector[conformance.SelectorNetwork],
+	}
+	if tv.Meta == nil {
+		return p
+	}
+	p.ID = tv.Meta.ID
+	for _, g := range tv.Meta.Gen {
+		switch g.Source {
+		case lotusGenSource:
+			p.LotusVersion = g.Version
+		case extractor.GenExtractedAt:
+			p.ExtractedAt = g.Version
+		case GenSigner:
+			p.Signer = g.Version
+		case GenSignature:
+			p.Signature = g.Version
+		}
+		kind, value, ok := strings.Cut(g.Source, ":")
+		if !ok {
+			continue
+		}
+		switch kind {
+		case "network":
+			if p.Network == "" {
+				p.Network = value
+			}
+		case "message":
+			p.Messages = append(p.Messages, value)
+		case "tipset":
+			p.Tipsets = append(p.Tipsets, value)
+		}
+	}
+	if p.Network != "" {
+		p.Network = conformance.NormalizeNetworkName(p.Network)
+	}
+	return p
+}
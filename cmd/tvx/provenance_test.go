@@ -0,0 +1,55 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+func TestVectorProvenanceOf(t *testing.T) {
+	tv := &schema.TestVector{
+		Class:    schema.ClassMessage,
+		Selector: schema.Selector{conformance.SelectorNetwork: "testnetnet"},
+		Meta: &schema.Metadata{
+			ID: "send-ok",
+			Gen: []schema.GenerationData{
+				{Source: "network:calibrationnet"},
+				{Source: "message:bafy2bzacea"},
+				{Source: "inclusion_tipset:{bafy2bzaceb}"},
+				{Source: lotusGenSource, Version: "1.20.0+mainnet"},
+				{Source: extractor.GenExtractedAt, Version: "2023-01-02T03:04:05Z"},
+				{Source: GenSigner, Version: "ed25519:abcd"},
+				{Source: GenSignature, Version: "c2ln"},
+			},
+		},
+	}
+	p := vectorProvenanceOf("corpus/send-ok.json", []byte("{}"), tv)
+	if p.ID != "send-ok" || p.Class != schema.ClassMessage || p.Path != "corpus/send-ok.json" {
+		t.Errorf("unexpected identity %+v", p)
+	}
+	// the selector prevails, normalized.
+	if p.Network != "mainnet" {
+		t.Errorf("expected network mainnet, got %s", p.Network)
+	}
+	if len(p.Messages) != 1 || p.Messages[0] != "bafy2bzacea" || len(p.Tipsets) != 0 {
+		t.Errorf("unexpected messages %v or tipsets %v", p.Messages, p.Tipsets)
+	}
+	if p.LotusVersion != "1.20.0+mainnet" || p.ExtractedAt != "2023-01-02T03:04:05Z" {
+		t.Errorf("unexpected version %s or extraction time %s", p.LotusVersion, p.ExtractedAt)
+	}
+	if p.Signer != "ed25519:abcd" || p.Signature != "c2ln" {
+		t.Errorf("unexpected signer %s or signature %s", p.Signer, p.Signature)
+	}
+	if p.SHA256 != "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a" {
+		t.Errorf("unexpected digest %s", p.SHA256)
+	}
+
+	// vectors without metadata are listed regardless.
+	if p := vectorProvenanceOf("x.json", nil, &schema.TestVector{Class: schema.ClassTipset}); p.ID != "" || p.Network != "" {
+		t.Errorf("unexpected provenance %+v", p)
+	}
+}
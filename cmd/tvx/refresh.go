@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+var refreshFlags struct {
+	out       string
+	retain    string
+	precursor string
+	keepGoing bool
+}
+
+var refreshCmd = &cli.Command{
+	Name: "refresh",
+	Description: `regenerate existing test vectors from the chain, with the current schema, state retention and metadata conventions.
+
+   The source of each vector (its message, tipsets, implicit message or
+   network upgrade) is read from its generation metadata, as recorded by tvx
+   extract, and re-extracted from the connected node, which must retain the
+   state at the epochs of the vector. The ID, description, comment, tags,
+   hints and selectors of the original vector are carried over.
+
+   Vectors are rewritten in place, unless --out is supplied, in which case they
+   are written to that directory under their original file names. Vectors
+   without source metadata (e.g. anonymized or hand-written vectors) are
+   skipped.`,
+	ArgsUsage: "<vector file or dir>...",
+	Action:    runRefresh,
+	Before:    initialize,
+	After:     destroy,
+	Flags: []cli.Flag{
+		&repoFlag,
+		&apiFlag,
+		&rpcQPSFlag,
+		&rpcConcurrencyFlag,
+		&repoDirectFlag,
+		&selfCheckFlag,
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Usage:       "directory to write the regenerated vectors to; if empty, vectors are rewritten in place",
+			Destination: &refreshFlags.out,
+		},
+		&cli.StringFlag{
+			Name:        "state-retain",
+			Usage:       "state retention policy; values: 'accessed-cids', 'accessed-actors'",
+			Value:       "accessed-cids",
+			Destination: &refreshFlags.retain,
+		},
+		&cli.StringFlag{
+			Name:        "precursor-select",
+			Usage:       "precursors to apply to message vectors; values: 'all', 'participants'",
+			Value:       extractor.PrecursorSelectParticipants,
+			Destination: &refreshFlags.precursor,
+		},
+		&cli.BoolFlag{
+			Name:        "keep-going",
+			Usage:       "continue regenerating the remaining vectors when one fails",
+			Destination: &refreshFlags.keepGoing,
+		},
+	},
+}
+
+func runRefresh(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no vector files or directories supplied")
+	}
+	if refreshFlags.out != "" {
+		if err := ensureDir(refreshFlags.out); err != nil {
+			return err
+		}
+	}
+
+	var refreshed, skipped, failed int
+	err := walkVectors(c.Args().Slice(), func(path string, tv *schema.TestVector) error {
+		opts, err := refreshOpts(tv)
+		if err != nil {
+			log.Printf("skipping vector %s: %s", path, err)
+			skipped++
+			return nil
+		}
+		opts.retain = refreshFlags.retain
+		opts.precursor = refreshFlags.precursor
+		opts.file = path
+		if refreshFlags.out != "" {
+			opts.file = filepath.Join(refreshFlags.out, filepath.Base(path))
+		} else if inVectorArchive(path) {
+			log.Printf("skipping vector %s: vectors in archives are refreshed with --out", path)
+			skipped++
+			return nil
+		}
+
+		log.Printf("refreshing vector %s (%s)", path, opts.class)
+		if err := refreshVector(opts, tv.Meta); err != nil {
+			failed++
+			if !refreshFlags.keepGoing {
+				return fmt.Errorf("failed to refresh vector %s: %w", path, err)
+			}
+			log.Printf("failed to refresh vector %s: %s", path, err)
+			return nil
+		}
+		refreshed++
+		return nil
+	})
+	log.Printf("refreshed %d vectors; skipped %d, failed %d", refreshed, skipped, failed)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to refresh %d vectors", failed)
+	}
+	return nil
+}
+
+// refreshOpts returns the extraction options that regenerate the vector, as
+// derived from its generation metadata.
+func refreshOpts(tv *schema.TestVector) (extractOpts, error) {
+	opts := extractOpts{class: string(tv.Class), hints: tv.Hints}
+	if tv.Meta == nil {
+		return opts, fmt.Errorf("no metadata")
+	}
+	opts.id = tv.Meta.ID
+	for k, v := range tv.Selector {
+		if k == schema.SelectorMinProtocolVersion {
+			// recomputed on extraction.
+			continue
+		}
+		opts.selectors = append(opts.selectors, k+"="+v)
+	}
+
+	var tipsets []string
+	for _, g := range tv.Meta.Gen {
+		kind, value, ok := strings.Cut(g.Source, ":")
+		if !ok {
+			continue
+		}
+		switch kind {
+		case "message":
+			opts.cid = value
+		case "implicit":
+			opts.implicit, opts.miner, _ = strings.Cut(value, ":")
+		case "tipset":
+			tipsets = append(tipsets, strings.Trim(value, "{}"))
+		}
+	}
+
+	switch {
+	case tv.Class == schema.ClassMessage && opts.cid != "":
+		opts.class = "message"
+	case tv.Class == schema.ClassMessage && opts.implicit != "" && len(tipsets) == 1:
+		opts.class = "implicit"
+		opts.tsk = tipsets[0]
+	case (tv.Class == schema.ClassTipset || tv.Class == schema.ClassBlockSeq) && len(tipsets) > 0:
+		opts.tsk = tipsets[0]
+		if len(tipsets) > 1 {
+			opts.tsk += ".." + tipsets[len(tipsets)-1]
+			opts.squash = true
+		}
+	case tv.Class == conformance.ClassMigration && tv.Pre != nil && len(tv.Pre.Variants) > 0:
+		opts.epoch = tv.Pre.Variants[0].Epoch
+	default:
+		return opts, fmt.Errorf("no source recorded in the metadata of %s vector", tv.Class)
+	}
+	return opts, nil
+}
+
+// refreshVector re-extracts the vector, and carries the curated metadata of
+// the original vector over to the regenerated one.
+func refreshVector(opts extractOpts, meta *schema.Metadata) error {
+	if err := doExtract(opts); err != nil {
+		return err
+	}
+
+	tv, err := readVector(opts.file)
+	if err != nil {
+		return fmt.Errorf("failed to decode regenerated vector: %w", err)
+	}
+	tv.Meta.ID = meta.ID
+	tv.Meta.Desc = meta.Desc
+	tv.Meta.Comment = meta.Comment
+	tv.Meta.Tags = meta.Tags
+	return writeVector(tv, opts.file)
+}
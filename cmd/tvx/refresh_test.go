@@ -0,0 +1,76 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestRefreshOpts(t *testing.T) {
+	gen := func(sources ...string) *schema.Metadata {
+		m := &schema.Metadata{ID: "v"}
+		for _, s := range sources {
+			m.Gen = append(m.Gen, schema.GenerationData{Source: s})
+		}
+		return m
+	}
+
+	for _, tc := range []struct {
+		name     string
+		tv       schema.TestVector
+		expected extractOpts
+	}{
+		{
+			name: "message",
+			tv: schema.TestVector{
+				Class:    schema.ClassMessage,
+				Meta:     gen("network:mainnet", "message:bafymsg", "inclusion_tipset:{bafyinc}"),
+				Selector: schema.Selector{schema.SelectorMinProtocolVersion: "nv16", "chaos_actor": "true"},
+				Hints:    []string{"incorrect-gas"},
+			},
+			expected: extractOpts{id: "v", class: "message", cid: "bafymsg"},
+		},
+		{
+			name: "implicit",
+			tv: schema.TestVector{
+				Class: schema.ClassMessage,
+				Meta:  gen("implicit:reward:f01000", "tipset:{bafya,bafyb}", "execution_tipset:{bafyc}"),
+			},
+			expected: extractOpts{id: "v", class: "implicit", implicit: "reward", miner: "f01000", tsk: "bafya,bafyb"},
+		},
+		{
+			name: "tipset range",
+			tv: schema.TestVector{
+				Class: schema.ClassTipset,
+				Meta:  gen("tipset:{bafya}", "tipset:{bafyb}", "tipset:{bafyc}"),
+			},
+			expected: extractOpts{id: "v", class: "tipset", tsk: "bafya..bafyc", squash: true},
+		},
+		{
+			name: "migration",
+			tv: schema.TestVector{
+				Class: conformance.ClassMigration,
+				Meta:  gen("upgrade:nv16"),
+				Pre:   &schema.Preconditions{Variants: []schema.Variant{{Epoch: 1960320}}},
+			},
+			expected: extractOpts{id: "v", class: "migration", epoch: 1960320},
+		},
+	} {
+		opts, err := refreshOpts(&tc.tv)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if opts.id != tc.expected.id || opts.class != tc.expected.class || opts.cid != tc.expected.cid ||
+			opts.implicit != tc.expected.implicit || opts.miner != tc.expected.miner || opts.tsk != tc.expected.tsk ||
+			opts.squash != tc.expected.squash || opts.epoch != tc.expected.epoch {
+			t.Errorf("%s: unexpected options %+v", tc.name, opts)
+		}
+	}
+
+	if _, err := refreshOpts(&schema.TestVector{Class: schema.ClassMessage, Meta: gen("network:mainnet")}); err == nil {
+		t.Fatal("expected error for vector without source")
+	}
+}
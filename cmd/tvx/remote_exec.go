@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// The remote execution protocol lets tvx exec dispatch vectors to the
+// execution endpoint of another implementation, over HTTP/JSON, and assert
+// its results against the postconditions of the vectors, as it does those of
+// Lotus. As vectors are extracted from, and their postconditions computed
+// by, Lotus, a corpus run against a remote executor is a differential test of
+// the two implementations. The endpoint serves:
+//
+//   - GET <url>/v1/info, returning a remoteExecInfo, which identifies the
+//     implementation.
+//   - POST <url>/v1/execute, taking a remoteExecRequest, and returning a
+//     remoteExecResponse with status 200. Vectors the implementation doesn't
+//     support, e.g. of classes it can't execute, are answered with status
+//     501, and a plain-text reason as body; they're reported as unsupported.
+//     Any other status fails the vector, with the body as reason.
+
+// remoteExecInfo identifies the implementation behind an execution endpoint.
+type remoteExecInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+func (i remoteExecInfo) String() string {
+	return i.Name + " " + i.Version
+}
+
+// remoteExecRequest requests the execution of a variant of a vector.
+type remoteExecRequest struct {
+	Vector  *schema.TestVector `json:"vector"`
+	Variant string             `json:"variant"`
+}
+
+// remoteExecResponse holds the results of the execution of a variant: the
+// receipts of the messages applied, implicit ones excluded, in order, the
+// receipts roots of the tipsets applied, for tipset and blockseq vectors,
+// and the post state root. Error, if set, is why the implementation failed
+// to execute the variant.
+type remoteExecResponse struct {
+	Receipts      []*schema.Receipt `json:"receipts,omitempty"`
+	ReceiptsRoots []cid.Cid         `json:"receipts_roots,omitempty"`
+	StateRoot     *cid.Cid          `json:"state_root,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// remoteExecutor executes vectors through an execution endpoint.
+type remoteExecutor struct {
+	url    string
+	client *http.Client
+	info   remoteExecInfo
+}
+
+// execExecutor is the remote executor of tvx exec, if requested with
+// --executor.
+var execExecutor *remoteExecutor
+
+// newRemoteExecutor returns an executor for the endpoint at the url,
+// identifying the implementation behind it.
+func newRemoteExecutor(url string, timeout time.Duration) (*remoteExecutor, error) {
+	e := &remoteExecutor{
+		url:    strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: timeout},
+	}
+	resp, err := e.client.Get(e.url + "/v1/info")
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach executor %s: %w", url, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to identify executor %s: %s", url, readErrorBody(resp))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&e.info); err != nil {
+		return nil, fmt.Errorf("failed to decode the info of executor %s: %w", url, err)
+	}
+	return e, nil
+}
+
+// executeVariant executes the variant of the vector on the endpoint, and
+// asserts the results against the postconditions of the vector, with the
+// assertion options and hooks of opts; the other options are the endpoint's
+// own. The request is canceled once ctx is done. The state of the
+// implementation isn't accessible: actor postconditions aren't asserted, and
+// no state diffs are returned.
+func (e *remoteExecutor) executeVariant(ctx context.Context, r conformance.Reporter, tv *schema.TestVector, v *schema.Variant, opts conformance.ExecuteOpts) (diffs []string, err error) {
+	body, err := json.Marshal(remoteExecRequest{Vector: tv, Variant: v.ID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode vector: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+"/v1/execute", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		r.Errorf("failed to execute the vector on %s: %s", e.info, err)
+		return nil, fmt.Errorf("failed to execute the vector on %s: %w", e.info, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotImplemented:
+		return nil, fmt.Errorf("%w: %s doesn't support the vector: %s", conformance.ErrUnsupportedClass, e.info, readErrorBody(resp))
+	default:
+		err := fmt.Errorf("%s failed to execute the vector: %s", e.info, readErrorBody(resp))
+		r.Errorf("%s", err)
+		return nil, err
+	}
+	var res remoteExecResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		r.Errorf("failed to decode the results of %s: %s", e.info, err)
+		return nil, fmt.Errorf("failed to decode the results of %s: %w", e.info, err)
+	}
+	if res.Error != "" {
+		r.Errorf("%s failed to execute the vector: %s", e.info, res.Error)
+		return nil, fmt.Errorf("%s failed to execute the vector: %s", e.info, res.Error)
+	}
+
+	assertRemoteResults(r, tv, &res, opts)
+	return nil, nil
+}
+
+// assertRemoteResults asserts the results of a remote execution against the
+// postconditions of the vector, and dispatches them to the hooks of opts, as
+// the driver does those of a local one.
+func assertRemoteResults(r conformance.Reporter, tv *schema.TestVector, res *remoteExecResponse, opts conformance.ExecuteOpts) {
+	aopts := opts.Assert
+	if conformance.HasHint(tv, conformance.HintIncorrectGas) {
+		aopts.IgnoreGas = true
+	}
+
+	if expected := tv.Post.Receipts; len(expected) > 0 {
+		if len(res.Receipts) != len(expected) {
+			r.Errorf("number of receipts did not match; expected: %d, got: %d", len(expected), len(res.Receipts))
+		}
+		for i, rct := range res.Receipts {
+			ret := &vm.ApplyRet{MessageReceipt: types.MessageReceipt{
+				ExitCode: exitcode.ExitCode(rct.ExitCode),
+				Return:   rct.ReturnValue,
+				GasUsed:  rct.GasUsed,
+			}}
+			if h := opts.Hooks; h != nil && h.OnSubcall != nil {
+				h.OnSubcall(0, &types.ExecutionTrace{MsgRct: &ret.MessageReceipt})
+			}
+			if i < len(expected) {
+				conformance.AssertMsgResultWithOpts(r, expected[i], ret, strconv.Itoa(i), aopts)
+			}
+		}
+	}
+
+	if expected := tv.Post.ReceiptsRoots; len(expected) > 0 {
+		if len(res.ReceiptsRoots) != len(expected) {
+			r.Errorf("number of receipts roots did not match; expected: %d, got: %d", len(expected), len(res.ReceiptsRoots))
+		}
+		for i := 0; i < len(expected) && i < len(res.ReceiptsRoots); i++ {
+			if !expected[i].Equals(res.ReceiptsRoots[i]) {
+				r.Errorf("receipts root of tipset %d did not match; expected: %s, got: %s", i, expected[i], res.ReceiptsRoots[i])
+			}
+		}
+	}
+
+	if res.StateRoot != nil {
+		if h := opts.Hooks; h != nil && h.OnStateRoot != nil {
+			h.OnStateRoot(*res.StateRoot)
+		}
+	}
+	if tv.Post.StateTree == nil || !tv.Post.StateTree.RootCID.Defined() {
+		if _, ok := tv.Selector[conformance.SelectorActorPostconditions]; ok {
+			r.Logf("the actor postconditions aren't asserted against remote executions")
+		}
+		return
+	}
+	switch expected := tv.Post.StateTree.RootCID; {
+	case res.StateRoot == nil:
+		r.Errorf("the executor returned no post state root; expected: %s", expected)
+	case !expected.Equals(*res.StateRoot):
+		r.Errorf("wrong post root cid; expected %v, but got %v", expected, *res.StateRoot)
+	}
+}
+
+// readErrorBody returns the status of the response, with its body, if any,
+// as the reason.
+func readErrorBody(resp *http.Response) string {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if msg := strings.TrimSpace(string(b)); msg != "" {
+		return fmt.Sprintf("%s: %s", resp.Status, msg)
+	}
+	return resp.Status
+}
@@ -0,0 +1,140 @@
+// stm: #unit
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestRemoteExecutor(t *testing.T) {
+	root, err := cid.Decode("bafy2bzacedg6ilkc2ygjqw2jlzqz3gpsgt3ns3qfd6sxpi5xdavbcgnxhqdfi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make(map[string]remoteExecResponse)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/info":
+			_ = json.NewEncoder(w).Encode(remoteExecInfo{Name: "forest", Version: "0.1.0"})
+		case "/v1/execute":
+			var req remoteExecRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			res, ok := results[req.Vector.Meta.ID]
+			if !ok {
+				http.Error(w, "unsupported vector", http.StatusNotImplemented)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(res)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	e, err := newRemoteExecutor(srv.URL+"/", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.info.String() != "forest 0.1.0" {
+		t.Fatalf("unexpected executor info: %s", e.info)
+	}
+
+	vector := func(id string) *schema.TestVector {
+		return &schema.TestVector{
+			Class: schema.ClassMessage,
+			Meta:  &schema.Metadata{ID: id},
+			Pre:   &schema.Preconditions{Variants: []schema.Variant{{ID: "v1"}}},
+			Post: &schema.Postconditions{
+				StateTree: &schema.StateTree{RootCID: root},
+				Receipts:  []*schema.Receipt{{ExitCode: 0, ReturnValue: []byte{1}, GasUsed: 100}},
+			},
+		}
+	}
+	results["pass"] = remoteExecResponse{Receipts: []*schema.Receipt{{ExitCode: 0, ReturnValue: []byte{1}, GasUsed: 100}}, StateRoot: &root}
+	results["exit-code"] = remoteExecResponse{Receipts: []*schema.Receipt{{ExitCode: 16, GasUsed: 100}}, StateRoot: &root}
+	results["no-root"] = remoteExecResponse{Receipts: []*schema.Receipt{{ExitCode: 0, ReturnValue: []byte{1}, GasUsed: 100}}}
+	results["error"] = remoteExecResponse{Error: "boom"}
+
+	for _, c := range []struct {
+		id     string
+		failed bool
+		err    bool
+	}{
+		{"pass", false, false},
+		{"exit-code", true, false},
+		{"no-root", true, false},
+		{"error", true, true},
+	} {
+		tv := vector(c.id)
+		r := new(conformance.LogReporter)
+		_, err := e.executeVariant(context.Background(), r, tv, &tv.Pre.Variants[0], conformance.ExecuteOpts{})
+		if (err != nil) != c.err {
+			t.Errorf("%s: expected error: %t, got %v", c.id, c.err, err)
+		}
+		if r.Failed() != c.failed {
+			t.Errorf("%s: expected failed: %t, got %t", c.id, c.failed, r.Failed())
+		}
+	}
+
+	tv := vector("unsupported")
+	if _, err := e.executeVariant(context.Background(), new(conformance.LogReporter), tv, &tv.Pre.Variants[0], conformance.ExecuteOpts{}); !errors.Is(err, conformance.ErrUnsupportedClass) {
+		t.Errorf("expected unsupported vector, got %v", err)
+	}
+}
+
+func TestExecuteGuardedTimeout(t *testing.T) {
+	canceled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/info":
+			_ = json.NewEncoder(w).Encode(remoteExecInfo{Name: "forest", Version: "0.1.0"})
+		case "/v1/execute":
+			// never completes; the request is canceled once timed out.
+			<-r.Context().Done()
+			close(canceled)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	e, err := newRemoteExecutor(srv.URL, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execExecutor, execFlags.perVectorTimeout = e, 50*time.Millisecond
+	defer func() { execExecutor, execFlags.perVectorTimeout = nil, 0 }()
+
+	tv := schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta:  &schema.Metadata{ID: "loops"},
+		Pre:   &schema.Preconditions{Variants: []schema.Variant{{ID: "v1"}}},
+	}
+	_, err = executeGuarded(new(reportingReporter), tv, conformance.ExecuteOpts{})
+	var terr *errVectorTimeout
+	if !errors.As(err, &terr) {
+		t.Fatalf("expected the execution to time out; got %v", err)
+	}
+	if terr.stuck {
+		t.Errorf("expected the execution to stop once interrupted; got %s", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the request of the execution to be canceled")
+	}
+}
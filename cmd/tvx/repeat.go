@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api/v0api"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+var (
+	extractRepeat         int
+	extractRepeatInterval time.Duration
+)
+
+// maxDifferingBlocks is the number of blocks listed on each side of a CAR
+// difference between repeated extractions.
+const maxDifferingBlocks = 10
+
+// doExtractRepeated extracts the vector requested in the options --repeat
+// times, --reextract-interval apart, and verifies that the extractions are
+// identical, listing the fields that differ otherwise. With many --api
+// endpoints, the extractions rotate across them, one endpoint each, so that
+// differences between nodes surface too. The first extraction is written.
+func doExtractRepeated(c *cli.Context, opts extractOpts) error {
+	if err := opts.resolveURL(); err != nil {
+		return err
+	}
+	if err := opts.resolveRelativeTarget(context.Background()); err != nil {
+		return err
+	}
+	xopts := opts.extractorOptions()
+	if xopts.Multiple() || (opts.class == string(schema.ClassMessage) && opts.cid == "" && opts.fromEpoch != 0) {
+		return fmt.Errorf("--repeat requires an extraction producing a single vector")
+	}
+
+	// a node per repetition, if many were supplied.
+	apis := []v0api.FullNode{FullAPI}
+	names := []string{"the node"}
+	if specs := c.StringSlice("api"); len(specs) > 1 {
+		apis, names = nil, nil
+		for _, spec := range specs {
+			api, closer, err := dialEndpoints(c.Context, []string{spec})
+			if err != nil {
+				return err
+			}
+			defer closer()
+			apis = append(apis, throttleFullNode(api, c.Float64("rpc-qps"), c.Int("rpc-concurrency")))
+			names = append(names, cliutil.ParseApiInfo(spec).Addr)
+		}
+	}
+
+	var (
+		first *schema.TestVector
+		diffs int
+	)
+	for i := 0; i < extractRepeat; i++ {
+		if i > 0 && extractRepeatInterval > 0 {
+			log.Printf("waiting %s before extraction %d", extractRepeatInterval, i+1)
+			select {
+			case <-time.After(extractRepeatInterval):
+			case <-c.Context.Done():
+				return c.Context.Err()
+			}
+		}
+
+		api := apis[i%len(apis)]
+		head, err := api.ChainHead(c.Context)
+		if err != nil {
+			return fmt.Errorf("failed to get chain head: %w", err)
+		}
+		log.Printf("extraction %d of %d, from %s at head epoch %d", i+1, extractRepeat, names[i%len(names)], head.Height())
+		vectors, err := extractor.ExtractAll(context.Background(), api, xopts)
+		if err != nil {
+			return fmt.Errorf("extraction %d failed: %w", i+1, err)
+		}
+		if first == nil {
+			first = vectors[0]
+			continue
+		}
+		differences, err := vectorDifferences(first, vectors[0])
+		if err != nil {
+			return err
+		}
+		for _, d := range differences {
+			log.Printf("nondeterminism: extraction %d differs from the first: %s", i+1, d)
+		}
+		diffs += len(differences)
+	}
+
+	if err := writeVector(first, opts.file); err != nil {
+		return err
+	}
+	if diffs > 0 {
+		return fmt.Errorf("repeated extractions differ in %d fields", diffs)
+	}
+	log.Printf("the %d extractions are identical", extractRepeat)
+	return nil
+}
+
+// vectorDifferences returns the fields of the vectors that differ, by their
+// JSON path, e.g. _meta.gen[3].version. Differing CARs are compared by their
+// blocks; see carDifferences. The times the vectors were extracted at, if
+// recorded, are ignored.
+func vectorDifferences(a, b *schema.TestVector) ([]string, error) {
+	var ja, jb interface{}
+	for _, x := range []struct {
+		tv  *schema.TestVector
+		out *interface{}
+	}{{a, &ja}, {b, &jb}} {
+		data, err := json.Marshal(withoutExtractionTime(x.tv))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, x.out); err != nil {
+			return nil, err
+		}
+	}
+
+	var diffs []string
+	jsonDifferences("", ja, jb, func(path string, va, vb interface{}) {
+		if path == "car" {
+			cds, err := carDifferences(a.CAR, b.CAR)
+			if err != nil {
+				diffs = append(diffs, fmt.Sprintf("car: %s", err))
+				return
+			}
+			for _, d := range cds {
+				diffs = append(diffs, "car: "+d)
+			}
+			return
+		}
+		diffs = append(diffs, fmt.Sprintf("%s: %s != %s", path, briefJSON(va), briefJSON(vb)))
+	})
+	return diffs, nil
+}
+
+// withoutExtractionTime returns a shallow copy of the vector without the
+// generation data entry recording the time it was extracted at.
+func withoutExtractionTime(tv *schema.TestVector) *schema.TestVector {
+	if tv.Meta == nil {
+		return tv
+	}
+	meta := *tv.Meta
+	meta.Gen = nil
+	for _, g := range tv.Meta.Gen {
+		if g.Source != extractor.GenExtractedAt {
+			meta.Gen = append(meta.Gen, g)
+		}
+	}
+	cpy := *tv
+	cpy.Meta = &meta
+	return &cpy
+}
+
+// jsonDifferences calls diff with the path of every leaf, or subtree of a
+// different shape, of the JSON values that differs.
+func jsonDifferences(path string, a, b interface{}, diff func(path string, a, b interface{})) {
+	switch ta := a.(type) {
+	case map[string]interface{}:
+		tb, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]struct{})
+		for k := range ta {
+			keys[k] = struct{}{}
+		}
+		for k := range tb {
+			keys[k] = struct{}{}
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			jsonDifferences(p, ta[k], tb[k], diff)
+		}
+		return
+	case []interface{}:
+		tb, ok := b.([]interface{})
+		if !ok || len(ta) != len(tb) {
+			break
+		}
+		for i := range ta {
+			jsonDifferences(fmt.Sprintf("%s[%d]", path, i), ta[i], tb[i], diff)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		diff(path, a, b)
+	}
+}
+
+// briefJSON returns the JSON encoding of the value, truncated.
+func briefJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	if len(b) > 80 {
+		return string(b[:77]) + "..."
+	}
+	return string(b)
+}
+
+// carDifferences describes how the CARs of two vectors differ: by their
+// roots, and the blocks only one of them holds, or, if they hold the same
+// blocks, by their order or their compression.
+func carDifferences(a, b []byte) ([]string, error) {
+	ua, err := gunzipCAR(a)
+	if err != nil {
+		return nil, err
+	}
+	ub, err := gunzipCAR(b)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(ua, ub) {
+		return []string{"the CARs are identical, but compressed differently"}, nil
+	}
+
+	rootsA, blocksA, err := listCAR(ua)
+	if err != nil {
+		return nil, err
+	}
+	rootsB, blocksB, err := listCAR(ub)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []string
+	if !reflect.DeepEqual(rootsA, rootsB) {
+		diffs = append(diffs, fmt.Sprintf("roots differ: %v != %v", rootsA, rootsB))
+	}
+	onlyA, onlyB := blockSetDifference(blocksA, blocksB), blockSetDifference(blocksB, blocksA)
+	for _, x := range []struct {
+		which  string
+		blocks []cid.Cid
+	}{{"first", onlyA}, {"other", onlyB}} {
+		if len(x.blocks) == 0 {
+			continue
+		}
+		listed := x.blocks
+		if len(listed) > maxDifferingBlocks {
+			listed = listed[:maxDifferingBlocks]
+		}
+		diffs = append(diffs, fmt.Sprintf("%d blocks only in the %s extraction, e.g. %v", len(x.blocks), x.which, listed))
+	}
+	if len(diffs) == 0 {
+		diffs = append(diffs, fmt.Sprintf("the CARs hold the same %d blocks, in a different order", len(blocksA)))
+	}
+	return diffs, nil
+}
+
+// blockSetDifference returns the CIDs of the blocks of a missing from b, in
+// the order of a.
+func blockSetDifference(a, b []carBlockStat) []cid.Cid {
+	inB := make(map[cid.Cid]struct{}, len(b))
+	for _, s := range b {
+		inB[s.cid] = struct{}{}
+	}
+	var out []cid.Cid
+	for _, s := range a {
+		if _, ok := inB[s.cid]; !ok {
+			out = append(out, s.cid)
+		}
+	}
+	return out
+}
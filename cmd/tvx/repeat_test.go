@@ -0,0 +1,54 @@
+// stm: #unit
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+func TestVectorDifferences(t *testing.T) {
+	vector := func(gas int64, version string) *schema.TestVector {
+		return &schema.TestVector{
+			Class: schema.ClassMessage,
+			Meta: &schema.Metadata{ID: "v", Gen: []schema.GenerationData{
+				{Source: "github.com/filecoin-project/lotus", Version: version},
+			}},
+			Post: &schema.Postconditions{Receipts: []*schema.Receipt{{GasUsed: gas}}},
+		}
+	}
+
+	diffs, err := vectorDifferences(vector(10, "1.0"), vector(10, "1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("identical vectors differ: %v", diffs)
+	}
+
+	diffs, err = vectorDifferences(vector(10, "1.0"), vector(11, "1.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`_meta.gen[0].version: "1.0" != "1.1"`,
+		`postconditions.receipts[0].gas_used: 10 != 11`,
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("got differences %q; want %q", diffs, want)
+	}
+
+	// extraction times differ between repeated extractions.
+	a, b := vector(10, "1.0"), vector(10, "1.0")
+	a.Meta.Gen = append(a.Meta.Gen, schema.GenerationData{Source: extractor.GenExtractedAt, Version: "2023-01-02T03:04:05Z"})
+	b.Meta.Gen = append(b.Meta.Gen, schema.GenerationData{Source: extractor.GenExtractedAt, Version: "2023-01-02T03:04:06Z"})
+	if diffs, err = vectorDifferences(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("vectors differing in extraction time only differ: %v", diffs)
+	}
+}
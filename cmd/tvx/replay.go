@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+var replayFlags struct {
+	file       string
+	tsk        string
+	onChain    bool
+	checkOrder bool
+}
+
+var replayCmd = &cli.Command{
+	Name: "replay",
+	Description: "replay the messages of a message-class test vector against a live node through StateCall " +
+		"(or StateReplay, with --on-chain), comparing the node's results with the postconditions of the vector. " +
+		"Messages are called on the parent state of the inclusion tipset recorded in the vector, or of the tipset " +
+		"at the vector epoch. StateCall applies every message independently and without charging gas, so only " +
+		"exit codes and return values are compared; with --on-chain, messages are replayed in the context of the " +
+		"tipset that included them, and gas used is compared too. With --check-precursor-order, the messages aren't replayed; " +
+		"instead, the positions of the message and its precursors among the messages of the inclusion tipset, as recorded " +
+		"by tvx extract, are cross-checked against those ChainGetParentMessages returns",
+	Action: runReplay,
+	Before: initialize,
+	After:  destroy,
+	Flags: []cli.Flag{
+		&repoFlag,
+		&apiFlag,
+		&rpcQPSFlag,
+		&rpcConcurrencyFlag,
+		&cli.StringFlag{
+			Name:        "vector",
+			Usage:       "test vector to replay",
+			TakesFile:   true,
+			Required:    true,
+			Destination: &replayFlags.file,
+		},
+		&cli.StringFlag{
+			Name:        "tsk",
+			Usage:       "tipset to replay the messages at, overriding the one recorded in the vector; format: @<height> or comma-separated CIDs",
+			Destination: &replayFlags.tsk,
+		},
+		&cli.BoolFlag{
+			Name:        "on-chain",
+			Usage:       "replay the messages as included on chain through StateReplay, instead of calling them through StateCall",
+			Destination: &replayFlags.onChain,
+		},
+		&cli.BoolFlag{
+			Name:        "check-precursor-order",
+			Usage:       "cross-check the recorded order of the message and its precursors against the node, instead of replaying the messages",
+			Destination: &replayFlags.checkOrder,
+		},
+	},
+}
+
+func runReplay(_ *cli.Context) error {
+	ctx := context.Background()
+
+	tv, err := readVector(replayFlags.file)
+	if err != nil {
+		return err
+	}
+	if tv.Class != schema.ClassMessage {
+		return fmt.Errorf("vector %s is of class %s; only message vectors can be replayed", replayFlags.file, tv.Class)
+	}
+	if replayFlags.checkOrder {
+		return checkPrecursorOrder(ctx, tv)
+	}
+
+	ts, err := replayTipset(ctx, tv)
+	if err != nil {
+		return err
+	}
+	log.Printf("replaying %d messages at tipset %s (epoch %d)", len(tv.ApplyMessages), ts.Key(), ts.Height())
+
+	var failed int
+	for i, m := range tv.ApplyMessages {
+		msg, _, err := conformance.DecodeVectorMessage(m.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize message %d: %w", i, err)
+		}
+
+		var res *api.InvocResult
+		if replayFlags.onChain {
+			res, err = FullAPI.StateReplay(ctx, ts.Key(), msg.Cid())
+		} else {
+			res, err = FullAPI.StateCall(ctx, msg, ts.Key())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay message %d (%s): %w", i, msg.Cid(), err)
+		}
+
+		if len(tv.Post.Receipts) <= i {
+			log.Printf("message %d (%s): vector has no receipt to compare against; skipping", i, msg.Cid())
+			continue
+		}
+
+		if diffs := compareInvocResult(tv.Post.Receipts[i], res, replayFlags.onChain); len(diffs) > 0 {
+			failed++
+			log.Println(color.HiRedString("❌ message %d (%s) diverges from the vector:", i, msg.Cid()))
+			for _, d := range diffs {
+				log.Printf("\t%s", d)
+			}
+		} else {
+			log.Println(color.GreenString("✅ message %d (%s) matches the vector", i, msg.Cid()))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d messages diverged from the vector", failed, len(tv.ApplyMessages))
+	}
+	return nil
+}
+
+// replayTipset resolves the tipset to replay the vector at: the one supplied
+// with --tsk, the inclusion tipset recorded by tvx extract, or the tipset at
+// the epoch of the first variant.
+func replayTipset(ctx context.Context, tv *schema.TestVector) (*types.TipSet, error) {
+	if replayFlags.tsk != "" {
+		return lcli.ParseTipSetRef(ctx, FullAPI, replayFlags.tsk)
+	}
+
+	if ts, ok, err := recordedTipset(ctx, tv, "inclusion_tipset"); err != nil || ok {
+		return ts, err
+	}
+
+	if len(tv.Pre.Variants) == 0 {
+		return nil, fmt.Errorf("vector has no variants to take the epoch from; supply a tipset with --tsk")
+	}
+	return FullAPI.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(tv.Pre.Variants[0].Epoch), types.EmptyTSK)
+}
+
+// recordedTipset fetches the tipset of the kind (inclusion_tipset or
+// execution_tipset) recorded in the vector by tvx extract. It returns false if
+// none is recorded.
+func recordedTipset(ctx context.Context, tv *schema.TestVector, kind string) (*types.TipSet, bool, error) {
+	if tv.Meta == nil {
+		return nil, false, nil
+	}
+	for _, g := range tv.Meta.Gen {
+		if strings.HasPrefix(g.Source, kind+":") {
+			tsk := strings.TrimPrefix(g.Source, kind+":")
+			cids, err := lcli.ParseTipSetString(strings.Trim(tsk, "{}"))
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to parse %s %s: %w", kind, tsk, err)
+			}
+			ts, err := FullAPI.ChainGetTipSet(ctx, types.NewTipSetKey(cids...))
+			return ts, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// checkPrecursorOrder cross-checks the order of the message of the vector
+// and its precursors among the messages of the inclusion tipset, as recorded
+// by tvx extract, against the canonical order of the node.
+func checkPrecursorOrder(ctx context.Context, tv *schema.TestVector) error {
+	var gen []schema.GenerationData
+	if tv.Meta != nil {
+		gen = tv.Meta.Gen
+	}
+	order, ok, err := extractor.ParsePrecursorOrder(gen)
+	if err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("vector records no precursor order; it predates the recording, or wasn't extracted from a tipset")
+	}
+	execTs, ok, err := recordedTipset(ctx, tv, "execution_tipset")
+	if err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("vector records no execution tipset")
+	}
+
+	msgs, err := FullAPI.ChainGetParentMessages(ctx, execTs.Blocks()[0].Cid())
+	if err != nil {
+		return fmt.Errorf("failed to get messages included at epoch %d: %w", execTs.Height()-1, err)
+	}
+	problems := order.Verify(msgs)
+	if len(problems) > 0 {
+		log.Println(color.HiRedString("❌ precursor order diverges from the node:"))
+		for _, p := range problems {
+			log.Printf("\t%s", p)
+		}
+		return fmt.Errorf("precursor order diverges from the node in %d places", len(problems))
+	}
+	log.Println(color.GreenString("✅ message at position %d of %d and its %d precursors match the order of the node", order.Target, order.Total, len(order.Precursors)))
+	return nil
+}
+
+// compareInvocResult compares the result of a node invocation with the
+// expected receipt, returning the divergences. Gas used is only compared if
+// withGas is true.
+func compareInvocResult(expected *schema.Receipt, res *api.InvocResult, withGas bool) []string {
+	var diffs []string
+	if res.MsgRct == nil {
+		return []string{fmt.Sprintf("node returned no receipt; error: %s", res.Error)}
+	}
+	if code := exitcode.ExitCode(expected.ExitCode); res.MsgRct.ExitCode != code {
+		diffs = append(diffs, fmt.Sprintf("exit code: expected %s, node returned %s (error: %s)", code, res.MsgRct.ExitCode, res.Error))
+	}
+	if !bytes.Equal(res.MsgRct.Return, expected.ReturnValue) {
+		diffs = append(diffs, fmt.Sprintf("return value: expected %x, node returned %x", expected.ReturnValue, res.MsgRct.Return))
+	}
+	if withGas && res.MsgRct.GasUsed != expected.GasUsed {
+		diffs = append(diffs, fmt.Sprintf("gas used: expected %d, node returned %d", expected.GasUsed, res.MsgRct.GasUsed))
+	}
+	return diffs
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+var sampleFlags struct {
+	perBucket int
+	out       string
+}
+
+var sampleCmd = &cli.Command{
+	Name: "sample",
+	Description: `select a representative subset of a corpus, to run as a fast smoke suite.
+
+   The vectors in the files and directories supplied as arguments (the current
+   directory if none) are stratified into buckets by class, and by the
+   receiver actor, method and exit code of their first message, as tvx search
+   lists them. Up to --per-bucket vectors are selected from each bucket,
+   spread evenly across the bucket in path order, so that selections are
+   reproducible, and that every behavior of the corpus is represented, rare
+   ones included.
+
+   The selection is written as a manifest, to stdout or --out: a list of
+   vector files, one per line, relative to the directory of the manifest,
+   grouped by bucket under comment lines starting with #. tvx exec executes
+   the vectors of a manifest with --manifest. Vectors in archives can't be
+   listed in manifests, and are skipped.`,
+	ArgsUsage: "[<vector file or dir>...]",
+	Action:    runSample,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:        "per-bucket",
+			Usage:       "maximum number of vectors to select from each bucket",
+			Value:       1,
+			Destination: &sampleFlags.perBucket,
+		},
+		&cli.StringFlag{
+			Name:        "out",
+			Aliases:     []string{"o"},
+			Usage:       "file to write the manifest to; stdout if empty",
+			TakesFile:   true,
+			Destination: &sampleFlags.out,
+		},
+	},
+}
+
+// sampleBucket is a stratum of the corpus, and the vectors selected from it.
+type sampleBucket struct {
+	key      string
+	paths    []string
+	selected []string
+}
+
+// sampleKey returns the bucket of the vector, whose messages are supplied.
+func sampleKey(tv *schema.TestVector, msgs []searchedMessage) string {
+	if len(msgs) == 0 {
+		return string(tv.Class)
+	}
+	return string(tv.Class) + " " + msgs[0].String()
+}
+
+// stratifiedSample groups the paths by their bucket, and selects up to
+// perBucket of each, evenly spaced in path order. The buckets are sorted by
+// key.
+func stratifiedSample(keys map[string]string, perBucket int) []*sampleBucket {
+	byKey := make(map[string]*sampleBucket)
+	for path, key := range keys {
+		b, ok := byKey[key]
+		if !ok {
+			b = &sampleBucket{key: key}
+			byKey[key] = b
+		}
+		b.paths = append(b.paths, path)
+	}
+
+	buckets := make([]*sampleBucket, 0, len(byKey))
+	for _, b := range byKey {
+		sort.Strings(b.paths)
+		n := len(b.paths)
+		if perBucket < n {
+			n = perBucket
+		}
+		for i := 0; i < n; i++ {
+			b.selected = append(b.selected, b.paths[i*len(b.paths)/n])
+		}
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].key < buckets[j].key })
+	return buckets
+}
+
+func runSample(c *cli.Context) error {
+	if sampleFlags.perBucket < 1 {
+		return fmt.Errorf("--per-bucket must be at least 1")
+	}
+
+	keys := make(map[string]string)
+	var skipped int
+	err := walkVectors(c.Args().Slice(), func(path string, tv *schema.TestVector) error {
+		if inVectorArchive(path) {
+			skipped++
+			return nil
+		}
+		keys[path] = sampleKey(tv, searchedMessages(tv, true))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		log.Printf("skipped %d vectors in archives, which can't be listed in manifests", skipped)
+	}
+
+	buckets := stratifiedSample(keys, sampleFlags.perBucket)
+
+	w, base := io.Writer(os.Stdout), "."
+	if sampleFlags.out != "" {
+		f, err := os.Create(sampleFlags.out)
+		if err != nil {
+			return fmt.Errorf("failed to create manifest: %w", err)
+		}
+		defer f.Close() //nolint:errcheck
+		w, base = f, filepath.Dir(sampleFlags.out)
+	}
+	selected, err := writeSampleManifest(w, base, buckets, len(keys))
+	if err != nil {
+		return err
+	}
+	log.Printf("selected %d of %d vectors, from %d buckets", selected, len(keys), len(buckets))
+	return nil
+}
+
+// writeSampleManifest writes the vectors selected from the buckets as a
+// manifest, with paths relative to base, and returns how many were selected.
+func writeSampleManifest(w io.Writer, base string, buckets []*sampleBucket, total int) (int, error) {
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(w)
+	var selected int
+	for _, b := range buckets {
+		selected += len(b.selected)
+	}
+	_, _ = fmt.Fprintf(bw, "# tvx sample: %d of %d vectors, from %d buckets\n", selected, total, len(buckets))
+	for _, b := range buckets {
+		_, _ = fmt.Fprintf(bw, "\n# %s (%d of %d)\n", b.key, len(b.selected), len(b.paths))
+		for _, p := range b.selected {
+			abs, err := filepath.Abs(p)
+			if err != nil {
+				return 0, err
+			}
+			rel, err := filepath.Rel(absBase, abs)
+			if err != nil {
+				return 0, err
+			}
+			_, _ = fmt.Fprintln(bw, filepath.ToSlash(rel))
+		}
+	}
+	return selected, bw.Flush()
+}
+
+// readVectorManifest returns the paths of the vector files listed in the
+// manifest, as written by tvx sample, resolved against its directory. Blank
+// lines, and lines starting with #, are ignored.
+func readVectorManifest(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	var paths []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := filepath.FromSlash(line)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(path), p)
+		}
+		paths = append(paths, p)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", path, err)
+	}
+	return paths, nil
+}
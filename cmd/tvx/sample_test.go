@@ -0,0 +1,54 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStratifiedSample(t *testing.T) {
+	keys := map[string]string{
+		"a/1.json": "message account.Send=0",
+		"a/2.json": "message account.Send=0",
+		"a/3.json": "message account.Send=0",
+		"a/4.json": "message account.Send=0",
+		"b/1.json": "message storageminer.ChangeWorkerAddress=16",
+		"c/1.json": "tipset",
+	}
+	buckets := stratifiedSample(keys, 2)
+	if len(buckets) != 3 {
+		t.Fatalf("expected 3 buckets, got %d", len(buckets))
+	}
+	// buckets are sorted by key, and selections spread across them.
+	if got, want := buckets[0].selected, []string{"a/1.json", "a/3.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selected %v from the first bucket; want %v", got, want)
+	}
+	if got, want := buckets[1].selected, []string{"b/1.json"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selected %v from the second bucket; want %v", got, want)
+	}
+}
+
+func TestSampleManifest(t *testing.T) {
+	dir := t.TempDir()
+	vector := filepath.Join(dir, "corpus", "v.json")
+	buckets := []*sampleBucket{{key: "message account.Send=0", paths: []string{vector}, selected: []string{vector}}}
+
+	var buf bytes.Buffer
+	if _, err := writeSampleManifest(&buf, dir, buckets, 1); err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(dir, "smoke.txt")
+	if err := os.WriteFile(manifest, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := readVectorManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != vector {
+		t.Errorf("manifest lists %v; want [%s]", paths, vector)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+var searchFlags struct {
+	class     string
+	actor     string
+	method    string
+	exitCode  int64
+	nv        string
+	tags      cli.StringSlice
+	selectors cli.StringSlice
+	long      bool
+}
+
+var searchCmd = &cli.Command{
+	Name: "search",
+	Description: `list the test vectors in a corpus that match the supplied criteria.
+
+   The files and directories supplied as arguments (the current directory if
+   none) are searched recursively for vectors. All criteria must be met for a
+   vector to match. --actor, --method and --exit-code must be met by the same
+   message of the vector; exit codes are only known for message-class
+   vectors. Actors are matched by the name of their code (e.g.
+   fil/8/storageminer), methods by number or name.
+
+   The paths of the matching vectors are printed to stdout, one per line, so
+   that they can be piped into other commands; --long prints their ID, class,
+   messages and network versions as well.
+
+   Example:
+
+     tvx search --actor storageminer --method 5 --exit-code 0 --nv '>=10' corpus/`,
+	ArgsUsage: "[<vector file or dir>...]",
+	Action:    runSearch,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "class",
+			Usage:       "class of the vectors: message, tipset, blockseq or migration",
+			Destination: &searchFlags.class,
+		},
+		&cli.StringFlag{
+			Name:        "actor",
+			Usage:       "name, or part of the name, of the code of the receiver (e.g. storageminer or fil/8/multisig)",
+			Destination: &searchFlags.actor,
+		},
+		&cli.StringFlag{
+			Name:        "method",
+			Usage:       "number or name of the method invoked",
+			Destination: &searchFlags.method,
+		},
+		&cli.Int64Flag{
+			Name:        "exit-code",
+			Usage:       "exit code of the message",
+			Destination: &searchFlags.exitCode,
+		},
+		&cli.StringFlag{
+			Name:        "nv",
+			Usage:       "network version of a variant of the vector, optionally preceded by a comparison operator (=, !=, <, <=, >, >=), e.g. '>=10'",
+			Destination: &searchFlags.nv,
+		},
+		&cli.StringSliceFlag{
+			Name:        "tag",
+			Usage:       "tag of the vector, or a tag above it (e.g. 'regression' matches 'regression/issue-1234'); can be repeated",
+			Destination: &searchFlags.tags,
+		},
+		&cli.StringSliceFlag{
+			Name:        "selector",
+			Usage:       "selector of the vector, as key=value; can be repeated",
+			Destination: &searchFlags.selectors,
+		},
+		&cli.BoolFlag{
+			Name:        "long",
+			Aliases:     []string{"l"},
+			Usage:       "print the ID, class, messages and network versions of the matching vectors",
+			Destination: &searchFlags.long,
+		},
+	},
+}
+
+// vectorQuery is the set of criteria vectors are searched by. Zero values
+// match any vector.
+type vectorQuery struct {
+	class     string
+	actor     string
+	method    string
+	exitCode  *int64
+	nv        func(uint) bool
+	tags      []string
+	selectors map[string]string
+}
+
+// searchedMessage is a message of a vector, as matched by a vectorQuery.
+type searchedMessage struct {
+	actor  string
+	method abi.MethodNum
+	// methodName is the exported name of the method, or Method<N>.
+	methodName string
+	// exitCode is the exit code of the message, if hasExit.
+	exitCode int64
+	hasExit  bool
+}
+
+func (m *searchedMessage) String() string {
+	s := m.actor + "." + m.methodName
+	if m.hasExit {
+		s += fmt.Sprintf("=%d", m.exitCode)
+	}
+	return s
+}
+
+func runSearch(c *cli.Context) error {
+	q := vectorQuery{
+		class:  searchFlags.class,
+		actor:  searchFlags.actor,
+		method: searchFlags.method,
+		tags:   searchFlags.tags.Value(),
+	}
+	if c.IsSet("exit-code") {
+		q.exitCode = &searchFlags.exitCode
+	}
+	if searchFlags.nv != "" {
+		nv, err := parseNetworkVersionConstraint(searchFlags.nv)
+		if err != nil {
+			return err
+		}
+		q.nv = nv
+	}
+	if sels := searchFlags.selectors.Value(); len(sels) > 0 {
+		q.selectors = make(map[string]string, len(sels))
+		for _, s := range sels {
+			k, v, ok := strings.Cut(s, "=")
+			if !ok {
+				return fmt.Errorf("invalid selector %q; expected key=value", s)
+			}
+			q.selectors[k] = v
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 4, 2, 2, ' ', 0)
+	defer tw.Flush() //nolint:errcheck
+
+	var matched int
+	err := walkVectors(c.Args().Slice(), func(path string, tv *schema.TestVector) error {
+		msgs := searchedMessages(tv, q.needsActors() || searchFlags.long)
+		if !q.matches(tv, msgs) {
+			return nil
+		}
+		matched++
+
+		if !searchFlags.long {
+			_, err := fmt.Fprintln(tw, path)
+			return err
+		}
+		var id string
+		if tv.Meta != nil {
+			id = tv.Meta.ID
+		}
+		var nvs []string
+		if tv.Pre != nil {
+			for _, v := range tv.Pre.Variants {
+				nvs = append(nvs, strconv.FormatUint(uint64(v.NetworkVersion), 10))
+			}
+		}
+		descs := make([]string, len(msgs))
+		for i := range msgs {
+			descs[i] = msgs[i].String()
+		}
+		_, err := fmt.Fprintf(tw, "%s\t%s\t%s\tnv%s\t%s\n", path, id, tv.Class, strings.Join(nvs, ","), strings.Join(descs, " "))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("%d vectors matched", matched)
+	return nil
+}
+
+// walkVectors calls fn with the test vectors in the files and directories
+// supplied (the current directory if none), searched recursively as
+// walkVectorFiles does. Files that aren't vectors are skipped.
+func walkVectors(paths []string, fn func(path string, tv *schema.TestVector) error) error {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	for _, p := range paths {
+		err := walkVectorFiles(p, func(path string, content []byte) error {
+			var tv schema.TestVector
+			if err := json.Unmarshal(content, &tv); err != nil || tv.Class == "" {
+				log.Printf("failed to decode test vector %s; skipping", path)
+				return nil
+			}
+			return fn(path, &tv)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// needsActors returns whether the query needs the actors and method names of
+// the messages of vectors, which are resolved against their state.
+func (q *vectorQuery) needsActors() bool {
+	if q.actor != "" {
+		return true
+	}
+	_, err := strconv.ParseUint(q.method, 10, 64)
+	return q.method != "" && err != nil
+}
+
+// matches returns whether the vector, whose messages are supplied, matches
+// the query.
+func (q *vectorQuery) matches(tv *schema.TestVector, msgs []searchedMessage) bool {
+	if q.class != "" && string(tv.Class) != q.class {
+		return false
+	}
+
+	if q.nv != nil {
+		var ok bool
+		if tv.Pre != nil {
+			for _, v := range tv.Pre.Variants {
+				ok = ok || q.nv(v.NetworkVersion)
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	for _, tag := range q.tags {
+		if !conformance.HasTag(tv, tag) {
+			return false
+		}
+	}
+
+	for k, v := range q.selectors {
+		if tv.Selector[k] != v {
+			return false
+		}
+	}
+
+	if q.actor == "" && q.method == "" && q.exitCode == nil {
+		return true
+	}
+	for i := range msgs {
+		if q.matchesMessage(&msgs[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *vectorQuery) matchesMessage(m *searchedMessage) bool {
+	if q.actor != "" && !strings.Contains(m.actor, q.actor) {
+		return false
+	}
+	if q.method != "" {
+		if n, err := strconv.ParseUint(q.method, 10, 64); err == nil {
+			if abi.MethodNum(n) != m.method {
+				return false
+			}
+		} else if !strings.EqualFold(q.method, m.methodName) {
+			return false
+		}
+	}
+	if q.exitCode != nil && (!m.hasExit || m.exitCode != *q.exitCode) {
+		return false
+	}
+	return true
+}
+
+// searchedMessages decodes the messages of the vector, along with their exit
+// codes, if known. If resolve is true, the codes of their receivers are
+// resolved against the pre-state of the vector, unless already recorded in
+// its metadata.
+func searchedMessages(tv *schema.TestVector, resolve bool) []searchedMessage {
+	var raw [][]byte
+	for _, m := range tv.ApplyMessages {
+		raw = append(raw, m.Bytes)
+	}
+	for _, ts := range tv.ApplyTipsets {
+		for _, b := range ts.Blocks {
+			for _, m := range b.Messages {
+				raw = append(raw, m)
+			}
+		}
+	}
+
+	// the receiver and method of message vectors are recorded by tvx extract.
+	var recordedActor, recordedMethod string
+	if tv.Meta != nil {
+		for _, g := range tv.Meta.Gen {
+			if s, ok := cutPrefix(g.Source, "actor:"); ok {
+				recordedActor = s
+			} else if s, ok := cutPrefix(g.Source, "method:"); ok {
+				recordedMethod = s
+			}
+		}
+	}
+
+	var st *state.StateTree
+	if resolve && (len(raw) > 1 || recordedActor == "") && tv.Pre != nil && tv.Pre.StateTree != nil {
+		if bs, err := conformance.LoadBlockstore(tv.CAR); err == nil {
+			st, _ = state.LoadStateTree(cbornode.NewCborStore(bs), tv.Pre.StateTree.RootCID)
+		}
+	}
+
+	msgs := make([]searchedMessage, 0, len(raw))
+	for i, b := range raw {
+		msg, _, err := conformance.DecodeVectorMessage(b)
+		if err != nil {
+			continue
+		}
+		code := cid.Undef
+		if st != nil {
+			if act, err := st.GetActor(msg.To); err == nil {
+				code = act.Code
+			}
+		}
+		m := searchedMessage{
+			actor:      extractor.ActorName(code),
+			method:     msg.Method,
+			methodName: extractor.MethodName(code, msg.Method),
+		}
+		if !code.Defined() && len(raw) == 1 && recordedActor != "" {
+			m.actor, m.methodName = recordedActor, recordedMethod
+		}
+		if tv.Class == schema.ClassMessage && tv.Post != nil && i < len(tv.Post.Receipts) {
+			m.exitCode, m.hasExit = tv.Post.Receipts[i].ExitCode, true
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// cutPrefix returns s without the prefix, and whether s had it.
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// parseNetworkVersionConstraint parses a network version constraint, such as
+// 16, =16, !=16, <16, <=16, >16 or >=16.
+func parseNetworkVersionConstraint(s string) (func(uint) bool, error) {
+	s = strings.TrimSpace(s)
+	op := strings.TrimRight(s, "0123456789 ")
+	n, err := strconv.ParseUint(strings.TrimSpace(s[len(op):]), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network version constraint %q: %w", s, err)
+	}
+	v := uint(n)
+	switch op {
+	case "", "=", "==":
+		return func(nv uint) bool { return nv == v }, nil
+	case "!=":
+		return func(nv uint) bool { return nv != v }, nil
+	case "<":
+		return func(nv uint) bool { return nv < v }, nil
+	case "<=":
+		return func(nv uint) bool { return nv <= v }, nil
+	case ">":
+		return func(nv uint) bool { return nv > v }, nil
+	case ">=":
+		return func(nv uint) bool { return nv >= v }, nil
+	default:
+		return nil, fmt.Errorf("invalid network version constraint %q: unknown operator %q", s, op)
+	}
+}
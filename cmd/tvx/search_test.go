@@ -0,0 +1,78 @@
+// stm: #unit
+package main
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestParseNetworkVersionConstraint(t *testing.T) {
+	for s, expected := range map[string][3]bool{ // nv 9, 10, 11
+		"10":   {false, true, false},
+		"=10":  {false, true, false},
+		"!=10": {true, false, true},
+		"<10":  {true, false, false},
+		"<=10": {true, true, false},
+		">10":  {false, false, true},
+		">=10": {false, true, true},
+	} {
+		f, err := parseNetworkVersionConstraint(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, nv := range []uint{9, 10, 11} {
+			if f(nv) != expected[i] {
+				t.Errorf("%s: expected %t for nv%d", s, expected[i], nv)
+			}
+		}
+	}
+	if _, err := parseNetworkVersionConstraint("~10"); err == nil {
+		t.Fatal("expected error for unknown operator")
+	}
+}
+
+func TestVectorQuery(t *testing.T) {
+	to, _ := address.NewIDAddress(1000)
+	msg := &types.Message{To: to, From: to, Method: 5}
+	b, err := msg.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv := &schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta: &schema.Metadata{
+			Gen: []schema.GenerationData{
+				{Source: "actor:fil/8/storageminer"},
+				{Source: "method:SubmitWindowedPoSt"},
+			},
+			Tags: []string{"anonymized"},
+		},
+		Pre:           &schema.Preconditions{Variants: []schema.Variant{{NetworkVersion: 16}}},
+		ApplyMessages: []schema.Message{{Bytes: b}},
+		Post:          &schema.Postconditions{Receipts: []*schema.Receipt{{ExitCode: 0}}},
+	}
+	msgs := searchedMessages(tv, false)
+
+	ok, fail := int64(0), int64(16)
+	nv, _ := parseNetworkVersionConstraint(">=10")
+	for _, tc := range []struct {
+		q     vectorQuery
+		match bool
+	}{
+		{vectorQuery{actor: "storageminer", method: "5", exitCode: &ok, nv: nv}, true},
+		{vectorQuery{method: "submitwindowedpost", tags: []string{"anonymized"}}, true},
+		{vectorQuery{actor: "multisig"}, false},
+		{vectorQuery{method: "6"}, false},
+		{vectorQuery{exitCode: &fail}, false},
+		{vectorQuery{class: "tipset"}, false},
+		{vectorQuery{tags: []string{"other"}}, false},
+	} {
+		if got := tc.q.matches(tv, msgs); got != tc.match {
+			t.Errorf("%+v: expected match %t, got %t", tc.q, tc.match, got)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// mockSyscallsSelector returns the selector enabling the supplied mock
+// syscall features, as passed through the --mock-syscalls flag, or nil if
+// no features were requested.
+func mockSyscallsSelector(features string) ([]string, error) {
+	if features == "" {
+		return nil, nil
+	}
+	if _, err := conformance.ParseMockSyscalls(features); err != nil {
+		return nil, err
+	}
+	return []string{conformance.SelectorMockSyscalls + "=" + features}, nil
+}
+
+// circSupplySelector returns the selector declaring the supplied circulating
+// supply mode, as passed through the --circ-supply flag, or nil for the
+// default fixed mode, which vectors needn't declare.
+func circSupplySelector(mode string) ([]string, error) {
+	switch mode {
+	case "", conformance.CircSupplyFixed:
+		return nil, nil
+	case conformance.CircSupplyRecompute:
+		return []string{conformance.SelectorCircSupply + "=" + mode}, nil
+	default:
+		return nil, fmt.Errorf("unknown circulating supply mode: %s", mode)
+	}
+}
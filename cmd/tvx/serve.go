@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/urfave/cli/v2"
+	"go.opencensus.io/stats/view"
+
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/metrics/proxy"
+)
+
+var serveFlags struct {
+	listen string
+	queue  int
+	jobTTL time.Duration
+}
+
+var serveCmd = &cli.Command{
+	Name: "serve",
+	Description: `serve vector extraction over an HTTP API, so that vectors can be generated remotely.
+
+   POST /v1/extract with a JSON body describing the vector to extract, e.g.
+   {"cid": "bafy...", "precursor": "all"}, enqueues an extraction job and
+   responds with 202 Accepted and the job, whose status can be polled at
+   GET /v1/jobs/<id>. Once the job succeeds, the vector is available at
+   GET /v1/jobs/<id>/vector. With POST /v1/extract?wait=true, the request
+   blocks until the job finishes, and responds with the vector.
+
+   Accepted fields: class (message, tipset, blockseq, implicit, migration;
+   defaults to message), id, cid, url, block, tsk, implicit, miner, epoch,
+   precursor, retain, selectors, hints, tags, mock_syscalls, record_syscalls,
+   force, ignore_sanity_checks, squash. They mirror the flags of tvx extract.
+
+   Jobs are executed one at a time, in submission order. Finished jobs, and
+   their vectors, are kept for --job-ttl, after which they're evicted, and
+   polling them responds with 404 Not Found.
+
+   Prometheus metrics are exported at GET /debug/metrics, including the number
+   of attempted, succeeded and failed extractions, the latency of the requests
+   to the backing node, the sizes of the extracted CARs, and the outcomes of the
+   extraction sanity checks.
+`,
+	Action: runServe,
+	Before: initialize,
+	After:  destroy,
+	Flags: []cli.Flag{
+		&repoFlag,
+		&apiFlag,
+		&rpcQPSFlag,
+		&rpcConcurrencyFlag,
+		&cli.StringFlag{
+			Name:        "listen",
+			Usage:       "address to listen on",
+			Value:       "127.0.0.1:1236",
+			Destination: &serveFlags.listen,
+		},
+		&cli.IntFlag{
+			Name:        "queue",
+			Usage:       "maximum number of queued jobs; further submissions are rejected with 503 Service Unavailable",
+			Value:       64,
+			Destination: &serveFlags.queue,
+		},
+		&cli.DurationFlag{
+			Name:        "job-ttl",
+			Usage:       "how long finished jobs, and their vectors, are kept for polling before they're evicted",
+			Value:       time.Hour,
+			Destination: &serveFlags.jobTTL,
+		},
+	},
+}
+
+// extractRequest is the body of an extraction request; see serveCmd.
+type extractRequest struct {
+	Class              string   `json:"class"`
+	ID                 string   `json:"id"`
+	Cid                string   `json:"cid"`
+	URL                string   `json:"url"`
+	Block              string   `json:"block"`
+	Tsk                string   `json:"tsk"`
+	Implicit           string   `json:"implicit"`
+	Miner              string   `json:"miner"`
+	Epoch              int64    `json:"epoch"`
+	Precursor          string   `json:"precursor"`
+	Retain             string   `json:"retain"`
+	Selectors          []string `json:"selectors"`
+	Hints              []string `json:"hints"`
+	Tags               []string `json:"tags"`
+	MockSyscalls       string   `json:"mock_syscalls"`
+	RecordSyscalls     bool     `json:"record_syscalls"`
+	Force              bool     `json:"force"`
+	IgnoreSanityChecks bool     `json:"ignore_sanity_checks"`
+	Squash             bool     `json:"squash"`
+}
+
+// opts returns the extraction options of the request, applying the defaults
+// of tvx extract.
+func (r *extractRequest) opts() (extractOpts, error) {
+	opts := extractOpts{
+		class:              r.Class,
+		id:                 r.ID,
+		cid:                r.Cid,
+		url:                r.URL,
+		block:              r.Block,
+		tsk:                r.Tsk,
+		implicit:           r.Implicit,
+		miner:              r.Miner,
+		epoch:              r.Epoch,
+		precursor:          r.Precursor,
+		retain:             r.Retain,
+		hints:              r.Hints,
+		tags:               r.Tags,
+		recordSyscalls:     r.RecordSyscalls,
+		force:              r.Force,
+		ignoreSanityChecks: r.IgnoreSanityChecks,
+		squash:             r.Squash,
+	}
+	if opts.class == "" {
+		opts.class = "message"
+	}
+	if opts.precursor == "" {
+		opts.precursor = extractor.PrecursorSelectParticipants
+	}
+	if opts.retain == "" {
+		opts.retain = "accessed-cids"
+	}
+	if opts.implicit == "" {
+		opts.implicit = extractor.ImplicitCron
+	}
+	if (opts.class == "tipset" || opts.class == "blockseq") && strings.Contains(opts.tsk, "..") && !opts.squash {
+		return opts, fmt.Errorf("tipset ranges can only be extracted with squash")
+	}
+
+	if err := conformance.ValidateTags(opts.tags); err != nil {
+		return opts, err
+	}
+
+	mocks, err := mockSyscallsSelector(r.MockSyscalls)
+	if err != nil {
+		return opts, err
+	}
+	opts.selectors = append(r.Selectors, mocks...)
+	return opts, nil
+}
+
+// Statuses of extraction jobs.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// extractJob is an extraction job submitted to tvx serve.
+type extractJob struct {
+	ID        string          `json:"id"`
+	Status    string          `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	Request   *extractRequest `json:"request"`
+	Submitted time.Time       `json:"submitted"`
+	Finished  time.Time       `json:"finished,omitempty"`
+
+	opts   extractOpts
+	vector []byte
+	done   chan struct{}
+}
+
+// extractServer queues extraction jobs, and executes them one at a time, as
+// the extraction commands rely on global state. Finished jobs are evicted
+// once older than ttl.
+type extractServer struct {
+	dir   string
+	queue chan *extractJob
+	ttl   time.Duration
+	// extract performs the extraction of a job; doExtract, unless testing.
+	extract func(extractOpts) error
+
+	lk   sync.Mutex
+	jobs map[string]*extractJob
+}
+
+func runServe(_ *cli.Context) error {
+	dir, err := os.MkdirTemp("", "tvx-serve")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	if err := view.Register(DefaultViews...); err != nil {
+		return fmt.Errorf("failed to register metric views: %w", err)
+	}
+	FullAPI = proxy.MetricedFullAPIV0(FullAPI)
+
+	s := &extractServer{
+		dir:     dir,
+		queue:   make(chan *extractJob, serveFlags.queue),
+		ttl:     serveFlags.jobTTL,
+		extract: doExtract,
+		jobs:    make(map[string]*extractJob),
+	}
+	go s.work()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/extract", s.handleExtract).Methods(http.MethodPost)
+	r.HandleFunc("/v1/jobs/{id}", s.handleJob).Methods(http.MethodGet)
+	r.HandleFunc("/v1/jobs/{id}/vector", s.handleVector).Methods(http.MethodGet)
+	r.Handle("/debug/metrics", metrics.Exporter()).Methods(http.MethodGet)
+
+	log.Printf("serving vector extraction on http://%s", serveFlags.listen)
+	return http.ListenAndServe(serveFlags.listen, r)
+}
+
+// work executes queued jobs.
+func (s *extractServer) work() {
+	for job := range s.queue {
+		s.run(job)
+	}
+}
+
+// run executes the job. A panicking extraction fails the job, rather than
+// taking the server, and the requests waiting for the job, down with it.
+func (s *extractServer) run(job *extractJob) {
+	defer close(job.done)
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("extraction job %s panicked: %v\n%s", job.ID, p, debug.Stack())
+			s.setStatus(job, JobFailed, fmt.Errorf("extraction panicked: %v", p))
+		}
+	}()
+	s.setStatus(job, JobRunning, nil)
+
+	file := filepath.Join(s.dir, job.ID+".json")
+	defer os.Remove(file) //nolint:errcheck
+	job.opts.file = file
+	err := s.extract(job.opts)
+	if err == nil {
+		job.vector, err = os.ReadFile(file)
+	}
+
+	if err != nil {
+		s.setStatus(job, JobFailed, err)
+	} else {
+		s.setStatus(job, JobSucceeded, nil)
+	}
+}
+
+// evict drops the jobs finished for longer than the TTL, as of now, and their
+// vectors; s.lk must be held.
+func (s *extractServer) evict(now time.Time) {
+	for id, job := range s.jobs {
+		if !job.Finished.IsZero() && now.Sub(job.Finished) > s.ttl {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *extractServer) setStatus(job *extractJob, status string, err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	job.Status = status
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if status == JobSucceeded || status == JobFailed {
+		job.Finished = time.Now()
+	}
+	log.Printf("extraction job %s: %s", job.ID, status)
+}
+
+func (s *extractServer) handleExtract(w http.ResponseWriter, r *http.Request) {
+	var req extractRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+	opts, err := req.opts()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	job := &extractJob{
+		ID:        uuid.New().String(),
+		Status:    JobQueued,
+		Request:   &req,
+		Submitted: time.Now(),
+		opts:      opts,
+		done:      make(chan struct{}),
+	}
+
+	s.lk.Lock()
+	s.evict(time.Now())
+	select {
+	case s.queue <- job:
+		s.jobs[job.ID] = job
+		s.lk.Unlock()
+	default:
+		s.lk.Unlock()
+		http.Error(w, "extraction queue is full", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.URL.Query().Get("wait") != "true" {
+		w.Header().Set("Location", "/v1/jobs/"+job.ID)
+		s.writeJob(w, job, http.StatusAccepted)
+		return
+	}
+
+	select {
+	case <-job.done:
+	case <-r.Context().Done():
+		return
+	}
+	if job.Status == JobFailed {
+		s.writeJob(w, job, http.StatusUnprocessableEntity)
+		return
+	}
+	writeVectorResponse(w, job)
+}
+
+func (s *extractServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	s.writeJob(w, job, http.StatusOK)
+}
+
+func (s *extractServer) handleVector(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	s.lk.Lock()
+	status := job.Status
+	s.lk.Unlock()
+	if status != JobSucceeded {
+		http.Error(w, fmt.Sprintf("job %s is %s", job.ID, status), http.StatusConflict)
+		return
+	}
+	writeVectorResponse(w, job)
+}
+
+// job returns the job identified in the request path, responding with 404 Not
+// Found if there's no such job.
+func (s *extractServer) job(w http.ResponseWriter, r *http.Request) (*extractJob, bool) {
+	id := mux.Vars(r)["id"]
+	s.lk.Lock()
+	s.evict(time.Now())
+	job, ok := s.jobs[id]
+	s.lk.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no such job: %s", id), http.StatusNotFound)
+	}
+	return job, ok
+}
+
+func (s *extractServer) writeJob(w http.ResponseWriter, job *extractJob, code int) {
+	s.lk.Lock()
+	b, err := json.Marshal(job)
+	s.lk.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
+
+func writeVectorResponse(w http.ResponseWriter, job *extractJob) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(job.vector)
+}
@@ -0,0 +1,119 @@
+// stm: #unit
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+func TestExtractRequestOpts(t *testing.T) {
+	opts, err := (&extractRequest{Cid: "bafy"}).opts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.class != "message" || opts.precursor != extractor.PrecursorSelectParticipants || opts.retain != "accessed-cids" || opts.implicit != extractor.ImplicitCron {
+		t.Fatalf("unexpected defaults: %+v", opts)
+	}
+
+	if _, err := (&extractRequest{Class: "tipset", Tsk: "@10..@20"}).opts(); err == nil {
+		t.Fatal("expected error for unsquashed tipset range")
+	}
+	if _, err := (&extractRequest{MockSyscalls: "bogus"}).opts(); err == nil {
+		t.Fatal("expected error for unknown mocked syscalls")
+	}
+}
+
+func TestExtractServerJobs(t *testing.T) {
+	s := &extractServer{
+		queue: make(chan *extractJob, 1),
+		jobs:  make(map[string]*extractJob),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/extract":
+			s.handleExtract(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	post := func(body string) *http.Response {
+		resp, err := http.Post(srv.URL+"/v1/extract", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := post("{"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid body; got %d", resp.StatusCode)
+	}
+
+	resp := post(`{"cid": "bafy"}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202; got %d", resp.StatusCode)
+	}
+	var job extractJob
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != JobQueued || resp.Header.Get("Location") != "/v1/jobs/"+job.ID {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	// the queue holds a single job, and there's no worker draining it.
+	if resp := post(`{"cid": "bafy"}`); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 with a full queue; got %d", resp.StatusCode)
+	}
+}
+
+func TestExtractServerPanic(t *testing.T) {
+	s := &extractServer{
+		dir:     t.TempDir(),
+		extract: func(extractOpts) error { panic("boom") },
+		jobs:    make(map[string]*extractJob),
+	}
+	job := &extractJob{ID: "job", Status: JobQueued, done: make(chan struct{})}
+	s.run(job)
+
+	select {
+	case <-job.done:
+	default:
+		t.Fatal("expected the panicked job to be done")
+	}
+	if job.Status != JobFailed || !strings.Contains(job.Error, "boom") || job.Finished.IsZero() {
+		t.Fatalf("expected the panicked job to fail; got %+v", job)
+	}
+}
+
+func TestExtractServerEvict(t *testing.T) {
+	now := time.Now()
+	s := &extractServer{
+		ttl: time.Hour,
+		jobs: map[string]*extractJob{
+			"queued":  {Status: JobQueued},
+			"running": {Status: JobRunning},
+			"recent":  {Status: JobSucceeded, Finished: now.Add(-time.Minute)},
+			"expired": {Status: JobSucceeded, Finished: now.Add(-2 * time.Hour)},
+			"failed":  {Status: JobFailed, Finished: now.Add(-2 * time.Hour)},
+		},
+	}
+	s.evict(now)
+	for _, id := range []string{"queued", "running", "recent"} {
+		if _, ok := s.jobs[id]; !ok {
+			t.Errorf("expected job %s to be kept", id)
+		}
+	}
+	for _, id := range []string{"expired", "failed"} {
+		if _, ok := s.jobs[id]; ok {
+			t.Errorf("expected job %s to be evicted", id)
+		}
+	}
+}
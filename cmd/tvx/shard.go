@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// vectorShard is a shard of a corpus, as selected by tvx exec --shard i/n:
+// the i-th of n, 1-based. Vectors are assigned to shards by a stable hash of
+// their IDs, so that CI workers split a corpus without coordinating, and a
+// vector stays in its shard as vectors are added to or removed from the
+// corpus.
+type vectorShard struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
+}
+
+// parseShard parses a shard in the i/n form.
+func parseShard(s string) (*vectorShard, error) {
+	i, n, ok := strings.Cut(s, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid shard %q; expected i/n, e.g. 1/4", s)
+	}
+	var (
+		sh  vectorShard
+		err error
+	)
+	if sh.Index, err = strconv.Atoi(i); err != nil {
+		return nil, fmt.Errorf("invalid shard index %q: %w", i, err)
+	}
+	if sh.Count, err = strconv.Atoi(n); err != nil {
+		return nil, fmt.Errorf("invalid shard count %q: %w", n, err)
+	}
+	if sh.Count < 1 || sh.Index < 1 || sh.Index > sh.Count {
+		return nil, fmt.Errorf("invalid shard %q; the index must be between 1 and the count", s)
+	}
+	return &sh, nil
+}
+
+func (s *vectorShard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// contains returns whether the vector is assigned to the shard; vectors
+// without IDs are assigned by the supplied label, e.g. their path.
+func (s *vectorShard) contains(tv *schema.TestVector, label string) bool {
+	if s == nil {
+		return true
+	}
+	key := label
+	if tv.Meta != nil && tv.Meta.ID != "" {
+		key = tv.Meta.ID
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum64()%uint64(s.Count)) == s.Index-1
+}
+
+var mergeReportsFlags struct {
+	reportHTML    string
+	reportMD      string
+	reportJSON    string
+	reportGroupBy string
+	triage        bool
+}
+
+var mergeReportsCmd = &cli.Command{
+	Name: "merge-reports",
+	Description: `merge the JSON reports of the shards of a corpus run into a single report.
+
+   Large corpora are split across CI workers with tvx exec --shard i/n, each
+   worker writing the report of its shard with --report-json. This command
+   combines those reports, and writes the report of the whole run in any of
+   the formats of tvx exec. It fails if two reports are of the same shard, or
+   of shards of different counts, and warns of missing shards.`,
+	ArgsUsage: "<report.json>...",
+	Action:    runMergeReports,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "report-html",
+			Usage:       "write the merged report as HTML to the specified file",
+			TakesFile:   true,
+			Destination: &mergeReportsFlags.reportHTML,
+		},
+		&cli.StringFlag{
+			Name:        "report-md",
+			Usage:       "write the merged report as Markdown to the specified file",
+			TakesFile:   true,
+			Destination: &mergeReportsFlags.reportMD,
+		},
+		&cli.StringFlag{
+			Name:        "report-json",
+			Usage:       "write the merged report as JSON to the specified file",
+			TakesFile:   true,
+			Destination: &mergeReportsFlags.reportJSON,
+		},
+		&cli.StringFlag{
+			Name:        "report-group-by",
+			Usage:       "how to group the vectors of the merged report; see tvx exec --report-group-by. Defaults to that of the reports",
+			Destination: &mergeReportsFlags.reportGroupBy,
+		},
+		&cli.BoolFlag{
+			Name:        "triage",
+			Usage:       "print the clusters of the failed vectors of the merged report by root cause signature; see tvx exec --triage",
+			Destination: &mergeReportsFlags.triage,
+		},
+	},
+}
+
+func runMergeReports(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no reports supplied")
+	}
+	switch mergeReportsFlags.reportGroupBy {
+	case "", reportGroupByMethod, reportGroupByTag:
+	default:
+		return fmt.Errorf("invalid --report-group-by %q; values: %s, %s", mergeReportsFlags.reportGroupBy, reportGroupByMethod, reportGroupByTag)
+	}
+
+	reports := make([]*corpusReport, 0, c.NArg())
+	for _, path := range c.Args().Slice() {
+		r, err := readJSONReport(path)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, r)
+	}
+	merged, err := mergeReports(reports)
+	if err != nil {
+		return err
+	}
+	if mergeReportsFlags.reportGroupBy != "" {
+		merged.GroupBy = mergeReportsFlags.reportGroupBy
+	}
+
+	t := merged.Totals()
+	log.Printf("merged %d reports of %d vectors: %d passed, %d failed, %d timed out, %d cached, %d expected failures, %d skipped",
+		len(reports), len(merged.Vectors), t.Passed, t.Failed, t.TimedOut, t.Cached, t.ExpectedFailures, t.Skipped)
+
+	if err := writeReports(merged, mergeReportsFlags.reportHTML, mergeReportsFlags.reportMD, mergeReportsFlags.reportJSON); err != nil {
+		return err
+	}
+	if mergeReportsFlags.triage {
+		return writeTriage(c.App.Writer, merged)
+	}
+	return nil
+}
+
+// mergeReports merges the reports of the shards of a run, in the order of
+// their shards. The merged report is generated at the time the last shard
+// was, and grouped as the first one.
+func mergeReports(reports []*corpusReport) (*corpusReport, error) {
+	sorted := append([]*corpusReport(nil), reports...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Shard != nil && (sorted[j].Shard == nil || sorted[i].Shard.Index < sorted[j].Shard.Index)
+	})
+
+	var (
+		merged = &corpusReport{GroupBy: sorted[0].GroupBy}
+		count  int
+		seen   = make(map[int]struct{})
+	)
+	for _, r := range sorted {
+		if r.Generated.After(merged.Generated) {
+			merged.Generated = r.Generated
+		}
+		merged.Vectors = append(merged.Vectors, r.Vectors...)
+
+		if r.Shard == nil {
+			continue
+		}
+		if count != 0 && r.Shard.Count != count {
+			return nil, fmt.Errorf("reports of shards of different counts: %d and %d", count, r.Shard.Count)
+		}
+		if _, ok := seen[r.Shard.Index]; ok {
+			return nil, fmt.Errorf("several reports of shard %s", r.Shard)
+		}
+		count = r.Shard.Count
+		seen[r.Shard.Index] = struct{}{}
+	}
+
+	var missing []string
+	for i := 1; i <= count; i++ {
+		if _, ok := seen[i]; !ok {
+			missing = append(missing, strconv.Itoa(i))
+		}
+	}
+	if len(missing) > 0 {
+		log.Printf("warning: missing the reports of shards %s of %d; the merged report is partial", strings.Join(missing, ", "), count)
+	}
+	if merged.Generated.IsZero() {
+		merged.Generated = time.Now()
+	}
+	return merged, nil
+}
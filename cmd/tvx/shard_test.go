@@ -0,0 +1,99 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestVectorShards(t *testing.T) {
+	for _, s := range []string{"0/4", "5/4", "1/0", "1", "a/4"} {
+		if _, err := parseShard(s); err == nil {
+			t.Errorf("expected shard %q to be invalid", s)
+		}
+	}
+
+	// every vector is assigned to exactly one shard.
+	const count = 4
+	sizes := make([]int, count)
+	for v := 0; v < 200; v++ {
+		tv := &schema.TestVector{Meta: &schema.Metadata{ID: fmt.Sprintf("vector-%d", v)}}
+		var assigned int
+		for i := 1; i <= count; i++ {
+			sh, err := parseShard(fmt.Sprintf("%d/%d", i, count))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sh.contains(tv, "") {
+				assigned++
+				sizes[i-1]++
+			}
+		}
+		if assigned != 1 {
+			t.Fatalf("vector %d assigned to %d shards", v, assigned)
+		}
+	}
+	for i, n := range sizes {
+		if n == 0 {
+			t.Errorf("shard %d/%d holds no vectors", i+1, count)
+		}
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	dir := t.TempDir()
+	write := func(shard int, vectors ...*vectorReport) string {
+		var buf bytes.Buffer
+		report := &corpusReport{Generated: time.Unix(int64(shard), 0), Shard: &vectorShard{Index: shard, Count: 3}, Vectors: vectors}
+		if err := writeJSONReport(&buf, report); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("shard-%d.json", shard))
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	read := func(paths ...string) []*corpusReport {
+		var reports []*corpusReport
+		for _, p := range paths {
+			r, err := readJSONReport(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reports = append(reports, r)
+		}
+		return reports
+	}
+
+	third := write(3, &vectorReport{Path: "c.json", Status: vectorStatusFailed, Failures: []string{"wrong exit code"}, Signature: "exit"})
+	first := write(1, &vectorReport{Path: "a.json", Status: vectorStatusPassed, HasGas: true, GasExpected: 10, GasActual: 12})
+
+	// shard 2 is missing, which only warns.
+	merged, err := mergeReports(read(third, first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Vectors) != 2 || merged.Vectors[0].Path != "a.json" || merged.Vectors[1].Path != "c.json" {
+		t.Fatalf("unexpected merged vectors: %+v", merged.Vectors)
+	}
+	if merged.Shard != nil || !merged.Generated.Equal(time.Unix(3, 0)) {
+		t.Errorf("unexpected merged shard %v, generated at %s", merged.Shard, merged.Generated)
+	}
+	if v := merged.Vectors[0]; v.GasDelta() != 2 {
+		t.Errorf("expected the gas to survive the round trip, got %+v", v)
+	}
+	if tot := merged.Totals(); tot.Passed != 1 || tot.Failed != 1 {
+		t.Errorf("unexpected totals: %+v", tot)
+	}
+
+	if _, err := mergeReports(read(first, first)); err == nil {
+		t.Error("expected error for several reports of the same shard")
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// sharedBlocks is the block store shared by the vectors of a corpus; see
+// conformance.SelectorSharedBlocks. It's either a CAR file, optionally
+// gzip-compressed, loaded in memory, and rewritten on close if blocks were
+// added, or a directory holding a file per block, named by CID.
+type sharedBlocks struct {
+	path string
+	// blks are the blocks of a CAR store; nil for a directory store.
+	blks map[cid.Cid][]byte
+	// roots are the roots of a CAR store: the block indexes of the vectors
+	// sharing it.
+	roots []cid.Cid
+	dirty bool
+}
+
+// isCARPath returns whether the path names a CAR file.
+func isCARPath(path string) bool {
+	return hasAnySuffix(path, []string{".car", ".car.gz"})
+}
+
+// openSharedBlocks opens the shared block store at path. Paths ending in .car
+// or .car.gz are CAR stores, and others directory stores. Missing stores are
+// created if create is true.
+func openSharedBlocks(path string, create bool) (*sharedBlocks, error) {
+	s := &sharedBlocks{path: path}
+	if !isCARPath(path) {
+		fi, err := os.Stat(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && create:
+			return s, os.MkdirAll(path, 0755)
+		case err != nil:
+			return nil, fmt.Errorf("failed to open shared block store: %w", err)
+		case !fi.IsDir():
+			return nil, fmt.Errorf("shared block store %s is neither a directory nor a .car or .car.gz file", path)
+		}
+		return s, nil
+	}
+
+	s.blks = make(map[cid.Cid][]byte)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && create:
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to open shared block store: %w", err)
+	}
+	if data, err = gunzipCAR(data); err != nil {
+		return nil, err
+	}
+	cr, err := car.NewCarReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shared block store %s: %w", path, err)
+	}
+	s.roots = cr.Header.Roots
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			return s, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read shared block store %s: %w", path, err)
+		}
+		s.blks[blk.Cid()] = blk.RawData()
+	}
+}
+
+// blockPath returns the path of the file of the block in a directory store.
+// Files are spread across subdirectories by the next to last two characters
+// of their CIDs, as CIDs share their prefixes.
+func (s *sharedBlocks) blockPath(c cid.Cid) string {
+	key := c.String()
+	return filepath.Join(s.path, key[len(key)-3:len(key)-1], key)
+}
+
+// Get is a conformance.BlockGetter.
+func (s *sharedBlocks) Get(_ context.Context, c cid.Cid) (blocks.Block, error) {
+	var (
+		data []byte
+		ok   bool
+	)
+	if s.blks != nil {
+		if data, ok = s.blks[c]; !ok {
+			return nil, fmt.Errorf("block %s not found in the shared store", c)
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(s.blockPath(c)); err != nil {
+			return nil, fmt.Errorf("block %s not found in the shared store: %w", c, err)
+		}
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// put adds the block to the store, and returns whether it was missing.
+func (s *sharedBlocks) put(c cid.Cid, data []byte) (bool, error) {
+	if s.blks != nil {
+		if _, ok := s.blks[c]; ok {
+			return false, nil
+		}
+		s.blks[c], s.dirty = data, true
+		return true, nil
+	}
+	p := s.blockPath(c)
+	if _, err := os.Stat(p); err == nil {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(p, data, 0644)
+}
+
+// Close writes a CAR store to its file, if blocks were added: the indexes of
+// the vectors sharing it are its roots, and its blocks are sorted by CID.
+func (s *sharedBlocks) Close() error {
+	if s.blks == nil || !s.dirty {
+		return nil
+	}
+	order := make([]cid.Cid, 0, len(s.blks))
+	for c := range s.blks {
+		order = append(order, c)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i].KeyString() < order[j].KeyString() })
+	write := func(w io.Writer) error {
+		if err := car.WriteHeader(&car.CarHeader{Roots: s.roots, Version: 1}, w); err != nil {
+			return fmt.Errorf("failed to write CAR header: %w", err)
+		}
+		for _, c := range order {
+			if err := util.LdWrite(w, c.Bytes(), s.blks[c]); err != nil {
+				return fmt.Errorf("failed to write CAR block: %w", err)
+			}
+		}
+		return nil
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if strings.HasSuffix(s.path, ".gz") {
+		data, err = extractor.EncodeCAR(write)
+	} else {
+		var buf bytes.Buffer
+		err = write(&buf)
+		data = buf.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write shared block store: %w", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+// shareVector moves the blocks of the CAR of the vector into the store, with
+// their index, and replaces the CAR with one carrying its roots only; see
+// conformance.SelectorSharedBlocks. It returns the number of blocks of the
+// vector, and of those missing from the store until then.
+func shareVector(tv *schema.TestVector, store *sharedBlocks) (total, added int, err error) {
+	data, err := gunzipCAR(tv.CAR)
+	if err != nil {
+		return 0, 0, err
+	}
+	cr, err := car.NewCarReader(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read CAR: %w", err)
+	}
+	var index conformance.SharedBlockIndex
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, 0, fmt.Errorf("failed to read CAR: %w", err)
+		}
+		ok, err := store.put(blk.Cid(), blk.RawData())
+		if err != nil {
+			return 0, 0, err
+		}
+		if ok {
+			added++
+		}
+		index.Blocks = append(index.Blocks, blk.Cid())
+	}
+
+	iblk, err := index.Block()
+	if err != nil {
+		return 0, 0, err
+	}
+	ok, err := store.put(iblk.Cid(), iblk.RawData())
+	if err != nil {
+		return 0, 0, err
+	}
+	if ok && store.blks != nil {
+		store.roots = append(store.roots, iblk.Cid())
+	}
+
+	if tv.CAR, err = extractor.EncodeCAR(func(w io.Writer) error {
+		return car.WriteHeader(&car.CarHeader{Roots: cr.Header.Roots, Version: 1}, w)
+	}); err != nil {
+		return 0, 0, err
+	}
+	if tv.Selector == nil {
+		tv.Selector = make(schema.Selector)
+	}
+	tv.Selector[conformance.SelectorSharedBlocks] = iblk.Cid().String()
+	return len(index.Blocks), added, nil
+}
+
+var sharedBlocksFlags struct {
+	store string
+}
+
+var sharedBlocksStoreFlag = cli.StringFlag{
+	Name:        "store",
+	Usage:       "shared block store: a .car or .car.gz file, or a directory of block files",
+	Required:    true,
+	TakesFile:   true,
+	Destination: &sharedBlocksFlags.store,
+}
+
+// rewriteVectorFiles applies fn to the vectors of the files and directories
+// supplied, rewriting those it changes in place. Vectors in archives are
+// skipped, as they can't be rewritten in place.
+func rewriteVectorFiles(paths []string, fn func(path string, tv *schema.TestVector) (bool, error)) (rewritten int, err error) {
+	for _, root := range paths {
+		if isVectorArchive(root) {
+			return rewritten, fmt.Errorf("can't rewrite the vectors of archive %s in place; extract it first", root)
+		}
+		err := walkVectorFiles(root, func(path string, content []byte) error {
+			if _, err := os.Stat(path); err != nil {
+				log.Printf("skipping vector %s: not a file that can be rewritten in place", path)
+				return nil
+			}
+			var tv schema.TestVector
+			if err := json.Unmarshal(content, &tv); err != nil {
+				return fmt.Errorf("failed to decode test vector %s: %w", path, err)
+			}
+			changed, err := fn(path, &tv)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if !changed {
+				return nil
+			}
+			rewritten++
+			return writeVector(&tv, path)
+		})
+		if err != nil {
+			return rewritten, err
+		}
+	}
+	return rewritten, nil
+}
+
+func runCarShare(c *cli.Context) (err error) {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no vector files or directories supplied")
+	}
+	store, err := openSharedBlocks(sharedBlocksFlags.store, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := store.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	var total, added int
+	shared, err := rewriteVectorFiles(c.Args().Slice(), func(path string, tv *schema.TestVector) (bool, error) {
+		if _, ok := tv.Selector[conformance.SelectorSharedBlocks]; ok {
+			return false, nil
+		}
+		t, a, err := shareVector(tv, store)
+		total, added = total+t, added+a
+		return err == nil, err
+	})
+	log.Printf("shared the blocks of %d vectors: %d blocks, %d of which were added to the store, %d deduplicated", shared, total, added, total-added)
+	return err
+}
+
+func runCarMaterialize(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no vector files or directories supplied")
+	}
+	store, err := openSharedBlocks(sharedBlocksFlags.store, false)
+	if err != nil {
+		return err
+	}
+	materialized, err := rewriteVectorFiles(c.Args().Slice(), func(path string, tv *schema.TestVector) (bool, error) {
+		if _, ok := tv.Selector[conformance.SelectorSharedBlocks]; !ok {
+			return false, nil
+		}
+		return true, conformance.MaterializeVector(c.Context, tv, store)
+	})
+	log.Printf("materialized %d vectors", materialized)
+	return err
+}
+
+// execSharedBlocks is the shared block store of tvx exec, if supplied with
+// --shared-blocks.
+var execSharedBlocks *sharedBlocks
+
+// materializeShared materializes the vector with the shared block store of
+// tvx exec, if its blocks are held in one; the driver refuses it otherwise.
+func materializeShared(tv *schema.TestVector) error {
+	if _, ok := tv.Selector[conformance.SelectorSharedBlocks]; !ok || execSharedBlocks == nil {
+		return nil
+	}
+	// the selector is dropped from a copy, as the caller holds the vector.
+	sel := make(schema.Selector, len(tv.Selector))
+	for k, v := range tv.Selector {
+		sel[k] = v
+	}
+	tv.Selector = sel
+	if err := conformance.MaterializeVector(context.TODO(), tv, execSharedBlocks); err != nil {
+		return fmt.Errorf("failed to materialize vector: %w", err)
+	}
+	return nil
+}
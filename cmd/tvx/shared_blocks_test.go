@@ -0,0 +1,89 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+func TestShareVector(t *testing.T) {
+	mkVector := func(blks ...blocks.Block) *schema.TestVector {
+		data, err := extractor.EncodeCAR(func(w io.Writer) error {
+			if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{blks[0].Cid()}, Version: 1}, w); err != nil {
+				return err
+			}
+			for _, b := range blks {
+				if err := util.LdWrite(w, b.Cid().Bytes(), b.RawData()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &schema.TestVector{Class: schema.ClassMessage, CAR: data}
+	}
+	a, b, c := blocks.NewBlock([]byte("a")), blocks.NewBlock([]byte("b")), blocks.NewBlock([]byte("c"))
+
+	for _, name := range []string{"blocks.car.gz", "blocks"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			store, err := openSharedBlocks(path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			first, second := mkVector(a, b), mkVector(b, c)
+			originals := [][]byte{first.CAR, second.CAR}
+			if total, added, err := shareVector(first, store); err != nil || total != 2 || added != 2 {
+				t.Fatalf("unexpected sharing of the first vector: %d blocks, %d added, err %v", total, added, err)
+			}
+			if total, added, err := shareVector(second, store); err != nil || total != 2 || added != 1 {
+				t.Fatalf("unexpected sharing of the second vector: %d blocks, %d added, err %v", total, added, err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, stats, err := listCAR(mustGunzip(t, first.CAR)); err != nil || len(stats) != 0 {
+				t.Fatalf("expected the CAR of a shared vector to hold no blocks; got %d, err %v", len(stats), err)
+			}
+
+			// reopened, the store materializes the vectors as they were.
+			if store, err = openSharedBlocks(path, false); err != nil {
+				t.Fatal(err)
+			}
+			for i, tv := range []*schema.TestVector{first, second} {
+				if err := conformance.MaterializeVector(context.Background(), tv, store); err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := tv.Selector[conformance.SelectorSharedBlocks]; ok {
+					t.Errorf("vector %d still carries the shared blocks selector", i)
+				}
+				if !bytes.Equal(tv.CAR, originals[i]) {
+					t.Errorf("vector %d materialized with a CAR other than its original", i)
+				}
+			}
+		})
+	}
+}
+
+func mustGunzip(t *testing.T, data []byte) []byte {
+	out, err := gunzipCAR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/lib/sigs"
+	_ "github.com/filecoin-project/lotus/lib/sigs/bls"
+	_ "github.com/filecoin-project/lotus/lib/sigs/secp"
+)
+
+// Sources of the generation data entries recording the provenance signature
+// of a vector. The signer is either "ed25519:<hex public key>" or
+// "wallet:<address>"; the signature is base64-encoded.
+const (
+	GenSigner    = "signer"
+	GenSignature = "signature"
+)
+
+// vectorSigner signs the digests of vectors.
+type vectorSigner interface {
+	// signer returns the identity of the signer, as recorded in the vector.
+	signer() string
+	sign(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// signer signs the vectors written by tvx extract, if enabled with
+// --sign-key or --sign-wallet.
+var signer vectorSigner
+
+// ed25519Signer signs vectors with an ed25519 key.
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+// loadEd25519Signer loads the ed25519 key in the file, which holds a
+// hex-encoded 32-byte seed (e.g. generated with openssl rand -hex 32) or
+// 64-byte private key.
+func loadEd25519Signer(path string) (*ed25519Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	b, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key in %s: %w", path, err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return &ed25519Signer{key: ed25519.NewKeyFromSeed(b)}, nil
+	case ed25519.PrivateKeySize:
+		return &ed25519Signer{key: b}, nil
+	default:
+		return nil, fmt.Errorf("invalid signing key in %s: expected %d or %d bytes, got %d", path, ed25519.SeedSize, ed25519.PrivateKeySize, len(b))
+	}
+}
+
+func (s *ed25519Signer) signer() string {
+	return "ed25519:" + hex.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+func (s *ed25519Signer) sign(_ context.Context, digest []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, digest), nil
+}
+
+// walletSigner signs vectors with a key of the node's wallet.
+type walletSigner struct {
+	addr address.Address
+}
+
+func (s *walletSigner) signer() string {
+	return "wallet:" + s.addr.String()
+}
+
+func (s *walletSigner) sign(ctx context.Context, digest []byte) ([]byte, error) {
+	sig, err := FullAPI.WalletSign(ctx, s.addr, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with wallet key %s: %w", s.addr, err)
+	}
+	return sig.MarshalBinary()
+}
+
+// vectorDigest returns the digest of the vector that's signed: the SHA-256
+// hash of its JSON encoding, without the signer and signature entries.
+func vectorDigest(vector *schema.TestVector) ([]byte, error) {
+	v := *vector
+	if v.Meta != nil {
+		meta := *v.Meta
+		meta.Gen = nil
+		for _, g := range v.Meta.Gen {
+			if g.Source != GenSigner && g.Source != GenSignature {
+				meta.Gen = append(meta.Gen, g)
+			}
+		}
+		v.Meta = &meta
+	}
+	b, err := json.Marshal(&v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode vector: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+// signVector signs the vector, recording the signer and signature in its
+// metadata, and replacing any previous signature.
+func signVector(ctx context.Context, s vectorSigner, vector *schema.TestVector) error {
+	if vector.Meta == nil {
+		vector.Meta = &schema.Metadata{}
+	}
+	digest, err := vectorDigest(vector)
+	if err != nil {
+		return err
+	}
+	sig, err := s.sign(ctx, digest)
+	if err != nil {
+		return err
+	}
+
+	gen := vector.Meta.Gen[:0]
+	for _, g := range vector.Meta.Gen {
+		if g.Source != GenSigner && g.Source != GenSignature {
+			gen = append(gen, g)
+		}
+	}
+	vector.Meta.Gen = append(gen,
+		schema.GenerationData{Source: GenSigner, Version: s.signer()},
+		schema.GenerationData{Source: GenSignature, Version: base64.StdEncoding.EncodeToString(sig)},
+	)
+	return nil
+}
+
+// verifyVector verifies the signature of the vector, and returns its signer.
+func verifyVector(vector *schema.TestVector) (string, error) {
+	var signer, signature string
+	if vector.Meta != nil {
+		for _, g := range vector.Meta.Gen {
+			switch g.Source {
+			case GenSigner:
+				signer = g.Version
+			case GenSignature:
+				signature = g.Version
+			}
+		}
+	}
+	if signer == "" || signature == "" {
+		return "", fmt.Errorf("vector is not signed")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return signer, fmt.Errorf("invalid signature: %w", err)
+	}
+	digest, err := vectorDigest(vector)
+	if err != nil {
+		return signer, err
+	}
+
+	scheme, id, _ := strings.Cut(signer, ":")
+	switch scheme {
+	case "ed25519":
+		pub, err := hex.DecodeString(id)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return signer, fmt.Errorf("invalid ed25519 signer %s", signer)
+		}
+		if !ed25519.Verify(pub, digest, sig) {
+			return signer, fmt.Errorf("invalid signature by %s", signer)
+		}
+	case "wallet":
+		addr, err := address.NewFromString(id)
+		if err != nil {
+			return signer, fmt.Errorf("invalid wallet signer %s: %w", signer, err)
+		}
+		var s crypto.Signature
+		if err := s.UnmarshalBinary(sig); err != nil {
+			return signer, fmt.Errorf("invalid signature: %w", err)
+		}
+		if err := sigs.Verify(&s, addr, digest); err != nil {
+			return signer, fmt.Errorf("invalid signature by %s: %w", signer, err)
+		}
+	default:
+		return signer, fmt.Errorf("unsupported signer %s", signer)
+	}
+	return signer, nil
+}
+
+var verifySignatureFlags struct {
+	signers cli.StringSlice
+}
+
+var verifySignatureCmd = &cli.Command{
+	Name: "verify-signature",
+	Description: "verify the provenance signatures of test vectors, signed with tvx extract --sign-key or --sign-wallet, " +
+		"printing the signer of each vector. Exits with an error if any vector is unsigned, its signature is invalid, " +
+		"or its signer is not one of the expected signers",
+	ArgsUsage: "<vector file>...",
+	Action:    runVerifySignature,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:        "signer",
+			Usage:       "expected signer, as 'ed25519:<hex public key>' or 'wallet:<address>'; can be repeated",
+			Destination: &verifySignatureFlags.signers,
+		},
+	},
+}
+
+func runVerifySignature(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no vector files supplied")
+	}
+	expected := make(map[string]struct{})
+	for _, s := range verifySignatureFlags.signers.Value() {
+		expected[s] = struct{}{}
+	}
+
+	var failed int
+	for _, file := range c.Args().Slice() {
+		signer, err := verifyVectorFile(file)
+		if err == nil && len(expected) > 0 {
+			if _, ok := expected[signer]; !ok {
+				err = fmt.Errorf("unexpected signer %s", signer)
+			}
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("%s: FAIL: %s\n", file, err)
+			continue
+		}
+		fmt.Printf("%s: OK: signed by %s\n", file, signer)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d vectors failed verification", failed, c.NArg())
+	}
+	return nil
+}
+
+func verifyVectorFile(file string) (string, error) {
+	vector, err := readVector(file)
+	if err != nil {
+		return "", err
+	}
+	return verifyVector(vector)
+}
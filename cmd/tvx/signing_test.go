@@ -0,0 +1,68 @@
+// stm: #unit
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestSignVector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	seed := make([]byte, ed25519.SeedSize)
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(seed)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadEd25519Signer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vector := &schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta: &schema.Metadata{
+			ID:  "test",
+			Gen: []schema.GenerationData{{Source: "lotus", Version: "v1"}},
+		},
+		CAR: []byte{1, 2, 3},
+	}
+	if err := signVector(context.Background(), s, vector); err != nil {
+		t.Fatal(err)
+	}
+
+	// signatures survive an encoding round trip.
+	b, err := json.Marshal(vector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded schema.TestVector
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := verifyVector(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer != s.signer() {
+		t.Fatalf("expected signer %s, got %s", s.signer(), signer)
+	}
+
+	// re-signing replaces the previous signature.
+	if err := signVector(context.Background(), s, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Meta.Gen) != 3 {
+		t.Fatalf("expected 3 generation entries, got %d", len(decoded.Meta.Gen))
+	}
+
+	decoded.Meta.ID = "tampered"
+	if _, err := verifyVector(&decoded); err == nil {
+		t.Fatal("expected tampered vector to fail verification")
+	}
+}
@@ -1,24 +1,26 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os/exec"
 
-	"github.com/fatih/color"
+	"github.com/ipfs/go-cid"
 	"github.com/urfave/cli/v2"
 
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/test-vectors/schema"
 
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
 	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/extractor"
 )
 
 var simulateFlags struct {
@@ -26,6 +28,8 @@ var simulateFlags struct {
 	epoch     int64
 	out       string
 	statediff bool
+	selectors cli.StringSlice
+	mocks     string
 }
 
 var simulateCmd = &cli.Command{
@@ -38,6 +42,10 @@ var simulateCmd = &cli.Command{
 	After:  destroy,
 	Flags: []cli.Flag{
 		&repoFlag,
+		&apiFlag,
+		&rpcQPSFlag,
+		&rpcConcurrencyFlag,
+		&prefetchFlag,
 		&cli.StringFlag{
 			Name:        "msg",
 			Usage:       "base64 cbor-encoded message",
@@ -51,10 +59,21 @@ var simulateCmd = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:        "out",
-			Usage:       "file to write the test vector to; if nil, the vector will be written to stdout",
+			Usage:       "file to write the test vector to; if empty or '-', the vector will be written to stdout",
 			TakesFile:   true,
 			Destination: &simulateFlags.out,
 		},
+		&cli.StringSliceFlag{
+			Name:        "selector",
+			Usage:       "selector to add to the vector, in key=value form; can be repeated. Selectors for features detected during simulation are added automatically",
+			Destination: &simulateFlags.selectors,
+		},
+		&cli.StringFlag{
+			Name: "mock-syscalls",
+			Usage: "comma-separated list of syscalls to mock, recorded in the vector so that it's replayed with the same mocks; " +
+				"values: 'proofs' (all proof verifications pass), 'signatures' (all signature verifications pass). Only effective before nv16",
+			Destination: &simulateFlags.mocks,
+		},
 		&cli.BoolFlag{
 			Name:        "statediff",
 			Usage:       "display a statediff of the precondition and postcondition states",
@@ -79,6 +98,16 @@ func runSimulateCmd(_ *cli.Context) error {
 
 	log.Printf("message to simulate has CID: %s", msg.Cid())
 
+	mocks, err := mockSyscallsSelector(simulateFlags.mocks)
+	if err != nil {
+		return err
+	}
+
+	selector, err := extractor.ParseSelectors(append(simulateFlags.selectors.Value(), mocks...))
+	if err != nil {
+		return err
+	}
+
 	msgjson, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to serialize message to json for printing: %w", err)
@@ -112,44 +141,41 @@ func runSimulateCmd(_ *cli.Context) error {
 	}
 
 	// Create the driver.
-	stores := NewProxyingStores(ctx, FullAPI)
-	driver := conformance.NewDriver(ctx, schema.Selector{}, conformance.DriverOpts{
+	stores := extractor.NewProxyingStores(ctx, FullAPI, prefetchLinks)
+	driver := conformance.NewDriver(ctx, selector, conformance.DriverOpts{
 		DisableVMFlush: true,
 	})
 	rand := conformance.NewRecordingRand(r, FullAPI)
 
-	tbs, ok := stores.Blockstore.(TracingBlockstore)
+	tbs, ok := stores.Blockstore.(extractor.TracingBlockstore)
 	if !ok {
 		return fmt.Errorf("no tracing blockstore available")
 	}
-	tbs.StartTracing()
-	applyret, postroot, err := driver.ExecuteMessage(stores.Blockstore, conformance.ExecuteMessageParams{
-		Preroot:    preroot,
-		Epoch:      epoch,
-		Message:    msg,
-		CircSupply: circSupply.FilCirculating,
-		BaseFee:    baseFee,
-		Rand:       rand,
-		// TODO NetworkVersion
+	var (
+		applyret *vm.ApplyRet
+		postroot cid.Cid
+	)
+	accessed, err := tbs.Trace(func(bs blockstore.Blockstore) (err error) {
+		applyret, postroot, err = driver.ExecuteMessage(bs, conformance.ExecuteMessageParams{
+			Preroot:    preroot,
+			Epoch:      epoch,
+			Message:    msg,
+			CircSupply: circSupply.FilCirculating,
+			BaseFee:    baseFee,
+			Rand:       rand,
+			// TODO NetworkVersion
+		})
+		return err
 	})
 	if err != nil {
 		return fmt.Errorf("failed to apply message: %w", err)
 	}
 
-	accessed := tbs.FinishTracing()
-
-	var (
-		out = new(bytes.Buffer)
-		gw  = gzip.NewWriter(out)
-		g   = NewSurgeon(ctx, FullAPI, stores)
-	)
-	if err := g.WriteCARIncluding(gw, accessed, preroot, postroot); err != nil {
-		return err
-	}
-	if err = gw.Flush(); err != nil {
-		return err
-	}
-	if err = gw.Close(); err != nil {
+	g := extractor.NewSurgeon(ctx, FullAPI, stores)
+	carBytes, err := extractor.EncodeCAR(func(w io.Writer) error {
+		return g.WriteCARIncluding(w, accessed, preroot, postroot)
+	})
+	if err != nil {
 		return err
 	}
 
@@ -164,7 +190,7 @@ func runSimulateCmd(_ *cli.Context) error {
 		return err
 	}
 
-	codename := GetProtocolCodename(epoch)
+	codename := extractor.GetProtocolCodename(epoch)
 
 	// Write out the test vector.
 	vector := schema.TestVector{
@@ -178,7 +204,7 @@ func runSimulateCmd(_ *cli.Context) error {
 			schema.SelectorMinProtocolVersion: codename,
 		},
 		Randomness: rand.Recorded(),
-		CAR:        out.Bytes(),
+		CAR:        carBytes,
 		Pre: &schema.Preconditions{
 			Variants: []schema.Variant{
 				{ID: codename, Epoch: int64(epoch), NetworkVersion: uint(nv)},
@@ -204,17 +230,17 @@ func runSimulateCmd(_ *cli.Context) error {
 		},
 	}
 
+	extractor.PopulateSelector(&vector, selector, applyret)
+
 	if err := writeVector(&vector, simulateFlags.out); err != nil {
 		return fmt.Errorf("failed to write vector: %w", err)
 	}
 
-	log.Printf(color.GreenString("wrote vector at: %s"), simulateFlags.out)
-
 	if !simulateFlags.statediff {
 		return nil
 	}
 
-	if simulateFlags.out == "" {
+	if simulateFlags.out == "" || simulateFlags.out == "-" {
 		log.Print("omitting statediff in non-file mode")
 		return nil
 	}
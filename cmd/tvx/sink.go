@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// vectorSink publishes vectors to shared storage.
+type vectorSink interface {
+	// put publishes the vector under the name, and returns the CID or URL
+	// it's reachable at.
+	put(ctx context.Context, name string, data []byte) (string, error)
+}
+
+// Schemes of the output sinks.
+const (
+	SinkIPFS = "ipfs"
+	SinkS3   = "s3"
+	SinkW3S  = "w3s"
+)
+
+// isSink returns whether the output is a sink URL, rather than a file.
+func isSink(out string) bool {
+	for _, s := range []string{SinkIPFS, SinkS3, SinkW3S} {
+		if strings.HasPrefix(out, s+"://") {
+			return true
+		}
+	}
+	return false
+}
+
+// openSink returns the sink for an output URL, and the name of the vector in
+// the sink:
+//
+//	ipfs://[host:port]         adds the vector to the IPFS node whose HTTP API
+//	                           listens at host:port (127.0.0.1:5001 if empty)
+//	s3://bucket/prefix/        puts the vector into the S3 bucket, under the
+//	                           prefix; credentials are read from the standard
+//	                           AWS_* environment variables
+//	w3s://                     uploads the vector to web3.storage, with the API
+//	                           token in WEB3_STORAGE_TOKEN
+func openSink(out string) (vectorSink, string, error) {
+	u, err := url.Parse(out)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid output URL %s: %w", out, err)
+	}
+	switch u.Scheme {
+	case SinkIPFS:
+		host := u.Host
+		if host == "" {
+			host = "127.0.0.1:5001"
+		}
+		return &ipfsSink{api: "http://" + host}, strings.TrimPrefix(u.Path, "/"), nil
+	case SinkS3:
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("no bucket in output URL %s", out)
+		}
+		s, err := newS3Sink(u.Host)
+		return s, strings.TrimPrefix(u.Path, "/"), err
+	case SinkW3S:
+		token := os.Getenv("WEB3_STORAGE_TOKEN")
+		if token == "" {
+			return nil, "", fmt.Errorf("WEB3_STORAGE_TOKEN must be set to upload to web3.storage")
+		}
+		return &w3sSink{token: token}, strings.TrimPrefix(u.Path, "/"), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported output URL %s", out)
+	}
+}
+
+// outputPath returns the output for the named file in the output directory,
+// which may be a sink URL.
+func outputPath(dir, name string) string {
+	if isSink(dir) {
+		if !strings.HasSuffix(dir, "://") {
+			dir = strings.TrimSuffix(dir, "/")
+		}
+		return dir + "/" + name
+	}
+	return filepath.Join(dir, name)
+}
+
+// publishVector publishes the encoded vector to the sink at the output URL,
+// and prints the CID or URL it's reachable at to stdout.
+func publishVector(out string, data []byte) (string, error) {
+	s, key, err := openSink(out)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	loc, err := s.put(ctx, key, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to publish vector to %s: %w", out, err)
+	}
+	fmt.Println(loc)
+	return loc, nil
+}
+
+// ipfsSink adds vectors to an IPFS node through its HTTP API.
+type ipfsSink struct {
+	api string
+}
+
+func (s *ipfsSink) put(ctx context.Context, name string, data []byte) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", path.Base(name))
+	if err != nil {
+		return "", err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.api+"/api/v0/add?cid-version=1&pin=true", &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var res struct {
+		Hash string
+	}
+	if err := doSinkRequest(req, &res); err != nil {
+		return "", err
+	}
+	return "ipfs://" + res.Hash, nil
+}
+
+// w3sSink uploads vectors to web3.storage.
+type w3sSink struct {
+	token string
+}
+
+func (s *w3sSink) put(ctx context.Context, name string, data []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.web3.storage/upload", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	if name != "" {
+		req.Header.Set("X-Name", url.PathEscape(path.Base(name)))
+	}
+
+	var res struct {
+		Cid string `json:"cid"`
+	}
+	if err := doSinkRequest(req, &res); err != nil {
+		return "", err
+	}
+	return "ipfs://" + res.Cid, nil
+}
+
+// s3Sink puts vectors into an S3 bucket, signing the requests with AWS
+// Signature Version 4.
+type s3Sink struct {
+	bucket   string
+	region   string
+	endpoint string
+	// pathStyle addresses the bucket in the path of custom endpoints, as
+	// S3-compatible stores commonly require.
+	pathStyle bool
+
+	accessKey, secretKey, sessionToken string
+}
+
+func newS3Sink(bucket string) (*s3Sink, error) {
+	s := &s3Sink{
+		bucket:       bucket,
+		region:       os.Getenv("AWS_REGION"),
+		endpoint:     os.Getenv("AWS_ENDPOINT_URL"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to upload to S3")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.endpoint == "" {
+		s.endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, s.region)
+	} else {
+		s.endpoint = strings.TrimSuffix(s.endpoint, "/")
+		s.pathStyle = true
+	}
+	return s, nil
+}
+
+func (s *s3Sink) put(ctx context.Context, key string, data []byte) (string, error) {
+	p := "/" + key
+	if s.pathStyle {
+		p = "/" + s.bucket + p
+	}
+	u, err := url.Parse(s.endpoint + p)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.sign(req, data, time.Now().UTC())
+
+	if err := doSinkRequest(req, nil); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("s3://%s/%s", s.bucket, key), nil
+}
+
+// sign signs the request with AWS Signature Version 4.
+func (s *s3Sink) sign(req *http.Request, payload []byte, now time.Time) {
+	var (
+		date      = now.Format("20060102")
+		timestamp = now.Format("20060102T150405Z")
+		scope     = date + "/" + s.region + "/s3/aws4_request"
+		sum       = sha256.Sum256(payload)
+		hash      = hex.EncodeToString(sum[:])
+	)
+	req.Header.Set("X-Amz-Date", timestamp)
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if s.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hash,
+	}, "\n")
+	crSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", timestamp, scope, hex.EncodeToString(crSum[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// doSinkRequest performs the request, and decodes the JSON response into
+// res, if not nil.
+func doSinkRequest(req *http.Request, res interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, bytes.TrimSpace(msg))
+	}
+	if res == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", req.URL.Redacted(), err)
+	}
+	return nil
+}
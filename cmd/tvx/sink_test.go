@@ -0,0 +1,94 @@
+// stm: #unit
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOutputPath(t *testing.T) {
+	for dir, expected := range map[string]string{
+		"vectors":             "vectors/v.json",
+		"s3://bucket/prefix/": "s3://bucket/prefix/v.json",
+		"s3://bucket":         "s3://bucket/v.json",
+		"ipfs://":             "ipfs:///v.json",
+	} {
+		if got := outputPath(dir, "v.json"); got != expected {
+			t.Errorf("outputPath(%q): expected %q, got %q", dir, expected, got)
+		}
+	}
+	if isSink("vectors/s3://x") || !isSink("w3s://") {
+		t.Fatal("unexpected sink detection")
+	}
+}
+
+func TestIPFSSink(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/add" {
+			http.NotFound(w, r)
+			return
+		}
+		f, hdr, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if data, _ := io.ReadAll(f); hdr.Filename != "v.json" || string(data) != "{}" {
+			http.Error(w, "unexpected file", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"Name":"v.json","Hash":"bafyvector"}`))
+	}))
+	defer srv.Close()
+
+	s, name, err := openSink("ipfs://" + strings.TrimPrefix(srv.URL, "http://") + "/v.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, err := s.put(context.Background(), name, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != "ipfs://bafyvector" {
+		t.Fatalf("unexpected location: %s", loc)
+	}
+}
+
+func TestS3Sink(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if r.Method != http.MethodPut || r.URL.Path != "/bucket/prefix/v.json" ||
+			!strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date,") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	s, name, err := openSink("s3://bucket/prefix/v.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, err := s.put(context.Background(), name, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc != "s3://bucket/prefix/v.json" {
+		t.Fatalf("unexpected location: %s", loc)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	if _, _, err := openSink("s3://bucket/"); err == nil {
+		t.Fatal("expected error without credentials")
+	}
+}
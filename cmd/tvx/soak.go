@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+var soakFlags struct {
+	file          string
+	duration      time.Duration
+	maxIterations int
+	leakWindow    int
+	leakMinGrowth float64
+}
+
+var soakCmd = &cli.Command{
+	Name: "soak",
+	Description: `execute a corpus of test vectors in a loop for a duration, sampling the heap
+   and goroutine counts between iterations, and flag their monotonic growth.
+
+   The conformance driver also runs in long-running services, where memory
+   retained per execution, e.g. blockstores that outlive their vectors,
+   accumulates. Every iteration executes all the vectors of the corpus, each
+   with a fresh blockstore, then collects garbage and samples the heap in use
+   and the number of goroutines. The first iteration warms caches up, and is
+   not sampled.
+
+   A leak is flagged when the heap in use grew on every one of the last
+   --leak-window iterations, by more than --leak-min-growth percent overall,
+   or when the number of goroutines did. The command then fails, so that it
+   gates CI; capture a heap profile with --memprofile to locate the leak.`,
+	Action: runSoak,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "file",
+			Usage:       "input vector file, or directory or archive of vector files",
+			TakesFile:   true,
+			Required:    true,
+			Destination: &soakFlags.file,
+		},
+		&cli.DurationFlag{
+			Name:        "duration",
+			Usage:       "how long to loop the corpus for; the iteration in progress completes",
+			Value:       10 * time.Minute,
+			Destination: &soakFlags.duration,
+		},
+		&cli.IntFlag{
+			Name:        "max-iterations",
+			Usage:       "stop after this many iterations, even within the duration; 0 is unlimited",
+			Destination: &soakFlags.maxIterations,
+		},
+		&cli.IntFlag{
+			Name:        "leak-window",
+			Usage:       "number of consecutive iterations the heap or goroutines must grow on to flag a leak",
+			Value:       5,
+			Destination: &soakFlags.leakWindow,
+		},
+		&cli.Float64Flag{
+			Name:        "leak-min-growth",
+			Usage:       "minimum growth of the heap in use over the leak window, in percent, to flag a leak, so that noise isn't flagged",
+			Value:       5,
+			Destination: &soakFlags.leakMinGrowth,
+		},
+	}, profileCmdFlags...),
+}
+
+// soakSample is a measurement taken after an iteration over the corpus.
+type soakSample struct {
+	iteration  int
+	elapsed    time.Duration
+	failed     int
+	heapInuse  uint64
+	goroutines int
+}
+
+func runSoak(c *cli.Context) error {
+	if soakFlags.leakWindow < 1 {
+		return fmt.Errorf("--leak-window must be at least 1")
+	}
+	if soakFlags.leakMinGrowth < 0 {
+		return fmt.Errorf("--leak-min-growth must be positive")
+	}
+
+	// decode the corpus once, so that its own memory isn't sampled as
+	// growth.
+	var (
+		labels  []string
+		vectors []*schema.TestVector
+	)
+	err := walkVectorFiles(soakFlags.file, func(path string, content []byte) error {
+		var tv schema.TestVector
+		if err := json.Unmarshal(content, &tv); err != nil {
+			return fmt.Errorf("failed to decode test vector %s: %w", path, err)
+		}
+		labels, vectors = append(labels, path), append(vectors, &tv)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(vectors) == 0 {
+		return fmt.Errorf("no vectors found in %s", soakFlags.file)
+	}
+
+	stopProfiling, err := startProfiling()
+	defer stopProfiling()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("soaking %d vectors for %s", len(vectors), soakFlags.duration)
+
+	var (
+		samples []soakSample
+		start   = time.Now()
+		failing = make(map[string]struct{})
+	)
+	for i := 0; ; i++ {
+		if soakFlags.maxIterations > 0 && i >= soakFlags.maxIterations {
+			break
+		}
+		if i > 0 && time.Since(start) >= soakFlags.duration {
+			break
+		}
+		if err := c.Context.Err(); err != nil {
+			log.Printf("interrupted after %d iterations", i)
+			break
+		}
+
+		failed := soakIteration(labels, vectors, failing)
+		if i == 0 {
+			continue
+		}
+
+		s := soakSample{iteration: i, elapsed: time.Since(start), failed: failed, goroutines: runtime.NumGoroutine()}
+		var ms runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		s.heapInuse = ms.HeapInuse
+		samples = append(samples, s)
+
+		log.Printf("iteration %d: heap in use: %d bytes, goroutines: %d, failed vectors: %d", i, s.heapInuse, s.goroutines, failed)
+	}
+
+	printSoakSamples(os.Stdout, samples)
+
+	heap := make([]uint64, len(samples))
+	goroutines := make([]uint64, len(samples))
+	for i, s := range samples {
+		heap[i], goroutines[i] = s.heapInuse, uint64(s.goroutines)
+	}
+	var leaks []string
+	if growth, ok := monotonicGrowth(heap, soakFlags.leakWindow, soakFlags.leakMinGrowth); ok {
+		leaks = append(leaks, fmt.Sprintf("the heap in use grew by %.2f%% over the last %d iterations", growth, soakFlags.leakWindow))
+	}
+	if growth, ok := monotonicGrowth(goroutines, soakFlags.leakWindow, 0); ok {
+		leaks = append(leaks, fmt.Sprintf("the number of goroutines grew by %.2f%% over the last %d iterations", growth, soakFlags.leakWindow))
+	}
+	if len(samples) <= soakFlags.leakWindow {
+		log.Printf("warning: only %d iterations were sampled, too few for the leak window of %d; extend --duration", len(samples), soakFlags.leakWindow)
+	}
+	for _, l := range leaks {
+		log.Printf("possible leak: %s", l)
+	}
+	if len(leaks) > 0 {
+		return fmt.Errorf("possible leaks detected: %d", len(leaks))
+	}
+	return nil
+}
+
+// soakIteration executes every vector of the corpus, and returns how many
+// failed. Failures are logged the first time a vector fails.
+func soakIteration(labels []string, vectors []*schema.TestVector, failing map[string]struct{}) int {
+	// silence the execution logs; failures are surfaced through the reporter.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+
+	var failed int
+	for i, tv := range vectors {
+		r := new(conformance.LogReporter)
+		_, err := executeTestVector(context.Background(), r, *tv, conformance.ExecuteOpts{})
+		if err == nil && !r.Failed() {
+			continue
+		}
+		failed++
+		if _, ok := failing[labels[i]]; ok {
+			continue
+		}
+		failing[labels[i]] = struct{}{}
+		msg := "vector execution failed; run it with 'tvx exec' for details"
+		if err != nil {
+			msg = err.Error()
+		}
+		_, _ = fmt.Fprintf(logOutput, "vector %s failed: %s\n", labels[i], msg)
+	}
+	return failed
+}
+
+// monotonicGrowth returns whether the values grew strictly on each of the
+// last window samples, and by more than minGrowth percent overall, and that
+// growth. It returns false if there are no more than window samples.
+func monotonicGrowth(values []uint64, window int, minGrowth float64) (growth float64, ok bool) {
+	if len(values) <= window {
+		return 0, false
+	}
+	tail := values[len(values)-window-1:]
+	for i := 1; i < len(tail); i++ {
+		if tail[i] <= tail[i-1] {
+			return 0, false
+		}
+	}
+	first, last := tail[0], tail[len(tail)-1]
+	if first == 0 {
+		return 0, false
+	}
+	growth = 100 * float64(last-first) / float64(first)
+	return growth, growth > minGrowth
+}
+
+func printSoakSamples(w io.Writer, samples []soakSample) {
+	tw := tabwriter.NewWriter(w, 2, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "iteration\telapsed\tfailed\theap in use\tgoroutines")
+	for _, s := range samples {
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\n", s.iteration, s.elapsed.Round(time.Millisecond), s.failed, s.heapInuse, s.goroutines)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,27 @@
+// stm: #unit
+package main
+
+import "testing"
+
+func TestMonotonicGrowth(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		values    []uint64
+		minGrowth float64
+		leak      bool
+	}{
+		{"too few samples", []uint64{100, 110, 120}, 5, false},
+		{"steady growth", []uint64{90, 100, 110, 120, 130}, 5, true},
+		{"growth below minimum", []uint64{1000, 1001, 1002, 1003, 1004}, 5, false},
+		{"plateau", []uint64{100, 110, 120, 120, 130}, 5, false},
+		{"growth before the window", []uint64{100, 200, 300, 150, 151, 150}, 0, false},
+		{"growth after a drop", []uint64{300, 100, 200, 300, 400, 500}, 5, true},
+		{"goroutines", []uint64{10, 10, 11, 12, 13, 14}, 0, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if _, leak := monotonicGrowth(c.values, 3, c.minGrowth); leak != c.leak {
+				t.Errorf("expected leak: %t, got %t", c.leak, leak)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+)
+
+// stateStatsTop is the number of largest retained objects to report after
+// extracting a vector; 0 disables the report.
+var stateStatsTop int
+
+// Kinds of the retained objects, as classified by the shape of their IPLD
+// nodes.
+const (
+	NodeKindHAMT  = "hamt"
+	NodeKindAMT   = "amt"
+	NodeKindOther = "other"
+)
+
+// retainedObject is an object of the state retained by a vector.
+type retainedObject struct {
+	cid      cid.Cid
+	kind     string
+	size     int
+	accesses int
+}
+
+// stateStats summarizes the state retained by a vector.
+type stateStats struct {
+	objects int
+	bytes   int
+	// kinds counts the objects, and their bytes, by node kind.
+	kinds      map[string]int
+	kindsBytes map[string]int
+	// largest are the largest objects, by decreasing size.
+	largest []retainedObject
+}
+
+// nodeKind classifies a DAG-CBOR node as a HAMT node ([bitfield, pointers]),
+// an AMT root or node ([bitwidth, height, count, node], [height, count, node]
+// or [bitmap, links, values]), or any other object.
+func nodeKind(c cid.Cid, raw []byte) string {
+	if c.Prefix().Codec != cid.DagCBOR {
+		return NodeKindOther
+	}
+	r := cbg.NewCborReader(bytes.NewReader(raw))
+	maj, n, err := r.ReadHeader()
+	if err != nil || maj != cbg.MajArray {
+		return NodeKindOther
+	}
+	first, extra, err := r.ReadHeader()
+	if err != nil {
+		return NodeKindOther
+	}
+	switch {
+	case n == 2 && first == cbg.MajByteString:
+		if _, err := io.CopyN(io.Discard, r, int64(extra)); err != nil {
+			return NodeKindOther
+		}
+		if second, _, err := r.ReadHeader(); err == nil && second == cbg.MajArray {
+			return NodeKindHAMT
+		}
+	case n == 3 && first == cbg.MajByteString,
+		(n == 3 || n == 4) && first == cbg.MajUnsignedInt:
+		return NodeKindAMT
+	}
+	return NodeKindOther
+}
+
+// computeStateStats computes the statistics of the accessed state, reading
+// the objects from the blockstore to classify them. Only the top largest
+// objects are kept.
+func computeStateStats(ctx context.Context, bs blockstore.Blockstore, accessed extractor.AccessSet, top int) *stateStats {
+	s := &stateStats{kinds: make(map[string]int), kindsBytes: make(map[string]int)}
+	for c, a := range accessed {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			// accessed, but absent, e.g. actors looked up but not found.
+			continue
+		}
+		o := retainedObject{
+			cid:      c,
+			kind:     nodeKind(c, blk.RawData()),
+			size:     len(blk.RawData()),
+			accesses: a.Count,
+		}
+		s.objects++
+		s.bytes += o.size
+		s.kinds[o.kind]++
+		s.kindsBytes[o.kind] += o.size
+		s.largest = append(s.largest, o)
+	}
+
+	sort.Slice(s.largest, func(i, j int) bool {
+		if s.largest[i].size != s.largest[j].size {
+			return s.largest[i].size > s.largest[j].size
+		}
+		return s.largest[i].cid.KeyString() < s.largest[j].cid.KeyString()
+	})
+	if len(s.largest) > top {
+		s.largest = s.largest[:top]
+	}
+	return s
+}
+
+func (s *stateStats) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 4, 2, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "retained state: %d objects, %d bytes\n", s.objects, s.bytes)
+	for _, kind := range []string{NodeKindHAMT, NodeKindAMT, NodeKindOther} {
+		_, _ = fmt.Fprintf(tw, "  %s nodes\t%d\t%d bytes\t\n", kind, s.kinds[kind], s.kindsBytes[kind])
+	}
+	_, _ = fmt.Fprintf(tw, "largest objects:\n")
+	for _, o := range s.largest {
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\t%d bytes\t%d accesses\t\n", o.cid, o.kind, o.size, o.accesses)
+	}
+	return tw.Flush()
+}
+
+// reportStateStats prints the statistics of the accessed state to stderr, if
+// requested through --state-stats.
+func reportStateStats(ctx context.Context, bs blockstore.Blockstore, accessed extractor.AccessSet) {
+	if stateStatsTop <= 0 {
+		return
+	}
+	s := computeStateStats(ctx, bs, accessed, stateStatsTop)
+	if err := s.print(os.Stderr); err != nil {
+		extractLog.Warnw("failed to report state statistics", "error", err)
+	}
+}
@@ -0,0 +1,69 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+func TestNodeKind(t *testing.T) {
+	// encode writes CBOR headers, and the bytes of byte strings.
+	encode := func(items ...interface{}) []byte {
+		var buf bytes.Buffer
+		for _, it := range items {
+			var err error
+			switch v := it.(type) {
+			case []byte:
+				if err = cbg.WriteMajorTypeHeader(&buf, cbg.MajByteString, uint64(len(v))); err == nil {
+					_, err = buf.Write(v)
+				}
+			case [2]uint64:
+				err = cbg.WriteMajorTypeHeader(&buf, byte(v[0]), v[1])
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return buf.Bytes()
+	}
+	array := func(n uint64) [2]uint64 { return [2]uint64{uint64(cbg.MajArray), n} }
+	uint_ := func(n uint64) [2]uint64 { return [2]uint64{uint64(cbg.MajUnsignedInt), n} }
+
+	cborCid := func(raw []byte) cid.Cid {
+		c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.BLAKE2B_MIN + 31}.Sum(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		want string
+	}{
+		{"hamt node", encode(array(2), []byte{0x01}, array(0)), NodeKindHAMT},
+		{"amt node", encode(array(3), []byte{0x01}, array(0), array(0)), NodeKindAMT},
+		{"amt root", encode(array(4), uint_(5), uint_(0), uint_(1), array(3)), NodeKindAMT},
+		{"legacy amt root", encode(array(3), uint_(0), uint_(1), array(3)), NodeKindAMT},
+		{"actor head", encode(array(2), uint_(1), uint_(2)), NodeKindOther},
+		{"byte string", encode([]byte("x")), NodeKindOther},
+	} {
+		if got := nodeKind(cborCid(tc.raw), tc.raw); got != tc.want {
+			t.Errorf("%s: got %s; want %s", tc.name, got, tc.want)
+		}
+	}
+
+	raw := encode(array(2), []byte{0x01}, array(0))
+	rawCid, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.IDENTITY}.Sum(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nodeKind(rawCid, raw); got != NodeKindOther {
+		t.Errorf("raw block: got %s; want %s", got, NodeKindOther)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// In stdio mode, tvx exec serves as an execution oracle to other processes,
+// e.g. the test harnesses of other implementations, or fuzzers: it reads
+// vectors from stdin, and writes the results of their execution by Lotus to
+// stdout, one result per vector, in the order the vectors were read, as soon
+// as they're executed. Logs go to stderr. In the json format, vectors and
+// results are JSON documents, one per line; in the cbor format, they're the
+// CBOR encodings of those, each prefixed by its length in bytes, as an
+// unsigned varint.
+//
+// The results of message and tipset vectors are those of the remote
+// execution protocol, per variant, so that they can be compared with those of
+// an implementation directly; vectors of other classes are only asserted.
+
+const (
+	stdioFormatJSON = "json"
+	stdioFormatCBOR = "cbor"
+)
+
+// maxStdioFrame is the maximum length of the CBOR vectors read in stdio mode.
+const maxStdioFrame = 1 << 30
+
+// stdioResult is the result of the execution of a vector in stdio mode.
+// Passed is whether the results matched the postconditions of the vector,
+// Failures the assertions that didn't, and Error why the vector couldn't be
+// executed, if it couldn't.
+type stdioResult struct {
+	ID       string               `json:"id"`
+	Passed   bool                 `json:"passed"`
+	Failures []string             `json:"failures,omitempty"`
+	Error    string               `json:"error,omitempty"`
+	Variants []stdioVariantResult `json:"variants,omitempty"`
+}
+
+// stdioVariantResult holds the results of the execution of a variant.
+type stdioVariantResult struct {
+	Variant string `json:"variant"`
+	remoteExecResponse
+}
+
+// execVectorsStdio executes the vectors read from in, in the format, and
+// writes their results to out. Vectors that fail to execute get a result
+// carrying the error; the stream is aborted only when it can't be decoded.
+func execVectorsStdio(in io.Reader, out io.Writer, format string) error {
+	switch format {
+	case stdioFormatJSON, stdioFormatCBOR:
+	default:
+		return fmt.Errorf("invalid --stdin-format %q; values: %s, %s", format, stdioFormatJSON, stdioFormatCBOR)
+	}
+
+	var (
+		r   = bufio.NewReader(in)
+		dec = json.NewDecoder(r)
+		w   = bufio.NewWriter(out)
+	)
+	for n := 0; ; n++ {
+		var (
+			data json.RawMessage
+			err  error
+		)
+		if format == stdioFormatCBOR {
+			data, err = readStdioFrame(r)
+		} else {
+			err = dec.Decode(&data)
+		}
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return fmt.Errorf("failed to read vector %d: %w", n, err)
+		}
+
+		var res stdioResult
+		var tv schema.TestVector
+		if err := json.Unmarshal(data, &tv); err != nil {
+			res.Error = fmt.Sprintf("failed to decode test vector: %s", err)
+		} else {
+			res, err = executeStdioVector(tv)
+		}
+		if err := writeStdioResult(w, format, &res); err != nil {
+			return fmt.Errorf("failed to write the result of vector %d: %w", n, err)
+		}
+		if err != nil {
+			return fmt.Errorf("vector %d: %w", n, err)
+		}
+	}
+}
+
+// readStdioFrame reads a length-prefixed CBOR vector, and returns its JSON
+// document.
+func readStdioFrame(r *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > maxStdioFrame {
+		return nil, fmt.Errorf("vector of %d bytes exceeds the maximum of %d", l, maxStdioFrame)
+	}
+	data := make([]byte, l)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return cborToJSON(data)
+}
+
+// writeStdioResult writes the result in the format, and flushes it, so that
+// the process reading it isn't left waiting.
+func writeStdioResult(w *bufio.Writer, format string, res *stdioResult) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if format == stdioFormatCBOR {
+		if data, err = jsonToCBOR(data); err != nil {
+			return err
+		}
+		var prefix [binary.MaxVarintLen64]byte
+		if _, err := w.Write(prefix[:binary.PutUvarint(prefix[:], uint64(len(data)))]); err != nil {
+			return err
+		}
+	} else {
+		data = append(data, '\n')
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// executeStdioVector executes the vector, and returns its result. The
+// variants of message and tipset vectors are executed for their results,
+// which are asserted as those of a remote executor are; the vectors of other
+// classes are executed as tvx exec does. The error returned, if any, is
+// that of an execution that timed out and didn't stop, which stops the run;
+// see executeGuarded.
+func executeStdioVector(tv schema.TestVector) (res stdioResult, stop error) {
+	if tv.Meta != nil {
+		res.ID = tv.Meta.ID
+	}
+	if tv.Pre == nil || len(tv.Pre.Variants) == 0 {
+		res.Error = "vector has no variants"
+		return res, nil
+	}
+	if err := materializeShared(&tv); err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+
+	r := new(reportingReporter)
+	var err error
+	switch tv.Class {
+	case schema.ClassMessage, schema.ClassTipset:
+		log.Println("executing test vector:", res.ID)
+		if execFlags.chaosActor {
+			if tv.Selector == nil {
+				tv.Selector = make(schema.Selector)
+			}
+			tv.Selector[schema.SelectorChaosActor] = "true"
+		}
+		var verr error
+		err = recoverFatal(func() {
+			for i := range tv.Pre.Variants {
+				v := &tv.Pre.Variants[i]
+				var vr stdioVariantResult
+				if vr, verr = computeStdioVariant(&tv, v); verr != nil {
+					verr = fmt.Errorf("failed to execute variant %s: %w", v.ID, verr)
+					return
+				}
+				res.Variants = append(res.Variants, vr)
+				if tv.Post != nil {
+					assertRemoteResults(r, &tv, &vr.remoteExecResponse, execVectorOpts)
+				}
+			}
+		})
+		if err == nil {
+			err = verr
+		}
+	default:
+		_, err = executeGuarded(r, tv, execVectorOpts)
+		if terr := (*errVectorTimeout)(nil); errors.As(err, &terr) && terr.stuck {
+			stop = err
+		}
+	}
+
+	res.Failures = r.failures
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.Passed = err == nil && !r.Failed()
+	return res, stop
+}
+
+// computeStdioVariant executes the variant of the message or tipset vector,
+// and returns its results.
+func computeStdioVariant(tv *schema.TestVector, v *schema.Variant) (res stdioVariantResult, err error) {
+	var post *schema.Postconditions
+	if perr := sandboxed(func() {
+		if tv.Class == schema.ClassMessage {
+			post, _, err = conformance.ComputeMessageVectorPostconditions(tv, v, execVectorOpts)
+		} else {
+			post, err = conformance.ComputeTipsetVectorPostconditions(tv, v, execVectorOpts)
+		}
+	}); perr != nil {
+		return res, perr
+	}
+	if err != nil {
+		return res, err
+	}
+
+	res.Variant = v.ID
+	res.Receipts, res.ReceiptsRoots = post.Receipts, post.ReceiptsRoots
+	if post.StateTree != nil {
+		root := post.StateTree.RootCID
+		res.StateRoot = &root
+	}
+	return res, nil
+}
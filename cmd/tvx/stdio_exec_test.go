@@ -0,0 +1,85 @@
+// stm: #unit
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExecVectorsStdioJSON(t *testing.T) {
+	in := `{"class":"message","_meta":{"id":"empty"}}` + "\n" + `{"class":5}` + "\n"
+	var out bytes.Buffer
+	if err := execVectorsStdio(strings.NewReader(in), &out, stdioFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 results, got %d: %q", len(lines), out.String())
+	}
+	var res stdioResult
+	if err := json.Unmarshal([]byte(lines[0]), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != "empty" || res.Passed || res.Error != "vector has no variants" {
+		t.Errorf("unexpected result of a vector without variants: %+v", res)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Passed || !strings.HasPrefix(res.Error, "failed to decode test vector") {
+		t.Errorf("unexpected result of a malformed vector: %+v", res)
+	}
+
+	if err := execVectorsStdio(strings.NewReader("{"), &out, stdioFormatJSON); err == nil {
+		t.Error("expected a truncated stream to fail")
+	}
+}
+
+func TestExecVectorsStdioCBOR(t *testing.T) {
+	vector, err := jsonToCBOR([]byte(`{"class":"message","_meta":{"id":"empty"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in bytes.Buffer
+	for i := 0; i < 2; i++ {
+		in.Write(uvarint(len(vector)))
+		in.Write(vector)
+	}
+	var out bytes.Buffer
+	if err := execVectorsStdio(&in, &out, stdioFormatCBOR); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(&out)
+	for i := 0; i < 2; i++ {
+		data, err := readStdioFrame(r)
+		if err != nil {
+			t.Fatalf("failed to read result %d: %s", i, err)
+		}
+		var res stdioResult
+		if err := json.Unmarshal(data, &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.ID != "empty" || res.Error != "vector has no variants" {
+			t.Errorf("unexpected result %d: %+v", i, res)
+		}
+	}
+	if _, err := r.ReadByte(); err == nil {
+		t.Error("expected exactly 2 results")
+	}
+
+	truncated := append(uvarint(len(vector)), vector[:len(vector)-1]...)
+	if err := execVectorsStdio(bytes.NewReader(truncated), &out, stdioFormatCBOR); err == nil {
+		t.Error("expected a truncated frame to fail")
+	}
+}
+
+func uvarint(n int) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, uint64(n))]
+}
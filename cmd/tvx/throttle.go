@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/time/rate"
+
+	"github.com/filecoin-project/lotus/api/v0api"
+)
+
+var rpcQPSFlag = cli.Float64Flag{
+	Name: "rpc-qps",
+	Usage: "maximum rate of JSON-RPC calls to the node, in calls per second, block fetches of the proxying store included; " +
+		"use this to extract against shared nodes without degrading them; 0 for no limit",
+}
+
+var rpcConcurrencyFlag = cli.IntFlag{
+	Name:  "rpc-concurrency",
+	Usage: "maximum number of JSON-RPC calls in flight to the node; 0 for no limit",
+}
+
+// throttleFullNode returns a full node API limiting the calls to the
+// supplied one to qps calls per second, and to concurrency calls in flight;
+// zero values don't limit. Calls wait for their turn, unless their context is
+// done first.
+func throttleFullNode(in v0api.FullNode, qps float64, concurrency int) v0api.FullNode {
+	if qps <= 0 && concurrency <= 0 {
+		return in
+	}
+
+	var (
+		limiter *rate.Limiter
+		sem     chan struct{}
+	)
+	if qps > 0 {
+		// allow bursts of up to a second worth of calls.
+		burst := int(qps)
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(rate.Limit(qps), burst)
+	}
+	if concurrency > 0 {
+		sem = make(chan struct{}, concurrency)
+	}
+
+	return proxyFullNode([]v0api.FullNode{in}, func(_ string, fns []reflect.Value, args []reflect.Value) []reflect.Value {
+		ctx, _ := args[0].Interface().(context.Context)
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return errorResults(fns[0].Type(), ctx.Err())
+			}
+		}
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return errorResults(fns[0].Type(), err)
+			}
+		}
+		return fns[0].Call(args)
+	})
+}
+
+// errorResults returns the results of a call to a function of the supplied
+// type that failed with err: zero values, and err as the trailing error.
+func errorResults(typ reflect.Type, err error) []reflect.Value {
+	out := make([]reflect.Value, typ.NumOut())
+	for i := range out {
+		out[i] = reflect.Zero(typ.Out(i))
+	}
+	out[len(out)-1] = reflect.ValueOf(&err).Elem()
+	return out
+}
@@ -0,0 +1,66 @@
+// stm: #unit
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/lotus/api/v0api"
+)
+
+// inFlightAPI records the maximum number of ChainReadObj calls in flight.
+type inFlightAPI struct {
+	v0api.FullNode
+
+	inFlight, max int32
+}
+
+func (a *inFlightAPI) ChainReadObj(context.Context, cid.Cid) ([]byte, error) {
+	n := atomic.AddInt32(&a.inFlight, 1)
+	defer atomic.AddInt32(&a.inFlight, -1)
+	for {
+		m := atomic.LoadInt32(&a.max)
+		if n <= m || atomic.CompareAndSwapInt32(&a.max, m, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return nil, nil
+}
+
+func TestThrottleFullNode(t *testing.T) {
+	in := new(inFlightAPI)
+	if throttleFullNode(in, 0, 0) != v0api.FullNode(in) {
+		t.Fatal("unlimited API wrapped")
+	}
+
+	tapi := throttleFullNode(in, 0, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = tapi.ChainReadObj(context.Background(), cid.Undef)
+		}()
+	}
+	wg.Wait()
+	if in.max > 2 {
+		t.Errorf("%d calls in flight, at most 2 expected", in.max)
+	}
+
+	// calls waiting for their turn give up when their context is done.
+	tapi = throttleFullNode(in, 0.001, 0)
+	if _, err := tapi.ChainReadObj(context.Background(), cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tapi.ChainReadObj(ctx, cid.Undef); err == nil {
+		t.Error("expected the rate-limited call to fail with its context")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// triageCluster is a set of failed vectors sharing a root cause signature.
+type triageCluster struct {
+	Signature string
+	Vectors   []*vectorReport
+}
+
+// mismatchSignature returns the root cause signature of the first mismatched
+// message of a failed vector. In order of precedence, the signature is that
+// of an exit code flip, of the first divergence of the call trees, of a
+// differing return value, or of the gas delta, which is kept exact since a
+// repricing shifts the gas used of all affected messages by the same amount.
+//
+// Vectors don't record the gas traces of their messages, so gas divergences
+// are located by receiver and method only.
+func mismatchSignature(m *conformance.ReceiptMismatch) string {
+	callee := fmt.Sprintf("%s.%d", builtin.ActorNameByCode(m.Code), m.Method)
+	switch {
+	case m.ExpectedExit != m.ActualExit:
+		return fmt.Sprintf("exit %s: %s -> %s", callee, m.ExpectedExit, m.ActualExit)
+	case m.TraceDiff != "":
+		return fmt.Sprintf("subcall %s: %s", callee, m.TraceDiff)
+	case m.ReturnDiffers:
+		return fmt.Sprintf("return %s", callee)
+	default:
+		return fmt.Sprintf("gas %s: %+d", callee, m.GasDelta)
+	}
+}
+
+// failureSignature returns the root cause signature of a vector that failed
+// with the supplied error and first mismatched message, either of which may
+// be nil.
+func failureSignature(err error, mismatch *conformance.ReceiptMismatch, diffs []string) string {
+	var (
+		perr *errVectorPanic
+		terr *errVectorTimeout
+	)
+	switch {
+	case errors.As(err, &terr):
+		return "timed out"
+	case errors.As(err, &perr):
+		return fmt.Sprintf("panic: %v", perr.value)
+	case mismatch != nil:
+		return mismatchSignature(mismatch)
+	case err != nil:
+		// the first line only, as errors may embed multi-line diffs.
+		return strings.SplitN(err.Error(), "\n", 2)[0]
+	case len(diffs) > 0:
+		return "post state root"
+	default:
+		return "other assertions"
+	}
+}
+
+// Triage returns the failed and timed out vectors of the report clustered by
+// root cause signature, the largest clusters first.
+func (c *corpusReport) Triage() []*triageCluster {
+	bySig := make(map[string]*triageCluster)
+	for _, v := range c.Vectors {
+		if v.Status != vectorStatusFailed && v.Status != vectorStatusTimedOut {
+			continue
+		}
+		cl, ok := bySig[v.Signature]
+		if !ok {
+			cl = &triageCluster{Signature: v.Signature}
+			bySig[v.Signature] = cl
+		}
+		cl.Vectors = append(cl.Vectors, v)
+	}
+
+	clusters := make([]*triageCluster, 0, len(bySig))
+	for _, cl := range bySig {
+		clusters = append(clusters, cl)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if a, b := len(clusters[i].Vectors), len(clusters[j].Vectors); a != b {
+			return a > b
+		}
+		return clusters[i].Signature < clusters[j].Signature
+	})
+	return clusters
+}
+
+// writeTriage writes the triage of the report in human-readable form, listing
+// up to three vectors of each cluster as examples.
+func writeTriage(w io.Writer, c *corpusReport) error {
+	clusters := c.Triage()
+	_, _ = fmt.Fprintf(w, "%d root causes:\n", len(clusters))
+
+	tw := tabwriter.NewWriter(w, 4, 2, 2, ' ', 0)
+	for _, cl := range clusters {
+		_, _ = fmt.Fprintf(tw, "%d\t%s\n", len(cl.Vectors), cl.Signature)
+		for i, v := range cl.Vectors {
+			if i == 3 {
+				_, _ = fmt.Fprintf(tw, "\t  ... and %d more\n", len(cl.Vectors)-i)
+				break
+			}
+			_, _ = fmt.Fprintf(tw, "\t  %s\n", v.Path)
+		}
+	}
+	return tw.Flush()
+}
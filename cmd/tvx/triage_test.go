@@ -0,0 +1,70 @@
+// stm: #unit
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestTriage(t *testing.T) {
+	var (
+		flip     = &conformance.ReceiptMismatch{Method: 5, ExpectedExit: exitcode.Ok, ActualExit: exitcode.ErrForbidden}
+		gas      = &conformance.ReceiptMismatch{Method: 5, GasDelta: 120}
+		subcall  = &conformance.ReceiptMismatch{Method: 5, TraceDiff: "root.0: exit code: expected 0, got 16", GasDelta: 10}
+		report   = new(corpusReport)
+		failures = []struct {
+			err      error
+			mismatch *conformance.ReceiptMismatch
+		}{
+			{nil, flip},
+			{nil, gas},
+			{nil, flip},
+			{nil, subcall},
+			{&errVectorPanic{value: "boom"}, nil},
+			{fmt.Errorf("failed to load state\nwith details"), nil},
+			{nil, flip},
+		}
+	)
+	for i, f := range failures {
+		report.Vectors = append(report.Vectors, &vectorReport{
+			Path:      fmt.Sprintf("%d.json", i),
+			Status:    vectorStatusFailed,
+			Signature: failureSignature(f.err, f.mismatch, nil),
+		})
+	}
+	report.Vectors = append(report.Vectors,
+		&vectorReport{Path: "passed.json", Status: vectorStatusPassed},
+		&vectorReport{Path: "timed-out.json", Status: vectorStatusTimedOut, Signature: failureSignature(&errVectorTimeout{}, nil, nil)},
+	)
+
+	clusters := report.Triage()
+	if len(clusters) != 6 {
+		t.Fatalf("expected 6 clusters, got %d", len(clusters))
+	}
+	if cl := clusters[0]; len(cl.Vectors) != 3 || !strings.HasPrefix(cl.Signature, "exit ") || !strings.HasSuffix(cl.Signature, ".5: Ok(0) -> ErrForbidden(18)") {
+		t.Errorf("unexpected largest cluster: %d vectors of %q", len(cl.Vectors), cl.Signature)
+	}
+	for _, sig := range []string{"subcall ", "gas ", "panic: boom", "failed to load state", "timed out"} {
+		var found bool
+		for _, cl := range clusters {
+			found = found || strings.HasPrefix(cl.Signature, sig) && !strings.Contains(cl.Signature, "\n")
+		}
+		if !found {
+			t.Errorf("no cluster with signature %q", sig)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeTriage(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "6 root causes:") {
+		t.Errorf("unexpected triage:\n%s", out.String())
+	}
+}
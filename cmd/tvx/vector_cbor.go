@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// The CBOR encoding of a vector is that of its JSON document: objects are
+// encoded as maps keyed by text strings, in sorted order, and integers that
+// don't fit 64 bits as bignums (tags 2 and 3). Vectors hold no fractional
+// numbers, which aren't supported.
+
+// maxCBORDepth is the maximum nesting depth of the CBOR vectors decoded.
+const maxCBORDepth = 64
+
+// jsonToCBOR returns the CBOR encoding of the JSON document.
+func jsonToCBOR(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeCBORValue(cbg.NewCborWriter(&buf), v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCBORValue(w *cbg.CborWriter, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		_, err := w.Write(cbg.CborNull)
+		return err
+	case bool:
+		return cbg.WriteBool(w, v)
+	case string:
+		if err := w.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(v))); err != nil {
+			return err
+		}
+		_, err := w.WriteString(v)
+		return err
+	case json.Number:
+		i, ok := new(big.Int).SetString(v.String(), 10)
+		if !ok {
+			return fmt.Errorf("unsupported number: %s", v)
+		}
+		maj, tag := byte(cbg.MajUnsignedInt), uint64(2)
+		if i.Sign() < 0 {
+			// negative integers are encoded as -1-n.
+			maj, tag = cbg.MajNegativeInt, 3
+			i.Neg(i).Sub(i, big.NewInt(1))
+		}
+		if i.IsUint64() {
+			return w.WriteMajorTypeHeader(maj, i.Uint64())
+		}
+		b := i.Bytes()
+		if err := w.WriteMajorTypeHeader(cbg.MajTag, tag); err != nil {
+			return err
+		}
+		if err := w.WriteMajorTypeHeader(cbg.MajByteString, uint64(len(b))); err != nil {
+			return err
+		}
+		_, err := w.Write(b)
+		return err
+	case []interface{}:
+		if err := w.WriteMajorTypeHeader(cbg.MajArray, uint64(len(v))); err != nil {
+			return err
+		}
+		for _, e := range v {
+			if err := writeCBORValue(w, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if err := w.WriteMajorTypeHeader(cbg.MajMap, uint64(len(v))); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := writeCBORValue(w, k); err != nil {
+				return err
+			}
+			if err := writeCBORValue(w, v[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported value of type %T", v)
+	}
+}
+
+// cborToJSON returns the JSON document of the CBOR encoding of a vector.
+func cborToJSON(data []byte) ([]byte, error) {
+	r := cbg.NewCborReader(bytes.NewReader(data))
+	v, err := readCBORValue(r, uint64(len(data)), 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode CBOR vector: %w", err)
+	}
+	return json.Marshal(v)
+}
+
+// readCBORValue reads a value of the JSON data model; limit bounds the
+// lengths of strings and collections, which can't exceed the input size.
+func readCBORValue(r *cbg.CborReader, limit uint64, depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("maximum nesting depth exceeded")
+	}
+	maj, extra, err := r.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+	switch maj {
+	case cbg.MajUnsignedInt:
+		return json.Number(strconv.FormatUint(extra, 10)), nil
+	case cbg.MajNegativeInt:
+		n := new(big.Int).SetUint64(extra)
+		return json.Number(n.Add(n, big.NewInt(1)).Neg(n).String()), nil
+	case cbg.MajTextString:
+		b, err := readCBORBytes(r, extra, limit)
+		return string(b), err
+	case cbg.MajTag:
+		if extra != 2 && extra != 3 {
+			return nil, fmt.Errorf("unsupported tag %d", extra)
+		}
+		maj, l, err := r.ReadHeader()
+		if err != nil {
+			return nil, err
+		}
+		if maj != cbg.MajByteString {
+			return nil, fmt.Errorf("expected a byte string in bignum; got major type %d", maj)
+		}
+		b, err := readCBORBytes(r, l, limit)
+		if err != nil {
+			return nil, err
+		}
+		n := new(big.Int).SetBytes(b)
+		if extra == 3 {
+			n.Add(n, big.NewInt(1)).Neg(n)
+		}
+		return json.Number(n.String()), nil
+	case cbg.MajArray:
+		if extra > limit {
+			return nil, fmt.Errorf("array length %d exceeds input", extra)
+		}
+		a := make([]interface{}, 0, extra)
+		for i := uint64(0); i < extra; i++ {
+			e, err := readCBORValue(r, limit, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, e)
+		}
+		return a, nil
+	case cbg.MajMap:
+		if extra > limit {
+			return nil, fmt.Errorf("map length %d exceeds input", extra)
+		}
+		m := make(map[string]interface{}, extra)
+		for i := uint64(0); i < extra; i++ {
+			k, err := readCBORValue(r, limit, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key is not a string: %v", k)
+			}
+			if m[ks], err = readCBORValue(r, limit, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case cbg.MajOther:
+		switch extra {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unsupported simple value or float %d", extra)
+	default:
+		return nil, fmt.Errorf("unsupported major type %d", maj)
+	}
+}
+
+func readCBORBytes(r io.Reader, l, limit uint64) ([]byte, error) {
+	if l > limit {
+		return nil, fmt.Errorf("string length %d exceeds input", l)
+	}
+	b := make([]byte, l)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
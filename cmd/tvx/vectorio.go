@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// Vector files are JSON or CBOR (see jsonToCBOR) documents, optionally
+// gzip-compressed. Vector archives are tar archives, optionally
+// gzip-compressed, and zip archives of vector files and archives.
+var (
+	vectorSuffixes  = []string{".json", ".json.gz", ".cbor", ".cbor.gz"}
+	archiveSuffixes = []string{".tar", ".tar.gz", ".tgz", ".zip"}
+)
+
+func hasAnySuffix(name string, suffixes []string) bool {
+	for _, s := range suffixes {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isVectorFile(name string) bool { return hasAnySuffix(name, vectorSuffixes) }
+
+func isVectorArchive(name string) bool { return hasAnySuffix(name, archiveSuffixes) }
+
+// vectorBaseName returns the base name of the vector file, sans suffixes.
+func vectorBaseName(name string) string {
+	base := path.Base(filepath.ToSlash(name))
+	for _, s := range vectorSuffixes {
+		if strings.HasSuffix(base, s) {
+			return strings.TrimSuffix(base, s)
+		}
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// vectorJSON returns the JSON document of the content of a vector file,
+// which is sniffed rather than inferred from the file name: gzip-compressed
+// or not, then JSON or CBOR.
+func vectorJSON(data []byte) ([]byte, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("failed to decompress vector: %w", err)
+		}
+	}
+	if trimmed := bytes.TrimLeft(data, " \t\r\n"); len(trimmed) > 0 && trimmed[0] != '{' && trimmed[0] != '[' {
+		return cborToJSON(data)
+	}
+	return data, nil
+}
+
+// encodeVectorFile encodes the JSON document of a vector as the file name
+// calls for: as CBOR for .cbor files, gzip-compressed for .gz files.
+func encodeVectorFile(name string, data []byte) ([]byte, error) {
+	var err error
+	if strings.HasSuffix(name, ".cbor") || strings.HasSuffix(name, ".cbor.gz") {
+		if data, err = jsonToCBOR(data); err != nil {
+			return nil, err
+		}
+	}
+	if strings.HasSuffix(name, ".gz") {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+	return data, nil
+}
+
+// readVector reads the test vector in the vector file.
+func readVector(path string) (*schema.TestVector, error) {
+	if isVectorArchive(path) {
+		return nil, fmt.Errorf("%s is a vector archive; a single vector file is expected", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open test vector: %w", err)
+	}
+	content, err := vectorJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode test vector %s: %w", path, err)
+	}
+	var tv schema.TestVector
+	if err = json.Unmarshal(content, &tv); err != nil {
+		return nil, fmt.Errorf("failed to decode test vector %s: %w", path, err)
+	}
+	return &tv, nil
+}
+
+// walkVectorFiles calls fn with the JSON document of every vector file in
+// root: the vector file itself, or those in the directory or archive,
+// searched recursively, archives within archives included. Vectors in
+// archives are named after the path of the archive joined with their path in
+// the archive. Files that can't be decoded are skipped, unless root is one.
+func walkVectorFiles(root string, fn func(path string, content []byte) error) error {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		if isVectorArchive(root) {
+			return walkArchiveFile(root, fn)
+		}
+		data, err := os.ReadFile(root)
+		if err != nil {
+			return fmt.Errorf("failed to read test vector %s: %w", root, err)
+		}
+		content, err := vectorJSON(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode test vector %s: %w", root, err)
+		}
+		return fn(root, content)
+	}
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed while visiting path %s: %w", path, err)
+		}
+		switch {
+		case d.IsDir():
+			return nil
+		case isVectorArchive(path):
+			return walkArchiveFile(path, fn)
+		case isVectorFile(path):
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read test vector %s: %w", path, err)
+			}
+			return visitVectorFile(path, data, fn)
+		}
+		return nil
+	})
+}
+
+func visitVectorFile(path string, data []byte, fn func(path string, content []byte) error) error {
+	content, err := vectorJSON(data)
+	if err != nil {
+		log.Printf("failed to decode test vector %s: %s; skipping", path, err)
+		return nil
+	}
+	return fn(path, content)
+}
+
+func walkArchiveFile(name string, fn func(path string, content []byte) error) error {
+	if strings.HasSuffix(name, ".zip") {
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return fmt.Errorf("failed to open vector archive %s: %w", name, err)
+		}
+		defer zr.Close() //nolint:errcheck
+		return walkZip(name, &zr.Reader, fn)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open vector archive %s: %w", name, err)
+	}
+	defer f.Close() //nolint:errcheck
+	return walkArchive(name, f, fn)
+}
+
+// walkArchive walks the vector files in the archive read from r.
+func walkArchive(name string, r io.Reader, fn func(path string, content []byte) error) error {
+	if strings.HasSuffix(name, ".zip") {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read vector archive %s: %w", name, err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return fmt.Errorf("failed to open vector archive %s: %w", name, err)
+		}
+		return walkZip(name, zr, fn)
+	}
+
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to decompress vector archive %s: %w", name, err)
+		}
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read vector archive %s: %w", name, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := visitArchiveMember(path.Join(name, hdr.Name), tr, fn); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(name string, zr *zip.Reader, fn func(path string, content []byte) error) error {
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to read %s in vector archive %s: %w", f.Name, name, err)
+		}
+		err = visitArchiveMember(path.Join(name, f.Name), rc, fn)
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func visitArchiveMember(name string, r io.Reader, fn func(path string, content []byte) error) error {
+	switch {
+	case isVectorArchive(name):
+		return walkArchive(name, r, fn)
+	case isVectorFile(name):
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read test vector %s: %w", name, err)
+		}
+		return visitVectorFile(name, data, fn)
+	}
+	return nil
+}
+
+// inVectorArchive returns whether the path walked by walkVectorFiles names a
+// vector in an archive, rather than a file.
+func inVectorArchive(p string) bool {
+	for dir := filepath.Dir(p); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if !isVectorArchive(dir) {
+			continue
+		}
+		if fi, err := os.Stat(dir); err == nil && !fi.IsDir() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+// stm: #unit
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestCBORRoundTrip(t *testing.T) {
+	in := []byte(`{"class":"message","epoch":-3,"big":340282366920938463463374607431768211456,"neg":-340282366920938463463374607431768211456,"nil":null,"ok":true,"list":[1,"a",{}]}`)
+	enc, err := jsonToCBOR(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := vectorJSON(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want, got interface{}
+	if err := json.Unmarshal(in, &want); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	wb, _ := json.Marshal(want)
+	gb, _ := json.Marshal(got)
+	if !bytes.Equal(wb, gb) {
+		t.Errorf("round trip mismatch:\n%s\n%s", wb, gb)
+	}
+	if !bytes.Contains(out, []byte("340282366920938463463374607431768211456")) {
+		t.Errorf("big integer not preserved: %s", out)
+	}
+}
+
+func TestWalkVectorFiles(t *testing.T) {
+	dir := t.TempDir()
+	vector := []byte(`{"class":"message"}`)
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustEncode := func(name string) []byte {
+		b, err := encodeVectorFile(name, vector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	write("a.json", vector)
+	write("b.json.gz", mustEncode("b.json.gz"))
+	write("c.cbor", mustEncode("c.cbor"))
+	write("notes.txt", []byte("not a vector"))
+
+	// a zip archive, within a gzipped tar archive.
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	f, _ := zw.Create("nested/d.cbor.gz")
+	_, _ = f.Write(mustEncode("d.cbor.gz"))
+	_ = zw.Close()
+
+	var tbuf bytes.Buffer
+	gw := gzip.NewWriter(&tbuf)
+	tw := tar.NewWriter(gw)
+	for name, data := range map[string][]byte{"e.json": vector, "inner.zip": zbuf.Bytes()} {
+		_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		_, _ = tw.Write(data)
+	}
+	_ = tw.Close()
+	_ = gw.Close()
+	write("corpus.tar.gz", tbuf.Bytes())
+
+	var paths []string
+	err := walkVectorFiles(dir, func(path string, content []byte) error {
+		if !bytes.Equal(content, vector) {
+			t.Errorf("unexpected content of %s: %s", path, content)
+		}
+		rel, _ := filepath.Rel(dir, path)
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	want := []string{"a.json", "b.json.gz", "c.cbor", "corpus.tar.gz/e.json", "corpus.tar.gz/inner.zip/nested/d.cbor.gz"}
+	if len(paths) != len(want) {
+		t.Fatalf("walked %v, expected %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("walked %v, expected %v", paths, want)
+			break
+		}
+	}
+
+	if !inVectorArchive(filepath.Join(dir, "corpus.tar.gz", "e.json")) || inVectorArchive(filepath.Join(dir, "a.json")) {
+		t.Error("archive members misidentified")
+	}
+}
@@ -0,0 +1,82 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/state"
+)
+
+// DecodeMethodValue decodes the params, or the return value, of the method of
+// the actor code against the ABI of the actor, and returns them as JSON. It
+// returns false if the actor or method is unknown, or the value doesn't
+// decode.
+func DecodeMethodValue(code cid.Cid, method abi.MethodNum, b []byte, params bool) (json.RawMessage, bool) {
+	m, ok := filcns.NewActorRegistry().Methods[code][method]
+	if !ok {
+		return nil, false
+	}
+	typ := m.Ret
+	if params {
+		typ = m.Params
+	}
+	v, ok := reflect.New(typ.Elem()).Interface().(cbg.CBORUnmarshaler)
+	if !ok {
+		return nil, false
+	}
+	if err := v.UnmarshalCBOR(bytes.NewReader(b)); err != nil {
+		return nil, false
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	return j, true
+}
+
+// FormatMethodValue renders the params, or the return value, of the method
+// of the actor code as decoded by DecodeMethodValue, falling back to hex.
+func FormatMethodValue(code cid.Cid, method abi.MethodNum, b []byte, params bool) string {
+	if len(b) == 0 {
+		return "(empty)"
+	}
+	if j, ok := DecodeMethodValue(code, method, b, params); ok {
+		return string(j)
+	}
+	return "0x" + hex.EncodeToString(b)
+}
+
+// stateCodeResolver returns a function resolving the codes of actors in the
+// state tree at the root, loaded on first use; it resolves unknown actors to
+// cid.Undef.
+func stateCodeResolver(bs blockstore.Blockstore, root cid.Cid) func(address.Address) cid.Cid {
+	var (
+		st     *state.StateTree
+		loaded bool
+	)
+	return func(addr address.Address) cid.Cid {
+		if !loaded {
+			st, _ = state.LoadStateTree(cbornode.NewCborStore(bs), root)
+			loaded = true
+		}
+		if st == nil {
+			return cid.Undef
+		}
+		act, err := st.GetActor(addr)
+		if err != nil {
+			return cid.Undef
+		}
+		return act.Code
+	}
+}
@@ -0,0 +1,25 @@
+// stm: #unit
+package conformance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+)
+
+func TestFormatMethodValue(t *testing.T) {
+	// the return value of Account.PubkeyAddress: the address f0100.
+	ret := []byte{0x42, 0x00, 0x64}
+	if s := FormatMethodValue(builtin2.AccountActorCodeID, builtin2.MethodsAccount.PubkeyAddress, ret, false); !strings.Contains(s, "0100") || strings.HasPrefix(s, "0x") {
+		t.Errorf("expected the return value decoded as an address; got %s", s)
+	}
+	if s := FormatMethodValue(cid.Undef, 2, []byte{0xde, 0xad}, false); s != "0xdead" {
+		t.Errorf("expected the return value of an unknown actor in hex; got %s", s)
+	}
+	if s := FormatMethodValue(cid.Undef, 2, nil, false); s != "(empty)" {
+		t.Errorf("unexpected rendering of an empty return value: %s", s)
+	}
+}
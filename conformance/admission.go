@@ -0,0 +1,283 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/messagepool"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/journal"
+)
+
+// ClassMessageValidity is the class of vectors that exercise the admission
+// of messages into the message pool, as received from the network. The
+// preconditions hold the chain context: the state tree at the head the
+// messages are validated against, the basefee of the head, and the single
+// variant carries its epoch and network version. The messages of the vector
+// are signed messages, submitted in order to an initially empty pool, and
+// SelectorExpectedAdmissions carries their expected outcomes. The
+// postconditions hold the state tree untouched.
+const ClassMessageValidity schema.Class = "message-validity"
+
+// SelectorExpectedAdmissions, in message-validity vectors, carries the
+// comma-separated outcomes of the admission of their messages, in order:
+// AdmissionAccept, or the reason of the rejection, e.g. "reject:nonce-gap".
+const SelectorExpectedAdmissions = "expected_admissions"
+
+// AdmissionAccept is the outcome of the admission of messages accepted into
+// the message pool.
+const AdmissionAccept = "accept"
+
+// admissionRejections are the reasons of the rejections of messages by the
+// message pool, by the error they're rejected with. Errors are matched in
+// order, soft validation failures last, as they wrap others.
+var admissionRejections = []struct {
+	err    error
+	reason string
+}{
+	{messagepool.ErrMessageTooBig, "message-too-big"},
+	{messagepool.ErrMessageValueTooHigh, "value-too-high"},
+	{messagepool.ErrInvalidToAddr, "invalid-to-address"},
+	{messagepool.ErrGasFeeCapTooLow, "gas-fee-cap-too-low"},
+	{messagepool.ErrNonceTooLow, "nonce-too-low"},
+	{messagepool.ErrNonceGap, "nonce-gap"},
+	{messagepool.ErrExistingNonce, "existing-nonce"},
+	{messagepool.ErrRBFTooLowPremium, "rbf-too-low-premium"},
+	{messagepool.ErrTooManyPendingMessages, "too-many-pending-messages"},
+	{messagepool.ErrNotEnoughFunds, "not-enough-funds"},
+	{messagepool.ErrSoftValidationFailure, "soft-validation-failure"},
+}
+
+// AdmissionOutcome returns the outcome of the admission of a message
+// rejected with err, or accepted if nil. Rejections for other reasons than
+// the message pool policies, e.g. invalid signatures or gas limits, are
+// reported as "reject:invalid".
+func AdmissionOutcome(err error) string {
+	if err == nil {
+		return AdmissionAccept
+	}
+	for _, r := range admissionRejections {
+		if errors.Is(err, r.err) {
+			return "reject:" + r.reason
+		}
+	}
+	return "reject:invalid"
+}
+
+// ExecuteAdmissionParams are the parameters of AdmitMessages.
+type ExecuteAdmissionParams struct {
+	// Root is the state tree at the head the messages are validated against.
+	Root cid.Cid
+	// Epoch is the epoch of the head.
+	Epoch abi.ChainEpoch
+	// BaseFee is the basefee of the head.
+	BaseFee abi.TokenAmount
+	// NetworkVersion is the network version at the head.
+	NetworkVersion network.Version
+	// Messages are the messages to submit, in order.
+	Messages []*types.SignedMessage
+}
+
+// AdmitMessages submits the messages in order to a fresh message pool over
+// the state tree, as received from the network, and returns the outcomes of
+// their admissions; see AdmissionOutcome. The errors of the rejections are
+// returned along.
+func AdmitMessages(ctx context.Context, bs blockstore.Blockstore, params ExecuteAdmissionParams) ([]string, []error, error) {
+	cst := cbor.NewCborStore(bs)
+	st, err := state.LoadStateTree(cst, params.Root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load state tree: %w", err)
+	}
+	head, err := types.NewTipSet([]*types.BlockHeader{{
+		Miner:                 builtin.SystemActorAddr,
+		Ticket:                &types.Ticket{VRFProof: []byte("message-validity")},
+		Height:                params.Epoch,
+		ParentStateRoot:       params.Root,
+		ParentMessageReceipts: params.Root,
+		Messages:              params.Root,
+		ParentWeight:          types.NewInt(0),
+		ParentBaseFee:         params.BaseFee,
+	}})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create head: %w", err)
+	}
+
+	// the message pool prices messages for inclusion in the next epoch.
+	revertPricing := adjustGasPricing(params.Epoch+1, params.NetworkVersion)
+	defer revertPricing()
+
+	p := &admissionProvider{head: head, st: st, cst: cst, nv: params.NetworkVersion}
+	mp, err := messagepool.New(ctx, p, ds.NewMapDatastore(), upgradeScheduleFor(params.Epoch, params.NetworkVersion), "message-validity", journal.NilJournal())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create message pool: %w", err)
+	}
+	defer mp.Close() //nolint:errcheck
+
+	outcomes := make([]string, len(params.Messages))
+	errs := make([]error, len(params.Messages))
+	for i, m := range params.Messages {
+		errs[i] = mp.Add(ctx, m)
+		outcomes[i] = AdmissionOutcome(errs[i])
+	}
+	return outcomes, errs, nil
+}
+
+// admissionProvider is the provider of the message pool of AdmitMessages: a
+// chain frozen at the head, whose state tree is that of the vector.
+type admissionProvider struct {
+	head *types.TipSet
+	st   *state.StateTree
+	cst  cbor.IpldStore
+	nv   network.Version
+}
+
+var _ messagepool.Provider = (*admissionProvider)(nil)
+
+func (p *admissionProvider) SubscribeHeadChanges(func(rev, app []*types.TipSet) error) *types.TipSet {
+	return p.head
+}
+
+func (p *admissionProvider) PutMessage(_ context.Context, m types.ChainMsg) (cid.Cid, error) {
+	return m.Cid(), nil
+}
+
+func (p *admissionProvider) PubSubPublish(string, []byte) error { return nil }
+
+func (p *admissionProvider) GetActorAfter(addr address.Address, _ *types.TipSet) (*types.Actor, error) {
+	return p.st.GetActor(addr)
+}
+
+func (p *admissionProvider) StateAccountKeyAtFinality(_ context.Context, addr address.Address, _ *types.TipSet) (address.Address, error) {
+	return vm.ResolveToKeyAddr(p.st, p.cst, addr)
+}
+
+func (p *admissionProvider) StateNetworkVersion(context.Context, abi.ChainEpoch) network.Version {
+	return p.nv
+}
+
+func (p *admissionProvider) MessagesForBlock(context.Context, *types.BlockHeader) ([]*types.Message, []*types.SignedMessage, error) {
+	return nil, nil, nil
+}
+
+func (p *admissionProvider) MessagesForTipset(context.Context, *types.TipSet) ([]types.ChainMsg, error) {
+	return nil, nil
+}
+
+func (p *admissionProvider) LoadTipSet(_ context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	if tsk != p.head.Key() {
+		return nil, fmt.Errorf("tipset %s not found", tsk)
+	}
+	return p.head, nil
+}
+
+func (p *admissionProvider) ChainComputeBaseFee(context.Context, *types.TipSet) (types.BigInt, error) {
+	return p.head.Blocks()[0].ParentBaseFee, nil
+}
+
+func (p *admissionProvider) IsLite() bool { return false }
+
+// admissionParams returns the parameters of the admission of the messages of
+// the vector variant.
+func admissionParams(vector *schema.TestVector, variant *schema.Variant) (ExecuteAdmissionParams, error) {
+	params := ExecuteAdmissionParams{
+		Root:           vector.Pre.StateTree.RootCID,
+		Epoch:          abi.ChainEpoch(variant.Epoch),
+		BaseFee:        BaseFeeOrDefault(vector.Pre.BaseFee),
+		NetworkVersion: network.Version(variant.NetworkVersion),
+	}
+	for i, m := range vector.ApplyMessages {
+		smsg, err := types.DecodeSignedMessage(m.Bytes)
+		if err != nil {
+			return params, fmt.Errorf("failed to deserialize message %d as a signed message: %w", i, err)
+		}
+		params.Messages = append(params.Messages, smsg)
+	}
+	return params, nil
+}
+
+// ComputeMessageValidityOutcomes returns the outcomes of the admission of
+// the messages of the message-validity vector variant, as recorded in
+// SelectorExpectedAdmissions. Of the options, only those of the execution
+// apply.
+func ComputeMessageValidityOutcomes(vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) ([]string, error) {
+	ctx := context.Background()
+
+	restoreNetwork, err := useVectorNetwork(ctx, vector, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer restoreNetwork()
+
+	bs, _, err := loadBlockstore(vector.CAR, opts.ReuseBlockstores)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the vector CAR: %w", err)
+	}
+	params, err := admissionParams(vector, variant)
+	if err != nil {
+		return nil, err
+	}
+	outcomes, _, err := AdmitMessages(ctx, bs, params)
+	return outcomes, err
+}
+
+// ExecuteMessageValidityVector executes a message-validity test vector, with
+// the supplied options; those of the driver don't apply, as no message is
+// applied.
+func ExecuteMessageValidityVector(ctx context.Context, r Reporter, vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) (diffs []string, err error) {
+	// Validate the network and requirements of the vector, and format
+	// addresses as it does.
+	restoreNetwork, err := useVectorNetwork(ctx, vector, opts)
+	if err != nil {
+		r.Fatalf("%s", err)
+		return nil, err
+	}
+	defer restoreNetwork()
+
+	// Load the CAR into a new temporary Blockstore.
+	bs, _, err := loadBlockstore(vector.CAR, opts.ReuseBlockstores)
+	if err != nil {
+		r.Fatalf("failed to load the vector CAR: %w", err)
+		return nil, err
+	}
+
+	params, err := admissionParams(vector, variant)
+	if err != nil {
+		r.Fatalf("%s", err)
+		return nil, err
+	}
+	expected := strings.Split(vector.Selector[SelectorExpectedAdmissions], ",")
+	if len(expected) != len(params.Messages) {
+		err := fmt.Errorf("expected %d admission outcomes, got %d", len(params.Messages), len(expected))
+		r.Fatalf("%s", err)
+		return nil, err
+	}
+
+	outcomes, errs, err := AdmitMessages(ctx, bs, params)
+	if err != nil {
+		r.Fatalf("failed to submit the messages: %s", err)
+		return nil, err
+	}
+	for i, outcome := range outcomes {
+		if outcome != expected[i] {
+			r.Errorf("message %d: admission outcome: expected %s, got %s (%v)", i, expected[i], outcome, errs[i])
+		} else {
+			r.Logf("message %d: admission outcome %s", i, outcome)
+		}
+	}
+	return nil, nil
+}
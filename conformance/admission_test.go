@@ -0,0 +1,28 @@
+// stm: #unit
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/filecoin-project/lotus/chain/messagepool"
+)
+
+func TestAdmissionOutcome(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, AdmissionAccept},
+		{fmt.Errorf("minimum expected nonce is 3: %w", messagepool.ErrNonceTooLow), "reject:nonce-too-low"},
+		{fmt.Errorf("not enough funds: %w", messagepool.ErrNotEnoughFunds), "reject:not-enough-funds"},
+		{fmt.Errorf("basefee lower bound: %w", messagepool.ErrSoftValidationFailure), "reject:soft-validation-failure"},
+		{messagepool.ErrGasFeeCapTooLow, "reject:gas-fee-cap-too-low"},
+		{errors.New("invalid signature"), "reject:invalid"},
+	} {
+		if got := AdmissionOutcome(tc.err); got != tc.want {
+			t.Errorf("AdmissionOutcome(%v) = %s; want %s", tc.err, got, tc.want)
+		}
+	}
+}
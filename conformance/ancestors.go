@@ -0,0 +1,166 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// SelectorAncestorHeaders, if it appears in a vector, it indicates that the
+// vector carries the ancestor tipsets actors looked up while it executed,
+// e.g. through the tipset CID syscall the EVM's BLOCKHASH relies on. Its
+// value is the CID of the AncestorTipSets, which are stored as a raw block in
+// the vector's CAR, along with the block headers of the tipsets. The driver
+// serves the tipset lookups of the VM from them; lookups of other epochs
+// return the empty tipset key, as in vectors without the selector.
+const SelectorAncestorHeaders = "ancestor_headers"
+
+// AncestorTipSet is a tipset looked up at an epoch, by the CIDs of its block
+// headers. At a null round, it's the tipset preceding it.
+type AncestorTipSet struct {
+	Epoch  abi.ChainEpoch `json:"epoch"`
+	Blocks []cid.Cid      `json:"blocks"`
+}
+
+// AncestorTipSets are the tipsets looked up during the execution of a
+// vector, in ascending order of epoch.
+type AncestorTipSets struct {
+	TipSets []AncestorTipSet `json:"tipsets"`
+}
+
+// Store serializes the tipsets into a raw block in the supplied blockstore,
+// and returns its CID.
+func (a *AncestorTipSets) Store(ctx context.Context, bs blockstore.Blockstore) (cid.Cid, error) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to serialize ancestor tipsets: %w", err)
+	}
+
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_256}.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := bs.Put(ctx, blk); err != nil {
+		return cid.Undef, fmt.Errorf("failed to store ancestor tipsets: %w", err)
+	}
+	return c, nil
+}
+
+// LoadAncestorTipSets loads the ancestor tipsets stored under the supplied
+// CID.
+func LoadAncestorTipSets(ctx context.Context, bs blockstore.Blockstore, c cid.Cid) (*AncestorTipSets, error) {
+	blk, err := bs.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ancestor tipsets %s: %w", c, err)
+	}
+	a := new(AncestorTipSets)
+	if err := json.Unmarshal(blk.RawData(), a); err != nil {
+		return nil, fmt.Errorf("failed to deserialize ancestor tipsets %s: %w", c, err)
+	}
+	return a, nil
+}
+
+// TipSetGetter returns a vm.TipSetGetter serving the tipsets, once it has
+// verified that the block headers of every tipset are in the blockstore, at
+// heights no greater than its epoch.
+func (a *AncestorTipSets) TipSetGetter(ctx context.Context, bs blockstore.Blockstore) (vm.TipSetGetter, error) {
+	keys := make(map[abi.ChainEpoch]types.TipSetKey, len(a.TipSets))
+	for _, ts := range a.TipSets {
+		for _, c := range ts.Blocks {
+			blk, err := bs.Get(ctx, c)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load block header %s of the tipset at epoch %d: %w", c, ts.Epoch, err)
+			}
+			h, err := types.DecodeBlock(blk.RawData())
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode block header %s: %w", c, err)
+			}
+			if h.Height > ts.Epoch {
+				return nil, fmt.Errorf("block header %s is at height %d, after the epoch %d it was looked up at", c, h.Height, ts.Epoch)
+			}
+		}
+		keys[ts.Epoch] = types.NewTipSetKey(ts.Blocks...)
+	}
+	return func(_ context.Context, epoch abi.ChainEpoch) (types.TipSetKey, error) {
+		if k, ok := keys[epoch]; ok {
+			return k, nil
+		}
+		return types.EmptyTSK, nil
+	}, nil
+}
+
+// TipSetRecorder wraps a vm.TipSetGetter, recording the tipsets it returns,
+// so that extraction embeds them in vectors.
+type TipSetRecorder struct {
+	getter vm.TipSetGetter
+
+	lk       sync.Mutex
+	recorded map[abi.ChainEpoch]types.TipSetKey
+}
+
+// NewTipSetRecorder returns a recorder of the tipsets the getter returns.
+func NewTipSetRecorder(getter vm.TipSetGetter) *TipSetRecorder {
+	return &TipSetRecorder{getter: getter, recorded: make(map[abi.ChainEpoch]types.TipSetKey)}
+}
+
+// GetTipSet is a vm.TipSetGetter.
+func (r *TipSetRecorder) GetTipSet(ctx context.Context, epoch abi.ChainEpoch) (types.TipSetKey, error) {
+	k, err := r.getter(ctx, epoch)
+	if err != nil {
+		return k, err
+	}
+	r.lk.Lock()
+	r.recorded[epoch] = k
+	r.lk.Unlock()
+	return k, nil
+}
+
+// Recorded returns the tipsets recorded so far.
+func (r *TipSetRecorder) Recorded() *AncestorTipSets {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	a := new(AncestorTipSets)
+	for epoch, k := range r.recorded {
+		a.TipSets = append(a.TipSets, AncestorTipSet{Epoch: epoch, Blocks: k.Cids()})
+	}
+	sort.Slice(a.TipSets, func(i, j int) bool { return a.TipSets[i].Epoch < a.TipSets[j].Epoch })
+	return a
+}
+
+// tipSetGetter returns the vm.TipSetGetter serving the ancestor tipsets of
+// the vector, if it carries SelectorAncestorHeaders, or one returning the
+// empty tipset key otherwise.
+func (d *Driver) tipSetGetter(bs blockstore.Blockstore) (vm.TipSetGetter, error) {
+	s, ok := d.selector[SelectorAncestorHeaders]
+	if !ok {
+		return func(context.Context, abi.ChainEpoch) (types.TipSetKey, error) {
+			return types.EmptyTSK, nil
+		}, nil
+	}
+	c, err := cid.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s selector: %w", SelectorAncestorHeaders, err)
+	}
+	a, err := LoadAncestorTipSets(d.ctx, bs, c)
+	if err != nil {
+		return nil, err
+	}
+	return a.TipSetGetter(d.ctx, bs)
+}
@@ -0,0 +1,83 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestAncestorTipSets(t *testing.T) {
+	ctx := context.Background()
+	dummy, err := abi.CidBuilder.Sum([]byte("dummy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	miner, _ := address.NewIDAddress(1000)
+	h := &types.BlockHeader{
+		Miner:                 miner,
+		Ticket:                &types.Ticket{VRFProof: []byte("ticket")},
+		ElectionProof:         &types.ElectionProof{},
+		ParentWeight:          types.NewInt(0),
+		Height:                100,
+		ParentStateRoot:       dummy,
+		ParentMessageReceipts: dummy,
+		Messages:              dummy,
+		ParentBaseFee:         types.NewInt(0),
+	}
+	blk, err := h.ToStorageBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemory()
+	if err := bs.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	// record the lookups of the tipset at 100, and at the null round 101.
+	key := types.NewTipSetKey(blk.Cid())
+	rec := NewTipSetRecorder(func(context.Context, abi.ChainEpoch) (types.TipSetKey, error) { return key, nil })
+	for _, epoch := range []abi.ChainEpoch{101, 100, 101} {
+		if _, err := rec.GetTipSet(ctx, epoch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorded := rec.Recorded()
+	if len(recorded.TipSets) != 2 || recorded.TipSets[0].Epoch != 100 || recorded.TipSets[1].Epoch != 101 {
+		t.Fatalf("unexpected recorded tipsets: %v", recorded.TipSets)
+	}
+	c, err := recorded.Store(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the driver serves the recorded tipsets, and the empty key otherwise.
+	d := NewDriver(ctx, schema.Selector{SelectorAncestorHeaders: c.String()}, DriverOpts{})
+	getter, err := d.tipSetGetter(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for epoch, expected := range map[abi.ChainEpoch]types.TipSetKey{100: key, 101: key, 99: types.EmptyTSK} {
+		if actual, err := getter(ctx, epoch); err != nil || actual != expected {
+			t.Errorf("unexpected tipset at epoch %d: %s (err: %v)", epoch, actual, err)
+		}
+	}
+
+	// the headers must be in the vector, at heights up to their epochs.
+	early := &AncestorTipSets{TipSets: []AncestorTipSet{{Epoch: 99, Blocks: []cid.Cid{blk.Cid()}}}}
+	if _, err := early.TipSetGetter(ctx, bs); err == nil {
+		t.Error("expected error for a header after its epoch")
+	}
+	missing := &AncestorTipSets{TipSets: []AncestorTipSet{{Epoch: 100, Blocks: []cid.Cid{dummy}}}}
+	if _, err := missing.TipSetGetter(ctx, bs); err == nil {
+		t.Error("expected error for a missing header")
+	}
+}
@@ -0,0 +1,167 @@
+package conformance
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// Known-broken vectors are annotated so that runners keep them from failing
+// regression runs while fixes are pending, and report them separately. Vectors
+// carry their own annotations as selectors, and runners may supply more in a
+// suppressions file, without editing the vectors; see Suppressions.
+const (
+	// SelectorSkip, if it appears in a vector, has runners skip it; its value
+	// is the reason, e.g. "tracked in #1234".
+	SelectorSkip = "skip"
+	// SelectorExpected, if it appears in a vector with value ExpectedFail, or
+	// "fail: <reason>", has runners expect it to fail: its failures don't fail
+	// the run, but its passing does, for the annotation to be removed.
+	SelectorExpected = "expected"
+
+	ExpectedFail = "fail"
+)
+
+// The kinds of annotations.
+const (
+	AnnotationSkip         = "skip"
+	AnnotationExpectedFail = "fail"
+)
+
+// Annotation marks a vector as known to be broken.
+type Annotation struct {
+	// Kind is AnnotationSkip or AnnotationExpectedFail.
+	Kind string
+	// Reason is why the vector is broken, if stated.
+	Reason string
+}
+
+func (a *Annotation) String() string {
+	kind := "skipped"
+	if a.Kind == AnnotationExpectedFail {
+		kind = "expected to fail"
+	}
+	if a.Reason == "" {
+		return kind
+	}
+	return fmt.Sprintf("%s: %s", kind, a.Reason)
+}
+
+// VectorAnnotation returns the annotation the vector carries through
+// SelectorSkip or SelectorExpected, if any. A skip prevails over an expected
+// failure.
+func VectorAnnotation(vector *schema.TestVector) *Annotation {
+	if reason, ok := vector.Selector[SelectorSkip]; ok {
+		return &Annotation{Kind: AnnotationSkip, Reason: reason}
+	}
+	expected, ok := vector.Selector[SelectorExpected]
+	if !ok {
+		return nil
+	}
+	kind, reason, _ := strings.Cut(expected, ":")
+	if strings.TrimSpace(kind) != ExpectedFail {
+		return nil
+	}
+	return &Annotation{Kind: AnnotationExpectedFail, Reason: strings.TrimSpace(reason)}
+}
+
+// Suppressions annotate the vectors of a corpus from outside of it. They're
+// read from a file of lines in the form
+//
+//	<pattern> skip|fail [reason]
+//
+// where the pattern is matched against the IDs of vectors, and their paths,
+// as path.Match does, e.g.
+//
+//	# pending the fix of #1234
+//	fil_8_storageminer-PreCommitSector-* fail #1234
+//	extracted/0004-coverage-storageminer/*.json skip too slow
+//
+// Blank lines and lines starting with # are ignored.
+type Suppressions struct {
+	entries []suppression
+}
+
+type suppression struct {
+	pattern string
+	Annotation
+}
+
+// LoadSuppressions reads the suppressions file at the path.
+func LoadSuppressions(file string) (*Suppressions, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open suppressions file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+	s, err := ParseSuppressions(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid suppressions file %s: %w", file, err)
+	}
+	return s, nil
+}
+
+// ParseSuppressions parses suppressions; see Suppressions for their format.
+func ParseSuppressions(r io.Reader) (*Suppressions, error) {
+	s := new(Suppressions)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected <pattern> skip|fail [reason]", n)
+		}
+		if _, err := path.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q: %w", n, fields[0], err)
+		}
+		var kind string
+		switch fields[1] {
+		case AnnotationSkip, AnnotationExpectedFail:
+			kind = fields[1]
+		default:
+			return nil, fmt.Errorf("line %d: unknown annotation %q; values: %s, %s", n, fields[1], AnnotationSkip, AnnotationExpectedFail)
+		}
+		s.entries = append(s.entries, suppression{
+			pattern:    fields[0],
+			Annotation: Annotation{Kind: kind, Reason: strings.Join(fields[2:], " ")},
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Annotation returns the annotation of the vector at the path: that of the
+// first suppression matching its ID or path, if any, or else its own; see
+// VectorAnnotation. It returns nil for vectors that aren't annotated. The
+// suppressions may be nil.
+func (s *Suppressions) Annotation(p string, vector *schema.TestVector) *Annotation {
+	var id string
+	if vector.Meta != nil {
+		id = vector.Meta.ID
+	}
+	p = filepath.ToSlash(p)
+	if s != nil {
+		for _, e := range s.entries {
+			if ok, _ := path.Match(e.pattern, id); ok && id != "" {
+				a := e.Annotation
+				return &a
+			}
+			if ok, _ := path.Match(e.pattern, p); ok && p != "" {
+				a := e.Annotation
+				return &a
+			}
+		}
+	}
+	return VectorAnnotation(vector)
+}
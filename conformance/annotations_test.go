@@ -0,0 +1,57 @@
+// stm: #unit
+package conformance
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestAnnotations(t *testing.T) {
+	s, err := ParseSuppressions(strings.NewReader(`
+# pending the fix of #1234
+fil_8_storageminer-* fail #1234
+slow/*.json skip too slow
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vector := func(id string, sel schema.Selector) *schema.TestVector {
+		return &schema.TestVector{Meta: &schema.Metadata{ID: id}, Selector: sel}
+	}
+	for _, c := range []struct {
+		path   string
+		vector *schema.TestVector
+		kind   string
+		reason string
+	}{
+		{"a.json", vector("fil_8_storageminer-PreCommitSector-ok", nil), AnnotationExpectedFail, "#1234"},
+		{"slow/b.json", vector("fil_8_init-Exec-ok", nil), AnnotationSkip, "too slow"},
+		{"c.json", vector("fil_8_init-Exec-ok", schema.Selector{SelectorExpected: "fail: flaky gas"}), AnnotationExpectedFail, "flaky gas"},
+		{"d.json", vector("fil_8_init-Exec-ok", schema.Selector{SelectorSkip: "broken", SelectorExpected: ExpectedFail}), AnnotationSkip, "broken"},
+		// the suppressions take precedence over the annotations of vectors.
+		{"slow/e.json", vector("fil_8_init-Exec-ok", schema.Selector{SelectorExpected: ExpectedFail}), AnnotationSkip, "too slow"},
+		{"f.json", vector("fil_8_init-Exec-ok", schema.Selector{SelectorExpected: "pass"}), "", ""},
+	} {
+		a := s.Annotation(c.path, c.vector)
+		switch {
+		case a == nil && c.kind != "":
+			t.Errorf("%s: expected annotation %s", c.path, c.kind)
+		case a != nil && (a.Kind != c.kind || a.Reason != c.reason):
+			t.Errorf("%s: expected annotation %s (%q); got %s (%q)", c.path, c.kind, c.reason, a.Kind, a.Reason)
+		}
+	}
+
+	var none *Suppressions
+	if a := none.Annotation("g.json", vector("", schema.Selector{SelectorSkip: ""})); a == nil || a.Kind != AnnotationSkip {
+		t.Errorf("expected the annotations of vectors to apply without suppressions; got %v", a)
+	}
+
+	for _, invalid := range []string{"pattern-only", "* ignore reason", "[ skip reason"} {
+		if _, err := ParseSuppressions(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/rand"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// SelectorBeaconEntries, if it appears in a vector, it indicates that the
+// vector carries the drand beacon entries of the epochs it spans. Its value
+// is the CID of the BeaconEntries, which are stored as a raw block in the
+// vector's CAR. The driver derives the beacon randomness of those epochs
+// from them, so that the behaviour that depends on it replays exactly, even
+// when the recorded randomness holds no match for a request.
+const SelectorBeaconEntries = "beacon_entries"
+
+// BeaconEpochEntry is the beacon entry beacon randomness is drawn from at an
+// epoch.
+type BeaconEpochEntry struct {
+	Epoch abi.ChainEpoch `json:"epoch"`
+	Round uint64         `json:"round"`
+	Data  []byte         `json:"data"`
+}
+
+// BeaconEntries are the beacon entries of the epochs of a vector, in
+// ascending order of epoch.
+type BeaconEntries struct {
+	Entries []BeaconEpochEntry `json:"entries"`
+}
+
+// Store serializes the entries into a raw block in the supplied blockstore,
+// and returns its CID.
+func (e *BeaconEntries) Store(ctx context.Context, bs blockstore.Blockstore) (cid.Cid, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to serialize beacon entries: %w", err)
+	}
+
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_256}.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := bs.Put(ctx, blk); err != nil {
+		return cid.Undef, fmt.Errorf("failed to store beacon entries: %w", err)
+	}
+	return c, nil
+}
+
+// LoadBeaconEntries loads the beacon entries stored under the supplied CID.
+func LoadBeaconEntries(ctx context.Context, bs blockstore.Blockstore, c cid.Cid) (*BeaconEntries, error) {
+	blk, err := bs.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load beacon entries %s: %w", c, err)
+	}
+	e := new(BeaconEntries)
+	if err := json.Unmarshal(blk.RawData(), e); err != nil {
+		return nil, fmt.Errorf("failed to deserialize beacon entries %s: %w", c, err)
+	}
+	return e, nil
+}
+
+// loadVectorBeaconEntries loads the beacon entries of the vector, if it
+// carries SelectorBeaconEntries; it returns nil otherwise.
+func loadVectorBeaconEntries(ctx context.Context, bs blockstore.Blockstore, vector *schema.TestVector) (*BeaconEntries, error) {
+	s, ok := vector.Selector[SelectorBeaconEntries]
+	if !ok {
+		return nil, nil
+	}
+	c, err := cid.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s selector: %w", SelectorBeaconEntries, err)
+	}
+	return LoadBeaconEntries(ctx, bs, c)
+}
+
+// BeaconRand derives beacon randomness from the beacon entries of a vector,
+// falling back to another vm.Rand for chain randomness, and for epochs
+// without entries.
+type BeaconRand struct {
+	beacon   map[abi.ChainEpoch][]byte
+	fallback vm.Rand
+}
+
+var _ vm.Rand = (*BeaconRand)(nil)
+
+// NewBeaconRand indexes the beacon entries by epoch.
+func NewBeaconRand(entries *BeaconEntries, fallback vm.Rand) *BeaconRand {
+	r := &BeaconRand{
+		beacon:   make(map[abi.ChainEpoch][]byte, len(entries.Entries)),
+		fallback: fallback,
+	}
+	for _, e := range entries.Entries {
+		r.beacon[e.Epoch] = e.Data
+	}
+	return r
+}
+
+func (r *BeaconRand) GetChainRandomness(ctx context.Context, pers crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) ([]byte, error) {
+	return r.fallback.GetChainRandomness(ctx, pers, round, entropy)
+}
+
+func (r *BeaconRand) GetBeaconRandomness(ctx context.Context, pers crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) ([]byte, error) {
+	if base, ok := r.beacon[round]; ok {
+		return rand.DrawRandomness(base, pers, round, entropy)
+	}
+	return r.fallback.GetBeaconRandomness(ctx, pers, round, entropy)
+}
@@ -0,0 +1,68 @@
+// stm: #unit
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/rand"
+)
+
+func TestBeaconEntries(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	entries := &BeaconEntries{Entries: []BeaconEpochEntry{
+		{Epoch: 100, Round: 7, Data: []byte("beacon7")},
+		{Epoch: 101, Round: 8, Data: []byte("beacon8")},
+	}}
+	c, err := entries.Store(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the recorded randomness takes precedence over the beacon entries.
+	entropy := []byte("entropy")
+	recorded := []byte("recorded")
+	vector := &schema.TestVector{
+		Selector: schema.Selector{SelectorBeaconEntries: c.String()},
+		Randomness: schema.Randomness{{
+			On:     schema.RandomnessRule{Kind: schema.RandomnessBeacon, DomainSeparationTag: int64(crypto.DomainSeparationTag_SealRandomness), Epoch: 101, Entropy: entropy},
+			Return: recorded,
+		}},
+	}
+	r, err := newVectorRand(new(LogReporter), bs, vector)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := rand.DrawRandomness([]byte("beacon7"), crypto.DomainSeparationTag_SealRandomness, 100, entropy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual, _ := r.GetBeaconRandomness(ctx, crypto.DomainSeparationTag_SealRandomness, 100, entropy); !bytes.Equal(expected, actual) {
+		t.Error("expected randomness drawn from the beacon entry of epoch 100")
+	}
+	if actual, _ := r.GetBeaconRandomness(ctx, crypto.DomainSeparationTag_SealRandomness, 101, entropy); !bytes.Equal(recorded, actual) {
+		t.Error("expected the recorded randomness for epoch 101")
+	}
+
+	// epochs without entries, and chain randomness, fall back.
+	fixed, _ := NewFixedRand().GetBeaconRandomness(ctx, crypto.DomainSeparationTag_SealRandomness, 102, entropy)
+	if actual, _ := r.GetBeaconRandomness(ctx, crypto.DomainSeparationTag_SealRandomness, 102, entropy); !bytes.Equal(fixed, actual) {
+		t.Error("expected fallback randomness for epoch without entry")
+	}
+	fixed, _ = NewFixedRand().GetChainRandomness(ctx, crypto.DomainSeparationTag_SealRandomness, 100, entropy)
+	if actual, _ := r.GetChainRandomness(ctx, crypto.DomainSeparationTag_SealRandomness, 100, entropy); !bytes.Equal(fixed, actual) {
+		t.Error("expected fallback chain randomness")
+	}
+
+	vector.Selector[SelectorBeaconEntries] = "invalid"
+	if _, err := newVectorRand(new(LogReporter), bs, vector); err == nil {
+		t.Error("expected error for invalid selector")
+	}
+}
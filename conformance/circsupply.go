@@ -0,0 +1,100 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/stmgr"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// SelectorCircSupply, if it appears in a vector, indicates how the driver
+// obtains the circulating supply: with CircSupplyFixed (the default), it's
+// the circulating supply of the preconditions throughout; with
+// CircSupplyRecompute, it's recomputed from the state tree as messages are
+// applied, for vectors whose messages change it in ways that matter to their
+// outcomes, e.g. by burning or locking funds, across epochs.
+const SelectorCircSupply = "circ_supply"
+
+// Circulating supply modes of the driver; see SelectorCircSupply.
+const (
+	CircSupplyFixed     = "fixed"
+	CircSupplyRecompute = "recompute"
+)
+
+// circSupplyTerms returns the terms of the circulating supply that are
+// derivable from the state tree alone: the mined and disbursed funds, less
+// the burnt and locked funds. Vested funds aren't, as their computation
+// requires the genesis state.
+func circSupplyTerms(ctx context.Context, st *state.StateTree) (abi.TokenAmount, error) {
+	mined, err := stmgr.GetFilMined(ctx, st)
+	if err != nil {
+		return big.Zero(), fmt.Errorf("failed to get mined funds: %w", err)
+	}
+	disbursed, err := stmgr.GetFilReserveDisbursed(ctx, st)
+	if err != nil {
+		return big.Zero(), fmt.Errorf("failed to get disbursed funds: %w", err)
+	}
+	burnt, err := stmgr.GetFilBurnt(ctx, st)
+	if err != nil {
+		return big.Zero(), fmt.Errorf("failed to get burnt funds: %w", err)
+	}
+	locked, err := stmgr.GetFilLocked(ctx, st)
+	if err != nil {
+		return big.Zero(), fmt.Errorf("failed to get locked funds: %w", err)
+	}
+	return big.Sub(big.Sub(big.Add(mined, disbursed), burnt), locked), nil
+}
+
+// recomputingCircSupply returns a circulating supply calculator that
+// recomputes the circulating supply of the state trees it's called with from
+// the supplied circulating supply at the base state root, adjusted by the
+// changes in the mined, disbursed, burnt and locked funds since. Vested funds
+// are held constant, as they change slowly, and their computation requires
+// the genesis state.
+func recomputingCircSupply(ctx context.Context, bs blockstore.Blockstore, base abi.TokenAmount, baseRoot cid.Cid) (vm.CircSupplyCalculator, error) {
+	st, err := state.LoadStateTree(cbor.NewCborStore(bs), baseRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load base state tree: %w", err)
+	}
+	baseTerms, err := circSupplyTerms(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, _ abi.ChainEpoch, st *state.StateTree) (abi.TokenAmount, error) {
+		terms, err := circSupplyTerms(ctx, st)
+		if err != nil {
+			return big.Zero(), err
+		}
+		cs := big.Add(base, big.Sub(terms, baseTerms))
+		if cs.LessThan(big.Zero()) {
+			cs = big.Zero()
+		}
+		return cs, nil
+	}, nil
+}
+
+// circSupplyAt returns the circulating supply at the supplied state root,
+// recomputed from that at the base state root; see recomputingCircSupply.
+func circSupplyAt(ctx context.Context, bs blockstore.Blockstore, base abi.TokenAmount, baseRoot, root cid.Cid) (abi.TokenAmount, error) {
+	if root == baseRoot {
+		return base, nil
+	}
+	calc, err := recomputingCircSupply(ctx, bs, base, baseRoot)
+	if err != nil {
+		return big.Zero(), err
+	}
+	st, err := state.LoadStateTree(cbor.NewCborStore(bs), root)
+	if err != nil {
+		return big.Zero(), fmt.Errorf("failed to load state tree: %w", err)
+	}
+	return calc(ctx, 0, st)
+}
@@ -0,0 +1,399 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	builtintypes "github.com/filecoin-project/go-state-types/builtin"
+	miner10 "github.com/filecoin-project/go-state-types/builtin/v10/miner"
+	runtime7 "github.com/filecoin-project/specs-actors/v7/actors/runtime"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ClassConsensusFault is the class of vectors that exercise the reporting of
+// consensus faults. They're laid out as message vectors, whose last message
+// invokes ReportConsensusFault on a miner, with the block headers proving the
+// fault. The headers are stored in the vector's CAR, and
+// SelectorConsensusFault binds them to the fault their structure proves, if
+// any. Besides the receipts and the post-state, runners assert the slashing of
+// the miner: a successful report makes it ineligible to mine, and penalizes
+// it; a failed one leaves it untouched.
+//
+// In the legacy VM, the verification of the fault is mocked with the
+// evidence, skipping that of the signatures of the headers, which requires
+// the worker key of the miner at their epochs; the FVM verifies the headers
+// for real, against the lookback state recorded in the vector.
+const ClassConsensusFault schema.Class = "consensus-fault"
+
+// SelectorConsensusFault, in consensus-fault vectors, carries the CID of the
+// ConsensusFaultEvidence, which is stored as a raw block in the vector's CAR.
+// The driver mocks the verification of the headers it references with it.
+const SelectorConsensusFault = "consensus_fault"
+
+// The kinds of consensus faults.
+const (
+	// ConsensusFaultNone is the kind of reports proving no fault, which
+	// miners reject.
+	ConsensusFaultNone             = "none"
+	ConsensusFaultDoubleForkMining = "double-fork-mining"
+	ConsensusFaultTimeOffsetMining = "time-offset-mining"
+	ConsensusFaultParentGrinding   = "parent-grinding"
+)
+
+var consensusFaultTypes = map[string]runtime7.ConsensusFaultType{
+	ConsensusFaultDoubleForkMining: runtime7.ConsensusFaultDoubleForkMining,
+	ConsensusFaultTimeOffsetMining: runtime7.ConsensusFaultTimeOffsetMining,
+	ConsensusFaultParentGrinding:   runtime7.ConsensusFaultParentGrinding,
+}
+
+// ConsensusFaultKind returns the kind of the consensus fault, or
+// ConsensusFaultNone if nil.
+func ConsensusFaultKind(fault *runtime7.ConsensusFault) string {
+	if fault == nil {
+		return ConsensusFaultNone
+	}
+	for kind, t := range consensusFaultTypes {
+		if t == fault.Type {
+			return kind
+		}
+	}
+	return fmt.Sprintf("unknown-%d", fault.Type)
+}
+
+// ConsensusFaultEvidence is the evidence of a consensus fault report: the
+// block headers reported, stored in the vector's CAR, and the fault they
+// prove.
+type ConsensusFaultEvidence struct {
+	// BlockHeader1 and BlockHeader2 are the CIDs of the headers of the blocks
+	// of the fault, and BlockHeaderExtra that of the witness of a parent
+	// grinding fault, if any.
+	BlockHeader1     cid.Cid  `json:"block_header_1"`
+	BlockHeader2     cid.Cid  `json:"block_header_2"`
+	BlockHeaderExtra *cid.Cid `json:"block_header_extra,omitempty"`
+	// Fault is the kind of the fault the structure of the headers proves, as
+	// ClassifyConsensusFault tells, or ConsensusFaultNone.
+	Fault string `json:"fault"`
+}
+
+// Store serializes the evidence into a raw block in the supplied blockstore,
+// and returns its CID. The headers must be stored separately.
+func (e *ConsensusFaultEvidence) Store(ctx context.Context, bs blockstore.Blockstore) (cid.Cid, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to serialize consensus fault evidence: %w", err)
+	}
+
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_256}.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := bs.Put(ctx, blk); err != nil {
+		return cid.Undef, fmt.Errorf("failed to store consensus fault evidence: %w", err)
+	}
+	return c, nil
+}
+
+// LoadConsensusFaultEvidence loads the evidence stored under the supplied
+// CID.
+func LoadConsensusFaultEvidence(ctx context.Context, bs blockstore.Blockstore, c cid.Cid) (*ConsensusFaultEvidence, error) {
+	blk, err := bs.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load consensus fault evidence %s: %w", c, err)
+	}
+	e := new(ConsensusFaultEvidence)
+	if err := json.Unmarshal(blk.RawData(), e); err != nil {
+		return nil, fmt.Errorf("failed to deserialize consensus fault evidence %s: %w", c, err)
+	}
+	if _, ok := consensusFaultTypes[e.Fault]; !ok && e.Fault != ConsensusFaultNone {
+		return nil, fmt.Errorf("consensus fault evidence %s: unknown fault %q", c, e.Fault)
+	}
+	return e, nil
+}
+
+// headers returns the serialized headers of the evidence, as reported; extra
+// is nil if the evidence carries no witness.
+func (e *ConsensusFaultEvidence) headers(ctx context.Context, bs blockstore.Blockstore) (a, b, extra []byte, err error) {
+	get := func(c cid.Cid) ([]byte, error) {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load reported block header %s: %w", c, err)
+		}
+		return blk.RawData(), nil
+	}
+	if a, err = get(e.BlockHeader1); err != nil {
+		return nil, nil, nil, err
+	}
+	if b, err = get(e.BlockHeader2); err != nil {
+		return nil, nil, nil, err
+	}
+	if e.BlockHeaderExtra != nil {
+		if extra, err = get(*e.BlockHeaderExtra); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return a, b, extra, nil
+}
+
+// outcome returns the outcome of the verification of the fault, as the
+// syscall reports it: the fault of the first block's miner, at the epoch of
+// the second, or an error if the headers prove none.
+func (e *ConsensusFaultEvidence) outcome(a, b []byte) (ConsensusFaultOutcome, error) {
+	if e.Fault == ConsensusFaultNone {
+		return ConsensusFaultOutcome{Err: "no consensus fault detected"}, nil
+	}
+	var ha, hb types.BlockHeader
+	if err := ha.UnmarshalCBOR(bytes.NewReader(a)); err != nil {
+		return ConsensusFaultOutcome{}, fmt.Errorf("cannot decode first block header: %w", err)
+	}
+	if err := hb.UnmarshalCBOR(bytes.NewReader(b)); err != nil {
+		return ConsensusFaultOutcome{}, fmt.Errorf("cannot decode second block header: %w", err)
+	}
+	return ConsensusFaultOutcome{Fault: &runtime7.ConsensusFault{
+		Target: ha.Miner,
+		Epoch:  hb.Height,
+		Type:   consensusFaultTypes[e.Fault],
+	}}, nil
+}
+
+// consensusFaultOverrides returns the syscall overrides mocking the
+// verification of the fault of the evidence under the supplied CID, on top
+// of the base overrides, which may be nil.
+func consensusFaultOverrides(ctx context.Context, bs blockstore.Blockstore, c cid.Cid, base *SyscallOverrides) (*SyscallOverrides, error) {
+	e, err := LoadConsensusFaultEvidence(ctx, bs, c)
+	if err != nil {
+		return nil, err
+	}
+	a, b, extra, err := e.headers(ctx, bs)
+	if err != nil {
+		return nil, err
+	}
+	out, err := e.outcome(a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	var o SyscallOverrides
+	if base != nil {
+		o = *base
+	}
+	o.ConsensusFaults = make(map[string]ConsensusFaultOutcome, len(o.ConsensusFaults)+1)
+	if base != nil {
+		for k, v := range base.ConsensusFaults {
+			o.ConsensusFaults[k] = v
+		}
+	}
+	o.ConsensusFaults[ConsensusFaultKey(a, b, extra)] = out
+	return &o, nil
+}
+
+// ClassifyConsensusFault returns the fault the block headers prove on their
+// structure alone, as the consensus fault syscall does before verifying their
+// signatures, or nil if they prove none. extra, the witness of parent
+// grinding, may be nil.
+func ClassifyConsensusFault(a, b, extra *types.BlockHeader) *runtime7.ConsensusFault {
+	if a.Cid().Equals(b.Cid()) || a.Miner != b.Miner || b.Height < a.Height {
+		return nil
+	}
+	fault := func(t runtime7.ConsensusFaultType) *runtime7.ConsensusFault {
+		return &runtime7.ConsensusFault{Target: a.Miner, Epoch: b.Height, Type: t}
+	}
+
+	var found *runtime7.ConsensusFault
+	if a.Height == b.Height {
+		found = fault(runtime7.ConsensusFaultDoubleForkMining)
+	}
+	if types.CidArrsEqual(a.Parents, b.Parents) && a.Height != b.Height {
+		found = fault(runtime7.ConsensusFaultTimeOffsetMining)
+	}
+	if extra != nil && types.CidArrsEqual(a.Parents, extra.Parents) && a.Height == extra.Height &&
+		types.CidArrsContains(b.Parents, extra.Cid()) && !types.CidArrsContains(b.Parents, a.Cid()) {
+		found = fault(runtime7.ConsensusFaultParentGrinding)
+	}
+	return found
+}
+
+// classifySerializedFault classifies the fault of the serialized headers, as
+// ClassifyConsensusFault does; extra may be nil.
+func classifySerializedFault(a, b, extra []byte) (*runtime7.ConsensusFault, error) {
+	var ha, hb types.BlockHeader
+	if err := ha.UnmarshalCBOR(bytes.NewReader(a)); err != nil {
+		return nil, fmt.Errorf("cannot decode first block header: %w", err)
+	}
+	if err := hb.UnmarshalCBOR(bytes.NewReader(b)); err != nil {
+		return nil, fmt.Errorf("cannot decode second block header: %w", err)
+	}
+	var hc *types.BlockHeader
+	if len(extra) > 0 {
+		hc = new(types.BlockHeader)
+		if err := hc.UnmarshalCBOR(bytes.NewReader(extra)); err != nil {
+			return nil, fmt.Errorf("cannot decode extra block header: %w", err)
+		}
+	}
+	return ClassifyConsensusFault(&ha, &hb, hc), nil
+}
+
+// ExecuteConsensusFaultVector executes a consensus-fault test vector: as a
+// message vector, with the supplied options, then asserting the slashing of
+// the reported miner.
+func ExecuteConsensusFaultVector(ctx context.Context, r Reporter, vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) (diffs []string, err error) {
+	diffs, err = ExecuteMessageVector(ctx, r, vector, variant, opts)
+	if err != nil {
+		// the post-state isn't that of the vector; its slashing is moot.
+		return diffs, err
+	}
+	if err := assertSlashing(ctx, r, vector, variant, opts); err != nil {
+		r.Errorf("%s", err)
+		return diffs, err
+	}
+	return diffs, nil
+}
+
+// assertSlashing asserts that the report of the consensus-fault vector
+// carries the headers of its evidence, which prove its fault, and that the
+// miner is slashed in the post-state if, and only if, the report succeeds.
+func assertSlashing(ctx context.Context, r Reporter, vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) error {
+	restoreNetwork, err := useVectorNetwork(ctx, vector, opts)
+	if err != nil {
+		return err
+	}
+	defer restoreNetwork()
+
+	bs, _, err := loadBlockstore(vector.CAR, opts.ReuseBlockstores)
+	if err != nil {
+		return fmt.Errorf("failed to load the vector CAR: %w", err)
+	}
+	restoreBundle, err := useVectorBundle(bs, vector.Pre.StateTree.RootCID)
+	if err != nil {
+		return fmt.Errorf("failed to select the built-in actors bundle: %w", err)
+	}
+	defer restoreBundle()
+
+	s, ok := vector.Selector[SelectorConsensusFault]
+	if !ok {
+		return fmt.Errorf("consensus-fault vector without a %s selector", SelectorConsensusFault)
+	}
+	c, err := cid.Decode(s)
+	if err != nil {
+		return fmt.Errorf("invalid %s selector: %w", SelectorConsensusFault, err)
+	}
+	e, err := LoadConsensusFaultEvidence(ctx, bs, c)
+	if err != nil {
+		return err
+	}
+	a, b, extra, err := e.headers(ctx, bs)
+	if err != nil {
+		return err
+	}
+
+	// the report is the last message.
+	if len(vector.ApplyMessages) == 0 {
+		return fmt.Errorf("consensus-fault vector without messages")
+	}
+	last := len(vector.ApplyMessages) - 1
+	msg, _, err := DecodeVectorMessage(vector.ApplyMessages[last].Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to deserialize the report: %w", err)
+	}
+	if msg.Method != builtintypes.MethodsMiner.ReportConsensusFault {
+		return fmt.Errorf("the last message invokes method %d, not ReportConsensusFault", msg.Method)
+	}
+	var params miner10.ReportConsensusFaultParams
+	if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+		return fmt.Errorf("failed to decode the report params: %w", err)
+	}
+	if !bytes.Equal(params.BlockHeader1, a) || !bytes.Equal(params.BlockHeader2, b) || !bytes.Equal(params.BlockHeaderExtra, extra) {
+		return fmt.Errorf("the report doesn't carry the block headers of the evidence")
+	}
+	fault, err := classifySerializedFault(a, b, extra)
+	if err != nil {
+		return err
+	}
+	if kind := ConsensusFaultKind(fault); kind != e.Fault {
+		return fmt.Errorf("the evidence claims a %s fault, but the headers prove %s", e.Fault, kind)
+	}
+
+	pre, err := minerSlashingState(ctx, bs, vector.Pre.StateTree.RootCID, msg)
+	if err != nil {
+		return fmt.Errorf("pre-state: %w", err)
+	}
+	post, err := minerSlashingState(ctx, bs, vector.Post.StateTree.RootCID, msg)
+	if err != nil {
+		return fmt.Errorf("post-state: %w", err)
+	}
+
+	penalty := big.Add(big.Sub(pre.balance, post.balance), big.Sub(post.feeDebt, pre.feeDebt))
+	receipt := vector.Post.Receipts[last]
+	if receipt.ExitCode != 0 {
+		// e.g. a fault already reported, or too old.
+		if post.faultElapsed != pre.faultElapsed || !penalty.IsZero() {
+			return fmt.Errorf("the miner was slashed by a report failing with exit code %d", receipt.ExitCode)
+		}
+		r.Logf("the report of a %s fault was rejected with exit code %d; the miner is untouched", e.Fault, receipt.ExitCode)
+		return nil
+	}
+
+	if e.Fault == ConsensusFaultNone {
+		return fmt.Errorf("the report of no fault succeeded")
+	}
+	if post.faultElapsed <= abi.ChainEpoch(variant.Epoch) {
+		return fmt.Errorf("the miner remains eligible after a %s fault; consensus fault elapsed at epoch %d", e.Fault, post.faultElapsed)
+	}
+	if penalty.Sign() <= 0 {
+		return fmt.Errorf("the miner wasn't penalized for a %s fault", e.Fault)
+	}
+	r.Logf("the miner was slashed for a %s fault: penalized %s, ineligible until epoch %d", e.Fault, types.FIL(penalty), post.faultElapsed)
+	return nil
+}
+
+// minerSlashing is the state of a miner its slashing affects.
+type minerSlashing struct {
+	faultElapsed abi.ChainEpoch
+	balance      abi.TokenAmount
+	feeDebt      abi.TokenAmount
+}
+
+// minerSlashingState returns the state of the miner the report is sent to,
+// in the state tree.
+func minerSlashingState(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, report *types.Message) (*minerSlashing, error) {
+	cst := cbor.NewCborStore(bs)
+	st, err := state.LoadStateTree(cst, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state tree: %w", err)
+	}
+	act, err := st.GetActor(report.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load miner %s: %w", report.To, err)
+	}
+	ms, err := miner.Load(adt.WrapStore(ctx, cst), act)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the state of miner %s: %w", report.To, err)
+	}
+	info, err := ms.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the info of miner %s: %w", report.To, err)
+	}
+	debt, err := ms.FeeDebt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the fee debt of miner %s: %w", report.To, err)
+	}
+	return &minerSlashing{faultElapsed: info.ConsensusFaultElapsed, balance: act.Balance, feeDebt: debt}, nil
+}
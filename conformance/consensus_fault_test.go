@@ -0,0 +1,117 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	runtime7 "github.com/filecoin-project/specs-actors/v7/actors/runtime"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// faultHeader returns a block header of the miner at the height, over the
+// parents, told apart from others by the ticket.
+func faultHeader(t *testing.T, miner address.Address, height abi.ChainEpoch, ticket string, parents ...cid.Cid) *types.BlockHeader {
+	root, err := abi.CidBuilder.Sum([]byte("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.BlockHeader{
+		Miner:                 miner,
+		Ticket:                &types.Ticket{VRFProof: []byte(ticket)},
+		Parents:               parents,
+		ParentWeight:          types.NewInt(0),
+		Height:                height,
+		ParentStateRoot:       root,
+		ParentMessageReceipts: root,
+		Messages:              root,
+		ParentBaseFee:         types.NewInt(100),
+	}
+}
+
+func TestClassifyConsensusFault(t *testing.T) {
+	m1, _ := address.NewIDAddress(1000)
+	m2, _ := address.NewIDAddress(1001)
+	p1, p2 := faultHeader(t, m2, 9, "p1").Cid(), faultHeader(t, m2, 9, "p2").Cid()
+
+	a := faultHeader(t, m1, 10, "a", p1)
+	witness := faultHeader(t, m2, 10, "c", p1)
+	for _, tc := range []struct {
+		name     string
+		a, b, c  *types.BlockHeader
+		expected string
+	}{
+		{"double fork", a, faultHeader(t, m1, 10, "b", p2), nil, ConsensusFaultDoubleForkMining},
+		{"time offset", a, faultHeader(t, m1, 11, "b", p1), nil, ConsensusFaultTimeOffsetMining},
+		{"parent grinding", a, faultHeader(t, m1, 11, "b", witness.Cid()), witness, ConsensusFaultParentGrinding},
+		{"same block", a, a, nil, ConsensusFaultNone},
+		{"different miners", a, faultHeader(t, m2, 10, "b", p2), nil, ConsensusFaultNone},
+		{"descending heights", faultHeader(t, m1, 11, "b", p2), a, nil, ConsensusFaultNone},
+		{"unrelated", a, faultHeader(t, m1, 11, "b", p2), nil, ConsensusFaultNone},
+	} {
+		fault := ClassifyConsensusFault(tc.a, tc.b, tc.c)
+		if kind := ConsensusFaultKind(fault); kind != tc.expected {
+			t.Errorf("%s: expected %s; got %s", tc.name, tc.expected, kind)
+			continue
+		}
+		if fault != nil && (fault.Target != m1 || fault.Epoch != tc.b.Height) {
+			t.Errorf("%s: expected a fault of %s at epoch %d; got %s at %d", tc.name, m1, tc.b.Height, fault.Target, fault.Epoch)
+		}
+	}
+}
+
+func TestConsensusFaultOverrides(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	m, _ := address.NewIDAddress(1000)
+	a, b := faultHeader(t, m, 10, "a"), faultHeader(t, m, 10, "b")
+
+	var serialized [][]byte
+	for _, h := range []*types.BlockHeader{a, b} {
+		blk, err := h.ToStorageBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+		serialized = append(serialized, blk.RawData())
+	}
+	key := ConsensusFaultKey(serialized[0], serialized[1], nil)
+
+	for _, kind := range []string{ConsensusFaultDoubleForkMining, ConsensusFaultNone} {
+		e := &ConsensusFaultEvidence{BlockHeader1: a.Cid(), BlockHeader2: b.Cid(), Fault: kind}
+		c, err := e.Store(ctx, bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := consensusFaultOverrides(ctx, bs, c, &SyscallOverrides{PassProofs: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !o.PassProofs {
+			t.Errorf("%s: expected the base overrides to be kept", kind)
+		}
+		out, ok := o.ConsensusFaults[key]
+		if !ok {
+			t.Fatalf("%s: expected the reported headers to be mocked", kind)
+		}
+		switch kind {
+		case ConsensusFaultNone:
+			if out.Fault != nil || out.Err == "" {
+				t.Errorf("expected no fault to be proven; got %+v", out)
+			}
+		default:
+			expected := runtime7.ConsensusFault{Target: m, Epoch: 10, Type: runtime7.ConsensusFaultDoubleForkMining}
+			if out.Fault == nil || *out.Fault != expected {
+				t.Errorf("expected fault %+v; got %+v", expected, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// ErrUnsupportedClass is returned by ExecuteVariant for vectors of classes
+// it doesn't execute.
+var ErrUnsupportedClass = errors.New("unsupported test vector class")
+
+// executors are the Execute* functions of the vector classes.
+var executors = map[schema.Class]func(context.Context, Reporter, *schema.TestVector, *schema.Variant, ExecuteOpts) ([]string, error){
+	schema.ClassMessage:  ExecuteMessageVector,
+	schema.ClassTipset:   ExecuteTipsetVector,
+	schema.ClassBlockSeq: ExecuteBlockSeqVector,
+	ClassMigration:       ExecuteMigrationVector,
+	ClassMessageValidity: ExecuteMessageValidityVector,
+	ClassConsensusFault:  ExecuteConsensusFaultVector,
+}
+
+// ExecuteVariant executes the variant of the vector with the Execute*
+// function of its class, with the supplied options. Once ctx is done, the
+// execution is interrupted before the next message it applies. It returns
+// ErrUnsupportedClass for unknown classes, ErrLightVector for light vectors,
+// and ErrSharedBlocks for vectors whose blocks are held in a shared store.
+func ExecuteVariant(ctx context.Context, r Reporter, vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) (diffs []string, err error) {
+	execute, ok := executors[vector.Class]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedClass, vector.Class)
+	}
+	if IsLightVector(vector) {
+		return nil, ErrLightVector
+	}
+	if err := checkSharedBlocks(vector); err != nil {
+		return nil, err
+	}
+	return execute(ctx, r, vector, variant, opts)
+}
+
+// corpusIgnore are the paths, relative to the corpus root, that are never
+// vectors.
+var corpusIgnore = []string{".git", "schema.json"}
+
+// Runner runs the vectors of a corpus as go tests, so that projects can run
+// a corpus inside their own test binaries. Vectors are the .json files found
+// by a recursive walk of the corpus root, but for those whose names start
+// with _, and the ignored paths. Vectors hinted as incorrect are skipped, as
+// are light vectors, those the selection of the Runner excludes, and those
+// annotated to be skipped. The variants of vectors annotated as expected to
+// fail are reported as skipped when they fail, and fail the test when they
+// pass; see Annotation.
+type Runner struct {
+	// Root is the directory of the corpus.
+	Root string
+	// Ignore are the paths, relative to Root, to skip, besides .git and
+	// schema.json.
+	Ignore []string
+	// Select, if not empty, restricts the run to the vectors whose selector
+	// carries all its entries.
+	Select schema.Selector
+	// Tags, if not empty, restricts the run to the vectors carrying all of
+	// them, or tags under them, and SkipTags skips those carrying any; see
+	// MatchesTag.
+	Tags     []string
+	SkipTags []string
+	// Unsupported are the selectors of the features the embedder doesn't
+	// support (e.g. schema.SelectorChaosActor): vectors declaring any of them
+	// as "true" are skipped.
+	Unsupported []string
+	// Skip, if not nil, returns why the vector, at the path relative to Root,
+	// must be skipped, or "" to run it. It's consulted after the selection.
+	Skip func(path string, vector *schema.TestVector) string
+	// Suppressions, if not nil, annotate the vectors of the corpus besides
+	// their own annotations, which they take precedence over.
+	Suppressions *Suppressions
+
+	// Opts are the options the vectors are executed with, e.g. the network
+	// the Runner is configured for; see ExecuteVariant.
+	Opts ExecuteOpts
+
+	// SharedBlocks, if not nil, is the block store shared by the vectors of
+	// the corpus; the vectors carrying SelectorSharedBlocks are materialized
+	// with it before they're executed. See MaterializeVector.
+	SharedBlocks BlockGetter
+
+	// OnSkip, if not nil, is called for every vector skipped, with the
+	// reason.
+	OnSkip func(path string, vector *schema.TestVector, reason string)
+	// OnVariant, if not nil, is called with the result of every variant
+	// executed.
+	OnVariant func(res VariantResult)
+}
+
+// VariantResult is the result of the execution of a vector variant by a
+// Runner.
+type VariantResult struct {
+	// Path is that of the vector, relative to the corpus root.
+	Path    string
+	Vector  *schema.TestVector
+	Variant *schema.Variant
+	// Passed is whether the variant passed its assertions.
+	Passed bool
+	// ExpectedFailure is whether the vector is annotated as expected to
+	// fail; its failures then don't fail the run.
+	ExpectedFailure bool
+	// Diffs are the state diffs of a failed variant, if computed.
+	Diffs []string
+	// Err is the error that interrupted the execution, if any.
+	Err      error
+	Duration time.Duration
+}
+
+// Vectors returns the paths of the vectors of the corpus, relative to Root.
+func (r *Runner) Vectors() ([]string, error) {
+	ignore := make(map[string]struct{}, len(corpusIgnore)+len(r.Ignore))
+	for _, p := range append(corpusIgnore, r.Ignore...) {
+		ignore[filepath.Clean(p)] = struct{}{}
+	}
+
+	var vectors []string
+	// the trailing separator has a symlinked root followed.
+	err := filepath.Walk(r.Root+string(filepath.Separator), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(r.Root, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := ignore[rel]; ok {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" || strings.HasPrefix(info.Name(), "_") {
+			return nil
+		}
+		vectors = append(vectors, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk corpus %s: %w", r.Root, err)
+	}
+	return vectors, nil
+}
+
+// SkipReason returns why the vector, at the path relative to Root, is
+// skipped, or "" if it runs. It has no side effects: vectors missing the
+// proof parameters they require are skipped, unless Opts.FetchProofParams
+// is set, in which case Run fetches them before executing the vector.
+func (r *Runner) SkipReason(path string, vector *schema.TestVector) string {
+	if HasHint(vector, schema.HintIncorrect) {
+		return "vector marked as incorrect"
+	}
+	if IsLightVector(vector) {
+		return ErrLightVector.Error()
+	}
+	if !r.Opts.FetchProofParams {
+		if err := MissingProofParams(vector); err != nil {
+			return err.Error()
+		}
+	}
+	for k, v := range r.Select {
+		if vector.Selector[k] != v {
+			return fmt.Sprintf("vector not selected: selector %s is %q, not %q", k, vector.Selector[k], v)
+		}
+	}
+	if reason := TagFilterReason(vector, r.Tags, r.SkipTags); reason != "" {
+		return "vector not selected: " + reason
+	}
+	for _, k := range r.Unsupported {
+		if vector.Selector[k] == "true" {
+			return fmt.Sprintf("vector requires unsupported %s", k)
+		}
+	}
+	if a := r.Suppressions.Annotation(path, vector); a != nil && a.Kind == AnnotationSkip {
+		return "vector " + a.String()
+	}
+	if r.Skip != nil {
+		return r.Skip(path, vector)
+	}
+	return ""
+}
+
+// Run runs the vectors of the corpus, as a subtest of t each, and each of
+// their variants as a subtest of those. It fails t if the corpus holds no
+// vectors.
+func (r *Runner) Run(t *testing.T) {
+	vectors, err := r.Vectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) == 0 {
+		t.Fatalf("no test vectors found in %s", r.Root)
+	}
+
+	for _, path := range vectors {
+		path := path
+		raw, err := os.ReadFile(filepath.Join(r.Root, path))
+		if err != nil {
+			t.Fatalf("failed to read test vector %s: %s", path, err)
+		}
+		var vector schema.TestVector
+		if err := json.Unmarshal(raw, &vector); err != nil {
+			t.Errorf("failed to parse test vector %s: %s; skipping", path, err)
+			continue
+		}
+
+		t.Run(path, func(t *testing.T) {
+			ctx := testContext(t)
+			if reason := r.SkipReason(path, &vector); reason != "" {
+				if r.OnSkip != nil {
+					r.OnSkip(path, &vector, reason)
+				}
+				t.Skipf("skipping %s: %s", vector.Meta.ID, reason)
+			}
+			if _, ok := executors[vector.Class]; !ok {
+				t.Fatalf("unsupported test vector class: %s", vector.Class)
+			}
+			if r.Opts.FetchProofParams {
+				if err := EnsureProofParams(ctx, &vector, true); err != nil {
+					t.Fatalf("failed to fetch the proof parameters of test vector %s: %s", path, err)
+				}
+			}
+			if r.SharedBlocks != nil {
+				if err := MaterializeVector(ctx, &vector, r.SharedBlocks); err != nil {
+					t.Fatalf("failed to materialize test vector %s: %s", path, err)
+				}
+			}
+			for _, variant := range vector.Pre.Variants {
+				variant := variant
+				t.Run(variant.ID, func(t *testing.T) {
+					a := r.Suppressions.Annotation(path, &vector)
+					if a == nil || a.Kind != AnnotationExpectedFail {
+						r.RunVariant(t, path, &vector, &variant)
+						return
+					}
+					rep := &expectedFailureReporter{Reporter: t}
+					var res VariantResult
+					rep.run(func() { res = r.RunVariant(rep, path, &vector, &variant) })
+					if res.Passed {
+						t.Errorf("vector %s, but passed; remove the annotation", a)
+						return
+					}
+					t.Skipf("vector %s, and failed", a)
+				})
+			}
+		})
+	}
+}
+
+// testContext returns a context cancelled once the test, and its subtests,
+// complete.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// RunVariant executes the variant of the vector, at the path relative to
+// Root, reporting to rep, and returns its result, which it passes to
+// OnVariant. Skip logic, and the handling of expected failures, are the
+// caller's; see SkipReason and Annotation.
+func (r *Runner) RunVariant(rep Reporter, path string, vector *schema.TestVector, variant *schema.Variant) (res VariantResult) {
+	res = VariantResult{Path: path, Vector: vector, Variant: variant}
+	if a := r.Suppressions.Annotation(path, vector); a != nil && a.Kind == AnnotationExpectedFail {
+		res.ExpectedFailure = true
+	}
+	start := time.Now()
+	// fatal failures reported to testing.T exit the goroutine.
+	defer func() {
+		res.Passed = res.Err == nil && !rep.Failed()
+		res.Duration = time.Since(start)
+		if r.OnVariant != nil {
+			r.OnVariant(res)
+		}
+	}()
+	res.Diffs, res.Err = ExecuteVariant(context.Background(), rep, vector, variant, r.Opts)
+	return res
+}
+
+// errExpectedFailureAborted is the panic value with which
+// expectedFailureReporter aborts the execution of a variant on fatal failures.
+type errExpectedFailureAborted struct{}
+
+// expectedFailureReporter is a Reporter executing variants expected to fail:
+// it logs their failures to the underlying Reporter, without failing it.
+type expectedFailureReporter struct {
+	Reporter
+	failed int32
+}
+
+func (r *expectedFailureReporter) Failed() bool {
+	return atomic.LoadInt32(&r.failed) == 1
+}
+
+func (r *expectedFailureReporter) Errorf(format string, args ...interface{}) {
+	atomic.StoreInt32(&r.failed, 1)
+	r.Reporter.Logf("expected failure: "+format, args...)
+}
+
+func (r *expectedFailureReporter) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	panic(errExpectedFailureAborted{})
+}
+
+func (r *expectedFailureReporter) FailNow() {
+	atomic.StoreInt32(&r.failed, 1)
+	panic(errExpectedFailureAborted{})
+}
+
+// run runs f, recovering from the fatal failures reported through r.
+func (r *expectedFailureReporter) run(f func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			if _, ok := p.(errExpectedFailureAborted); !ok {
+				panic(p)
+			}
+		}
+	}()
+	f()
+}
@@ -0,0 +1,102 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestRunnerVectors(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{
+		"a/vector.json",
+		"a/_draft.json",
+		"a/notes.txt",
+		"b/vector.json",
+		"schema.json",
+		".git/config.json",
+		"skipped/vector.json",
+	} {
+		p = filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &Runner{Root: root, Ignore: []string{"skipped"}}
+	vectors, err := r.Vectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join("a", "vector.json"), filepath.Join("b", "vector.json")}
+	if !reflect.DeepEqual(vectors, want) {
+		t.Errorf("expected vectors %v, got %v", want, vectors)
+	}
+}
+
+func TestRunnerSkipReason(t *testing.T) {
+	r := &Runner{
+		Select:      schema.Selector{"network": "mainnet"},
+		Unsupported: []string{schema.SelectorChaosActor},
+		Skip: func(path string, _ *schema.TestVector) string {
+			if path == "slow.json" {
+				return "slow"
+			}
+			return ""
+		},
+	}
+	for _, c := range []struct {
+		path    string
+		vector  schema.TestVector
+		skipped bool
+	}{
+		{"ok.json", schema.TestVector{Selector: schema.Selector{"network": "mainnet"}}, false},
+		{"incorrect.json", schema.TestVector{Selector: schema.Selector{"network": "mainnet"}, Hints: []string{schema.HintIncorrect}}, true},
+		{"unselected.json", schema.TestVector{Selector: schema.Selector{"network": "calibrationnet"}}, true},
+		{"chaos.json", schema.TestVector{Selector: schema.Selector{"network": "mainnet", schema.SelectorChaosActor: "true"}}, true},
+		{"slow.json", schema.TestVector{Selector: schema.Selector{"network": "mainnet"}}, true},
+		{"annotated.json", schema.TestVector{Selector: schema.Selector{"network": "mainnet", SelectorSkip: "broken"}}, true},
+		{"expected.json", schema.TestVector{Selector: schema.Selector{"network": "mainnet", SelectorExpected: ExpectedFail}}, false},
+	} {
+		if reason := r.SkipReason(c.path, &c.vector); (reason != "") != c.skipped {
+			t.Errorf("%s: expected skipped: %t, got reason %q", c.path, c.skipped, reason)
+		}
+	}
+}
+
+func TestRunnerSkipReasonProofParams(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FIL_PROOFS_PARAMETER_CACHE", dir)
+	vector := &schema.TestVector{Selector: schema.Selector{SelectorProofParams: "2KiB"}}
+
+	if reason := new(Runner).SkipReason("proofs.json", vector); reason == "" {
+		t.Error("expected a vector missing its proof parameters to be skipped")
+	}
+	// with FetchProofParams, the vector runs, and Run fetches them; SkipReason
+	// itself doesn't.
+	r := &Runner{Opts: ExecuteOpts{FetchProofParams: true}}
+	if reason := r.SkipReason("proofs.json", vector); reason != "" {
+		t.Errorf("expected the vector to run, got reason %q", reason)
+	}
+	if entries, err := os.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Errorf("expected no proof parameters fetched, found %d files", len(entries))
+	}
+}
+
+func TestExecuteVariantUnsupportedClass(t *testing.T) {
+	tv := &schema.TestVector{Class: "unknown"}
+	if _, err := ExecuteVariant(context.Background(), new(LogReporter), tv, &schema.Variant{}, ExecuteOpts{}); !errors.Is(err, ErrUnsupportedClass) {
+		t.Errorf("expected ErrUnsupportedClass, got %v", err)
+	}
+}
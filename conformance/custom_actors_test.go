@@ -0,0 +1,26 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"testing"
+
+	rtt "github.com/filecoin-project/go-state-types/rt"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance/chaos"
+	"github.com/filecoin-project/lotus/conformance/puppet"
+)
+
+func TestDriverCustomActors(t *testing.T) {
+	ctx := context.Background()
+	if actors := NewDriver(ctx, nil, DriverOpts{}).testActors(); len(actors) != 0 {
+		t.Errorf("expected no test actors by default; got %d", len(actors))
+	}
+
+	d := NewDriver(ctx, schema.Selector{schema.SelectorChaosActor: "true"}, DriverOpts{CustomActors: []rtt.VMActor{puppet.Actor{}}})
+	actors := d.testActors()
+	if len(actors) != 2 || actors[0].Code() != chaos.ChaosActorCodeCID || actors[1].Code() != puppet.PuppetActorCodeCID {
+		t.Errorf("expected the chaos actor and the custom actor to be registered; got %v", actors)
+	}
+}
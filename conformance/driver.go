@@ -2,8 +2,8 @@ package conformance
 
 import (
 	"context"
+	"fmt"
 	gobig "math/big"
-	"os"
 
 	"github.com/ipfs/go-cid"
 	ds "github.com/ipfs/go-datastore"
@@ -19,7 +19,11 @@ import (
 	"github.com/filecoin-project/test-vectors/schema"
 
 	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors"
 	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/cron"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/reward"
 	"github.com/filecoin-project/lotus/chain/consensus/filcns"
 	"github.com/filecoin-project/lotus/chain/state"
 	"github.com/filecoin-project/lotus/chain/stmgr"
@@ -27,6 +31,7 @@ import (
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/chain/vm"
 	"github.com/filecoin-project/lotus/conformance/chaos"
+	"github.com/filecoin-project/lotus/conformance/puppet"
 	_ "github.com/filecoin-project/lotus/lib/sigs/bls"  // enable bls signatures
 	_ "github.com/filecoin-project/lotus/lib/sigs/secp" // enable secp signatures
 	"github.com/filecoin-project/lotus/storage/sealer/ffiwrapper"
@@ -42,10 +47,36 @@ var (
 	DefaultBaseFee = abi.NewTokenAmount(100)
 )
 
+// SelectorPuppetActor, if "true", indicates that the vector installs the
+// puppet actor (see the puppet package) in its pre-state, so runners lacking
+// support for it skip the vector. As the chaos actor, it's only supported by
+// the legacy VM.
+const SelectorPuppetActor = "puppet_actor"
+
 type Driver struct {
 	ctx      context.Context
 	selector schema.Selector
 	vmFlush  bool
+
+	overrides    *SyscallOverrides
+	overridesErr error
+	recording    *SyscallRecording
+	chaos        bool
+	puppet       bool
+	hooks        *DriverHooks
+	debugBundles bool
+	recomputeCS  bool
+	verifySigs   bool
+
+	limits       *ExecutionLimits
+	msgOverrides *MessageOverrides
+	customActors []rtt.VMActor
+	// steps counts the messages applied, for the limits.
+	steps int
+
+	// vmConstructor, if not nil, constructs the VMs messages and tipsets are
+	// executed in, in place of those of their network version; for tests.
+	vmConstructor func(ctx context.Context, vmopt *vm.VMOpts) (vm.Interface, error)
 }
 
 type DriverOpts struct {
@@ -55,14 +86,132 @@ type DriverOpts struct {
 	// vectors and trimming state, as we don't want to force an accidental
 	// deep copy of the state tree.
 	//
-	// Disabling VM flushing almost always should go hand-in-hand with
-	// LOTUS_DISABLE_VM_BUF=iknowitsabadidea. That way, state tree writes are
-	// immediately committed to the blockstore.
+	// Disabling VM flushing also disables the buffering of the writes of the
+	// legacy VM (see vm.VMOpts.UnbufferedWrites), so that state tree writes
+	// are immediately committed to the blockstore.
 	DisableVMFlush bool
+
+	// Syscalls, if not nil, overrides the outcomes of syscalls made by actors.
+	// If nil, and the selector carries SelectorMockSyscalls, the overrides are
+	// built from the selector.
+	Syscalls *SyscallOverrides
+
+	// RecordSyscalls, if not nil, records the outcomes of the syscalls made by
+	// actors into the supplied recording. If nil, and the selector carries
+	// SelectorRecordedSyscalls, the recorded outcomes are replayed instead.
+	RecordSyscalls *SyscallRecording
+
+	// ChaosActor, if true, registers the chaos actor in the VM's actor
+	// registry, even if the selector doesn't declare it through
+	// schema.SelectorChaosActor. The chaos actor is only supported by the
+	// legacy VM, so the legacy VM is used regardless of network version.
+	ChaosActor bool
+
+	// PuppetActor, if true, registers the puppet actor in the VM's actor
+	// registry, even if the selector doesn't declare it through
+	// SelectorPuppetActor. As ChaosActor, it forces the legacy VM.
+	PuppetActor bool
+
+	// Hooks, if not nil, are invoked during the execution of messages.
+	Hooks *DriverHooks
+
+	// DebugBundles, if true, executes messages on the FVM with the built-in
+	// actors code redirected to the bundles configured through the
+	// LOTUS_FVM_DEBUG_BUNDLE_V<N> environment variables (see vm.NewDebugFVM).
+	DebugBundles bool
+
+	// RecomputeCircSupply, if true, recomputes the circulating supply from
+	// the state tree as messages are applied, rather than taking the supplied
+	// one as constant, even if the selector doesn't declare it through
+	// SelectorCircSupply.
+	RecomputeCircSupply bool
+
+	// Limits, if not nil, bound the resources the executions of the driver
+	// may use; see ExecutionLimits.
+	Limits *ExecutionLimits
+
+	// VerifySignatures, if true, verifies the signatures of the messages
+	// before applying them, even if the selector doesn't declare it through
+	// SelectorVerifySignatures; see there.
+	VerifySignatures bool
+
+	// MessageOverrides, if not nil, tweak the fields of the explicit messages
+	// before they're applied; results are then non-canonical. See
+	// MessageOverrides.
+	MessageOverrides *MessageOverrides
+
+	// CustomActors, if not empty, are registered in the VM's actor registry
+	// under their code CIDs, alongside the built-in actors, so that vectors
+	// exercising actors being prototyped execute without forking this
+	// package; actors registered under the code CID of a built-in actor
+	// replace it. As ChaosActor, they force the legacy VM. The states of
+	// custom actors are decoded once registered in ActorStates.
+	CustomActors []rtt.VMActor
 }
 
 func NewDriver(ctx context.Context, selector schema.Selector, opts DriverOpts) *Driver {
-	return &Driver{ctx: ctx, selector: selector, vmFlush: !opts.DisableVMFlush}
+	d := &Driver{
+		ctx:          ctx,
+		selector:     selector,
+		vmFlush:      !opts.DisableVMFlush,
+		overrides:    opts.Syscalls,
+		recording:    opts.RecordSyscalls,
+		chaos:        opts.ChaosActor || selector[schema.SelectorChaosActor] == "true",
+		puppet:       opts.PuppetActor || selector[SelectorPuppetActor] == "true",
+		hooks:        opts.Hooks,
+		debugBundles: opts.DebugBundles,
+		recomputeCS:  opts.RecomputeCircSupply || selector[SelectorCircSupply] == CircSupplyRecompute,
+		limits:       opts.Limits,
+		verifySigs:   opts.VerifySignatures || selector[SelectorVerifySignatures] == "true",
+		msgOverrides: opts.MessageOverrides,
+		customActors: opts.CustomActors,
+	}
+	if features, ok := selector[SelectorMockSyscalls]; ok && d.overrides == nil {
+		d.overrides, d.overridesErr = ParseMockSyscalls(features)
+	}
+	return d
+}
+
+// syscalls returns the syscall builder to use in the VM. Recorded syscall
+// outcomes are loaded from the supplied blockstore.
+func (d *Driver) syscalls(bs blockstore.Blockstore) (vm.SyscallBuilder, error) {
+	if d.overridesErr != nil {
+		return nil, d.overridesErr
+	}
+
+	overrides := d.overrides
+	if s, ok := d.selector[SelectorConsensusFault]; ok {
+		c, err := cid.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s selector: %w", SelectorConsensusFault, err)
+		}
+		if overrides, err = consensusFaultOverrides(d.ctx, bs, c, overrides); err != nil {
+			return nil, err
+		}
+	}
+
+	base := vm.Syscalls(ffiwrapper.ProofVerifier)
+	if overrides != nil {
+		base = overrides.Builder()
+	}
+
+	if d.recording != nil {
+		return d.recording.Recorder(base), nil
+	}
+
+	if s, ok := d.selector[SelectorRecordedSyscalls]; ok {
+		c, err := cid.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s selector: %w", SelectorRecordedSyscalls, err)
+		}
+		rec, err := LoadSyscallRecording(d.ctx, bs, c)
+		if err != nil {
+			return nil, err
+		}
+		return rec.Replayer(base), nil
+	}
+
+	return base, nil
 }
 
 type ExecuteTipsetResult struct {
@@ -91,6 +240,10 @@ type ExecuteTipsetParams struct {
 	Rand vm.Rand
 	// BaseFee if not nil or zero, will override the basefee of the tipset.
 	BaseFee abi.TokenAmount
+	// NetworkVersion, if not zero, is the network version the tipset executes
+	// under. If the default upgrade schedule yields another one at ExecEpoch,
+	// the network version is pinned instead; see upgradeScheduleFor.
+	NetworkVersion network.Version
 }
 
 // ExecuteTipset executes the supplied tipset on top of the state represented
@@ -101,13 +254,17 @@ type ExecuteTipsetParams struct {
 // and reward withdrawal per miner.
 func (d *Driver) ExecuteTipset(bs blockstore.Blockstore, ds ds.Batching, params ExecuteTipsetParams) (*ExecuteTipsetResult, error) {
 	var (
-		tipset   = params.Tipset
-		syscalls = vm.Syscalls(ffiwrapper.ProofVerifier)
-
-		cs      = store.NewChainStore(bs, bs, ds, filcns.Weight, nil)
-		tse     = filcns.NewTipSetExecutor()
-		sm, err = stmgr.NewStateManager(cs, tse, syscalls, filcns.DefaultUpgradeSchedule(), nil)
+		tipset = params.Tipset
+		cs     = store.NewChainStore(bs, bs, ds, filcns.Weight, nil)
+		tse    = filcns.NewTipSetExecutor()
 	)
+
+	syscalls, err := d.syscalls(bs)
+	if err != nil {
+		return nil, err
+	}
+
+	sm, err := stmgr.NewStateManager(cs, tse, syscalls, upgradeScheduleFor(params.ExecEpoch, params.NetworkVersion), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -122,16 +279,47 @@ func (d *Driver) ExecuteTipset(bs blockstore.Blockstore, ds ds.Batching, params
 
 	defer cs.Close() //nolint:errcheck
 
-	blocks := make([]filcns.FilecoinBlockMessages, 0, len(tipset.Blocks))
-	for _, b := range tipset.Blocks {
-		sb := store.BlockMessages{
-			Miner: b.MinerAddr,
-		}
+	// account for the messages of the blocks, and the cron ticks of the
+	// epochs executed.
+	var (
+		msgs      []*types.Message
+		blockMsgs = make([][]*types.Message, len(tipset.Blocks))
+	)
+	for i, b := range tipset.Blocks {
+		sigs := make([]*crypto.Signature, 0, len(b.Messages))
 		for _, m := range b.Messages {
-			msg, err := types.DecodeMessage(m)
+			msg, sig, err := DecodeVectorMessage(m)
 			if err != nil {
 				return nil, err
 			}
+			blockMsgs[i] = append(blockMsgs[i], msg)
+			sigs = append(sigs, sig)
+		}
+		// as the chain does, the signers are resolved in the parent state.
+		if d.verifySigs {
+			if err := VerifyBlockSignatures(d.ctx, bs, params.Preroot, blockMsgs[i], sigs); err != nil {
+				return nil, fmt.Errorf("block %d: %w", i, err)
+			}
+		}
+		// overrides are applied past verification, as they break signatures.
+		for j, msg := range blockMsgs[i] {
+			if msg, err = d.msgOverrides.Apply(msg); err != nil {
+				return nil, fmt.Errorf("block %d: %w", i, err)
+			}
+			blockMsgs[i][j] = msg
+			msgs = append(msgs, msg)
+		}
+	}
+	if err := d.checkLimits(len(msgs)+int(params.ExecEpoch-params.ParentEpoch), msgs...); err != nil {
+		return nil, err
+	}
+
+	blocks := make([]filcns.FilecoinBlockMessages, 0, len(tipset.Blocks))
+	for i, b := range tipset.Blocks {
+		sb := store.BlockMessages{
+			Miner: b.MinerAddr,
+		}
+		for _, msg := range blockMsgs[i] {
 			switch msg.From.Protocol() {
 			case address.SECP256K1:
 				sb.SecpkMessages = append(sb.SecpkMessages, toChainMsg(msg))
@@ -159,11 +347,31 @@ func (d *Driver) ExecuteTipset(bs blockstore.Blockstore, ds ds.Batching, params
 		vmopt.CircSupplyCalc = func(context.Context, abi.ChainEpoch, *state.StateTree) (abi.TokenAmount, error) {
 			return big.Zero(), nil
 		}
-
-		return vm.NewVM(ctx, vmopt)
+		vmopt.UnbufferedWrites = !d.vmFlush
+
+		vmopt.Bstore = d.hooks.wrapBlockstore(vmopt.Bstore)
+
+		var (
+			vmi vm.Interface
+			err error
+		)
+		switch {
+		case d.vmConstructor != nil:
+			vmi, err = d.vmConstructor(ctx, vmopt)
+		case len(d.testActors()) > 0:
+			vmi, err = d.newTestActorsVM(ctx, vmopt)
+		case d.debugBundles && vmopt.NetworkVersion >= network.Version16:
+			vmi, err = vm.NewDebugFVM(ctx, vmopt)
+		default:
+			vmi, err = vm.NewVM(ctx, vmopt)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return d.wrapVM(vmi), nil
 	})
 
-	postcid, receiptsroot, err := tse.ApplyBlocks(context.Background(),
+	postcid, receiptsroot, err := tse.ApplyBlocks(d.ctx,
 		sm,
 		params.ParentEpoch,
 		params.Preroot,
@@ -179,6 +387,7 @@ func (d *Driver) ExecuteTipset(bs blockstore.Blockstore, ds ds.Batching, params
 	if err != nil {
 		return nil, err
 	}
+	d.hooks.stateRoot(postcid)
 
 	ret := &ExecuteTipsetResult{
 		ReceiptsRoot:    receiptsroot,
@@ -189,6 +398,121 @@ func (d *Driver) ExecuteTipset(bs blockstore.Blockstore, ds ds.Batching, params
 	return ret, nil
 }
 
+type ExecuteTipsetsParams struct {
+	Preroot cid.Cid
+	// ParentEpoch is the epoch of the last tipset processed before the first
+	// tipset in the sequence.
+	ParentEpoch abi.ChainEpoch
+	// BaseEpoch is the epoch the offsets of the tipsets are relative to.
+	BaseEpoch abi.ChainEpoch
+	Tipsets   []schema.Tipset
+	// Rand is an optional vm.Rand implementation to use. If nil, the driver
+	// will use a vm.Rand that returns a fixed value for all calls.
+	Rand vm.Rand
+	// NetworkVersion, if not zero, is the network version the tipsets execute
+	// under; see ExecuteTipsetParams.NetworkVersion.
+	NetworkVersion network.Version
+	// Checkpoint, if not nil, is called after each tipset is applied, with the
+	// index of the tipset, the parameters it was executed with, and its result.
+	// Returning an error aborts the sequence.
+	Checkpoint func(i int, params *ExecuteTipsetParams, res *ExecuteTipsetResult) error
+}
+
+// ExecuteTipsets applies the supplied tipsets in sequence, each on top of the
+// state produced by the previous one, starting from the preroot. It returns
+// the result of every tipset, in order, so callers can verify intermediate
+// state roots.
+func (d *Driver) ExecuteTipsets(bs blockstore.Blockstore, ds ds.Batching, params ExecuteTipsetsParams) ([]*ExecuteTipsetResult, error) {
+	var (
+		root    = params.Preroot
+		prev    = params.ParentEpoch
+		results = make([]*ExecuteTipsetResult, 0, len(params.Tipsets))
+	)
+	for i := range params.Tipsets {
+		execEpoch := params.BaseEpoch + abi.ChainEpoch(params.Tipsets[i].EpochOffset)
+		p := ExecuteTipsetParams{
+			Preroot:        root,
+			ParentEpoch:    prev,
+			Tipset:         &params.Tipsets[i],
+			ExecEpoch:      execEpoch,
+			Rand:           params.Rand,
+			NetworkVersion: params.NetworkVersion,
+		}
+		res, err := d.ExecuteTipset(bs, ds, p)
+		if err != nil {
+			return results, fmt.Errorf("failed to apply tipset %d: %w", i, err)
+		}
+		results = append(results, res)
+
+		if params.Checkpoint != nil {
+			if err := params.Checkpoint(i, &p, res); err != nil {
+				return results, err
+			}
+		}
+
+		root, prev = res.PostStateRoot, execEpoch
+	}
+	return results, nil
+}
+
+type ExecuteMigrationParams struct {
+	// Preroot is the state root produced by the upgrade epoch, i.e. the state
+	// the migration runs on.
+	Preroot cid.Cid
+	// Epoch is the upgrade epoch.
+	Epoch abi.ChainEpoch
+	// NetworkVersion is the network version the upgrade transitions into. It
+	// is used to locate the migration in the upgrade schedule, so that vectors
+	// remain valid regardless of the epoch the upgrade is scheduled at in the
+	// network Lotus was built for.
+	NetworkVersion network.Version
+}
+
+// ExecuteMigration runs the state migration of the network upgrade into the
+// supplied network version on top of the state represented by the preroot
+// CID, and returns the resulting state root.
+//
+// Migrations that require access to chain history (e.g. lookback or genesis
+// state) are not supported, as vectors carry no chain.
+func (d *Driver) ExecuteMigration(bs blockstore.Blockstore, ds ds.Batching, params ExecuteMigrationParams) (cid.Cid, error) {
+	var upgrade *stmgr.Upgrade
+	for _, u := range filcns.DefaultUpgradeSchedule() {
+		u := u // capture
+		if u.Network == params.NetworkVersion && u.Migration != nil {
+			upgrade = &u
+			break
+		}
+	}
+	if upgrade == nil {
+		return cid.Undef, fmt.Errorf("no migration found for network version %d", params.NetworkVersion)
+	}
+
+	// reschedule the upgrade at the vector's epoch, dropping pre-migrations;
+	// the state manager will run it with a fresh migration cache.
+	upgrade.Height = params.Epoch
+	upgrade.PreMigrations = nil
+
+	syscalls, err := d.syscalls(bs)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	cs := store.NewChainStore(bs, bs, ds, filcns.Weight, nil)
+	sm, err := stmgr.NewStateManager(cs, filcns.NewTipSetExecutor(), syscalls, stmgr.UpgradeSchedule{*upgrade}, nil)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	defer cs.Close() //nolint:errcheck
+
+	root, err := sm.HandleStateForks(d.ctx, params.Preroot, params.Epoch, nil, nil)
+	if err != nil {
+		return cid.Undef, err
+	}
+	d.hooks.stateRoot(root)
+	return root, nil
+}
+
 type ExecuteMessageParams struct {
 	Preroot        cid.Cid
 	Epoch          abi.ChainEpoch
@@ -204,29 +528,122 @@ type ExecuteMessageParams struct {
 	// Lookback is the LookbackStateGetter; returns the state tree at a given epoch.
 	Lookback vm.LookbackStateGetter
 
-	// TipSetGetter returns the tipset key at any given epoch.
+	// TipSetGetter returns the tipset key at any given epoch. If nil, the
+	// driver serves the ancestor tipsets of the vector; see
+	// SelectorAncestorHeaders.
 	TipSetGetter vm.TipSetGetter
 }
 
 // ExecuteMessage executes a conformance test vector message in a temporary VM.
 func (d *Driver) ExecuteMessage(bs blockstore.Blockstore, params ExecuteMessageParams) (*vm.ApplyRet, cid.Cid, error) {
-	if !d.vmFlush {
-		// do not flush the VM, just the state tree; this should be used with
-		// LOTUS_DISABLE_VM_BUF enabled, so writes will anyway be visible.
-		_ = os.Setenv("LOTUS_DISABLE_VM_BUF", "iknowitsabadidea")
+	msg, err := d.msgOverrides.Apply(params.Message)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	params.Message = msg
+	if err := d.checkLimits(1, params.Message); err != nil {
+		return nil, cid.Undef, err
+	}
+	vmi, err := d.newVM(bs, &params)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+
+	ret, err := d.wrapVM(vmi).ApplyMessage(d.ctx, toChainMsg(params.Message))
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+
+	root, err := d.flush(vmi)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	d.hooks.stateRoot(root)
+	return ret, root, nil
+}
+
+// ExecuteImplicitMessage executes a system message (e.g. a cron tick, or a
+// reward award) in a temporary VM. Implicit messages are not subject to gas
+// charging, nor to nonce and signature checks.
+func (d *Driver) ExecuteImplicitMessage(bs blockstore.Blockstore, params ExecuteMessageParams) (*vm.ApplyRet, cid.Cid, error) {
+	if err := d.checkLimits(1); err != nil {
+		return nil, cid.Undef, err
+	}
+	vmi, err := d.newVM(bs, &params)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+
+	ret, err := d.wrapVM(vmi).ApplyImplicitMessage(d.ctx, params.Message)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+
+	root, err := d.flush(vmi)
+	if err != nil {
+		return nil, cid.Undef, err
 	}
+	d.hooks.stateRoot(root)
+	return ret, root, nil
+}
+
+// NewCronMessage returns the implicit message the system actor sends to the
+// cron actor at the end of every epoch, null rounds included.
+func NewCronMessage(epoch abi.ChainEpoch) *types.Message {
+	return &types.Message{
+		To:         cron.Address,
+		From:       builtin.SystemActorAddr,
+		Nonce:      uint64(epoch),
+		Value:      types.NewInt(0),
+		GasFeeCap:  types.NewInt(0),
+		GasPremium: types.NewInt(0),
+		GasLimit:   build.BlockGasLimit * 10000, // same as in the tipset executor.
+		Method:     cron.Methods.EpochTick,
+		Params:     nil,
+	}
+}
 
+// NewRewardMessage returns the implicit message the system actor sends to the
+// reward actor after applying the messages of a block, to award the block
+// reward and the gas reward to its miner.
+func NewRewardMessage(epoch abi.ChainEpoch, miner address.Address, penalty, gasReward abi.TokenAmount, winCount int64) (*types.Message, error) {
+	params, aerr := actors.SerializeParams(&reward.AwardBlockRewardParams{
+		Miner:     miner,
+		Penalty:   penalty,
+		GasReward: gasReward,
+		WinCount:  winCount,
+	})
+	if aerr != nil {
+		return nil, fmt.Errorf("failed to serialize award params: %w", aerr)
+	}
+
+	return &types.Message{
+		From:       builtin.SystemActorAddr,
+		To:         reward.Address,
+		Nonce:      uint64(epoch),
+		Value:      types.NewInt(0),
+		GasFeeCap:  types.NewInt(0),
+		GasPremium: types.NewInt(0),
+		GasLimit:   1 << 30, // same as in the tipset executor.
+		Method:     reward.Methods.AwardBlockReward,
+		Params:     params,
+	}, nil
+}
+
+// newVM creates a temporary VM on top of params.Preroot, filling in defaults
+// for the optional params.
+func (d *Driver) newVM(bs blockstore.Blockstore, params *ExecuteMessageParams) (vm.Interface, error) {
 	if params.Rand == nil {
 		params.Rand = NewFixedRand()
 	}
 
 	if params.TipSetGetter == nil {
-		// TODO: If/when we start writing conformance tests against the EVM, we'll need to
-		// actually implement this and (unfortunately) capture any tipsets looked up by
-		// messages.
-		params.TipSetGetter = func(context.Context, abi.ChainEpoch) (types.TipSetKey, error) {
-			return types.EmptyTSK, nil
+		// serve the ancestor tipsets captured at extraction, if any.
+		getter, err := d.tipSetGetter(bs)
+		if err != nil {
+			return nil, err
 		}
+		params.TipSetGetter = getter
 	}
 
 	if params.Lookback == nil {
@@ -236,73 +653,108 @@ func (d *Driver) ExecuteMessage(bs blockstore.Blockstore, params ExecuteMessageP
 		//  current finality window, this workaround is enough.
 		//  The correct solutions are documented in https://github.com/filecoin-project/ref-fvm/issues/381,
 		//  but they're much harder to implement, and the tradeoffs aren't clear.
+		preroot := params.Preroot
 		params.Lookback = func(ctx context.Context, epoch abi.ChainEpoch) (*state.StateTree, error) {
 			cst := cbor.NewCborStore(bs)
-			return state.LoadStateTree(cst, params.Preroot)
+			return state.LoadStateTree(cst, preroot)
 		}
 	}
 
+	syscalls, err := d.syscalls(bs)
+	if err != nil {
+		return nil, err
+	}
+
+	circSupply := params.CircSupply
+	circSupplyCalc := func(_ context.Context, _ abi.ChainEpoch, _ *state.StateTree) (abi.TokenAmount, error) {
+		return circSupply, nil
+	}
+	if d.recomputeCS {
+		if circSupplyCalc, err = recomputingCircSupply(d.ctx, bs, circSupply, params.Preroot); err != nil {
+			return nil, fmt.Errorf("failed to set up circulating supply recomputation: %w", err)
+		}
+	}
 	vmOpts := &vm.VMOpts{
-		StateBase: params.Preroot,
-		Epoch:     params.Epoch,
-		Bstore:    bs,
-		Syscalls:  vm.Syscalls(ffiwrapper.ProofVerifier),
-		CircSupplyCalc: func(_ context.Context, _ abi.ChainEpoch, _ *state.StateTree) (abi.TokenAmount, error) {
-			return params.CircSupply, nil
-		},
+		StateBase:      params.Preroot,
+		Epoch:          params.Epoch,
+		Bstore:         d.hooks.wrapBlockstore(bs),
+		Syscalls:       syscalls,
+		CircSupplyCalc: circSupplyCalc,
 		Rand:           params.Rand,
 		BaseFee:        params.BaseFee,
 		NetworkVersion: params.NetworkVersion,
 		LookbackState:  params.Lookback,
 		TipSetGetter:   params.TipSetGetter,
+		// the VM isn't flushed, just the state tree, so writes must be
+		// visible without it.
+		UnbufferedWrites: !d.vmFlush,
 	}
 
-	var vmi vm.Interface
-	if chaosOn, ok := d.selector["chaos_actor"]; ok && chaosOn == "true" {
-		lvm, err := vm.NewLegacyVM(context.TODO(), vmOpts)
-		if err != nil {
-			return nil, cid.Undef, err
-		}
+	if d.vmConstructor != nil {
+		return d.vmConstructor(context.TODO(), vmOpts)
+	}
 
-		invoker := filcns.NewActorRegistry()
-		av, _ := actorstypes.VersionForNetwork(params.NetworkVersion)
-		registry := builtin.MakeRegistryLegacy([]rtt.VMActor{chaos.Actor{}})
-		invoker.Register(av, nil, registry)
-		lvm.SetInvoker(invoker)
-		vmi = lvm
-	} else {
-		if vmOpts.NetworkVersion >= network.Version16 {
-			fvm, err := vm.NewFVM(context.TODO(), vmOpts)
-			if err != nil {
-				return nil, cid.Undef, err
-			}
-			vmi = fvm
-		} else {
-			lvm, err := vm.NewLegacyVM(context.TODO(), vmOpts)
-			if err != nil {
-				return nil, cid.Undef, err
-			}
-			invoker := filcns.NewActorRegistry()
-			lvm.SetInvoker(invoker)
-			vmi = lvm
+	if len(d.testActors()) > 0 {
+		return d.newTestActorsVM(context.TODO(), vmOpts)
+	}
+
+	if vmOpts.NetworkVersion >= network.Version16 {
+		if d.debugBundles {
+			return vm.NewDebugFVM(context.TODO(), vmOpts)
 		}
+		return vm.NewFVM(context.TODO(), vmOpts)
 	}
 
-	ret, err := vmi.ApplyMessage(d.ctx, toChainMsg(params.Message))
+	lvm, err := vm.NewLegacyVM(context.TODO(), vmOpts)
 	if err != nil {
-		return nil, cid.Undef, err
+		return nil, err
 	}
+	invoker := filcns.NewActorRegistry()
+	lvm.SetInvoker(invoker)
+	return lvm, nil
+}
 
-	var root cid.Cid
+// testActors returns the actors to register in the actor registry of the VM
+// besides the built-in actors: the chaos and puppet actors, if the vector
+// relies on them, and the custom actors.
+func (d *Driver) testActors() []rtt.VMActor {
+	var actors []rtt.VMActor
+	if d.chaos {
+		actors = append(actors, chaos.Actor{})
+	}
+	if d.puppet {
+		actors = append(actors, puppet.Actor{})
+	}
+	return append(actors, d.customActors...)
+}
+
+// newTestActorsVM creates a legacy VM with the test and custom actors
+// registered in its actor registry; see testActors.
+func (d *Driver) newTestActorsVM(ctx context.Context, vmOpts *vm.VMOpts) (vm.Interface, error) {
+	lvm, err := vm.NewLegacyVM(ctx, vmOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	invoker := filcns.NewActorRegistry()
+	av, err := actorstypes.VersionForNetwork(vmOpts.NetworkVersion)
+	if err != nil {
+		return nil, fmt.Errorf("cannot register test actors: %w", err)
+	}
+	registry := builtin.MakeRegistryLegacy(d.testActors())
+	invoker.Register(av, nil, registry)
+	lvm.SetInvoker(invoker)
+	return lvm, nil
+}
+
+// flush returns the post-state root of the supplied VM.
+func (d *Driver) flush(vmi vm.Interface) (cid.Cid, error) {
 	if d.vmFlush {
 		// flush the VM, committing the state tree changes and forcing a
 		// recursive copy from the temporary blockstore to the real blockstore.
-		root, err = vmi.Flush(d.ctx)
-	} else {
-		root, err = vmi.(*vm.LegacyVM).StateTree().(*state.StateTree).Flush(d.ctx)
+		return vmi.Flush(d.ctx)
 	}
-
-	return ret, root, err
+	return vmi.(*vm.LegacyVM).StateTree().(*state.StateTree).Flush(d.ctx)
 }
 
 // toChainMsg injects a synthetic 0-filled signature of the right length to
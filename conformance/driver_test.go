@@ -0,0 +1,115 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/cron"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// vmRecorder constructs VMs for a driver, recording their base states, the
+// messages applied in them, and the state roots they're flushed to.
+type vmRecorder struct {
+	// bases are the base states of the VMs constructed, in order.
+	bases []cid.Cid
+	// crons are the epochs of the cron ticks applied.
+	crons []abi.ChainEpoch
+	// explicit are the epochs the explicit messages are applied at.
+	explicit []abi.ChainEpoch
+	// roots are the state roots the VMs are flushed to, in order.
+	roots []cid.Cid
+}
+
+func (r *vmRecorder) construct(_ context.Context, vmopt *vm.VMOpts) (vm.Interface, error) {
+	r.bases = append(r.bases, vmopt.StateBase)
+	return &recordingVM{rec: r, base: vmopt.StateBase, epoch: vmopt.Epoch}, nil
+}
+
+// recordingVM applies every message successfully, recording it; flushing it
+// yields a state root derived from its base state.
+type recordingVM struct {
+	vm.Interface
+	rec   *vmRecorder
+	base  cid.Cid
+	epoch abi.ChainEpoch
+}
+
+func (v *recordingVM) ApplyMessage(context.Context, types.ChainMsg) (*vm.ApplyRet, error) {
+	v.rec.explicit = append(v.rec.explicit, v.epoch)
+	return &vm.ApplyRet{}, nil
+}
+
+func (v *recordingVM) ApplyImplicitMessage(_ context.Context, msg *types.Message) (*vm.ApplyRet, error) {
+	if msg.To == cron.Address && msg.Method == cron.Methods.EpochTick {
+		v.rec.crons = append(v.rec.crons, abi.ChainEpoch(msg.Nonce))
+	}
+	return &vm.ApplyRet{}, nil
+}
+
+func (v *recordingVM) Flush(context.Context) (cid.Cid, error) {
+	root := blocks.NewBlock([]byte(fmt.Sprintf("%s/%d", v.base, len(v.rec.roots)))).Cid()
+	v.rec.roots = append(v.rec.roots, root)
+	return root, nil
+}
+
+// equalEpochs returns whether the epochs are the same, in the same order.
+func equalEpochs(a, b []abi.ChainEpoch) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDriverNullRounds(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	preroot := blocks.NewBlock([]byte("pre-state")).Cid()
+
+	rec := new(vmRecorder)
+	d := NewDriver(ctx, nil, DriverOpts{})
+	d.vmConstructor = rec.construct
+
+	// the first tipset is at the epoch following the parent one; the second
+	// is preceded by null rounds at epochs 12 and 13.
+	block := schema.Block{MinerAddr: mock.Address(1000), WinCount: 1}
+	results, err := d.ExecuteTipsets(bs, dssync.MutexWrap(datastore.NewMapDatastore()), ExecuteTipsetsParams{
+		Preroot:     preroot,
+		ParentEpoch: 10,
+		BaseEpoch:   10,
+		Tipsets: []schema.Tipset{
+			{EpochOffset: 1, Blocks: []schema.Block{block}},
+			{EpochOffset: 4, Blocks: []schema.Block{block}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected the results of 2 tipsets; got %d", len(results))
+	}
+
+	// cron runs once at every epoch past the parent one: at the end of each
+	// tipset, and at each null round.
+	if expected := []abi.ChainEpoch{11, 12, 13, 14}; !equalEpochs(rec.crons, expected) {
+		t.Fatalf("expected cron at epochs %v; got %v", expected, rec.crons)
+	}
+}
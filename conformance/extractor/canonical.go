@@ -0,0 +1,110 @@
+package extractor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+)
+
+// EncodeCAR returns the canonical, gzip-compressed encoding of the CAR
+// written by write, so that vectors extracted from the same inputs are
+// byte-identical, and can be deduplicated by content:
+//
+//   - the blocks of the CAR are deduplicated and sorted by CID, regardless of
+//     the order they were traversed in;
+//   - the gzip header carries no name, modification time nor OS, and the
+//     compression level is fixed.
+func EncodeCAR(write func(w io.Writer) error) ([]byte, error) {
+	b, _, err := encodeCanonicalCAR(write)
+	return b, err
+}
+
+// encodeCanonicalCAR encodes the CAR written by write as EncodeCAR does, and
+// returns its uncompressed size along.
+func encodeCanonicalCAR(write func(w io.Writer) error) ([]byte, int, error) {
+	var raw bytes.Buffer
+	if err := write(&raw); err != nil {
+		return nil, 0, err
+	}
+
+	var canonical bytes.Buffer
+	if err := canonicalizeCAR(&raw, &canonical); err != nil {
+		return nil, 0, err
+	}
+	size := canonical.Len()
+
+	var out bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&out, gzip.DefaultCompression)
+	if err != nil {
+		return nil, 0, err
+	}
+	gw.Header = gzip.Header{ModTime: time.Time{}, OS: 255}
+	if _, err := canonical.WriteTo(gw); err != nil {
+		return nil, 0, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return out.Bytes(), size, nil
+}
+
+// CARSizes returns the compressed and uncompressed sizes of the
+// gzip-compressed CAR of a vector.
+func CARSizes(gz []byte) (compressed, uncompressed int64, err error) {
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to inflate CAR: %w", err)
+	}
+	defer r.Close() //nolint:errcheck
+	if uncompressed, err = io.Copy(io.Discard, r); err != nil {
+		return 0, 0, fmt.Errorf("failed to inflate CAR: %w", err)
+	}
+	return int64(len(gz)), uncompressed, nil
+}
+
+// canonicalizeCAR rewrites the CAR read from r into w, with its blocks
+// deduplicated and sorted by CID. The roots are preserved in order.
+func canonicalizeCAR(r io.Reader, w io.Writer) error {
+	cr, err := car.NewCarReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read CAR: %w", err)
+	}
+
+	var (
+		blks []blocks.Block
+		seen = make(map[string]struct{})
+	)
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read CAR: %w", err)
+		}
+		if _, ok := seen[blk.Cid().KeyString()]; ok {
+			continue
+		}
+		seen[blk.Cid().KeyString()] = struct{}{}
+		blks = append(blks, blk)
+	}
+	sort.Slice(blks, func(i, j int) bool {
+		return blks[i].Cid().KeyString() < blks[j].Cid().KeyString()
+	})
+
+	if err := car.WriteHeader(&car.CarHeader{Roots: cr.Header.Roots, Version: 1}, w); err != nil {
+		return fmt.Errorf("failed to write CAR header: %w", err)
+	}
+	for _, blk := range blks {
+		if err := util.LdWrite(w, blk.Cid().Bytes(), blk.RawData()); err != nil {
+			return fmt.Errorf("failed to write CAR block: %w", err)
+		}
+	}
+	return nil
+}
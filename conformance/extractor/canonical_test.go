@@ -0,0 +1,103 @@
+// stm: #unit
+package extractor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+)
+
+func TestEncodeCARIsCanonical(t *testing.T) {
+	var (
+		a = blocks.NewBlock([]byte("a"))
+		b = blocks.NewBlock([]byte("b"))
+		c = blocks.NewBlock([]byte("c"))
+	)
+	writer := func(blks ...blocks.Block) func(w io.Writer) error {
+		return func(w io.Writer) error {
+			if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{a.Cid()}, Version: 1}, w); err != nil {
+				return err
+			}
+			for _, blk := range blks {
+				if err := util.LdWrite(w, blk.Cid().Bytes(), blk.RawData()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	first, err := EncodeCAR(writer(a, b, c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := EncodeCAR(writer(c, a, b, a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatal("expected identical encodings of CARs with the same blocks")
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gr.ModTime.IsZero() || gr.Name != "" {
+		t.Fatalf("unexpected gzip header: %+v", gr.Header)
+	}
+	cr, err := car.NewCarReader(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.Header.Roots) != 1 || cr.Header.Roots[0] != a.Cid() {
+		t.Fatalf("unexpected roots: %v", cr.Header.Roots)
+	}
+	var n int
+	for {
+		if _, err := cr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 blocks, got %d", n)
+	}
+}
+
+func TestCARSizeLimit(t *testing.T) {
+	blk := blocks.NewBlock(bytes.Repeat([]byte("a"), 1024))
+	write := func(w io.Writer) error {
+		if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{blk.Cid()}, Version: 1}, w); err != nil {
+			return err
+		}
+		return util.LdWrite(w, blk.Cid().Bytes(), blk.RawData())
+	}
+
+	x := &extraction{opts: Options{Class: "message", Retain: "accessed-cids", MaxCARSize: 1024}}
+	if _, err := x.encodeCAR(write); !errors.Is(err, ErrCARTooLarge) {
+		t.Fatalf("expected the CAR to be too large; got %v", err)
+	}
+
+	x.opts.MaxCARSize = 2048
+	gz, err := x.encodeCAR(write)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, uncompressed, err := CARSizes(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressed != int64(len(gz)) || uncompressed <= 1024 || uncompressed > 2048 || compressed >= uncompressed {
+		t.Errorf("unexpected sizes: %d compressed, %d uncompressed", compressed, uncompressed)
+	}
+}
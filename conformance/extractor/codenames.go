@@ -1,4 +1,4 @@
-package main
+package extractor
 
 import (
 	"github.com/filecoin-project/go-state-types/abi"
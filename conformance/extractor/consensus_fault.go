@@ -0,0 +1,144 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+
+	builtintypes "github.com/filecoin-project/go-state-types/builtin"
+	miner10 "github.com/filecoin-project/go-state-types/builtin/v10/miner"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// ClassConsensusFault is the extraction mode of consensus fault reports:
+// message vectors applying a ReportConsensusFault message, carrying the block
+// headers it reports; see conformance.ClassConsensusFault.
+const ClassConsensusFault = string(conformance.ClassConsensusFault)
+
+// faultEvidence is the evidence of the consensus fault reported by the
+// message of a consensus-fault extraction, with the headers it references.
+type faultEvidence struct {
+	conformance.ConsensusFaultEvidence
+	headers []*types.BlockHeader
+}
+
+// newFaultEvidence decodes the headers reported by the message, and
+// classifies the fault they prove.
+func newFaultEvidence(msg *types.Message) (*faultEvidence, error) {
+	if msg.Method != builtintypes.MethodsMiner.ReportConsensusFault {
+		return nil, fmt.Errorf("message %s invokes method %d, not ReportConsensusFault", msg.Cid(), msg.Method)
+	}
+	var params miner10.ReportConsensusFaultParams
+	if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+		return nil, fmt.Errorf("failed to decode report params: %w", err)
+	}
+
+	decode := func(name string, b []byte) (*types.BlockHeader, error) {
+		var h types.BlockHeader
+		if err := h.UnmarshalCBOR(bytes.NewReader(b)); err != nil {
+			return nil, fmt.Errorf("failed to decode the %s block header: %w", name, err)
+		}
+		return &h, nil
+	}
+	a, err := decode("first", params.BlockHeader1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decode("second", params.BlockHeader2)
+	if err != nil {
+		return nil, err
+	}
+	var extra *types.BlockHeader
+	if len(params.BlockHeaderExtra) > 0 {
+		if extra, err = decode("extra", params.BlockHeaderExtra); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &faultEvidence{
+		ConsensusFaultEvidence: conformance.ConsensusFaultEvidence{
+			BlockHeader1: a.Cid(),
+			BlockHeader2: b.Cid(),
+			Fault:        conformance.ConsensusFaultKind(conformance.ClassifyConsensusFault(a, b, extra)),
+		},
+		headers: []*types.BlockHeader{a, b},
+	}
+	if extra != nil {
+		c := extra.Cid()
+		f.BlockHeaderExtra = &c
+		f.headers = append(f.headers, extra)
+	}
+	return f, nil
+}
+
+// store stores the headers, and the evidence, in the blockstore, and returns
+// the CIDs of the headers, then of the evidence.
+func (f *faultEvidence) store(ctx context.Context, bs blockstore.Blockstore) ([]cid.Cid, error) {
+	var cids []cid.Cid
+	for _, h := range f.headers {
+		blk, err := h.ToStorageBlock()
+		if err != nil {
+			return nil, err
+		}
+		if err := bs.Put(ctx, blk); err != nil {
+			return nil, fmt.Errorf("failed to store reported block header: %w", err)
+		}
+		cids = append(cids, blk.Cid())
+	}
+	c, err := f.Store(ctx, bs)
+	if err != nil {
+		return nil, err
+	}
+	extractLog.Infow("recorded consensus fault evidence", "fault", f.Fault, "headers", len(f.headers), "evidence", c)
+	return append(cids, c), nil
+}
+
+// consensusFault extracts a consensus-fault vector, applying the
+// ReportConsensusFault message of the options, on chain or supplied, as a
+// message vector does, with the headers it reports and their evidence stored
+// in the CAR.
+//
+// The evidence records the fault the structure of the headers proves; the
+// vector records whether the miner accepted the report, with their
+// signatures verified, as a message vector does.
+func (x *extraction) consensusFault(ctx context.Context) (*schema.TestVector, error) {
+	opts := x.opts
+	if opts.Retain != "accessed-cids" {
+		return nil, fmt.Errorf("%w: consensus fault reports require 'accessed-cids' state retention", ErrRetentionUnsupported)
+	}
+
+	msg := opts.Message
+	if msg == nil {
+		if opts.CID == "" {
+			return nil, fmt.Errorf("missing report message CID")
+		}
+		mcid, err := cid.Decode(opts.CID)
+		if err != nil {
+			return nil, err
+		}
+		if msg, err = x.api.ChainGetMessage(ctx, mcid); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrMessageNotFound, mcid, err)
+		}
+	}
+	fault, err := newFaultEvidence(msg)
+	if err != nil {
+		return nil, err
+	}
+	extractLog.Infow("classified reported consensus fault", "miner", msg.To, "fault", fault.Fault)
+
+	x.fault = fault
+	defer func() { x.fault = nil }()
+	vector, err := x.message(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vector.Class = conformance.ClassConsensusFault
+	vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{Source: "consensus_fault:" + fault.Fault})
+	return vector, nil
+}
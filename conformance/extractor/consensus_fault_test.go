@@ -0,0 +1,81 @@
+// stm: #unit
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtintypes "github.com/filecoin-project/go-state-types/builtin"
+	miner10 "github.com/filecoin-project/go-state-types/builtin/v10/miner"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestFaultEvidence(t *testing.T) {
+	miner, _ := address.NewIDAddress(1000)
+	reporter, _ := address.NewIDAddress(1001)
+	root, err := abi.CidBuilder.Sum([]byte("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := func(ticket string) []byte {
+		h := &types.BlockHeader{
+			Miner:                 miner,
+			Ticket:                &types.Ticket{VRFProof: []byte(ticket)},
+			ParentWeight:          types.NewInt(0),
+			Height:                10,
+			ParentStateRoot:       root,
+			ParentMessageReceipts: root,
+			Messages:              root,
+			ParentBaseFee:         types.NewInt(100),
+		}
+		b, err := h.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	var params bytes.Buffer
+	if err := (&miner10.ReportConsensusFaultParams{BlockHeader1: header("a"), BlockHeader2: header("b")}).MarshalCBOR(&params); err != nil {
+		t.Fatal(err)
+	}
+	report := &types.Message{To: miner, From: reporter, Method: builtintypes.MethodsMiner.ReportConsensusFault, Params: params.Bytes(), Value: types.NewInt(0)}
+
+	f, err := newFaultEvidence(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Fault != conformance.ConsensusFaultDoubleForkMining {
+		t.Errorf("expected a %s fault; got %s", conformance.ConsensusFaultDoubleForkMining, f.Fault)
+	}
+	if f.BlockHeaderExtra != nil || len(f.headers) != 2 {
+		t.Errorf("expected the two headers reported, without a witness; got %d", len(f.headers))
+	}
+
+	bs := blockstore.NewMemory()
+	cids, err := f.store(context.Background(), bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cids) != 3 || cids[0] != f.BlockHeader1 || cids[1] != f.BlockHeader2 {
+		t.Fatalf("expected the headers, then the evidence; got %v", cids)
+	}
+	loaded, err := conformance.LoadConsensusFaultEvidence(context.Background(), bs, cids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded != f.ConsensusFaultEvidence {
+		t.Errorf("expected evidence %+v; got %+v", f.ConsensusFaultEvidence, *loaded)
+	}
+
+	report.Method = builtintypes.MethodsMiner.ChangeWorkerAddress
+	if _, err := newFaultEvidence(report); err == nil {
+		t.Error("expected a message invoking another method to be refused")
+	}
+}
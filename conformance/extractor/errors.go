@@ -0,0 +1,56 @@
+package extractor
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors extractions fail with, for callers to tell the causes of
+// failures apart with errors.Is (or errors.As, for SanityCheckError).
+var (
+	// ErrMessageNotFound is returned when the message to extract isn't found
+	// on chain, in the tipset it's said to be included in, or in the message
+	// pool.
+	ErrMessageNotFound = errors.New("message not found")
+
+	// ErrTipsetUnavailable is returned when a tipset the extraction requires,
+	// e.g. the inclusion or execution tipset of the message, can't be
+	// fetched, or hasn't been produced yet.
+	ErrTipsetUnavailable = errors.New("tipset unavailable")
+
+	// ErrRetentionUnsupported is returned when the state retention requested
+	// is unknown, or can't be honored by the extraction or its blockstore.
+	ErrRetentionUnsupported = errors.New("state retention unsupported")
+
+	// ErrSanityCheckFailed is matched by the SanityCheckErrors returned when
+	// the local execution of a vector disagrees with the chain.
+	ErrSanityCheckFailed = errors.New("sanity check failed")
+
+	// ErrSelfCheckFailed is returned when a vector fails to execute from the
+	// state it embeds alone, e.g. because its CAR misses blocks the extraction
+	// happened to have cached; see Options.SelfCheck.
+	ErrSelfCheckFailed = errors.New("self-check failed")
+)
+
+// SanityCheckError is returned when the local execution of a vector disagrees
+// with the chain, and the extraction is aborted.
+type SanityCheckError struct {
+	// Field is the field that disagrees first: exit_code, return_value or
+	// gas_used for message receipts, receipts for flows, and post_state_root
+	// for tipset replays.
+	Field string
+	// Detail describes the disagreement, if known.
+	Detail string
+}
+
+func (e *SanityCheckError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s on %s; vector generation aborted", ErrSanityCheckFailed, e.Field)
+	}
+	return fmt.Sprintf("%s on %s (%s); vector generation aborted", ErrSanityCheckFailed, e.Field, e.Detail)
+}
+
+// Is makes SanityCheckErrors match ErrSanityCheckFailed.
+func (e *SanityCheckError) Is(target error) bool {
+	return target == ErrSanityCheckFailed
+}
@@ -0,0 +1,83 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// precursorStep is a configuration of the precursors of a message vector, on
+// the ladder of those escalated through when its receipt sanity check fails
+// on gas.
+type precursorStep struct {
+	mode string
+	// max is the maximum number of precursors, besides those of the sender;
+	// 0 applies all.
+	max int
+}
+
+func (s precursorStep) String() string {
+	if s.max > 0 {
+		return fmt.Sprintf("%s (max %d)", s.mode, s.max)
+	}
+	return s.mode
+}
+
+// precursorSteps returns the configurations of the precursors to extract the
+// message vector of the options with, escalating towards the full tipset: that
+// of the options, then the 'all' selection mode, then the 'all' mode with
+// every preceding message of the tipset, uncapped by MaxPrecursors. Unless
+// EscalatePrecursors is set, or if the message is applied with no precursors
+// from the chain, only that of the options is.
+func precursorSteps(opts Options) []precursorStep {
+	steps := []precursorStep{{mode: opts.Precursor, max: opts.MaxPrecursors}}
+	if !opts.EscalatePrecursors || opts.IgnorePrecursors || opts.PreRoot != "" || opts.FromMpool || opts.Message != nil {
+		return steps
+	}
+	for _, s := range []precursorStep{{mode: PrecursorSelectAll, max: opts.MaxPrecursors}, {mode: PrecursorSelectAll}} {
+		if s != steps[len(steps)-1] {
+			steps = append(steps, s)
+		}
+	}
+	return steps
+}
+
+// escalatingMessage extracts a message vector, escalating its precursors
+// through precursorSteps while its receipt sanity check fails on gas, the
+// usual symptom of preceding messages of the tipset affecting the state the
+// message executes on. The configuration the vector was extracted with is
+// recorded in its metadata, after those escalated from.
+func (x *extraction) escalatingMessage(ctx context.Context) (*schema.TestVector, error) {
+	base := x.opts
+	defer func() { x.opts = base }()
+
+	steps := precursorSteps(base)
+	var tried []string
+	for i := 0; ; i++ {
+		s := steps[i]
+		x.opts.Precursor, x.opts.MaxPrecursors = s.mode, s.max
+		tried = append(tried, s.String())
+
+		vector, err := x.message(ctx)
+		if err == nil {
+			if i > 0 {
+				extractLog.Infow("extracted vector with escalated precursors", "precursors", s, "escalated_from", tried[:i])
+				vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{Source: "precursors:escalated", Version: strings.Join(tried, " -> ")})
+			}
+			return vector, nil
+		}
+
+		var sce *SanityCheckError
+		if !errors.As(err, &sce) || sce.Field != "gas_used" || i == len(steps)-1 {
+			if i > 0 {
+				return nil, fmt.Errorf("%w (precursors escalated through: %s)", err, strings.Join(tried, " -> "))
+			}
+			return nil, err
+		}
+		extractLog.Warnw("receipt sanity check failed on gas; escalating precursors", "from", s, "to", steps[i+1])
+		x.emit(EventPrecursorsEscalated, "from", s.String(), "to", steps[i+1].String())
+	}
+}
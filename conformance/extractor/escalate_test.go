@@ -0,0 +1,31 @@
+// stm: #unit
+package extractor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrecursorSteps(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     Options
+		expected []string
+	}{
+		{"disabled", Options{Precursor: PrecursorSelectParticipants}, []string{"participants"}},
+		{"participants", Options{Precursor: PrecursorSelectParticipants, EscalatePrecursors: true}, []string{"participants", "all"}},
+		{"capped", Options{Precursor: PrecursorSelectParticipants, MaxPrecursors: 10, EscalatePrecursors: true},
+			[]string{"participants (max 10)", "all (max 10)", "all"}},
+		{"all", Options{Precursor: PrecursorSelectAll, EscalatePrecursors: true}, []string{"all"}},
+		{"ignored", Options{Precursor: PrecursorSelectParticipants, IgnorePrecursors: true, EscalatePrecursors: true}, []string{"participants"}},
+		{"mpool", Options{Precursor: PrecursorSelectParticipants, FromMpool: true, EscalatePrecursors: true}, []string{"participants"}},
+	} {
+		var steps []string
+		for _, s := range precursorSteps(tc.opts) {
+			steps = append(steps, s.String())
+		}
+		if !reflect.DeepEqual(steps, tc.expected) {
+			t.Errorf("%s: expected %v; got %v", tc.name, tc.expected, steps)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+// Package extractor extracts test vectors from a live chain, through the API
+// of a Filecoin node. It powers tvx extract and the TvxExtractMessage method
+// of the node, and can be embedded by other tools to extract vectors without
+// shelling out to tvx.
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	rtt "github.com/filecoin-project/go-state-types/rt"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// extractLog is the leveled, structured logger of the extractions.
+var extractLog = logging.Logger("tvx/extract")
+
+// Progress events emitted during extraction.
+const (
+	EventMessageResolved  = "message_resolved"
+	EventPrecursorApplied = "precursor_applied"
+	EventMessageApplied   = "message_applied"
+	EventTipsetApplied    = "tipset_applied"
+	EventMigrationApplied = "migration_applied"
+	EventBlocksFetched    = "blocks_fetched"
+	// EventPrecursorsEscalated is emitted when a message vector is extracted
+	// again with more precursors; see Options.EscalatePrecursors.
+	EventPrecursorsEscalated = "precursors_escalated"
+)
+
+// Classes of vectors to extract, besides the schema classes (message, tipset,
+// blockseq).
+const (
+	// ClassImplicit is the extraction mode of implicit messages: message
+	// vectors applying the block reward award or the cron tick of a tipset.
+	ClassImplicit = "implicit"
+	// ClassMigration is the extraction mode of network upgrade state
+	// migrations.
+	ClassMigration = string(conformance.ClassMigration)
+)
+
+const (
+	PrecursorSelectAll          = "all"
+	PrecursorSelectParticipants = "participants"
+)
+
+// Options are the options of an extraction.
+type Options struct {
+	// Class is the class of vector to extract: message, tipset, blockseq,
+	// implicit, migration, msig-flow, paych-flow or consensus-fault.
+	Class string
+	// ID is the identifier to name the vector with; if empty, one is
+	// generated.
+	ID string
+	// CID is the CID of the message to extract.
+	CID string
+	// Block is the CID of the block the message was included in, if known,
+	// to avoid scanning the chain.
+	Block string
+	// TSK is the tipset key to extract, or range of tipsets in tsk1..tsk2
+	// form; for implicit messages, the tipset whose execution emits them.
+	TSK string
+	// Retain is the state retention policy: accessed-cids or
+	// accessed-actors.
+	Retain string
+	// RetainActors are actors whose entries and state heads to retain in the
+	// pre-state of message vectors besides those the message accesses, for
+	// tools inspecting the vector to find them.
+	RetainActors []address.Address
+	// Precursor is the precursor selection mode: all or participants.
+	Precursor string
+	// EmbedPrecursors applies the precursors as messages of the vector,
+	// instead of squashing them into its pre-state.
+	EmbedPrecursors bool
+	// IgnorePrecursors applies no precursors.
+	IgnorePrecursors bool
+	// EscalatePrecursors extracts message vectors whose receipt sanity check
+	// fails on gas again, with the 'all' precursor selection mode, then with
+	// every preceding message of the tipset, before giving up. The
+	// configuration the vector was extracted with is recorded in its
+	// metadata.
+	EscalatePrecursors bool
+	// PreRoot, if set, is the CID of the state root to apply the message of a
+	// message vector on, instead of the parent state of its inclusion tipset,
+	// to construct what-if vectors. The state is taken as is: neither
+	// precursors nor the cron ticks of preceding null rounds are applied, and
+	// the receipt on chain isn't sanity checked against.
+	PreRoot string
+	// FromMpool takes the message of a message vector from the message pool
+	// of the node, pending, rather than from the chain, and applies it
+	// speculatively on the state of the head, as if included in the head
+	// tipset after its messages, and the pending messages of its sender with
+	// lower nonces, which are precursors. The vector is tagged TagSpeculative.
+	FromMpool bool
+	// Message, if set, is the message of a message vector, supplied rather
+	// than taken from the chain or the message pool, e.g. produced by a
+	// wallet or SDK under test. Like a message from the message pool, it's
+	// applied speculatively, on the state of the tipset of TSK, or the head,
+	// as if included in the tipset after its messages. The vector is tagged
+	// TagSpeculative.
+	Message *types.Message
+	// Light extracts a light message vector, carrying the message, the
+	// epoch, basefee and circulating supply it was applied with, and its
+	// receipt on chain, but no state; see conformance.SelectorLight.
+	Light bool
+	// MaxPrecursors is the maximum number of precursors to apply, besides
+	// those of the sender of the message; 0 applies all.
+	MaxPrecursors int
+	// PrecursorLookback is the number of tipsets preceding the inclusion
+	// tipset of a message vector to search, along with it, for the messages
+	// of its sender missing from the PreRoot override, if the nonce of the
+	// sender there isn't that the message follows; those found are applied
+	// as precursors first. The decision is recorded in the metadata; see
+	// PrecursorLookbackSource. Only the messages of the sender are applied,
+	// not the rest of those tipsets. It doesn't apply without PreRoot: the
+	// parent state of the inclusion tipset includes the prior tipsets. 0
+	// searches none.
+	PrecursorLookback int
+	// Implicit is the implicit message to extract: cron or reward.
+	Implicit string
+	// Miner is the miner whose block reward to extract; the miner of the
+	// first block if empty.
+	Miner string
+	// Epoch is the epoch of the network upgrade whose migration to extract.
+	Epoch int64
+	// Squash squashes a range of tipsets into a single vector.
+	Squash bool
+	// StateCompute records, in tipset and blockseq vectors, the execution of
+	// the tipsets by the node, through StateCompute, rather than the local
+	// one: their receipts, receipts roots, post state roots, and the call
+	// trees of their messages, which are asserted. The tipsets are still
+	// executed locally, to gather the state they access into the CAR;
+	// divergences from the node are logged, and recorded in the metadata.
+	StateCompute bool
+	// MsigLookback is the number of epochs before a multisig approval to
+	// search for its proposal in.
+	MsigLookback int64
+	// Paych is the address of the payment channel whose lifecycle to
+	// extract.
+	Paych string
+	// PaychLookback is the number of epochs before the head to search for
+	// messages to the payment channel in.
+	PaychLookback int64
+	// Selectors are the selectors to pass to the driver, and add to the
+	// vector, in key=value form.
+	Selectors []string
+	// Hints are the hints to add to the vector.
+	Hints []string
+	// Tags are the tags to add to the metadata of the vector; see
+	// conformance.ValidateTag for their format.
+	Tags []string
+	// RecordSyscalls records the outcomes of the syscalls the message
+	// invokes into the vector.
+	RecordSyscalls bool
+	// RecordTraces records the call trees of the messages of message vectors
+	// into the vector, for runners to assert; see
+	// conformance.SelectorExpectedTraces.
+	RecordTraces bool
+	// RecordTime records the time the vectors are extracted at in
+	// their metadata; see GenExtractedAt. It's off by default, as it makes
+	// the vectors of repeated extractions differ.
+	RecordTime bool
+	// Assert are the options of the receipt sanity check.
+	Assert conformance.AssertOpts
+	// IgnoreSanityChecks proceeds when sanity checks fail.
+	IgnoreSanityChecks bool
+	// Force proceeds when sanity checks fail, documenting the locally
+	// observed behaviour.
+	Force bool
+	// Prefetch is the maximum number of linked blocks to prefetch along with
+	// every block fetched from the node; see NewProxyingStores.
+	Prefetch int
+	// Warmup replays message vectors on the node before extracting them, and
+	// fetches the state their trace predicts the local execution to read, in
+	// bulk, ahead of it: the state of the actors involved, and the lookback
+	// tipsets; see PlanDependencies.
+	Warmup bool
+	// Preflight is the pre-flight check mode: PreflightOff, PreflightWarn or
+	// PreflightRefuse. The checks assert that the node is synced past the
+	// execution tipset of the extraction, and that the latter is final with
+	// the requested Confidence; their outcome is recorded in the vector.
+	Preflight string
+	// Confidence is the number of epochs the execution tipset must be behind
+	// the head to be deemed final; see DefaultConfidence.
+	Confidence abi.ChainEpoch
+	// MaxCARSize is the maximum size, uncompressed, of the CAR of the state
+	// a vector retains; extractions exceeding it fail with ErrCARTooLarge.
+	// 0 for no limit.
+	MaxCARSize int64
+	// SelfCheck executes every vector extracted from scratch before returning
+	// it, against a fresh blockstore holding only the blocks of its CAR, and
+	// fails the extraction with ErrSelfCheckFailed if it doesn't execute, or
+	// disagrees with its postconditions.
+	SelfCheck bool
+	// CustomActors are registered in the VM alongside the built-in actors, to
+	// extract vectors exercising actors being prototyped; see
+	// conformance.DriverOpts.CustomActors. Runners register them too, through
+	// the same option, to execute the vectors.
+	CustomActors []rtt.VMActor
+
+	// Hooks are the optional hooks of the extraction.
+	Hooks Hooks
+}
+
+// hasHint returns whether the supplied hint was requested.
+func (o Options) hasHint(hint string) bool {
+	for _, h := range o.Hints {
+		if h == hint {
+			return true
+		}
+	}
+	return false
+}
+
+// Multiple returns whether the extraction yields a vector per tipset of a
+// range, rather than a single vector.
+func (o Options) Multiple() bool {
+	return (o.Class == string(schema.ClassTipset) || o.Class == string(schema.ClassBlockSeq)) &&
+		strings.Contains(o.TSK, "..") && !o.Squash
+}
+
+// Hooks are optional functions invoked during an extraction, for callers to
+// report on it, or to supply state shared across extractions.
+type Hooks struct {
+	// OnEvent is invoked on the progress events, with their fields in
+	// key-value pairs.
+	OnEvent func(event string, kvs ...interface{})
+	// OnSanityCheck is invoked with the outcome of every sanity check.
+	OnSanityCheck func(passed bool)
+	// OnCARWritten is invoked with the size of every compressed CAR written
+	// into a vector.
+	OnCARWritten func(size int)
+	// OnStateRetained is invoked with the state retained with 'accessed-cids'
+	// retention.
+	OnStateRetained func(ctx context.Context, bs blockstore.Blockstore, accessed AccessSet)
+	// LocateMessage locates the execution tipset of a message without
+	// searching the chain, e.g. in an index; ok is false if unknown.
+	LocateMessage func(ctx context.Context, c cid.Cid) (execution types.TipSetKey, ok bool, err error)
+	// Stores returns the stores, and the state surgeon over them, to extract
+	// a message vector at the epoch with, e.g. to share them across
+	// extractions; fresh proxying stores are used if nil.
+	Stores func(ctx context.Context, epoch abi.ChainEpoch) (*Stores, *StateSurgeon)
+}
+
+// Extract extracts a vector of the class requested in the options, through
+// the supplied node.
+func Extract(ctx context.Context, api v0api.FullNode, opts Options) (*schema.TestVector, error) {
+	vectors, err := ExtractAll(ctx, api, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("extraction yielded %d vectors; use ExtractAll", len(vectors))
+	}
+	return vectors[0], nil
+}
+
+// ExtractAll extracts the vectors of the class requested in the options,
+// through the supplied node: one vector per tipset of a range, unless
+// squashed, and a single vector otherwise.
+func ExtractAll(ctx context.Context, api v0api.FullNode, opts Options) ([]*schema.TestVector, error) {
+	if err := conformance.ValidateTags(opts.Tags); err != nil {
+		return nil, err
+	}
+	x := &extraction{
+		api:   api,
+		opts:  opts,
+		addrs: make(map[address.Address]address.Address),
+	}
+	vectors, err := x.extractAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the network is declared for runners to format addresses with its
+	// prefix, and to refuse vectors of networks they're not configured for.
+	ntwkName, err := api.StateNetworkName(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve network name: %w", err)
+	}
+	manifests := make(map[network.Version]cid.Cid)
+	extractedAt := time.Now().UTC().Format(time.RFC3339)
+	for _, v := range vectors {
+		if opts.RecordTime {
+			v.Meta.Gen = append(v.Meta.Gen, schema.GenerationData{Source: GenExtractedAt, Version: extractedAt})
+		}
+		// light vectors carry no CAR.
+		if !conformance.IsLightVector(v) {
+			gen, err := carSizeGen(v.CAR)
+			if err != nil {
+				return nil, err
+			}
+			v.Meta.Gen = append(v.Meta.Gen, gen...)
+		}
+		v.Meta.Tags = conformance.MergeTags(v.Meta.Tags, opts.Tags...)
+		if v.Selector == nil {
+			v.Selector = make(schema.Selector)
+		}
+		if _, ok := v.Selector[conformance.SelectorNetwork]; !ok {
+			v.Selector[conformance.SelectorNetwork] = string(ntwkName)
+		}
+		if err := x.recordRequirements(ctx, v, manifests); err != nil {
+			return nil, err
+		}
+		if opts.SelfCheck {
+			if err := selfCheck(ctx, v, opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return vectors, nil
+}
+
+// extractAll extracts the vectors of the class requested in the options.
+func (x *extraction) extractAll(ctx context.Context) ([]*schema.TestVector, error) {
+	opts := x.opts
+	one := func(v *schema.TestVector, err error) ([]*schema.TestVector, error) {
+		if err != nil {
+			return nil, err
+		}
+		return []*schema.TestVector{v}, nil
+	}
+	if opts.PreRoot != "" && opts.Class != string(schema.ClassMessage) {
+		return nil, fmt.Errorf("a pre-state root is only supported for message vectors")
+	}
+	if opts.FromMpool && opts.Class != string(schema.ClassMessage) {
+		return nil, fmt.Errorf("extraction from the message pool is only supported for message vectors")
+	}
+	if opts.Message != nil && opts.Class != string(schema.ClassMessage) && opts.Class != ClassConsensusFault {
+		return nil, fmt.Errorf("supplied messages are only supported for message and consensus-fault vectors")
+	}
+	if opts.StateCompute && opts.Class != string(schema.ClassTipset) && opts.Class != string(schema.ClassBlockSeq) {
+		return nil, fmt.Errorf("state computation by the node is only supported for tipset and blockseq vectors")
+	}
+	if opts.Light && opts.Class != string(schema.ClassMessage) {
+		return nil, fmt.Errorf("light vectors are only supported for message vectors")
+	}
+	switch opts.Class {
+	case string(schema.ClassMessage):
+		if opts.Light {
+			return one(x.lightMessage(ctx))
+		}
+		return one(x.escalatingMessage(ctx))
+	case string(schema.ClassTipset), string(schema.ClassBlockSeq):
+		return x.tipsets(ctx)
+	case ClassImplicit:
+		return one(x.implicit(ctx))
+	case ClassMigration:
+		return one(x.migration(ctx))
+	case ClassMsigFlow:
+		return one(x.msigFlow(ctx))
+	case ClassPaychFlow:
+		return one(x.paychFlow(ctx))
+	case ClassConsensusFault:
+		return one(x.consensusFault(ctx))
+	default:
+		return nil, fmt.Errorf("unsupported vector class")
+	}
+}
+
+// extraction is the state of an extraction.
+type extraction struct {
+	api  v0api.FullNode
+	opts Options
+
+	// addrs caches the resolution of addresses to ID addresses.
+	addrs map[address.Address]address.Address
+	// fault is the evidence of the consensus fault reported by the message
+	// extracted, in consensus-fault extractions, stored along its state.
+	fault *faultEvidence
+}
+
+// emit emits a progress event, with its fields in key-value pairs.
+func (x *extraction) emit(event string, kvs ...interface{}) {
+	if x.opts.Hooks.OnEvent != nil {
+		x.opts.Hooks.OnEvent(event, kvs...)
+	}
+}
+
+// sanityChecked reports the outcome of a sanity check.
+func (x *extraction) sanityChecked(passed bool) {
+	if x.opts.Hooks.OnSanityCheck != nil {
+		x.opts.Hooks.OnSanityCheck(passed)
+	}
+}
+
+// stateRetained reports the state retained with 'accessed-cids' retention.
+func (x *extraction) stateRetained(ctx context.Context, bs blockstore.Blockstore, accessed AccessSet) {
+	if x.opts.Hooks.OnStateRetained != nil {
+		x.opts.Hooks.OnStateRetained(ctx, bs, accessed)
+	}
+}
+
+// ErrCARTooLarge is returned by extractions whose CAR exceeds
+// Options.MaxCARSize.
+var ErrCARTooLarge = errors.New("CAR too large")
+
+// encodeCAR encodes the CAR written by write, as EncodeCAR does, and reports
+// its size; it fails if the CAR exceeds the maximum size.
+func (x *extraction) encodeCAR(write func(w io.Writer) error) ([]byte, error) {
+	b, size, err := encodeCanonicalCAR(write)
+	if err != nil {
+		return nil, err
+	}
+	if max := x.opts.MaxCARSize; max > 0 && int64(size) > max {
+		return nil, fmt.Errorf("%w: the retained state is %d bytes (%d compressed), over the limit of %d bytes; %s",
+			ErrCARTooLarge, size, len(b), max, x.carSizeAdvice())
+	}
+	if x.opts.Hooks.OnCARWritten != nil {
+		x.opts.Hooks.OnCARWritten(len(b))
+	}
+	return b, nil
+}
+
+// carSizeAdvice suggests how to reduce the state retained by the extraction.
+func (x *extraction) carSizeAdvice() string {
+	switch x.opts.Class {
+	case string(schema.ClassMessage), ClassMsigFlow, ClassPaychFlow, ClassConsensusFault:
+		if x.opts.Retain != "accessed-cids" {
+			return "retain the accessed CIDs only, with --state-retain accessed-cids"
+		}
+		return "apply fewer precursors, with --max-precursors or --ignore-precursors, or raise the limit"
+	case string(schema.ClassTipset), string(schema.ClassBlockSeq):
+		return "slice the range into fewer tipsets per vector, e.g. without --squash, or raise the limit"
+	default:
+		return "raise the limit"
+	}
+}
+
+// GenExtractedAt is the source of the generation data entry recording the
+// time a vector was extracted at, in RFC 3339 format, in UTC, if
+// Options.RecordTime is set.
+const GenExtractedAt = "extracted_at"
+
+// carSizeGen returns the generation data entries recording the compressed
+// and uncompressed sizes of the CAR of a vector, for corpus checks to assert.
+func carSizeGen(gz []byte) ([]schema.GenerationData, error) {
+	compressed, uncompressed, err := CARSizes(gz)
+	if err != nil {
+		return nil, err
+	}
+	return []schema.GenerationData{
+		{Source: "car_size:gzip", Version: strconv.FormatInt(compressed, 10)},
+		{Source: "car_size:uncompressed", Version: strconv.FormatInt(uncompressed, 10)},
+	}, nil
+}
+
+// newStores returns fresh proxying stores, and the state surgeon over them.
+func (x *extraction) newStores(ctx context.Context) (*Stores, *StateSurgeon) {
+	pst := newProxyingStores(ctx, x.api, x.opts.Prefetch, x.emit)
+	return pst, NewSurgeon(ctx, x.api, pst)
+}
+
+// stores returns the stores, and the state surgeon over them, to extract a
+// vector at the epoch with.
+func (x *extraction) stores(ctx context.Context, epoch abi.ChainEpoch) (*Stores, *StateSurgeon) {
+	if x.opts.Hooks.Stores != nil {
+		return x.opts.Hooks.Stores(ctx, epoch)
+	}
+	return x.newStores(ctx)
+}
@@ -0,0 +1,370 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+const (
+	ImplicitCron   = "cron"
+	ImplicitReward = "reward"
+)
+
+// doExtractImplicit extracts a vector for one of the implicit messages that
+// the system actor applies when executing a tipset: the block reward award
+// for one of its miners, or the cron tick at the end of the epoch.
+//
+// To isolate the implicit message, the tipset is replayed locally in the same
+// order the tipset executor uses (explicit messages of each block followed by
+// its reward, and cron at the end), and only the target message is traced.
+func (x *extraction) implicit(ctx context.Context) (*schema.TestVector, error) {
+	opts := x.opts
+
+	if opts.TSK == "" {
+		return nil, fmt.Errorf("tipset key cannot be empty")
+	}
+
+	if opts.Retain != "accessed-cids" {
+		return nil, fmt.Errorf("implicit message extraction only supports 'accessed-cids' state retention")
+	}
+
+	selector, err := ParseSelectors(opts.Selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	var miner address.Address
+	switch opts.Implicit {
+	case ImplicitCron:
+	case ImplicitReward:
+		if opts.Miner != "" {
+			if miner, err = address.NewFromString(opts.Miner); err != nil {
+				return nil, fmt.Errorf("failed to parse miner address: %w", err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported implicit message: %s", opts.Implicit)
+	}
+
+	ts, err := lcli.ParseTipSetRef(ctx, x.api, opts.TSK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tipset: %w", err)
+	}
+
+	if opts.Implicit == ImplicitReward && miner == address.Undef {
+		miner = ts.Blocks()[0].Miner
+		extractLog.Infow("no miner supplied; extracting the reward of the first block's miner", "miner", miner)
+	}
+
+	parentTs, err := x.api.ChainGetTipSet(ctx, ts.Parents())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch parent tipset: %w", err)
+	}
+
+	// the tipset whose parent state is the result of executing this tipset.
+	execTs, err := FindExecutionTipset(ctx, x.api, ts.Height())
+	if err != nil {
+		return nil, err
+	}
+	preflight, err := x.preflight(ctx, execTs)
+	if err != nil {
+		return nil, err
+	}
+
+	nv, err := x.api.StateNetworkVersion(ctx, ts.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve network version: %w", err)
+	}
+
+	circSupplyDetail, err := x.api.StateVMCirculatingSupplyInternal(ctx, ts.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed while fetching circulating supply: %w", err)
+	}
+
+	var recording *conformance.SyscallRecording
+	if opts.RecordSyscalls {
+		recording = conformance.NewSyscallRecording()
+	}
+
+	var (
+		pst, g = x.newStores(ctx)
+		driver = conformance.NewDriver(ctx, selector, conformance.DriverOpts{
+			DisableVMFlush: true,
+			CustomActors:   x.opts.CustomActors,
+			RecordSyscalls: recording,
+		})
+
+		root    = ts.ParentState()
+		basefee = ts.Blocks()[0].ParentBaseFee
+		epoch   = ts.Height()
+
+		// recordingRand will record randomness so we can embed it in the test
+		// vector; other executions use a throwaway instance.
+		recordingRand = conformance.NewRecordingRand(new(conformance.LogReporter), x.api)
+
+		// tipsets records the ancestor tipsets looked up by the target, whose
+		// headers are included in the CAR.
+		tipsets   = conformance.NewTipSetRecorder(x.tipSetGetter(ts))
+		ancestors *conformance.AncestorTipSets
+
+		target   *types.Message
+		applyret *vm.ApplyRet
+		preroot  cid.Cid
+		postroot cid.Cid
+		accessed AccessSet
+	)
+
+	tbs, ok := pst.Blockstore.(TracingBlockstore)
+	if !ok {
+		return nil, fmt.Errorf("%w: requested 'accessed-cids' state retention, but no tracing blockstore was present", ErrRetentionUnsupported)
+	}
+
+	params := func(m *types.Message, epoch abi.ChainEpoch) conformance.ExecuteMessageParams {
+		return conformance.ExecuteMessageParams{
+			Preroot:        root,
+			Epoch:          epoch,
+			Message:        m,
+			CircSupply:     circSupplyDetail.FilCirculating,
+			BaseFee:        basefee,
+			Rand:           conformance.NewRecordingRand(new(conformance.LogReporter), x.api),
+			NetworkVersion: nv,
+			TipSetGetter:   x.tipSetGetter(ts),
+		}
+	}
+
+	// applyImplicit applies an implicit message, tracing it if it's the target.
+	applyImplicit := func(m *types.Message, isTarget bool) error {
+		p := params(m, epoch)
+		if !isTarget {
+			ret, r, err := driver.ExecuteImplicitMessage(pst.Blockstore, p)
+			if err != nil {
+				return err
+			}
+			if ret.ExitCode != 0 {
+				return fmt.Errorf("implicit message to %s failed (exit %d): %s", m.To, ret.ExitCode, ret.ActorErr)
+			}
+			root = r
+			return nil
+		}
+
+		extractLog.Infow("applying requested implicit message", "cid", m.Cid())
+		p.Rand, p.TipSetGetter = recordingRand, tipsets.GetTipSet
+		preroot, target = root, m
+
+		accessed, err = tbs.Trace(func(bs blockstore.Blockstore) (err error) {
+			if applyret, postroot, err = driver.ExecuteImplicitMessage(bs, p); err != nil {
+				return err
+			}
+			ancestors = x.recordAncestorHeaders(ctx, bs, tipsets.Recorded())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		x.stateRetained(ctx, pst.Blockstore, accessed)
+		root = postroot
+		return nil
+	}
+
+	// run cron for the null rounds preceding this tipset.
+	nulls := nullRounds(parentTs, ts)
+	for _, e := range nulls {
+		p := params(conformance.NewCronMessage(e), e)
+		if _, root, err = driver.ExecuteImplicitMessage(pst.Blockstore, p); err != nil {
+			return nil, fmt.Errorf("failed to apply cron for null round %d: %w", e, err)
+		}
+	}
+
+	processed := make(map[cid.Cid]struct{})
+	for _, b := range ts.Blocks() {
+		msgs, err := x.api.ChainGetBlockMessages(ctx, b.Cid())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get block messages (cid: %s): %w", b.Cid(), err)
+		}
+
+		all := append([]*types.Message{}, msgs.BlsMessages...)
+		for _, m := range msgs.SecpkMessages {
+			all = append(all, m.VMMessage())
+		}
+
+		extractLog.Infow("applying block messages", "count", len(all), "block", b.Cid(), "miner", b.Miner)
+
+		penalty, gasReward := big.Zero(), big.Zero()
+		for _, m := range all {
+			if _, ok := processed[m.Cid()]; ok {
+				continue
+			}
+			processed[m.Cid()] = struct{}{}
+
+			ret, r, err := driver.ExecuteMessage(pst.Blockstore, params(m, epoch))
+			if err != nil {
+				return nil, fmt.Errorf("failed to execute message %s: %w", m.Cid(), err)
+			}
+			root = r
+			gasReward = big.Add(gasReward, ret.GasCosts.MinerTip)
+			penalty = big.Add(penalty, ret.GasCosts.MinerPenalty)
+		}
+
+		rwMsg, err := conformance.NewRewardMessage(epoch, b.Miner, penalty, gasReward, b.ElectionProof.WinCount)
+		if err != nil {
+			return nil, err
+		}
+		isTarget := opts.Implicit == ImplicitReward && b.Miner == miner
+		if err := applyImplicit(rwMsg, isTarget); err != nil {
+			return nil, fmt.Errorf("failed to apply reward message for miner %s: %w", b.Miner, err)
+		}
+	}
+
+	if err := applyImplicit(conformance.NewCronMessage(epoch), opts.Implicit == ImplicitCron); err != nil {
+		return nil, fmt.Errorf("failed to apply cron: %w", err)
+	}
+
+	if target == nil {
+		return nil, fmt.Errorf("no block mined by %s in tipset %s", miner, ts.Key())
+	}
+
+	extractLog.Infow("implicit message applied", "preroot", preroot, "postroot", postroot)
+	x.emit(EventMessageApplied, "implicit", opts.Implicit, "preroot", preroot.String(), "postroot", postroot.String())
+
+	// sanity check: the state after replaying the whole tipset must match the
+	// parent state of the execution tipset.
+	expected := execTs.ParentState()
+	x.sanityChecked(root == expected)
+	if root != expected {
+		extractLog.Errorw("tipset replay sanity check failed", "expected_root", expected, "root", root)
+		if !opts.IgnoreSanityChecks && !opts.Force && !opts.hasHint(schema.HintIncorrect) {
+			return nil, &SanityCheckError{Field: "post_state_root", Detail: fmt.Sprintf("expected %s, got %s", expected, root)}
+		}
+		extractLog.Warnw("proceeding anyway")
+	} else {
+		extractLog.Infow("tipset replay sanity check succeeded")
+	}
+
+	msgBytes, err := target.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	recordingCid, err := storeSyscallRecording(ctx, recording, pst.Blockstore)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestorsCid, err := storeAncestorTipSets(ctx, ancestors, pst.Blockstore)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := []cid.Cid{preroot, postroot}
+	if recordingCid.Defined() {
+		accessed[recordingCid] = AccessStats{}
+		roots = append(roots, recordingCid)
+	}
+	if ancestorsCid.Defined() {
+		accessed[ancestorsCid] = AccessStats{}
+		roots = append(roots, ancestorsCid)
+	}
+
+	carBytes, err := x.encodeCAR(func(w io.Writer) error {
+		return g.WriteCARIncluding(w, accessed, roots...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := x.api.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ntwkName, err := x.api.StateNetworkName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	codename := GetProtocolCodename(epoch)
+
+	code := x.receiverCode(ctx, target, execTs.Key())
+	if opts.ID == "" {
+		opts.ID = messageVectorID(code, target, applyret.ExitCode)
+	}
+
+	implicit := opts.Implicit
+	if implicit == ImplicitReward {
+		implicit = fmt.Sprintf("%s:%s", implicit, miner)
+	}
+
+	vector := schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta: &schema.Metadata{
+			ID: opts.ID,
+			Gen: []schema.GenerationData{
+				{Source: fmt.Sprintf("network:%s", ntwkName)},
+				{Source: fmt.Sprintf("implicit:%s", implicit)},
+				{Source: fmt.Sprintf("tipset:%s", ts.Key().String())},
+				{Source: fmt.Sprintf("execution_tipset:%s", execTs.Key().String())},
+				{Source: "github.com/filecoin-project/lotus", Version: version.String()}},
+		},
+		Selector: schema.Selector{
+			schema.SelectorMinProtocolVersion:    codename,
+			conformance.SelectorImplicitMessages: "true",
+		},
+		Randomness: recordingRand.Recorded(),
+		CAR:        carBytes,
+		Pre: &schema.Preconditions{
+			Variants: []schema.Variant{
+				{ID: codename, Epoch: int64(epoch), NetworkVersion: uint(nv)},
+			},
+			CircSupply: circSupplyDetail.FilCirculating.Int,
+			BaseFee:    basefee.Int,
+			StateTree: &schema.StateTree{
+				RootCID: preroot,
+			},
+		},
+		ApplyMessages: []schema.Message{{Bytes: msgBytes}},
+		Post: &schema.Postconditions{
+			StateTree: &schema.StateTree{
+				RootCID: postroot,
+			},
+			Receipts: []*schema.Receipt{
+				{
+					ExitCode:    int64(applyret.ExitCode),
+					ReturnValue: applyret.Return,
+					GasUsed:     applyret.GasUsed,
+				},
+			},
+		},
+	}
+	vector.Meta.Gen = append(vector.Meta.Gen, receiverGen(code, target.Method)...)
+	vector.Meta.Gen = append(vector.Meta.Gen, preflight...)
+	if len(nulls) > 0 {
+		// the cron ticks for these epochs are already applied in the preroot.
+		vector.Selector[conformance.SelectorNullRounds] = conformance.FormatNullRounds(nulls)
+	}
+
+	PopulateSelector(&vector, selector, applyret)
+	if recordingCid.Defined() {
+		vector.Selector[conformance.SelectorRecordedSyscalls] = recordingCid.String()
+		dropProofParams(&vector, selector)
+	}
+	if ancestorsCid.Defined() {
+		vector.Selector[conformance.SelectorAncestorHeaders] = ancestorsCid.String()
+	}
+	vector.Hints = opts.Hints
+
+	return &vector, nil
+}
@@ -0,0 +1,145 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// lightMessage extracts a light message vector: the message on chain, with
+// the epoch, network version, basefee and circulating supply it was applied
+// with, and its receipt on chain, but no state; see conformance.SelectorLight.
+// The message isn't executed, nor its state fetched, so that light vectors
+// are cheap to extract in bulk; the pre-state root is that on chain, for
+// implementations to resolve in their synced state.
+func (x *extraction) lightMessage(ctx context.Context) (*schema.TestVector, error) {
+	opts := x.opts
+	switch {
+	case opts.PreRoot != "" || opts.FromMpool || opts.Message != nil:
+		return nil, fmt.Errorf("light vectors are only extracted for messages on chain, on the state on chain")
+	case opts.EmbedPrecursors || opts.RecordSyscalls || opts.RecordTraces:
+		return nil, fmt.Errorf("light vectors can't embed precursors, syscalls or traces; they carry no state")
+	case opts.CID == "":
+		return nil, fmt.Errorf("missing message CID")
+	}
+	mcid, err := cid.Decode(opts.CID)
+	if err != nil {
+		return nil, err
+	}
+
+	selector, err := ParseSelectors(opts.Selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, execTs, incTs, err := x.resolveFromChain(ctx, mcid, opts.Block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve message and tipsets from chain: %w", err)
+	}
+	preflight, err := x.preflight(ctx, execTs)
+	if err != nil {
+		return nil, err
+	}
+
+	nv, err := x.api.StateNetworkVersion(ctx, incTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve network version from inclusion height: %w", err)
+	}
+	circSupplyDetail, err := x.api.StateVMCirculatingSupplyInternal(ctx, incTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed while fetching circulating supply: %w", err)
+	}
+	basefee := incTs.Blocks()[0].ParentBaseFee
+
+	x.emit(EventMessageResolved,
+		"cid", mcid.String(),
+		"execution_tipset", execTs.Key().String(),
+		"inclusion_tipset", incTs.Key().String(),
+		"epoch", incTs.Height())
+
+	// the receipt is all a light vector asserts, so it must be found.
+	rec, err := x.api.StateGetReceipt(ctx, mcid, execTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find receipt on chain: %w", err)
+	}
+	var missing *missingReceipt
+	if rec == nil {
+		m := locateMissingReceipt(ctx, x.api, mcid, incTs, execTs)
+		if m.receipt == nil {
+			return nil, fmt.Errorf("the receipt of message %s is missing on chain (%s); light vectors require it", mcid, m.reason)
+		}
+		extractLog.Warnw("got back a nil receipt from lotus; looked it up", "reason", m.reason, "source", m.source)
+		rec, missing = m.receipt, &m
+	}
+	extractLog.Infow("found receipt", "receipt", rec)
+
+	msgBytes, err := msg.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	version, err := x.api.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ntwkName, err := x.api.StateNetworkName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	codename := GetProtocolCodename(execTs.Height())
+	code := x.receiverCode(ctx, msg, execTs.Key())
+	if opts.ID == "" {
+		opts.ID = messageVectorID(code, msg, rec.ExitCode)
+	}
+
+	vector := schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta: &schema.Metadata{
+			ID: opts.ID,
+			Gen: []schema.GenerationData{
+				{Source: fmt.Sprintf("network:%s", ntwkName)},
+				{Source: fmt.Sprintf("message:%s", msg.Cid().String())},
+				{Source: fmt.Sprintf("inclusion_tipset:%s", incTs.Key().String())},
+				{Source: fmt.Sprintf("execution_tipset:%s", execTs.Key().String())},
+				{Source: "github.com/filecoin-project/lotus", Version: version.String()},
+			},
+		},
+		Selector: schema.Selector{
+			schema.SelectorMinProtocolVersion: codename,
+			conformance.SelectorLight:         "true",
+		},
+		Pre: &schema.Preconditions{
+			Variants: []schema.Variant{
+				{ID: codename, Epoch: int64(incTs.Height()), NetworkVersion: uint(nv)},
+			},
+			CircSupply: circSupplyDetail.FilCirculating.Int,
+			BaseFee:    basefee.Int,
+			StateTree: &schema.StateTree{
+				RootCID: incTs.ParentState(),
+			},
+		},
+		ApplyMessages: []schema.Message{{Bytes: msgBytes}},
+		Post: &schema.Postconditions{
+			Receipts: []*schema.Receipt{{
+				ExitCode:    int64(rec.ExitCode),
+				ReturnValue: rec.Return,
+				GasUsed:     rec.GasUsed,
+			}},
+		},
+	}
+	vector.Meta.Gen = append(vector.Meta.Gen, receiverGen(code, msg.Method)...)
+	vector.Meta.Gen = append(vector.Meta.Gen, preflight...)
+	if missing != nil {
+		vector.Meta.Gen = append(vector.Meta.Gen, missing.gen()...)
+	}
+	vector.Hints = opts.Hints
+	PopulateSelector(&vector, selector)
+
+	extractLog.Infow("generated light vector", "cid", mcid, "exit_code", rec.ExitCode)
+	return &vector, nil
+}
@@ -0,0 +1,226 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// maxBeaconLookback is the number of tipsets searched back for a beacon
+// entry, as when drawing beacon randomness.
+const maxBeaconLookback = 20
+
+// recordLookback resolves the block headers the recorded randomness was drawn
+// from, on the chain ending at head, and reads them through the blockstore,
+// so that a tracing blockstore includes them in the vector CAR. Headers that
+// can't be resolved are skipped, as the recorded randomness is replayed
+// regardless.
+func (x *extraction) recordLookback(ctx context.Context, bs blockstore.Blockstore, recorded schema.Randomness, head *types.TipSet) []conformance.LookbackEntry {
+	type request struct {
+		kind  schema.RandomnessKind
+		epoch abi.ChainEpoch
+	}
+	seen := make(map[request]struct{})
+	var requests []request
+	for _, m := range recorded {
+		req := request{kind: m.On.Kind, epoch: abi.ChainEpoch(m.On.Epoch)}
+		if _, ok := seen[req]; ok {
+			continue
+		}
+		seen[req] = struct{}{}
+		requests = append(requests, req)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].kind != requests[j].kind {
+			return requests[i].kind < requests[j].kind
+		}
+		return requests[i].epoch < requests[j].epoch
+	})
+
+	var entries []conformance.LookbackEntry
+	for _, req := range requests {
+		e, err := x.resolveLookback(ctx, req.kind, req.epoch, head)
+		if err == nil {
+			_, err = bs.Get(ctx, e.Header)
+		}
+		if err != nil {
+			extractLog.Warnw("failed to resolve lookback header; skipping", "kind", req.kind, "epoch", req.epoch, "error", err)
+			continue
+		}
+		extractLog.Debugw("resolved lookback header", "kind", e.Kind, "epoch", e.Epoch, "header", e.Header)
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// resolveLookback resolves the block header randomness of the kind is drawn
+// from at the epoch, as the state manager does under the network version of
+// the epoch: chain randomness is drawn from the minimum ticket of the tipset
+// at the epoch, and beacon randomness from the beacon entry of the epoch
+// (nv14 onwards), or the latest beacon entry of the tipset at the epoch.
+// Before nv13, the tipsets at null rounds are the preceding ones; from then
+// on, the following ones.
+func (x *extraction) resolveLookback(ctx context.Context, kind schema.RandomnessKind, epoch abi.ChainEpoch, head *types.TipSet) (conformance.LookbackEntry, error) {
+	e := conformance.LookbackEntry{Kind: kind, Epoch: epoch}
+
+	at, err := x.api.ChainGetTipSetByHeight(ctx, nonNegative(epoch), head.Key())
+	if err != nil {
+		return e, err
+	}
+	nv, err := x.api.StateNetworkVersion(ctx, at.Key())
+	if err != nil {
+		return e, err
+	}
+
+	lookback := nv < network.Version13
+	ts, err := x.tipsetForRandomness(ctx, epoch, head, lookback)
+	if err != nil {
+		return e, err
+	}
+
+	switch kind {
+	case schema.RandomnessChain:
+		e.Header = ts.MinTicketBlock().Cid()
+		return e, nil
+
+	case schema.RandomnessBeacon:
+		var round uint64
+		if nv >= network.Version14 && epoch >= 0 {
+			be, err := x.api.BeaconGetEntry(ctx, epoch)
+			if err != nil {
+				return e, fmt.Errorf("failed to get beacon entry: %w", err)
+			}
+			round = be.Round
+		}
+		for i := 0; i < maxBeaconLookback; i++ {
+			blk := ts.Blocks()[0]
+			for _, be := range blk.BeaconEntries {
+				if round == 0 || be.Round == round {
+					// without a round, the latest entry is the last one.
+					e.Header, e.Round = blk.Cid(), be.Round
+				}
+			}
+			if e.Header.Defined() {
+				return e, nil
+			}
+			if ts, err = x.api.ChainGetTipSet(ctx, ts.Parents()); err != nil {
+				return e, err
+			}
+		}
+		return e, fmt.Errorf("no beacon entry found within %d tipsets", maxBeaconLookback)
+
+	default:
+		return e, fmt.Errorf("unknown randomness kind %q", kind)
+	}
+}
+
+// captureBeaconEntries resolves the beacon entries beacon randomness is drawn
+// from at every epoch from..to, null rounds included, on the chain ending at
+// head, as resolveLookback does. Epochs whose entry can't be resolved are
+// skipped, as the driver falls back to the lookback headers and recorded
+// randomness for them.
+func (x *extraction) captureBeaconEntries(ctx context.Context, from, to abi.ChainEpoch, head *types.TipSet) *conformance.BeaconEntries {
+	entries := new(conformance.BeaconEntries)
+	for epoch := from; epoch <= to; epoch++ {
+		e, err := x.resolveLookback(ctx, schema.RandomnessBeacon, epoch, head)
+		if err != nil {
+			extractLog.Warnw("failed to resolve beacon entry; skipping", "epoch", epoch, "error", err)
+			continue
+		}
+		blk, err := x.api.ChainGetBlock(ctx, e.Header)
+		if err != nil {
+			extractLog.Warnw("failed to get block header of beacon entry; skipping", "epoch", epoch, "header", e.Header, "error", err)
+			continue
+		}
+		for _, be := range blk.BeaconEntries {
+			if be.Round == e.Round {
+				entries.Entries = append(entries.Entries, conformance.BeaconEpochEntry{Epoch: epoch, Round: be.Round, Data: be.Data})
+				break
+			}
+		}
+	}
+	extractLog.Debugw("captured beacon entries", "from", from, "to", to, "count", len(entries.Entries))
+	return entries
+}
+
+// tipsetForRandomness returns the tipset at the epoch on the chain ending at
+// head. If the epoch is a null round, it's the preceding tipset if lookback,
+// or the following one otherwise.
+func (x *extraction) tipsetForRandomness(ctx context.Context, epoch abi.ChainEpoch, head *types.TipSet, lookback bool) (*types.TipSet, error) {
+	h := nonNegative(epoch)
+	if h > head.Height() {
+		return nil, fmt.Errorf("cannot draw randomness from the future")
+	}
+	ts, err := x.api.ChainGetTipSetByHeight(ctx, h, head.Key())
+	if err != nil || lookback || ts.Height() == h {
+		return ts, err
+	}
+	for next := h + 1; next <= head.Height(); next++ {
+		if ts, err = x.api.ChainGetTipSetByHeight(ctx, next, head.Key()); err != nil {
+			return nil, err
+		}
+		if ts.Height() > h {
+			return ts, nil
+		}
+	}
+	return head, nil
+}
+
+func nonNegative(epoch abi.ChainEpoch) abi.ChainEpoch {
+	if epoch < 0 {
+		return 0
+	}
+	return epoch
+}
+
+// lookbackGen returns the generation data recording the lookback entries.
+func lookbackGen(entries []conformance.LookbackEntry) []schema.GenerationData {
+	gen := make([]schema.GenerationData, 0, len(entries))
+	for _, e := range entries {
+		gen = append(gen, e.GenerationData())
+	}
+	return gen
+}
+
+// tipSetGetter returns a vm.TipSetGetter looking tipsets up on the chain
+// ending at ts, as the tipset executor does for the VM executing ts.
+func (x *extraction) tipSetGetter(ts *types.TipSet) vm.TipSetGetter {
+	return func(ctx context.Context, epoch abi.ChainEpoch) (types.TipSetKey, error) {
+		at, err := x.api.ChainGetTipSetByHeight(ctx, epoch, ts.Key())
+		if err != nil {
+			return types.EmptyTSK, err
+		}
+		return at.Key(), nil
+	}
+}
+
+// recordAncestorHeaders reads the block headers of the tipsets recorded
+// through the blockstore, so that a tracing blockstore includes them in the
+// vector CAR. Tipsets whose headers can't be read are skipped, and the VM
+// then sees the empty tipset key at their epochs.
+func (x *extraction) recordAncestorHeaders(ctx context.Context, bs blockstore.Blockstore, recorded *conformance.AncestorTipSets) *conformance.AncestorTipSets {
+	ancestors := new(conformance.AncestorTipSets)
+	for _, ts := range recorded.TipSets {
+		var err error
+		for _, c := range ts.Blocks {
+			if _, err = bs.Get(ctx, c); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			extractLog.Warnw("failed to read ancestor tipset headers; skipping", "epoch", ts.Epoch, "error", err)
+			continue
+		}
+		ancestors.TipSets = append(ancestors.TipSets, ts)
+	}
+	return ancestors
+}
@@ -0,0 +1,1050 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	init_ "github.com/filecoin-project/lotus/chain/actors/builtin/init"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/reward"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func (x *extraction) message(ctx context.Context) (*schema.TestVector, error) {
+	opts := x.opts
+
+	var (
+		mcid cid.Cid
+		err  error
+	)
+	switch {
+	case opts.Message != nil && (opts.CID != "" || opts.FromMpool):
+		return nil, fmt.Errorf("a supplied message can't be combined with a message CID or the message pool")
+	case opts.Message != nil:
+		mcid = opts.Message.Cid()
+	case opts.CID == "":
+		return nil, fmt.Errorf("missing message CID")
+	default:
+		if mcid, err = cid.Decode(opts.CID); err != nil {
+			return nil, err
+		}
+	}
+	// messages from the message pool, and supplied ones, aren't on chain:
+	// they're applied speculatively.
+	speculative := opts.FromMpool || opts.Message != nil
+
+	selector, err := ParseSelectors(opts.Selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	preRoot := cid.Undef
+	if opts.PreRoot != "" {
+		if preRoot, err = cid.Decode(opts.PreRoot); err != nil {
+			return nil, fmt.Errorf("invalid pre-state root: %w", err)
+		}
+		if opts.EmbedPrecursors {
+			return nil, fmt.Errorf("a pre-state root can't be combined with embedded precursors")
+		}
+		if speculative {
+			return nil, fmt.Errorf("a pre-state root can't be combined with a message from the message pool, or a supplied one")
+		}
+	}
+
+	var (
+		msg           *types.Message
+		execTs, incTs *types.TipSet
+		// pending are the messages a pending message is applied after, and
+		// the message, in order.
+		pending   []api.Message
+		preflight []schema.GenerationData
+	)
+	switch {
+	case speculative && opts.Retain != "accessed-cids":
+		return nil, fmt.Errorf("%w: extracting a message not on chain requires 'accessed-cids' state retention", ErrRetentionUnsupported)
+	case opts.FromMpool:
+		// the message is applied on the head, which it's deemed included in;
+		// it has no execution tipset, and the head isn't final.
+		if msg, incTs, pending, err = x.resolveFromMpool(ctx, mcid); err != nil {
+			return nil, fmt.Errorf("failed to resolve message from the message pool: %w", err)
+		}
+		execTs = incTs
+	case opts.Message != nil:
+		// likewise, the message is applied on the tipset supplied.
+		if msg, incTs, pending, err = x.resolveSupplied(ctx, opts.Message); err != nil {
+			return nil, fmt.Errorf("failed to resolve the tipset to apply the supplied message on: %w", err)
+		}
+		execTs = incTs
+	default:
+		if msg, execTs, incTs, err = x.resolveFromChain(ctx, mcid, opts.Block); err != nil {
+			return nil, fmt.Errorf("failed to resolve message and tipsets from chain: %w", err)
+		}
+		if preflight, err = x.preflight(ctx, execTs); err != nil {
+			return nil, err
+		}
+	}
+
+	// Assumes that the desired message isn't at the boundary of network versions.
+	// Otherwise this will be inaccurate. But it's such a tiny edge case that
+	// it's not worth spending the time to support boundary messages unless
+	// actually needed.
+	nv, err := x.api.StateNetworkVersion(ctx, incTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve network version from inclusion height: %w", err)
+	}
+
+	// get the circulating supply before the message was executed.
+	circSupplyDetail, err := x.api.StateVMCirculatingSupplyInternal(ctx, incTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed while fetching circulating supply: %w", err)
+	}
+
+	circSupply := circSupplyDetail.FilCirculating
+
+	extractLog.Infow("resolved message",
+		"cid", mcid,
+		"execution_tipset", execTs.Key(),
+		"inclusion_tipset", incTs.Key(),
+		"network_version", nv,
+		"circulating_supply", circSupply)
+	x.emit(EventMessageResolved,
+		"cid", mcid.String(),
+		"execution_tipset", execTs.Key().String(),
+		"inclusion_tipset", incTs.Key().String(),
+		"epoch", incTs.Height())
+	var (
+		precursors []*types.Message
+		skipped    int
+		order      *PrecursorOrder
+	)
+	switch {
+	case speculative:
+		if precursors, skipped, err = x.precursorsAmong(ctx, mcid, msg, pending); err != nil {
+			return nil, err
+		}
+	case !preRoot.Defined():
+		if precursors, skipped, order, err = x.resolvePrecursors(ctx, mcid, msg, execTs); err != nil {
+			return nil, err
+		}
+	}
+
+	// create a read-through store that uses ChainGetObject to fetch unknown
+	// CIDs; within a batch, it's shared with the preceding extractions.
+	pst, g := x.stores(ctx, incTs.Height())
+	if opts.Warmup && !speculative && !preRoot.Defined() {
+		x.warmup(ctx, pst, mcid, incTs)
+	}
+
+	var recording *conformance.SyscallRecording
+	if opts.RecordSyscalls {
+		recording = conformance.NewSyscallRecording()
+	}
+
+	driver := conformance.NewDriver(ctx, selector, conformance.DriverOpts{
+		DisableVMFlush: true,
+		CustomActors:   x.opts.CustomActors,
+		RecordSyscalls: recording,
+	})
+
+	// this is the root of the state tree we start with.
+	root := incTs.ParentState()
+	if preRoot.Defined() {
+		if _, err := state.LoadStateTree(cbor.NewCborStore(pst.Blockstore), preRoot); err != nil {
+			return nil, fmt.Errorf("failed to load pre-state root %s: %w", preRoot, err)
+		}
+		root = preRoot
+	}
+	extractLog.Infow("base state tree", "root", root, "override", preRoot.Defined())
+
+	basefee := incTs.Blocks()[0].ParentBaseFee
+	extractLog.Infow("base fee", "basefee", basefee)
+
+	// the parent state of the inclusion tipset doesn't include the cron ticks
+	// of the null rounds immediately preceding it; those run right before the
+	// messages in the tipset, so we apply them first.
+	parentTs, err := x.api.ChainGetTipSet(ctx, incTs.Parents())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch parent of inclusion tipset: %w", err)
+	}
+
+	var nulls []abi.ChainEpoch
+	if !preRoot.Defined() {
+		nulls = nullRounds(parentTs, incTs)
+	}
+	if len(nulls) > 0 {
+		extractLog.Infow("null rounds before inclusion tipset; applying cron for each", "null_rounds", nulls)
+	}
+	for _, epoch := range nulls {
+		_, root, err = driver.ExecuteImplicitMessage(pst.Blockstore, conformance.ExecuteMessageParams{
+			Preroot:    root,
+			Epoch:      epoch,
+			Message:    conformance.NewCronMessage(epoch),
+			CircSupply: circSupplyDetail.FilCirculating,
+			BaseFee:    basefee,
+			// recorded randomness will be discarded.
+			Rand:           conformance.NewRecordingRand(new(conformance.LogReporter), x.api),
+			NetworkVersion: nv,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply cron for null round %d: %w", epoch, err)
+		}
+	}
+
+	// the messages of the sender missing from a pre-state override, included
+	// in the inclusion tipset or the prior ones, are applied first.
+	var lookbackDecision *precursorLookback
+	if opts.PrecursorLookback > 0 && !preRoot.Defined() {
+		extractLog.Infow("the parent state of the inclusion tipset includes the messages of the prior tipsets; the precursor lookback only applies to pre-state overrides")
+	}
+	if opts.PrecursorLookback > 0 && preRoot.Defined() && !opts.IgnorePrecursors {
+		if lookbackDecision, err = x.lookbackPrecursors(ctx, pst.Blockstore, root, msg, precursors, incTs); err != nil {
+			return nil, fmt.Errorf("failed to look back for precursors: %w", err)
+		}
+		switch d := lookbackDecision; d.decision() {
+		case LookbackApplied:
+			extractLog.Infow("found messages of the sender missing from the base state in the chain; applying them as precursors",
+				"applied", len(d.Applied), "scanned", d.Scanned, "state_nonce", d.StateNonce, "expected_nonce", d.ExpectedNonce)
+			precursors = append(append([]*types.Message(nil), d.Applied...), precursors...)
+		case LookbackIncomplete:
+			extractLog.Warnw("messages of the sender missing from the base state not found in the chain; nonces may be wrong",
+				"scanned", d.Scanned, "state_nonce", d.StateNonce, "expected_nonce", d.ExpectedNonce)
+		}
+	}
+
+	var (
+		preroot   cid.Cid
+		postroot  cid.Cid
+		applyret  *vm.ApplyRet
+		carWriter func(w io.Writer, extraRoots ...cid.Cid) error
+		retention = opts.Retain
+
+		// recordingRand will record randomness so we can embed it in the test vector.
+		recordingRand = conformance.NewRecordingRand(new(conformance.LogReporter), x.api)
+
+		// precursorRets are the results of the precursors, if embedded in the
+		// vector rather than squashed into its pre-state.
+		precursorRets []*vm.ApplyRet
+
+		// lookback are the block headers randomness was drawn from, included
+		// in the CAR; only resolved with 'accessed-cids' state retention.
+		lookback []conformance.LookbackEntry
+
+		// tipsets records the ancestor tipsets looked up by actors, whose
+		// headers are included in the CAR; ancestors are those resolved,
+		// only with 'accessed-cids' state retention.
+		tipsets   = conformance.NewTipSetRecorder(x.tipSetGetter(incTs))
+		ancestors *conformance.AncestorTipSets
+
+		// merged is the number of blocks written by squashed precursors, and
+		// not accessed by the message, merged into the retained state.
+		merged int
+	)
+
+	tbs, tracing := pst.Blockstore.(TracingBlockstore)
+	if opts.EmbedPrecursors && (retention != "accessed-cids" || !tracing) {
+		return nil, fmt.Errorf("%w: embedding precursors requires 'accessed-cids' state retention", ErrRetentionUnsupported)
+	}
+
+	// applyPrecursors applies all precursors on top of the state tree.
+	applyPrecursors := func(bs blockstore.Blockstore) error {
+		extractLog.Infow("applying precursors", "count", len(precursors), "embedded", opts.EmbedPrecursors)
+		for i, m := range precursors {
+			extractLog.Debugw("applying precursor", "index", i, "cid", m.Cid())
+			// randomness drawn by squashed precursors will be discarded.
+			rand := conformance.NewRecordingRand(new(conformance.LogReporter), x.api)
+			getter := x.tipSetGetter(incTs)
+			if opts.EmbedPrecursors {
+				rand, getter = recordingRand, tipsets.GetTipSet
+			}
+			ret, newRoot, err := driver.ExecuteMessage(bs, conformance.ExecuteMessageParams{
+				Preroot:        root,
+				Epoch:          incTs.Height(),
+				Message:        m,
+				CircSupply:     circSupplyDetail.FilCirculating,
+				BaseFee:        basefee,
+				Rand:           rand,
+				NetworkVersion: nv,
+				TipSetGetter:   getter,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to execute precursor message: %w", err)
+			}
+			root = newRoot
+			precursorRets = append(precursorRets, ret)
+			x.emit(EventPrecursorApplied, "index", i, "total", len(precursors), "cid", m.Cid().String())
+		}
+		return nil
+	}
+
+	// unless embedded, the precursors are squashed into the pre-state. With
+	// 'accessed-cids' state retention, the blocks they write are traced, and
+	// merged into the CAR: the target may depend on them without reading them
+	// through the tracing scope, and they're missing from the chain state.
+	var precursorWrites AccessSet
+	switch {
+	case opts.EmbedPrecursors:
+	case retention == "accessed-cids" && tracing && len(precursors) > 0:
+		traced, err := tbs.Trace(applyPrecursors)
+		if err != nil {
+			return nil, err
+		}
+		precursorWrites = traced.Written()
+	default:
+		if err := applyPrecursors(pst.Blockstore); err != nil {
+			return nil, err
+		}
+	}
+
+	extractLog.Infow("applying requested message", "cid", msg.Cid(), "retention", retention)
+	switch retention {
+	case "accessed-cids":
+		if !tracing {
+			return nil, fmt.Errorf("%w: requested 'accessed-cids' state retention, but no tracing blockstore was present", ErrRetentionUnsupported)
+		}
+
+		preroot = root
+		accessed, err := tbs.Trace(func(bs blockstore.Blockstore) error {
+			if err := retainActors(ctx, bs, root, opts.RetainActors); err != nil {
+				return err
+			}
+			if opts.EmbedPrecursors {
+				// the vector starts before the precursors, which it applies.
+				if err := applyPrecursors(bs); err != nil {
+					return err
+				}
+			}
+			applyret, postroot, err = driver.ExecuteMessage(bs, conformance.ExecuteMessageParams{
+				Preroot:        root,
+				Epoch:          incTs.Height(),
+				Message:        msg,
+				CircSupply:     circSupplyDetail.FilCirculating,
+				BaseFee:        basefee,
+				Rand:           recordingRand,
+				NetworkVersion: nv,
+				TipSetGetter:   tipsets.GetTipSet,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to execute message: %w", err)
+			}
+			lookback = x.recordLookback(ctx, bs, recordingRand.Recorded(), execTs)
+			ancestors = x.recordAncestorHeaders(ctx, bs, tipsets.Recorded())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		merged = mergePrecursorWrites(accessed, precursorWrites)
+		if len(precursorWrites) > 0 {
+			extractLog.Infow("merged precursor writes into retained state", "written", len(precursorWrites), "merged", merged)
+		}
+		x.stateRetained(ctx, pst.Blockstore, accessed)
+		carWriter = func(w io.Writer, extraRoots ...cid.Cid) error {
+			for _, c := range extraRoots {
+				accessed[c] = AccessStats{}
+			}
+			return g.WriteCARIncluding(w, accessed, append([]cid.Cid{preroot, postroot}, extraRoots...)...)
+		}
+
+	case "accessed-actors":
+		extractLog.Infow("calculating accessed actors")
+		// get actors accessed by message.
+		retain, err := g.GetAccessedActors(ctx, x.api, mcid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate accessed actors: %w", err)
+		}
+		// also append the reward actor and the burnt funds actor.
+		retain = append(retain, reward.Address, builtin.BurntFundsActorAddr, init_.Address)
+		retain = append(retain, opts.RetainActors...)
+		extractLog.Infow("calculated accessed actors", "actors", retain)
+
+		// get the masked state tree from the root,
+		preroot, err = g.GetMaskedStateTree(root, retain)
+		if err != nil {
+			return nil, err
+		}
+		applyret, postroot, err = driver.ExecuteMessage(pst.Blockstore, conformance.ExecuteMessageParams{
+			Preroot:    preroot,
+			Epoch:      incTs.Height(),
+			Message:    msg,
+			CircSupply: circSupplyDetail.FilCirculating,
+			BaseFee:    basefee,
+			Rand:       recordingRand,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute message: %w", err)
+		}
+		carWriter = func(w io.Writer, extraRoots ...cid.Cid) error {
+			return g.WriteCAR(w, append([]cid.Cid{preroot, postroot}, extraRoots...)...)
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: unknown state retention option: %s", ErrRetentionUnsupported, retention)
+	}
+
+	extractLog.Infow("message applied", "preroot", preroot, "postroot", postroot)
+	x.emit(EventMessageApplied, "cid", msg.Cid().String(), "preroot", preroot.String(), "postroot", postroot.String(), "exit_code", applyret.ExitCode, "gas_used", applyret.GasUsed)
+	extractLog.Infow("performing sanity check on receipt")
+
+	// StateGetReceipt sometimes returns a nil receipt and no error, e.g.
+	// https://filfox.info/en/message/bafy2bzacebpxw3yiaxzy2bako62akig46x3imji7fewszen6fryiz6nymu2b2
+	// The receipt is then looked up through a search and a replay, and the
+	// reason it was missing recorded in the metadata; the receipt comparison
+	// is skipped if it's not recovered.
+	var (
+		rec     *types.MessageReceipt
+		missing *missingReceipt
+	)
+	if !preRoot.Defined() && !speculative {
+		if rec, err = x.api.StateGetReceipt(ctx, mcid, execTs.Key()); err != nil {
+			return nil, fmt.Errorf("failed to find receipt on chain: %w", err)
+		}
+		if rec == nil {
+			m := locateMissingReceipt(ctx, x.api, mcid, incTs, execTs)
+			extractLog.Warnw("got back a nil receipt from lotus; looked it up", "reason", m.reason, "recovered", m.receipt != nil, "source", m.source)
+			rec, missing = m.receipt, &m
+		}
+		extractLog.Infow("found receipt", "receipt", rec)
+	}
+
+	// generate the schema receipt; if we got
+	var (
+		receipt     *schema.Receipt
+		diagnostics *schema.Diagnostics
+	)
+	if rec != nil {
+		receipt = &schema.Receipt{
+			ExitCode:    int64(rec.ExitCode),
+			ReturnValue: rec.Return,
+			GasUsed:     rec.GasUsed,
+		}
+
+		reporter := new(conformance.LogReporter)
+		conformance.AssertMsgResultWithOpts(reporter, receipt, applyret, "as locally executed", opts.Assert)
+		x.sanityChecked(!reporter.Failed())
+		if reporter.Failed() {
+			var chainTrace *types.ExecutionTrace
+			if res, err := x.api.StateReplay(ctx, incTs.Key(), mcid); err != nil {
+				extractLog.Warnw("failed to replay message on chain", "error", err)
+			} else {
+				chainTrace = &res.ExecutionTrace
+			}
+			printReceiptMismatch(receipt, applyret, x.receiverCode(ctx, msg, execTs.Key()), msg.Method, chainTrace)
+
+			gasOnly := receipt.ExitCode == int64(applyret.ExitCode) && bytes.Equal(receipt.ReturnValue, applyret.Return)
+			switch {
+			case opts.Force:
+				extractLog.Warnw("receipt sanity check failed; forcing emission and recording both receipts in diagnostics")
+				if diagnostics, err = receiptMismatchDiagnostics(receipt, applyret); err != nil {
+					return nil, err
+				}
+			case opts.IgnoreSanityChecks:
+				extractLog.Warnw("receipt sanity check failed; proceeding anyway")
+			case gasOnly && opts.hasHint(conformance.HintIncorrectGas):
+				extractLog.Warnw("receipt sanity check failed only on gas; proceeding with hint", "hint", conformance.HintIncorrectGas)
+			case opts.hasHint(schema.HintIncorrect):
+				extractLog.Warnw("receipt sanity check failed; proceeding with hint", "hint", schema.HintIncorrect)
+			default:
+				if gasOnly {
+					extractLog.Warnf("receipt sanity check failed only on gas; supply --hint=%s to emit the vector anyway", conformance.HintIncorrectGas)
+				}
+				extractLog.Errorw("receipt sanity check failed; aborting")
+				field := "gas_used"
+				switch {
+				case receipt.ExitCode != int64(applyret.ExitCode):
+					field = "exit_code"
+				case !gasOnly:
+					field = "return_value"
+				}
+				return nil, &SanityCheckError{Field: field, Detail: "receipt of message " + mcid.String()}
+			}
+		} else {
+			extractLog.Infow("receipt sanity check succeeded")
+		}
+
+	} else {
+		receipt = &schema.Receipt{
+			ExitCode:    int64(applyret.ExitCode),
+			ReturnValue: applyret.Return,
+			GasUsed:     applyret.GasUsed,
+		}
+		switch {
+		case opts.FromMpool:
+			extractLog.Infow("skipping receipts comparison; the message is pending, and was applied speculatively")
+		case opts.Message != nil:
+			extractLog.Infow("skipping receipts comparison; the message was supplied, and was applied speculatively")
+		case preRoot.Defined():
+			extractLog.Infow("skipping receipts comparison; the message was applied on a pre-state override")
+		default:
+			extractLog.Warnw("skipping receipts comparison; the receipt is missing on chain", "reason", missing.reason)
+		}
+	}
+
+	extractLog.Infow("generating vector")
+	msgBytes, err := msg.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	recordingCid, err := storeSyscallRecording(ctx, recording, pst.Blockstore)
+	if err != nil {
+		return nil, err
+	}
+
+	ancestorsCid, err := storeAncestorTipSets(ctx, ancestors, pst.Blockstore)
+	if err != nil {
+		return nil, err
+	}
+
+	var faultCids []cid.Cid
+	if x.fault != nil {
+		if faultCids, err = x.fault.store(ctx, pst.Blockstore); err != nil {
+			return nil, err
+		}
+	}
+
+	var extraRoots []cid.Cid
+	extraRoots = append(extraRoots, faultCids...)
+	if recordingCid.Defined() {
+		extraRoots = append(extraRoots, recordingCid)
+	}
+	if ancestorsCid.Defined() {
+		extraRoots = append(extraRoots, ancestorsCid)
+	}
+
+	// the traces follow the receipts: the embedded precursors, then the
+	// message.
+	tracesCid := cid.Undef
+	if opts.RecordTraces {
+		var rets []*vm.ApplyRet
+		if opts.EmbedPrecursors {
+			rets = append(rets, precursorRets...)
+		}
+		if tracesCid, err = storeExpectedTraces(ctx, append(rets, applyret), pst.Blockstore); err != nil {
+			return nil, err
+		}
+		extraRoots = append(extraRoots, tracesCid)
+	}
+
+	carBytes, err := x.encodeCAR(func(w io.Writer) error {
+		return carWriter(w, extraRoots...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := x.api.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ntwkName, err := x.api.StateNetworkName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	codename := GetProtocolCodename(execTs.Height())
+
+	gen := []schema.GenerationData{
+		{Source: fmt.Sprintf("network:%s", ntwkName)},
+		{Source: fmt.Sprintf("message:%s", msg.Cid().String())},
+	}
+	// a pending or supplied message is applied on a tipset, but not included
+	// in, nor executed by, any tipset yet.
+	if !speculative {
+		gen = append(gen,
+			schema.GenerationData{Source: fmt.Sprintf("inclusion_tipset:%s", incTs.Key().String())},
+			schema.GenerationData{Source: fmt.Sprintf("execution_tipset:%s", execTs.Key().String())})
+	}
+	gen = append(gen, schema.GenerationData{Source: "github.com/filecoin-project/lotus", Version: version.String()})
+
+	code := x.receiverCode(ctx, msg, execTs.Key())
+	if opts.ID == "" {
+		opts.ID = messageVectorID(code, msg, exitcode.ExitCode(receipt.ExitCode))
+	}
+
+	// Write out the test vector.
+	vector := schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta: &schema.Metadata{
+			ID: opts.ID,
+			// TODO need to replace schema.GenerationData with a more flexible
+			//  data structure that makes no assumption about the traceability
+			//  data that's being recorded; a flexible map[string]string
+			//  would do.
+			Gen: gen,
+		},
+		Selector: schema.Selector{
+			schema.SelectorMinProtocolVersion: codename,
+		},
+		Randomness: recordingRand.Recorded(),
+		CAR:        carBytes,
+		Pre: &schema.Preconditions{
+			Variants: []schema.Variant{
+				{ID: codename, Epoch: int64(incTs.Height()), NetworkVersion: uint(nv)},
+			},
+			CircSupply: circSupply.Int,
+			BaseFee:    basefee.Int,
+			StateTree: &schema.StateTree{
+				RootCID: preroot,
+			},
+		},
+		Post: &schema.Postconditions{
+			StateTree: &schema.StateTree{
+				RootCID: postroot,
+			},
+		},
+	}
+	if opts.EmbedPrecursors {
+		for i, m := range precursors {
+			b, err := m.Serialize()
+			if err != nil {
+				return nil, err
+			}
+			vector.ApplyMessages = append(vector.ApplyMessages, schema.Message{Bytes: b})
+			vector.Post.Receipts = append(vector.Post.Receipts, &schema.Receipt{
+				ExitCode:    int64(precursorRets[i].ExitCode),
+				ReturnValue: precursorRets[i].Return,
+				GasUsed:     precursorRets[i].GasUsed,
+			})
+		}
+	}
+	vector.ApplyMessages = append(vector.ApplyMessages, schema.Message{Bytes: msgBytes})
+	vector.Post.Receipts = append(vector.Post.Receipts, &schema.Receipt{
+		ExitCode:    int64(applyret.ExitCode),
+		ReturnValue: applyret.Return,
+		GasUsed:     applyret.GasUsed,
+	})
+	vector.Meta.Gen = append(vector.Meta.Gen, x.precursorsGen(ctx, msg, precursors, skipped)...)
+	if order != nil {
+		vector.Meta.Gen = append(vector.Meta.Gen, order.GenerationData())
+	}
+	if lookbackDecision != nil {
+		vector.Meta.Gen = append(vector.Meta.Gen, lookbackDecision.GenerationData())
+	}
+	if len(precursorWrites) > 0 {
+		vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{
+			Source:  "precursors:writes_merged",
+			Version: fmt.Sprintf("written=%d,merged=%d", len(precursorWrites), merged),
+		})
+	}
+	vector.Meta.Gen = append(vector.Meta.Gen, receiverGen(code, msg.Method)...)
+	vector.Meta.Gen = append(vector.Meta.Gen, lookbackGen(lookback)...)
+	vector.Meta.Gen = append(vector.Meta.Gen, preflight...)
+	if missing != nil {
+		vector.Meta.Gen = append(vector.Meta.Gen, missing.gen()...)
+	}
+	if opts.FromMpool {
+		// the message is pending: the vector is speculative, and its
+		// receipt may differ from that of the message once mined.
+		vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{Source: "speculative:mpool", Version: "head=" + incTs.Key().String()})
+		vector.Meta.Tags = conformance.MergeTags(vector.Meta.Tags, TagSpeculative)
+	}
+	if opts.Message != nil {
+		// the message was supplied, rather than taken from the node.
+		vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{Source: "speculative:supplied", Version: "tipset=" + incTs.Key().String()})
+		vector.Meta.Tags = conformance.MergeTags(vector.Meta.Tags, TagSpeculative)
+	}
+	if preRoot.Defined() {
+		// the pre-state is an override, rather than that on chain.
+		vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{Source: "pre_root:" + preRoot.String()})
+	}
+	if len(nulls) > 0 {
+		// the cron ticks for these epochs are already applied in the preroot.
+		vector.Selector[conformance.SelectorNullRounds] = conformance.FormatNullRounds(nulls)
+	}
+	vector.Hints = opts.Hints
+	vector.Diagnostics = diagnostics
+
+	PopulateSelector(&vector, selector, applyret)
+	if recordingCid.Defined() {
+		vector.Selector[conformance.SelectorRecordedSyscalls] = recordingCid.String()
+		dropProofParams(&vector, selector)
+	}
+	if tracesCid.Defined() {
+		vector.Selector[conformance.SelectorExpectedTraces] = tracesCid.String()
+	}
+	if ancestorsCid.Defined() {
+		vector.Selector[conformance.SelectorAncestorHeaders] = ancestorsCid.String()
+	}
+	if len(faultCids) > 0 {
+		// the evidence follows the headers.
+		vector.Selector[conformance.SelectorConsensusFault] = faultCids[len(faultCids)-1].String()
+	}
+
+	return &vector, nil
+}
+
+// resolveFromChain queries the chain for the provided message, using the block CID to
+// speed up the query, if provided
+func (x *extraction) resolveFromChain(ctx context.Context, mcid cid.Cid, block string) (msg *types.Message, execTs *types.TipSet, incTs *types.TipSet, err error) {
+	api := x.api
+
+	// Extract the full message.
+	msg, err = api.ChainGetMessage(ctx, mcid)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s: %s", ErrMessageNotFound, mcid, err)
+	}
+
+	extractLog.Debugw("found message", "cid", mcid, "message", msg)
+
+	if locate := x.opts.Hooks.LocateMessage; block == "" && locate != nil {
+		tsk, ok, err := locate(ctx, mcid)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if ok {
+			extractLog.Infow("located message without searching the chain", "tipset", tsk)
+			execTs, incTs, err = fetchThisAndPrevTipset(ctx, api, tsk)
+			return msg, execTs, incTs, err
+		}
+		extractLog.Infow("message not located; searching the chain")
+	}
+
+	if block == "" {
+		extractLog.Infow("locating message in blockchain")
+
+		// Locate the message.
+		msgInfo, err := api.StateSearchMsg(ctx, mcid)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to locate message: %w", err)
+		}
+		if msgInfo == nil {
+			return nil, nil, nil, fmt.Errorf("failed to locate message: %w on chain", ErrMessageNotFound)
+		}
+
+		extractLog.Infow("located message", "tipset", msgInfo.TipSet, "height", msgInfo.Height, "exit_code", msgInfo.Receipt.ExitCode)
+
+		execTs, incTs, err = fetchThisAndPrevTipset(ctx, api, msgInfo.TipSet)
+		return msg, execTs, incTs, err
+	}
+
+	bcid, err := cid.Decode(block)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	extractLog.Infow("message inclusion block CID was provided; scanning around it", "block", bcid)
+
+	blk, err := api.ChainGetBlock(ctx, bcid)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: failed to get block %s: %s", ErrTipsetUnavailable, bcid, err)
+	}
+
+	// the execution tipset is the first non-null tipset after the inclusion
+	// height, which is not necessarily at height+1 if null rounds intervened.
+	execTs, err = FindExecutionTipset(ctx, api, blk.Height)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	incTs, err = api.ChainGetTipSet(ctx, execTs.Parents())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: failed to get message inclusion tipset (%d): %s", ErrTipsetUnavailable, blk.Height, err)
+	}
+
+	var included bool
+	for _, c := range incTs.Cids() {
+		included = included || c == bcid
+	}
+	if !included {
+		return nil, nil, nil, fmt.Errorf("block %s is not part of the canonical tipset at height %d (%s)", bcid, blk.Height, incTs.Key())
+	}
+
+	return msg, execTs, incTs, nil
+}
+
+// FindExecutionTipset walks the chain forward from the supplied inclusion
+// height, skipping over null rounds, and returns the first tipset found.
+func FindExecutionTipset(ctx context.Context, api v0api.FullNode, inclusion abi.ChainEpoch) (*types.TipSet, error) {
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chain head: %w", err)
+	}
+
+	for h := inclusion + 1; h <= head.Height(); h++ {
+		// walk back from the HEAD; if h is a null round, we get the previous
+		// non-null tipset back.
+		ts, err := api.ChainGetTipSetByHeight(ctx, h, head.Key())
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to get message execution tipset (%d): %s", ErrTipsetUnavailable, h, err)
+		}
+		if ts.Height() == h {
+			return ts, nil
+		}
+		extractLog.Debugw("epoch after inclusion was a null round", "epoch", h)
+	}
+
+	return nil, fmt.Errorf("%w: message included at height %d has not been executed yet (head: %d)", ErrTipsetUnavailable, inclusion, head.Height())
+}
+
+// nullRounds returns the epochs that were null rounds between the parent
+// and the child tipsets, exclusive.
+func nullRounds(parent, child *types.TipSet) (ret []abi.ChainEpoch) {
+	for e := parent.Height() + 1; e < child.Height(); e++ {
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+// resolvePrecursors returns the precursors of the message to apply, as
+// selected by the options, the number of precursors skipped, and the order of
+// the message and the precursors among the messages of the inclusion tipset.
+func (x *extraction) resolvePrecursors(ctx context.Context, mcid cid.Cid, msg *types.Message, execTs *types.TipSet) (precursors []*types.Message, skipped int, order *PrecursorOrder, err error) {
+	opts := x.opts
+	extractLog.Infow("finding precursor messages", "mode", opts.Precursor)
+
+	// Fetch messages in canonical order from inclusion tipset.
+	msgs, err := x.api.ChainGetParentMessages(ctx, execTs.Blocks()[0].Cid())
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to fetch messages in canonical order from inclusion tipset: %w", err)
+	}
+
+	if precursors, skipped, err = x.precursorsAmong(ctx, mcid, msg, msgs); err != nil {
+		return nil, 0, nil, err
+	}
+	return precursors, skipped, newPrecursorOrder(msgs, mcid, precursors), nil
+}
+
+// precursorsAmong returns the precursors of the message to apply among the
+// messages, in order, up to the message, as selected by the options, and the
+// number of precursors skipped.
+func (x *extraction) precursorsAmong(ctx context.Context, mcid cid.Cid, msg *types.Message, msgs []api.Message) (precursors []*types.Message, skipped int, err error) {
+	opts := x.opts
+	related, found, err := x.findMsgAndPrecursors(ctx, opts.Precursor, mcid, msg.From, msg.To, msgs)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed while finding message and precursors: %w", err)
+	}
+
+	if !found {
+		return nil, 0, fmt.Errorf("%w among the messages of its tipset; precursors found: %d", ErrMessageNotFound, len(related))
+	}
+
+	var precursorsCids []cid.Cid
+	precursors = related[:len(related)-1]
+	for _, p := range precursors {
+		precursorsCids = append(precursorsCids, p.Cid())
+	}
+
+	extractLog.Infow("found message", "precursors", len(precursors), "precursor_cids", precursorsCids)
+
+	senderID := x.mustResolveAddr(ctx, msg.From)
+	selected := selectPrecursors(precursors, opts.IgnorePrecursors, opts.MaxPrecursors, func(m *types.Message) bool {
+		return x.mustResolveAddr(ctx, m.From) == senderID
+	})
+	skipped = len(precursors) - len(selected)
+	if skipped > 0 {
+		extractLog.Warnw("skipping precursors; state may drift from that on chain", "skipped", skipped, "applied", len(selected))
+	}
+	return selected, skipped, nil
+}
+
+// fetchThisAndPrevTipset returns the full tipset identified by the key, as well
+// as the previous tipset. In the context of vector generation, the target
+// tipset is the one where a message was executed, and the previous tipset is
+// the one where the message was included.
+func fetchThisAndPrevTipset(ctx context.Context, api v0api.FullNode, target types.TipSetKey) (targetTs *types.TipSet, prevTs *types.TipSet, err error) {
+	// get the tipset on which this message was "executed" on.
+	// https://github.com/filecoin-project/lotus/issues/2847
+	targetTs, err = api.ChainGetTipSet(ctx, target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %s", ErrTipsetUnavailable, target, err)
+	}
+	// get the previous tipset, on which this message was mined,
+	// i.e. included on-chain.
+	prevTs, err = api.ChainGetTipSet(ctx, targetTs.Parents())
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %s", ErrTipsetUnavailable, targetTs.Parents(), err)
+	}
+	return targetTs, prevTs, nil
+}
+
+// findMsgAndPrecursors ranges through the canonical messages slice, locating
+// the target message and returning precursors in accordance to the supplied
+// mode.
+func (x *extraction) findMsgAndPrecursors(ctx context.Context, mode string, msgCid cid.Cid, sender address.Address, recipient address.Address, msgs []api.Message) (related []*types.Message, found bool, err error) {
+	// Resolve addresses to IDs for canonicality.
+	senderID := x.mustResolveAddr(ctx, sender)
+	recipientID := x.mustResolveAddr(ctx, recipient)
+
+	// Range through messages, selecting only the precursors based on selection mode.
+	for _, m := range msgs {
+		msgSenderID := x.mustResolveAddr(ctx, m.Message.From)
+		msgRecipientID := x.mustResolveAddr(ctx, m.Message.To)
+
+		switch {
+		case mode == PrecursorSelectAll:
+			fallthrough
+		case mode == PrecursorSelectParticipants &&
+			(msgSenderID == senderID ||
+				msgRecipientID == recipientID ||
+				msgSenderID == recipientID ||
+				msgRecipientID == senderID):
+			related = append(related, m.Message)
+		}
+
+		// this message is the target; we're done.
+		if m.Cid == msgCid {
+			return related, true, nil
+		}
+	}
+
+	// this could happen because a block contained related messages, but not
+	// the target (that is, messages with a lower nonce, but ultimately not the
+	// target).
+	return related, false, nil
+}
+
+// Reasons a precursor was selected, as recorded in the vector metadata.
+const (
+	PrecursorReasonAll               = "all"
+	PrecursorReasonSender            = "sender"
+	PrecursorReasonRecipient         = "recipient"
+	PrecursorReasonSenderIsRecipient = "sender_is_recipient"
+	PrecursorReasonRecipientIsSender = "recipient_is_sender"
+)
+
+// precursorReason returns why a message from/to the supplied (ID) addresses
+// was selected as a precursor of a message from sender to recipient.
+func precursorReason(mode string, sender, recipient, from, to address.Address) string {
+	switch {
+	case mode == PrecursorSelectAll:
+		return PrecursorReasonAll
+	case from == sender:
+		return PrecursorReasonSender
+	case to == recipient:
+		return PrecursorReasonRecipient
+	case from == recipient:
+		return PrecursorReasonSenderIsRecipient
+	default:
+		return PrecursorReasonRecipientIsSender
+	}
+}
+
+// selectPrecursors returns the precursors to apply: none if ignore is true,
+// otherwise at most limit (all if 0) besides those fromSender, which are always
+// applied to preserve the nonce sequence of the sender. The precursors closest
+// to the message are preferred; canonical order is preserved.
+func selectPrecursors(precursors []*types.Message, ignore bool, limit int, fromSender func(*types.Message) bool) []*types.Message {
+	if ignore {
+		return nil
+	}
+	if limit <= 0 || len(precursors) <= limit {
+		return precursors
+	}
+
+	keep := make([]bool, len(precursors))
+	for i := len(precursors) - 1; i >= 0; i-- {
+		switch {
+		case fromSender(precursors[i]):
+			keep[i] = true
+		case limit > 0:
+			keep[i] = true
+			limit--
+		}
+	}
+	selected := make([]*types.Message, 0, len(precursors))
+	for i, m := range precursors {
+		if keep[i] {
+			selected = append(selected, m)
+		}
+	}
+	return selected
+}
+
+// precursorsGen returns the generation metadata recording how the precursors
+// of the message were selected and applied: whether squashed into the
+// pre-state or embedded in the vector, how many were skipped, and the sender,
+// nonce and selection reason of each applied.
+func (x *extraction) precursorsGen(ctx context.Context, msg *types.Message, precursors []*types.Message, skipped int) []schema.GenerationData {
+	applied := "squashed"
+	if x.opts.EmbedPrecursors {
+		applied = "embedded"
+	}
+	gen := []schema.GenerationData{{Source: "precursors:" + applied, Version: x.opts.Precursor}}
+	if skipped > 0 {
+		gen = append(gen, schema.GenerationData{Source: "precursors:skipped", Version: strconv.Itoa(skipped)})
+	}
+
+	senderID := x.mustResolveAddr(ctx, msg.From)
+	recipientID := x.mustResolveAddr(ctx, msg.To)
+	for _, p := range precursors {
+		reason := precursorReason(x.opts.Precursor, senderID, recipientID, x.mustResolveAddr(ctx, p.From), x.mustResolveAddr(ctx, p.To))
+		gen = append(gen, schema.GenerationData{
+			Source:  "precursor:" + p.Cid().String(),
+			Version: fmt.Sprintf("from=%s,nonce=%d,reason=%s", p.From, p.Nonce, reason),
+		})
+	}
+	return gen
+}
+
+func (x *extraction) mustResolveAddr(ctx context.Context, addr address.Address) address.Address {
+	if resolved, ok := x.addrs[addr]; ok {
+		return resolved
+	}
+	id, err := x.api.StateLookupID(ctx, addr, types.EmptyTSK)
+	if err != nil {
+		panic(fmt.Errorf("failed to resolve addr: %w", err))
+	}
+	x.addrs[addr] = id
+	return id
+}
+
+// retainActors reads the entries and state heads of the actors in the state
+// tree at root from bs, for them to be retained with 'accessed-cids' state
+// retention. Actors absent from the state tree are skipped.
+func retainActors(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, addrs []address.Address) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	st, err := state.LoadStateTree(cbor.NewCborStore(bs), root)
+	if err != nil {
+		return fmt.Errorf("failed to load state tree: %w", err)
+	}
+	for _, addr := range addrs {
+		act, err := st.GetActor(addr)
+		if errors.Is(err, types.ErrActorNotFound) {
+			extractLog.Debugw("not retaining absent actor", "address", addr)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to load actor %s: %w", addr, err)
+		}
+		if _, err := bs.Get(ctx, act.Head); err != nil {
+			return fmt.Errorf("failed to retain state of actor %s: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+// mergePrecursorWrites merges the blocks written by squashed precursors into
+// the blocks accessed by the message, and returns how many weren't accessed.
+// The CAR walk from the state roots drops those it can't reach, e.g. the
+// intermediate states of the precursors.
+func mergePrecursorWrites(accessed, writes AccessSet) int {
+	var merged int
+	for c, stats := range writes {
+		if _, ok := accessed[c]; ok {
+			continue
+		}
+		accessed[c] = stats
+		merged++
+	}
+	return merged
+}
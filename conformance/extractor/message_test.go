@@ -0,0 +1,99 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestPrecursorReason(t *testing.T) {
+	id := func(n uint64) address.Address {
+		a, err := address.NewIDAddress(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	sender, recipient, other := id(100), id(200), id(300)
+
+	for _, tc := range []struct {
+		mode     string
+		from, to address.Address
+		want     string
+	}{
+		{PrecursorSelectAll, other, other, PrecursorReasonAll},
+		{PrecursorSelectParticipants, sender, other, PrecursorReasonSender},
+		{PrecursorSelectParticipants, sender, recipient, PrecursorReasonSender},
+		{PrecursorSelectParticipants, other, recipient, PrecursorReasonRecipient},
+		{PrecursorSelectParticipants, recipient, other, PrecursorReasonSenderIsRecipient},
+		{PrecursorSelectParticipants, other, sender, PrecursorReasonRecipientIsSender},
+	} {
+		if got := precursorReason(tc.mode, sender, recipient, tc.from, tc.to); got != tc.want {
+			t.Errorf("precursorReason(%s, %s -> %s) = %s; want %s", tc.mode, tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestSelectPrecursors(t *testing.T) {
+	sender, err := address.NewIDAddress(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := address.NewIDAddress(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// s0 o1 s2 o3 o4, in canonical order.
+	var precursors []*types.Message
+	for i, from := range []address.Address{sender, other, sender, other, other} {
+		precursors = append(precursors, &types.Message{From: from, To: other, Nonce: uint64(i)})
+	}
+	fromSender := func(m *types.Message) bool { return m.From == sender }
+
+	for _, tc := range []struct {
+		ignore bool
+		limit  int
+		want   string
+	}{
+		{ignore: true, want: ""},
+		{limit: 0, want: "0 1 2 3 4"},
+		{limit: 5, want: "0 1 2 3 4"},
+		{limit: 2, want: "0 2 3 4"},
+		{limit: 1, want: "0 2 4"},
+	} {
+		var got []string
+		for _, m := range selectPrecursors(precursors, tc.ignore, tc.limit, fromSender) {
+			got = append(got, strconv.FormatUint(m.Nonce, 10))
+		}
+		if s := strings.Join(got, " "); s != tc.want {
+			t.Errorf("selectPrecursors(ignore=%t, limit=%d) = %q; want %q", tc.ignore, tc.limit, s, tc.want)
+		}
+	}
+}
+
+func TestPreRootOptions(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"other class", Options{Class: "tipset", PreRoot: "bafy2bzaceaa"}, "only supported for message vectors"},
+		{"invalid root", Options{Class: "message", CID: "bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4", PreRoot: "nope"}, "invalid pre-state root"},
+		{"embedded precursors", Options{Class: "message", CID: "bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4",
+			PreRoot: "bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4", EmbedPrecursors: true}, "embedded precursors"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ExtractAll(ctx, nil, tc.opts); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected an error containing %q; got %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/stmgr"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// doExtractMigration extracts a vector exercising the state migration of the
+// network upgrade scheduled at the supplied epoch.
+//
+// The migration runs on the state produced by the upgrade epoch, before the
+// messages of the first tipset after it are applied. That state is the parent
+// state of that tipset, plus the cron ticks of any null rounds up to, and
+// including, the upgrade epoch.
+func (x *extraction) migration(ctx context.Context) (*schema.TestVector, error) {
+	opts := x.opts
+
+	if opts.Epoch <= 0 {
+		return nil, fmt.Errorf("upgrade epoch must be supplied")
+	}
+
+	if opts.Retain != "accessed-cids" {
+		return nil, fmt.Errorf("migration extraction only supports 'accessed-cids' state retention")
+	}
+
+	selector, err := ParseSelectors(opts.Selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	epoch := abi.ChainEpoch(opts.Epoch)
+
+	var upgrade *stmgr.Upgrade
+	for _, u := range filcns.DefaultUpgradeSchedule() {
+		u := u // capture
+		if u.Height == epoch {
+			upgrade = &u
+			break
+		}
+	}
+	if upgrade == nil {
+		return nil, fmt.Errorf("no network upgrade scheduled at epoch %d", epoch)
+	}
+	if upgrade.Migration == nil {
+		return nil, fmt.Errorf("network upgrade at epoch %d (network version %d) has no state migration", epoch, upgrade.Network)
+	}
+
+	extractLog.Infow("extracting migration", "network_version", upgrade.Network, "epoch", epoch)
+	if upgrade.Expensive {
+		extractLog.Warnw("this migration is expensive; extraction may take a long time")
+	}
+
+	// the first tipset after the upgrade epoch; the migration runs when it's
+	// executed.
+	execTs, err := FindExecutionTipset(ctx, x.api, epoch)
+	if err != nil {
+		return nil, err
+	}
+	preflight, err := x.preflight(ctx, execTs)
+	if err != nil {
+		return nil, err
+	}
+
+	parentTs, err := x.api.ChainGetTipSet(ctx, execTs.Parents())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch parent tipset: %w", err)
+	}
+
+	nv, err := x.api.StateNetworkVersion(ctx, parentTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve network version: %w", err)
+	}
+
+	circSupplyDetail, err := x.api.StateVMCirculatingSupplyInternal(ctx, parentTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed while fetching circulating supply: %w", err)
+	}
+
+	var (
+		pst, g = x.newStores(ctx)
+		driver = conformance.NewDriver(ctx, selector, conformance.DriverOpts{
+			DisableVMFlush: true,
+		})
+
+		preroot = execTs.ParentState()
+	)
+
+	tbs, ok := pst.Blockstore.(TracingBlockstore)
+	if !ok {
+		return nil, fmt.Errorf("%w: requested 'accessed-cids' state retention, but no tracing blockstore was present", ErrRetentionUnsupported)
+	}
+
+	// run cron for the null rounds up to, and including, the upgrade epoch.
+	for e := parentTs.Height() + 1; e <= epoch; e++ {
+		extractLog.Infow("applying cron for null round", "epoch", e)
+		params := conformance.ExecuteMessageParams{
+			Preroot:        preroot,
+			Epoch:          e,
+			Message:        conformance.NewCronMessage(e),
+			CircSupply:     circSupplyDetail.FilCirculating,
+			BaseFee:        execTs.Blocks()[0].ParentBaseFee,
+			Rand:           conformance.NewRecordingRand(new(conformance.LogReporter), x.api),
+			NetworkVersion: nv,
+		}
+		if _, preroot, err = driver.ExecuteImplicitMessage(pst.Blockstore, params); err != nil {
+			return nil, fmt.Errorf("failed to apply cron for null round %d: %w", e, err)
+		}
+	}
+
+	extractLog.Infow("running migration", "root", preroot)
+
+	var postroot cid.Cid
+	accessed, err := tbs.Trace(func(bs blockstore.Blockstore) (err error) {
+		postroot, err = driver.ExecuteMigration(bs, pst.Datastore, conformance.ExecuteMigrationParams{
+			Preroot:        preroot,
+			Epoch:          epoch,
+			NetworkVersion: upgrade.Network,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to run migration: %w", err)
+	}
+	x.stateRetained(ctx, pst.Blockstore, accessed)
+
+	extractLog.Infow("migration succeeded", "preroot", preroot, "postroot", postroot)
+	x.emit(EventMigrationApplied, "network_version", upgrade.Network, "epoch", epoch, "preroot", preroot.String(), "postroot", postroot.String())
+
+	carBytes, err := x.encodeCAR(func(w io.Writer) error {
+		return g.WriteCARIncluding(w, accessed, preroot, postroot)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := x.api.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ntwkName, err := x.api.StateNetworkName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the codename of the protocol version being upgraded into.
+	codename := GetProtocolCodename(epoch + 1)
+
+	if opts.ID == "" {
+		opts.ID = fmt.Sprintf("migration-nv%d-%d", upgrade.Network, epoch)
+	}
+
+	vector := schema.TestVector{
+		Class: conformance.ClassMigration,
+		Meta: &schema.Metadata{
+			ID: opts.ID,
+			Gen: []schema.GenerationData{
+				{Source: fmt.Sprintf("network:%s", ntwkName)},
+				{Source: fmt.Sprintf("upgrade:nv%d", upgrade.Network)},
+				{Source: fmt.Sprintf("execution_tipset:%s", execTs.Key().String())},
+				{Source: "github.com/filecoin-project/lotus", Version: version.String()}},
+		},
+		Selector: schema.Selector{
+			schema.SelectorMinProtocolVersion: codename,
+		},
+		CAR: carBytes,
+		Pre: &schema.Preconditions{
+			Variants: []schema.Variant{
+				{ID: codename, Epoch: int64(epoch), NetworkVersion: uint(upgrade.Network)},
+			},
+			StateTree: &schema.StateTree{
+				RootCID: preroot,
+			},
+		},
+		Post: &schema.Postconditions{
+			StateTree: &schema.StateTree{
+				RootCID: postroot,
+			},
+		},
+	}
+
+	vector.Meta.Gen = append(vector.Meta.Gen, preflight...)
+	PopulateSelector(&vector, selector)
+	vector.Hints = opts.Hints
+
+	return &vector, nil
+}
@@ -0,0 +1,95 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+// TagSpeculative tags the vectors of messages extracted from the message
+// pool, and of messages supplied, which weren't on chain at the time of
+// extraction.
+const TagSpeculative = "speculative"
+
+// resolveFromMpool resolves the pending message of the CID, that of the
+// message or of the signed message, from the message pool of the node. The
+// message is applied speculatively, as if included in the head tipset after
+// its messages, and the pending messages of its sender with lower nonces; it
+// returns the head, and those messages, with the message last, in the order
+// they're applied, for precursor selection.
+func (x *extraction) resolveFromMpool(ctx context.Context, mcid cid.Cid) (msg *types.Message, head *types.TipSet, msgs []api.Message, err error) {
+	if head, err = x.api.ChainHead(ctx); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get chain head: %w", err)
+	}
+	pending, err := x.api.MpoolPending(ctx, head.Key())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to fetch pending messages: %w", err)
+	}
+	for _, sm := range pending {
+		if sm.Cid() == mcid || sm.Message.Cid() == mcid {
+			msg = &sm.Message
+			break
+		}
+	}
+	if msg == nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s in the message pool of %d pending messages; it may have been mined", ErrMessageNotFound, mcid, len(pending))
+	}
+
+	if msgs, err = x.api.ChainGetMessagesInTipset(ctx, head.Key()); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to fetch messages of head tipset: %w", err)
+	}
+
+	// the pending messages of the sender with lower nonces are included
+	// before the message.
+	sender := x.mustResolveAddr(ctx, msg.From)
+	var earlier []*types.Message
+	for _, sm := range pending {
+		if m := &sm.Message; m.Nonce < msg.Nonce && x.mustResolveAddr(ctx, m.From) == sender {
+			earlier = append(earlier, m)
+		}
+	}
+	sort.Slice(earlier, func(i, j int) bool { return earlier[i].Nonce < earlier[j].Nonce })
+	for _, m := range earlier {
+		msgs = append(msgs, api.Message{Cid: m.Cid(), Message: m})
+	}
+	msgs = append(msgs, api.Message{Cid: mcid, Message: msg})
+
+	extractLog.Infow("resolved pending message", "cid", mcid, "head", head.Key(), "head_messages", len(msgs)-len(earlier)-1, "pending_from_sender", len(earlier))
+	return msg, head, msgs, nil
+}
+
+// resolveSupplied resolves the tipset the message supplied with
+// Options.Message is applied on: that of Options.TSK, or the head. Like a
+// pending message, the message is applied speculatively, as if included in
+// the tipset after its messages; it returns the tipset, and those messages,
+// with the message last, in the order they're applied, for precursor
+// selection.
+func (x *extraction) resolveSupplied(ctx context.Context, msg *types.Message) (*types.Message, *types.TipSet, []api.Message, error) {
+	var (
+		ts  *types.TipSet
+		err error
+	)
+	if x.opts.TSK != "" {
+		ts, err = lcli.ParseTipSetRef(ctx, x.api, x.opts.TSK)
+	} else {
+		ts, err = x.api.ChainHead(ctx)
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrTipsetUnavailable, err)
+	}
+
+	msgs, err := x.api.ChainGetMessagesInTipset(ctx, ts.Key())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to fetch messages of tipset %s: %w", ts.Key(), err)
+	}
+	msgs = append(msgs, api.Message{Cid: msg.Cid(), Message: msg})
+
+	extractLog.Infow("resolved supplied message", "cid", msg.Cid(), "tipset", ts.Key(), "epoch", ts.Height(), "tipset_messages", len(msgs)-1)
+	return msg, ts, msgs, nil
+}
@@ -0,0 +1,100 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// mpoolAPI serves the pending messages of a message pool, and the messages of
+// the head tipset, over a chain.
+type mpoolAPI struct {
+	chainAPI
+
+	pending []*types.SignedMessage
+	head    []api.Message
+}
+
+func (a *mpoolAPI) MpoolPending(context.Context, types.TipSetKey) ([]*types.SignedMessage, error) {
+	return a.pending, nil
+}
+
+func (a *mpoolAPI) ChainGetMessagesInTipset(context.Context, types.TipSetKey) ([]api.Message, error) {
+	return a.head, nil
+}
+
+func (a *mpoolAPI) StateLookupID(_ context.Context, addr address.Address, _ types.TipSetKey) (address.Address, error) {
+	return addr, nil
+}
+
+func TestResolveFromMpool(t *testing.T) {
+	ctx := context.Background()
+	sender, other := mustIDAddr(t, 100), mustIDAddr(t, 101)
+	msg := func(from address.Address, nonce uint64) *types.Message {
+		return &types.Message{From: from, To: other, Nonce: nonce}
+	}
+	mined := msg(sender, 0)
+	a := &mpoolAPI{
+		chainAPI: chainAPI{chain: mkChain(5, 0)},
+		pending: []*types.SignedMessage{
+			{Message: *msg(sender, 3)},
+			{Message: *msg(sender, 1)},
+			{Message: *msg(other, 0)},
+			{Message: *msg(sender, 2)},
+		},
+		head: []api.Message{{Cid: mined.Cid(), Message: mined}},
+	}
+	x := &extraction{api: a, opts: Options{Precursor: PrecursorSelectParticipants}, addrs: make(map[address.Address]address.Address)}
+
+	target := a.pending[3]
+	got, head, msgs, err := x.resolveFromMpool(ctx, target.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Nonce != 2 || !head.Equals(a.chain[5]) {
+		t.Fatalf("resolved the wrong message or head: nonce %d, head %s", got.Nonce, head.Key())
+	}
+	// the messages of the head, the pending ones of the sender with lower
+	// nonces, then the message.
+	var nonces []uint64
+	for _, m := range msgs {
+		nonces = append(nonces, m.Message.Nonce)
+	}
+	if len(msgs) != 3 || nonces[0] != 0 || nonces[1] != 1 || nonces[2] != 2 || msgs[2].Cid != target.Cid() {
+		t.Errorf("unexpected messages: nonces %v", nonces)
+	}
+
+	precursors, skipped, err := x.precursorsAmong(ctx, target.Cid(), got, msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(precursors) != 2 || skipped != 0 {
+		t.Errorf("expected 2 precursors; got %d, skipped %d", len(precursors), skipped)
+	}
+
+	if _, _, _, err := x.resolveFromMpool(ctx, mined.Cid()); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected a mined message not to be found; got %v", err)
+	}
+}
+
+func TestFromMpoolOptions(t *testing.T) {
+	_, err := ExtractAll(context.Background(), nil, Options{Class: "tipset", FromMpool: true})
+	if err == nil || !strings.Contains(err.Error(), "only supported for message vectors") {
+		t.Errorf("expected the extraction of a tipset from the message pool to fail; got %v", err)
+	}
+}
+
+func mustIDAddr(t *testing.T, id uint64) address.Address {
+	addr, err := address.NewIDAddress(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
@@ -0,0 +1,421 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	msig10 "github.com/filecoin-project/go-state-types/builtin/v10/multisig"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/multisig"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// ClassMsigFlow is the extraction mode of multisig flows: message vectors
+// applying a multisig proposal, and the approval that executes it.
+const ClassMsigFlow = "msig-flow"
+
+// TagMsigFlow tags the vectors of multisig flows.
+const TagMsigFlow = "msig-flow"
+
+// doExtractMsigFlow extracts a message vector applying the proposal approved
+// by the message, and the approval, in sequence.
+//
+// The pre-state is that of the approval, after its precursors, with the
+// multisig and proposer actors rewound to their states before the proposal,
+// after its precursors. Both messages are applied at the epoch of the
+// approval. Besides their receipts, the exit code of the call the approval
+// executes is checked against the on-chain execution trace.
+func (x *extraction) msigFlow(ctx context.Context) (*schema.TestVector, error) {
+	opts := x.opts
+
+	if opts.CID == "" {
+		return nil, fmt.Errorf("missing approval message CID")
+	}
+	if opts.Retain != "accessed-cids" {
+		return nil, fmt.Errorf("multisig flows require 'accessed-cids' state retention")
+	}
+
+	acid, err := cid.Decode(opts.CID)
+	if err != nil {
+		return nil, err
+	}
+
+	selector, err := ParseSelectors(opts.Selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	approve, execTs, incTs, err := x.resolveFromChain(ctx, acid, opts.Block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve approval and tipsets from chain: %w", err)
+	}
+	preflight, err := x.preflight(ctx, execTs)
+	if err != nil {
+		return nil, err
+	}
+	if approve.Method != multisig.Methods.Approve {
+		return nil, fmt.Errorf("message %s invokes method %d, not Approve", acid, approve.Method)
+	}
+	var txn msig10.TxnIDParams
+	if err := txn.UnmarshalCBOR(bytes.NewReader(approve.Params)); err != nil {
+		return nil, fmt.Errorf("failed to decode approval params: %w", err)
+	}
+
+	propose, plookup, err := x.findMsigProposal(ctx, approve, incTs, int64(txn.ID), abi.ChainEpoch(opts.MsigLookback))
+	if err != nil {
+		return nil, err
+	}
+	var proposal multisig.ProposeParams
+	if err := proposal.UnmarshalCBOR(bytes.NewReader(propose.Params)); err != nil {
+		return nil, fmt.Errorf("failed to decode proposal params: %w", err)
+	}
+	extractLog.Infow("found proposal", "cid", propose.Cid(), "txn", txn.ID, "tipset", plookup.TipSet, "height", plookup.Height)
+
+	nv, err := x.api.StateNetworkVersion(ctx, incTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve network version from inclusion height: %w", err)
+	}
+	circSupplyDetail, err := x.api.StateVMCirculatingSupplyInternal(ctx, incTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed while fetching circulating supply: %w", err)
+	}
+	basefee := incTs.Blocks()[0].ParentBaseFee
+
+	pst, g := x.stores(ctx, incTs.Height())
+	tbs, ok := pst.Blockstore.(TracingBlockstore)
+	if !ok {
+		return nil, fmt.Errorf("%w: requested 'accessed-cids' state retention, but no tracing blockstore was present", ErrRetentionUnsupported)
+	}
+
+	driver := conformance.NewDriver(ctx, selector, conformance.DriverOpts{DisableVMFlush: true, CustomActors: x.opts.CustomActors})
+	recordingRand := conformance.NewRecordingRand(new(conformance.LogReporter), x.api)
+
+	root, err := x.applySquashedPrecursors(ctx, driver, pst.Blockstore, acid, approve, execTs, incTs)
+	if err != nil {
+		return nil, err
+	}
+	pexecTs, pincTs, err := fetchThisAndPrevTipset(ctx, x.api, plookup.TipSet)
+	if err != nil {
+		return nil, err
+	}
+	proot, err := x.applySquashedPrecursors(ctx, driver, pst.Blockstore, propose.Cid(), propose, pexecTs, pincTs)
+	if err != nil {
+		return nil, err
+	}
+
+	// rewind the multisig and the proposer to their states before the
+	// proposal.
+	msigID, proposerID := x.mustResolveAddr(ctx, approve.To), x.mustResolveAddr(ctx, propose.From)
+	preroot, err := rewindActors(ctx, pst.Blockstore, root, proot, msigID, proposerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewind multisig and proposer: %w", err)
+	}
+
+	var (
+		prets    [2]*vm.ApplyRet
+		postroot cid.Cid
+		lookback []conformance.LookbackEntry
+	)
+	accessed, err := tbs.Trace(func(bs blockstore.Blockstore) error {
+		root := preroot
+		for i, m := range []*types.Message{propose, approve} {
+			var err error
+			prets[i], root, err = driver.ExecuteMessage(bs, conformance.ExecuteMessageParams{
+				Preroot:        root,
+				Epoch:          incTs.Height(),
+				Message:        m,
+				CircSupply:     circSupplyDetail.FilCirculating,
+				BaseFee:        basefee,
+				Rand:           recordingRand,
+				NetworkVersion: nv,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to execute message %s: %w", m.Cid(), err)
+			}
+			x.emit(EventMessageApplied, "cid", m.Cid().String(), "exit_code", prets[i].ExitCode, "gas_used", prets[i].GasUsed)
+		}
+		postroot = root
+		lookback = x.recordLookback(ctx, bs, recordingRand.Recorded(), execTs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	x.stateRetained(ctx, pst.Blockstore, accessed)
+
+	// sanity check the receipts, and the inner call, against the chain.
+	arec, err := x.api.StateGetReceipt(ctx, acid, execTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find approval receipt on chain: %w", err)
+	}
+	var missing *missingReceipt
+	if arec == nil {
+		m := locateMissingReceipt(ctx, x.api, acid, incTs, execTs)
+		extractLog.Warnw("got back a nil approval receipt from lotus; looked it up", "reason", m.reason, "recovered", m.receipt != nil, "source", m.source)
+		arec, missing = m.receipt, &m
+	}
+	areplay, err := x.api.StateReplay(ctx, incTs.Key(), acid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay approval on chain: %w", err)
+	}
+	var failures []string
+	failures = append(failures, checkFlowReceipt("proposal", &plookup.Receipt, prets[0])...)
+	if arec != nil {
+		failures = append(failures, checkFlowReceipt("approval", arec, prets[1])...)
+	}
+	chainInner := msigInnerCall(&areplay.ExecutionTrace, &proposal)
+	localInner := msigInnerCall(&prets[1].ExecutionTrace, &proposal)
+	switch {
+	case chainInner == nil && localInner == nil:
+		extractLog.Warnw("approval executed no inner call on chain nor locally")
+	case chainInner == nil || localInner == nil:
+		failures = append(failures, fmt.Sprintf("inner call executed on chain: %t, locally: %t", chainInner != nil, localInner != nil))
+	case chainInner.MsgRct.ExitCode != localInner.MsgRct.ExitCode:
+		failures = append(failures, fmt.Sprintf("inner call exit code: expected %d, got %d", chainInner.MsgRct.ExitCode, localInner.MsgRct.ExitCode))
+	}
+	x.sanityChecked(len(failures) == 0)
+	for _, f := range failures {
+		extractLog.Errorw("sanity check failed", "failure", f)
+	}
+	if len(failures) > 0 && !opts.IgnoreSanityChecks && !opts.Force {
+		return nil, &SanityCheckError{Field: "receipts", Detail: strings.Join(failures, "; ")}
+	}
+
+	carBytes, err := x.encodeCAR(func(w io.Writer) error {
+		return g.WriteCARIncluding(w, accessed, preroot, postroot)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := x.api.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ntwkName, err := x.api.StateNetworkName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	codename := GetProtocolCodename(execTs.Height())
+	code := x.receiverCode(ctx, approve, execTs.Key())
+	if opts.ID == "" {
+		opts.ID = messageVectorID(code, approve, prets[1].ExitCode)
+	}
+
+	vector := schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta: &schema.Metadata{
+			ID: opts.ID,
+			Gen: []schema.GenerationData{
+				{Source: fmt.Sprintf("network:%s", ntwkName)},
+				{Source: fmt.Sprintf("message:%s", acid)},
+				{Source: fmt.Sprintf("msig_proposal:%s", propose.Cid())},
+				{Source: fmt.Sprintf("msig_txn:%d", txn.ID)},
+				{Source: fmt.Sprintf("inclusion_tipset:%s", incTs.Key())},
+				{Source: fmt.Sprintf("execution_tipset:%s", execTs.Key())},
+				{Source: "github.com/filecoin-project/lotus", Version: version.String()}},
+			Tags: []string{TagMsigFlow},
+		},
+		Selector: schema.Selector{
+			schema.SelectorMinProtocolVersion: codename,
+		},
+		Randomness: recordingRand.Recorded(),
+		CAR:        carBytes,
+		Pre: &schema.Preconditions{
+			Variants: []schema.Variant{
+				{ID: codename, Epoch: int64(incTs.Height()), NetworkVersion: uint(nv)},
+			},
+			CircSupply: circSupplyDetail.FilCirculating.Int,
+			BaseFee:    basefee.Int,
+			StateTree:  &schema.StateTree{RootCID: preroot},
+		},
+		Post: &schema.Postconditions{
+			StateTree: &schema.StateTree{RootCID: postroot},
+		},
+	}
+	for i, m := range []*types.Message{propose, approve} {
+		b, err := m.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		vector.ApplyMessages = append(vector.ApplyMessages, schema.Message{Bytes: b})
+		vector.Post.Receipts = append(vector.Post.Receipts, &schema.Receipt{
+			ExitCode:    int64(prets[i].ExitCode),
+			ReturnValue: prets[i].Return,
+			GasUsed:     prets[i].GasUsed,
+		})
+	}
+	if missing != nil {
+		vector.Meta.Gen = append(vector.Meta.Gen, missing.gen()...)
+	}
+	if localInner != nil {
+		vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{
+			Source: fmt.Sprintf("msig_inner_exit_code:%d", localInner.MsgRct.ExitCode),
+		})
+	}
+	vector.Meta.Gen = append(vector.Meta.Gen, receiverGen(code, approve.Method)...)
+	vector.Meta.Gen = append(vector.Meta.Gen, lookbackGen(lookback)...)
+	vector.Meta.Gen = append(vector.Meta.Gen, preflight...)
+	vector.Hints = opts.Hints
+
+	PopulateSelector(&vector, selector, prets[:]...)
+	return &vector, nil
+}
+
+// applySquashedPrecursors applies the precursors of the message, selected as
+// requested in the options, on top of the parent state of its inclusion
+// tipset, and returns the resulting root.
+func (x *extraction) applySquashedPrecursors(ctx context.Context, driver *conformance.Driver, bs blockstore.Blockstore, mcid cid.Cid, msg *types.Message, execTs, incTs *types.TipSet) (cid.Cid, error) {
+	msgs, err := x.api.ChainGetParentMessages(ctx, execTs.Blocks()[0].Cid())
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to fetch messages in canonical order from inclusion tipset: %w", err)
+	}
+	related, found, err := x.findMsgAndPrecursors(ctx, x.opts.Precursor, mcid, msg.From, msg.To, msgs)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if !found {
+		return cid.Undef, fmt.Errorf("%w: %s in inclusion tipset", ErrMessageNotFound, mcid)
+	}
+	senderID := x.mustResolveAddr(ctx, msg.From)
+	precursors := selectPrecursors(related[:len(related)-1], x.opts.IgnorePrecursors, x.opts.MaxPrecursors, func(m *types.Message) bool {
+		return x.mustResolveAddr(ctx, m.From) == senderID
+	})
+
+	nv, err := x.api.StateNetworkVersion(ctx, incTs.Key())
+	if err != nil {
+		return cid.Undef, err
+	}
+	circSupply, err := x.api.StateVMCirculatingSupplyInternal(ctx, incTs.Key())
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	root := incTs.ParentState()
+	for _, m := range precursors {
+		_, root, err = driver.ExecuteMessage(bs, conformance.ExecuteMessageParams{
+			Preroot:    root,
+			Epoch:      incTs.Height(),
+			Message:    m,
+			CircSupply: circSupply.FilCirculating,
+			BaseFee:    incTs.Blocks()[0].ParentBaseFee,
+			// randomness drawn by squashed precursors will be discarded.
+			Rand:           conformance.NewRecordingRand(new(conformance.LogReporter), x.api),
+			NetworkVersion: nv,
+		})
+		if err != nil {
+			return cid.Undef, fmt.Errorf("failed to execute precursor message: %w", err)
+		}
+	}
+	extractLog.Infow("applied precursors", "message", mcid, "count", len(precursors))
+	return root, nil
+}
+
+// findMsigProposal walks back the messages sent to the multisig approved by
+// the message, up to lookback epochs before the inclusion tipset, for the
+// proposal that created the transaction.
+func (x *extraction) findMsigProposal(ctx context.Context, approve *types.Message, incTs *types.TipSet, txn int64, lookback abi.ChainEpoch) (*types.Message, *api.MsgLookup, error) {
+	toHeight := incTs.Height() - lookback
+	if toHeight < 0 {
+		toHeight = 0
+	}
+	extractLog.Infow("searching for proposal", "multisig", approve.To, "txn", txn, "from_height", incTs.Height(), "to_height", toHeight)
+
+	cids, err := x.api.StateListMessages(ctx, &api.MessageMatch{To: approve.To}, incTs.Key(), toHeight)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list messages to multisig %s: %w", approve.To, err)
+	}
+	for _, c := range cids {
+		msg, err := x.api.ChainGetMessage(ctx, c)
+		if err != nil {
+			return nil, nil, err
+		}
+		if msg.Method != multisig.Methods.Propose {
+			continue
+		}
+		lookup, err := x.api.StateSearchMsg(ctx, c)
+		if err != nil || lookup == nil || lookup.Receipt.ExitCode != exitcode.Ok {
+			continue
+		}
+		var ret multisig.ProposeReturn
+		if err := ret.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return)); err != nil {
+			continue
+		}
+		if int64(ret.TxnID) == txn {
+			if ret.Applied {
+				return nil, nil, fmt.Errorf("proposal %s of transaction %d was applied when proposed", c, txn)
+			}
+			return msg, lookup, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no proposal of transaction %d found within %d epochs; try a larger --msig-lookback", txn, lookback)
+}
+
+// rewindActors returns the root of the state tree at root, with the supplied
+// actors replaced with their states in the state tree at past.
+func rewindActors(ctx context.Context, bs blockstore.Blockstore, root, past cid.Cid, actors ...address.Address) (cid.Cid, error) {
+	cst := cbor.NewCborStore(bs)
+	st, err := state.LoadStateTree(cst, root)
+	if err != nil {
+		return cid.Undef, err
+	}
+	pst, err := state.LoadStateTree(cst, past)
+	if err != nil {
+		return cid.Undef, err
+	}
+	for _, a := range actors {
+		act, err := pst.GetActor(a)
+		if err != nil {
+			return cid.Undef, fmt.Errorf("failed to get actor %s: %w", a, err)
+		}
+		if err := st.SetActor(a, act); err != nil {
+			return cid.Undef, err
+		}
+	}
+	return st.Flush(ctx)
+}
+
+// msigInnerCall returns the call a multisig approval executed, as found in
+// its execution trace: the first call of the proposed method, with the
+// proposed value, made by the multisig; nil if none.
+func msigInnerCall(trace *types.ExecutionTrace, proposal *multisig.ProposeParams) *types.ExecutionTrace {
+	for i := range trace.Subcalls {
+		sub := &trace.Subcalls[i]
+		if sub.Msg != nil && sub.MsgRct != nil && sub.Msg.Method == proposal.Method && types.BigCmp(sub.Msg.Value, proposal.Value) == 0 {
+			return sub
+		}
+	}
+	return nil
+}
+
+// checkFlowReceipt compares the exit code and return value of a message of a
+// multi-message flow with its on-chain receipt; gas used is expected to
+// differ, as the messages aren't applied on their on-chain states.
+func checkFlowReceipt(name string, expected *types.MessageReceipt, actual *vm.ApplyRet) []string {
+	var failures []string
+	if expected.ExitCode != actual.ExitCode {
+		failures = append(failures, fmt.Sprintf("%s exit code: expected %d, got %d", name, expected.ExitCode, actual.ExitCode))
+	}
+	if !bytes.Equal(expected.Return, actual.Return) {
+		failures = append(failures, fmt.Sprintf("%s return value: expected %x, got %x", name, expected.Return, actual.Return))
+	}
+	if expected.GasUsed != actual.GasUsed {
+		extractLog.Warnw("gas used differs from chain", "message", name, "expected", expected.GasUsed, "actual", actual.GasUsed)
+	}
+	return failures
+}
@@ -0,0 +1,50 @@
+// stm: #unit
+package extractor
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/multisig"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+func TestMsigInnerCall(t *testing.T) {
+	msig, _ := address.NewIDAddress(1000)
+	target, _ := address.NewIDAddress(1001)
+	proposal := &multisig.ProposeParams{To: target, Value: abi.NewTokenAmount(10), Method: 2}
+
+	trace := &types.ExecutionTrace{
+		Msg: &types.Message{To: msig, Method: multisig.Methods.Approve, Value: abi.NewTokenAmount(0)},
+		Subcalls: []types.ExecutionTrace{
+			// e.g. a lookup by the multisig, unrelated to the proposal.
+			{Msg: &types.Message{From: msig, To: target, Method: 2, Value: abi.NewTokenAmount(0)}, MsgRct: &types.MessageReceipt{}},
+			{Msg: &types.Message{From: msig, To: target, Method: 2, Value: abi.NewTokenAmount(10)}, MsgRct: &types.MessageReceipt{ExitCode: exitcode.ErrForbidden}},
+		},
+	}
+	inner := msigInnerCall(trace, proposal)
+	if inner == nil || inner.MsgRct.ExitCode != exitcode.ErrForbidden {
+		t.Fatalf("expected the proposed call, got %v", inner)
+	}
+
+	proposal.Method = 3
+	if inner := msigInnerCall(trace, proposal); inner != nil {
+		t.Fatalf("expected no inner call, got %v", inner)
+	}
+}
+
+func TestCheckFlowReceipt(t *testing.T) {
+	expected := &types.MessageReceipt{ExitCode: 0, Return: []byte{1}, GasUsed: 100}
+	actual := &vm.ApplyRet{MessageReceipt: types.MessageReceipt{ExitCode: 0, Return: []byte{1}, GasUsed: 120}}
+	if failures := checkFlowReceipt("approval", expected, actual); len(failures) != 0 {
+		t.Errorf("expected gas differences to be tolerated, got %v", failures)
+	}
+	actual.ExitCode, actual.Return = exitcode.ErrForbidden, nil
+	if failures := checkFlowReceipt("approval", expected, actual); len(failures) != 2 {
+		t.Errorf("expected exit code and return failures, got %v", failures)
+	}
+}
@@ -0,0 +1,378 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtintypes "github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/paych"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// ClassPaychFlow is the extraction mode of payment channel lifecycles:
+// message vectors applying the messages sent to a payment channel.
+const ClassPaychFlow = "paych-flow"
+
+// TagPaychFlow tags the vectors of payment channel lifecycles.
+const TagPaychFlow = "paych-flow"
+
+// paychFlowMessage is a message of a payment channel lifecycle, as found on
+// chain.
+type paychFlowMessage struct {
+	cid    cid.Cid
+	msg    *types.Message
+	lookup *api.MsgLookup
+	// execTs and incTs are the tipsets the message was executed and included
+	// in.
+	execTs, incTs *types.TipSet
+}
+
+// isPaychFlowMethod returns whether messages invoking the method are part of
+// the lifecycle of a payment channel: fundings (plain sends), voucher
+// redemptions, settlement and collection.
+func isPaychFlowMethod(m abi.MethodNum) bool {
+	switch m {
+	case builtintypes.MethodSend,
+		builtintypes.MethodsPaych.UpdateChannelState,
+		builtintypes.MethodsPaych.Settle,
+		builtintypes.MethodsPaych.Collect:
+		return true
+	}
+	return false
+}
+
+// renonce returns copies of the messages, applied in sequence, with the
+// nonces of their senders renumbered consecutively from those in the
+// pre-state, as the senders sent other messages in between on chain.
+func renonce(msgs []*types.Message, nonceOf func(address.Address) (uint64, error)) ([]*types.Message, error) {
+	next := make(map[address.Address]uint64)
+	ret := make([]*types.Message, 0, len(msgs))
+	for _, m := range msgs {
+		nonce, ok := next[m.From]
+		if !ok {
+			var err error
+			if nonce, err = nonceOf(m.From); err != nil {
+				return nil, err
+			}
+		}
+		cpy := *m
+		cpy.Nonce = nonce
+		next[m.From] = nonce + 1
+		ret = append(ret, &cpy)
+	}
+	return ret, nil
+}
+
+// doExtractPaychFlow extracts a message vector applying the lifecycle of the
+// payment channel: the fundings, voucher redemptions, settlement and
+// collection found on chain, in sequence, at the epochs they were included
+// at.
+//
+// The pre-state is that of the first message, after its precursors. As the
+// senders sent other messages in between on chain, their nonces are
+// renumbered; the original messages are recorded in the metadata. Cron is
+// applied at the end of every epoch between the messages, so that the vector
+// spans epochs as the chain did. The lane states of the channel are retained
+// in full, in the pre- and post-states.
+func (x *extraction) paychFlow(ctx context.Context) (*schema.TestVector, error) {
+	opts := x.opts
+
+	if opts.Paych == "" {
+		return nil, fmt.Errorf("missing payment channel address")
+	}
+	if opts.Retain != "accessed-cids" {
+		return nil, fmt.Errorf("payment channel flows require 'accessed-cids' state retention")
+	}
+	ch, err := address.NewFromString(opts.Paych)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payment channel address %s: %w", opts.Paych, err)
+	}
+
+	selector, err := ParseSelectors(opts.Selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	flow, err := x.findPaychFlow(ctx, ch, abi.ChainEpoch(opts.PaychLookback))
+	if err != nil {
+		return nil, err
+	}
+	extractLog.Infow("found payment channel lifecycle", "channel", ch, "messages", len(flow))
+	preflight, err := x.preflight(ctx, flow[len(flow)-1].execTs)
+	if err != nil {
+		return nil, err
+	}
+
+	first := flow[0]
+	execTs, incTs := first.execTs, first.incTs
+	nv, err := x.api.StateNetworkVersion(ctx, incTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve network version from inclusion height: %w", err)
+	}
+	circSupplyDetail, err := x.api.StateVMCirculatingSupplyInternal(ctx, incTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed while fetching circulating supply: %w", err)
+	}
+	basefee := incTs.Blocks()[0].ParentBaseFee
+
+	pst, g := x.stores(ctx, incTs.Height())
+	tbs, ok := pst.Blockstore.(TracingBlockstore)
+	if !ok {
+		return nil, fmt.Errorf("%w: requested 'accessed-cids' state retention, but no tracing blockstore was present", ErrRetentionUnsupported)
+	}
+
+	driver := conformance.NewDriver(ctx, selector, conformance.DriverOpts{DisableVMFlush: true, CustomActors: x.opts.CustomActors})
+	recordingRand := conformance.NewRecordingRand(new(conformance.LogReporter), x.api)
+
+	preroot, err := x.applySquashedPrecursors(ctx, driver, pst.Blockstore, first.cid, first.msg, execTs, incTs)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := state.LoadStateTree(cbor.NewCborStore(pst.Blockstore), preroot)
+	if err != nil {
+		return nil, err
+	}
+	orig := make([]*types.Message, len(flow))
+	for i, f := range flow {
+		orig[i] = f.msg
+	}
+	msgs, err := renonce(orig, func(a address.Address) (uint64, error) {
+		act, err := st.GetActor(a)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get sender %s: %w", a, err)
+		}
+		return act.Nonce, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		rets     = make([]*vm.ApplyRet, len(msgs))
+		postroot cid.Cid
+		lookback []conformance.LookbackEntry
+	)
+	accessed, err := tbs.Trace(func(bs blockstore.Blockstore) error {
+		steps := make([]conformance.SequenceStep, len(msgs))
+		for i, m := range msgs {
+			steps[i].Message = m
+			if i > 0 {
+				steps[i].Advance = flow[i].incTs.Height() - flow[i-1].incTs.Height()
+			}
+		}
+		res, err := driver.ExecuteSequence(bs, conformance.ExecuteSequenceParams{
+			Preroot:        preroot,
+			BaseEpoch:      incTs.Height(),
+			Steps:          steps,
+			CircSupply:     circSupplyDetail.FilCirculating,
+			BaseFee:        basefee,
+			Rand:           recordingRand,
+			NetworkVersion: nv,
+			Cron:           true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to execute payment channel lifecycle: %w", err)
+		}
+		for i, ret := range res.Rets {
+			rets[i] = ret
+			x.emit(EventMessageApplied, "cid", flow[i].cid.String(), "exit_code", ret.ExitCode, "gas_used", ret.GasUsed)
+		}
+		postroot = res.Root
+
+		// retain the lane states in full, besides those accessed.
+		for _, root := range []cid.Cid{preroot, postroot} {
+			if err := loadPaychLanes(ctx, bs, root, ch); err != nil {
+				return fmt.Errorf("failed to load lane states: %w", err)
+			}
+		}
+		lookback = x.recordLookback(ctx, bs, recordingRand.Recorded(), flow[len(flow)-1].execTs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	x.stateRetained(ctx, pst.Blockstore, accessed)
+
+	var failures []string
+	for i, f := range flow {
+		failures = append(failures, checkFlowReceipt(fmt.Sprintf("message %d (%s)", i, f.cid), &f.lookup.Receipt, rets[i])...)
+	}
+	x.sanityChecked(len(failures) == 0)
+	for _, f := range failures {
+		extractLog.Errorw("sanity check failed", "failure", f)
+	}
+	if len(failures) > 0 && !opts.IgnoreSanityChecks && !opts.Force {
+		return nil, &SanityCheckError{Field: "receipts", Detail: strings.Join(failures, "; ")}
+	}
+
+	carBytes, err := x.encodeCAR(func(w io.Writer) error {
+		return g.WriteCARIncluding(w, accessed, preroot, postroot)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := x.api.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ntwkName, err := x.api.StateNetworkName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	codename := GetProtocolCodename(execTs.Height())
+	if opts.ID == "" {
+		opts.ID = fmt.Sprintf("paych-flow-%s-%d", ch, len(flow))
+	}
+
+	vector := schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta: &schema.Metadata{
+			ID: opts.ID,
+			Gen: []schema.GenerationData{
+				{Source: fmt.Sprintf("network:%s", ntwkName)},
+				{Source: fmt.Sprintf("paych:%s", ch)},
+				{Source: fmt.Sprintf("inclusion_tipset:%s", incTs.Key())},
+				{Source: "github.com/filecoin-project/lotus", Version: version.String()}},
+			Tags: []string{TagPaychFlow},
+		},
+		Selector: schema.Selector{
+			schema.SelectorMinProtocolVersion: codename,
+		},
+		Randomness: recordingRand.Recorded(),
+		CAR:        carBytes,
+		Pre: &schema.Preconditions{
+			Variants: []schema.Variant{
+				{ID: codename, Epoch: int64(incTs.Height()), NetworkVersion: uint(nv)},
+			},
+			CircSupply: circSupplyDetail.FilCirculating.Int,
+			BaseFee:    basefee.Int,
+			StateTree:  &schema.StateTree{RootCID: preroot},
+		},
+		Post: &schema.Postconditions{
+			StateTree: &schema.StateTree{RootCID: postroot},
+		},
+	}
+	for i, m := range msgs {
+		b, err := m.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		am := schema.Message{Bytes: b}
+		if i > 0 {
+			offset := int64(flow[i].incTs.Height() - flow[i-1].incTs.Height())
+			am.EpochOffset = &offset
+		}
+		vector.ApplyMessages = append(vector.ApplyMessages, am)
+		vector.Post.Receipts = append(vector.Post.Receipts, &schema.Receipt{
+			ExitCode:    int64(rets[i].ExitCode),
+			ReturnValue: rets[i].Return,
+			GasUsed:     rets[i].GasUsed,
+		})
+		vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{
+			Source:  "paych_message:" + flow[i].cid.String(),
+			Version: fmt.Sprintf("method=%d,nonce=%d,height=%d", m.Method, flow[i].msg.Nonce, flow[i].incTs.Height()),
+		})
+	}
+	vector.Meta.Gen = append(vector.Meta.Gen, lookbackGen(lookback)...)
+	vector.Meta.Gen = append(vector.Meta.Gen, preflight...)
+	vector.Hints = opts.Hints
+
+	if incTs.Height() != flow[len(flow)-1].incTs.Height() {
+		vector.Selector[conformance.SelectorEpochCron] = "true"
+	}
+	PopulateSelector(&vector, selector, rets...)
+	return &vector, nil
+}
+
+// findPaychFlow returns the messages of the lifecycle of the payment channel
+// sent up to lookback epochs before the head, in the order they were
+// applied.
+func (x *extraction) findPaychFlow(ctx context.Context, ch address.Address, lookback abi.ChainEpoch) ([]paychFlowMessage, error) {
+	head, err := x.api.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	toHeight := head.Height() - lookback
+	if toHeight < 0 {
+		toHeight = 0
+	}
+	extractLog.Infow("searching for payment channel messages", "channel", ch, "from_height", head.Height(), "to_height", toHeight)
+
+	cids, err := x.api.StateListMessages(ctx, &api.MessageMatch{To: ch}, head.Key(), toHeight)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages to payment channel %s: %w", ch, err)
+	}
+
+	var flow []paychFlowMessage
+	for _, c := range cids {
+		msg, err := x.api.ChainGetMessage(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if !isPaychFlowMethod(msg.Method) {
+			continue
+		}
+		lookup, err := x.api.StateSearchMsg(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate message %s: %w", c, err)
+		}
+		if lookup == nil {
+			continue
+		}
+		execTs, incTs, err := fetchThisAndPrevTipset(ctx, x.api, lookup.TipSet)
+		if err != nil {
+			return nil, err
+		}
+		flow = append(flow, paychFlowMessage{cid: c, msg: msg, lookup: lookup, execTs: execTs, incTs: incTs})
+	}
+	if len(flow) == 0 {
+		return nil, fmt.Errorf("no messages sent to payment channel %s within %d epochs; try a larger --paych-lookback", ch, lookback)
+	}
+
+	// messages are applied by inclusion tipset, then by sender nonce.
+	sort.SliceStable(flow, func(i, j int) bool {
+		if hi, hj := flow[i].incTs.Height(), flow[j].incTs.Height(); hi != hj {
+			return hi < hj
+		}
+		return flow[i].msg.Nonce < flow[j].msg.Nonce
+	})
+	return flow, nil
+}
+
+// loadPaychLanes reads all the lane states of the payment channel in the
+// state tree at root through the blockstore, so that a tracing blockstore
+// retains them.
+func loadPaychLanes(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, ch address.Address) error {
+	store := adt.WrapStore(ctx, cbor.NewCborStore(bs))
+	st, err := state.LoadStateTree(store, root)
+	if err != nil {
+		return err
+	}
+	act, err := st.GetActor(ch)
+	if err != nil {
+		return err
+	}
+	pst, err := paych.Load(store, act)
+	if err != nil {
+		return err
+	}
+	return pst.ForEachLaneState(func(uint64, paych.LaneState) error { return nil })
+}
@@ -0,0 +1,44 @@
+// stm: #unit
+package extractor
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	builtintypes "github.com/filecoin-project/go-state-types/builtin"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestRenonce(t *testing.T) {
+	from, _ := address.NewIDAddress(1000)
+	to, _ := address.NewIDAddress(1001)
+	msgs := []*types.Message{
+		{From: from, Nonce: 7},
+		{From: to, Nonce: 3},
+		{From: from, Nonce: 12},
+	}
+	nonces := map[address.Address]uint64{from: 5, to: 3}
+
+	ret, err := renonce(msgs, func(a address.Address) (uint64, error) { return nonces[a], nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []uint64{5, 3, 6} {
+		if ret[i].Nonce != expected {
+			t.Errorf("message %d: expected nonce %d, got %d", i, expected, ret[i].Nonce)
+		}
+	}
+	if msgs[0].Nonce != 7 || msgs[2].Nonce != 12 {
+		t.Errorf("original messages were modified")
+	}
+}
+
+func TestIsPaychFlowMethod(t *testing.T) {
+	if !isPaychFlowMethod(builtintypes.MethodsPaych.Collect) || !isPaychFlowMethod(builtintypes.MethodSend) {
+		t.Errorf("expected lifecycle methods to be part of the flow")
+	}
+	if isPaychFlowMethod(builtintypes.MethodsPaych.Constructor) {
+		t.Errorf("expected the constructor not to be part of the flow")
+	}
+}
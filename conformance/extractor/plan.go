@@ -0,0 +1,277 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	init_ "github.com/filecoin-project/lotus/chain/actors/builtin/init"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/reward"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+// Plan is the plan of an extraction, as resolved by PlanExtraction without
+// applying any message, nor fetching any state besides that of the chain.
+type Plan struct {
+	Class string
+	// Vectors is the number of vectors the extraction would yield.
+	Vectors int
+	// Retain is the state retention policy.
+	Retain string
+
+	// Message is the message to extract, and InclusionTipset and
+	// ExecutionTipset the tipsets it was included and executed in, for
+	// message vectors.
+	Message         cid.Cid
+	InclusionTipset types.TipSetKey
+	ExecutionTipset types.TipSetKey
+	// NullRounds are the null rounds preceding the inclusion tipset, whose
+	// cron ticks are applied first.
+	NullRounds []abi.ChainEpoch
+	// Precursor is the precursor selection mode, Precursors the precursors
+	// selected, and SkippedPrecursors the number of those skipped due to
+	// the options.
+	Precursor         string
+	Precursors        []cid.Cid
+	SkippedPrecursors int
+	EmbedPrecursors   bool
+
+	// Tipsets are the tipsets to apply, for tipset and blockseq vectors.
+	Tipsets []PlannedTipset
+
+	// Epoch and NetworkVersion are those of the base state of the
+	// extraction.
+	Epoch          abi.ChainEpoch
+	NetworkVersion network.Version
+
+	// Actors are the actors the extraction involves, whose state the
+	// estimated state size covers; UnresolvedActors is the number of those
+	// not found in the base state, e.g. created by the messages.
+	Actors           []address.Address
+	UnresolvedActors int
+	// StateSize and StateBlocks are an upper bound of the size and number of
+	// blocks of the state retained: the whole state of the actors involved.
+	// Actual retention is usually much smaller, as messages only access a
+	// fraction of that state.
+	StateSize   uint64
+	StateBlocks uint64
+}
+
+// PlannedTipset is a tipset of a Plan.
+type PlannedTipset struct {
+	Key   types.TipSetKey
+	Epoch abi.ChainEpoch
+	// Messages is the number of distinct messages in the blocks of the
+	// tipset.
+	Messages int
+}
+
+// PlanExtraction resolves the message, tipsets and precursors of the
+// extraction requested in the options, and estimates the size of the state
+// it would retain, without extracting anything. It supports the message,
+// tipset and blockseq classes.
+func PlanExtraction(ctx context.Context, api v0api.FullNode, opts Options) (*Plan, error) {
+	x := &extraction{
+		api:   api,
+		opts:  opts,
+		addrs: make(map[address.Address]address.Address),
+	}
+	switch opts.Class {
+	case string(schema.ClassMessage):
+		return x.planMessage(ctx)
+	case string(schema.ClassTipset), string(schema.ClassBlockSeq):
+		return x.planTipsets(ctx)
+	default:
+		return nil, fmt.Errorf("dry runs are not supported for class %s", opts.Class)
+	}
+}
+
+func (x *extraction) planMessage(ctx context.Context) (*Plan, error) {
+	opts := x.opts
+
+	if opts.Message != nil {
+		return nil, fmt.Errorf("extractions of supplied messages can't be planned")
+	}
+	if opts.CID == "" {
+		return nil, fmt.Errorf("missing message CID")
+	}
+	if opts.Retain != "accessed-cids" && opts.Retain != "accessed-actors" {
+		return nil, fmt.Errorf("%w: unknown state retention option: %s", ErrRetentionUnsupported, opts.Retain)
+	}
+	if opts.EmbedPrecursors && opts.Retain != "accessed-cids" {
+		return nil, fmt.Errorf("%w: embedding precursors requires 'accessed-cids' state retention", ErrRetentionUnsupported)
+	}
+	if opts.FromMpool {
+		return nil, fmt.Errorf("extractions from the message pool can't be planned; the head moves on")
+	}
+
+	mcid, err := cid.Decode(opts.CID)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, execTs, incTs, err := x.resolveFromChain(ctx, mcid, opts.Block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve message and tipsets from chain: %w", err)
+	}
+	nv, err := x.api.StateNetworkVersion(ctx, incTs.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve network version from inclusion height: %w", err)
+	}
+	parentTs, err := x.api.ChainGetTipSet(ctx, incTs.Parents())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch parent of inclusion tipset: %w", err)
+	}
+	precursors, skipped, _, err := x.resolvePrecursors(ctx, mcid, msg, execTs)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Class:             opts.Class,
+		Vectors:           1,
+		Retain:            opts.Retain,
+		Message:           mcid,
+		InclusionTipset:   incTs.Key(),
+		ExecutionTipset:   execTs.Key(),
+		NullRounds:        nullRounds(parentTs, incTs),
+		Precursor:         opts.Precursor,
+		SkippedPrecursors: skipped,
+		EmbedPrecursors:   opts.EmbedPrecursors,
+		Epoch:             incTs.Height(),
+		NetworkVersion:    nv,
+	}
+	for _, p := range precursors {
+		plan.Precursors = append(plan.Precursors, p.Cid())
+	}
+	msgs := append(append([]*types.Message{}, precursors...), msg)
+	if err := x.estimateState(ctx, plan, incTs.Key(), msgs); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (x *extraction) planTipsets(ctx context.Context) (*Plan, error) {
+	opts := x.opts
+
+	if opts.Retain != "accessed-cids" {
+		return nil, fmt.Errorf("tipset extraction only supports 'accessed-cids' state retention")
+	}
+	if opts.TSK == "" {
+		return nil, fmt.Errorf("tipset key cannot be empty")
+	}
+
+	var tss []*types.TipSet
+	switch ss := strings.Split(opts.TSK, ".."); len(ss) {
+	case 1:
+		ts, err := lcli.ParseTipSetRef(ctx, x.api, opts.TSK)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch tipset: %w", err)
+		}
+		tss = []*types.TipSet{ts}
+	case 2:
+		left, err := lcli.ParseTipSetRef(ctx, x.api, ss[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch tipset %s: %w", ss[0], err)
+		}
+		right, err := lcli.ParseTipSetRef(ctx, x.api, ss[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch tipset %s: %w", ss[1], err)
+		}
+		if tss, err = x.resolveTipsetRange(ctx, left, right); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unrecognized tipset format")
+	}
+
+	nv, err := x.api.StateNetworkVersion(ctx, tss[0].Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve network version: %w", err)
+	}
+	plan := &Plan{
+		Class:          opts.Class,
+		Vectors:        1,
+		Retain:         opts.Retain,
+		Epoch:          tss[0].Height(),
+		NetworkVersion: nv,
+	}
+	if opts.Multiple() {
+		plan.Vectors = len(tss)
+	}
+
+	var msgs []*types.Message
+	for _, ts := range tss {
+		seen := make(map[cid.Cid]struct{})
+		for _, b := range ts.Blocks() {
+			bm, err := x.api.ChainGetBlockMessages(ctx, b.Cid())
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch messages of block %s: %w", b.Cid(), err)
+			}
+			all := bm.BlsMessages
+			for _, sm := range bm.SecpkMessages {
+				all = append(all, &sm.Message)
+			}
+			for _, m := range all {
+				if _, ok := seen[m.Cid()]; ok {
+					continue
+				}
+				seen[m.Cid()] = struct{}{}
+				msgs = append(msgs, m)
+			}
+		}
+		plan.Tipsets = append(plan.Tipsets, PlannedTipset{Key: ts.Key(), Epoch: ts.Height(), Messages: len(seen)})
+	}
+	if err := x.estimateState(ctx, plan, tss[0].Key(), msgs); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// estimateState populates the actors of the plan, those sending and
+// receiving the messages along with the reward, burnt funds and init actors,
+// and the size of their state in the parent state of the tipset.
+func (x *extraction) estimateState(ctx context.Context, plan *Plan, tsk types.TipSetKey, msgs []*types.Message) error {
+	var (
+		seen  = make(map[address.Address]struct{})
+		addrs = []address.Address{reward.Address, builtin.BurntFundsActorAddr, init_.Address}
+	)
+	for _, m := range msgs {
+		addrs = append(addrs, m.From, m.To)
+	}
+	for _, addr := range addrs {
+		id, err := x.api.StateLookupID(ctx, addr, tsk)
+		if err != nil {
+			// not in the base state, e.g. created by the messages.
+			plan.UnresolvedActors++
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		act, err := x.api.StateGetActor(ctx, id, tsk)
+		if err != nil {
+			return fmt.Errorf("failed to get actor %s: %w", id, err)
+		}
+		stat, err := x.api.ChainStatObj(ctx, act.Head, cid.Undef)
+		if err != nil {
+			return fmt.Errorf("failed to stat the state of actor %s: %w", id, err)
+		}
+		plan.Actors = append(plan.Actors, id)
+		plan.StateSize += stat.Size
+		plan.StateBlocks += stat.Links
+	}
+	return nil
+}
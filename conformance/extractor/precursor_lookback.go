@@ -0,0 +1,160 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// PrecursorLookbackSource is the source of the generation metadata recording
+// the decision of the precursor lookback of a message vector; see
+// Options.PrecursorLookback.
+const PrecursorLookbackSource = "precursors:lookback"
+
+// Decisions of the precursor lookback.
+const (
+	// LookbackNotNeeded: the nonce of the sender in the base state is that
+	// the precursors and the message follow.
+	LookbackNotNeeded = "not_needed"
+	// LookbackApplied: the messages of the sender filling the gap were found
+	// in the inclusion tipset and the prior tipsets, and are applied as
+	// precursors.
+	LookbackApplied = "applied"
+	// LookbackIncomplete: the gap wasn't filled, or the base state is ahead
+	// of the message; the nonces of the vector may be wrong.
+	LookbackIncomplete = "incomplete"
+)
+
+// precursorLookback is the outcome of the search of the prior tipsets for the
+// messages of the sender missing from the base state of a message vector.
+type precursorLookback struct {
+	// Tipsets is the number of prior tipsets to search, and Scanned the
+	// number searched, besides the inclusion tipset.
+	Tipsets, Scanned int
+	// StateNonce is the nonce of the sender in the base state, and
+	// ExpectedNonce that the precursors of the sender, or else the message,
+	// follow.
+	StateNonce, ExpectedNonce uint64
+	// Applied are the messages found, in nonce order.
+	Applied []*types.Message
+}
+
+// decision returns the decision of the lookback.
+func (l *precursorLookback) decision() string {
+	switch {
+	case l.StateNonce == l.ExpectedNonce:
+		return LookbackNotNeeded
+	case l.StateNonce+uint64(len(l.Applied)) == l.ExpectedNonce:
+		return LookbackApplied
+	default:
+		return LookbackIncomplete
+	}
+}
+
+// GenerationData returns the generation metadata recording the lookback in a
+// vector, in decision=<decision>,tipsets=<n>,scanned=<n>,state_nonce=<n>,
+// expected_nonce=<n>,applied=<n> form. The messages applied are recorded as
+// precursors too.
+func (l *precursorLookback) GenerationData() schema.GenerationData {
+	return schema.GenerationData{
+		Source: PrecursorLookbackSource,
+		Version: fmt.Sprintf("decision=%s,tipsets=%d,scanned=%d,state_nonce=%d,expected_nonce=%d,applied=%d",
+			l.decision(), l.Tipsets, l.Scanned, l.StateNonce, l.ExpectedNonce, len(l.Applied)),
+	}
+}
+
+// lookbackPrecursors searches the inclusion tipset, and the tipsets preceding
+// it, up to Options.PrecursorLookback of them, for the messages of the sender
+// of the message missing from the base state at root: those whose nonces lie
+// between that of the sender in the base state, and that the precursors of
+// the sender, or else the message, follow. It only applies to pre-state
+// overrides, which may lag behind the chain; the parent state of the
+// inclusion tipset already includes the messages of the prior tipsets. The
+// search stops as soon as the gap is filled; the lookback isn't needed, and
+// no tipset is searched, if there's none. If the gap isn't filled, no message
+// is applied.
+func (x *extraction) lookbackPrecursors(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, msg *types.Message, precursors []*types.Message, incTs *types.TipSet) (*precursorLookback, error) {
+	senderID := x.mustResolveAddr(ctx, msg.From)
+	l := &precursorLookback{Tipsets: x.opts.PrecursorLookback, ExpectedNonce: msg.Nonce}
+	for _, p := range precursors {
+		if x.mustResolveAddr(ctx, p.From) == senderID && p.Nonce < l.ExpectedNonce {
+			l.ExpectedNonce = p.Nonce
+		}
+	}
+
+	st, err := state.LoadStateTree(cbor.NewCborStore(bs), root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the base state tree: %w", err)
+	}
+	// senders created by the precursors don't exist yet.
+	switch act, err := st.GetActor(msg.From); {
+	case errors.Is(err, types.ErrActorNotFound):
+	case err != nil:
+		return nil, fmt.Errorf("failed to load sender %s: %w", msg.From, err)
+	default:
+		l.StateNonce = act.Nonce
+	}
+	if l.StateNonce >= l.ExpectedNonce {
+		return l, nil
+	}
+
+	// the messages of the sender in the inclusion tipset with lower nonces
+	// precede the message; the prior tipsets are then searched, most recent
+	// first.
+	var tipsets [][]api.Message
+	for ts := incTs; ; l.Scanned++ {
+		msgs, err := x.api.ChainGetMessagesInTipset(ctx, ts.Key())
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch messages of tipset %s: %w", ts.Key(), err)
+		}
+		tipsets = append(tipsets, msgs)
+		l.Applied = senderGapMessages(tipsets, l.StateNonce, l.ExpectedNonce, func(m *types.Message) bool {
+			return x.mustResolveAddr(ctx, m.From) == senderID
+		})
+		if l.StateNonce+uint64(len(l.Applied)) == l.ExpectedNonce {
+			return l, nil
+		}
+		if l.Scanned == l.Tipsets || ts.Height() == 0 {
+			break
+		}
+		if ts, err = x.api.ChainGetTipSet(ctx, ts.Parents()); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrTipsetUnavailable, err)
+		}
+	}
+	// a partial gap leaves the nonces wrong regardless.
+	l.Applied = nil
+	return l, nil
+}
+
+// senderGapMessages returns the messages of the sender among those of the
+// tipsets with consecutive nonces from the nonce from, in nonce order: those
+// filling the gap up to the nonce to, excluded, or the prefix of it found.
+func senderGapMessages(tipsets [][]api.Message, from, to uint64, fromSender func(*types.Message) bool) []*types.Message {
+	byNonce := make(map[uint64]*types.Message)
+	for _, msgs := range tipsets {
+		for _, m := range msgs {
+			if m.Message.Nonce >= from && m.Message.Nonce < to && fromSender(m.Message) {
+				byNonce[m.Message.Nonce] = m.Message
+			}
+		}
+	}
+	var gap []*types.Message
+	for n := from; n < to; n++ {
+		m, ok := byNonce[n]
+		if !ok {
+			break
+		}
+		gap = append(gap, m)
+	}
+	return gap
+}
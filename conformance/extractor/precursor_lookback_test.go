@@ -0,0 +1,156 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+)
+
+func TestSenderGapMessages(t *testing.T) {
+	msg := func(from address.Address, nonce uint64) api.Message {
+		m := &types.Message{From: from, To: address.TestAddress2, Nonce: nonce}
+		return api.Message{Cid: m.Cid(), Message: m}
+	}
+	fromSender := func(m *types.Message) bool { return m.From == address.TestAddress }
+	// the tipsets, most recent first.
+	tipsets := [][]api.Message{
+		{msg(address.TestAddress, 7), msg(address.TestAddress2, 6)},
+		{msg(address.TestAddress, 5), msg(address.TestAddress, 6)},
+		{msg(address.TestAddress, 4)},
+	}
+
+	gap := senderGapMessages(tipsets, 5, 8, fromSender)
+	if len(gap) != 3 || gap[0].Nonce != 5 || gap[1].Nonce != 6 || gap[2].Nonce != 7 {
+		t.Fatalf("unexpected gap: %v", gap)
+	}
+	for _, m := range gap {
+		if m.From != address.TestAddress {
+			t.Errorf("message of another sender in the gap: %v", m)
+		}
+	}
+
+	// the gap is filled up to the first missing nonce.
+	if gap := senderGapMessages(tipsets[1:], 5, 8, fromSender); len(gap) != 2 {
+		t.Errorf("expected a partial gap of 2 messages, got %d", len(gap))
+	}
+	if gap := senderGapMessages(tipsets, 3, 8, fromSender); len(gap) != 0 {
+		t.Errorf("expected no gap without the first nonce, got %d", len(gap))
+	}
+
+	l := &precursorLookback{Tipsets: 3, Scanned: 2, StateNonce: 5, ExpectedNonce: 8, Applied: gap}
+	if d := l.decision(); d != LookbackApplied {
+		t.Errorf("expected the lookback applied, got %s", d)
+	}
+	if g := l.GenerationData(); g.Source != PrecursorLookbackSource ||
+		g.Version != "decision=applied,tipsets=3,scanned=2,state_nonce=5,expected_nonce=8,applied=3" {
+		t.Errorf("unexpected generation data %+v", g)
+	}
+	l.Applied = nil
+	if d := l.decision(); d != LookbackIncomplete {
+		t.Errorf("expected the lookback incomplete, got %s", d)
+	}
+	l.StateNonce = 8
+	if d := l.decision(); d != LookbackNotNeeded {
+		t.Errorf("expected the lookback not needed, got %s", d)
+	}
+}
+
+// lookbackChainAPI serves the tipsets of a chain, and their messages.
+type lookbackChainAPI struct {
+	v0api.FullNode
+
+	tipsets map[types.TipSetKey]*types.TipSet
+	msgs    map[types.TipSetKey][]api.Message
+}
+
+func (a *lookbackChainAPI) ChainGetTipSet(_ context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	ts, ok := a.tipsets[tsk]
+	if !ok {
+		return nil, fmt.Errorf("tipset %s not found", tsk)
+	}
+	return ts, nil
+}
+
+func (a *lookbackChainAPI) ChainGetMessagesInTipset(_ context.Context, tsk types.TipSetKey) ([]api.Message, error) {
+	return a.msgs[tsk], nil
+}
+
+func TestLookbackPrecursors(t *testing.T) {
+	ctx := context.Background()
+	sender, other := mock.Address(100), mock.Address(101)
+	msg := func(from address.Address, nonce uint64) api.Message {
+		m := &types.Message{From: from, To: other, Nonce: nonce}
+		return api.Message{Cid: m.Cid(), Message: m}
+	}
+
+	// the pre-state lags behind the chain: the sender is at nonce 3 there,
+	// and its messages of nonces 3 to 5 are included in the last tipsets.
+	bs := blockstore.NewMemory()
+	cst := cbor.NewCborStore(bs)
+	st, err := state.NewStateTree(cst, types.StateTreeVersion0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := cst.Put(ctx, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetActor(sender, &types.Actor{Code: builtin0.AccountActorCodeID, Head: head, Nonce: 3, Balance: types.NewInt(0)}); err != nil {
+		t.Fatal(err)
+	}
+	root, err := st.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen := mock.TipSet(mock.MkBlock(nil, 1, 1))
+	parent := mock.TipSet(mock.MkBlock(gen, 1, 2))
+	incTs := mock.TipSet(mock.MkBlock(parent, 1, 3))
+	target := msg(sender, 6)
+	node := &lookbackChainAPI{
+		tipsets: map[types.TipSetKey]*types.TipSet{gen.Key(): gen, parent.Key(): parent, incTs.Key(): incTs},
+		msgs: map[types.TipSetKey][]api.Message{
+			gen.Key():    {msg(sender, 3)},
+			parent.Key(): {msg(other, 4), msg(sender, 4)},
+			incTs.Key():  {msg(sender, 5), target},
+		},
+	}
+
+	for _, tc := range []struct {
+		tipsets, scanned int
+		decision         string
+	}{
+		{1, 1, LookbackIncomplete},
+		{2, 2, LookbackApplied},
+		{5, 2, LookbackApplied},
+	} {
+		x := &extraction{
+			api:   node,
+			opts:  Options{PrecursorLookback: tc.tipsets},
+			addrs: map[address.Address]address.Address{sender: sender, other: other},
+		}
+		l, err := x.lookbackPrecursors(ctx, bs, root, target.Message, nil, incTs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := l.decision(); d != tc.decision || l.Scanned != tc.scanned {
+			t.Errorf("looking back %d tipsets: expected %s after scanning %d, got %s after %d", tc.tipsets, tc.decision, tc.scanned, d, l.Scanned)
+		}
+		if tc.decision == LookbackApplied && (len(l.Applied) != 3 || l.Applied[0].Nonce != 3 || l.Applied[2].Nonce != 5) {
+			t.Errorf("looking back %d tipsets: unexpected messages applied: %v", tc.tipsets, l.Applied)
+		}
+	}
+}
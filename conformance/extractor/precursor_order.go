@@ -0,0 +1,167 @@
+package extractor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// PrecursorOrderSource is the source of the generation metadata recording the
+// PrecursorOrder of a message vector.
+const PrecursorOrderSource = "precursors:order"
+
+// PrecursorOrder records the positions of the message of a vector, and of the
+// precursors it applies, among the messages of its inclusion tipset, in the
+// canonical order ChainGetParentMessages returns them in. It tells which
+// ordering the vector was generated with, to triage precursor-related gas
+// mismatches.
+type PrecursorOrder struct {
+	// Target is the position of the message of the vector, and TargetCID its
+	// CID, as returned by the node.
+	Target    int
+	TargetCID cid.Cid
+	// Total is the number of messages of the tipset.
+	Total int
+	// Positions are the positions of the precursors applied, in the order
+	// applied, and Precursors their CIDs, as returned by the node.
+	Positions  []int
+	Precursors []cid.Cid
+}
+
+// newPrecursorOrder returns the order of the target message and its selected
+// precursors among the messages of the tipset, which the precursors point
+// into.
+func newPrecursorOrder(msgs []api.Message, target cid.Cid, precursors []*types.Message) *PrecursorOrder {
+	o := &PrecursorOrder{Target: -1, TargetCID: target, Total: len(msgs)}
+	pos := make(map[*types.Message]int, len(msgs))
+	for i, m := range msgs {
+		pos[m.Message] = i
+		if m.Cid == target {
+			o.Target = i
+		}
+	}
+	for _, p := range precursors {
+		if i, ok := pos[p]; ok {
+			o.Positions = append(o.Positions, i)
+			o.Precursors = append(o.Precursors, msgs[i].Cid)
+		}
+	}
+	return o
+}
+
+// GenerationData returns the generation metadata recording the order in a
+// vector, in target=<pos>@<cid>,total=<n>,precursors=<pos>@<cid>;... form.
+func (o *PrecursorOrder) GenerationData() schema.GenerationData {
+	precursors := make([]string, len(o.Precursors))
+	for i, c := range o.Precursors {
+		precursors[i] = fmt.Sprintf("%d@%s", o.Positions[i], c)
+	}
+	return schema.GenerationData{
+		Source:  PrecursorOrderSource,
+		Version: fmt.Sprintf("target=%d@%s,total=%d,precursors=%s", o.Target, o.TargetCID, o.Total, strings.Join(precursors, ";")),
+	}
+}
+
+// ParsePrecursorOrder parses the precursor order recorded in the generation
+// metadata of a vector. It returns false if none is recorded.
+func ParsePrecursorOrder(gen []schema.GenerationData) (*PrecursorOrder, bool, error) {
+	for _, g := range gen {
+		if g.Source != PrecursorOrderSource {
+			continue
+		}
+		o, err := parsePrecursorOrder(g.Version)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid precursor order %q: %w", g.Version, err)
+		}
+		return o, true, nil
+	}
+	return nil, false, nil
+}
+
+func parsePrecursorOrder(s string) (*PrecursorOrder, error) {
+	o := new(PrecursorOrder)
+	var err error
+	for _, field := range strings.SplitN(s, ",", 3) {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed field %q", field)
+		}
+		switch k {
+		case "target":
+			if o.Target, o.TargetCID, err = parsePositionedCid(v); err != nil {
+				return nil, err
+			}
+		case "total":
+			if o.Total, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("malformed total %q: %w", v, err)
+			}
+		case "precursors":
+			if v == "" {
+				continue
+			}
+			for _, p := range strings.Split(v, ";") {
+				i, c, err := parsePositionedCid(p)
+				if err != nil {
+					return nil, err
+				}
+				o.Positions = append(o.Positions, i)
+				o.Precursors = append(o.Precursors, c)
+			}
+		default:
+			return nil, fmt.Errorf("unknown field %q", k)
+		}
+	}
+	return o, nil
+}
+
+// parsePositionedCid parses a <pos>@<cid> pair.
+func parsePositionedCid(s string) (int, cid.Cid, error) {
+	p, c, ok := strings.Cut(s, "@")
+	if !ok {
+		return 0, cid.Undef, fmt.Errorf("malformed position %q", s)
+	}
+	i, err := strconv.Atoi(p)
+	if err != nil {
+		return 0, cid.Undef, fmt.Errorf("malformed position %q: %w", s, err)
+	}
+	parsed, err := cid.Decode(c)
+	if err != nil {
+		return 0, cid.Undef, fmt.Errorf("malformed CID %q: %w", c, err)
+	}
+	return i, parsed, nil
+}
+
+// Verify cross-checks the order against the messages of the inclusion tipset,
+// in canonical order, as returned by ChainGetParentMessages, and returns the
+// discrepancies.
+func (o *PrecursorOrder) Verify(msgs []api.Message) []string {
+	var problems []string
+	if len(msgs) != o.Total {
+		problems = append(problems, fmt.Sprintf("tipset includes %d messages; the vector recorded %d", len(msgs), o.Total))
+	}
+	pos := make(map[cid.Cid]int, len(msgs))
+	for i, m := range msgs {
+		pos[m.Cid] = i
+	}
+	check := func(what string, recorded int, c cid.Cid) {
+		actual, ok := pos[c]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s %s is not included in the tipset", what, c))
+		case actual != recorded:
+			problems = append(problems, fmt.Sprintf("%s %s is at position %d; the vector recorded %d", what, c, actual, recorded))
+		}
+	}
+	check("message", o.Target, o.TargetCID)
+	for i, c := range o.Precursors {
+		check("precursor", o.Positions[i], c)
+	}
+	return problems
+}
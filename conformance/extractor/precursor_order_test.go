@@ -0,0 +1,50 @@
+// stm: #unit
+package extractor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestPrecursorOrder(t *testing.T) {
+	var msgs []api.Message
+	for i := uint64(0); i < 5; i++ {
+		m := &types.Message{From: address.TestAddress, To: address.TestAddress2, Nonce: i}
+		msgs = append(msgs, api.Message{Cid: m.Cid(), Message: m})
+	}
+
+	order := newPrecursorOrder(msgs, msgs[3].Cid, []*types.Message{msgs[0].Message, msgs[2].Message})
+	if order.Target != 3 || order.Total != 5 || !reflect.DeepEqual(order.Positions, []int{0, 2}) {
+		t.Fatalf("unexpected order: %+v", order)
+	}
+
+	parsed, ok, err := ParsePrecursorOrder([]schema.GenerationData{{Source: "message:x"}, order.GenerationData()})
+	if err != nil || !ok {
+		t.Fatalf("failed to parse the recorded order: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, order) {
+		t.Fatalf("expected %+v; got %+v", order, parsed)
+	}
+	if p := parsed.Verify(msgs); len(p) != 0 {
+		t.Errorf("unexpected problems: %v", p)
+	}
+
+	// the node orders the first two messages differently.
+	reordered := append([]api.Message{msgs[1], msgs[0]}, msgs[2:]...)
+	if p := parsed.Verify(reordered); len(p) != 1 {
+		t.Errorf("expected a single problem; got %v", p)
+	}
+	if p := parsed.Verify(msgs[:3]); len(p) != 2 {
+		t.Errorf("expected the total and the missing message to be reported; got %v", p)
+	}
+
+	if _, ok, err := ParsePrecursorOrder(nil); ok || err != nil {
+		t.Errorf("expected no recorded order; got %t, %v", ok, err)
+	}
+}
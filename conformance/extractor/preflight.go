@@ -0,0 +1,137 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Pre-flight check modes; see Options.Preflight.
+const (
+	// PreflightOff skips the pre-flight checks.
+	PreflightOff = ""
+	// PreflightWarn logs the failed pre-flight checks, and proceeds.
+	PreflightWarn = "warn"
+	// PreflightRefuse refuses to extract if a pre-flight check fails.
+	PreflightRefuse = "refuse"
+)
+
+// DefaultConfidence is the default number of epochs the execution tipset of
+// an extraction must be behind the head of the node to be deemed final.
+var DefaultConfidence = policy.ChainFinality
+
+// maxHeadLag is the number of epochs the head of a synced node may lag behind
+// the wall clock.
+const maxHeadLag = 5
+
+// PreflightResult is the outcome of the pre-flight checks of an extraction.
+type PreflightResult struct {
+	// Head is the epoch of the head of the node, and Lag how far behind the
+	// wall clock its timestamp is.
+	Head abi.ChainEpoch
+	Lag  time.Duration
+	// Target is the epoch of the execution tipset of the extraction, and
+	// Confirmations the number of epochs the head is past it.
+	Target        abi.ChainEpoch
+	Confirmations abi.ChainEpoch
+	// Synced is true if the head of the node is recent and past the target.
+	Synced bool
+	// Canonical is true if the execution tipset is on the chain of the head,
+	// i.e. not reorged out.
+	Canonical bool
+	// Final is true if the execution tipset has at least the requested
+	// confidence.
+	Final bool
+}
+
+// Problems returns descriptions of the failed checks.
+func (r *PreflightResult) Problems(confidence abi.ChainEpoch) []string {
+	var problems []string
+	if !r.Synced {
+		problems = append(problems, fmt.Sprintf("node is not synced: head at epoch %d is %s behind the wall clock (target epoch %d)", r.Head, r.Lag.Round(time.Second), r.Target))
+	}
+	if !r.Canonical {
+		problems = append(problems, fmt.Sprintf("execution tipset at epoch %d is not on the chain of the head; it may have been reorged out", r.Target))
+	}
+	if r.Canonical && !r.Final {
+		problems = append(problems, fmt.Sprintf("execution tipset at epoch %d is not final: %d confirmations, %d required", r.Target, r.Confirmations, confidence))
+	}
+	return problems
+}
+
+// GenerationData returns the generation metadata recording the result in a
+// vector.
+func (r *PreflightResult) GenerationData() schema.GenerationData {
+	return schema.GenerationData{
+		Source: fmt.Sprintf("preflight:head=%d,confirmations=%d,synced=%t,canonical=%t,final=%t",
+			r.Head, r.Confirmations, r.Synced, r.Canonical, r.Final),
+	}
+}
+
+// CheckPreflight checks that the node is synced past the target tipset, and
+// that the target tipset is on the chain of its head with at least the
+// supplied confidence.
+func CheckPreflight(ctx context.Context, api v0api.FullNode, target *types.TipSet, confidence abi.ChainEpoch) (*PreflightResult, error) {
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chain head: %w", err)
+	}
+
+	r := &PreflightResult{
+		Head:          head.Height(),
+		Lag:           time.Since(time.Unix(int64(head.MinTimestamp()), 0)),
+		Target:        target.Height(),
+		Confirmations: head.Height() - target.Height(),
+	}
+	if r.Lag < 0 {
+		r.Lag = 0
+	}
+	r.Synced = r.Lag <= maxHeadLag*time.Duration(build.BlockDelaySecs)*time.Second && r.Confirmations >= 0
+	if r.Confirmations >= 0 {
+		ts, err := api.ChainGetTipSetByHeight(ctx, target.Height(), head.Key())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tipset at epoch %d: %w", target.Height(), err)
+		}
+		r.Canonical = ts.Equals(target)
+	}
+	r.Final = r.Canonical && r.Confirmations >= confidence
+	return r, nil
+}
+
+// preflight runs the pre-flight checks on the target tipset, as requested in
+// the options, and returns the generation metadata recording their outcome.
+func (x *extraction) preflight(ctx context.Context, target *types.TipSet) ([]schema.GenerationData, error) {
+	switch x.opts.Preflight {
+	case PreflightOff:
+		return nil, nil
+	case PreflightWarn, PreflightRefuse:
+	default:
+		return nil, fmt.Errorf("unknown pre-flight check mode: %s", x.opts.Preflight)
+	}
+
+	r, err := CheckPreflight(ctx, x.api, target, x.opts.Confidence)
+	if err != nil {
+		return nil, err
+	}
+	problems := r.Problems(x.opts.Confidence)
+	switch {
+	case len(problems) == 0:
+		extractLog.Infow("pre-flight checks passed", "head", r.Head, "target", r.Target, "confirmations", r.Confirmations)
+	case x.opts.Preflight == PreflightRefuse:
+		return nil, fmt.Errorf("pre-flight checks failed: %s", strings.Join(problems, "; "))
+	default:
+		for _, p := range problems {
+			extractLog.Warnw("pre-flight check failed", "problem", p)
+		}
+	}
+	return []schema.GenerationData{r.GenerationData()}, nil
+}
@@ -0,0 +1,101 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+)
+
+// chainAPI serves ChainHead and ChainGetTipSetByHeight from a chain of
+// tipsets, one per epoch.
+type chainAPI struct {
+	v0api.FullNode
+
+	chain []*types.TipSet
+}
+
+func (a *chainAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	return a.chain[len(a.chain)-1], nil
+}
+
+func (a *chainAPI) ChainGetTipSetByHeight(_ context.Context, h abi.ChainEpoch, _ types.TipSetKey) (*types.TipSet, error) {
+	return a.chain[h], nil
+}
+
+// mkChain returns a chain of tipsets up to the head epoch, whose head
+// timestamp lags the supplied duration behind the wall clock.
+func mkChain(head abi.ChainEpoch, lag time.Duration) []*types.TipSet {
+	chain := []*types.TipSet{mock.TipSet(mock.MkBlock(nil, 1, 0))}
+	for len(chain) <= int(head) {
+		blk := mock.MkBlock(chain[len(chain)-1], 1, 0)
+		if len(chain) == int(head) {
+			blk.Timestamp = uint64(time.Now().Add(-lag).Unix())
+		}
+		chain = append(chain, mock.TipSet(blk))
+	}
+	return chain
+}
+
+func TestCheckPreflight(t *testing.T) {
+	ctx := context.Background()
+	api := &chainAPI{chain: mkChain(20, 0)}
+
+	r, err := CheckPreflight(ctx, api, api.chain[5], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Synced || !r.Canonical || !r.Final || r.Confirmations != 15 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if p := r.Problems(10); len(p) != 0 {
+		t.Errorf("unexpected problems: %v", p)
+	}
+
+	// not final with the supplied confidence.
+	r, err = CheckPreflight(ctx, api, api.chain[15], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Final {
+		t.Errorf("tipset with %d confirmations deemed final", r.Confirmations)
+	}
+
+	// reorged out.
+	orphan := mock.TipSet(mock.MkBlock(api.chain[4], 1, 1))
+	if r, err = CheckPreflight(ctx, api, orphan, 10); err != nil {
+		t.Fatal(err)
+	}
+	if r.Canonical || r.Final {
+		t.Errorf("orphaned tipset deemed canonical: %+v", r)
+	}
+
+	// head lagging behind the wall clock.
+	api = &chainAPI{chain: mkChain(20, time.Hour)}
+	if r, err = CheckPreflight(ctx, api, api.chain[5], 10); err != nil {
+		t.Fatal(err)
+	}
+	if r.Synced {
+		t.Errorf("node lagging %s deemed synced", r.Lag)
+	}
+
+	x := &extraction{api: api, opts: Options{Preflight: PreflightRefuse, Confidence: 10}}
+	if _, err := x.preflight(ctx, api.chain[5]); err == nil || !strings.Contains(err.Error(), "not synced") {
+		t.Errorf("expected the extraction to be refused, got %v", err)
+	}
+	x.opts.Preflight = PreflightWarn
+	gen, err := x.preflight(ctx, api.chain[5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gen) != 1 || !strings.Contains(gen[0].Source, "synced=false") {
+		t.Errorf("unexpected generation data: %v", gen)
+	}
+}
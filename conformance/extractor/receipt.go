@@ -0,0 +1,116 @@
+package extractor
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// The reasons StateGetReceipt returns no receipt for a message, as
+// classified by the fallback lookup of the receipt, and recorded in the
+// metadata of vectors as receipt_missing:<reason>.
+const (
+	// ReceiptMissingPruned is the reason for messages the node no longer
+	// finds on its chain within the lookback of the extraction, as the
+	// stores of the node were pruned (e.g. by the splitstore) past them.
+	ReceiptMissingPruned = "pruned"
+	// ReceiptMissingWrongTipset is the reason for messages executed in
+	// another tipset than that the extraction executes them in, e.g. as the
+	// block supplied isn't that including the message, or the chain reorged.
+	ReceiptMissingWrongTipset = "wrong-tipset"
+	// ReceiptMissingGateway is the reason for nodes that are gateways, which
+	// cap the lookback of searches and don't serve replays.
+	ReceiptMissingGateway = "gateway"
+	// ReceiptMissingUnknown is the reason for receipts the node serves
+	// otherwise, or fails to for other reasons.
+	ReceiptMissingUnknown = "unknown"
+)
+
+// The sources of the receipts recovered by the fallback lookup, recorded in
+// the metadata of vectors as receipt_source:<source>.
+const (
+	ReceiptSourceSearch = "search"
+	ReceiptSourceReplay = "replay"
+)
+
+// missingReceipt is the outcome of the fallback lookup of a receipt that
+// StateGetReceipt returned none for.
+type missingReceipt struct {
+	// reason is the reason the receipt was missing; see ReceiptMissing*.
+	reason string
+	// receipt is the receipt recovered, if any, from source.
+	receipt *types.MessageReceipt
+	source  string
+}
+
+// gen returns the generation metadata recording the outcome of the lookup.
+func (m missingReceipt) gen() []schema.GenerationData {
+	gen := []schema.GenerationData{{Source: "receipt_missing:" + m.reason}}
+	if m.receipt != nil {
+		gen = append(gen, schema.GenerationData{Source: "receipt_source:" + m.source})
+	}
+	return gen
+}
+
+// locateMissingReceipt looks the receipt of the message, included in incTs
+// and executed in execTs, up when StateGetReceipt returns none: by searching
+// the chain back to the execution tipset with StateSearchMsgLimited, then by
+// replaying the message with StateReplay. It classifies why the receipt was
+// missing from the outcomes of both.
+func locateMissingReceipt(ctx context.Context, a v0api.FullNode, mcid cid.Cid, incTs, execTs *types.TipSet) missingReceipt {
+	limit := api.LookbackNoLimit
+	if head, err := a.ChainHead(ctx); err == nil {
+		limit = head.Height() - execTs.Height() + 1
+	}
+
+	m := missingReceipt{reason: ReceiptMissingUnknown}
+	lookup, err := a.StateSearchMsgLimited(ctx, mcid, limit)
+	switch {
+	case err != nil:
+		extractLog.Warnw("failed to search for the message receipt", "error", err)
+		m.reason = classifyReceiptError(err)
+	case lookup == nil:
+		m.reason = ReceiptMissingPruned
+	case lookup.TipSet != execTs.Key():
+		extractLog.Warnw("message executed in another tipset", "tipset", lookup.TipSet, "height", lookup.Height, "expected", execTs.Key())
+		m.reason = ReceiptMissingWrongTipset
+		return m
+	default:
+		m.receipt, m.source = &lookup.Receipt, ReceiptSourceSearch
+		return m
+	}
+
+	res, err := a.StateReplay(ctx, incTs.Key(), mcid)
+	switch {
+	case err != nil:
+		extractLog.Warnw("failed to replay the message for its receipt", "error", err)
+		if r := classifyReceiptError(err); r == ReceiptMissingGateway {
+			m.reason = r
+		}
+	case res.MsgRct != nil:
+		m.receipt, m.source = res.MsgRct, ReceiptSourceReplay
+	}
+	return m
+}
+
+// classifyReceiptError classifies the error of a receipt lookup: gateways
+// refuse lookbacks past their cap, and don't expose the methods of full nodes
+// beyond their own, while pruned stores miss the blocks of the chain.
+func classifyReceiptError(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "lookbacks of more than"),
+		strings.Contains(msg, "method '") && strings.Contains(msg, "not found"):
+		return ReceiptMissingGateway
+	case strings.Contains(msg, "not found"), strings.Contains(msg, "could not find"):
+		return ReceiptMissingPruned
+	}
+	return ReceiptMissingUnknown
+}
@@ -0,0 +1,78 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// receiptAPI serves the lookups of a receipt missing from StateGetReceipt.
+type receiptAPI struct {
+	chainAPI
+
+	lookup    *api.MsgLookup
+	searchErr error
+	replay    *api.InvocResult
+	replayErr error
+}
+
+func (a *receiptAPI) StateSearchMsgLimited(context.Context, cid.Cid, abi.ChainEpoch) (*api.MsgLookup, error) {
+	return a.lookup, a.searchErr
+}
+
+func (a *receiptAPI) StateReplay(context.Context, types.TipSetKey, cid.Cid) (*api.InvocResult, error) {
+	return a.replay, a.replayErr
+}
+
+func TestLocateMissingReceipt(t *testing.T) {
+	ctx := context.Background()
+	chain := mkChain(20, 0)
+	incTs, execTs := chain[9], chain[10]
+	rct := types.MessageReceipt{ExitCode: 16, GasUsed: 100}
+	gatewayErr := errors.New("method 'Filecoin.StateReplay' not found")
+
+	for _, c := range []struct {
+		name   string
+		api    receiptAPI
+		reason string
+		source string
+	}{
+		{"found by search", receiptAPI{lookup: &api.MsgLookup{TipSet: execTs.Key(), Receipt: rct}},
+			ReceiptMissingUnknown, ReceiptSourceSearch},
+		{"executed elsewhere", receiptAPI{lookup: &api.MsgLookup{TipSet: chain[12].Key(), Receipt: rct}, replay: &api.InvocResult{MsgRct: &rct}},
+			ReceiptMissingWrongTipset, ""},
+		{"pruned, replayed", receiptAPI{replay: &api.InvocResult{MsgRct: &rct}},
+			ReceiptMissingPruned, ReceiptSourceReplay},
+		{"pruned", receiptAPI{searchErr: errors.New("blockstore: block not found"), replayErr: errors.New("blockstore: block not found")},
+			ReceiptMissingPruned, ""},
+		{"gateway", receiptAPI{searchErr: errors.New("lookbacks of more than 24h0m0s are disallowed"), replayErr: gatewayErr},
+			ReceiptMissingGateway, ""},
+		{"gateway, capped search", receiptAPI{replayErr: gatewayErr},
+			ReceiptMissingGateway, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			a := c.api
+			a.chain = chain
+			m := locateMissingReceipt(ctx, &a, cid.Undef, incTs, execTs)
+			if m.reason != c.reason {
+				t.Errorf("expected reason %s, got %s", c.reason, m.reason)
+			}
+			if m.source != c.source {
+				t.Errorf("expected source %q, got %q", c.source, m.source)
+			}
+			if recovered := m.receipt != nil; recovered != (c.source != "") {
+				t.Errorf("expected a recovered receipt: %t, got %v", c.source != "", m.receipt)
+			} else if recovered && m.receipt.ExitCode != rct.ExitCode {
+				t.Errorf("expected receipt %v, got %v", rct, *m.receipt)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// DiagnosticsReceiptMismatch is the format of the diagnostics recorded when
+// a vector is emitted despite a receipt mismatch. The data is a JSON object
+// holding the locally observed receipt, which the vector asserts, and the
+// on-chain receipt.
+const DiagnosticsReceiptMismatch = "receipt_mismatch+json"
+
+// receiptMismatchDiagnostics builds the diagnostics recording both the
+// on-chain and the locally observed receipts.
+func receiptMismatchDiagnostics(onchain *schema.Receipt, observed *vm.ApplyRet) (*schema.Diagnostics, error) {
+	data, err := json.Marshal(struct {
+		Observed *schema.Receipt `json:"observed"`
+		OnChain  *schema.Receipt `json:"on_chain"`
+	}{
+		Observed: &schema.Receipt{
+			ExitCode:    int64(observed.ExitCode),
+			ReturnValue: observed.Return,
+			GasUsed:     observed.GasUsed,
+		},
+		OnChain: onchain,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode receipt mismatch diagnostics: %w", err)
+	}
+	return &schema.Diagnostics{Format: DiagnosticsReceiptMismatch, Data: data}, nil
+}
+
+// printReceiptMismatch prints a side-by-side, colored report comparing the
+// expected (on-chain) receipt with the actual (locally executed) one, with
+// return values decoded against the ABI of the receiver code, if known. If
+// the on-chain execution trace is supplied, it also reports the point at
+// which the gas charges of both executions diverge.
+func printReceiptMismatch(expected *schema.Receipt, actual *vm.ApplyRet, code cid.Cid, method abi.MethodNum, chainTrace *types.ExecutionTrace) {
+	log.Println(color.HiWhiteString("receipt mismatch report (expected: on-chain, actual: local)"))
+	printRow("field", "expected", "actual", color.HiWhiteString)
+
+	row := func(field, e, a string) {
+		c := color.GreenString
+		if e != a {
+			c = color.RedString
+		}
+		printRow(field, e, a, c)
+	}
+
+	row("exit code", exitcode.ExitCode(expected.ExitCode).String(), actual.ExitCode.String())
+	row("gas used", strconv.FormatInt(expected.GasUsed, 10), strconv.FormatInt(actual.GasUsed, 10))
+	row("return (hex)", hex.EncodeToString(expected.ReturnValue), hex.EncodeToString(actual.Return))
+	if !bytes.Equal(expected.ReturnValue, actual.Return) {
+		row("return (cbor)", decodeCBOR(expected.ReturnValue), decodeCBOR(actual.Return))
+		row("return (abi)", conformance.FormatMethodValue(code, method, expected.ReturnValue, false),
+			conformance.FormatMethodValue(code, method, actual.Return, false))
+	}
+	if actual.ActorErr != nil {
+		log.Println(color.YellowString("local actor error: %s", actual.ActorErr))
+	}
+
+	if chainTrace == nil {
+		log.Println(color.YellowString("on-chain execution trace unavailable; cannot locate gas divergence"))
+		return
+	}
+	printGasDivergence(chainTrace, &actual.ExecutionTrace)
+}
+
+func printRow(field, expected, actual string, c func(format string, a ...interface{}) string) {
+	log.Println(c("%-14s | %-48s | %-48s", field, expected, actual))
+}
+
+// decodeCBOR renders CBOR-encoded bytes as JSON, if they can be decoded.
+func decodeCBOR(b []byte) string {
+	if len(b) == 0 {
+		return "(empty)"
+	}
+	nd, err := cbornode.Decode(b, multihash.SHA2_256, -1)
+	if err != nil {
+		return "(not cbor)"
+	}
+	j, err := nd.MarshalJSON()
+	if err != nil {
+		return "(not cbor)"
+	}
+	return string(j)
+}
+
+// gasCharge is a gas charge in a flattened execution trace, along with the
+// message of the call it was charged in.
+type gasCharge struct {
+	msg    *types.Message
+	depth  int
+	charge *types.GasTrace
+}
+
+// flattenGasCharges flattens the gas charges of an execution trace, in the
+// order they were incurred.
+func flattenGasCharges(trace *types.ExecutionTrace, depth int, out []gasCharge) []gasCharge {
+	for _, c := range trace.GasCharges {
+		out = append(out, gasCharge{msg: trace.Msg, depth: depth, charge: c})
+	}
+	for i := range trace.Subcalls {
+		out = flattenGasCharges(&trace.Subcalls[i], depth+1, out)
+	}
+	return out
+}
+
+// printGasDivergence reports the first gas charge at which the expected and
+// actual execution traces differ.
+func printGasDivergence(expected, actual *types.ExecutionTrace) {
+	var (
+		e = flattenGasCharges(expected, 0, nil)
+		a = flattenGasCharges(actual, 0, nil)
+	)
+
+	describe := func(gc []gasCharge, i int) string {
+		if i >= len(gc) {
+			return "(none)"
+		}
+		c := gc[i]
+		s := fmt.Sprintf("%s: %d", c.charge.Name, c.charge.TotalGas)
+		if len(c.charge.Location) > 0 {
+			l := c.charge.Location[0]
+			s += fmt.Sprintf(" @ %s:%d", l.File, l.Line)
+		}
+		return s
+	}
+
+	for i := 0; i < len(e) || i < len(a); i++ {
+		if i < len(e) && i < len(a) && e[i].charge.Name == a[i].charge.Name && e[i].charge.TotalGas == a[i].charge.TotalGas {
+			continue
+		}
+
+		log.Println(color.HiWhiteString("gas traces diverge at charge %d (of %d expected, %d actual)", i, len(e), len(a)))
+		for _, gc := range [][]gasCharge{e, a} {
+			if i < len(gc) && gc[i].msg != nil {
+				m := gc[i].msg
+				log.Printf("\tcall at depth %d: %s -> %s (method %d)", gc[i].depth, m.From, m.To, m.Method)
+				break
+			}
+		}
+		printRow("gas charge", describe(e, i), describe(a, i), color.RedString)
+		return
+	}
+	log.Println(color.GreenString("gas traces do not diverge"))
+}
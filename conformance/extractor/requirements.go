@@ -0,0 +1,43 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// recordRequirements records the requirements of the vector on the builds
+// executing it, as selectors: the version of Lotus generating it, and the
+// manifest of the built-in actors bundle of its network version, from nv16
+// onwards; see conformance.CheckRequirements. Manifests are cached in
+// manifests by network version.
+func (x *extraction) recordRequirements(ctx context.Context, v *schema.TestVector, manifests map[network.Version]cid.Cid) error {
+	if _, ok := v.Selector[conformance.SelectorMinLotusVersion]; !ok {
+		v.Selector[conformance.SelectorMinLotusVersion] = build.BuildVersion
+	}
+	if _, ok := v.Selector[conformance.SelectorActorsBundle]; ok || v.Pre == nil || len(v.Pre.Variants) == 0 {
+		return nil
+	}
+	// bundles, and their manifests, were introduced in nv16.
+	nv := network.Version(v.Pre.Variants[0].NetworkVersion)
+	if nv < network.Version16 {
+		return nil
+	}
+	m, ok := manifests[nv]
+	if !ok {
+		var err error
+		if m, err = x.api.StateActorManifestCID(ctx, nv); err != nil {
+			return fmt.Errorf("failed to resolve the actors manifest of network version %d: %w", nv, err)
+		}
+		manifests[nv] = m
+	}
+	v.Selector[conformance.SelectorActorsBundle] = m.String()
+	return nil
+}
@@ -0,0 +1,227 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/chaos"
+	"github.com/filecoin-project/lotus/conformance/puppet"
+)
+
+// ParseSelectors parses selectors in key=value form, as supplied through the
+// --selector flag of tvx extract.
+func ParseSelectors(kvs []string) (schema.Selector, error) {
+	sel := make(schema.Selector, len(kvs))
+	for _, kv := range kvs {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid selector %q; expected key=value", kv)
+		}
+		sel[k] = v
+	}
+	return sel, nil
+}
+
+// storeSyscallRecording stores the syscall recording in the blockstore, and
+// returns its CID. If there's no recording, or it's empty, it returns
+// cid.Undef.
+func storeSyscallRecording(ctx context.Context, recording *conformance.SyscallRecording, bs blockstore.Blockstore) (cid.Cid, error) {
+	if recording == nil || recording.Len() == 0 {
+		return cid.Undef, nil
+	}
+	c, err := recording.Store(ctx, bs)
+	if err != nil {
+		return cid.Undef, err
+	}
+	extractLog.Infow("recorded syscall outcomes", "count", recording.Len(), "recording", c)
+	return c, nil
+}
+
+// storeAncestorTipSets stores the ancestor tipsets in the blockstore, and
+// returns their CID, or cid.Undef if there are none.
+func storeAncestorTipSets(ctx context.Context, ancestors *conformance.AncestorTipSets, bs blockstore.Blockstore) (cid.Cid, error) {
+	if ancestors == nil || len(ancestors.TipSets) == 0 {
+		return cid.Undef, nil
+	}
+	c, err := ancestors.Store(ctx, bs)
+	if err != nil {
+		return cid.Undef, err
+	}
+	extractLog.Infow("recorded ancestor tipsets", "count", len(ancestors.TipSets), "tipsets", c)
+	return c, nil
+}
+
+// storeExpectedTraces stores the call trees of the supplied executions, in
+// the order of the receipts of the vector, in the blockstore, and returns
+// their CID.
+func storeExpectedTraces(ctx context.Context, rets []*vm.ApplyRet, bs blockstore.Blockstore) (cid.Cid, error) {
+	var traces conformance.ExpectedTraces
+	for _, ret := range rets {
+		traces.Traces = append(traces.Traces, conformance.NormalizeTrace(&ret.ExecutionTrace))
+	}
+	c, err := traces.Store(ctx, bs)
+	if err != nil {
+		return cid.Undef, err
+	}
+	extractLog.Infow("recorded expected call trees", "count", len(traces.Traces), "traces", c)
+	return c, nil
+}
+
+// PopulateSelector completes the selector of the vector with the features
+// exercised by the supplied executions, so that runners can skip vectors they
+// can't support. Manually supplied selectors are added last, and take
+// precedence over detected ones.
+func PopulateSelector(vector *schema.TestVector, manual schema.Selector, rets ...*vm.ApplyRet) {
+	if vector.Selector == nil {
+		vector.Selector = make(schema.Selector)
+	}
+
+	for _, ret := range rets {
+		if ret != nil && involvesChaos(ret.ExecutionTrace) {
+			vector.Selector[schema.SelectorChaosActor] = "true"
+			break
+		}
+	}
+
+	if len(rets) > 0 {
+		isPuppet := puppetActors(vector)
+		for _, ret := range rets {
+			if ret != nil && involvesPuppet(ret.ExecutionTrace, isPuppet) {
+				vector.Selector[conformance.SelectorPuppetActor] = "true"
+				break
+			}
+		}
+	}
+
+	// proofs are verified for real, unless the syscalls are recorded; see
+	// dropProofParams.
+	for _, ret := range rets {
+		if ret != nil && verifiesProofs(&ret.ExecutionTrace) {
+			vector.Selector[conformance.SelectorProofParams] = conformance.ProofParamsAll
+			break
+		}
+	}
+
+	// randomness is replayed from the vector, so runners need to support
+	// randomness injection.
+	if len(vector.Randomness) > 0 {
+		vector.Selector[conformance.SelectorRandomness] = "true"
+	}
+
+	for k, v := range manual {
+		vector.Selector[k] = v
+	}
+}
+
+// verifiesProofs returns whether the execution trace verifies any proof.
+func verifiesProofs(trace *types.ExecutionTrace) bool {
+	for _, gc := range trace.GasCharges {
+		if _, ok := proofCharges[gc.Name]; ok {
+			return true
+		}
+	}
+	for i := range trace.Subcalls {
+		if verifiesProofs(&trace.Subcalls[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropProofParams removes the proof parameters requirement PopulateSelector
+// detected from the selector of a vector recording its syscalls, which
+// replays the outcomes of the proof verifications instead, unless it was
+// supplied manually.
+func dropProofParams(vector *schema.TestVector, manual schema.Selector) {
+	if _, ok := manual[conformance.SelectorProofParams]; !ok {
+		delete(vector.Selector, conformance.SelectorProofParams)
+	}
+}
+
+// involvesChaos returns whether the chaos actor sent or received any message
+// in the execution trace.
+func involvesChaos(trace types.ExecutionTrace) bool {
+	if m := trace.Msg; m != nil && (m.To == chaos.Address || m.From == chaos.Address) {
+		return true
+	}
+	for _, sub := range trace.Subcalls {
+		if involvesChaos(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// involvesPuppet returns whether a puppet actor, as told by isPuppet, sent or
+// received any message in the execution trace.
+func involvesPuppet(trace types.ExecutionTrace, isPuppet func(address.Address) bool) bool {
+	if m := trace.Msg; m != nil && (isPuppet(m.To) || isPuppet(m.From)) {
+		return true
+	}
+	for _, sub := range trace.Subcalls {
+		if involvesPuppet(sub, isPuppet) {
+			return true
+		}
+	}
+	return false
+}
+
+// puppetActors returns a function telling whether an address is that of a
+// puppet actor in the pre-state of the vector. Unlike the chaos actor, the
+// puppet actor isn't a singleton, so addresses are resolved to the code of
+// their actor, in the state tree loaded from the CAR of the vector on first
+// use. Addresses that don't resolve aren't puppet actors.
+func puppetActors(vector *schema.TestVector) func(address.Address) bool {
+	var (
+		st     *state.StateTree
+		loaded bool
+		known  = make(map[address.Address]bool)
+	)
+	return func(a address.Address) bool {
+		if is, ok := known[a]; ok {
+			return is
+		}
+		if !loaded {
+			loaded = true
+			st = loadPreStateTree(vector)
+		}
+		var is bool
+		if st != nil {
+			act, err := st.GetActor(a)
+			is = err == nil && act.Code == puppet.PuppetActorCodeCID
+		}
+		known[a] = is
+		return is
+	}
+}
+
+// loadPreStateTree loads the pre-state tree of the vector from its CAR, or
+// returns nil if it has none, or it fails to load.
+func loadPreStateTree(vector *schema.TestVector) *state.StateTree {
+	if len(vector.CAR) == 0 || vector.Pre == nil || vector.Pre.StateTree == nil {
+		return nil
+	}
+	bs, err := conformance.LoadBlockstore(vector.CAR)
+	if err != nil {
+		extractLog.Warnw("failed to load the vector CAR; not detecting puppet actors", "error", err)
+		return nil
+	}
+	st, err := state.LoadStateTree(cbor.NewCborStore(bs), vector.Pre.StateTree.RootCID)
+	if err != nil {
+		extractLog.Warnw("failed to load the pre-state tree; not detecting puppet actors", "error", err)
+		return nil
+	}
+	return st
+}
@@ -0,0 +1,142 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+	"github.com/filecoin-project/lotus/conformance/chaos"
+	"github.com/filecoin-project/lotus/conformance/puppet"
+)
+
+func TestParseSelectors(t *testing.T) {
+	sel, err := ParseSelectors([]string{"chaos_actor=true", "foo=bar=baz", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel["chaos_actor"] != "true" || sel["foo"] != "bar=baz" || sel["empty"] != "" {
+		t.Fatalf("unexpected selector: %v", sel)
+	}
+
+	for _, bad := range []string{"nokey", "=value"} {
+		if _, err := ParseSelectors([]string{bad}); err == nil {
+			t.Fatalf("expected error for selector %q", bad)
+		}
+	}
+}
+
+func TestPopulateSelector(t *testing.T) {
+	ret := &vm.ApplyRet{
+		ExecutionTrace: types.ExecutionTrace{
+			Msg: &types.Message{},
+			Subcalls: []types.ExecutionTrace{
+				{Msg: &types.Message{To: chaos.Address}, GasCharges: []*types.GasTrace{{Name: "OnVerifyPost"}}},
+			},
+		},
+	}
+
+	vector := &schema.TestVector{
+		Selector:   schema.Selector{schema.SelectorMinProtocolVersion: "genesis"},
+		Randomness: schema.Randomness{{}},
+	}
+	PopulateSelector(vector, schema.Selector{schema.SelectorMinProtocolVersion: "breeze"}, ret)
+
+	if vector.Selector[schema.SelectorChaosActor] != "true" {
+		t.Fatal("expected chaos actor selector")
+	}
+	if vector.Selector[conformance.SelectorRandomness] != "true" {
+		t.Fatal("expected randomness selector")
+	}
+	if vector.Selector[schema.SelectorMinProtocolVersion] != "breeze" {
+		t.Fatal("expected manual selector to take precedence")
+	}
+	if vector.Selector[conformance.SelectorProofParams] != conformance.ProofParamsAll {
+		t.Fatal("expected proof params selector")
+	}
+	dropProofParams(vector, nil)
+	if _, ok := vector.Selector[conformance.SelectorProofParams]; ok {
+		t.Fatal("expected proof params selector to be dropped")
+	}
+}
+
+func TestPopulateSelectorPuppet(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	cst := cbor.NewCborStore(bs)
+	st, err := state.NewStateTree(cst, types.StateTreeVersion0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := cst.Put(ctx, new(puppet.State))
+	if err != nil {
+		t.Fatal(err)
+	}
+	puppetAddr, otherAddr := mock.Address(1000), mock.Address(1001)
+	if err := st.SetActor(puppetAddr, &types.Actor{Code: puppet.PuppetActorCodeCID, Head: head, Balance: types.NewInt(0)}); err != nil {
+		t.Fatal(err)
+	}
+	root, err := st.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := EncodeCAR(func(w io.Writer) error {
+		if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, w); err != nil {
+			return err
+		}
+		for c := range keys {
+			blk, err := bs.Get(ctx, c)
+			if err != nil {
+				return err
+			}
+			if err := util.LdWrite(w, c.Bytes(), blk.RawData()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		to     address.Address
+		puppet bool
+	}{
+		{puppetAddr, true},
+		{otherAddr, false},
+	} {
+		vector := &schema.TestVector{
+			CAR: b,
+			Pre: &schema.Preconditions{StateTree: &schema.StateTree{RootCID: root}},
+		}
+		ret := &vm.ApplyRet{
+			ExecutionTrace: types.ExecutionTrace{
+				Msg:      &types.Message{From: mock.Address(100), To: otherAddr},
+				Subcalls: []types.ExecutionTrace{{Msg: &types.Message{From: otherAddr, To: tc.to}}},
+			},
+		}
+		PopulateSelector(vector, nil, ret)
+		if got := vector.Selector[conformance.SelectorPuppetActor] == "true"; got != tc.puppet {
+			t.Errorf("message to %s: expected puppet actor selector: %t, got %t", tc.to, tc.puppet, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// selfCheck executes the variants of the vector from scratch, against a fresh
+// blockstore holding only the blocks of its CAR, to catch CARs missing blocks
+// the extraction fetched from the node or had cached. It fails with
+// ErrSelfCheckFailed if the vector doesn't execute, or, unless its sanity
+// checks were overridden (see postconditionsExecuted), if it disagrees with
+// its postconditions. The custom actors of the extraction are registered in
+// the VM.
+func selfCheck(ctx context.Context, v *schema.TestVector, opts Options) error {
+	if conformance.FallbackBlockstoreGetter != nil {
+		return fmt.Errorf("%w: a fallback blockstore is set, through which missing blocks would be fetched", ErrSelfCheckFailed)
+	}
+	id := ""
+	if v.Meta != nil {
+		id = v.Meta.ID
+	}
+	strict := !opts.IgnoreSanityChecks && postconditionsExecuted(v)
+	for i := range v.Pre.Variants {
+		variant := &v.Pre.Variants[i]
+		r := new(selfCheckReporter)
+		err := r.run(func() error {
+			_, err := conformance.ExecuteVariant(ctx, r, v, variant, conformance.ExecuteOpts{DriverOpts: conformance.DriverOpts{CustomActors: opts.CustomActors}})
+			return err
+		})
+		switch {
+		case errors.Is(err, conformance.ErrUnsupportedClass):
+			extractLog.Warnw("skipping self-check; vectors of the class can't be executed", "vector", id, "class", v.Class)
+			return nil
+		case errors.Is(err, conformance.ErrLightVector):
+			extractLog.Warnw("skipping self-check; light vectors carry no state to execute", "vector", id)
+			return nil
+		case err != nil:
+			return fmt.Errorf("%w: vector %s doesn't execute from its CAR alone (variant %s): %s", ErrSelfCheckFailed, id, variant.ID, err)
+		case strict && r.Failed():
+			return fmt.Errorf("%w: vector %s disagrees with its postconditions when executed from its CAR alone (variant %s): %s",
+				ErrSelfCheckFailed, id, variant.ID, strings.Join(r.failures, "; "))
+		}
+	}
+	extractLog.Infow("self-check succeeded", "vector", id)
+	return nil
+}
+
+// postconditionsExecuted returns whether the postconditions of the vector are
+// those of its local execution. They aren't when a sanity check failed and was
+// overridden with a hint, or with diagnostics.
+func postconditionsExecuted(v *schema.TestVector) bool {
+	if v.Diagnostics != nil {
+		return false
+	}
+	for _, h := range v.Hints {
+		if h == schema.HintIncorrect || h == conformance.HintIncorrectGas {
+			return false
+		}
+	}
+	return true
+}
+
+// errSelfCheckAborted is the panic value with which selfCheckReporter aborts
+// the execution of a vector on fatal failures.
+type errSelfCheckAborted struct{ msg string }
+
+// selfCheckReporter is a conformance.Reporter that records the failed
+// assertions of a self-check, and aborts it on fatal failures.
+type selfCheckReporter struct {
+	failures []string
+}
+
+var _ conformance.Reporter = (*selfCheckReporter)(nil)
+
+func (*selfCheckReporter) Helper() {}
+
+func (*selfCheckReporter) Log(args ...interface{}) {
+	extractLog.Debug(args...)
+}
+
+func (*selfCheckReporter) Logf(format string, args ...interface{}) {
+	extractLog.Debugf(format, args...)
+}
+
+func (r *selfCheckReporter) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Errorf(format, args...).Error())
+}
+
+func (r *selfCheckReporter) Fatalf(format string, args ...interface{}) {
+	msg := fmt.Errorf(format, args...).Error()
+	r.failures = append(r.failures, msg)
+	panic(errSelfCheckAborted{msg: msg})
+}
+
+func (r *selfCheckReporter) FailNow() {
+	panic(errSelfCheckAborted{msg: "execution aborted"})
+}
+
+func (r *selfCheckReporter) Failed() bool {
+	return len(r.failures) > 0
+}
+
+// run runs f, recovering from the fatal failures it reports, which it returns
+// as errors.
+func (r *selfCheckReporter) run(f func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			aborted, ok := p.(errSelfCheckAborted)
+			if !ok {
+				panic(p)
+			}
+			err = errors.New(aborted.msg)
+		}
+	}()
+	return f()
+}
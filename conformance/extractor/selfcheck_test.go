@@ -0,0 +1,60 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestSelfCheckMissingBlocks(t *testing.T) {
+	var (
+		present = blocks.NewBlock([]byte("present"))
+		missing = blocks.NewBlock([]byte("missing"))
+	)
+	b, err := EncodeCAR(func(w io.Writer) error {
+		if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{missing.Cid()}, Version: 1}, w); err != nil {
+			return err
+		}
+		return util.LdWrite(w, present.Cid().Bytes(), present.RawData())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &schema.TestVector{
+		Class: schema.ClassMessage,
+		Meta:  &schema.Metadata{ID: "v"},
+		CAR:   b,
+		Pre: &schema.Preconditions{
+			Variants:  []schema.Variant{{ID: "v1", Epoch: 1}},
+			StateTree: &schema.StateTree{RootCID: missing.Cid()},
+		},
+		Post: &schema.Postconditions{StateTree: &schema.StateTree{RootCID: missing.Cid()}},
+	}
+	if err := selfCheck(context.Background(), v, Options{}); !errors.Is(err, ErrSelfCheckFailed) {
+		t.Fatalf("expected the self-check of a vector missing its state root to fail; got %v", err)
+	}
+}
+
+func TestPostconditionsExecuted(t *testing.T) {
+	if !postconditionsExecuted(&schema.TestVector{}) {
+		t.Error("expected the postconditions of a vector to be those of its execution")
+	}
+	if postconditionsExecuted(&schema.TestVector{Hints: []string{conformance.HintIncorrectGas}}) {
+		t.Error("expected the postconditions of a vector hinted with incorrect gas not to be those of its execution")
+	}
+	if postconditionsExecuted(&schema.TestVector{Diagnostics: &schema.Diagnostics{Format: DiagnosticsReceiptMismatch}}) {
+		t.Error("expected the postconditions of a vector with diagnostics not to be those of its execution")
+	}
+}
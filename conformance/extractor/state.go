@@ -1,12 +1,15 @@
-package main
+package extractor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"sort"
 
 	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipld/go-car"
 	cbg "github.com/whyrusleeping/cbor-gen"
@@ -15,7 +18,13 @@ import (
 	"github.com/filecoin-project/go-state-types/abi"
 
 	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/account"
 	init_ "github.com/filecoin-project/lotus/chain/actors/builtin/init"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/market"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/state"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/chain/vm"
@@ -55,7 +64,7 @@ func (sg *StateSurgeon) GetMaskedStateTree(previousRoot cid.Cid, retain []addres
 		return cid.Undef, err
 	}
 
-	err = sg.retainInitEntries(initState, retain)
+	err = sg.retainInitEntries(st, initState, retain)
 	if err != nil {
 		return cid.Undef, err
 	}
@@ -84,51 +93,125 @@ func (sg *StateSurgeon) GetMaskedStateTree(previousRoot cid.Cid, retain []addres
 	return root, nil
 }
 
-// GetAccessedActors identifies the actors that were accessed during the
-// execution of a message.
-func (sg *StateSurgeon) GetAccessedActors(ctx context.Context, a v0api.FullNode, mid cid.Cid) ([]address.Address, error) {
-	log.Printf("calculating accessed actors during execution of message: %s", mid)
-	msgInfo, err := a.StateSearchMsg(ctx, mid)
-	if err != nil {
-		return nil, err
-	}
-	if msgInfo == nil {
-		return nil, fmt.Errorf("message info is nil")
+// GetMaskedActorState returns the CIDs of the state needed to look up the
+// entries of the supplied keys in the state of the actor: the path from the
+// state tree root to the actor, the head of its state, and the paths from the
+// roots of its HAMTs and AMTs to the entries of the keys, rather than the
+// whole collections. Keys are deal IDs for the market actor, whose proposals
+// and states are retained, and sector numbers for miner actors, whose sectors
+// and precommits are retained, along with their info.
+//
+// The CIDs are meant to be written with WriteCARIncluding, which leaves the
+// rest of the state dangling.
+func (sg *StateSurgeon) GetMaskedActorState(root cid.Cid, addr address.Address, keys []uint64) (AccessSet, error) {
+	tbs, ok := sg.stores.Blockstore.(TracingBlockstore)
+	if !ok {
+		return nil, fmt.Errorf("masking actor state requires a tracing blockstore")
 	}
+	return tbs.Trace(func(bs blockstore.Blockstore) error {
+		store := adt.WrapStore(sg.ctx, cbor.NewCborStore(bs))
+		st, err := state.LoadStateTree(store, root)
+		if err != nil {
+			return err
+		}
+		id, err := st.LookupID(addr)
+		if err != nil {
+			return fmt.Errorf("failed to resolve actor %s: %w", addr, err)
+		}
+		act, err := st.GetActor(id)
+		if err != nil {
+			return fmt.Errorf("failed to load actor %s: %w", addr, err)
+		}
 
-	msgObj, err := a.ChainGetMessage(ctx, mid)
-	if err != nil {
-		return nil, err
-	}
+		switch {
+		case id == market.Address:
+			ms, err := market.Load(store, act)
+			if err != nil {
+				return err
+			}
+			proposals, err := ms.Proposals()
+			if err != nil {
+				return err
+			}
+			states, err := ms.States()
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if _, _, err := proposals.Get(abi.DealID(k)); err != nil {
+					return fmt.Errorf("failed to look up proposal of deal %d: %w", k, err)
+				}
+				if _, _, err := states.Get(abi.DealID(k)); err != nil {
+					return fmt.Errorf("failed to look up state of deal %d: %w", k, err)
+				}
+			}
 
-	ts, err := a.ChainGetTipSet(ctx, msgInfo.TipSet)
-	if err != nil {
-		return nil, err
-	}
+		case builtin.IsStorageMinerActor(act.Code):
+			ms, err := miner.Load(store, act)
+			if err != nil {
+				return err
+			}
+			if _, err := ms.Info(); err != nil {
+				return fmt.Errorf("failed to load miner info: %w", err)
+			}
+			for _, k := range keys {
+				if _, err := ms.GetSector(abi.SectorNumber(k)); err != nil {
+					return fmt.Errorf("failed to look up sector %d: %w", k, err)
+				}
+				if _, err := ms.GetPrecommittedSector(abi.SectorNumber(k)); err != nil {
+					return fmt.Errorf("failed to look up precommit of sector %d: %w", k, err)
+				}
+			}
 
-	trace, err := a.StateCall(ctx, msgObj, ts.Parents())
+		default:
+			return fmt.Errorf("masking the state of actor %s (code %s) is unsupported", addr, act.Code)
+		}
+		return nil
+	})
+}
+
+// GetAccessedActors identifies the actors that were accessed during the
+// execution of a message, as recorded in the execution trace of its replay
+// on chain: the senders and receivers of the message and of all its
+// subcalls, including value transfers. Actors created during the execution
+// are included as well; the actors absent from a state tree are dropped when
+// masking it. The actors are sorted by address.
+func (sg *StateSurgeon) GetAccessedActors(ctx context.Context, a v0api.FullNode, mid cid.Cid) ([]address.Address, error) {
+	log.Printf("calculating accessed actors during execution of message: %s", mid)
+	res, err := a.StateReplay(ctx, types.EmptyTSK, mid)
 	if err != nil {
 		return nil, fmt.Errorf("could not replay msg: %w", err)
 	}
 
+	return tracedActors(&res.ExecutionTrace), nil
+}
+
+// tracedActors returns the senders and receivers of the messages of the
+// execution trace, and of all its subcalls, sorted by address.
+func tracedActors(trace *types.ExecutionTrace) []address.Address {
 	accessed := make(map[address.Address]struct{})
 
 	var recur func(trace *types.ExecutionTrace)
 	recur = func(trace *types.ExecutionTrace) {
-		accessed[trace.Msg.To] = struct{}{}
-		accessed[trace.Msg.From] = struct{}{}
+		if trace.Msg != nil {
+			accessed[trace.Msg.To] = struct{}{}
+			accessed[trace.Msg.From] = struct{}{}
+		}
 		for i := range trace.Subcalls {
 			recur(&trace.Subcalls[i])
 		}
 	}
-	recur(&trace.ExecutionTrace)
+	recur(trace)
 
 	ret := make([]address.Address, 0, len(accessed))
 	for k := range accessed {
 		ret = append(ret, k)
 	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].String() < ret[j].String()
+	})
 
-	return ret, nil
+	return ret
 }
 
 // WriteCAR recursively writes the tree referenced by the root as a CAR into the
@@ -148,7 +231,7 @@ func (sg *StateSurgeon) WriteCAR(w io.Writer, roots ...cid.Cid) error {
 
 // WriteCARIncluding writes a CAR including only the CIDs that are listed in
 // the include set. This leads to an intentially sparse tree with dangling links.
-func (sg *StateSurgeon) WriteCARIncluding(w io.Writer, include map[cid.Cid]struct{}, roots ...cid.Cid) error {
+func (sg *StateSurgeon) WriteCARIncluding(w io.Writer, include AccessSet, roots ...cid.Cid) error {
 	carWalkFn := func(nd format.Node) (out []*format.Link, err error) {
 		for _, link := range nd.Links() {
 			if _, ok := include[link.Cid]; !ok {
@@ -176,6 +259,11 @@ func (sg *StateSurgeon) transplantActors(src *state.StateTree, pluck []address.A
 
 	for _, a := range pluck {
 		actor, err := src.GetActor(a)
+		if errors.Is(err, types.ErrActorNotFound) {
+			// created by the message.
+			log.Printf("actor not found: %s; skipping", a)
+			continue
+		}
 		if err != nil {
 			return nil, fmt.Errorf("get actor %s failed: %w", a, err)
 		}
@@ -231,45 +319,98 @@ func (sg *StateSurgeon) saveInitActor(initActor *types.Actor, initState init_.St
 	return nil
 }
 
-// retainInitEntries takes an old init actor state, and retains only the
-// entries in the retain set, returning a new init actor state.
-func (sg *StateSurgeon) retainInitEntries(state init_.State, retain []address.Address) error {
+// retainInitEntries slices the address map of the init actor state down to
+// the entries in use: those of the robust addresses in the retain set, i.e.
+// referenced by the message and its subcalls, and those of the public key
+// addresses of the retained account actors. Rather than removing the other
+// entries, which walks the whole map, holding millions of entries on
+// mainnet, the entries in use are looked up, fetching their paths only, and
+// written to a fresh map.
+func (sg *StateSurgeon) retainInitEntries(st *state.StateTree, initState init_.State, retain []address.Address) error {
 	log.Printf("retaining init actor entries for addresses: %v", retain)
 
-	m := make(map[address.Address]struct{}, len(retain))
+	keep := make(map[address.Address]struct{}, len(retain))
 	for _, a := range retain {
-		m[a] = struct{}{}
+		if a.Protocol() != address.ID {
+			keep[a] = struct{}{}
+			continue
+		}
+		act, err := st.GetActor(a)
+		if errors.Is(err, types.ErrActorNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to load actor %s: %w", a, err)
+		}
+		if !builtin.IsAccountActor(act.Code) {
+			continue
+		}
+		as, err := account.Load(sg.stores.ADTStore, act)
+		if err != nil {
+			return fmt.Errorf("failed to load account actor %s: %w", a, err)
+		}
+		pk, err := as.PubkeyAddress()
+		if err != nil {
+			return fmt.Errorf("failed to get public key address of account actor %s: %w", a, err)
+		}
+		keep[pk] = struct{}{}
 	}
 
-	var remove []address.Address
-	_ = state.ForEachActor(func(id abi.ActorID, address address.Address) error {
-		if _, ok := m[address]; !ok {
-			remove = append(remove, address)
+	// an empty address map of the version of the state.
+	empty, err := init_.MakeState(sg.stores.ADTStore, initState.ActorVersion(), "")
+	if err != nil {
+		return err
+	}
+	amap, err := empty.AddressMap()
+	if err != nil {
+		return err
+	}
+	var kept int
+	for a := range keep {
+		resolved, found, err := initState.ResolveAddress(a)
+		if err != nil {
+			return fmt.Errorf("failed to resolve address %s: %w", a, err)
 		}
-		return nil
-	})
-
-	err := state.Remove(remove...)
-	log.Printf("new init actor state: %+v", state)
-	return err
+		if !found {
+			continue
+		}
+		id, err := address.IDFromAddress(resolved)
+		if err != nil {
+			return err
+		}
+		v := cbg.CborInt(id)
+		if err := amap.Put(abi.AddrKey(a), &v); err != nil {
+			return err
+		}
+		kept++
+	}
+	mroot, err := amap.Root()
+	if err != nil {
+		return err
+	}
+	if err := initState.SetAddressMap(mroot); err != nil {
+		return err
+	}
+	log.Printf("retained %d init actor address map entries", kept)
+	return nil
 }
 
 // resolveAddresses resolved the requested addresses from the provided
-// InitActor state, returning a slice of length len(orig), where each index
-// contains the resolved address.
+// InitActor state, returning the resolved addresses. Addresses that aren't
+// found, like those of actors created by the message, are dropped.
 func (sg *StateSurgeon) resolveAddresses(orig []address.Address, ist init_.State) (ret []address.Address, err error) {
 	log.Printf("resolving addresses: %v", orig)
 
-	ret = make([]address.Address, len(orig))
-	for i, addr := range orig {
+	ret = make([]address.Address, 0, len(orig))
+	for _, addr := range orig {
 		resolved, found, err := ist.ResolveAddress(addr)
 		if err != nil {
 			return nil, err
 		}
 		if !found {
-			return nil, fmt.Errorf("address not found: %s", addr)
+			log.Printf("address not found: %s; skipping", addr)
+			continue
 		}
-		ret[i] = resolved
+		ret = append(ret, resolved)
 	}
 
 	log.Printf("resolved addresses: %v", ret)
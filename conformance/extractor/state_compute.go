@@ -0,0 +1,97 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	blockadt "github.com/filecoin-project/specs-actors/actors/util/adt"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+// computedTipset is the execution of a tipset by the node, through
+// StateCompute: the receipts of the messages it applied, implicit ones
+// included, in order, as the driver returns them, the root of the receipts of
+// its explicit messages, its post state root, and the call trees of its
+// messages.
+type computedTipset struct {
+	receipts     []*schema.Receipt
+	receiptsRoot cid.Cid
+	root         cid.Cid
+	traces       []conformance.CallTree
+}
+
+// stateCompute executes the tipset on the node, through StateCompute at its
+// own epoch, so that the vector records the execution of the node rather than
+// the local one; see Options.StateCompute.
+func (x *extraction) stateCompute(ctx context.Context, ts *types.TipSet) (*computedTipset, error) {
+	out, err := x.api.StateCompute(ctx, ts.Height(), nil, ts.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the state of tipset %s on the node: %w", ts.Key(), err)
+	}
+	return newComputedTipset(ctx, out)
+}
+
+func newComputedTipset(ctx context.Context, out *api.ComputeStateOutput) (*computedTipset, error) {
+	c := &computedTipset{root: out.Root}
+	// the receipts root commits to the receipts of the explicit messages, as
+	// on chain; implicit messages are sent by the system actor.
+	receipts := blockadt.MakeEmptyArray(blockadt.WrapStore(ctx, cbor.NewCborStore(blockstore.NewMemory())))
+	var explicit uint64
+	for i, ir := range out.Trace {
+		if ir.MsgRct == nil {
+			return nil, fmt.Errorf("message %d (%s) of the computation has no receipt: %s", i, ir.MsgCid, ir.Error)
+		}
+		c.receipts = append(c.receipts, &schema.Receipt{
+			ExitCode:    int64(ir.MsgRct.ExitCode),
+			ReturnValue: ir.MsgRct.Return,
+			GasUsed:     ir.MsgRct.GasUsed,
+		})
+		c.traces = append(c.traces, conformance.NormalizeTrace(&ir.ExecutionTrace))
+		if ir.Msg != nil && ir.Msg.From == builtin.SystemActorAddr {
+			continue
+		}
+		if err := receipts.Set(explicit, ir.MsgRct); err != nil {
+			return nil, fmt.Errorf("failed to build receipts amt: %w", err)
+		}
+		explicit++
+	}
+	root, err := receipts.Root()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build receipts amt: %w", err)
+	}
+	c.receiptsRoot = root
+	return c, nil
+}
+
+// divergence returns how the local execution of the tipset diverges from that
+// of the node, or an empty string if it doesn't.
+func (c *computedTipset) divergence(local *conformance.ExecuteTipsetResult) string {
+	var diffs []string
+	if len(local.AppliedResults) != len(c.receipts) {
+		diffs = append(diffs, fmt.Sprintf("applied %d messages; the node %d", len(local.AppliedResults), len(c.receipts)))
+	}
+	for i := 0; i < len(local.AppliedResults) && i < len(c.receipts); i++ {
+		l, n := local.AppliedResults[i], c.receipts[i]
+		if int64(l.ExitCode) != n.ExitCode || l.GasUsed != n.GasUsed || !bytes.Equal(l.Return, n.ReturnValue) {
+			diffs = append(diffs, fmt.Sprintf("receipt %d: exit code %d, gas used %d; the node: exit code %d, gas used %d", i, l.ExitCode, l.GasUsed, n.ExitCode, n.GasUsed))
+		}
+	}
+	if local.ReceiptsRoot != c.receiptsRoot {
+		diffs = append(diffs, fmt.Sprintf("receipts root %s; the node %s", local.ReceiptsRoot, c.receiptsRoot))
+	}
+	if local.PostStateRoot != c.root {
+		diffs = append(diffs, fmt.Sprintf("post state root %s; the node %s", local.PostStateRoot, c.root))
+	}
+	return strings.Join(diffs, "; ")
+}
@@ -0,0 +1,75 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	blockadt "github.com/filecoin-project/specs-actors/actors/util/adt"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func TestComputedTipset(t *testing.T) {
+	ctx := context.Background()
+	from, _ := address.NewIDAddress(1000)
+	explicit := &types.MessageReceipt{ExitCode: exitcode.ErrInsufficientFunds, GasUsed: 100}
+	implicit := &types.MessageReceipt{GasUsed: 10}
+	root, err := abi.CidBuilder.Sum([]byte("root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := newComputedTipset(ctx, &api.ComputeStateOutput{
+		Root: root,
+		Trace: []*api.InvocResult{
+			{Msg: &types.Message{From: from, To: builtin.BurntFundsActorAddr}, MsgRct: explicit},
+			{Msg: &types.Message{From: builtin.SystemActorAddr, To: builtin.CronActorAddr}, MsgRct: implicit},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.receipts) != 2 || len(c.traces) != 2 || c.root != root {
+		t.Fatalf("expected the receipts and traces of both messages; got %+v", c)
+	}
+
+	// the receipts root only commits to the explicit message.
+	arr := blockadt.MakeEmptyArray(blockadt.WrapStore(ctx, cbor.NewCborStore(blockstore.NewMemory())))
+	if err := arr.Set(0, explicit); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := arr.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.receiptsRoot != expected {
+		t.Errorf("expected receipts root %s; got %s", expected, c.receiptsRoot)
+	}
+
+	local := &conformance.ExecuteTipsetResult{
+		ReceiptsRoot:  expected,
+		PostStateRoot: root,
+		AppliedResults: []*vm.ApplyRet{
+			{MessageReceipt: *explicit},
+			{MessageReceipt: *implicit},
+		},
+	}
+	if d := c.divergence(local); d != "" {
+		t.Errorf("expected no divergence; got %s", d)
+	}
+	local.AppliedResults[0] = &vm.ApplyRet{MessageReceipt: types.MessageReceipt{ExitCode: explicit.ExitCode, GasUsed: 120}}
+	if d := c.divergence(local); d == "" {
+		t.Error("expected the gas used to diverge")
+	}
+}
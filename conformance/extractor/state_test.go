@@ -0,0 +1,118 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/account"
+	init_ "github.com/filecoin-project/lotus/chain/actors/builtin/init"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestTracedActors(t *testing.T) {
+	id := func(n uint64) address.Address {
+		a, err := address.NewIDAddress(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	// 100 calls 200, which transfers value to 300 and calls back 100.
+	trace := &types.ExecutionTrace{
+		Msg: &types.Message{From: id(100), To: id(200)},
+		Subcalls: []types.ExecutionTrace{
+			{Msg: &types.Message{From: id(200), To: id(300)}},
+			{
+				Msg:      &types.Message{From: id(200), To: id(100)},
+				Subcalls: []types.ExecutionTrace{{Msg: &types.Message{From: id(100), To: id(400)}}},
+			},
+		},
+	}
+
+	got := tracedActors(trace)
+	want := []address.Address{id(100), id(200), id(300), id(400)}
+	if len(got) != len(want) {
+		t.Fatalf("got actors %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got actors %v; want %v", got, want)
+		}
+	}
+}
+
+func TestRetainInitEntries(t *testing.T) {
+	ctx := context.Background()
+	cst := cbor.NewCborStore(blockstore.NewMemory())
+	store := adt.WrapStore(ctx, cst)
+	sg := &StateSurgeon{ctx: ctx, stores: &Stores{CBORStore: cst, ADTStore: store}}
+
+	initState, err := init_.MakeState(store, actorstypes.Version0, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addrs, ids []address.Address
+	for i := 0; i < 50; i++ {
+		a, err := address.NewSecp256k1Address([]byte(fmt.Sprintf("pubkey-%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := initState.MapAddressToNewID(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs, ids = append(addrs, a), append(ids, id)
+	}
+
+	// the account actor of the first address, retained by ID.
+	st, err := state.NewStateTree(cst, types.StateTreeVersion0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	as, err := account.MakeState(store, actorstypes.Version0, addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := store.Put(ctx, as)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetActor(ids[0], &types.Actor{Code: builtin0.AccountActorCodeID, Head: head, Balance: types.NewInt(0)}); err != nil {
+		t.Fatal(err)
+	}
+	unknown, _ := address.NewSecp256k1Address([]byte("unknown"))
+
+	if err := sg.retainInitEntries(st, initState, []address.Address{ids[0], addrs[1], unknown}); err != nil {
+		t.Fatal(err)
+	}
+	retained := make(map[address.Address]abi.ActorID)
+	err = initState.ForEachActor(func(id abi.ActorID, a address.Address) error {
+		retained[a] = id
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retained) != 2 {
+		t.Fatalf("expected 2 entries to be retained; got %v", retained)
+	}
+	for _, i := range []int{0, 1} {
+		resolved, found, err := initState.ResolveAddress(addrs[i])
+		if err != nil || !found || resolved != ids[i] {
+			t.Errorf("expected %s to resolve to %s; got %s, %t, %v", addrs[i], ids[i], resolved, found, err)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	exchange "github.com/ipfs/go-ipfs-exchange-interface"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+)
+
+// Stores is a collection of the different stores and services that are needed
+// to deal with the data layer of Filecoin, conveniently interlinked with one
+// another.
+type Stores struct {
+	CBORStore    cbor.IpldStore
+	ADTStore     adt.Store
+	Datastore    ds.Batching
+	Blockstore   blockstore.Blockstore
+	BlockService blockservice.BlockService
+	Exchange     exchange.Interface
+	DAGService   format.DAGService
+}
+
+// NewProxyingStores is a set of Stores backed by a proxying Blockstore that
+// proxies Get requests for unknown CIDs to a Filecoin node, via the
+// ChainReadObj RPC. Along with every block fetched, up to prefetch of the
+// blocks it links to are fetched in a single ChainReadObjMany call, so that
+// walking a HAMT or AMT doesn't take a round trip per node; 0 disables
+// prefetching.
+func NewProxyingStores(ctx context.Context, api v0api.FullNode, prefetch int) *Stores {
+	return newProxyingStores(ctx, api, prefetch, nil)
+}
+
+// newProxyingStores is like NewProxyingStores, emitting progress events
+// through emit, if not nil.
+func newProxyingStores(ctx context.Context, api v0api.FullNode, prefetch int, emit func(event string, kvs ...interface{})) *Stores {
+	ds := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := &proxyingBlockstore{
+		ctx:        ctx,
+		api:        api,
+		emit:       emit,
+		prefetch:   prefetch,
+		Blockstore: blockstore.FromDatastore(ds),
+	}
+	return NewStores(ctx, ds, bs)
+}
+
+// NewStores creates a non-proxying set of Stores.
+func NewStores(ctx context.Context, ds ds.Batching, bs blockstore.Blockstore) *Stores {
+	var (
+		cborstore = cbor.NewCborStore(bs)
+		offl      = offline.Exchange(bs)
+		blkserv   = blockservice.New(bs, offl)
+		dserv     = merkledag.NewDAGService(blkserv)
+	)
+
+	return &Stores{
+		CBORStore:    cborstore,
+		ADTStore:     adt.WrapStore(ctx, cborstore),
+		Datastore:    ds,
+		Blockstore:   bs,
+		Exchange:     offl,
+		BlockService: blkserv,
+		DAGService:   dserv,
+	}
+}
+
+// TracingBlockstore is a Blockstore trait that records the CIDs accessed
+// within tracing scopes.
+type TracingBlockstore interface {
+	// Trace calls fn with a view of this Blockstore that records the CIDs
+	// accessed through Get, Put and PutMany, and returns them. Scopes are
+	// independent of one another, so that concurrent executions sharing this
+	// Blockstore are traced separately; accesses through the Blockstore
+	// itself, rather than the view, aren't traced.
+	Trace(fn func(bs blockstore.Blockstore) error) (AccessSet, error)
+}
+
+// AccessStats are the statistics of the accesses to a CID within a tracing
+// scope.
+type AccessStats struct {
+	// Count is the number of times the CID was accessed.
+	Count int
+	// Writes is the number of those accesses that wrote the block.
+	Writes int
+	// Size is the size of the block, in bytes, if it was accessed
+	// successfully.
+	Size int
+}
+
+// AccessSet is the set of CIDs accessed within a tracing scope, along with
+// their access statistics.
+type AccessSet map[cid.Cid]AccessStats
+
+// Written returns the CIDs of the set that were written.
+func (s AccessSet) Written() AccessSet {
+	written := make(AccessSet)
+	for c, stats := range s {
+		if stats.Writes > 0 {
+			written[c] = stats
+		}
+	}
+	return written
+}
+
+// blocksFetchedInterval is the number of blocks fetched via JSON-RPC between
+// blocks_fetched progress events.
+const blocksFetchedInterval = 100
+
+// proxyingBlockstore is a Blockstore wrapper that fetches unknown CIDs from
+// a Filecoin node via JSON-RPC.
+type proxyingBlockstore struct {
+	ctx  context.Context
+	api  v0api.FullNode
+	emit func(event string, kvs ...interface{})
+
+	// prefetch is the maximum number of linked blocks to prefetch along with
+	// every block fetched; 0 disables prefetching.
+	prefetch int
+
+	lk      sync.Mutex
+	fetched int
+	// noBatch is set when the node doesn't support ChainReadObjMany,
+	// disabling prefetching.
+	noBatch bool
+
+	blockstore.Blockstore
+}
+
+var _ TracingBlockstore = (*proxyingBlockstore)(nil)
+
+func (pb *proxyingBlockstore) Trace(fn func(bs blockstore.Blockstore) error) (AccessSet, error) {
+	scope := &tracingScope{
+		proxyingBlockstore: pb,
+		traced:             make(AccessSet),
+	}
+	if err := fn(scope); err != nil {
+		return nil, err
+	}
+	scope.lk.Lock()
+	defer scope.lk.Unlock()
+	return scope.traced, nil
+}
+
+func (pb *proxyingBlockstore) Get(ctx context.Context, cid cid.Cid) (blocks.Block, error) {
+	if block, err := pb.Blockstore.Get(ctx, cid); err == nil {
+		return block, err
+	}
+
+	extractLog.Debugw("fetching cid via rpc", "cid", cid)
+	item, err := pb.api.ChainReadObj(pb.ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	pb.countFetched(1)
+
+	block, err := blocks.NewBlockWithCid(item, cid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pb.Blockstore.Put(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+
+	pb.prefetchLinks(ctx, block)
+	return block, nil
+}
+
+// countFetched counts blocks fetched via JSON-RPC, emitting blocks_fetched
+// progress events.
+func (pb *proxyingBlockstore) countFetched(n int) {
+	pb.lk.Lock()
+	defer pb.lk.Unlock()
+	before := pb.fetched
+	pb.fetched += n
+	if pb.emit != nil && pb.fetched/blocksFetchedInterval > before/blocksFetchedInterval {
+		pb.emit(EventBlocksFetched, "count", pb.fetched)
+	}
+}
+
+// prefetchLinks fetches the blocks the supplied DAG-CBOR block links to, up
+// to the prefetch limit, in a single ChainReadObjMany call. Prefetching is
+// best effort: blocks that fail to be prefetched are fetched on demand.
+func (pb *proxyingBlockstore) prefetchLinks(ctx context.Context, block blocks.Block) {
+	pb.lk.Lock()
+	disabled := pb.prefetch <= 0 || pb.noBatch
+	pb.lk.Unlock()
+	if disabled || block.Cid().Prefix().Codec != cid.DagCBOR {
+		return
+	}
+
+	// the node reads at most ChainReadObjManyLimit blocks per call.
+	limit := pb.prefetch
+	if limit > api.ChainReadObjManyLimit {
+		limit = api.ChainReadObjManyLimit
+	}
+
+	var links []cid.Cid
+	err := cbg.ScanForLinks(bytes.NewReader(block.RawData()), func(c cid.Cid) {
+		if len(links) >= limit || c.Prefix().Codec != cid.DagCBOR {
+			return
+		}
+		if has, err := pb.Blockstore.Has(ctx, c); err == nil && !has {
+			links = append(links, c)
+		}
+	})
+	if err != nil || len(links) == 0 {
+		return
+	}
+
+	extractLog.Debugw("prefetching linked cids via rpc", "cid", block.Cid(), "count", len(links))
+	pb.fetchMany(ctx, links)
+}
+
+// fetchMany fetches the blocks of the CIDs in ChainReadObjMany calls of up
+// to ChainReadObjManyLimit blocks each, and returns those fetched. Like
+// prefetching, it's best effort: the blocks of a failed call, e.g. because
+// one of them is missing, are left to be fetched on demand. Batching is only
+// disabled once the node turns out not to support ChainReadObjMany.
+func (pb *proxyingBlockstore) fetchMany(ctx context.Context, cids []cid.Cid) []blocks.Block {
+	var fetched []blocks.Block
+	for len(cids) > 0 {
+		batch := cids
+		if len(batch) > api.ChainReadObjManyLimit {
+			batch = batch[:api.ChainReadObjManyLimit]
+		}
+		cids = cids[len(batch):]
+
+		items, err := pb.api.ChainReadObjMany(pb.ctx, batch)
+		if err != nil {
+			if isMethodNotFound(err) {
+				extractLog.Infow("node doesn't support batched block fetching; fetching blocks one by one", "error", err)
+				pb.lk.Lock()
+				pb.noBatch = true
+				pb.lk.Unlock()
+				break
+			}
+			extractLog.Debugw("batched block fetching failed; fetching its blocks on demand", "count", len(batch), "error", err)
+			continue
+		}
+		blks := make([]blocks.Block, 0, len(items))
+		for i, item := range items {
+			if i >= len(batch) {
+				break
+			}
+			b, err := blocks.NewBlockWithCid(item, batch[i])
+			if err != nil {
+				extractLog.Warnw("prefetched block doesn't match its cid", "cid", batch[i], "error", err)
+				continue
+			}
+			blks = append(blks, b)
+		}
+		if err := pb.Blockstore.PutMany(ctx, blks); err != nil {
+			extractLog.Warnw("failed to store prefetched blocks", "error", err)
+			continue
+		}
+		pb.countFetched(len(blks))
+		fetched = append(fetched, blks...)
+	}
+	return fetched
+}
+
+// isMethodNotFound returns whether the error of an RPC call means that the
+// node doesn't support the method called, as opposed to the call failing.
+func isMethodNotFound(err error) bool {
+	if errors.Is(err, api.ErrNotSupported) {
+		return true
+	}
+	// errors aren't typed once they cross JSON-RPC: -32601 is the code of
+	// unknown methods, and ErrNotSupported that of methods the API of the
+	// node leaves unimplemented.
+	msg := err.Error()
+	return strings.Contains(msg, "(-32601)") || strings.Contains(msg, api.ErrNotSupported.Error())
+}
+
+func (pb *proxyingBlockstore) View(ctx context.Context, c cid.Cid, callback func([]byte) error) error {
+	blk, err := pb.Get(ctx, c)
+	if err != nil {
+		return xerrors.Errorf("failed to Get cid %s: %w", c, err)
+	}
+
+	return callback(blk.RawData())
+}
+
+// tracingScope is a view of a proxyingBlockstore that records the CIDs
+// accessed through it.
+type tracingScope struct {
+	*proxyingBlockstore
+
+	lk     sync.Mutex
+	traced AccessSet
+}
+
+func (ts *tracingScope) trace(c cid.Cid, size int, write bool) {
+	ts.lk.Lock()
+	stats := ts.traced[c]
+	stats.Count++
+	if write {
+		stats.Writes++
+	}
+	if size > 0 {
+		stats.Size = size
+	}
+	ts.traced[c] = stats
+	ts.lk.Unlock()
+}
+
+func (ts *tracingScope) Get(ctx context.Context, cid cid.Cid) (blocks.Block, error) {
+	block, err := ts.proxyingBlockstore.Get(ctx, cid)
+	var size int
+	if err == nil {
+		size = len(block.RawData())
+	}
+	ts.trace(cid, size, false)
+	return block, err
+}
+
+func (ts *tracingScope) Put(ctx context.Context, block blocks.Block) error {
+	ts.trace(block.Cid(), len(block.RawData()), true)
+	return ts.proxyingBlockstore.Put(ctx, block)
+}
+
+func (ts *tracingScope) PutMany(ctx context.Context, blocks []blocks.Block) error {
+	for _, b := range blocks {
+		ts.trace(b.Cid(), len(b.RawData()), true)
+	}
+	return ts.proxyingBlockstore.PutMany(ctx, blocks)
+}
+
+func (ts *tracingScope) View(ctx context.Context, c cid.Cid, callback func([]byte) error) error {
+	blk, err := ts.Get(ctx, c)
+	if err != nil {
+		return xerrors.Errorf("failed to Get cid %s: %w", c, err)
+	}
+
+	return callback(blk.RawData())
+}
@@ -0,0 +1,241 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/blockstore"
+)
+
+func TestTracingScopes(t *testing.T) {
+	ctx := context.Background()
+	pb := &proxyingBlockstore{ctx: ctx, Blockstore: blockstore.NewMemory()}
+
+	a, b := blocks.NewBlock([]byte("a")), blocks.NewBlock([]byte("b"))
+	if err := pb.PutMany(ctx, []blocks.Block{a, b}); err != nil {
+		t.Fatal(err)
+	}
+
+	// scopes, even nested ones, trace independently of one another.
+	var inner AccessSet
+	outer, err := pb.Trace(func(bs blockstore.Blockstore) error {
+		for i := 0; i < 2; i++ {
+			if _, err := bs.Get(ctx, a.Cid()); err != nil {
+				return err
+			}
+		}
+		var err error
+		inner, err = pb.Trace(func(bs blockstore.Blockstore) error {
+			return bs.View(ctx, b.Cid(), func([]byte) error { return nil })
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := outer[a.Cid()]; !ok || len(outer) != 1 {
+		t.Errorf("outer scope traced %v; want only %s", outer, a.Cid())
+	}
+	if stats := outer[a.Cid()]; stats.Count != 2 || stats.Size != len(a.RawData()) {
+		t.Errorf("outer scope recorded %+v for %s; want 2 accesses of %d bytes", stats, a.Cid(), len(a.RawData()))
+	}
+	if _, ok := inner[b.Cid()]; !ok || len(inner) != 1 {
+		t.Errorf("inner scope traced %v; want only %s", inner, b.Cid())
+	}
+
+	// accesses outside of scopes aren't traced.
+	untraced, err := pb.Trace(func(blockstore.Blockstore) error {
+		_, err := pb.Get(ctx, a.Cid())
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(untraced) != 0 {
+		t.Errorf("traced %v outside of the scope", untraced)
+	}
+}
+
+func TestPrecursorWrites(t *testing.T) {
+	ctx := context.Background()
+	pb := &proxyingBlockstore{ctx: ctx, Blockstore: blockstore.NewMemory()}
+
+	a, b := blocks.NewBlock([]byte("a")), blocks.NewBlock([]byte("b"))
+	if err := pb.Put(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	// the precursors read a, and write b.
+	traced, err := pb.Trace(func(bs blockstore.Blockstore) error {
+		if _, err := bs.Get(ctx, a.Cid()); err != nil {
+			return err
+		}
+		return bs.Put(ctx, b)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := traced.Written()
+	if _, ok := writes[b.Cid()]; !ok || len(writes) != 1 {
+		t.Fatalf("write-set is %v; want only %s", writes, b.Cid())
+	}
+
+	// writes the message accessed already aren't counted as merged.
+	accessed := AccessSet{b.Cid(): {Count: 1}}
+	if merged := mergePrecursorWrites(accessed, writes); merged != 0 {
+		t.Errorf("merged %d writes; want 0", merged)
+	}
+	accessed = AccessSet{a.Cid(): {Count: 1}}
+	if merged := mergePrecursorWrites(accessed, writes); merged != 1 || len(accessed) != 2 {
+		t.Errorf("merged %d writes into %v; want 1", merged, accessed)
+	}
+}
+
+// readObjAPI serves ChainReadObj and ChainReadObjMany from a blockstore,
+// counting the calls, and recording the number of CIDs of batched ones.
+type readObjAPI struct {
+	v0api.FullNode
+
+	bs           blockstore.Blockstore
+	noMany       bool
+	single, many int
+	batches      []int
+}
+
+func (a *readObjAPI) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) {
+	a.single++
+	b, err := a.bs.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return b.RawData(), nil
+}
+
+func (a *readObjAPI) ChainReadObjMany(ctx context.Context, cs []cid.Cid) ([][]byte, error) {
+	a.many++
+	a.batches = append(a.batches, len(cs))
+	if a.noMany {
+		return nil, fmt.Errorf("RPC error (-32601): method 'Filecoin.ChainReadObjMany' not found")
+	}
+	if len(cs) > api.ChainReadObjManyLimit {
+		return nil, fmt.Errorf("too many objects requested: %d", len(cs))
+	}
+	out := make([][]byte, len(cs))
+	for i, c := range cs {
+		b, err := a.bs.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b.RawData()
+	}
+	return out, nil
+}
+
+func TestPrefetchLinks(t *testing.T) {
+	ctx := context.Background()
+	remote := blockstore.NewMemory()
+	var leaves []cid.Cid
+	for i := 0; i < 3; i++ {
+		n, err := cbor.WrapObject(fmt.Sprintf("leaf %d", i), mh.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Put(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+		leaves = append(leaves, n.Cid())
+	}
+	root, err := cbor.WrapObject(leaves, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Put(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, noMany := range []bool{false, true} {
+		api := &readObjAPI{bs: remote, noMany: noMany}
+		pb := &proxyingBlockstore{ctx: ctx, api: api, prefetch: 2, Blockstore: blockstore.NewMemory()}
+		for _, c := range append([]cid.Cid{root.Cid()}, leaves...) {
+			if _, err := pb.Get(ctx, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the first two leaves are prefetched along with the root, unless
+		// the node doesn't support batched reads.
+		want := 2
+		if noMany {
+			want = 4
+		}
+		if api.single != want || api.many != 1 {
+			t.Errorf("noMany=%t: %d single and %d batched reads; want %d and 1", noMany, api.single, api.many, want)
+		}
+	}
+}
+
+func TestFetchMany(t *testing.T) {
+	ctx := context.Background()
+	remote := blockstore.NewMemory()
+	var cids []cid.Cid
+	for i := 0; i < api.ChainReadObjManyLimit+10; i++ {
+		b := blocks.NewBlock([]byte(fmt.Sprintf("block %d", i)))
+		if err := remote.Put(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, b.Cid())
+	}
+	a := &readObjAPI{bs: remote}
+	pb := &proxyingBlockstore{ctx: ctx, api: a, Blockstore: blockstore.NewMemory()}
+
+	// the blocks are fetched in batches within the limit of the node.
+	if fetched := pb.fetchMany(ctx, cids); len(fetched) != len(cids) {
+		t.Fatalf("fetched %d blocks; want %d", len(fetched), len(cids))
+	}
+	if len(a.batches) != 2 || a.batches[0] != api.ChainReadObjManyLimit || a.batches[1] != 10 {
+		t.Fatalf("fetched batches of %v blocks; want %d and 10", a.batches, api.ChainReadObjManyLimit)
+	}
+
+	// a failed batch, e.g. with a missing block, doesn't disable batching.
+	missing := blocks.NewBlock([]byte("missing")).Cid()
+	if fetched := pb.fetchMany(ctx, []cid.Cid{missing}); len(fetched) != 0 {
+		t.Fatalf("fetched %d blocks; want none", len(fetched))
+	}
+	if pb.noBatch {
+		t.Fatal("batching disabled after a failed batch")
+	}
+
+	// a node that doesn't support batched reads does.
+	a.noMany = true
+	pb.fetchMany(ctx, []cid.Cid{missing})
+	if !pb.noBatch {
+		t.Fatal("batching not disabled for a node that doesn't support it")
+	}
+}
+
+func TestIsMethodNotFound(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{api.ErrNotSupported, true},
+		{fmt.Errorf("wrapped: %w", api.ErrNotSupported), true},
+		{errors.New("RPC error (-32601): method 'Filecoin.ChainReadObjMany' not found"), true},
+		{errors.New("RPC error (1): method not supported"), true},
+		{errors.New("RPC error (1): blockstore get: ipld: could not find node"), false},
+		{context.DeadlineExceeded, false},
+	} {
+		if got := isMethodNotFound(tc.err); got != tc.want {
+			t.Errorf("isMethodNotFound(%q) = %t; want %t", tc.err, got, tc.want)
+		}
+	}
+}
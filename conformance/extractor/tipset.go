@@ -0,0 +1,412 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/conformance"
+)
+
+func (x *extraction) tipsets(ctx context.Context) ([]*schema.TestVector, error) {
+	opts := x.opts
+
+	if opts.Retain != "accessed-cids" {
+		return nil, fmt.Errorf("tipset extraction only supports 'accessed-cids' state retention")
+	}
+
+	if opts.TSK == "" {
+		return nil, fmt.Errorf("tipset key cannot be empty")
+	}
+
+	var (
+		class         = schema.Class(opts.Class)
+		selector, err = ParseSelectors(opts.Selectors)
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := strings.Split(opts.TSK, "..")
+	switch len(ss) {
+	case 1: // extracting a single tipset.
+		ts, err := lcli.ParseTipSetRef(ctx, x.api, opts.TSK)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch tipset: %w", err)
+		}
+		v, err := x.extractTipsets(ctx, class, selector, ts)
+		if err != nil {
+			return nil, err
+		}
+		v.Hints = opts.Hints
+		return []*schema.TestVector{v}, nil
+
+	case 2: // extracting a range of tipsets.
+		left, err := lcli.ParseTipSetRef(ctx, x.api, ss[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch tipset %s: %w", ss[0], err)
+		}
+		right, err := lcli.ParseTipSetRef(ctx, x.api, ss[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch tipset %s: %w", ss[1], err)
+		}
+
+		// resolve the tipset range.
+		tss, err := x.resolveTipsetRange(ctx, left, right)
+		if err != nil {
+			return nil, err
+		}
+
+		// are are squashing all tipsets into a single multi-tipset vector?
+		if opts.Squash {
+			vector, err := x.extractTipsets(ctx, class, selector, tss...)
+			if err != nil {
+				return nil, err
+			}
+			vector.Hints = opts.Hints
+			return []*schema.TestVector{vector}, nil
+		}
+
+		// we are generating a single-tipset vector per tipset.
+		vectors, err := x.extractIndividualTipsets(ctx, class, selector, tss...)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vectors {
+			v.Hints = opts.Hints
+		}
+		return vectors, nil
+
+	default:
+		return nil, fmt.Errorf("unrecognized tipset format")
+	}
+}
+
+func (x *extraction) resolveTipsetRange(ctx context.Context, left *types.TipSet, right *types.TipSet) (tss []*types.TipSet, err error) {
+	// start from the right tipset and walk back the chain until the left tipset, inclusive.
+	for curr := right; curr.Key() != left.Parents(); {
+		tss = append(tss, curr)
+		curr, err = x.api.ChainGetTipSet(ctx, curr.Parents())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tipset %s (height: %d): %w", curr.Parents(), curr.Height()-1, err)
+		}
+	}
+	// reverse the slice.
+	for i, j := 0, len(tss)-1; i < j; i, j = i+1, j-1 {
+		tss[i], tss[j] = tss[j], tss[i]
+	}
+	return tss, nil
+}
+
+func (x *extraction) extractIndividualTipsets(ctx context.Context, class schema.Class, selector schema.Selector, tss ...*types.TipSet) (vectors []*schema.TestVector, err error) {
+	for _, ts := range tss {
+		v, err := x.extractTipsets(ctx, class, selector, ts)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors, nil
+}
+
+// extractTipsets extracts a vector of the supplied class, tipset or blockseq,
+// applying the supplied tipsets in sequence. The selector is passed to the
+// driver, and added to the vector. Blockseq vectors additionally
+// carry the block headers of every tipset, and those of the tipset that
+// follows the last one, as roots of the CAR, along with the messages each
+// block commits to.
+func (x *extraction) extractTipsets(ctx context.Context, class schema.Class, selector schema.Selector, tss ...*types.TipSet) (*schema.TestVector, error) {
+	var (
+		// create a read-through store that uses ChainGetObject to fetch unknown CIDs.
+		pst, g = x.newStores(ctx)
+
+		// recordingRand will record randomness so we can embed it in the test vector.
+		recordingRand = conformance.NewRecordingRand(new(conformance.LogReporter), x.api)
+	)
+
+	tbs, ok := pst.Blockstore.(TracingBlockstore)
+	if !ok {
+		return nil, fmt.Errorf("%w: requested 'accessed-cids' state retention, but no tracing blockstore was present", ErrRetentionUnsupported)
+	}
+
+	driver := conformance.NewDriver(ctx, selector, conformance.DriverOpts{
+		DisableVMFlush: true,
+		CustomActors:   x.opts.CustomActors,
+	})
+
+	base := tss[0]
+	last := tss[len(tss)-1]
+
+	preflight, err := x.preflight(ctx, last)
+	if err != nil {
+		return nil, err
+	}
+
+	// this is the root of the state tree we start with.
+	root := base.ParentState()
+	extractLog.Infow("base state tree", "root", root)
+
+	codename := GetProtocolCodename(base.Height())
+	nv, err := x.api.StateNetworkVersion(ctx, base.Key())
+	if err != nil {
+		return nil, err
+	}
+
+	// the variant epoch is that of the parent of the base tipset, and tipsets
+	// are applied at offsets relative to it. This way, the driver runs cron
+	// for any null rounds between tipsets (including those immediately
+	// preceding the base tipset) just like the chain did.
+	parent, err := x.api.ChainGetTipSet(ctx, base.Parents())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get parent of base tipset: %w", err)
+	}
+	parentEpoch := parent.Height()
+
+	version, err := x.api.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ntwkName, err := x.api.StateNetworkName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vector := schema.TestVector{
+		Class: class,
+		Meta: &schema.Metadata{
+			ID: fmt.Sprintf("@%d..@%d", base.Height(), last.Height()),
+			Gen: []schema.GenerationData{
+				{Source: fmt.Sprintf("network:%s", ntwkName)},
+				{Source: "github.com/filecoin-project/lotus", Version: version.String()}},
+			// will be completed by extra tipset stamps.
+		},
+		Selector: schema.Selector{
+			schema.SelectorMinProtocolVersion: codename,
+		},
+		Pre: &schema.Preconditions{
+			Variants: []schema.Variant{
+				{ID: codename, Epoch: int64(parentEpoch), NetworkVersion: uint(nv)},
+			},
+			StateTree: &schema.StateTree{
+				RootCID: base.ParentState(),
+			},
+		},
+		Post: &schema.Postconditions{
+			StateTree: new(schema.StateTree),
+		},
+	}
+
+	var (
+		rets      []*vm.ApplyRet
+		roots     = []cid.Cid{base.ParentState()}
+		carRoots  []cid.Cid
+		beaconCid cid.Cid
+		// computedTraces are the call trees of the messages executed by the
+		// node, with StateCompute.
+		computedTraces []conformance.CallTree
+		tracesCid      cid.Cid
+	)
+	accessed, err := tbs.Trace(func(bs blockstore.Blockstore) error {
+		prevEpoch := parentEpoch
+		for _, ts := range tss {
+			extractLog.Infow("extracting tipset", "tipset", ts.Key(), "blocks", len(ts.Blocks()))
+			if nulls := ts.Height() - prevEpoch - 1; nulls > 0 {
+				extractLog.Infow("tipset is preceded by null rounds", "tipset", ts.Key(), "null_rounds", nulls)
+			}
+
+			var blocks []schema.Block
+			for _, b := range ts.Blocks() {
+				msgs, err := x.api.ChainGetBlockMessages(ctx, b.Cid())
+				if err != nil {
+					return fmt.Errorf("failed to get block messages (cid: %s): %w", b.Cid(), err)
+				}
+
+				extractLog.Debugw("block messages", "block", b.Cid(), "count", len(msgs.Cids))
+
+				packed := make([]schema.Base64EncodedBytes, 0, len(msgs.Cids))
+				for _, m := range msgs.BlsMessages {
+					b, err := m.Serialize()
+					if err != nil {
+						return fmt.Errorf("failed to serialize message: %w", err)
+					}
+					packed = append(packed, b)
+				}
+				for _, m := range msgs.SecpkMessages {
+					b, err := m.Message.Serialize()
+					if err != nil {
+						return fmt.Errorf("failed to serialize message: %w", err)
+					}
+					packed = append(packed, b)
+				}
+				blocks = append(blocks, schema.Block{
+					MinerAddr: b.Miner,
+					WinCount:  b.ElectionProof.WinCount,
+					Messages:  packed,
+				})
+			}
+
+			basefee := base.Blocks()[0].ParentBaseFee
+			extractLog.Infow("tipset base fee", "basefee", basefee)
+
+			tipset := schema.Tipset{
+				BaseFee:     *basefee.Int,
+				Blocks:      blocks,
+				EpochOffset: int64(ts.Height() - parentEpoch),
+			}
+
+			params := conformance.ExecuteTipsetParams{
+				Preroot:     roots[len(roots)-1],
+				ParentEpoch: prevEpoch,
+				Tipset:      &tipset,
+				ExecEpoch:   ts.Height(),
+				Rand:        recordingRand,
+			}
+
+			result, err := driver.ExecuteTipset(bs, pst.Datastore, params)
+			if err != nil {
+				return fmt.Errorf("failed to execute tipset: %w", err)
+			}
+			x.emit(EventTipsetApplied, "tipset", ts.Key().String(), "epoch", ts.Height(), "messages", len(result.AppliedMessages), "postroot", result.PostStateRoot.String())
+
+			var receipts []*schema.Receipt
+			for _, res := range result.AppliedResults {
+				receipts = append(receipts, &schema.Receipt{
+					ExitCode:    int64(res.ExitCode),
+					ReturnValue: res.Return,
+					GasUsed:     res.GasUsed,
+				})
+			}
+			postRoot, receiptsRoot := result.PostStateRoot, result.ReceiptsRoot
+
+			// the postconditions are those of the execution of the node; the
+			// local one only gathers the state it accesses.
+			if x.opts.StateCompute {
+				computed, err := x.stateCompute(ctx, ts)
+				if err != nil {
+					return err
+				}
+				d := computed.divergence(result)
+				x.sanityChecked(d == "")
+				if d != "" {
+					extractLog.Warnw("local execution of tipset diverges from that of the node; recording the latter", "tipset", ts.Key(), "divergence", d)
+					vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{Source: "state_compute:local_divergence", Version: ts.Key().String()})
+				}
+				receipts, postRoot, receiptsRoot = computed.receipts, computed.root, computed.receiptsRoot
+				computedTraces = append(computedTraces, computed.traces...)
+			}
+
+			roots = append(roots, postRoot)
+			rets = append(rets, result.AppliedResults...)
+			prevEpoch = ts.Height()
+
+			// update the vector.
+			vector.ApplyTipsets = append(vector.ApplyTipsets, tipset)
+			vector.Post.ReceiptsRoots = append(vector.Post.ReceiptsRoots, receiptsRoot)
+			vector.Post.Receipts = append(vector.Post.Receipts, receipts...)
+
+			vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{
+				Source: "tipset:" + ts.Key().String(),
+			})
+		}
+
+		// include the block headers randomness was drawn from.
+		lookback := x.recordLookback(ctx, bs, recordingRand.Recorded(), last)
+		vector.Meta.Gen = append(vector.Meta.Gen, lookbackGen(lookback)...)
+
+		// capture the beacon entries of the epochs the tipsets are executed
+		// at, so that beacon randomness is derived from them on replay.
+		if beacon := x.captureBeaconEntries(ctx, parentEpoch+1, last.Height(), last); len(beacon.Entries) > 0 {
+			c, err := beacon.Store(ctx, bs)
+			if err != nil {
+				return err
+			}
+			beaconCid, carRoots = c, append(carRoots, c)
+		}
+
+		// the call trees of the node are asserted along with its receipts.
+		if len(computedTraces) > 0 {
+			traces := conformance.ExpectedTraces{Traces: computedTraces}
+			c, err := traces.Store(ctx, bs)
+			if err != nil {
+				return err
+			}
+			extractLog.Infow("recorded the call trees of the node", "count", len(computedTraces), "traces", c)
+			tracesCid, carRoots = c, append(carRoots, c)
+		}
+
+		carRoots = append(carRoots, roots...)
+		if class == schema.ClassBlockSeq {
+			// the tipset following the last one commits to its resulting state
+			// and receipts, and serves as the final checkpoint.
+			next, err := FindExecutionTipset(ctx, x.api, last.Height())
+			if err != nil {
+				return err
+			}
+
+			// load the messages committed to by each block through the tracing
+			// blockstore, so they're included in the CAR.
+			cs := store.NewChainStore(bs, bs, pst.Datastore, filcns.Weight, nil)
+			defer cs.Close() //nolint:errcheck
+
+			for _, ts := range tss {
+				for _, b := range ts.Blocks() {
+					if _, _, err := cs.MessagesForBlock(ctx, b); err != nil {
+						return fmt.Errorf("failed to load messages for block %s: %w", b.Cid(), err)
+					}
+				}
+				carRoots = append(carRoots, ts.Cids()...)
+			}
+			carRoots = append(carRoots, next.Cids()...)
+
+			vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{
+				Source: "next_tipset:" + next.Key().String(),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	x.stateRetained(ctx, pst.Blockstore, accessed)
+
+	//
+	// ComputeBaseFee(ctx, baseTs)
+
+	// write a CAR with the accessed state into a buffer.
+	carBytes, err := x.encodeCAR(func(w io.Writer) error {
+		return g.WriteCARIncluding(w, accessed, carRoots...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vector.Randomness = recordingRand.Recorded()
+	vector.Post.StateTree.RootCID = roots[len(roots)-1]
+	vector.CAR = carBytes
+	vector.Meta.Gen = append(vector.Meta.Gen, preflight...)
+
+	PopulateSelector(&vector, selector, rets...)
+	if beaconCid.Defined() {
+		vector.Selector[conformance.SelectorBeaconEntries] = beaconCid.String()
+	}
+	if tracesCid.Defined() {
+		vector.Selector[conformance.SelectorExpectedTraces] = tracesCid.String()
+	}
+	if x.opts.StateCompute {
+		vector.Meta.Gen = append(vector.Meta.Gen, schema.GenerationData{Source: "state_compute"})
+	}
+
+	return &vector, nil
+}
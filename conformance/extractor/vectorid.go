@@ -0,0 +1,94 @@
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// vectorID returns a descriptive vector ID, used when --id is omitted, of the
+// form <actor>-<method>-<exit code>-<suffix>, e.g.
+// fil_8_storageminer-SubmitWindowedPoSt-ok-5xkfnbq2. The actor is the name of
+// the receiver's code, with slashes replaced by underscores, as in the IDs
+// generated by extract-many.
+func vectorID(code cid.Cid, method abi.MethodNum, exit exitcode.ExitCode, suffix string) string {
+	actor := strings.ReplaceAll(ActorName(code), "/", "_")
+	methodname := MethodName(code, method)
+
+	// exitcode string representations are of kind ErrType(0); strip out the
+	// number portion.
+	exitcodename := "ok"
+	if exit != exitcode.Ok {
+		exitcodename = strings.Split(exit.String(), "(")[0]
+	}
+
+	return fmt.Sprintf("%s-%s-%s-%s", actor, methodname, exitcodename, suffix)
+}
+
+// ActorName returns the name of the actor code, e.g. fil/8/storageminer, or
+// "unknown" if undefined.
+func ActorName(code cid.Cid) string {
+	if !code.Defined() {
+		return "unknown"
+	}
+	return builtin.ActorNameByCode(code)
+}
+
+// MethodName returns the exported name of the method of the actor code, or
+// Method<N> if it's not known.
+func MethodName(code cid.Cid, method abi.MethodNum) string {
+	if m, ok := filcns.NewActorRegistry().Methods[code][method]; ok && m.Name != "" {
+		return m.Name
+	}
+	return fmt.Sprintf("Method%d", method)
+}
+
+// receiverGen returns the generation data entries recording the names of the
+// receiver actor and method of the message of a vector, so that reviewers
+// needn't look method numbers up by hand.
+func receiverGen(code cid.Cid, method abi.MethodNum) []schema.GenerationData {
+	return []schema.GenerationData{
+		{Source: "actor:" + ActorName(code)},
+		{Source: "method:" + MethodName(code, method)},
+	}
+}
+
+// shortCid returns the last eight characters of the CID, which are enough to
+// tell vectors apart within an actor, method and exit code.
+func shortCid(c cid.Cid) string {
+	s := c.String()
+	if len(s) > 8 {
+		s = s[len(s)-8:]
+	}
+	return s
+}
+
+// messageVectorID returns the ID of the vector of a message to an actor of the
+// supplied code, applied with the supplied exit code.
+func messageVectorID(code cid.Cid, msg *types.Message, exit exitcode.ExitCode) string {
+	return vectorID(code, msg.Method, exit, shortCid(msg.Cid()))
+}
+
+// receiverCode returns the code of the receiver of the message at the tipset,
+// or cid.Undef if it can't be resolved, and logs the names of the receiver
+// actor and method.
+func (x *extraction) receiverCode(ctx context.Context, msg *types.Message, tsk types.TipSetKey) cid.Cid {
+	code := cid.Undef
+	if act, err := x.api.StateGetActor(ctx, msg.To, tsk); err != nil {
+		extractLog.Debugw("failed to resolve receiver code", "receiver", msg.To, "error", err)
+	} else {
+		code = act.Code
+	}
+	extractLog.Infow("resolved receiver", "receiver", msg.To, "actor", ActorName(code), "method", MethodName(code, msg.Method))
+	return code
+}
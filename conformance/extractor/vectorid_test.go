@@ -0,0 +1,39 @@
+// stm: #unit
+package extractor
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/go-state-types/manifest"
+
+	"github.com/filecoin-project/lotus/chain/actors"
+)
+
+func TestVectorID(t *testing.T) {
+	code, ok := actors.GetActorCodeID(actorstypes.Version7, manifest.MinerKey)
+	if !ok {
+		t.Fatal("no code for v7 miner actor")
+	}
+	id := vectorID(code, builtin.MethodsMiner.SubmitWindowedPoSt, exitcode.Ok, "5xkfnbq2")
+	if expected := "fil_7_storageminer-SubmitWindowedPoSt-ok-5xkfnbq2"; id != expected {
+		t.Fatalf("expected %s, got %s", expected, id)
+	}
+
+	id = vectorID(cid.Undef, 2, exitcode.ErrForbidden, "5xkfnbq2")
+	if expected := "unknown-Method2-ErrForbidden-5xkfnbq2"; id != expected {
+		t.Fatalf("expected %s, got %s", expected, id)
+	}
+}
+
+func TestReceiverGen(t *testing.T) {
+	code, _ := actors.GetActorCodeID(actorstypes.Version7, manifest.MinerKey)
+	gen := receiverGen(code, builtin.MethodsMiner.SubmitWindowedPoSt)
+	if len(gen) != 2 || gen[0].Source != "actor:fil/7/storageminer" || gen[1].Source != "method:SubmitWindowedPoSt" {
+		t.Fatalf("unexpected generation data: %v", gen)
+	}
+}
@@ -0,0 +1,194 @@
+package extractor
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// EventDependenciesPlanned is emitted when the dependencies of a message are
+// predicted from its replay on the node, with the number of actors and
+// lookback epochs predicted, and of blocks fetched ahead of the execution;
+// see Options.Warmup.
+const EventDependenciesPlanned = "dependencies_planned"
+
+// maxWarmupBlocks is the maximum number of blocks of the state of the
+// predicted actors fetched ahead of the execution.
+const maxWarmupBlocks = 4096
+
+// proofCharges are the gas charges of the proof verifications, which draw
+// their randomness from the chain.
+var proofCharges = map[string]struct{}{
+	"OnVerifySeal":           {},
+	"OnVerifyAggregateSeals": {},
+	"OnVerifyReplicaUpdate":  {},
+	"OnVerifyPost":           {},
+	"OnVerifyConsensusFault": {},
+}
+
+// DependencyPlan is the state the local execution of a message is predicted
+// to read, from the trace of its execution by the node.
+type DependencyPlan struct {
+	// Actors are the actors the message and its internal calls involve, as
+	// senders or recipients, in the order they're first involved.
+	Actors []address.Address
+	// Proofs is the number of proofs the message verifies.
+	Proofs int
+	// Lookback are the epochs of the tipsets whose headers are predicted to
+	// be read: those searched for the beacon entries the randomness of the
+	// proofs is drawn from, if any.
+	Lookback []abi.ChainEpoch
+}
+
+// PlanDependencies predicts the dependencies of the message executed at the
+// epoch from its execution trace.
+func PlanDependencies(trace *types.ExecutionTrace, epoch abi.ChainEpoch) *DependencyPlan {
+	plan := new(DependencyPlan)
+	seen := make(map[address.Address]struct{})
+	involve := func(a address.Address) {
+		if _, ok := seen[a]; ok || a == address.Undef {
+			return
+		}
+		seen[a] = struct{}{}
+		plan.Actors = append(plan.Actors, a)
+	}
+
+	var walk func(t *types.ExecutionTrace)
+	walk = func(t *types.ExecutionTrace) {
+		if t.Msg != nil {
+			involve(t.Msg.From)
+			involve(t.Msg.To)
+		}
+		for _, gc := range t.GasCharges {
+			if _, ok := proofCharges[gc.Name]; ok {
+				plan.Proofs++
+			}
+		}
+		for i := range t.Subcalls {
+			walk(&t.Subcalls[i])
+		}
+	}
+	walk(trace)
+
+	if plan.Proofs > 0 {
+		for e := epoch; e > epoch-maxBeaconLookback && e >= 0; e-- {
+			plan.Lookback = append(plan.Lookback, e)
+		}
+	}
+	return plan
+}
+
+// warmup replays the message on the node, predicts the state its local
+// execution will read from the trace, and fetches it ahead of the execution
+// in bulk: the state of the actors involved, and the headers of the lookback
+// tipsets. The blocks are read outside of any tracing scope, so that the
+// retained state is unaffected. Warming up is best effort: failures are
+// logged, and the state is fetched on demand.
+func (x *extraction) warmup(ctx context.Context, pst *Stores, mcid cid.Cid, incTs *types.TipSet) {
+	res, err := x.api.StateReplay(ctx, incTs.Key(), mcid)
+	if err != nil {
+		extractLog.Warnw("failed to replay message on the node; skipping warmup", "cid", mcid, "error", err)
+		return
+	}
+	plan := PlanDependencies(&res.ExecutionTrace, incTs.Height())
+
+	// the state tree path of every actor is walked locally, fetching the
+	// nodes it traverses, and the roots of their state collected.
+	st, err := state.LoadStateTree(pst.CBORStore, incTs.ParentState())
+	if err != nil {
+		extractLog.Warnw("failed to load the base state tree; skipping warmup", "error", err)
+		return
+	}
+	var heads []cid.Cid
+	for _, a := range plan.Actors {
+		// actors created by the message don't exist yet.
+		if act, err := st.GetActor(a); err == nil {
+			heads = append(heads, act.Head)
+		}
+	}
+
+	var headers []cid.Cid
+	for _, e := range plan.Lookback {
+		ts, err := x.api.ChainGetTipSetByHeight(ctx, e, incTs.Key())
+		if err != nil {
+			extractLog.Warnw("failed to resolve lookback tipset; skipping", "epoch", e, "error", err)
+			continue
+		}
+		headers = append(headers, ts.Cids()...)
+	}
+
+	fetched := 0
+	if pb, ok := pst.Blockstore.(*proxyingBlockstore); ok {
+		fetched = pb.warm(ctx, headers, heads)
+	}
+	extractLog.Infow("warmed up state predicted from the replay",
+		"actors", len(plan.Actors), "proofs", plan.Proofs, "lookback", len(plan.Lookback), "blocks", fetched)
+	x.emit(EventDependenciesPlanned,
+		"actors", len(plan.Actors), "lookback", len(plan.Lookback), "blocks", fetched)
+}
+
+// warm fetches the blocks of the CIDs in bulk, then the DAGs of those of
+// the roots, level by level, up to maxWarmupBlocks blocks, and returns the
+// number of blocks fetched.
+func (pb *proxyingBlockstore) warm(ctx context.Context, cids, roots []cid.Cid) int {
+	// without batching, blocks are fetched on demand anyway.
+	pb.lk.Lock()
+	noBatch := pb.noBatch
+	pb.lk.Unlock()
+	if noBatch {
+		return 0
+	}
+
+	fetched := 0
+	// missing returns the CIDs not in the store yet, up to the budget left.
+	missing := func(cs []cid.Cid) []cid.Cid {
+		var out []cid.Cid
+		seen := make(map[cid.Cid]struct{})
+		for _, c := range cs {
+			if fetched+len(out) >= maxWarmupBlocks {
+				break
+			}
+			if _, ok := seen[c]; ok {
+				continue
+			}
+			seen[c] = struct{}{}
+			if has, err := pb.Blockstore.Has(ctx, c); err == nil && !has {
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+
+	if cs := missing(cids); len(cs) > 0 {
+		fetched += len(pb.fetchMany(ctx, cs))
+	}
+	for level := roots; len(level) > 0; {
+		cs := missing(level)
+		if len(cs) == 0 {
+			break
+		}
+		blks := pb.fetchMany(ctx, cs)
+		fetched += len(blks)
+
+		level = nil
+		for _, b := range blks {
+			if b.Cid().Prefix().Codec != cid.DagCBOR {
+				continue
+			}
+			_ = cbg.ScanForLinks(bytes.NewReader(b.RawData()), func(c cid.Cid) {
+				if c.Prefix().Codec == cid.DagCBOR {
+					level = append(level, c)
+				}
+			})
+		}
+	}
+	return fetched
+}
@@ -0,0 +1,81 @@
+// stm: #unit
+package extractor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestPlanDependencies(t *testing.T) {
+	a, _ := address.NewIDAddress(100)
+	b, _ := address.NewIDAddress(101)
+	c, _ := address.NewIDAddress(102)
+
+	trace := &types.ExecutionTrace{
+		Msg: &types.Message{From: a, To: b},
+		Subcalls: []types.ExecutionTrace{
+			{Msg: &types.Message{From: b, To: c}},
+			{Msg: &types.Message{From: b, To: a}},
+		},
+	}
+	plan := PlanDependencies(trace, 100)
+	if len(plan.Actors) != 3 || plan.Actors[0] != a || plan.Actors[1] != b || plan.Actors[2] != c {
+		t.Errorf("expected actors %s, %s, %s; got %v", a, b, c, plan.Actors)
+	}
+	if plan.Proofs != 0 || len(plan.Lookback) != 0 {
+		t.Errorf("expected no lookback without proofs; got %+v", plan)
+	}
+
+	trace.Subcalls[0].GasCharges = []*types.GasTrace{{Name: "OnIpldGet"}, {Name: "OnVerifyPost"}}
+	plan = PlanDependencies(trace, 5)
+	if plan.Proofs != 1 {
+		t.Errorf("expected 1 proof; got %d", plan.Proofs)
+	}
+	// the beacon lookback, bounded by genesis.
+	if len(plan.Lookback) != 6 || plan.Lookback[0] != 5 || plan.Lookback[5] != 0 {
+		t.Errorf("expected lookback epochs 5 to 0; got %v", plan.Lookback)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	ctx := context.Background()
+	remote := blockstore.NewMemory()
+	var leaves []cid.Cid
+	for i := 0; i < 2; i++ {
+		n, err := cbor.WrapObject(fmt.Sprintf("leaf %d", i), mh.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Put(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+		leaves = append(leaves, n.Cid())
+	}
+	root, err := cbor.WrapObject(leaves, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Put(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	api := &readObjAPI{bs: remote}
+	pb := &proxyingBlockstore{ctx: ctx, api: api, Blockstore: blockstore.NewMemory()}
+	// the root, then its leaves, a batched read per level.
+	if n := pb.warm(ctx, nil, []cid.Cid{root.Cid()}); n != 3 || api.single != 0 || api.many != 2 {
+		t.Errorf("fetched %d blocks in %d single and %d batched reads; want 3 in 0 and 2", n, api.single, api.many)
+	}
+	if n := pb.warm(ctx, nil, []cid.Cid{root.Cid()}); n != 0 {
+		t.Errorf("expected no blocks fetched again; got %d", n)
+	}
+}
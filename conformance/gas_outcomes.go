@@ -0,0 +1,130 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// SelectorExpectedGasOutcomes, if it appears in a message vector, holds the
+// expected gas outcomes of its messages, asserted after execution besides
+// their receipts: a JSON array of GasOutcome, one per message, in order, or
+// the CID of such an array stored as a raw block in the vector's CAR. Null
+// entries aren't asserted. Vectors exercising the fee market (see tvx build
+// with fee scenarios) carry them, so that the split of the fees between the
+// miner tip, the burns and the refund is asserted, not only the gas used.
+const SelectorExpectedGasOutcomes = "expected_gas_outcomes"
+
+// GasOutcome is the split of the gas fees of a message, as computed by the VM:
+// the amounts burnt, the tip of the miner, the penalty of the miner, and the
+// refund to the sender, in attoFIL, and the gas refunded and burnt.
+type GasOutcome struct {
+	BaseFeeBurn        abi.TokenAmount `json:"base_fee_burn"`
+	OverEstimationBurn abi.TokenAmount `json:"over_estimation_burn"`
+	MinerPenalty       abi.TokenAmount `json:"miner_penalty"`
+	MinerTip           abi.TokenAmount `json:"miner_tip"`
+	Refund             abi.TokenAmount `json:"refund"`
+	GasRefund          int64           `json:"gas_refund"`
+	GasBurned          int64           `json:"gas_burned"`
+}
+
+// NewGasOutcome returns the gas outcome of the gas outputs of a message.
+func NewGasOutcome(out *vm.GasOutputs) GasOutcome {
+	return GasOutcome{
+		BaseFeeBurn:        out.BaseFeeBurn,
+		OverEstimationBurn: out.OverEstimationBurn,
+		MinerPenalty:       out.MinerPenalty,
+		MinerTip:           out.MinerTip,
+		Refund:             out.Refund,
+		GasRefund:          out.GasRefund,
+		GasBurned:          out.GasBurned,
+	}
+}
+
+// loadGasOutcomes loads the expected gas outcomes of the vector, if it carries
+// SelectorExpectedGasOutcomes; it returns nil otherwise.
+func loadGasOutcomes(ctx context.Context, bs blockstore.Blockstore, vector *schema.TestVector) ([]*GasOutcome, error) {
+	s, ok := vector.Selector[SelectorExpectedGasOutcomes]
+	if !ok {
+		return nil, nil
+	}
+	data := []byte(s)
+	if c, err := cid.Decode(s); err == nil {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load expected gas outcomes %s: %w", c, err)
+		}
+		data = blk.RawData()
+	}
+	var outcomes []*GasOutcome
+	if err := json.Unmarshal(data, &outcomes); err != nil {
+		return nil, fmt.Errorf("invalid %s selector: %w", SelectorExpectedGasOutcomes, err)
+	}
+	if len(outcomes) != len(vector.ApplyMessages) {
+		return nil, fmt.Errorf("invalid %s selector: %d outcomes for %d messages", SelectorExpectedGasOutcomes, len(outcomes), len(vector.ApplyMessages))
+	}
+	return outcomes, nil
+}
+
+// AssertGasOutcomes asserts the gas outcomes of the messages applied against
+// those expected, by index, reporting the mismatches, which it also returns.
+// Messages without gas outputs, as implicit messages, fail the assertion if
+// an outcome is expected of them.
+func AssertGasOutcomes(r Reporter, expected []*GasOutcome, rets []*vm.ApplyRet) error {
+	r.Helper()
+
+	var merr error
+	fail := func(format string, args ...interface{}) {
+		ierr := fmt.Errorf(format, args...)
+		r.Errorf(ierr.Error())
+		merr = multierror.Append(merr, ierr)
+	}
+	for i, e := range expected {
+		if e == nil || i >= len(rets) {
+			continue
+		}
+		if rets[i].GasCosts == nil {
+			fail("message %d: no gas outputs to assert", i)
+			continue
+		}
+		a := NewGasOutcome(rets[i].GasCosts)
+		for _, f := range []struct {
+			name             string
+			expected, actual big.Int
+		}{
+			{"base fee burn", e.BaseFeeBurn, a.BaseFeeBurn},
+			{"overestimation burn", e.OverEstimationBurn, a.OverEstimationBurn},
+			{"miner penalty", e.MinerPenalty, a.MinerPenalty},
+			{"miner tip", e.MinerTip, a.MinerTip},
+			{"refund", e.Refund, a.Refund},
+			{"gas refund", big.NewInt(e.GasRefund), big.NewInt(a.GasRefund)},
+			{"gas burned", big.NewInt(e.GasBurned), big.NewInt(a.GasBurned)},
+		} {
+			if !tokenEquals(f.expected, f.actual) {
+				fail("message %d: wrong %s; expected %s, got %s", i, f.name, f.expected, f.actual)
+			}
+		}
+	}
+	return merr
+}
+
+// tokenEquals compares amounts, nil ones as zero.
+func tokenEquals(a, b big.Int) bool {
+	if a.Int == nil {
+		a = big.Zero()
+	}
+	if b.Int == nil {
+		b = big.Zero()
+	}
+	return a.Equals(b)
+}
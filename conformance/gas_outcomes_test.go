@@ -0,0 +1,43 @@
+// stm: #unit
+package conformance
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+func TestAssertGasOutcomes(t *testing.T) {
+	out := &vm.GasOutputs{
+		BaseFeeBurn:        big.NewInt(100),
+		OverEstimationBurn: big.NewInt(20),
+		MinerPenalty:       big.Zero(),
+		MinerTip:           big.NewInt(5),
+		Refund:             big.NewInt(75),
+		GasRefund:          10,
+		GasBurned:          2,
+	}
+	rets := []*vm.ApplyRet{{GasCosts: out}, {}}
+
+	expected := NewGasOutcome(out)
+	r := new(recordingReporter)
+	if err := AssertGasOutcomes(r, []*GasOutcome{&expected, nil}, rets); err != nil || r.errors != 0 {
+		t.Fatalf("expected the outcomes to match, got %v", err)
+	}
+
+	wrong := expected
+	wrong.MinerTip = big.NewInt(6)
+	wrong.GasBurned = 3
+	r = new(recordingReporter)
+	if err := AssertGasOutcomes(r, []*GasOutcome{&wrong, nil}, rets); err == nil || r.errors != 2 {
+		t.Errorf("expected 2 mismatches, got %d", r.errors)
+	}
+
+	// messages without gas outputs can't be asserted.
+	r = new(recordingReporter)
+	if err := AssertGasOutcomes(r, []*GasOutcome{nil, &expected}, rets); err == nil || r.errors != 1 {
+		t.Errorf("expected a failure for the message without gas outputs, got %d", r.errors)
+	}
+}
@@ -0,0 +1,137 @@
+package conformance
+
+import (
+	"context"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// DriverHooks are callbacks invoked by the driver while executing vectors,
+// allowing embedders to collect metrics or enforce invariants without
+// patching the driver. All hooks are optional.
+//
+// Subcall and gas charge hooks are derived from the execution trace of each
+// message, once it has been applied. Gas charges are only traced by the legacy
+// VM when detailed tracing is enabled (LOTUS_VM_ENABLE_TRACING=1).
+type DriverHooks struct {
+	// OnMessageStart is called before a message is applied. implicit is true
+	// for system messages (cron ticks, reward awards).
+	OnMessageStart func(msg *types.Message, implicit bool)
+
+	// OnSubcall is called for every call in the execution trace of an applied
+	// message, starting with the top-level call at depth 0.
+	OnSubcall func(depth int, trace *types.ExecutionTrace)
+
+	// OnGasCharge is called for every gas charge in the execution trace of an
+	// applied message, in the order they were incurred. msg is the message of
+	// the call the gas was charged in.
+	OnGasCharge func(depth int, msg *types.Message, charge *types.GasTrace)
+
+	// OnStateWrite is called for every block written to the blockstore backing
+	// the VM. Note that the legacy VM buffers writes until it's flushed, unless
+	// the driver is created with DisableVMFlush.
+	OnStateWrite func(c cid.Cid, data []byte)
+
+	// OnStateRoot is called with the state root resulting from every message,
+	// tipset or migration executed by the driver.
+	OnStateRoot func(root cid.Cid)
+}
+
+func (h *DriverHooks) empty() bool {
+	return h == nil || (h.OnMessageStart == nil && h.OnSubcall == nil && h.OnGasCharge == nil && h.OnStateWrite == nil)
+}
+
+// stateRoot invokes the OnStateRoot hook, if set.
+func (h *DriverHooks) stateRoot(root cid.Cid) {
+	if h != nil && h.OnStateRoot != nil && root.Defined() {
+		h.OnStateRoot(root)
+	}
+}
+
+// wrapBlockstore returns the supplied blockstore, wrapped to invoke the
+// OnStateWrite hook, if set.
+func (h *DriverHooks) wrapBlockstore(bs blockstore.Blockstore) blockstore.Blockstore {
+	if h == nil || h.OnStateWrite == nil {
+		return bs
+	}
+	return &hookedBlockstore{Blockstore: bs, onWrite: h.OnStateWrite}
+}
+
+// wrapVM returns the supplied VM, wrapped to invoke the message hooks.
+func (h *DriverHooks) wrapVM(vmi vm.Interface) vm.Interface {
+	if h.empty() {
+		return vmi
+	}
+	return &hookedVM{Interface: vmi, hooks: h}
+}
+
+// dispatchTrace invokes the subcall and gas charge hooks for the supplied
+// execution trace.
+func (h *DriverHooks) dispatchTrace(depth int, trace *types.ExecutionTrace) {
+	if h.OnSubcall != nil {
+		h.OnSubcall(depth, trace)
+	}
+	if h.OnGasCharge != nil {
+		for _, c := range trace.GasCharges {
+			h.OnGasCharge(depth, trace.Msg, c)
+		}
+	}
+	for i := range trace.Subcalls {
+		h.dispatchTrace(depth+1, &trace.Subcalls[i])
+	}
+}
+
+type hookedVM struct {
+	vm.Interface
+	hooks *DriverHooks
+}
+
+func (v *hookedVM) ApplyMessage(ctx context.Context, cmsg types.ChainMsg) (*vm.ApplyRet, error) {
+	if v.hooks.OnMessageStart != nil {
+		v.hooks.OnMessageStart(cmsg.VMMessage(), false)
+	}
+	ret, err := v.Interface.ApplyMessage(ctx, cmsg)
+	if err == nil && ret != nil {
+		v.hooks.dispatchTrace(0, &ret.ExecutionTrace)
+	}
+	return ret, err
+}
+
+func (v *hookedVM) ApplyImplicitMessage(ctx context.Context, msg *types.Message) (*vm.ApplyRet, error) {
+	if v.hooks.OnMessageStart != nil {
+		v.hooks.OnMessageStart(msg, true)
+	}
+	ret, err := v.Interface.ApplyImplicitMessage(ctx, msg)
+	if err == nil && ret != nil {
+		v.hooks.dispatchTrace(0, &ret.ExecutionTrace)
+	}
+	return ret, err
+}
+
+type hookedBlockstore struct {
+	blockstore.Blockstore
+	onWrite func(c cid.Cid, data []byte)
+}
+
+func (bs *hookedBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	if err := bs.Blockstore.Put(ctx, blk); err != nil {
+		return err
+	}
+	bs.onWrite(blk.Cid(), blk.RawData())
+	return nil
+}
+
+func (bs *hookedBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	if err := bs.Blockstore.PutMany(ctx, blks); err != nil {
+		return err
+	}
+	for _, blk := range blks {
+		bs.onWrite(blk.Cid(), blk.RawData())
+	}
+	return nil
+}
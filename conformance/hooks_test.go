@@ -0,0 +1,64 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+type fakeVM struct {
+	vm.Interface
+	ret *vm.ApplyRet
+}
+
+func (v *fakeVM) ApplyImplicitMessage(context.Context, *types.Message) (*vm.ApplyRet, error) {
+	return v.ret, nil
+}
+
+func TestDriverHooks(t *testing.T) {
+	ret := &vm.ApplyRet{
+		ExecutionTrace: types.ExecutionTrace{
+			Msg:        &types.Message{Method: 1},
+			GasCharges: []*types.GasTrace{{Name: "OnMethodInvocation"}},
+			Subcalls: []types.ExecutionTrace{
+				{
+					Msg:        &types.Message{Method: 2},
+					GasCharges: []*types.GasTrace{{Name: "OnGetActor"}, {Name: "OnMethodInvocation"}},
+				},
+			},
+		},
+	}
+
+	var (
+		started  []bool
+		subcalls []int
+		charges  []string
+	)
+	hooks := &DriverHooks{
+		OnMessageStart: func(_ *types.Message, implicit bool) { started = append(started, implicit) },
+		OnSubcall:      func(depth int, _ *types.ExecutionTrace) { subcalls = append(subcalls, depth) },
+		OnGasCharge: func(depth int, msg *types.Message, c *types.GasTrace) {
+			charges = append(charges, c.Name)
+			if int(msg.Method) != depth+1 {
+				t.Fatalf("gas charge %s attributed to the wrong call", c.Name)
+			}
+		},
+	}
+
+	if _, err := hooks.wrapVM(&fakeVM{ret: ret}).ApplyImplicitMessage(context.Background(), &types.Message{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(started) != 1 || !started[0] {
+		t.Fatalf("expected a single implicit message start; got %v", started)
+	}
+	if len(subcalls) != 2 || subcalls[0] != 0 || subcalls[1] != 1 {
+		t.Fatalf("unexpected subcall depths: %v", subcalls)
+	}
+	if len(charges) != 3 || charges[0] != "OnMethodInvocation" || charges[1] != "OnGetActor" {
+		t.Fatalf("unexpected gas charges: %v", charges)
+	}
+}
@@ -0,0 +1,41 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// wrapVM returns the supplied VM, wrapped to invoke the hooks of the driver,
+// and to refuse the messages applied once the context of the driver is done,
+// e.g. when the execution of the vector times out. The execution is thus
+// interrupted between messages, tipsets included; a message being applied
+// runs to completion.
+func (d *Driver) wrapVM(vmi vm.Interface) vm.Interface {
+	vmi = d.hooks.wrapVM(vmi)
+	if d.ctx == nil || d.ctx.Done() == nil {
+		return vmi
+	}
+	return &interruptibleVM{Interface: vmi, ctx: d.ctx}
+}
+
+type interruptibleVM struct {
+	vm.Interface
+	ctx context.Context
+}
+
+func (v *interruptibleVM) ApplyMessage(ctx context.Context, cmsg types.ChainMsg) (*vm.ApplyRet, error) {
+	if err := v.ctx.Err(); err != nil {
+		return nil, fmt.Errorf("execution interrupted: %w", err)
+	}
+	return v.Interface.ApplyMessage(ctx, cmsg)
+}
+
+func (v *interruptibleVM) ApplyImplicitMessage(ctx context.Context, msg *types.Message) (*vm.ApplyRet, error) {
+	if err := v.ctx.Err(); err != nil {
+		return nil, fmt.Errorf("execution interrupted: %w", err)
+	}
+	return v.Interface.ApplyImplicitMessage(ctx, msg)
+}
@@ -0,0 +1,54 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// countingVM counts the messages applied; its other methods aren't
+// implemented.
+type countingVM struct {
+	vm.Interface
+	applied int
+}
+
+func (v *countingVM) ApplyMessage(context.Context, types.ChainMsg) (*vm.ApplyRet, error) {
+	v.applied++
+	return &vm.ApplyRet{}, nil
+}
+
+func (v *countingVM) ApplyImplicitMessage(context.Context, *types.Message) (*vm.ApplyRet, error) {
+	v.applied++
+	return &vm.ApplyRet{}, nil
+}
+
+func TestDriverInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := NewDriver(ctx, nil, DriverOpts{})
+	inner := new(countingVM)
+	vmi := d.wrapVM(inner)
+
+	msg := &types.Message{}
+	if _, err := vmi.ApplyMessage(ctx, msg); err != nil {
+		t.Fatalf("unexpected error before the context is done: %s", err)
+	}
+	if _, err := vmi.ApplyImplicitMessage(ctx, msg); err != nil {
+		t.Fatalf("unexpected error before the context is done: %s", err)
+	}
+
+	cancel()
+	if _, err := vmi.ApplyMessage(ctx, msg); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the message to be refused once the context is done; got %v", err)
+	}
+	if _, err := vmi.ApplyImplicitMessage(ctx, msg); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the implicit message to be refused once the context is done; got %v", err)
+	}
+	if inner.applied != 2 {
+		t.Errorf("expected 2 messages to be applied; got %d", inner.applied)
+	}
+}
@@ -0,0 +1,26 @@
+package conformance
+
+import (
+	"errors"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// SelectorLight, if "true", indicates that the vector is light: a message
+// vector carrying no state, but its message, the epoch, basefee and
+// circulating supply it's applied with, and its expected receipt, for
+// implementations replaying it against their own synced chain. Its pre-state
+// root is the parent state of the tipset including the message, and the
+// generation data of the vector name the tipsets including and executing it.
+// Light vectors are cheap to extract in bulk, as a smoke layer before full
+// state-based vectors; Lotus doesn't execute them, as it holds no chain while
+// running vectors.
+const SelectorLight = "light"
+
+// ErrLightVector is returned by ExecuteVariant for light vectors.
+var ErrLightVector = errors.New("light vector, carrying no state; replay it against a synced chain")
+
+// IsLightVector returns whether the vector is light; see SelectorLight.
+func IsLightVector(vector *schema.TestVector) bool {
+	return vector.Selector[SelectorLight] == "true"
+}
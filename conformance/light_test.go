@@ -0,0 +1,29 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestLightVector(t *testing.T) {
+	tv := &schema.TestVector{
+		Class:    schema.ClassMessage,
+		Selector: schema.Selector{SelectorLight: "true"},
+	}
+	if !IsLightVector(tv) {
+		t.Fatal("expected the vector to be light")
+	}
+	if _, err := ExecuteVariant(context.Background(), new(LogReporter), tv, &schema.Variant{}, ExecuteOpts{}); !errors.Is(err, ErrLightVector) {
+		t.Errorf("expected a light vector to be refused; got %v", err)
+	}
+	if reason := new(Runner).SkipReason("light.json", tv); reason == "" {
+		t.Error("expected the runner to skip a light vector")
+	}
+	if IsLightVector(&schema.TestVector{Class: schema.ClassMessage}) {
+		t.Error("expected a vector without the selector not to be light")
+	}
+}
@@ -0,0 +1,48 @@
+package conformance
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ErrLimitExceeded is returned by the driver when the execution of a vector
+// exceeds its ExecutionLimits.
+var ErrLimitExceeded = errors.New("execution limit exceeded")
+
+// ExecutionLimits bound the resources the execution of a vector may use, so
+// that pathological vectors, e.g. fuzz mutants advancing the epoch by
+// billions or carrying huge gas limits, fail fast rather than hang a run.
+type ExecutionLimits struct {
+	// MaxGasLimit is the maximum gas limit of the explicit messages applied;
+	// 0 for no limit. Implicit messages are exempt, as they carry fixed,
+	// large gas limits.
+	MaxGasLimit int64
+
+	// MaxSteps is the maximum number of messages applied by the driver,
+	// implicit ones included (e.g. the cron ticks of the epochs advanced
+	// over); 0 for no limit.
+	MaxSteps int
+}
+
+// checkLimits accounts for steps messages about to be applied, checking the
+// supplied explicit messages against the gas limit, and fails with
+// ErrLimitExceeded if the limits are exceeded.
+func (d *Driver) checkLimits(steps int, msgs ...*types.Message) error {
+	if d.limits == nil {
+		return nil
+	}
+	if max := d.limits.MaxGasLimit; max > 0 {
+		for _, msg := range msgs {
+			if msg.GasLimit > max {
+				return fmt.Errorf("%w: gas limit of message %s is %d (max %d)", ErrLimitExceeded, msg.Cid(), msg.GasLimit, max)
+			}
+		}
+	}
+	d.steps += steps
+	if max := d.limits.MaxSteps; max > 0 && d.steps > max {
+		return fmt.Errorf("%w: more than %d messages applied", ErrLimitExceeded, max)
+	}
+	return nil
+}
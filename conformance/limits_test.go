@@ -0,0 +1,27 @@
+// stm: #unit
+package conformance
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestExecutionLimits(t *testing.T) {
+	d := &Driver{limits: &ExecutionLimits{MaxGasLimit: 1000, MaxSteps: 2}}
+
+	if err := d.checkLimits(1, &types.Message{GasLimit: 1001}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected the gas limit to be exceeded; got %v", err)
+	}
+	if err := d.checkLimits(2, &types.Message{GasLimit: 1000}); err != nil {
+		t.Errorf("unexpected error within limits: %s", err)
+	}
+	if err := d.checkLimits(1); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected the step limit to be exceeded; got %v", err)
+	}
+
+	if err := new(Driver).checkLimits(1 << 20); err != nil {
+		t.Errorf("unexpected error without limits: %s", err)
+	}
+}
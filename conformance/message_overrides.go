@@ -0,0 +1,84 @@
+package conformance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// MessageOverrides tweak the fields of the explicit messages of a vector at
+// execution time, without editing the vector, e.g. to check during triage
+// whether a failure persists with a higher gas limit. Implicit messages are
+// left as they are.
+//
+// Results obtained with overrides are non-canonical: the messages applied
+// aren't those of the vector, so their CIDs, and thus the receipts roots, and
+// usually the post state roots, differ from the expected ones, and their
+// signatures no longer hold (signatures are verified, if required, on the
+// messages of the vector, before the overrides are applied). They answer
+// "would it still fail?", and must never be recorded as expectations.
+type MessageOverrides struct {
+	// NonceOffset is added to the nonces of the messages.
+	NonceOffset int64
+	// GasLimit, if not 0, replaces the gas limits of the messages.
+	GasLimit int64
+	// GasFeeCap, if not nil, replaces the gas fee caps of the messages.
+	GasFeeCap *abi.TokenAmount
+	// GasPremium, if not nil, replaces the gas premiums of the messages.
+	GasPremium *abi.TokenAmount
+}
+
+// IsZero returns whether the overrides leave messages as they are.
+func (o *MessageOverrides) IsZero() bool {
+	return o == nil || (o.NonceOffset == 0 && o.GasLimit == 0 && o.GasFeeCap == nil && o.GasPremium == nil)
+}
+
+// String describes the overrides, e.g. "nonce+1 gas_limit=10000000".
+func (o *MessageOverrides) String() string {
+	if o.IsZero() {
+		return "none"
+	}
+	var s []string
+	if o.NonceOffset != 0 {
+		s = append(s, fmt.Sprintf("nonce%+d", o.NonceOffset))
+	}
+	if o.GasLimit != 0 {
+		s = append(s, fmt.Sprintf("gas_limit=%d", o.GasLimit))
+	}
+	if o.GasFeeCap != nil {
+		s = append(s, fmt.Sprintf("gas_fee_cap=%s", o.GasFeeCap))
+	}
+	if o.GasPremium != nil {
+		s = append(s, fmt.Sprintf("gas_premium=%s", o.GasPremium))
+	}
+	return strings.Join(s, " ")
+}
+
+// Apply returns a copy of the message with the overrides applied, or the
+// message itself if there are none.
+func (o *MessageOverrides) Apply(msg *types.Message) (*types.Message, error) {
+	if o.IsZero() {
+		return msg, nil
+	}
+	m := *msg
+	if o.NonceOffset != 0 {
+		nonce := int64(m.Nonce) + o.NonceOffset
+		if nonce < 0 {
+			return nil, fmt.Errorf("nonce offset %d underflows nonce %d of message %s", o.NonceOffset, m.Nonce, msg.Cid())
+		}
+		m.Nonce = uint64(nonce)
+	}
+	if o.GasLimit != 0 {
+		m.GasLimit = o.GasLimit
+	}
+	if o.GasFeeCap != nil {
+		m.GasFeeCap = *o.GasFeeCap
+	}
+	if o.GasPremium != nil {
+		m.GasPremium = *o.GasPremium
+	}
+	return &m, nil
+}
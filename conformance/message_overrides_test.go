@@ -0,0 +1,39 @@
+// stm: #unit
+package conformance
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestMessageOverrides(t *testing.T) {
+	msg := &types.Message{Nonce: 3, GasLimit: 1000, GasFeeCap: abi.NewTokenAmount(10), GasPremium: abi.NewTokenAmount(1)}
+
+	var none *MessageOverrides
+	if m, err := none.Apply(msg); err != nil || m != msg {
+		t.Errorf("expected the message as is without overrides; got %v, %v", m, err)
+	}
+
+	premium := abi.NewTokenAmount(5)
+	o := &MessageOverrides{NonceOffset: -1, GasLimit: 2000, GasPremium: &premium}
+	m, err := o.Apply(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Nonce != 2 || m.GasLimit != 2000 || !m.GasFeeCap.Equals(abi.NewTokenAmount(10)) || !m.GasPremium.Equals(premium) {
+		t.Errorf("unexpected overridden message: %+v", m)
+	}
+	if msg.Nonce != 3 || msg.GasLimit != 1000 {
+		t.Error("the original message was modified")
+	}
+	if s := o.String(); s != "nonce-1 gas_limit=2000 gas_premium=5" {
+		t.Errorf("unexpected description %q", s)
+	}
+
+	if _, err := (&MessageOverrides{NonceOffset: -4}).Apply(msg); err == nil {
+		t.Error("expected a nonce underflow to fail")
+	}
+}
@@ -0,0 +1,78 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// SelectorNetwork, if it appears in a vector, is the name of the network the
+// vector was extracted from, as reported by the node, e.g. "testnetnet" for
+// mainnet, or "calibrationnet". Addresses are formatted with the prefix of
+// that network while the vector executes, and runners configured for a
+// network refuse vectors of others; see ExecuteOpts.Network.
+const SelectorNetwork = "network"
+
+// ErrNetworkMismatch is returned for vectors of a network other than that
+// the runner is configured for.
+var ErrNetworkMismatch = errors.New("network mismatch")
+
+// NormalizeNetworkName returns the canonical name of the network: mainnet
+// reports itself as "testnetnet" for historical reasons, and is named
+// "mainnet" instead.
+func NormalizeNetworkName(name string) string {
+	if name == "testnetnet" {
+		return "mainnet"
+	}
+	return name
+}
+
+// AddressNetwork returns the address network of the named network: mainnet
+// addresses are prefixed with f, and those of all other networks with t.
+func AddressNetwork(name string) address.Network {
+	if NormalizeNetworkName(name) == "mainnet" {
+		return address.Mainnet
+	}
+	return address.Testnet
+}
+
+// checkVectorNetwork validates the network of the vector, if declared,
+// against that the runner is configured for, if any.
+func checkVectorNetwork(vector *schema.TestVector, network string) error {
+	name, ok := vector.Selector[SelectorNetwork]
+	if !ok || network == "" {
+		return nil
+	}
+	if NormalizeNetworkName(name) != NormalizeNetworkName(network) {
+		return fmt.Errorf("%w: vector of network %s, runner configured for %s", ErrNetworkMismatch, name, network)
+	}
+	return nil
+}
+
+// useVectorNetwork validates the network of the vector against that of the
+// options, its requirements on the build (see checkVectorRequirements), and
+// the proof parameters it requires (see EnsureProofParams), and switches the
+// address network to its own, if declared. It returns a function reverting
+// the address network, which the caller MUST invoke.
+func useVectorNetwork(ctx context.Context, vector *schema.TestVector, opts ExecuteOpts) (restore func(), err error) {
+	restore = func() {}
+	if err := checkVectorNetwork(vector, opts.Network); err != nil {
+		return restore, err
+	}
+	if err := checkVectorRequirements(vector, opts.LenientRequirements); err != nil {
+		return restore, err
+	}
+	if err := EnsureProofParams(ctx, vector, opts.FetchProofParams); err != nil {
+		return restore, err
+	}
+	name, ok := vector.Selector[SelectorNetwork]
+	if !ok {
+		return restore, nil
+	}
+	prev := address.CurrentNetwork
+	address.CurrentNetwork = AddressNetwork(name)
+	return func() { address.CurrentNetwork = prev }, nil
+}
@@ -0,0 +1,46 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestUseVectorNetwork(t *testing.T) {
+	prev := address.CurrentNetwork
+	defer func() { address.CurrentNetwork = prev }()
+
+	address.CurrentNetwork = address.Mainnet
+	calibnet := &schema.TestVector{Selector: schema.Selector{SelectorNetwork: "calibrationnet"}}
+	restore, err := useVectorNetwork(context.Background(), calibnet, ExecuteOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address.CurrentNetwork != address.Testnet {
+		t.Error("expected the testnet address network for a calibnet vector")
+	}
+	restore()
+	if address.CurrentNetwork != address.Mainnet {
+		t.Error("expected the address network to be restored")
+	}
+
+	opts := ExecuteOpts{Network: "mainnet"}
+	if _, err := useVectorNetwork(context.Background(), calibnet, opts); !errors.Is(err, ErrNetworkMismatch) {
+		t.Errorf("expected a network mismatch; got %v", err)
+	}
+	mainnet := &schema.TestVector{Selector: schema.Selector{SelectorNetwork: "testnetnet"}}
+	if restore, err := useVectorNetwork(context.Background(), mainnet, opts); err != nil {
+		t.Errorf("unexpected error for a mainnet vector: %s", err)
+	} else {
+		restore()
+	}
+	if restore, err := useVectorNetwork(context.Background(), &schema.TestVector{}, opts); err != nil {
+		t.Errorf("unexpected error for a vector without network: %s", err)
+	} else {
+		restore()
+	}
+}
@@ -0,0 +1,55 @@
+package conformance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// SelectorNullRounds, if it appears in a message-class vector, lists the null
+// rounds immediately preceding the epoch of the vector, comma-separated and in
+// order. The chain applies cron at the end of each, before the messages of the
+// tipset at the epoch of the vector; the pre-state of the vector already
+// includes those cron ticks, so it isn't the parent state of that tipset.
+// Tipset-class vectors declare none: their null rounds are the gaps between
+// the epochs of their tipsets, from that of the variant, and the driver
+// applies cron at each (see ExecuteTipsets).
+const SelectorNullRounds = "null_rounds"
+
+// NullRounds returns the null rounds the vector declares through
+// SelectorNullRounds, if any. They must be distinct, in order, and precede
+// the epoch of the variant.
+func NullRounds(vector *schema.TestVector, variant *schema.Variant) ([]abi.ChainEpoch, error) {
+	s, ok := vector.Selector[SelectorNullRounds]
+	if !ok || s == "" {
+		return nil, nil
+	}
+	var epochs []abi.ChainEpoch
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(f), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s selector: %w", SelectorNullRounds, err)
+		}
+		e := abi.ChainEpoch(n)
+		if len(epochs) > 0 && e <= epochs[len(epochs)-1] {
+			return nil, fmt.Errorf("invalid %s selector: null round %d out of order", SelectorNullRounds, e)
+		}
+		epochs = append(epochs, e)
+	}
+	if last := epochs[len(epochs)-1]; variant != nil && last >= abi.ChainEpoch(variant.Epoch) {
+		return nil, fmt.Errorf("invalid %s selector: null round %d doesn't precede the epoch %d of variant %s", SelectorNullRounds, last, variant.Epoch, variant.ID)
+	}
+	return epochs, nil
+}
+
+// FormatNullRounds formats the null rounds as the value of SelectorNullRounds.
+func FormatNullRounds(epochs []abi.ChainEpoch) string {
+	s := make([]string, len(epochs))
+	for i, e := range epochs {
+		s[i] = strconv.FormatInt(int64(e), 10)
+	}
+	return strings.Join(s, ",")
+}
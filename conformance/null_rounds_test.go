@@ -0,0 +1,31 @@
+// stm: #unit
+package conformance
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestNullRounds(t *testing.T) {
+	variant := &schema.Variant{ID: "test", Epoch: 13}
+	tv := &schema.TestVector{Selector: schema.Selector{SelectorNullRounds: FormatNullRounds([]abi.ChainEpoch{11, 12})}}
+	epochs, err := NullRounds(tv, variant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(epochs) != 2 || epochs[0] != 11 || epochs[1] != 12 {
+		t.Fatalf("expected null rounds 11 and 12; got %v", epochs)
+	}
+
+	if epochs, err := NullRounds(&schema.TestVector{}, variant); err != nil || epochs != nil {
+		t.Errorf("expected no null rounds without the selector; got %v, %v", epochs, err)
+	}
+	for _, s := range []string{"11,x", "12,11", "11,11", "12,13"} {
+		tv := &schema.TestVector{Selector: schema.Selector{SelectorNullRounds: s}}
+		if _, err := NullRounds(tv, variant); err == nil {
+			t.Errorf("expected null rounds %q to be rejected at epoch %d", s, variant.Epoch)
+		}
+	}
+}
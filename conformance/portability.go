@@ -0,0 +1,236 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+)
+
+// The recorded syscalls whose inputs include addresses, which are keyed by
+// their string form, and thus by the address network they were recorded with.
+var addressKeyedSyscalls = []string{"VerifySignature", "BatchVerifySeals"}
+
+// NetworkDependency is a value of a vector whose interpretation depends on
+// the address network in effect while the vector executes: that of the
+// network the vector declares through SelectorNetwork, or else that of the
+// runner. A vector extracted from calibnet, declaring no network, fails to
+// match such values when executed by a runner configured for mainnet.
+type NetworkDependency struct {
+	// Where describes the value, e.g. "actor postcondition f01000 field
+	// Info.Owner".
+	Where string
+	// Address is the address string the value holds, whose prefix is that of
+	// the address network it's formatted for; it's empty for values hashing
+	// addresses, e.g. the keys of recorded syscall outcomes.
+	Address string
+}
+
+// NetworkDependencies returns the values of the vector that depend on the
+// address network: the address strings asserted by its actor postconditions,
+// and its recorded outcomes of syscalls taking addresses. Addresses in
+// messages and state are encoded in binary, and don't depend on the network.
+func NetworkDependencies(ctx context.Context, bs blockstore.Blockstore, vector *schema.TestVector) ([]NetworkDependency, error) {
+	var ret []NetworkDependency
+
+	pcs, err := loadActorPostconditions(ctx, bs, vector)
+	if err != nil {
+		return nil, err
+	}
+	for _, pc := range pcs {
+		err := rewriteAddressFields(pc, func(path, addr string) string {
+			ret = append(ret, NetworkDependency{
+				Where:   fmt.Sprintf("actor postcondition %s field %s", pc.Actor, path),
+				Address: addr,
+			})
+			return addr
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s, ok := vector.Selector[SelectorRecordedSyscalls]; ok {
+		c, err := cid.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s selector: %w", SelectorRecordedSyscalls, err)
+		}
+		rec, err := LoadSyscallRecording(ctx, bs, c)
+		if err != nil {
+			return nil, err
+		}
+		counts := make(map[string]int)
+		for _, out := range rec.Outcomes {
+			counts[out.Syscall]++
+		}
+		for _, syscall := range addressKeyedSyscalls {
+			if n := counts[syscall]; n > 0 {
+				ret = append(ret, NetworkDependency{Where: fmt.Sprintf("%d recorded %s syscall outcomes", n, syscall)})
+			}
+		}
+	}
+	return ret, nil
+}
+
+// CheckPortability returns the problems making the vector misbehave on
+// runners configured for networks other than that it was extracted from: its
+// network dependencies if it declares no network, and its address strings
+// formatted for an address network other than that of the network it
+// declares otherwise. Vectors without problems execute alike on all runners.
+func CheckPortability(ctx context.Context, bs blockstore.Blockstore, vector *schema.TestVector) ([]string, error) {
+	deps, err := NetworkDependencies(ctx, bs, vector)
+	if err != nil {
+		return nil, err
+	}
+	name, declared := vector.Selector[SelectorNetwork]
+	var ret []string
+	for _, dep := range deps {
+		switch {
+		case !declared:
+			ret = append(ret, fmt.Sprintf("%s depends on the address network, and the vector declares no network", dep.Where))
+		case dep.Address != "" && addressStringNetwork(dep.Address) != AddressNetwork(name):
+			ret = append(ret, fmt.Sprintf("%s holds address %s, not formatted for network %s", dep.Where, dep.Address, name))
+		}
+	}
+	return ret, nil
+}
+
+// NormalizeAddressPrefixes rewrites the address strings asserted by the
+// inline actor postconditions of the vector to the address network of the
+// network it declares, and returns the number rewritten. Vectors declaring no
+// network, or storing their postconditions in their CAR, are left untouched.
+func NormalizeAddressPrefixes(vector *schema.TestVector) (int, error) {
+	name, ok := vector.Selector[SelectorNetwork]
+	if !ok {
+		return 0, nil
+	}
+	s, ok := vector.Selector[SelectorActorPostconditions]
+	if !ok {
+		return 0, nil
+	}
+	if _, err := cid.Decode(s); err == nil {
+		return 0, nil
+	}
+	var pcs []ActorPostcondition
+	if err := json.Unmarshal([]byte(s), &pcs); err != nil {
+		return 0, fmt.Errorf("invalid %s selector: %w", SelectorActorPostconditions, err)
+	}
+
+	network := AddressNetwork(name)
+	prefix := address.MainnetPrefix
+	if network == address.Testnet {
+		prefix = address.TestnetPrefix
+	}
+	var n int
+	for _, pc := range pcs {
+		err := rewriteAddressFields(pc, func(_, addr string) string {
+			if addressStringNetwork(addr) == network {
+				return addr
+			}
+			n++
+			return prefix + addr[1:]
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	// the actors of the postconditions are formatted with the address network
+	// in effect, which is switched to that of the vector, for consistency.
+	prev := address.CurrentNetwork
+	address.CurrentNetwork = network
+	data, err := json.Marshal(pcs)
+	address.CurrentNetwork = prev
+	if err != nil {
+		return 0, fmt.Errorf("failed to serialize actor postconditions: %w", err)
+	}
+	vector.Selector[SelectorActorPostconditions] = string(data)
+	return n, nil
+}
+
+// rewriteAddressFields calls fn with the path of each field asserted by the
+// postcondition, in order, and each address string its value holds, and
+// replaces the address strings with those returned.
+func rewriteAddressFields(pc ActorPostcondition, fn func(path, addr string) string) error {
+	paths := make([]string, 0, len(pc.Fields))
+	for path := range pc.Fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		var v interface{}
+		if err := json.Unmarshal(pc.Fields[path], &v); err != nil {
+			return fmt.Errorf("invalid actor postcondition on %s field %s: %w", pc.Actor, path, err)
+		}
+		var rewritten bool
+		v = rewriteAddressStrings(v, func(addr string) string {
+			ret := fn(path, addr)
+			rewritten = rewritten || ret != addr
+			return ret
+		})
+		if !rewritten {
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to serialize actor postcondition on %s field %s: %w", pc.Actor, path, err)
+		}
+		pc.Fields[path] = data
+	}
+	return nil
+}
+
+// rewriteAddressStrings replaces the address strings within the JSON value,
+// in order, with those returned by fn.
+func rewriteAddressStrings(v interface{}, fn func(addr string) string) interface{} {
+	switch v := v.(type) {
+	case string:
+		if isAddressString(v) {
+			return fn(v)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = rewriteAddressStrings(v[i], fn)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v[k] = rewriteAddressStrings(v[k], fn)
+		}
+	}
+	return v
+}
+
+// isAddressString returns whether the string is an address, formatted for
+// either address network.
+func isAddressString(s string) bool {
+	if len(s) < 3 || (s[:1] != address.MainnetPrefix && s[:1] != address.TestnetPrefix) {
+		return false
+	}
+	_, err := address.NewFromString(s)
+	return err == nil
+}
+
+// addressStringNetwork returns the address network the address string is
+// formatted for.
+func addressStringNetwork(s string) address.Network {
+	if s[:1] == address.MainnetPrefix {
+		return address.Mainnet
+	}
+	return address.Testnet
+}
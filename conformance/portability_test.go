@@ -0,0 +1,56 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+)
+
+func TestCheckPortability(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	pcs := `[{"actor":"t01000","fields":{"Info.Owner":"t01001","Info.ControlAddresses":["t01002","f01003"],"Info.SectorSize":34359738368}}]`
+
+	undeclared := &schema.TestVector{Selector: schema.Selector{SelectorActorPostconditions: pcs}}
+	problems, err := CheckPortability(ctx, bs, undeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 3 {
+		t.Fatalf("expected a problem per address string; got %q", problems)
+	}
+	if n, err := NormalizeAddressPrefixes(undeclared); err != nil || n != 0 {
+		t.Errorf("expected vectors declaring no network to be left untouched; got %d, %v", n, err)
+	}
+
+	calibnet := &schema.TestVector{Selector: schema.Selector{
+		SelectorNetwork:             "calibrationnet",
+		SelectorActorPostconditions: pcs,
+	}}
+	problems, err = CheckPortability(ctx, bs, calibnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "f01003") {
+		t.Fatalf("expected the mainnet address to be reported; got %q", problems)
+	}
+
+	n, err := NormalizeAddressPrefixes(calibnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected an address to be rewritten; got %d", n)
+	}
+	if problems, err := CheckPortability(ctx, bs, calibnet); err != nil || len(problems) != 0 {
+		t.Errorf("expected no problems once normalized; got %q, %v", problems, err)
+	}
+	if s := calibnet.Selector[SelectorActorPostconditions]; !strings.Contains(s, `"actor":"t01000"`) || !strings.Contains(s, "t01003") {
+		t.Errorf("unexpected normalized postconditions: %s", s)
+	}
+}
@@ -0,0 +1,200 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+)
+
+// SelectorActorPostconditions, if it appears in a vector, it holds
+// postconditions on specific actors, asserted on the post-state after
+// execution: a JSON array of ActorPostcondition, or the CID of such an array
+// stored as a raw block in the vector's CAR. Vectors carrying actor
+// postconditions may omit the post state root, which is then not asserted;
+// hand-written vectors can thus assert the outcomes they exercise without
+// pinning the whole state.
+const SelectorActorPostconditions = "actor_postconditions"
+
+// ActorPostcondition is a postcondition on an actor of the post-state. Nil
+// fields aren't asserted.
+type ActorPostcondition struct {
+	Actor address.Address `json:"actor"`
+	// Absent asserts that the actor doesn't exist; other fields are then
+	// ignored.
+	Absent  bool             `json:"absent,omitempty"`
+	Balance *abi.TokenAmount `json:"balance,omitempty"`
+	Nonce   *uint64          `json:"nonce,omitempty"`
+	Head    *cid.Cid         `json:"head,omitempty"`
+	// Fields asserts fields of the state of the actor, decoded as JSON, by
+	// dot-separated path, e.g. "LockedFunds" or "Info.Owner".
+	Fields map[string]json.RawMessage `json:"fields,omitempty"`
+}
+
+// loadActorPostconditions loads the actor postconditions of the vector, if
+// it carries SelectorActorPostconditions; it returns nil otherwise.
+func loadActorPostconditions(ctx context.Context, bs blockstore.Blockstore, vector *schema.TestVector) ([]ActorPostcondition, error) {
+	s, ok := vector.Selector[SelectorActorPostconditions]
+	if !ok {
+		return nil, nil
+	}
+	data := []byte(s)
+	if c, err := cid.Decode(s); err == nil {
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load actor postconditions %s: %w", c, err)
+		}
+		data = blk.RawData()
+	}
+	var pcs []ActorPostcondition
+	if err := json.Unmarshal(data, &pcs); err != nil {
+		return nil, fmt.Errorf("invalid %s selector: %w", SelectorActorPostconditions, err)
+	}
+	return pcs, nil
+}
+
+// AssertActorPostconditions asserts the postconditions on the actors of the
+// state tree at the root, reporting the failed ones, which it also returns.
+func AssertActorPostconditions(ctx context.Context, r Reporter, bs blockstore.Blockstore, root cid.Cid, pcs []ActorPostcondition) error {
+	r.Helper()
+
+	st, err := state.LoadStateTree(cbornode.NewCborStore(bs), root)
+	if err != nil {
+		return fmt.Errorf("failed to load the post-state tree: %w", err)
+	}
+
+	var merr error
+	fail := func(format string, args ...interface{}) {
+		ierr := fmt.Errorf(format, args...)
+		r.Errorf(ierr.Error())
+		merr = multierror.Append(merr, ierr)
+	}
+	for _, pc := range pcs {
+		act, err := st.GetActor(pc.Actor)
+		switch {
+		case pc.Absent && err == nil:
+			fail("actor %s: expected to be absent, but exists", pc.Actor)
+			continue
+		case pc.Absent:
+			continue
+		case err != nil:
+			fail("actor %s: failed to load: %s", pc.Actor, err)
+			continue
+		}
+
+		if pc.Balance != nil && !pc.Balance.Equals(act.Balance) {
+			fail("actor %s: wrong balance; expected %s, got %s", pc.Actor, *pc.Balance, act.Balance)
+		}
+		if pc.Nonce != nil && *pc.Nonce != act.Nonce {
+			fail("actor %s: wrong nonce; expected %d, got %d", pc.Actor, *pc.Nonce, act.Nonce)
+		}
+		if pc.Head != nil && *pc.Head != act.Head {
+			fail("actor %s: wrong head; expected %s, got %s", pc.Actor, *pc.Head, act.Head)
+		}
+		if len(pc.Fields) == 0 {
+			continue
+		}
+		decoded, err := ActorStates.DecodeStateJSON(ctx, bs, act)
+		if err != nil {
+			fail("actor %s: failed to decode state: %s", pc.Actor, err)
+			continue
+		}
+		for path, expected := range pc.Fields {
+			actual, ok := stateField(decoded, path)
+			if !ok {
+				fail("actor %s: state field %s not found", pc.Actor, path)
+				continue
+			}
+			var e interface{}
+			if err := json.Unmarshal(expected, &e); err != nil {
+				fail("actor %s: invalid expected value of state field %s: %s", pc.Actor, path, err)
+				continue
+			}
+			if !reflect.DeepEqual(e, actual) {
+				a, _ := json.Marshal(actual)
+				fail("actor %s: wrong state field %s; expected %s, got %s", pc.Actor, path, expected, a)
+			}
+		}
+	}
+	return merr
+}
+
+// stateField returns the field of the decoded state at the dot-separated
+// path.
+func stateField(v interface{}, path string) (interface{}, bool) {
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// assertPostState asserts the post-state of the vector variant at the
+// supplied root: its actor postconditions, and its post state root. It
+// returns the state diffs of a wrong post state root, and the failed
+// assertions. The post-state is passed to opts.OnPostState first, if set.
+func assertPostState(ctx context.Context, r Reporter, vector *schema.TestVector, variant *schema.Variant, bs blockstore.Blockstore, root cid.Cid, opts ExecuteOpts) (diffs []string, err error) {
+	r.Helper()
+
+	if opts.OnPostState != nil {
+		opts.OnPostState(vector, variant, bs, root)
+	}
+
+	pcs, perr := loadActorPostconditions(ctx, bs, vector)
+	if perr != nil {
+		r.Errorf(perr.Error())
+		err = multierror.Append(err, perr)
+	} else if len(pcs) > 0 {
+		if aerr := AssertActorPostconditions(ctx, r, bs, root, pcs); aerr != nil {
+			err = multierror.Append(err, aerr)
+		}
+	}
+
+	// the post state root may be omitted by vectors with actor
+	// postconditions.
+	if vector.Post.StateTree == nil {
+		if len(pcs) == 0 {
+			ierr := fmt.Errorf("vector has neither a post state root nor actor postconditions")
+			r.Errorf(ierr.Error())
+			err = multierror.Append(err, ierr)
+		}
+		return nil, err
+	}
+
+	// assert that the final state root matches the expected postcondition
+	// root.
+	if expected, actual := vector.Post.StateTree.RootCID, root; expected != actual {
+		ierr := fmt.Errorf("wrong post root cid; expected %v, but got %v", expected, actual)
+		r.Errorf(ierr.Error())
+		err = multierror.Append(err, ierr)
+		if !opts.SemanticStateDiffs {
+			diffs = dumpThreeWayStateDiff(r, vector, bs, root)
+			return diffs, err
+		}
+		d, derr := DiffStateTrees(ctx, bs, expected, actual)
+		if derr != nil {
+			r.Log(fmt.Sprintf("failed to diff the expected and actual post states: %s", derr))
+			return nil, err
+		}
+		r.Log(d)
+		diffs = []string{d}
+	}
+	return diffs, err
+}
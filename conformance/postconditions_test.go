@@ -0,0 +1,79 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	cbornode "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestAssertActorPostconditions(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	cst := cbornode.NewCborStore(bs)
+
+	st, err := state.NewStateTree(cst, types.StateTreeVersion4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := cst.Put(ctx, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	present, _ := address.NewIDAddress(100)
+	absent, _ := address.NewIDAddress(101)
+	if err := st.SetActor(present, &types.Actor{Code: builtin2.AccountActorCodeID, Head: head, Nonce: 3, Balance: abi.NewTokenAmount(50)}); err != nil {
+		t.Fatal(err)
+	}
+	root, err := st.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		balance = abi.NewTokenAmount(50)
+		wrong   = abi.NewTokenAmount(49)
+		nonce   = uint64(3)
+	)
+	for _, tc := range []struct {
+		name   string
+		pcs    []ActorPostcondition
+		errors int
+	}{
+		{"satisfied", []ActorPostcondition{{Actor: present, Balance: &balance, Nonce: &nonce, Head: &head}, {Actor: absent, Absent: true}}, 0},
+		{"wrong balance", []ActorPostcondition{{Actor: present, Balance: &wrong}}, 1},
+		{"unexpectedly present", []ActorPostcondition{{Actor: present, Absent: true}}, 1},
+		{"missing", []ActorPostcondition{{Actor: absent, Nonce: &nonce}}, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(recordingReporter)
+			err := AssertActorPostconditions(ctx, r, bs, root, tc.pcs)
+			if r.errors != tc.errors || (err != nil) != (tc.errors > 0) {
+				t.Fatalf("expected %d errors, got %d (%v)", tc.errors, r.errors, err)
+			}
+		})
+	}
+}
+
+func TestStateField(t *testing.T) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(`{"Info": {"Owner": "f0100"}, "LockedFunds": "10"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := stateField(v, "Info.Owner"); !ok || f != "f0100" {
+		t.Errorf("unexpected field Info.Owner: %v", f)
+	}
+	if _, ok := stateField(v, "LockedFunds.Value"); ok {
+		t.Errorf("expected no field under a scalar")
+	}
+}
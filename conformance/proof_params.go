@@ -0,0 +1,145 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/go-units"
+
+	"github.com/filecoin-project/go-paramfetch"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/build"
+)
+
+// SelectorProofParams, if it appears in a vector, declares that the vector
+// verifies proofs for real, rather than replaying recorded or mocked
+// outcomes, and requires the proof parameter files to: a comma-separated
+// list of the sector sizes of the proofs, e.g. "32GiB,64GiB", whose
+// verification keys are required, "aggregate" for the SRS of aggregate
+// proofs, or "all" for every verification key and the SRS. Vectors whose
+// parameters are missing fail with ErrProofParamsMissing, unless
+// ExecuteOpts.FetchProofParams is set; runners skip them instead, or fetch
+// them before executing the vector if it is (see Runner.SkipReason).
+const SelectorProofParams = "proof_params"
+
+// Values of SelectorProofParams besides sector sizes.
+const (
+	ProofParamsAll       = "all"
+	ProofParamsAggregate = "aggregate"
+)
+
+// ErrProofParamsMissing is returned for vectors requiring proof parameters
+// missing from ProofParamsDir.
+var ErrProofParamsMissing = errors.New("proof parameters missing")
+
+// ProofParamsDir returns the directory the proof parameters are read from by
+// the proofs library, and fetched into: that of FIL_PROOFS_PARAMETER_CACHE,
+// or /var/tmp/filecoin-proof-parameters by default.
+func ProofParamsDir() string {
+	if dir := os.Getenv("FIL_PROOFS_PARAMETER_CACHE"); dir != "" {
+		return dir
+	}
+	return "/var/tmp/filecoin-proof-parameters"
+}
+
+// proofParamFile is an entry of the proof parameters manifests of the build.
+type proofParamFile struct {
+	SectorSize uint64 `json:"sector_size"`
+}
+
+// RequiredProofParams returns the names of the proof parameter files the
+// vector requires, sorted, or nil if it declares none.
+func RequiredProofParams(vector *schema.TestVector) ([]string, error) {
+	s, ok := vector.Selector[SelectorProofParams]
+	if !ok {
+		return nil, nil
+	}
+	var (
+		all, aggregate bool
+		sizes          = make(map[uint64]struct{})
+	)
+	for _, v := range strings.Split(s, ",") {
+		switch v = strings.TrimSpace(v); v {
+		case ProofParamsAll:
+			all = true
+		case ProofParamsAggregate:
+			aggregate = true
+		default:
+			size, err := units.RAMInBytes(v)
+			if err != nil || size <= 0 {
+				return nil, fmt.Errorf("invalid %s selector: invalid sector size %q", SelectorProofParams, v)
+			}
+			sizes[uint64(size)] = struct{}{}
+		}
+	}
+
+	var params, srs map[string]proofParamFile
+	if err := json.Unmarshal(build.ParametersJSON(), &params); err != nil {
+		return nil, fmt.Errorf("failed to decode the proof parameters manifest: %w", err)
+	}
+	if err := json.Unmarshal(build.SrsJSON(), &srs); err != nil {
+		return nil, fmt.Errorf("failed to decode the SRS manifest: %w", err)
+	}
+	var files []string
+	for name, p := range params {
+		// only the verification keys are required to verify proofs.
+		if !strings.HasSuffix(name, ".vk") {
+			continue
+		}
+		if _, ok := sizes[p.SectorSize]; ok || all {
+			files = append(files, name)
+		}
+	}
+	if all || aggregate {
+		for name := range srs {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// MissingProofParams returns an error wrapping ErrProofParamsMissing if the
+// vector requires proof parameters missing from ProofParamsDir.
+func MissingProofParams(vector *schema.TestVector) error {
+	files, err := RequiredProofParams(vector)
+	if err != nil {
+		return err
+	}
+	dir := ProofParamsDir()
+	var missing []string
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d of %d files required by the vector not in %s, e.g. %s; fetch them with lotus fetch-params, or tvx exec --fetch-proof-params",
+		ErrProofParamsMissing, len(missing), len(files), dir, missing[0])
+}
+
+// EnsureProofParams returns nil if the proof parameters the vector requires
+// are present, fetching them first if fetch is set, and an error wrapping
+// ErrProofParamsMissing otherwise. Verification keys are small, but the SRS
+// of aggregate proofs isn't.
+func EnsureProofParams(ctx context.Context, vector *schema.TestVector, fetch bool) error {
+	err := MissingProofParams(vector)
+	if err == nil || !fetch || !errors.Is(err, ErrProofParamsMissing) {
+		return err
+	}
+	// the verification keys of all sizes, and the SRS, are fetched; the
+	// parameters of no size, which only provers need.
+	if ferr := paramfetch.GetParams(ctx, build.ParametersJSON(), build.SrsJSON(), 0); ferr != nil {
+		return fmt.Errorf("%w: failed to fetch them: %s", ErrProofParamsMissing, ferr)
+	}
+	return MissingProofParams(vector)
+}
@@ -0,0 +1,59 @@
+// stm: #unit
+package conformance
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestProofParams(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FIL_PROOFS_PARAMETER_CACHE", dir)
+
+	if err := MissingProofParams(&schema.TestVector{}); err != nil {
+		t.Fatalf("expected vectors without the selector to require no parameters, got %s", err)
+	}
+
+	vector := &schema.TestVector{Selector: schema.Selector{SelectorProofParams: "2KiB"}}
+	files, err := RequiredProofParams(vector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("expected verification keys of 2KiB sectors")
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f, ".vk") {
+			t.Errorf("unexpected file %s required", f)
+		}
+	}
+	if err := MissingProofParams(vector); !errors.Is(err, ErrProofParamsMissing) {
+		t.Fatalf("expected missing parameters, got %v", err)
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := MissingProofParams(vector); err != nil {
+		t.Fatalf("expected the parameters to be found, got %s", err)
+	}
+
+	// all also requires the SRS, and the keys of other sizes.
+	all, err := RequiredProofParams(&schema.TestVector{Selector: schema.Selector{SelectorProofParams: ProofParamsAll}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) <= len(files) {
+		t.Errorf("expected more files for all sizes, got %d", len(all))
+	}
+
+	if _, err := RequiredProofParams(&schema.TestVector{Selector: schema.Selector{SelectorProofParams: "huge"}}); err == nil {
+		t.Error("expected an invalid sector size to fail")
+	}
+}
@@ -0,0 +1,135 @@
+package puppet
+
+import (
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/cbor"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/go-state-types/rt"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	runtime2 "github.com/filecoin-project/specs-actors/v2/actors/runtime"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+)
+
+//go:generate go run ./gen
+
+// Actor is a port of the puppet actor of specs-actors. It sends arbitrary
+// messages on behalf of the messages it receives, so that hand-built vectors
+// exercise the edge cases of sends and calls between actors, e.g. params,
+// returns and states that fail to marshal.
+//
+// Unlike the chaos actor, it isn't a singleton: vectors install it at any
+// address, with any state, in their pre-state. Its CID is PuppetActorCodeCID.
+//
+// Test vectors relying on the puppet actor being deployed will carry selector
+// "puppet_actor:true".
+type Actor struct{}
+
+const (
+	_          = 0 // skip zero iota value; first usage of iota gets 1.
+	MethodSend = builtin.MethodConstructor + iota
+	// MethodSendMarshalCBORFailure is the identifier for the method that sends
+	// a message with params that fail to marshal.
+	MethodSendMarshalCBORFailure
+	// MethodReturnMarshalCBORFailure is the identifier for the method that
+	// returns a value that fails to marshal.
+	MethodReturnMarshalCBORFailure
+	// MethodRuntimeTransactionMarshalCBORFailure is the identifier for the
+	// method that commits a state that fails to marshal.
+	MethodRuntimeTransactionMarshalCBORFailure
+)
+
+// Exports defines the methods this actor exposes publicly.
+func (a Actor) Exports() []interface{} {
+	return []interface{}{
+		builtin.MethodConstructor:                  a.Constructor,
+		MethodSend:                                 a.Send,
+		MethodSendMarshalCBORFailure:               a.SendMarshalCBORFailure,
+		MethodReturnMarshalCBORFailure:             a.ReturnMarshalCBORFailure,
+		MethodRuntimeTransactionMarshalCBORFailure: a.RuntimeTransactionMarshalCBORFailure,
+	}
+}
+
+func (a Actor) Code() cid.Cid     { return PuppetActorCodeCID }
+func (a Actor) State() cbor.Er    { return new(State) }
+func (a Actor) IsSingleton() bool { return false }
+
+var _ rt.VMActor = Actor{}
+
+// Constructor creates the empty state of the actor.
+func (a Actor) Constructor(rt runtime2.Runtime, _ *abi.EmptyValue) *abi.EmptyValue {
+	rt.ValidateImmediateCallerAcceptAny()
+	rt.StateCreate(&State{})
+	return nil
+}
+
+// SendParams are the params of the Send and SendMarshalCBORFailure methods.
+type SendParams struct {
+	To     address.Address
+	Value  abi.TokenAmount
+	Method abi.MethodNum
+	Params []byte
+}
+
+// SendReturn is the return value of the Send and SendMarshalCBORFailure
+// methods.
+type SendReturn struct {
+	Return builtin2.CBORBytes
+	Code   exitcode.ExitCode
+}
+
+// Send requests for this actor to send a message to an actor with the
+// passed parameters.
+func (a Actor) Send(rt runtime2.Runtime, params *SendParams) *SendReturn {
+	rt.ValidateImmediateCallerAcceptAny()
+	var out builtin2.CBORBytes
+	code := rt.Send(
+		params.To,
+		params.Method,
+		builtin2.CBORBytes(params.Params),
+		params.Value,
+		&out,
+	)
+	return &SendReturn{
+		Return: out,
+		Code:   code,
+	}
+}
+
+// SendMarshalCBORFailure requests for this actor to send a message to an
+// actor with params that fail to marshal; the params passed are ignored.
+func (a Actor) SendMarshalCBORFailure(rt runtime2.Runtime, params *SendParams) *SendReturn {
+	rt.ValidateImmediateCallerAcceptAny()
+	var out builtin2.CBORBytes
+	code := rt.Send(
+		params.To,
+		params.Method,
+		&FailToMarshalCBOR{},
+		params.Value,
+		&out,
+	)
+	return &SendReturn{
+		Return: out,
+		Code:   code,
+	}
+}
+
+// ReturnMarshalCBORFailure returns a value that fails to marshal.
+func (a Actor) ReturnMarshalCBORFailure(rt runtime2.Runtime, _ *abi.EmptyValue) *FailToMarshalCBOR {
+	rt.ValidateImmediateCallerAcceptAny()
+	return &FailToMarshalCBOR{}
+}
+
+// RuntimeTransactionMarshalCBORFailure commits a state that fails to marshal
+// in a transaction.
+func (a Actor) RuntimeTransactionMarshalCBORFailure(rt runtime2.Runtime, _ *abi.EmptyValue) *abi.EmptyValue {
+	rt.ValidateImmediateCallerAcceptAny()
+	var st State
+	rt.StateTransaction(&st, func() {
+		st.OptFailToMarshalCBOR = []*FailToMarshalCBOR{{}}
+	})
+	return nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	gen "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/lotus/conformance/puppet"
+)
+
+func main() {
+	if err := gen.WriteTupleEncodersToFile("./cbor_gen.go", "puppet",
+		puppet.State{},
+		puppet.SendParams{},
+		puppet.SendReturn{},
+	); err != nil {
+		panic(err)
+	}
+}
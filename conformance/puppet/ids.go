@@ -0,0 +1,16 @@
+package puppet
+
+import (
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// PuppetActorCodeCID is the CID by which this kind of actor will be identified.
+var PuppetActorCodeCID = func() cid.Cid {
+	builder := cid.V1Builder{Codec: cid.Raw, MhType: multihash.IDENTITY}
+	c, err := builder.Sum([]byte("fil/1/puppet"))
+	if err != nil {
+		panic(err)
+	}
+	return c
+}()
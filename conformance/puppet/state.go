@@ -0,0 +1,28 @@
+package puppet
+
+import (
+	"fmt"
+	"io"
+)
+
+// State is the state of the puppet actor.
+type State struct {
+	// OptFailToMarshalCBOR is a sentinel value. If the slice contains no
+	// values, the State struct will encode as CBOR without issue. If the slice
+	// is non-nil, CBOR encoding will fail.
+	OptFailToMarshalCBOR []*FailToMarshalCBOR
+}
+
+// FailToMarshalCBOR is a type that cannot be marshalled or unmarshalled to
+// CBOR despite implementing the CBORMarshaler and CBORUnmarshaler interface.
+type FailToMarshalCBOR struct{}
+
+// UnmarshalCBOR will fail to unmarshal the value from CBOR.
+func (t *FailToMarshalCBOR) UnmarshalCBOR(io.Reader) error {
+	return fmt.Errorf("failed to unmarshal cbor")
+}
+
+// MarshalCBOR will fail to marshal the value to CBOR.
+func (t *FailToMarshalCBOR) MarshalCBOR(io.Writer) error {
+	return fmt.Errorf("failed to marshal cbor")
+}
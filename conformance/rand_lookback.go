@@ -0,0 +1,197 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/rand"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// LookbackSourcePrefix prefixes the sources of the generation data recording
+// the lookback entries of a vector, as "lookback:<kind>:<epoch>".
+const LookbackSourcePrefix = "lookback:"
+
+// LookbackEntry records the block header randomness was drawn from at an
+// epoch: the header with the minimum ticket of the tipset chain randomness
+// is drawn from, or the header carrying the beacon entry beacon randomness is
+// drawn from. The headers are included in the vector CAR, so that the driver
+// derives randomness from them when the vector holds no recorded randomness
+// matching a request, e.g. because its entropy differs.
+type LookbackEntry struct {
+	Kind   schema.RandomnessKind
+	Epoch  abi.ChainEpoch
+	Header cid.Cid
+	// Round is the round of the beacon entry of the header randomness is
+	// drawn from; beacon entries only.
+	Round uint64
+}
+
+// GenerationData returns the generation data recording the entry in the
+// metadata of a vector.
+func (e LookbackEntry) GenerationData() schema.GenerationData {
+	version := "header=" + e.Header.String()
+	if e.Kind == schema.RandomnessBeacon {
+		version += fmt.Sprintf(",round=%d", e.Round)
+	}
+	return schema.GenerationData{
+		Source:  fmt.Sprintf("%s%s:%d", LookbackSourcePrefix, e.Kind, e.Epoch),
+		Version: version,
+	}
+}
+
+// LookbackEntries returns the lookback entries recorded in the metadata of a
+// vector.
+func LookbackEntries(meta *schema.Metadata) ([]LookbackEntry, error) {
+	if meta == nil {
+		return nil, nil
+	}
+	var entries []LookbackEntry
+	for _, g := range meta.Gen {
+		if !strings.HasPrefix(g.Source, LookbackSourcePrefix) {
+			continue
+		}
+		e, err := parseLookbackEntry(g)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lookback entry %s: %w", g.Source, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parseLookbackEntry(g schema.GenerationData) (LookbackEntry, error) {
+	var e LookbackEntry
+	kind, epoch, ok := strings.Cut(strings.TrimPrefix(g.Source, LookbackSourcePrefix), ":")
+	if !ok {
+		return e, fmt.Errorf("no epoch")
+	}
+	switch e.Kind = schema.RandomnessKind(kind); e.Kind {
+	case schema.RandomnessChain, schema.RandomnessBeacon:
+	default:
+		return e, fmt.Errorf("unknown randomness kind %q", kind)
+	}
+	n, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return e, fmt.Errorf("invalid epoch: %w", err)
+	}
+	e.Epoch = abi.ChainEpoch(n)
+
+	for _, kv := range strings.Split(g.Version, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		switch k {
+		case "header":
+			if e.Header, err = cid.Decode(v); err != nil {
+				return e, fmt.Errorf("invalid header: %w", err)
+			}
+		case "round":
+			if e.Round, err = strconv.ParseUint(v, 10, 64); err != nil {
+				return e, fmt.Errorf("invalid round: %w", err)
+			}
+		}
+	}
+	if !e.Header.Defined() {
+		return e, fmt.Errorf("no header")
+	}
+	return e, nil
+}
+
+// LookbackRand derives randomness from the tickets and beacon entries of the
+// block headers of the lookback entries of a vector, falling back to another
+// vm.Rand for epochs without entries.
+type LookbackRand struct {
+	chain    map[abi.ChainEpoch][]byte
+	beacon   map[abi.ChainEpoch][]byte
+	fallback vm.Rand
+}
+
+var _ vm.Rand = (*LookbackRand)(nil)
+
+// NewLookbackRand loads the block headers of the lookback entries from the
+// blockstore.
+func NewLookbackRand(bs blockstore.Blockstore, entries []LookbackEntry, fallback vm.Rand) (*LookbackRand, error) {
+	r := &LookbackRand{
+		chain:    make(map[abi.ChainEpoch][]byte),
+		beacon:   make(map[abi.ChainEpoch][]byte),
+		fallback: fallback,
+	}
+	for _, e := range entries {
+		blk, err := bs.Get(context.Background(), e.Header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load lookback header %s: %w", e.Header, err)
+		}
+		h, err := types.DecodeBlock(blk.RawData())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode lookback header %s: %w", e.Header, err)
+		}
+
+		switch e.Kind {
+		case schema.RandomnessChain:
+			if h.Ticket == nil {
+				return nil, fmt.Errorf("lookback header %s has no ticket", e.Header)
+			}
+			r.chain[e.Epoch] = h.Ticket.VRFProof
+		case schema.RandomnessBeacon:
+			var found bool
+			for _, be := range h.BeaconEntries {
+				if be.Round == e.Round {
+					r.beacon[e.Epoch], found = be.Data, true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("lookback header %s has no beacon entry for round %d", e.Header, e.Round)
+			}
+		}
+	}
+	return r, nil
+}
+
+func (r *LookbackRand) GetChainRandomness(ctx context.Context, pers crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) ([]byte, error) {
+	if base, ok := r.chain[round]; ok {
+		return rand.DrawRandomness(base, pers, round, entropy)
+	}
+	return r.fallback.GetChainRandomness(ctx, pers, round, entropy)
+}
+
+func (r *LookbackRand) GetBeaconRandomness(ctx context.Context, pers crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) ([]byte, error) {
+	if base, ok := r.beacon[round]; ok {
+		return rand.DrawRandomness(base, pers, round, entropy)
+	}
+	return r.fallback.GetBeaconRandomness(ctx, pers, round, entropy)
+}
+
+// newVectorRand returns the vm.Rand to execute the vector with: recorded
+// randomness is replayed, falling back to beacon randomness derived from the
+// beacon entries of the vector, then to randomness derived from its lookback
+// headers, if any, then to fixed randomness.
+func newVectorRand(r Reporter, bs blockstore.Blockstore, vector *schema.TestVector) (vm.Rand, error) {
+	rr := NewReplayingRand(r, vector.Randomness)
+	entries, err := LookbackEntries(vector.Meta)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		if rr.fallback, err = NewLookbackRand(bs, entries, rr.fallback); err != nil {
+			return nil, err
+		}
+	}
+	beacon, err := loadVectorBeaconEntries(context.Background(), bs, vector)
+	if err != nil {
+		return nil, err
+	}
+	if beacon != nil {
+		rr.fallback = NewBeaconRand(beacon, rr.fallback)
+	}
+	return rr, nil
+}
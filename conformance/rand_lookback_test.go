@@ -0,0 +1,96 @@
+// stm: #unit
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/rand"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestLookbackRand(t *testing.T) {
+	ctx := context.Background()
+	dummy, err := abi.CidBuilder.Sum([]byte("dummy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	miner, _ := address.NewIDAddress(1000)
+	h := &types.BlockHeader{
+		Miner:                 miner,
+		Ticket:                &types.Ticket{VRFProof: []byte("ticket")},
+		ElectionProof:         &types.ElectionProof{},
+		BeaconEntries:         []types.BeaconEntry{{Round: 7, Data: []byte("beacon7")}, {Round: 8, Data: []byte("beacon8")}},
+		ParentWeight:          types.NewInt(0),
+		Height:                100,
+		ParentStateRoot:       dummy,
+		ParentMessageReceipts: dummy,
+		Messages:              dummy,
+		ParentBaseFee:         types.NewInt(0),
+	}
+	blk, err := h.ToStorageBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewMemory()
+	if err := bs.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+
+	// round trip the entries through the vector metadata.
+	meta := &schema.Metadata{Gen: []schema.GenerationData{
+		{Source: "network:mainnet"},
+		LookbackEntry{Kind: schema.RandomnessChain, Epoch: 90, Header: blk.Cid()}.GenerationData(),
+		LookbackEntry{Kind: schema.RandomnessBeacon, Epoch: 95, Header: blk.Cid(), Round: 7}.GenerationData(),
+	}}
+	entries, err := LookbackEntries(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Epoch != 90 || entries[1].Round != 7 || entries[1].Header != blk.Cid() {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	r, err := NewLookbackRand(bs, entries, NewFixedRand())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entropy := []byte("entropy")
+	for _, tc := range []struct {
+		get   func(context.Context, crypto.DomainSeparationTag, abi.ChainEpoch, []byte) ([]byte, error)
+		epoch abi.ChainEpoch
+		base  []byte
+	}{
+		{r.GetChainRandomness, 90, []byte("ticket")},
+		{r.GetBeaconRandomness, 95, []byte("beacon7")},
+	} {
+		expected, err := rand.DrawRandomness(tc.base, crypto.DomainSeparationTag_SealRandomness, tc.epoch, entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := tc.get(ctx, crypto.DomainSeparationTag_SealRandomness, tc.epoch, entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("epoch %d: expected randomness drawn from %s", tc.epoch, tc.base)
+		}
+	}
+
+	// epochs without entries fall back.
+	fixed, _ := NewFixedRand().GetChainRandomness(ctx, crypto.DomainSeparationTag_SealRandomness, 91, entropy)
+	if actual, _ := r.GetChainRandomness(ctx, crypto.DomainSeparationTag_SealRandomness, 91, entropy); !bytes.Equal(fixed, actual) {
+		t.Error("expected fallback randomness for epoch without entry")
+	}
+
+	if _, err := LookbackEntries(&schema.Metadata{Gen: []schema.GenerationData{{Source: "lookback:chain:x", Version: "header=" + blk.Cid().String()}}}); err == nil {
+		t.Error("expected error for invalid epoch")
+	}
+}
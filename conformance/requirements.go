@@ -0,0 +1,122 @@
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/build"
+)
+
+// SelectorMinLotusVersion, if it appears in a vector, is the minimum version
+// of Lotus executing the vector, e.g. "1.19.1": that of the build it was
+// generated with. Older builds may lack the fixes the vector depends on,
+// and fail it with confusing gas mismatches; they refuse it instead, unless
+// ExecuteOpts.LenientRequirements is set.
+const SelectorMinLotusVersion = "min_lotus_version"
+
+// SelectorActorsBundle, if it appears in a vector, is the CID of the manifest
+// of the built-in actors bundle the vector was generated with. Builds not
+// embedding the bundle refuse the vector, unless
+// ExecuteOpts.LenientRequirements is set.
+const SelectorActorsBundle = "actors_bundle"
+
+// ErrRequirementsUnmet is returned for vectors requiring a build other than
+// that executing them.
+var ErrRequirementsUnmet = errors.New("requirements unmet")
+
+// CheckRequirements returns an error wrapping ErrRequirementsUnmet if the
+// build doesn't meet the requirements the vector declares, if any.
+func CheckRequirements(vector *schema.TestVector) error {
+	var unmet []string
+	if v, ok := vector.Selector[SelectorMinLotusVersion]; ok {
+		older, err := versionOlder(build.BuildVersion, v)
+		if err != nil {
+			return fmt.Errorf("invalid %s selector: %w", SelectorMinLotusVersion, err)
+		}
+		if older {
+			unmet = append(unmet, fmt.Sprintf("lotus %s or later required, executed by %s", v, build.BuildVersion))
+		}
+	}
+	if v, ok := vector.Selector[SelectorActorsBundle]; ok {
+		c, err := cid.Decode(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s selector: %w", SelectorActorsBundle, err)
+		}
+		if !embedsBundle(c) {
+			unmet = append(unmet, fmt.Sprintf("built-in actors bundle %s required, not embedded in the build", c))
+		}
+	}
+	if len(unmet) > 0 {
+		return fmt.Errorf("%w: %s", ErrRequirementsUnmet, strings.Join(unmet, "; "))
+	}
+	return nil
+}
+
+// checkVectorRequirements checks the requirements of the vector, logging
+// unmet requirements rather than failing if lenient is set.
+func checkVectorRequirements(vector *schema.TestVector, lenient bool) error {
+	err := CheckRequirements(vector)
+	if err != nil && lenient && errors.Is(err, ErrRequirementsUnmet) {
+		log.Printf("warning: executing vector regardless: %s", err)
+		return nil
+	}
+	return err
+}
+
+// embedsBundle returns whether the build embeds the bundle of the manifest.
+func embedsBundle(manifest cid.Cid) bool {
+	for _, meta := range build.EmbeddedBuiltinActorsMetadata {
+		if meta.ManifestCid == manifest {
+			return true
+		}
+	}
+	return false
+}
+
+// versionOlder returns whether the version a precedes b, comparing their
+// major, minor and patch numbers; pre-release and build suffixes (e.g.
+// "-dev", "+mainnet") are ignored.
+func versionOlder(a, b string) (bool, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return false, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return false, err
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] < vb[i], nil
+		}
+	}
+	return false, nil
+}
+
+// parseVersion parses the major, minor and patch numbers of the version.
+func parseVersion(v string) ([3]int, error) {
+	var ret [3]int
+	s := strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > len(ret) {
+		return ret, fmt.Errorf("invalid version %q", v)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return ret, fmt.Errorf("invalid version %q", v)
+		}
+		ret[i] = n
+	}
+	return ret, nil
+}
@@ -0,0 +1,60 @@
+// stm: #unit
+package conformance
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/build"
+)
+
+func TestVersionOlder(t *testing.T) {
+	for _, tc := range []struct {
+		a, b  string
+		older bool
+	}{
+		{"1.19.1-dev", "1.19.1", false},
+		{"1.19.0", "1.19.1", true},
+		{"1.19.1", "v1.20", true},
+		{"1.20.0+mainnet+git.abc", "1.19.9", false},
+		{"2.0.0", "1.99.99", false},
+	} {
+		older, err := versionOlder(tc.a, tc.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if older != tc.older {
+			t.Errorf("%s older than %s: expected %t", tc.a, tc.b, tc.older)
+		}
+	}
+	if _, err := versionOlder("1.19.1", "latest"); err == nil {
+		t.Error("expected an invalid version to be rejected")
+	}
+}
+
+func TestCheckRequirements(t *testing.T) {
+	met := &schema.TestVector{Selector: schema.Selector{SelectorMinLotusVersion: build.BuildVersion}}
+	if len(build.EmbeddedBuiltinActorsMetadata) > 0 {
+		met.Selector[SelectorActorsBundle] = build.EmbeddedBuiltinActorsMetadata[0].ManifestCid.String()
+	}
+	if err := CheckRequirements(met); err != nil {
+		t.Errorf("expected the requirements of the build to be met; got %s", err)
+	}
+	if err := CheckRequirements(&schema.TestVector{}); err != nil {
+		t.Errorf("expected a vector without requirements to run; got %s", err)
+	}
+
+	unmet := &schema.TestVector{Selector: schema.Selector{
+		SelectorMinLotusVersion: "999.0.0",
+		// the CID of an empty identity block, which is no manifest.
+		SelectorActorsBundle: "bafkqaaa",
+	}}
+	if err := checkVectorRequirements(unmet, false); !errors.Is(err, ErrRequirementsUnmet) {
+		t.Errorf("expected unmet requirements; got %v", err)
+	}
+	if err := checkVectorRequirements(unmet, true); err != nil {
+		t.Errorf("expected unmet requirements to be downgraded to a warning; got %s", err)
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"encoding/base64"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/fatih/color"
 	"github.com/hashicorp/go-multierror"
@@ -23,6 +25,7 @@ import (
 	"github.com/ipfs/go-merkledag"
 	"github.com/ipld/go-car"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/exitcode"
 	"github.com/filecoin-project/go-state-types/network"
@@ -30,6 +33,7 @@ import (
 
 	"github.com/filecoin-project/lotus/blockstore"
 	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/store"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/chain/vm"
 )
@@ -42,6 +46,30 @@ var FallbackBlockstoreGetter interface {
 	ChainReadObj(context.Context, cid.Cid) ([]byte, error)
 }
 
+// SelectorImplicitMessages, if it appears in a message-class vector and its
+// value is literal "true", it indicates that the messages to apply are
+// implicit (system) messages, such as cron ticks or block reward awards, and
+// must be applied as such.
+const SelectorImplicitMessages = "implicit_messages"
+
+// SelectorRandomness, if it appears and its value is literal "true", it
+// indicates that the vector draws randomness during execution, which must be
+// replayed from the randomness recorded in the vector.
+const SelectorRandomness = "randomness"
+
+// HintIncorrectGas is a hint to convey that the gas used recorded in the
+// receipts of the vector is knowingly incorrect, e.g. because it couldn't be
+// reproduced when the vector was extracted. Drivers should not assert gas used,
+// but should still assert exit codes, return values and state roots.
+const HintIncorrectGas = "incorrect-gas"
+
+// ClassMigration is the class of vectors that exercise the state migration
+// of a network upgrade. These vectors carry no messages; the preconditions
+// hold the state produced by the upgrade epoch, the single variant carries the
+// upgrade epoch and the network version being upgraded into, and the
+// postconditions hold the migrated state.
+const ClassMigration schema.Class = "migration"
+
 var TipsetVectorOpts struct {
 	// PipelineBaseFee pipelines the basefee in multi-tipset vectors from one
 	// tipset to another. Basefees in the vector are ignored, except for that of
@@ -99,88 +127,390 @@ func adjustGasPricing(vectorEpoch abi.ChainEpoch, vectorNv network.Version) GasP
 	}
 }
 
-// ExecuteMessageVector executes a message-class test vector.
-func ExecuteMessageVector(r Reporter, vector *schema.TestVector, variant *schema.Variant) (diffs []string, err error) {
+// ExecuteOpts are the options vectors are executed with by ExecuteVariant,
+// the Execute* and Compute* functions, and Runner.
+type ExecuteOpts struct {
+	// DriverOpts are the options of the drivers the vectors are executed
+	// with, but for DisableVMFlush, which depends on the class of the
+	// vector; see newVectorDriver.
+	DriverOpts
+
+	// Assert are the options receipts are asserted with; see assertOptsFor.
+	Assert AssertOpts
+
+	// Network, if not empty, is the name of the network the runner is
+	// configured for: vectors declaring another network through
+	// SelectorNetwork fail with ErrNetworkMismatch. Vectors declaring no
+	// network run regardless.
+	Network string
+
+	// LenientRequirements downgrades the requirements of vectors on the
+	// build (see SelectorMinLotusVersion and SelectorActorsBundle) to
+	// warnings: vectors with unmet requirements execute regardless, rather
+	// than failing with ErrRequirementsUnmet.
+	LenientRequirements bool
+
+	// FetchProofParams, if set, has the proof parameters vectors require
+	// (see SelectorProofParams) fetched into ProofParamsDir when missing,
+	// before they execute; see EnsureProofParams.
+	FetchProofParams bool
+
+	// ReuseBlockstores, if true, reuses the blockstore loaded from a vector
+	// CAR in later executions of the same vector, instead of loading a fresh
+	// one every time. Blocks written during previous executions remain in the
+	// blockstore. This is used for warm benchmarks; see
+	// ResetReusedBlockstores.
+	ReuseBlockstores bool
+
+	// SpillBlockstores, if not nil, backs the execution of tipset-class,
+	// blockseq-class and migration-class vectors with a temporary badger
+	// blockstore on disk, rather than an in-memory one; see SpillOpts.
+	SpillBlockstores *SpillOpts
+
+	// SemanticStateDiffs, if true, reports a wrong post state root with the
+	// semantic diff of the expected and actual state trees, computed by
+	// DiffStateTrees, instead of the 3-way diffs of the external statediff
+	// tool.
+	SemanticStateDiffs bool
+
+	// OnPostState, if not nil, is called with the post-state of every
+	// variant executed: the blockstore, and the post state root resulting
+	// from the execution, before it's asserted. The blockstore may be
+	// released after the call returns.
+	OnPostState func(vector *schema.TestVector, variant *schema.Variant, bs blockstore.Blockstore, root cid.Cid)
+}
+
+// newVectorDriver creates the driver the vector is executed with. Vectors
+// applying messages one by one on top of their pre-state, those of the
+// message and consensus fault classes, are executed without flushing the
+// VM, as the state tree holds their post-state; the others are executed
+// with VM flushing.
+func newVectorDriver(ctx context.Context, vector *schema.TestVector, opts ExecuteOpts) *Driver {
+	dopts := opts.DriverOpts
+	switch vector.Class {
+	case schema.ClassMessage, ClassConsensusFault:
+		dopts.DisableVMFlush = true
+	default:
+		dopts.DisableVMFlush = false
+	}
+	return NewDriver(ctx, vector.Selector, dopts)
+}
+
+// ExecuteMessageVector executes a message-class test vector, with the
+// supplied options.
+func ExecuteMessageVector(ctx context.Context, r Reporter, vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) (diffs []string, err error) {
+	root := vector.Pre.StateTree.RootCID
+
+	// Validate the network and requirements of the vector, and format
+	// addresses as it does.
+	restoreNetwork, err := useVectorNetwork(ctx, vector, opts)
+	if err != nil {
+		r.Fatalf("%s", err)
+		return nil, err
+	}
+	defer restoreNetwork()
+
+	// Load the CAR into a new temporary Blockstore.
+	bs, _, err := loadBlockstore(vector.CAR, opts.ReuseBlockstores)
+	if err != nil {
+		r.Fatalf("failed to load the vector CAR: %w", err)
+	}
+
+	// Use the built-in actors bundle the vector state was created with.
+	restoreBundle, err := useVectorBundle(bs, root)
+	if err != nil {
+		r.Fatalf("failed to select the built-in actors bundle: %s", err)
+	}
+	defer restoreBundle()
+
+	// Replay the recorded randomness, or derive it from the beacon entries and
+	// lookback headers.
+	rand, err := newVectorRand(r, bs, vector)
+	if err != nil {
+		r.Fatalf("failed to load the beacon entries and lookback headers: %s", err)
+	}
+
+	// Load the expected call trees, if the vector asserts them.
+	traces, err := loadVectorTraces(ctx, bs, vector)
+	if err != nil {
+		r.Fatalf("failed to load the expected traces: %s", err)
+	}
+
+	// Apply every message, advancing the epoch by the offsets set.
+	res, err := applyMessageVector(ctx, bs, vector, variant, rand, opts)
+	if err != nil {
+		r.Fatalf("fatal failure when executing message: %s", err)
+	}
+	root = res.Root
+
+	// Assert that the receipts match what the test vector expects; the
+	// receivers are resolved in the post-state, which holds those created by
+	// the messages too.
+	aopts := assertOptsFor(vector, opts)
+	aopts.ResolveCode = stateCodeResolver(bs, root)
+	for i, ret := range res.Rets {
+		aopts.ExpectedTrace = traces.traceAt(i)
+		AssertMsgResultWithOpts(r, vector.Post.Receipts[i], ret, strconv.Itoa(i), aopts)
+	}
+
+	// Assert the split of the gas fees, if the vector expects one.
+	outcomes, gerr := loadGasOutcomes(ctx, bs, vector)
+	if gerr != nil {
+		r.Errorf(gerr.Error())
+		err = multierror.Append(err, gerr)
+	} else if gerr = AssertGasOutcomes(r, outcomes, res.Rets); gerr != nil {
+		err = multierror.Append(err, gerr)
+	}
+
+	// Assert the post-state: the actor postconditions, and the post state root.
+	diffs, perr := assertPostState(ctx, r, vector, variant, bs, root, opts)
+	if perr != nil {
+		err = multierror.Append(err, perr)
+	}
+	return diffs, err
+}
+
+// applyMessageVector applies the messages of the message vector variant to
+// its pre-state, held in bs, through a driver with the supplied options.
+func applyMessageVector(ctx context.Context, bs blockstore.Blockstore, vector *schema.TestVector, variant *schema.Variant, rand vm.Rand, opts ExecuteOpts) (*ExecuteSequenceResult, error) {
 	var (
-		ctx       = context.Background()
 		baseEpoch = abi.ChainEpoch(variant.Epoch)
 		nv        = network.Version(variant.NetworkVersion)
-		root      = vector.Pre.StateTree.RootCID
 	)
 
-	// Load the CAR into a new temporary Blockstore.
-	bs, err := LoadBlockstore(vector.CAR)
-	if err != nil {
-		r.Fatalf("failed to load the vector CAR: %w", err)
+	// The cron ticks of the null rounds the vector declares are in its
+	// pre-state; declaring them inconsistently with its epoch is an error.
+	if _, err := NullRounds(vector, variant); err != nil {
+		return nil, err
 	}
 
-	// Create a new Driver.
-	driver := NewDriver(ctx, vector.Selector, DriverOpts{DisableVMFlush: true})
+	driver := newVectorDriver(ctx, vector, opts)
 
 	// Monkey patch the gas pricing.
 	revertFn := adjustGasPricing(baseEpoch, nv)
 	defer revertFn()
 
-	// Apply every message.
+	// Apply every message, advancing the epoch by the offsets set.
+	steps := make([]SequenceStep, len(vector.ApplyMessages))
 	for i, m := range vector.ApplyMessages {
-		msg, err := types.DecodeMessage(m.Bytes)
+		msg, sig, err := DecodeVectorMessage(m.Bytes)
 		if err != nil {
-			r.Fatalf("failed to deserialize message: %s", err)
+			return nil, fmt.Errorf("failed to deserialize message %d: %w", i, err)
 		}
-
-		// add the epoch offset if one is set.
+		steps[i].Message, steps[i].Signature = msg, sig
 		if m.EpochOffset != nil {
-			baseEpoch += abi.ChainEpoch(*m.EpochOffset)
+			steps[i].Advance = abi.ChainEpoch(*m.EpochOffset)
 		}
+	}
+	return driver.ExecuteSequence(bs, ExecuteSequenceParams{
+		Preroot:        vector.Pre.StateTree.RootCID,
+		BaseEpoch:      baseEpoch,
+		Steps:          steps,
+		BaseFee:        BaseFeeOrDefault(vector.Pre.BaseFee),
+		CircSupply:     CircSupplyOrDefault(vector.Pre.CircSupply),
+		Rand:           rand,
+		NetworkVersion: nv,
+		Cron:           vector.Selector[SelectorEpochCron] == "true",
+		Implicit:       vector.Selector[SelectorImplicitMessages] == "true",
+	})
+}
 
-		// Execute the message.
-		var ret *vm.ApplyRet
-		ret, root, err = driver.ExecuteMessage(bs, ExecuteMessageParams{
+// ComputeMessageVectorPostconditions executes the messages of the message
+// vector variant as ExecuteMessageVector does, and returns the postconditions
+// they result in: their receipts, and the post state root. The returned
+// blockstore holds both the pre- and the post-state. It lets tools author
+// vectors whose postconditions are computed by the driver. Of the options,
+// those of the execution, and the driver hooks, debug bundles and custom
+// actors apply; the limits, message overrides and signature verification
+// don't.
+func ComputeMessageVectorPostconditions(vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) (*schema.Postconditions, blockstore.Blockstore, error) {
+	post, _, bs, err := ComputeMessageVectorOutcomes(vector, variant, opts)
+	return post, bs, err
+}
+
+// ComputeMessageVectorOutcomes is like ComputeMessageVectorPostconditions,
+// but also returns the gas outcomes of the messages, for vectors to assert
+// through SelectorExpectedGasOutcomes; nil for those without gas outputs.
+func ComputeMessageVectorOutcomes(vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) (*schema.Postconditions, []*GasOutcome, blockstore.Blockstore, error) {
+	ctx := context.Background()
+
+	restoreNetwork, err := useVectorNetwork(ctx, vector, opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer restoreNetwork()
+
+	bs, _, err := loadBlockstore(vector.CAR, opts.ReuseBlockstores)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load the vector CAR: %w", err)
+	}
+	restoreBundle, err := useVectorBundle(bs, vector.Pre.StateTree.RootCID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to select the built-in actors bundle: %w", err)
+	}
+	defer restoreBundle()
+
+	rand, err := newVectorRand(new(LogReporter), bs, vector)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load the beacon entries and lookback headers: %w", err)
+	}
+	res, err := applyMessageVector(ctx, bs, vector, variant, rand, computeOpts(opts))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	post := &schema.Postconditions{
+		StateTree: &schema.StateTree{RootCID: res.Root},
+		Receipts:  make([]*schema.Receipt, len(res.Rets)),
+	}
+	outcomes := make([]*GasOutcome, len(res.Rets))
+	for i, ret := range res.Rets {
+		post.Receipts[i] = &schema.Receipt{
+			ExitCode:    int64(ret.ExitCode),
+			ReturnValue: ret.Return,
+			GasUsed:     ret.GasUsed,
+		}
+		if ret.GasCosts != nil {
+			o := NewGasOutcome(ret.GasCosts)
+			outcomes[i] = &o
+		}
+	}
+	return post, outcomes, bs, nil
+}
+
+// computeOpts returns the options of the executions of the Compute*
+// functions: those supplied, but for the limits, message overrides and
+// signature verification, which only apply to the executions asserting
+// vectors.
+func computeOpts(opts ExecuteOpts) ExecuteOpts {
+	opts.Limits, opts.MessageOverrides, opts.VerifySignatures = nil, nil, false
+	return opts
+}
+
+// ComputeTipsetVectorPostconditions executes the tipsets of the tipset vector
+// variant as ExecuteTipsetVector does, and returns the postconditions they
+// result in: the receipts of their messages, the receipts roots of the
+// tipsets, and the post state root. The options apply as they do to
+// ComputeMessageVectorPostconditions.
+func ComputeTipsetVectorPostconditions(vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) (*schema.Postconditions, error) {
+	var (
+		ctx       = context.Background()
+		baseEpoch = abi.ChainEpoch(variant.Epoch)
+		nv        = network.Version(variant.NetworkVersion)
+		root      = vector.Pre.StateTree.RootCID
+		tmpds     = ds.NewMapDatastore()
+	)
+
+	restoreNetwork, err := useVectorNetwork(ctx, vector, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer restoreNetwork()
+
+	bs, release, err := loadExecutionBlockstore(vector.CAR, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the vector CAR: %w", err)
+	}
+	defer release()
+
+	restore, err := useVectorVersions(bs, root, baseEpoch, nv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select the built-in actors bundle: %w", err)
+	}
+	defer restore()
+
+	rand, err := newVectorRand(new(LogReporter), bs, vector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the beacon entries and lookback headers: %w", err)
+	}
+
+	driver := newVectorDriver(ctx, vector, computeOpts(opts))
+
+	post := new(schema.Postconditions)
+	prevEpoch := baseEpoch
+	for i, ts := range vector.ApplyTipsets {
+		ts := ts // capture
+		execEpoch := baseEpoch + abi.ChainEpoch(ts.EpochOffset)
+		ret, err := driver.ExecuteTipset(bs, tmpds, ExecuteTipsetParams{
 			Preroot:        root,
-			Epoch:          baseEpoch,
-			Message:        msg,
-			BaseFee:        BaseFeeOrDefault(vector.Pre.BaseFee),
-			CircSupply:     CircSupplyOrDefault(vector.Pre.CircSupply),
-			Rand:           NewReplayingRand(r, vector.Randomness),
+			ParentEpoch:    prevEpoch,
+			Tipset:         &ts,
+			ExecEpoch:      execEpoch,
+			Rand:           rand,
 			NetworkVersion: nv,
 		})
 		if err != nil {
-			r.Fatalf("fatal failure when executing message: %s", err)
+			return nil, fmt.Errorf("failed to apply tipset %d: %w", i, err)
 		}
+		for _, ret := range ret.AppliedResults {
+			post.Receipts = append(post.Receipts, &schema.Receipt{
+				ExitCode:    int64(ret.ExitCode),
+				ReturnValue: ret.Return,
+				GasUsed:     ret.GasUsed,
+			})
+		}
+		post.ReceiptsRoots = append(post.ReceiptsRoots, ret.ReceiptsRoot)
 
-		// Assert that the receipt matches what the test vector expects.
-		AssertMsgResult(r, vector.Post.Receipts[i], ret, strconv.Itoa(i))
-	}
-
-	// Once all messages are applied, assert that the final state root matches
-	// the expected postcondition root.
-	if expected, actual := vector.Post.StateTree.RootCID, root; expected != actual {
-		ierr := fmt.Errorf("wrong post root cid; expected %v, but got %v", expected, actual)
-		r.Errorf(ierr.Error())
-		err = multierror.Append(err, ierr)
-		diffs = dumpThreeWayStateDiff(r, vector, bs, root)
+		prevEpoch = execEpoch
+		root = ret.PostStateRoot
 	}
-	return diffs, err
+	post.StateTree = &schema.StateTree{RootCID: root}
+	return post, nil
 }
 
-// ExecuteTipsetVector executes a tipset-class test vector.
-func ExecuteTipsetVector(r Reporter, vector *schema.TestVector, variant *schema.Variant) (diffs []string, err error) {
+// ExecuteTipsetVector executes a tipset-class test vector, with the supplied
+// options.
+func ExecuteTipsetVector(ctx context.Context, r Reporter, vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) (diffs []string, err error) {
 	var (
-		ctx       = context.Background()
 		baseEpoch = abi.ChainEpoch(variant.Epoch)
+		nv        = network.Version(variant.NetworkVersion)
 		root      = vector.Pre.StateTree.RootCID
 		tmpds     = ds.NewMapDatastore()
 	)
 
+	// Validate the network and requirements of the vector, and format
+	// addresses as it does.
+	restoreNetwork, err := useVectorNetwork(ctx, vector, opts)
+	if err != nil {
+		r.Fatalf("%s", err)
+		return nil, err
+	}
+	defer restoreNetwork()
+
 	// Load the vector CAR into a new temporary Blockstore.
-	bs, err := LoadBlockstore(vector.CAR)
+	bs, release, err := loadExecutionBlockstore(vector.CAR, opts)
 	if err != nil {
 		r.Fatalf("failed to load the vector CAR: %w", err)
 		return nil, err
 	}
+	defer release()
+
+	// Use the built-in actors bundle the vector state was created with, and
+	// the gas pricing of its network version.
+	restore, err := useVectorVersions(bs, root, baseEpoch, nv)
+	if err != nil {
+		r.Fatalf("failed to select the built-in actors bundle: %s", err)
+		return nil, err
+	}
+	defer restore()
+
+	// Replay the recorded randomness, or derive it from the beacon entries and
+	// lookback headers.
+	rand, err := newVectorRand(r, bs, vector)
+	if err != nil {
+		r.Fatalf("failed to load the beacon entries and lookback headers: %s", err)
+		return nil, err
+	}
 
 	// Create a new Driver.
-	driver := NewDriver(ctx, vector.Selector, DriverOpts{})
+	// Load the expected call trees, if the vector asserts them.
+	traces, err := loadVectorTraces(ctx, bs, vector)
+	if err != nil {
+		r.Fatalf("failed to load the expected traces: %s", err)
+		return nil, err
+	}
+
+	driver := newVectorDriver(ctx, vector, opts)
 
 	// Apply every tipset.
 	var receiptsIdx int
@@ -189,11 +519,12 @@ func ExecuteTipsetVector(r Reporter, vector *schema.TestVector, variant *schema.
 		ts := ts // capture
 		execEpoch := baseEpoch + abi.ChainEpoch(ts.EpochOffset)
 		params := ExecuteTipsetParams{
-			Preroot:     root,
-			ParentEpoch: prevEpoch,
-			Tipset:      &ts,
-			ExecEpoch:   execEpoch,
-			Rand:        NewReplayingRand(r, vector.Randomness),
+			Preroot:        root,
+			ParentEpoch:    prevEpoch,
+			Tipset:         &ts,
+			ExecEpoch:      execEpoch,
+			Rand:           rand,
+			NetworkVersion: nv,
 		}
 		ret, err := driver.ExecuteTipset(bs, tmpds, params)
 		if err != nil {
@@ -206,8 +537,11 @@ func ExecuteTipsetVector(r Reporter, vector *schema.TestVector, variant *schema.
 			cb(bs, &params, ret)
 		}
 
+		aopts := assertOptsFor(vector, opts)
+		aopts.ResolveCode = stateCodeResolver(bs, ret.PostStateRoot)
 		for j, v := range ret.AppliedResults {
-			AssertMsgResult(r, vector.Post.Receipts[receiptsIdx], v, fmt.Sprintf("%d of tipset %d", j, i))
+			aopts.ExpectedTrace = traces.traceAt(receiptsIdx)
+			AssertMsgResultWithOpts(r, vector.Post.Receipts[receiptsIdx], v, fmt.Sprintf("%d of tipset %d", j, i), aopts)
 			receiptsIdx++
 		}
 
@@ -222,34 +556,490 @@ func ExecuteTipsetVector(r Reporter, vector *schema.TestVector, variant *schema.
 		root = ret.PostStateRoot
 	}
 
-	// Once all messages are applied, assert that the final state root matches
-	// the expected postcondition root.
-	if expected, actual := vector.Post.StateTree.RootCID, root; expected != actual {
-		ierr := fmt.Errorf("wrong post root cid; expected %v, but got %v", expected, actual)
+	// Assert the post-state: the actor postconditions, and the post state root.
+	diffs, perr := assertPostState(ctx, r, vector, variant, bs, root, opts)
+	if perr != nil {
+		err = multierror.Append(err, perr)
+	}
+	return diffs, err
+}
+
+// ExecuteBlockSeqVector executes a blockseq-class test vector.
+//
+// Blockseq vectors are tipset vectors that additionally carry the full block
+// headers of every tipset in the sequence, as well as those of the tipset
+// that follows it, as roots of the CAR. Besides applying the tipsets, the
+// runner validates the chain they form (winner election, tickets, beacon
+// entries and signatures excluded), and checkpoints the state root and
+// receipts root produced by each tipset against the parent state root and
+// parent receipts root committed to by the headers of the next one.
+func ExecuteBlockSeqVector(ctx context.Context, r Reporter, vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) (diffs []string, err error) {
+	var (
+		baseEpoch = abi.ChainEpoch(variant.Epoch)
+		nv        = network.Version(variant.NetworkVersion)
+		root      = vector.Pre.StateTree.RootCID
+		tmpds     = ds.NewMapDatastore()
+	)
+
+	if err := checkBlockSeqVector(vector); err != nil {
+		r.Fatalf("malformed blockseq vector: %s", err)
+		return nil, err
+	}
+
+	// Validate the network and requirements of the vector, and format
+	// addresses as it does.
+	restoreNetwork, err := useVectorNetwork(ctx, vector, opts)
+	if err != nil {
+		r.Fatalf("%s", err)
+		return nil, err
+	}
+	defer restoreNetwork()
+
+	// Load the vector CAR into a new temporary Blockstore.
+	bs, carRoots, err := loadBlockstore(vector.CAR, opts.ReuseBlockstores)
+	if err != nil {
+		r.Fatalf("failed to load the vector CAR: %w", err)
+		return nil, err
+	}
+
+	// Use the built-in actors bundle the vector state was created with, and
+	// the gas pricing of its network version.
+	restore, err := useVectorVersions(bs, root, baseEpoch, nv)
+	if err != nil {
+		r.Fatalf("failed to select the built-in actors bundle: %s", err)
+		return nil, err
+	}
+	defer restore()
+
+	tipsets, err := loadTipsets(bs, carRoots)
+	if err != nil {
+		r.Fatalf("failed to load block headers: %s", err)
+		return nil, err
+	}
+
+	// we expect one tipset per applied tipset, plus the one that follows.
+	if expected, actual := len(vector.ApplyTipsets)+1, len(tipsets); expected != actual {
+		r.Fatalf("expected headers for %d tipsets, got %d", expected, actual)
+		return nil, fmt.Errorf("wrong tipset count")
+	}
+
+	cs := store.NewChainStore(bs, bs, tmpds, filcns.Weight, nil)
+	defer cs.Close() //nolint:errcheck
+
+	// Validate the chain formed by the headers.
+	for i, ts := range vector.ApplyTipsets {
+		var parent *types.TipSet
+		if i > 0 {
+			parent = tipsets[i-1]
+		}
+		epoch := baseEpoch + abi.ChainEpoch(ts.EpochOffset)
+		if verr := validateTipset(ctx, cs, tipsets[i], parent, &ts, epoch); verr != nil {
+			ierr := fmt.Errorf("invalid tipset %d: %w", i, verr)
+			r.Errorf(ierr.Error())
+			err = multierror.Append(err, ierr)
+		}
+	}
+	if last := tipsets[len(tipsets)-1]; last.Parents() != tipsets[len(tipsets)-2].Key() {
+		ierr := fmt.Errorf("tipset following the sequence doesn't link to the last tipset")
 		r.Errorf(ierr.Error())
 		err = multierror.Append(err, ierr)
-		diffs = dumpThreeWayStateDiff(r, vector, bs, root)
+	}
+	if expected, actual := root, tipsets[0].ParentState(); expected != actual {
+		ierr := fmt.Errorf("first tipset has wrong parent state root; expected %s, got %s", expected, actual)
+		r.Errorf(ierr.Error())
+		err = multierror.Append(err, ierr)
+	}
+
+	// Replay the recorded randomness, or derive it from the beacon entries and
+	// lookback headers.
+	rand, rerr := newVectorRand(r, bs, vector)
+	if rerr != nil {
+		r.Fatalf("failed to load the beacon entries and lookback headers: %s", rerr)
+		return nil, rerr
+	}
+
+	// Create a new Driver.
+	// Load the expected call trees, if the vector asserts them.
+	traces, terr := loadVectorTraces(ctx, bs, vector)
+	if terr != nil {
+		r.Fatalf("failed to load the expected traces: %s", terr)
+		return nil, terr
+	}
+
+	driver := newVectorDriver(ctx, vector, opts)
+
+	var receiptsIdx int
+	checkpoint := func(i int, params *ExecuteTipsetParams, res *ExecuteTipsetResult) error {
+		// invoke callbacks.
+		for _, cb := range TipsetVectorOpts.OnTipsetApplied {
+			cb(bs, params, res)
+		}
+
+		if expected, actual := receiptsIdx+len(res.AppliedResults), len(vector.Post.Receipts); expected > actual {
+			return fmt.Errorf("tipset %d applied messages up to receipt %d, but the vector has %d receipts", i, expected, actual)
+		}
+
+		aopts := assertOptsFor(vector, opts)
+		aopts.ResolveCode = stateCodeResolver(bs, res.PostStateRoot)
+		for j, v := range res.AppliedResults {
+			aopts.ExpectedTrace = traces.traceAt(receiptsIdx)
+			AssertMsgResultWithOpts(r, vector.Post.Receipts[receiptsIdx], v, fmt.Sprintf("%d of tipset %d", j, i), aopts)
+			receiptsIdx++
+		}
+
+		// Compare the receipts root.
+		if expected, actual := vector.Post.ReceiptsRoots[i], res.ReceiptsRoot; expected != actual {
+			ierr := fmt.Errorf("post receipts root doesn't match; expected: %s, was: %s", expected, actual)
+			r.Errorf(ierr.Error())
+			err = multierror.Append(err, ierr)
+		}
+
+		// Checkpoint against the headers of the next tipset.
+		next := tipsets[i+1]
+		if expected, actual := next.ParentState(), res.PostStateRoot; expected != actual {
+			ierr := fmt.Errorf("state root checkpoint after tipset %d failed; expected: %s, was: %s", i, expected, actual)
+			r.Errorf(ierr.Error())
+			err = multierror.Append(err, ierr)
+		}
+		if expected, actual := next.Blocks()[0].ParentMessageReceipts, res.ReceiptsRoot; expected != actual {
+			ierr := fmt.Errorf("receipts root checkpoint after tipset %d failed; expected: %s, was: %s", i, expected, actual)
+			r.Errorf(ierr.Error())
+			err = multierror.Append(err, ierr)
+		}
+		return nil
+	}
+
+	results, xerr := driver.ExecuteTipsets(bs, tmpds, ExecuteTipsetsParams{
+		Preroot:        root,
+		ParentEpoch:    baseEpoch,
+		BaseEpoch:      baseEpoch,
+		Tipsets:        vector.ApplyTipsets,
+		Rand:           rand,
+		NetworkVersion: nv,
+		Checkpoint:     checkpoint,
+	})
+	if xerr != nil {
+		r.Fatalf("failed to apply tipsets: %s", xerr)
+		return nil, xerr
+	}
+	if len(results) > 0 {
+		root = results[len(results)-1].PostStateRoot
+	}
+
+	// Assert the post-state: the actor postconditions, and the post state root.
+	diffs, perr := assertPostState(ctx, r, vector, variant, bs, root, opts)
+	if perr != nil {
+		err = multierror.Append(err, perr)
+	}
+	return diffs, err
+}
+
+// checkBlockSeqVector checks that the blockseq vector applies tipsets, and
+// declares the receipts root each of them produces, so that executing it
+// doesn't index past its tipsets or postconditions.
+func checkBlockSeqVector(vector *schema.TestVector) error {
+	if len(vector.ApplyTipsets) == 0 {
+		return fmt.Errorf("no tipsets to apply")
+	}
+	if vector.Post == nil {
+		return fmt.Errorf("no postconditions")
+	}
+	if expected, actual := len(vector.ApplyTipsets), len(vector.Post.ReceiptsRoots); expected != actual {
+		return fmt.Errorf("expected %d receipts roots, one per applied tipset, got %d", expected, actual)
+	}
+	return nil
+}
+
+// loadTipsets decodes the block headers among the supplied CIDs, and
+// assembles them into tipsets, sorted by height. CIDs of other objects are
+// skipped.
+func loadTipsets(bs blockstore.Blockstore, cids []cid.Cid) ([]*types.TipSet, error) {
+	byHeight := make(map[abi.ChainEpoch][]*types.BlockHeader)
+	for _, c := range cids {
+		blk, err := bs.Get(context.Background(), c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get root %s: %w", c, err)
+		}
+		hdr, err := types.DecodeBlock(blk.RawData())
+		if err != nil {
+			continue // not a block header.
+		}
+		byHeight[hdr.Height] = append(byHeight[hdr.Height], hdr)
+	}
+
+	tipsets := make([]*types.TipSet, 0, len(byHeight))
+	for h, hdrs := range byHeight {
+		ts, err := types.NewTipSet(hdrs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tipset at height %d: %w", h, err)
+		}
+		tipsets = append(tipsets, ts)
+	}
+	sort.Slice(tipsets, func(i, j int) bool {
+		return tipsets[i].Height() < tipsets[j].Height()
+	})
+	return tipsets, nil
+}
+
+// validateTipset checks that the headers of a tipset are consistent with its
+// parent and with the tipset as encoded in the vector: the height, the miners,
+// the win counts, the basefee, and the messages committed to by each block.
+func validateTipset(ctx context.Context, cs *store.ChainStore, ts *types.TipSet, parent *types.TipSet, expected *schema.Tipset, epoch abi.ChainEpoch) error {
+	if ts.Height() != epoch {
+		return fmt.Errorf("wrong height; expected %d, got %d", epoch, ts.Height())
+	}
+	if parent != nil {
+		if ts.Parents() != parent.Key() {
+			return fmt.Errorf("wrong parents; expected %s, got %s", parent.Key(), ts.Parents())
+		}
+		if ts.Blocks()[0].ParentWeight.LessThan(parent.ParentWeight()) {
+			return fmt.Errorf("parent weight decreased")
+		}
+	}
+	if len(ts.Blocks()) != len(expected.Blocks) {
+		return fmt.Errorf("wrong block count; expected %d, got %d", len(expected.Blocks), len(ts.Blocks()))
+	}
+	if bf := ts.Blocks()[0].ParentBaseFee; !bf.Equals(abi.TokenAmount{Int: &expected.BaseFee}) {
+		return fmt.Errorf("wrong basefee; expected %s, got %s", expected.BaseFee.String(), bf)
+	}
+
+	for i, b := range ts.Blocks() {
+		eb := expected.Blocks[i]
+		if b.Miner != eb.MinerAddr {
+			return fmt.Errorf("block %d: wrong miner; expected %s, got %s", i, eb.MinerAddr, b.Miner)
+		}
+		if b.ElectionProof == nil || b.ElectionProof.WinCount != eb.WinCount {
+			return fmt.Errorf("block %d: wrong win count", i)
+		}
+
+		bmsgs, smsgs, err := cs.MessagesForBlock(ctx, b)
+		if err != nil {
+			return fmt.Errorf("block %d: failed to load messages: %w", i, err)
+		}
+		actual := make([]cid.Cid, 0, len(bmsgs)+len(smsgs))
+		for _, m := range bmsgs {
+			actual = append(actual, m.Cid())
+		}
+		for _, m := range smsgs {
+			actual = append(actual, m.Message.Cid())
+		}
+		if len(actual) != len(eb.Messages) {
+			return fmt.Errorf("block %d: wrong message count; expected %d, got %d", i, len(eb.Messages), len(actual))
+		}
+		for j, raw := range eb.Messages {
+			msg, _, err := DecodeVectorMessage(raw)
+			if err != nil {
+				return fmt.Errorf("block %d: failed to decode message %d: %w", i, j, err)
+			}
+			if msg.Cid() != actual[j] {
+				return fmt.Errorf("block %d: message %d doesn't match; expected %s, got %s", i, j, msg.Cid(), actual[j])
+			}
+		}
+	}
+	return nil
+}
+
+// ExecuteMigrationVector executes a migration-class test vector, with the
+// supplied options.
+func ExecuteMigrationVector(ctx context.Context, r Reporter, vector *schema.TestVector, variant *schema.Variant, opts ExecuteOpts) (diffs []string, err error) {
+	tmpds := ds.NewMapDatastore()
+
+	// Validate the network and requirements of the vector, and format
+	// addresses as it does.
+	restoreNetwork, err := useVectorNetwork(ctx, vector, opts)
+	if err != nil {
+		r.Fatalf("%s", err)
+		return nil, err
+	}
+	defer restoreNetwork()
+
+	// Load the vector CAR into a new temporary Blockstore.
+	bs, release, err := loadExecutionBlockstore(vector.CAR, opts)
+	if err != nil {
+		r.Fatalf("failed to load the vector CAR: %w", err)
+		return nil, err
+	}
+	defer release()
+
+	// Use the built-in actors bundle the vector state was created with, which
+	// also determines the bundle migrated into.
+	restoreBundle, err := useVectorBundle(bs, vector.Pre.StateTree.RootCID)
+	if err != nil {
+		r.Fatalf("failed to select the built-in actors bundle: %s", err)
+		return nil, err
+	}
+	defer restoreBundle()
+
+	// Create a new Driver.
+	driver := newVectorDriver(ctx, vector, opts)
+
+	root, err := driver.ExecuteMigration(bs, tmpds, ExecuteMigrationParams{
+		Preroot:        vector.Pre.StateTree.RootCID,
+		Epoch:          abi.ChainEpoch(variant.Epoch),
+		NetworkVersion: network.Version(variant.NetworkVersion),
+	})
+	if err != nil {
+		r.Fatalf("failed to run migration: %s", err)
+		return nil, err
+	}
+
+	// Assert the post-state: the actor postconditions, and the post state root.
+	diffs, perr := assertPostState(ctx, r, vector, variant, bs, root, opts)
+	if perr != nil {
+		err = multierror.Append(err, perr)
 	}
 	return diffs, err
 }
 
+// AssertOpts controls the strictness of receipt assertions. The zero value
+// asserts exit code, gas used and return value strictly.
+type AssertOpts struct {
+	// ExitCodeOnly, if true, only compares exit codes.
+	ExitCodeOnly bool
+	// IgnoreGas, if true, skips the comparison of gas used.
+	IgnoreGas bool
+	// GasTolerance is the tolerated delta of gas used, as a percentage of the
+	// expected gas used.
+	GasTolerance float64
+	// IgnoreReturn, if true, skips the comparison of return values.
+	IgnoreReturn bool
+	// ResolveCode, if set, resolves the codes of the receivers of messages,
+	// to decode mismatching return values against the ABI of the actors in
+	// failure messages; they're rendered in hex otherwise.
+	ResolveCode func(addr address.Address) cid.Cid
+	// IgnoreTraces, if true, skips the comparison of call trees.
+	IgnoreTraces bool
+	// ExpectedTrace, if not nil, is the expected call tree of the message,
+	// compared with that of its execution trace; see SelectorExpectedTraces.
+	ExpectedTrace *CallTree
+	// OnMismatch, if set, is invoked with the mismatches of every message
+	// whose result didn't match the expected receipt, e.g. to triage the
+	// failures of a corpus run.
+	OnMismatch func(*ReceiptMismatch)
+}
+
+// ReceiptMismatch describes how the result of a message didn't match its
+// expected receipt. Tolerated and ignored differences aren't included.
+type ReceiptMismatch struct {
+	Label string
+	// Code is the code of the receiver of the message, if resolved, and
+	// Method the method invoked.
+	Code   cid.Cid
+	Method abi.MethodNum
+	// ExpectedExit and ActualExit are the exit codes, which differ if the
+	// exit code didn't match.
+	ExpectedExit exitcode.ExitCode
+	ActualExit   exitcode.ExitCode
+	// GasDelta is the actual gas used less the expected gas used, if they
+	// didn't match.
+	GasDelta int64
+	// ReturnDiffers is true if the return values didn't match.
+	ReturnDiffers bool
+	// TraceDiff is the first difference between the call trees, if they
+	// didn't match; see DiffCallTrees.
+	TraceDiff string
+}
+
+// assertOptsFor returns the receipt assertion options to use for a vector
+// executed with the options. Vectors hinted with HintIncorrectGas are
+// asserted with IgnoreGas regardless.
+func assertOptsFor(vector *schema.TestVector, opts ExecuteOpts) AssertOpts {
+	aopts := opts.Assert
+	if HasHint(vector, HintIncorrectGas) {
+		aopts.IgnoreGas = true
+	}
+	return aopts
+}
+
 // AssertMsgResult compares a message result. It takes the expected receipt
 // encoded in the vector, the actual receipt returned by Lotus, and a message
 // label to log in the assertion failure message to facilitate debugging.
 func AssertMsgResult(r Reporter, expected *schema.Receipt, actual *vm.ApplyRet, label string) {
 	r.Helper()
+	AssertMsgResultWithOpts(r, expected, actual, label, AssertOpts{})
+}
+
+// AssertMsgResultWithOpts is like AssertMsgResult, but compares with the
+// strictness specified by the supplied options.
+func AssertMsgResultWithOpts(r Reporter, expected *schema.Receipt, actual *vm.ApplyRet, label string, opts AssertOpts) {
+	r.Helper()
 
 	applyret := actual
+	receiver := func() (code cid.Cid, method abi.MethodNum) {
+		if msg := applyret.ExecutionTrace.Msg; msg != nil {
+			if opts.ResolveCode != nil {
+				code = opts.ResolveCode(msg.To)
+			}
+			method = msg.Method
+		}
+		return code, method
+	}
+
+	var mm *ReceiptMismatch
+	mismatch := func() *ReceiptMismatch {
+		if mm == nil {
+			mm = &ReceiptMismatch{
+				Label:        label,
+				ExpectedExit: exitcode.ExitCode(expected.ExitCode),
+				ActualExit:   applyret.ExitCode,
+			}
+			mm.Code, mm.Method = receiver()
+		}
+		return mm
+	}
+	if opts.OnMismatch != nil {
+		defer func() {
+			if mm != nil {
+				opts.OnMismatch(mm)
+			}
+		}()
+	}
+
 	if expected, actual := exitcode.ExitCode(expected.ExitCode), actual.ExitCode; expected != actual {
 		r.Errorf("exit code of msg %s did not match; expected: %s, got: %s", label, expected, actual)
 		r.Errorf("\t\\==> actor error: %s", applyret.ActorErr)
+		mismatch()
+	}
+	if opts.ExitCodeOnly {
+		return
+	}
+	if opts.ExpectedTrace != nil && !opts.IgnoreTraces {
+		actual := NormalizeTrace(&applyret.ExecutionTrace)
+		if d := DiffCallTrees(opts.ExpectedTrace, &actual); d != "" {
+			r.Errorf("call tree of msg %s did not match; %s", label, d)
+			mismatch().TraceDiff = d
+		}
 	}
 	if expected, actual := expected.GasUsed, actual.GasUsed; expected != actual {
-		r.Errorf("gas used of msg %s did not match; expected: %d, got: %d", label, expected, actual)
+		switch delta := math.Abs(float64(actual - expected)); {
+		case opts.IgnoreGas:
+			r.Logf("gas used of msg %s did not match; expected: %d, got: %d (ignored)", label, expected, actual)
+		case delta <= math.Abs(float64(expected))*opts.GasTolerance/100:
+			r.Logf("gas used of msg %s did not match; expected: %d, got: %d (within %.2f%% tolerance)", label, expected, actual, opts.GasTolerance)
+		default:
+			r.Errorf("gas used of msg %s did not match; expected: %d, got: %d", label, expected, actual)
+			mismatch().GasDelta = actual - expected
+		}
+	}
+	if opts.IgnoreReturn {
+		return
 	}
 	if expected, actual := []byte(expected.ReturnValue), actual.Return; !bytes.Equal(expected, actual) {
-		r.Errorf("return value of msg %s did not match; expected: %s, got: %s", label, base64.StdEncoding.EncodeToString(expected), base64.StdEncoding.EncodeToString(actual))
+		code, method := receiver()
+		r.Errorf("return value of msg %s did not match; expected: %s, got: %s", label,
+			FormatMethodValue(code, method, expected, false), FormatMethodValue(code, method, actual, false))
+		mismatch().ReturnDiffers = true
+	}
+}
+
+// HasHint returns whether the vector carries the supplied hint.
+func HasHint(vector *schema.TestVector, hint string) bool {
+	for _, h := range vector.Hints {
+		if h == hint {
+			return true
+		}
 	}
+	return false
 }
 
 func dumpThreeWayStateDiff(r Reporter, vector *schema.TestVector, bs blockstore.Blockstore, actual cid.Cid) []string {
@@ -363,20 +1153,76 @@ func writeStateToTempCAR(bs blockstore.Blockstore, roots ...cid.Cid) (string, er
 }
 
 func LoadBlockstore(vectorCAR schema.Base64EncodedBytes) (blockstore.Blockstore, error) {
-	bs := blockstore.Blockstore(blockstore.NewMemory())
+	bs, _, err := loadBlockstore(vectorCAR, false)
+	return bs, err
+}
+
+var reusedBlockstores struct {
+	lk  sync.Mutex
+	bss map[[32]byte]loadedBlockstore
+}
+
+type loadedBlockstore struct {
+	bs    blockstore.Blockstore
+	roots []cid.Cid
+}
+
+// loadBlockstore is like LoadBlockstore, but also returns the roots of the
+// CAR. If reuse is set, the blockstore is retained, and returned to later
+// loads of the same CAR; see ExecuteOpts.ReuseBlockstores.
+func loadBlockstore(vectorCAR schema.Base64EncodedBytes, reuse bool) (blockstore.Blockstore, []cid.Cid, error) {
+	if !reuse {
+		return loadFreshBlockstore(vectorCAR)
+	}
+
+	key := sha256.Sum256(vectorCAR)
+
+	reusedBlockstores.lk.Lock()
+	defer reusedBlockstores.lk.Unlock()
+
+	if l, ok := reusedBlockstores.bss[key]; ok {
+		return l.bs, l.roots, nil
+	}
+	bs, roots, err := loadFreshBlockstore(vectorCAR)
+	if err != nil {
+		return nil, nil, err
+	}
+	if reusedBlockstores.bss == nil {
+		reusedBlockstores.bss = make(map[[32]byte]loadedBlockstore)
+	}
+	reusedBlockstores.bss[key] = loadedBlockstore{bs: bs, roots: roots}
+	return bs, roots, nil
+}
+
+// ResetReusedBlockstores drops the blockstores retained by
+// ExecuteOpts.ReuseBlockstores.
+func ResetReusedBlockstores() {
+	reusedBlockstores.lk.Lock()
+	reusedBlockstores.bss = nil
+	reusedBlockstores.lk.Unlock()
+}
+
+// loadFreshBlockstore loads the vector CAR into a new blockstore.
+func loadFreshBlockstore(vectorCAR schema.Base64EncodedBytes) (blockstore.Blockstore, []cid.Cid, error) {
+	return loadCAR(blockstore.NewMemory(), vectorCAR)
+}
 
+// loadCAR loads the vector CAR into the blockstore, and returns it, wrapped
+// in a fallback store if FallbackBlockstoreGetter is set, along with the
+// roots of the CAR.
+func loadCAR(bs blockstore.Blockstore, vectorCAR schema.Base64EncodedBytes) (blockstore.Blockstore, []cid.Cid, error) {
 	// Read the base64-encoded CAR from the vector, and inflate the gzip.
 	buf := bytes.NewReader(vectorCAR)
 	r, err := gzip.NewReader(buf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to inflate gzipped CAR: %s", err)
+		return nil, nil, fmt.Errorf("failed to inflate gzipped CAR: %s", err)
 	}
 	defer r.Close() // nolint
 
 	// Load the CAR embedded in the test vector into the Blockstore.
-	_, err = car.LoadCar(context.TODO(), bs, r)
+	hdr, err := car.LoadCar(context.TODO(), bs, r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load state tree car from test vector: %s", err)
+		return nil, nil, fmt.Errorf("failed to load state tree car from test vector: %s", err)
 	}
 
 	if FallbackBlockstoreGetter != nil {
@@ -391,5 +1237,5 @@ func LoadBlockstore(vectorCAR schema.Base64EncodedBytes) (blockstore.Blockstore,
 		bs = fbs
 	}
 
-	return bs, nil
+	return bs, hdr.Roots, nil
 }
@@ -0,0 +1,96 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// recordingReporter is a Reporter that records failures instead of failing
+// the test.
+type recordingReporter struct {
+	LogReporter
+	errors int
+}
+
+func (r *recordingReporter) Errorf(format string, args ...interface{}) {
+	r.errors++
+	r.LogReporter.Errorf(format, args...)
+}
+
+func TestAssertMsgResultWithOpts(t *testing.T) {
+	expected := &schema.Receipt{ExitCode: 0, GasUsed: 1000, ReturnValue: []byte{1}}
+	actual := &vm.ApplyRet{
+		MessageReceipt: types.MessageReceipt{ExitCode: exitcode.Ok, GasUsed: 1040, Return: []byte{2}},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		opts   AssertOpts
+		errors int
+	}{
+		{"strict", AssertOpts{}, 2},
+		{"exit code only", AssertOpts{ExitCodeOnly: true}, 0},
+		{"ignore gas", AssertOpts{IgnoreGas: true}, 1},
+		{"gas within tolerance", AssertOpts{GasTolerance: 5}, 1},
+		{"gas outside tolerance", AssertOpts{GasTolerance: 3}, 2},
+		{"ignore return", AssertOpts{IgnoreReturn: true}, 1},
+		{"lenient", AssertOpts{GasTolerance: 5, IgnoreReturn: true}, 0},
+		{"trace mismatch", AssertOpts{GasTolerance: 5, IgnoreReturn: true, ExpectedTrace: &CallTree{Method: 2}}, 1},
+		{"ignore traces", AssertOpts{GasTolerance: 5, IgnoreReturn: true, IgnoreTraces: true, ExpectedTrace: &CallTree{Method: 2}}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := new(recordingReporter)
+			AssertMsgResultWithOpts(r, expected, actual, "test", tc.opts)
+			if r.errors != tc.errors {
+				t.Fatalf("expected %d errors, got %d", tc.errors, r.errors)
+			}
+		})
+	}
+}
+
+func TestNewVectorDriverFlush(t *testing.T) {
+	ctx := context.Background()
+	for class, flush := range map[schema.Class]bool{
+		schema.ClassMessage:  false,
+		ClassConsensusFault:  false,
+		schema.ClassTipset:   true,
+		schema.ClassBlockSeq: true,
+		ClassMigration:       true,
+	} {
+		// the class of the vector decides, whatever the options.
+		for _, disable := range []bool{false, true} {
+			opts := ExecuteOpts{DriverOpts: DriverOpts{DisableVMFlush: disable}}
+			if d := newVectorDriver(ctx, &schema.TestVector{Class: class}, opts); d.vmFlush != flush {
+				t.Errorf("%s vector with DisableVMFlush=%t: VM flushed: %t; want %t", class, disable, d.vmFlush, flush)
+			}
+		}
+	}
+}
+
+func TestCheckBlockSeqVector(t *testing.T) {
+	tipsets := []schema.Tipset{{EpochOffset: 0}, {EpochOffset: 1}}
+	roots := []cid.Cid{cid.Undef, cid.Undef}
+	for _, tc := range []struct {
+		name   string
+		vector schema.TestVector
+		ok     bool
+	}{
+		{"well-formed", schema.TestVector{ApplyTipsets: tipsets, Post: &schema.Postconditions{ReceiptsRoots: roots}}, true},
+		{"no tipsets", schema.TestVector{Post: &schema.Postconditions{}}, false},
+		{"no postconditions", schema.TestVector{ApplyTipsets: tipsets}, false},
+		{"missing receipts root", schema.TestVector{ApplyTipsets: tipsets, Post: &schema.Postconditions{ReceiptsRoots: roots[:1]}}, false},
+	} {
+		if err := checkBlockSeqVector(&tc.vector); (err == nil) != tc.ok {
+			t.Errorf("%s: expected ok: %t, got %v", tc.name, tc.ok, err)
+		}
+	}
+}
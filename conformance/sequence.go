@@ -0,0 +1,146 @@
+package conformance
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// SelectorEpochCron, if it appears in a message-class vector and its value is
+// literal "true", it indicates that the epoch offsets of the messages are
+// epoch-advance steps: before applying a message with a positive offset, cron
+// is applied at the end of every epoch advanced over, as the chain does, e.g.
+// for a payment channel settled at one epoch and collected after the settle
+// period. Cron ticks carry no receipts; their effects are in the post-state.
+const SelectorEpochCron = "epoch_cron"
+
+// SequenceStep is a step of a message sequence: advancing the epoch, then
+// applying a message.
+type SequenceStep struct {
+	// Advance is the number of epochs to advance by before applying the
+	// message.
+	Advance abi.ChainEpoch
+	// Message is the message to apply at the resulting epoch; nil to only
+	// advance.
+	Message *types.Message
+	// Signature is the signature of the message, verified if the driver
+	// verifies signatures.
+	Signature *crypto.Signature
+}
+
+type ExecuteSequenceParams struct {
+	Preroot        cid.Cid
+	BaseEpoch      abi.ChainEpoch
+	Steps          []SequenceStep
+	CircSupply     abi.TokenAmount
+	BaseFee        abi.TokenAmount
+	NetworkVersion network.Version
+
+	// Cron applies cron at the end of every epoch advanced over.
+	Cron bool
+
+	// Implicit applies the messages as implicit (system) messages.
+	Implicit bool
+
+	// Rand is an optional vm.Rand implementation to use. If nil, the driver
+	// will use a vm.Rand that returns a fixed value for all calls.
+	Rand vm.Rand
+}
+
+// ExecuteSequenceResult is the outcome of a message sequence.
+type ExecuteSequenceResult struct {
+	// Rets are the results of the steps; nil for steps without a message.
+	Rets []*vm.ApplyRet
+	// Root is the state root after the last step.
+	Root cid.Cid
+	// Epoch is the epoch of the last step.
+	Epoch abi.ChainEpoch
+}
+
+// ExecuteSequence applies the steps of a message sequence in temporary VMs,
+// advancing the epoch as instructed. When advancing from epoch e by n epochs
+// with params.Cron, cron is applied at epochs e to e+n-1, as the chain applies
+// it after the messages of every tipset, and at every null round.
+func (d *Driver) ExecuteSequence(bs blockstore.Blockstore, params ExecuteSequenceParams) (*ExecuteSequenceResult, error) {
+	res := &ExecuteSequenceResult{
+		Rets:  make([]*vm.ApplyRet, len(params.Steps)),
+		Root:  params.Preroot,
+		Epoch: params.BaseEpoch,
+	}
+	base := ExecuteMessageParams{
+		CircSupply:     params.CircSupply,
+		BaseFee:        params.BaseFee,
+		NetworkVersion: params.NetworkVersion,
+		Rand:           params.Rand,
+	}
+
+	// circSupply returns the circulating supply at the state root: that of the
+	// params, unless the driver recomputes it.
+	circSupply := func(root cid.Cid) (abi.TokenAmount, error) {
+		if !d.recomputeCS {
+			return params.CircSupply, nil
+		}
+		return circSupplyAt(d.ctx, bs, params.CircSupply, params.Preroot, root)
+	}
+
+	var err error
+	for i, step := range params.Steps {
+		target := res.Epoch + step.Advance
+		if params.Cron {
+			for ; res.Epoch < target; res.Epoch++ {
+				p := base
+				p.Preroot, p.Epoch, p.Message = res.Root, res.Epoch, NewCronMessage(res.Epoch)
+				if p.CircSupply, err = circSupply(res.Root); err != nil {
+					return nil, fmt.Errorf("failed to recompute circulating supply at epoch %d: %w", res.Epoch, err)
+				}
+				ret, root, err := d.ExecuteImplicitMessage(bs, p)
+				if err != nil {
+					return nil, fmt.Errorf("failed to apply cron at epoch %d: %w", res.Epoch, err)
+				}
+				if ret.ExitCode != exitcode.Ok {
+					return nil, fmt.Errorf("cron at epoch %d exited with code %d", res.Epoch, ret.ExitCode)
+				}
+				res.Root = root
+			}
+		}
+		res.Epoch = target
+		if step.Message == nil {
+			continue
+		}
+
+		if d.verifySigs && !params.Implicit {
+			if err := VerifyMessageSignature(bs, res.Root, step.Message, step.Signature); err != nil {
+				return nil, fmt.Errorf("message of step %d: %w", i, err)
+			}
+		}
+
+		p := base
+		p.Preroot, p.Epoch, p.Message = res.Root, res.Epoch, step.Message
+		if p.CircSupply, err = circSupply(res.Root); err != nil {
+			return nil, fmt.Errorf("failed to recompute circulating supply for step %d: %w", i, err)
+		}
+		var (
+			ret  *vm.ApplyRet
+			root cid.Cid
+		)
+		if params.Implicit {
+			ret, root, err = d.ExecuteImplicitMessage(bs, p)
+		} else {
+			ret, root, err = d.ExecuteMessage(bs, p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply message of step %d: %w", i, err)
+		}
+		res.Rets[i], res.Root = ret, root
+	}
+	return res, nil
+}
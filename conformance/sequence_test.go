@@ -0,0 +1,88 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+)
+
+func TestExecuteSequence(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	preroot := blocks.NewBlock([]byte("pre-state")).Cid()
+
+	rec := new(vmRecorder)
+	d := NewDriver(ctx, nil, DriverOpts{})
+	d.vmConstructor = rec.construct
+
+	from, to := mock.Address(1000), mock.Address(1001)
+	res, err := d.ExecuteSequence(bs, ExecuteSequenceParams{
+		Preroot:   preroot,
+		BaseEpoch: 10,
+		Steps: []SequenceStep{
+			{Message: mock.UnsignedMessage(from, to, 0)},
+			{Advance: 3, Message: mock.UnsignedMessage(from, to, 1)},
+			{Advance: 1},
+		},
+		Cron: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cron runs at the end of every epoch advanced over, before the message
+	// applied at the resulting epoch.
+	if expected := []abi.ChainEpoch{10, 11, 12, 13}; !equalEpochs(rec.crons, expected) {
+		t.Fatalf("expected cron at epochs %v; got %v", expected, rec.crons)
+	}
+	if expected := []abi.ChainEpoch{10, 13}; !equalEpochs(rec.explicit, expected) {
+		t.Fatalf("expected messages applied at epochs %v; got %v", expected, rec.explicit)
+	}
+	if res.Epoch != 14 {
+		t.Errorf("expected the sequence to end at epoch 14; got %d", res.Epoch)
+	}
+	if res.Rets[0] == nil || res.Rets[1] == nil || res.Rets[2] != nil {
+		t.Errorf("expected results for the steps with a message only; got %v", res.Rets)
+	}
+
+	// every message and cron tick is applied on top of the state the previous
+	// one resulted in.
+	if len(rec.bases) != 6 || len(rec.roots) != 6 {
+		t.Fatalf("expected 6 messages applied in their own VM; got %d VMs, flushed %d times", len(rec.bases), len(rec.roots))
+	}
+	if rec.bases[0] != preroot {
+		t.Errorf("expected the first message to be applied on the pre-state %s; got %s", preroot, rec.bases[0])
+	}
+	for i := 1; i < len(rec.bases); i++ {
+		if rec.bases[i] != rec.roots[i-1] {
+			t.Errorf("expected message %d to be applied on the state %s; got %s", i, rec.roots[i-1], rec.bases[i])
+		}
+	}
+	if last := rec.roots[len(rec.roots)-1]; res.Root != last {
+		t.Errorf("expected the sequence to end at state %s; got %s", last, res.Root)
+	}
+
+	// without cron, the epoch is advanced alone.
+	rec = new(vmRecorder)
+	d.vmConstructor = rec.construct
+	if _, err := d.ExecuteSequence(bs, ExecuteSequenceParams{
+		Preroot:   preroot,
+		BaseEpoch: 10,
+		Steps:     []SequenceStep{{Advance: 3, Message: mock.UnsignedMessage(from, to, 0)}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.crons) != 0 {
+		t.Errorf("expected no cron without Cron; got cron at epochs %v", rec.crons)
+	}
+	if expected := []abi.ChainEpoch{13}; !equalEpochs(rec.explicit, expected) {
+		t.Errorf("expected the message applied at epoch 13; got %v", rec.explicit)
+	}
+}
@@ -0,0 +1,141 @@
+package conformance
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// SelectorSharedBlocks, if it appears in a vector, indicates that the blocks
+// of its CAR are held in the block store shared by the vectors of its corpus,
+// rather than embedded: its CAR carries the roots only. Its value is the CID
+// of the SharedBlockIndex listing them, which is stored as a raw block in the
+// shared store. Corpora of vectors extracted at the same epochs share most of
+// their state; the shared store holds it once. Such vectors are materialized
+// with MaterializeVector before they're executed; ExecuteVariant refuses them
+// with ErrSharedBlocks otherwise.
+const SelectorSharedBlocks = "shared_blocks"
+
+// ErrSharedBlocks is returned for vectors whose blocks are held in a shared
+// store, and which weren't materialized.
+var ErrSharedBlocks = errors.New("vector blocks held in a shared store")
+
+// BlockGetter gets blocks by CID, e.g. from the block store shared by a
+// corpus.
+type BlockGetter interface {
+	Get(ctx context.Context, c cid.Cid) (blocks.Block, error)
+}
+
+// SharedBlockIndex lists the blocks of the CAR of a vector held in a shared
+// store, in the order of the CAR.
+type SharedBlockIndex struct {
+	Blocks []cid.Cid `json:"blocks"`
+}
+
+// Block returns the raw block the index is stored as.
+func (i *SharedBlockIndex) Block() (blocks.Block, error) {
+	data, err := json.Marshal(i)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize shared block index: %w", err)
+	}
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_256}.Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// LoadSharedBlockIndex loads the shared block index stored under the CID.
+func LoadSharedBlockIndex(ctx context.Context, bg BlockGetter, c cid.Cid) (*SharedBlockIndex, error) {
+	blk, err := bg.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load shared block index %s: %w", c, err)
+	}
+	i := new(SharedBlockIndex)
+	if err := json.Unmarshal(blk.RawData(), i); err != nil {
+		return nil, fmt.Errorf("failed to deserialize shared block index %s: %w", c, err)
+	}
+	return i, nil
+}
+
+// checkSharedBlocks refuses the vector if its blocks are held in a shared
+// store.
+func checkSharedBlocks(vector *schema.TestVector) error {
+	if s, ok := vector.Selector[SelectorSharedBlocks]; ok {
+		return fmt.Errorf("%w: index %s; materialize the vector with its corpus store first", ErrSharedBlocks, s)
+	}
+	return nil
+}
+
+// MaterializeVector replaces the CAR of the vector, whose blocks are held in
+// the shared store, with a self-contained one, holding its roots and the
+// blocks its index lists, in order, and drops SelectorSharedBlocks. The CAR
+// is compressed as the extractor does, so that the CAR of a vector shared and
+// materialized back is that it was extracted with. Vectors without the
+// selector are left as they are.
+func MaterializeVector(ctx context.Context, vector *schema.TestVector, store BlockGetter) error {
+	s, ok := vector.Selector[SelectorSharedBlocks]
+	if !ok {
+		return nil
+	}
+	ic, err := cid.Decode(s)
+	if err != nil {
+		return fmt.Errorf("invalid %s selector: %w", SelectorSharedBlocks, err)
+	}
+	index, err := LoadSharedBlockIndex(ctx, store, ic)
+	if err != nil {
+		return err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(vector.CAR))
+	if err != nil {
+		return fmt.Errorf("failed to inflate gzipped CAR: %w", err)
+	}
+	defer zr.Close() //nolint:errcheck
+	cr, err := car.NewCarReader(zr)
+	if err != nil {
+		return fmt.Errorf("failed to read CAR: %w", err)
+	}
+
+	var raw bytes.Buffer
+	if err := car.WriteHeader(&car.CarHeader{Roots: cr.Header.Roots, Version: 1}, &raw); err != nil {
+		return fmt.Errorf("failed to write CAR header: %w", err)
+	}
+	for _, c := range index.Blocks {
+		blk, err := store.Get(ctx, c)
+		if err != nil {
+			return fmt.Errorf("failed to get block %s from the shared store: %w", c, err)
+		}
+		if err := util.LdWrite(&raw, c.Bytes(), blk.RawData()); err != nil {
+			return fmt.Errorf("failed to write CAR block: %w", err)
+		}
+	}
+
+	var out bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&out, gzip.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	zw.Header = gzip.Header{ModTime: time.Time{}, OS: 255}
+	if _, err := raw.WriteTo(zw); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	vector.CAR = out.Bytes()
+	delete(vector.Selector, SelectorSharedBlocks)
+	return nil
+}
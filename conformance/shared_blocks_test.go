@@ -0,0 +1,44 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+)
+
+func TestSharedBlockIndex(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	a, b := blocks.NewBlock([]byte("a")), blocks.NewBlock([]byte("b"))
+	index := &SharedBlockIndex{Blocks: []cid.Cid{b.Cid(), a.Cid()}}
+	blk, err := index.Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSharedBlockIndex(ctx, bs, blk.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Blocks) != 2 || loaded.Blocks[0] != b.Cid() || loaded.Blocks[1] != a.Cid() {
+		t.Errorf("unexpected index: %v", loaded.Blocks)
+	}
+
+	tv := &schema.TestVector{
+		Class:    schema.ClassMessage,
+		Selector: schema.Selector{SelectorSharedBlocks: blk.Cid().String()},
+	}
+	if _, err := ExecuteVariant(context.Background(), new(LogReporter), tv, &schema.Variant{}, ExecuteOpts{}); !errors.Is(err, ErrSharedBlocks) {
+		t.Errorf("expected a vector with shared blocks to be refused; got %v", err)
+	}
+}
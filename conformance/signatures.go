@@ -0,0 +1,144 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/consensus"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/lib/sigs"
+)
+
+// SelectorVerifySignatures, if it appears in a vector and its value is
+// literal "true", it indicates that the messages of the vector are signed,
+// and that their signatures must be verified before they're applied, as the
+// chain does: those of secp256k1 messages individually, and those of BLS
+// messages of a tipset block as the aggregate signature of the block. The
+// signers are resolved in the state the messages apply to.
+const SelectorVerifySignatures = "verify_signatures"
+
+// ErrInvalidSignature is returned by the driver for messages that fail
+// signature verification.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// DecodeVectorMessage decodes a message of a vector, which is either an
+// unsigned message or a signed message, whose signature is returned along.
+func DecodeVectorMessage(b []byte) (*types.Message, *crypto.Signature, error) {
+	msg, err := types.DecodeMessage(b)
+	if err == nil {
+		return msg, nil, nil
+	}
+	smsg, serr := types.DecodeSignedMessage(b)
+	if serr != nil {
+		// report the error of the common case.
+		return nil, nil, err
+	}
+	return &smsg.Message, &smsg.Signature, nil
+}
+
+// signerResolver resolves the key addresses of signers in the state tree at
+// a root.
+type signerResolver struct {
+	st  *state.StateTree
+	cst cbornode.IpldStore
+}
+
+func newSignerResolver(bs blockstore.Blockstore, root cid.Cid) (*signerResolver, error) {
+	cst := cbornode.NewCborStore(bs)
+	st, err := state.LoadStateTree(cst, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state tree %s: %w", root, err)
+	}
+	return &signerResolver{st: st, cst: cst}, nil
+}
+
+func (r *signerResolver) resolve(addr address.Address) (address.Address, error) {
+	key, err := vm.ResolveToKeyAddr(r.st, r.cst, addr)
+	if err != nil {
+		return address.Undef, fmt.Errorf("%w: failed to resolve signer %s: %s", ErrInvalidSignature, addr, err)
+	}
+	return key, nil
+}
+
+// VerifyMessageSignature verifies the signature of the message by its
+// sender, resolved in the state tree at root.
+func VerifyMessageSignature(bs blockstore.Blockstore, root cid.Cid, msg *types.Message, sig *crypto.Signature) error {
+	r, err := newSignerResolver(bs, root)
+	if err != nil {
+		return err
+	}
+	return r.verify(msg, sig)
+}
+
+func (r *signerResolver) verify(msg *types.Message, sig *crypto.Signature) error {
+	if sig == nil {
+		return fmt.Errorf("%w: message %s is unsigned", ErrInvalidSignature, msg.Cid())
+	}
+	key, err := r.resolve(msg.From)
+	if err != nil {
+		return err
+	}
+	if err := sigs.Verify(sig, key, msg.Cid().Bytes()); err != nil {
+		return fmt.Errorf("%w: message %s: %s", ErrInvalidSignature, msg.Cid(), err)
+	}
+	return nil
+}
+
+// VerifyBlockSignatures verifies the signatures of the messages of a block,
+// with their senders resolved in the state tree at root: those of secp256k1
+// messages individually, and those of BLS messages in aggregate, as the
+// block's aggregate signature is verified.
+func VerifyBlockSignatures(ctx context.Context, bs blockstore.Blockstore, root cid.Cid, msgs []*types.Message, signatures []*crypto.Signature) error {
+	r, err := newSignerResolver(bs, root)
+	if err != nil {
+		return err
+	}
+	var (
+		blsCids []cid.Cid
+		blsKeys [][]byte
+		blsSigs []crypto.Signature
+	)
+	for i, msg := range msgs {
+		sig := signatures[i]
+		if sig == nil {
+			return fmt.Errorf("%w: message %s is unsigned", ErrInvalidSignature, msg.Cid())
+		}
+		if sig.Type != crypto.SigTypeBLS {
+			if err := r.verify(msg, sig); err != nil {
+				return err
+			}
+			continue
+		}
+		key, err := r.resolve(msg.From)
+		if err != nil {
+			return err
+		}
+		if key.Protocol() != address.BLS {
+			return fmt.Errorf("%w: message %s has a BLS signature, but its sender %s isn't a BLS key", ErrInvalidSignature, msg.Cid(), key)
+		}
+		blsCids = append(blsCids, msg.Cid())
+		blsKeys = append(blsKeys, key.Payload())
+		blsSigs = append(blsSigs, *sig)
+	}
+	if len(blsSigs) == 0 {
+		return nil
+	}
+	agg, err := consensus.AggregateSignatures(blsSigs)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate BLS signatures: %w", err)
+	}
+	if err := consensus.VerifyBlsAggregate(ctx, agg, blsCids, blsKeys); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package conformance
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	badgerbs "github.com/filecoin-project/lotus/blockstore/badger"
+)
+
+// SpillOpts configures the on-disk blockstores of
+// ExecuteOpts.SpillBlockstores, which back the execution of tipset-class,
+// blockseq-class and migration-class vectors with a temporary badger
+// blockstore on disk, rather than an in-memory one, so that executions
+// touching more state than fits in memory succeed. The blockstores are
+// removed once the vector is executed; ExecuteOpts.ReuseBlockstores doesn't
+// apply to them.
+type SpillOpts struct {
+	// Dir is the directory the temporary blockstores are created in; the
+	// default directory for temporary files if empty.
+	Dir string
+
+	// MemoryBudget is the approximate memory, in bytes, a blockstore may use
+	// for its memtables and caches; badger's defaults apply if 0.
+	MemoryBudget int64
+}
+
+// badgerOptions returns the badger options of a spilled blockstore at the
+// path, sizing its memtables and caches after the memory budget: half of it
+// goes to the memtables, and the rest to the block and index caches.
+func (o *SpillOpts) badgerOptions(path string) badgerbs.Options {
+	opts := badgerbs.DefaultOptions(path)
+	// the blockstore is discarded after executing the vector; blocks are
+	// immutable, so no conflicts are expected.
+	opts.SyncWrites = false
+	opts.DetectConflicts = false
+	// keep tables and values on disk, rather than mapped into memory.
+	opts.TableLoadingMode = badgerbs.FileIO
+	opts.ValueLogLoadingMode = badgerbs.FileIO
+
+	if b := o.MemoryBudget; b > 0 {
+		opts.NumMemtables = 2
+		opts.MaxTableSize = b / 4
+		opts.BlockCacheSize = b / 4
+		opts.IndexCacheSize = b / 4
+		opts.NumLevelZeroTables = 2
+		opts.NumLevelZeroTablesStall = 4
+	}
+	return opts
+}
+
+// loadSpilledBlockstore loads the vector CAR into a new temporary badger
+// blockstore. The returned function closes and removes the blockstore, and
+// MUST be invoked.
+func loadSpilledBlockstore(o *SpillOpts, vectorCAR schema.Base64EncodedBytes) (blockstore.Blockstore, func(), error) {
+	dir, err := os.MkdirTemp(o.Dir, "tvx-blockstore-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temporary blockstore directory: %w", err)
+	}
+
+	bbs, err := badgerbs.Open(o.badgerOptions(dir))
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	cleanup := func() {
+		_ = bbs.Close()
+		_ = os.RemoveAll(dir)
+	}
+
+	bs, _, err := loadCAR(bbs, vectorCAR)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return bs, cleanup, nil
+}
+
+// loadExecutionBlockstore loads the vector CAR into the blockstore to execute
+// tipset-class, blockseq-class and migration-class vectors with: a temporary
+// badger blockstore if opts.SpillBlockstores is set, or as loadBlockstore
+// does otherwise. The returned function releases the blockstore, and MUST be
+// invoked.
+func loadExecutionBlockstore(vectorCAR schema.Base64EncodedBytes, opts ExecuteOpts) (blockstore.Blockstore, func(), error) {
+	if opts.SpillBlockstores != nil {
+		return loadSpilledBlockstore(opts.SpillBlockstores, vectorCAR)
+	}
+	bs, _, err := loadBlockstore(vectorCAR, opts.ReuseBlockstores)
+	return bs, func() {}, err
+}
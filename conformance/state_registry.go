@@ -0,0 +1,181 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/manifest"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/conformance/puppet"
+)
+
+// StateDecoder decodes the head block of an actor into its typed state.
+type StateDecoder func(raw []byte) (interface{}, error)
+
+// ActorStateSchema describes the state of the actors of a code.
+type ActorStateSchema struct {
+	// Name is the name of the actor, e.g. "storageminer".
+	Name string
+	// Version is the actors version the code belongs to.
+	Version actorstypes.Version
+	Decode  StateDecoder
+}
+
+// StateRegistry maps actor code CIDs to the schemas of their states, so that
+// tooling decodes the states of actors of any actors version, rather than
+// those of the specs-actors version it imports. The codes of the built-in
+// actors of every actors version Lotus supports resolve without
+// registration, including those of the bundles loaded after the registry is
+// created; other actors, e.g. test actors, are registered.
+type StateRegistry struct {
+	lk      sync.RWMutex
+	schemas map[cid.Cid]ActorStateSchema
+}
+
+// ActorStates is the registry the driver and tvx decode actor states with,
+// for actor postconditions, semantic state diffs and inspection. Embedders
+// register the states of their own actors into it; that of the puppet actor is
+// registered.
+var ActorStates = func() *StateRegistry {
+	r := NewStateRegistry()
+	r.Register(puppet.PuppetActorCodeCID, ActorStateSchema{Name: "puppet", Decode: func(raw []byte) (interface{}, error) {
+		var st puppet.State
+		if err := st.UnmarshalCBOR(bytes.NewReader(raw)); err != nil {
+			return nil, err
+		}
+		return &st, nil
+	}})
+	return r
+}()
+
+// NewStateRegistry returns a registry resolving the codes of the built-in
+// actors only.
+func NewStateRegistry() *StateRegistry {
+	return &StateRegistry{schemas: make(map[cid.Cid]ActorStateSchema)}
+}
+
+// Register registers the schema of the state of the actors of the code,
+// replacing any registered, or built-in, one.
+func (r *StateRegistry) Register(code cid.Cid, s ActorStateSchema) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.schemas[code] = s
+}
+
+// Lookup returns the schema of the state of the actors of the code.
+func (r *StateRegistry) Lookup(code cid.Cid) (ActorStateSchema, bool) {
+	r.lk.RLock()
+	s, ok := r.schemas[code]
+	r.lk.RUnlock()
+	if ok {
+		return s, true
+	}
+
+	name, av, ok := builtinActorMeta(code)
+	if !ok {
+		return ActorStateSchema{}, false
+	}
+	s = ActorStateSchema{Name: name, Version: av, Decode: builtinStateDecoder(code)}
+	r.Register(code, s)
+	return s, true
+}
+
+// DecodeState loads the head of the actor from the blockstore, and decodes it
+// with the schema of its code.
+func (r *StateRegistry) DecodeState(ctx context.Context, bs blockstore.Blockstore, act *types.Actor) (interface{}, error) {
+	s, ok := r.Lookup(act.Code)
+	if !ok {
+		return nil, fmt.Errorf("no state schema registered for actor code %s", act.Code)
+	}
+	blk, err := bs.Get(ctx, act.Head)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.Decode(blk.RawData())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s (v%d) state: %w", s.Name, s.Version, err)
+	}
+	return st, nil
+}
+
+// DecodeStateJSON decodes the state of the actor as DecodeState does, in its
+// generic JSON form: maps, slices, strings, numbers and booleans.
+func (r *StateRegistry) DecodeStateJSON(ctx context.Context, bs blockstore.Blockstore, act *types.Actor) (interface{}, error) {
+	st, err := r.DecodeState(ctx, bs, act)
+	if err != nil {
+		return nil, err
+	}
+	j, err := json.Marshal(st)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(j, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+var (
+	builtinOnce sync.Once
+	// builtinRegistry holds the actors of every actors version, for their
+	// state types.
+	builtinRegistry *vm.ActorRegistry
+	// legacyCodes are the names and versions of the codes of the actors
+	// preceding the bundles (v0 to v7), which carry no manifest.
+	legacyCodes map[cid.Cid]legacyActor
+)
+
+type legacyActor struct {
+	name    string
+	version actorstypes.Version
+}
+
+func initBuiltin() {
+	builtinOnce.Do(func() {
+		builtinRegistry = filcns.NewActorRegistry()
+		legacyCodes = make(map[cid.Cid]legacyActor)
+		for _, v := range actors.Versions {
+			av := actorstypes.Version(v)
+			if av >= actorstypes.Version8 {
+				continue
+			}
+			for _, key := range manifest.GetBuiltinActorsKeys(av) {
+				if code, ok := actors.GetActorCodeID(av, key); ok {
+					legacyCodes[code] = legacyActor{name: key, version: av}
+				}
+			}
+		}
+	})
+}
+
+// builtinActorMeta returns the name and actors version of the built-in actor
+// of the code.
+func builtinActorMeta(code cid.Cid) (string, actorstypes.Version, bool) {
+	if name, av, ok := actors.GetActorMetaByCode(code); ok {
+		return name, av, true
+	}
+	initBuiltin()
+	a, ok := legacyCodes[code]
+	return a.name, a.version, ok
+}
+
+// builtinStateDecoder returns the decoder of the state of the built-in actor
+// of the code.
+func builtinStateDecoder(code cid.Cid) StateDecoder {
+	return func(raw []byte) (interface{}, error) {
+		initBuiltin()
+		return vm.DumpActorState(builtinRegistry, &types.Actor{Code: code}, raw)
+	}
+}
@@ -0,0 +1,64 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/manifest"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestStateRegistry(t *testing.T) {
+	ctx := context.Background()
+	r := NewStateRegistry()
+
+	// the built-in actors of legacy versions resolve without registration.
+	miner0, ok := actors.GetActorCodeID(actorstypes.Version0, manifest.MinerKey)
+	if !ok {
+		t.Fatal("no code for the v0 miner actor")
+	}
+	if s, ok := r.Lookup(miner0); !ok || s.Name != manifest.MinerKey || s.Version != actorstypes.Version0 {
+		t.Errorf("unexpected schema of the v0 miner actor: %+v (found: %t)", s, ok)
+	}
+
+	// other actors are registered.
+	code, err := abi.CidBuilder.Sum([]byte("test actor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Lookup(code); ok {
+		t.Fatal("expected no schema for an unregistered code")
+	}
+	r.Register(code, ActorStateSchema{
+		Name:    "test",
+		Version: actorstypes.Version10,
+		Decode: func(raw []byte) (interface{}, error) {
+			return map[string]string{"Value": string(raw)}, nil
+		},
+	})
+
+	bs := blockstore.NewMemory()
+	blk := blocks.NewBlock([]byte("state"))
+	if err := bs.Put(ctx, blk); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := r.DecodeStateJSON(ctx, bs, &types.Actor{Code: code, Head: blk.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := stateField(decoded, "Value"); !ok || v != "state" {
+		t.Errorf("unexpected decoded state: %v", decoded)
+	}
+
+	if _, err := r.DecodeState(ctx, bs, &types.Actor{Code: blk.Cid(), Head: blk.Cid()}); err == nil {
+		t.Error("expected error for an unknown code")
+	}
+}
@@ -0,0 +1,171 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// maxDiffValueLen is the length beyond which values are elided in diffs.
+const maxDiffValueLen = 160
+
+// DiffStateTrees returns the semantic diff of the state trees at the expected
+// and actual roots: the actors present in only one of them, and the code,
+// nonce, balance and head of those that differ, along with the fields of
+// their states, where the states can be decoded. The state trees of vectors
+// are often sparse: the states that can't be loaded are reported as such, but
+// the trees themselves must be complete.
+func DiffStateTrees(ctx context.Context, bs blockstore.Blockstore, expected, actual cid.Cid) (string, error) {
+	cst := cbornode.NewCborStore(bs)
+	load := func(root cid.Cid) (map[address.Address]types.Actor, error) {
+		st, err := state.LoadStateTree(cst, root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load state tree %s: %w", root, err)
+		}
+		actors := make(map[address.Address]types.Actor)
+		err = st.ForEach(func(addr address.Address, act *types.Actor) error {
+			actors[addr] = *act
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk state tree %s: %w", root, err)
+		}
+		return actors, nil
+	}
+	ea, err := load(expected)
+	if err != nil {
+		return "", err
+	}
+	aa, err := load(actual)
+	if err != nil {
+		return "", err
+	}
+
+	addrs := make([]address.Address, 0, len(ea)+len(aa))
+	for addr := range ea {
+		addrs = append(addrs, addr)
+	}
+	for addr := range aa {
+		if _, ok := ea[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].String() < addrs[j].String() })
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "state diff: expected %s, actual %s\n", expected, actual)
+	var changed int
+	for _, addr := range addrs {
+		e, inExpected := ea[addr]
+		a, inActual := aa[addr]
+		switch {
+		case !inActual:
+			_, _ = fmt.Fprintf(&b, "- actor %s (%s): only in expected state\n", addr, builtin.ActorNameByCode(e.Code))
+		case !inExpected:
+			_, _ = fmt.Fprintf(&b, "+ actor %s (%s): only in actual state\n", addr, builtin.ActorNameByCode(a.Code))
+		case e.Code == a.Code && e.Head == a.Head && e.Nonce == a.Nonce && e.Balance.Equals(a.Balance):
+			continue
+		default:
+			_, _ = fmt.Fprintf(&b, "~ actor %s (%s):\n", addr, builtin.ActorNameByCode(a.Code))
+			for _, line := range diffActors(ctx, bs, &e, &a) {
+				_, _ = fmt.Fprintf(&b, "    %s\n", line)
+			}
+		}
+		changed++
+	}
+	_, _ = fmt.Fprintf(&b, "%d of %d actors differ\n", changed, len(addrs))
+	return b.String(), nil
+}
+
+// diffActors returns the differences between the expected and actual
+// versions of an actor, one per line.
+func diffActors(ctx context.Context, bs blockstore.Blockstore, e, a *types.Actor) []string {
+	var out []string
+	if e.Code != a.Code {
+		out = append(out, fmt.Sprintf("code: %s -> %s", builtin.ActorNameByCode(e.Code), builtin.ActorNameByCode(a.Code)))
+	}
+	if e.Nonce != a.Nonce {
+		out = append(out, fmt.Sprintf("nonce: %d -> %d", e.Nonce, a.Nonce))
+	}
+	if !e.Balance.Equals(a.Balance) {
+		out = append(out, fmt.Sprintf("balance: %s -> %s", e.Balance, a.Balance))
+	}
+	if e.Head == a.Head {
+		return out
+	}
+	out = append(out, fmt.Sprintf("head: %s -> %s", e.Head, a.Head))
+
+	es, err := ActorStates.DecodeStateJSON(ctx, bs, e)
+	if err != nil {
+		return append(out, fmt.Sprintf("expected state not decodable: %s", err))
+	}
+	as, err := ActorStates.DecodeStateJSON(ctx, bs, a)
+	if err != nil {
+		return append(out, fmt.Sprintf("actual state not decodable: %s", err))
+	}
+	return diffValues("state", es, as, out)
+}
+
+// diffValues appends the differences between the expected and actual values,
+// in their generic JSON form, to out: per field of objects, and per element
+// of arrays of equal lengths.
+func diffValues(path string, e, a interface{}, out []string) []string {
+	if reflect.DeepEqual(e, a) {
+		return out
+	}
+	switch ev := e.(type) {
+	case map[string]interface{}:
+		av, ok := a.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(ev)+len(av))
+		for k := range ev {
+			keys = append(keys, k)
+		}
+		for k := range av {
+			if _, ok := ev[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out = diffValues(path+"."+k, ev[k], av[k], out)
+		}
+		return out
+	case []interface{}:
+		av, ok := a.([]interface{})
+		if !ok || len(av) != len(ev) {
+			break
+		}
+		for i := range ev {
+			out = diffValues(fmt.Sprintf("%s[%d]", path, i), ev[i], av[i], out)
+		}
+		return out
+	}
+	return append(out, fmt.Sprintf("%s: %s -> %s", path, diffValue(e), diffValue(a)))
+}
+
+func diffValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	if len(b) > maxDiffValueLen {
+		return string(b[:maxDiffValueLen]) + "…"
+	}
+	return string(b)
+}
@@ -0,0 +1,84 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	cbornode "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	account2 "github.com/filecoin-project/specs-actors/v2/actors/builtin/account"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestDiffStateTrees(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	cst := cbornode.NewCborStore(bs)
+
+	var (
+		a100, _ = address.NewIDAddress(100)
+		a101, _ = address.NewIDAddress(101)
+		a102, _ = address.NewIDAddress(102)
+		k1, _   = address.NewSecp256k1Address([]byte("key 1"))
+		k2, _   = address.NewSecp256k1Address([]byte("key 2"))
+	)
+	mkTree := func(balance int64, key address.Address, actors ...address.Address) *state.StateTree {
+		st, err := state.NewStateTree(cst, types.StateTreeVersion4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, err := cst.Put(ctx, &account2.State{Address: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range actors {
+			if err := st.SetActor(a, &types.Actor{Code: builtin2.AccountActorCodeID, Head: head, Balance: abi.NewTokenAmount(balance)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return st
+	}
+	expected, err := mkTree(50, k1, a100, a101).Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := mkTree(60, k2, a100, a102).Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := DiffStateTrees(ctx, bs, expected, actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"~ actor f0100 (fil/2/account)",
+		"balance: 50 -> 60",
+		`state.Address: "` + k1.String() + `" -> "` + k2.String() + `"`,
+		"- actor f0101 (fil/2/account): only in expected state",
+		"+ actor f0102 (fil/2/account): only in actual state",
+		"3 of 3 actors differ",
+	} {
+		if !strings.Contains(d, want) {
+			t.Errorf("diff lacks %q:\n%s", want, d)
+		}
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	e := map[string]interface{}{"A": "x", "B": []interface{}{1.0, 2.0}, "C": []interface{}{1.0}}
+	a := map[string]interface{}{"A": "x", "B": []interface{}{1.0, 3.0}, "C": []interface{}{1.0, 2.0}, "D": true}
+	got := diffValues("state", e, a, nil)
+	want := []string{"state.B[1]: 2 -> 3", "state.C: [1] -> [1,2]", "state.D: null -> true"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected diff:\n%s", strings.Join(got, "\n"))
+	}
+}
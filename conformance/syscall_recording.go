@@ -0,0 +1,242 @@
+package conformance
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/minio/blake2b-simd"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	runtime7 "github.com/filecoin-project/specs-actors/v7/actors/runtime"
+	proof7 "github.com/filecoin-project/specs-actors/v7/actors/runtime/proof"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// SelectorRecordedSyscalls, if it appears in a vector, it indicates that the
+// outcomes of the syscalls made during extraction were recorded, and must be
+// replayed. Its value is the CID of the SyscallRecording, which is stored as a
+// raw block in the vector's CAR.
+const SelectorRecordedSyscalls = "recorded_syscalls"
+
+// SyscallRecording records the outcomes of the verification syscalls
+// (signatures, seals, PoSts, consensus faults) made by actors, keyed by
+// syscall and inputs, so that they can be replayed deterministically.
+//
+// Like SyscallOverrides, recordings only take effect in the legacy VM.
+type SyscallRecording struct {
+	lk       sync.Mutex
+	Outcomes map[string]*SyscallOutcome `json:"outcomes"`
+}
+
+// SyscallOutcome is the recorded outcome of a syscall.
+type SyscallOutcome struct {
+	Syscall        string                   `json:"syscall"`
+	Error          string                   `json:"error,omitempty"`
+	ConsensusFault *runtime7.ConsensusFault `json:"consensus_fault,omitempty"`
+	// BatchResults holds the results of BatchVerifySeals, keyed by miner.
+	BatchResults map[string][]bool `json:"batch_results,omitempty"`
+}
+
+func NewSyscallRecording() *SyscallRecording {
+	return &SyscallRecording{Outcomes: make(map[string]*SyscallOutcome)}
+}
+
+// Store serializes the recording into a raw block in the supplied
+// blockstore, and returns its CID.
+func (r *SyscallRecording) Store(ctx context.Context, bs blockstore.Blockstore) (cid.Cid, error) {
+	r.lk.Lock()
+	data, err := json.Marshal(r)
+	r.lk.Unlock()
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to serialize syscall recording: %w", err)
+	}
+
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_256}.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := bs.Put(ctx, blk); err != nil {
+		return cid.Undef, fmt.Errorf("failed to store syscall recording: %w", err)
+	}
+	return c, nil
+}
+
+// LoadSyscallRecording loads the recording stored under the supplied CID.
+func LoadSyscallRecording(ctx context.Context, bs blockstore.Blockstore, c cid.Cid) (*SyscallRecording, error) {
+	blk, err := bs.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load syscall recording %s: %w", c, err)
+	}
+	r := NewSyscallRecording()
+	if err := json.Unmarshal(blk.RawData(), r); err != nil {
+		return nil, fmt.Errorf("failed to deserialize syscall recording %s: %w", c, err)
+	}
+	return r, nil
+}
+
+// Len returns the number of recorded outcomes.
+func (r *SyscallRecording) Len() int {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return len(r.Outcomes)
+}
+
+// Recorder returns a syscall builder that records the outcomes of the
+// syscalls built by base.
+func (r *SyscallRecording) Recorder(base vm.SyscallBuilder) vm.SyscallBuilder {
+	return func(ctx context.Context, rt *vm.Runtime) runtime7.Syscalls {
+		return &recordedSyscalls{Syscalls: base(ctx, rt), r: r}
+	}
+}
+
+// Replayer returns a syscall builder that replays the recorded outcomes,
+// deferring to the syscalls built by base for syscalls that weren't recorded.
+func (r *SyscallRecording) Replayer(base vm.SyscallBuilder) vm.SyscallBuilder {
+	return func(ctx context.Context, rt *vm.Runtime) runtime7.Syscalls {
+		return &recordedSyscalls{Syscalls: base(ctx, rt), r: r, replay: true}
+	}
+}
+
+// syscallKey derives the key of a syscall outcome from the syscall name and
+// the JSON encoding of its inputs.
+func syscallKey(syscall string, inputs ...interface{}) (string, error) {
+	b, err := json.Marshal(inputs)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize %s syscall inputs: %w", syscall, err)
+	}
+	h := blake2b.Sum256(b)
+	return syscall + ":" + hex.EncodeToString(h[:]), nil
+}
+
+type recordedSyscalls struct {
+	runtime7.Syscalls
+	r      *SyscallRecording
+	replay bool
+}
+
+// do replays or records the outcome of the syscall identified by the name and
+// inputs; call performs the actual syscall, and fills in the outcome.
+func (s *recordedSyscalls) do(syscall string, call func(out *SyscallOutcome) error, inputs ...interface{}) (*SyscallOutcome, error) {
+	key, err := syscallKey(syscall, inputs...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.r.lk.Lock()
+	out, ok := s.r.Outcomes[key]
+	s.r.lk.Unlock()
+
+	if s.replay && ok {
+		if out.Error != "" {
+			return out, errors.New(out.Error)
+		}
+		return out, nil
+	}
+
+	out = &SyscallOutcome{Syscall: syscall}
+	err = call(out)
+	if err != nil {
+		out.Error = err.Error()
+	}
+
+	if !s.replay {
+		s.r.lk.Lock()
+		s.r.Outcomes[key] = out
+		s.r.lk.Unlock()
+	}
+	return out, err
+}
+
+func (s *recordedSyscalls) VerifySignature(sig crypto.Signature, signer address.Address, plaintext []byte) error {
+	_, err := s.do("VerifySignature", func(*SyscallOutcome) error {
+		return s.Syscalls.VerifySignature(sig, signer, plaintext)
+	}, sig, signer, plaintext)
+	return err
+}
+
+func (s *recordedSyscalls) VerifySeal(info proof7.SealVerifyInfo) error {
+	_, err := s.do("VerifySeal", func(*SyscallOutcome) error {
+		return s.Syscalls.VerifySeal(info)
+	}, info)
+	return err
+}
+
+func (s *recordedSyscalls) VerifyAggregateSeals(aggregate proof7.AggregateSealVerifyProofAndInfos) error {
+	_, err := s.do("VerifyAggregateSeals", func(*SyscallOutcome) error {
+		return s.Syscalls.VerifyAggregateSeals(aggregate)
+	}, aggregate)
+	return err
+}
+
+func (s *recordedSyscalls) VerifyReplicaUpdate(update proof7.ReplicaUpdateInfo) error {
+	_, err := s.do("VerifyReplicaUpdate", func(*SyscallOutcome) error {
+		return s.Syscalls.VerifyReplicaUpdate(update)
+	}, update)
+	return err
+}
+
+func (s *recordedSyscalls) VerifyPoSt(info proof7.WindowPoStVerifyInfo) error {
+	_, err := s.do("VerifyPoSt", func(*SyscallOutcome) error {
+		return s.Syscalls.VerifyPoSt(info)
+	}, info)
+	return err
+}
+
+func (s *recordedSyscalls) VerifyConsensusFault(a, b, extra []byte) (*runtime7.ConsensusFault, error) {
+	out, err := s.do("VerifyConsensusFault", func(out *SyscallOutcome) error {
+		fault, err := s.Syscalls.VerifyConsensusFault(a, b, extra)
+		out.ConsensusFault = fault
+		return err
+	}, a, b, extra)
+	if err != nil {
+		return nil, err
+	}
+	return out.ConsensusFault, nil
+}
+
+func (s *recordedSyscalls) BatchVerifySeals(vis map[address.Address][]proof7.SealVerifyInfo) (map[address.Address][]bool, error) {
+	// addresses can't be JSON map keys; key the inputs by their string form.
+	inputs := make(map[string][]proof7.SealVerifyInfo, len(vis))
+	for a, v := range vis {
+		inputs[a.String()] = v
+	}
+
+	out, err := s.do("BatchVerifySeals", func(out *SyscallOutcome) error {
+		res, err := s.Syscalls.BatchVerifySeals(vis)
+		if err != nil {
+			return err
+		}
+		out.BatchResults = make(map[string][]bool, len(res))
+		for a, r := range res {
+			out.BatchResults[a.String()] = r
+		}
+		return nil
+	}, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[address.Address][]bool, len(out.BatchResults))
+	for k, r := range out.BatchResults {
+		a, err := address.NewFromString(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid miner address in recorded batch seal verification: %w", err)
+		}
+		ret[a] = r
+	}
+	return ret, nil
+}
@@ -0,0 +1,75 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	runtime7 "github.com/filecoin-project/specs-actors/v7/actors/runtime"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/vm"
+)
+
+// countingSyscalls fails signature verifications of empty plaintexts, and
+// counts the verifications it performs.
+type countingSyscalls struct {
+	runtime7.Syscalls
+	calls int
+}
+
+func (s *countingSyscalls) VerifySignature(_ crypto.Signature, _ address.Address, plaintext []byte) error {
+	s.calls++
+	if len(plaintext) == 0 {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+func TestSyscallRecordingRoundtrip(t *testing.T) {
+	ctx := context.Background()
+	base := new(countingSyscalls)
+	builder := func(context.Context, *vm.Runtime) runtime7.Syscalls { return base }
+
+	rec := NewSyscallRecording()
+	sc := rec.Recorder(builder)(ctx, nil)
+	if err := sc.VerifySignature(crypto.Signature{}, address.Undef, []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.VerifySignature(crypto.Signature{}, address.Undef, nil); err == nil {
+		t.Fatal("expected verification to fail")
+	}
+	if rec.Len() != 2 {
+		t.Fatalf("expected 2 recorded outcomes, got %d", rec.Len())
+	}
+
+	bs := blockstore.NewMemory()
+	c, err := rec.Store(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSyscallRecording(ctx, bs, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base.calls = 0
+	sc = loaded.Replayer(builder)(ctx, nil)
+	if err := sc.VerifySignature(crypto.Signature{}, address.Undef, []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.VerifySignature(crypto.Signature{}, address.Undef, nil); err == nil || err.Error() != "bad signature" {
+		t.Fatalf("expected recorded error, got %v", err)
+	}
+	if base.calls != 0 {
+		t.Fatalf("expected recorded outcomes to be replayed, but %d syscalls were made", base.calls)
+	}
+
+	// unrecorded syscalls defer to the underlying implementation.
+	if err := sc.VerifySignature(crypto.Signature{}, address.Undef, []byte("other")); err != nil || base.calls != 1 {
+		t.Fatalf("expected unrecorded syscall to be performed; err: %v, calls: %d", err, base.calls)
+	}
+}
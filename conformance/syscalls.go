@@ -0,0 +1,149 @@
+package conformance
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/minio/blake2b-simd"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/proof"
+	runtime7 "github.com/filecoin-project/specs-actors/v7/actors/runtime"
+
+	"github.com/filecoin-project/lotus/chain/vm"
+	"github.com/filecoin-project/lotus/storage/sealer/ffiwrapper"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// SelectorMockSyscalls, if it appears in a vector, it indicates that the
+// vector was produced with mocked syscalls, and must be replayed with the same
+// mocks. Its value is a comma-separated list of MockSyscalls* features.
+const SelectorMockSyscalls = "mock_syscalls"
+
+const (
+	// MockSyscallsProofs makes all proof verifications pass.
+	MockSyscallsProofs = "proofs"
+	// MockSyscallsSignatures makes all signature verifications pass.
+	MockSyscallsSignatures = "signatures"
+)
+
+// SyscallOverrides overrides the outcomes of syscalls made by actors, so that
+// vectors involving proofs (e.g. WindowPoSt, ProveCommit) and signatures can
+// be extracted and replayed deterministically, without proof parameters.
+// Unset fields defer to the real syscalls.
+//
+// Overrides only take effect in the legacy VM (network versions before 16);
+// the FVM performs these verifications natively.
+type SyscallOverrides struct {
+	// PassProofs, if true, makes all seal, aggregate seal, replica update and
+	// window PoSt verifications pass.
+	PassProofs bool
+
+	// VerifySignature, if not nil, replaces signature verification.
+	VerifySignature func(sig crypto.Signature, signer address.Address, plaintext []byte) error
+
+	// ConsensusFaults holds the outcomes of consensus fault verifications,
+	// keyed by ConsensusFaultKey. Verifications of faults not present in the
+	// map defer to the real syscall.
+	ConsensusFaults map[string]ConsensusFaultOutcome
+}
+
+// ConsensusFaultOutcome is the outcome of a consensus fault verification.
+type ConsensusFaultOutcome struct {
+	Fault *runtime7.ConsensusFault
+	Err   string
+}
+
+// ConsensusFaultKey returns the key identifying the consensus fault
+// verification with the supplied arguments in SyscallOverrides.
+func ConsensusFaultKey(a, b, extra []byte) string {
+	h := blake2b.New256()
+	for _, p := range [][]byte{a, b, extra} {
+		_, _ = fmt.Fprintf(h, "%d:", len(p))
+		_, _ = h.Write(p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ParseMockSyscalls builds the syscall overrides for the comma-separated list
+// of MockSyscalls* features found in the SelectorMockSyscalls selector.
+func ParseMockSyscalls(features string) (*SyscallOverrides, error) {
+	var o SyscallOverrides
+	for _, f := range strings.Split(features, ",") {
+		switch strings.TrimSpace(f) {
+		case MockSyscallsProofs:
+			o.PassProofs = true
+		case MockSyscallsSignatures:
+			o.VerifySignature = func(crypto.Signature, address.Address, []byte) error { return nil }
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown mock syscall feature: %s", f)
+		}
+	}
+	return &o, nil
+}
+
+// Builder returns a syscall builder that applies the overrides on top of the
+// real syscalls.
+func (o *SyscallOverrides) Builder() vm.SyscallBuilder {
+	var verifier storiface.Verifier = ffiwrapper.ProofVerifier
+	if o.PassProofs {
+		verifier = passingVerifier{Verifier: verifier}
+	}
+	base := vm.Syscalls(verifier)
+	return func(ctx context.Context, rt *vm.Runtime) runtime7.Syscalls {
+		return &overriddenSyscalls{Syscalls: base(ctx, rt), o: o}
+	}
+}
+
+type overriddenSyscalls struct {
+	runtime7.Syscalls
+	o *SyscallOverrides
+}
+
+func (s *overriddenSyscalls) VerifySignature(sig crypto.Signature, signer address.Address, plaintext []byte) error {
+	if s.o.VerifySignature != nil {
+		return s.o.VerifySignature(sig, signer, plaintext)
+	}
+	return s.Syscalls.VerifySignature(sig, signer, plaintext)
+}
+
+func (s *overriddenSyscalls) VerifyConsensusFault(a, b, extra []byte) (*runtime7.ConsensusFault, error) {
+	if out, ok := s.o.ConsensusFaults[ConsensusFaultKey(a, b, extra)]; ok {
+		if out.Err != "" {
+			return nil, fmt.Errorf("%s", out.Err)
+		}
+		return out.Fault, nil
+	}
+	return s.Syscalls.VerifyConsensusFault(a, b, extra)
+}
+
+// passingVerifier is a storiface.Verifier whose verifications always pass.
+type passingVerifier struct {
+	storiface.Verifier
+}
+
+func (passingVerifier) VerifySeal(proof.SealVerifyInfo) (bool, error) {
+	return true, nil
+}
+
+func (passingVerifier) VerifyAggregateSeals(proof.AggregateSealVerifyProofAndInfos) (bool, error) {
+	return true, nil
+}
+
+func (passingVerifier) VerifyReplicaUpdate(proof.ReplicaUpdateInfo) (bool, error) {
+	return true, nil
+}
+
+func (passingVerifier) VerifyWinningPoSt(context.Context, proof.WinningPoStVerifyInfo) (bool, error) {
+	return true, nil
+}
+
+func (passingVerifier) VerifyWindowPoSt(context.Context, proof.WindowPoStVerifyInfo) (bool, error) {
+	return true, nil
+}
+
+var _ storiface.Verifier = passingVerifier{}
@@ -0,0 +1,98 @@
+package conformance
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+// Vector tags are free-form labels recorded in the metadata of vectors, for
+// curating corpora: tools attach them at extraction and build time, filter
+// vectors by them at execution time, and group reports by them. A tag is a
+// path of one or more segments separated by '/', from the most general to
+// the most specific, e.g. "slow" or "regression/issue-1234"; segments are
+// made of lowercase letters, digits, '-', '_' and '.'. Filtering by a tag
+// selects the vectors carrying it, or any tag under it: "regression" selects
+// vectors tagged "regression/issue-1234".
+
+// ValidateTag returns an error if the tag doesn't follow the format of vector
+// tags.
+func ValidateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("empty tag")
+	}
+	for _, seg := range strings.Split(tag, "/") {
+		if seg == "" {
+			return fmt.Errorf("invalid tag %q: empty segment", tag)
+		}
+		for _, r := range seg {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			default:
+				return fmt.Errorf("invalid tag %q: invalid character %q; tags are lowercase letters, digits, '-', '_' and '.', in segments separated by '/'", tag, r)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateTags validates every tag; see ValidateTag.
+func ValidateTags(tags []string) error {
+	for _, t := range tags {
+		if err := ValidateTag(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchesTag returns whether the tag is, or falls under, the filter tag.
+func MatchesTag(tag, filter string) bool {
+	return tag == filter || strings.HasPrefix(tag, filter+"/")
+}
+
+// HasTag returns whether the vector carries a tag matching the filter tag;
+// see MatchesTag.
+func HasTag(vector *schema.TestVector, filter string) bool {
+	if vector.Meta == nil {
+		return false
+	}
+	for _, t := range vector.Meta.Tags {
+		if MatchesTag(t, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// TagFilterReason returns why the vector is filtered out by tags it must
+// carry all of, and skip tags it must carry none of, or "" if it's selected.
+func TagFilterReason(vector *schema.TestVector, tags, skip []string) string {
+	for _, t := range tags {
+		if !HasTag(vector, t) {
+			return fmt.Sprintf("not tagged %s", t)
+		}
+	}
+	for _, t := range skip {
+		if HasTag(vector, t) {
+			return fmt.Sprintf("tagged %s", t)
+		}
+	}
+	return ""
+}
+
+// MergeTags returns the tags with the supplied ones appended, except those
+// already present.
+func MergeTags(tags []string, add ...string) []string {
+	for _, a := range add {
+		var found bool
+		for _, t := range tags {
+			found = found || t == a
+		}
+		if !found {
+			tags = append(tags, a)
+		}
+	}
+	return tags
+}
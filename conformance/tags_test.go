@@ -0,0 +1,47 @@
+// stm: #unit
+package conformance
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/filecoin-project/test-vectors/schema"
+)
+
+func TestTags(t *testing.T) {
+	for _, tag := range []string{"slow", "regression/issue-1234", "nv18/fevm_v1.0"} {
+		if err := ValidateTag(tag); err != nil {
+			t.Errorf("expected tag %q to be valid: %s", tag, err)
+		}
+	}
+	for _, tag := range []string{"", "Slow", "regression/", "/slow", "a//b", "with space"} {
+		if err := ValidateTag(tag); err == nil {
+			t.Errorf("expected tag %q to be invalid", tag)
+		}
+	}
+
+	vector := &schema.TestVector{Meta: &schema.Metadata{Tags: []string{"slow", "regression/issue-1234"}}}
+	for _, c := range []struct {
+		tags, skip []string
+		reason     string
+	}{
+		{nil, nil, ""},
+		{[]string{"regression"}, nil, ""},
+		{[]string{"regression/issue-1234", "slow"}, nil, ""},
+		{[]string{"regress"}, nil, "not tagged regress"},
+		{[]string{"regression/issue-1"}, nil, "not tagged regression/issue-1"},
+		{nil, []string{"slow"}, "tagged slow"},
+		{[]string{"slow"}, []string{"regression"}, "tagged regression"},
+	} {
+		if reason := TagFilterReason(vector, c.tags, c.skip); reason != c.reason {
+			t.Errorf("tags %v, skip %v: expected %q, got %q", c.tags, c.skip, c.reason, reason)
+		}
+	}
+	if HasTag(&schema.TestVector{}, "slow") {
+		t.Error("expected a vector without metadata to carry no tags")
+	}
+
+	if tags := MergeTags([]string{"slow"}, "slow", "flaky"); !reflect.DeepEqual(tags, []string{"slow", "flaky"}) {
+		t.Errorf("unexpected merged tags: %v", tags)
+	}
+}
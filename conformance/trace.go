@@ -0,0 +1,139 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// SelectorExpectedTraces, if it appears in a vector, it indicates that the
+// call trees of the messages of the vector are asserted, besides their
+// receipts. Its value is the CID of the ExpectedTraces, which are stored as
+// a raw block in the vector's CAR.
+const SelectorExpectedTraces = "expected_traces"
+
+// CallTree is the normalized form of the execution trace of a message: the
+// receivers, methods and exit codes of the calls it made, free of gas
+// charges, durations and values, which receipts and state roots assert.
+type CallTree struct {
+	To       address.Address   `json:"to"`
+	Method   abi.MethodNum     `json:"method"`
+	ExitCode exitcode.ExitCode `json:"exit_code"`
+	Subcalls []CallTree        `json:"subcalls,omitempty"`
+}
+
+// NormalizeTrace returns the call tree of the execution trace.
+func NormalizeTrace(trace *types.ExecutionTrace) CallTree {
+	var t CallTree
+	if trace.Msg != nil {
+		t.To, t.Method = trace.Msg.To, trace.Msg.Method
+	}
+	if trace.MsgRct != nil {
+		t.ExitCode = trace.MsgRct.ExitCode
+	}
+	for i := range trace.Subcalls {
+		t.Subcalls = append(t.Subcalls, NormalizeTrace(&trace.Subcalls[i]))
+	}
+	return t
+}
+
+// DiffCallTrees returns a description of the first difference between the
+// expected and actual call trees, found depth-first, or "" if they match.
+func DiffCallTrees(expected, actual *CallTree) string {
+	return diffCallTrees(expected, actual, "root")
+}
+
+func diffCallTrees(expected, actual *CallTree, path string) string {
+	switch {
+	case expected.To != actual.To:
+		return fmt.Sprintf("%s: receiver: expected %s, got %s", path, expected.To, actual.To)
+	case expected.Method != actual.Method:
+		return fmt.Sprintf("%s: method: expected %d, got %d", path, expected.Method, actual.Method)
+	case expected.ExitCode != actual.ExitCode:
+		return fmt.Sprintf("%s: exit code: expected %s, got %s", path, expected.ExitCode, actual.ExitCode)
+	}
+	for i := 0; i < len(expected.Subcalls) && i < len(actual.Subcalls); i++ {
+		if d := diffCallTrees(&expected.Subcalls[i], &actual.Subcalls[i], fmt.Sprintf("%s.%d", path, i)); d != "" {
+			return d
+		}
+	}
+	if e, a := len(expected.Subcalls), len(actual.Subcalls); e != a {
+		return fmt.Sprintf("%s: subcalls: expected %d, got %d", path, e, a)
+	}
+	return ""
+}
+
+// ExpectedTraces are the call trees of the messages of a vector, in the
+// order of its receipts.
+type ExpectedTraces struct {
+	Traces []CallTree `json:"traces"`
+}
+
+// Store serializes the traces into a raw block in the supplied blockstore,
+// and returns its CID.
+func (t *ExpectedTraces) Store(ctx context.Context, bs blockstore.Blockstore) (cid.Cid, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to serialize expected traces: %w", err)
+	}
+
+	c, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.SHA2_256}.Sum(data)
+	if err != nil {
+		return cid.Undef, err
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := bs.Put(ctx, blk); err != nil {
+		return cid.Undef, fmt.Errorf("failed to store expected traces: %w", err)
+	}
+	return c, nil
+}
+
+// LoadExpectedTraces loads the traces stored under the supplied CID.
+func LoadExpectedTraces(ctx context.Context, bs blockstore.Blockstore, c cid.Cid) (*ExpectedTraces, error) {
+	blk, err := bs.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load expected traces %s: %w", c, err)
+	}
+	t := new(ExpectedTraces)
+	if err := json.Unmarshal(blk.RawData(), t); err != nil {
+		return nil, fmt.Errorf("failed to deserialize expected traces %s: %w", c, err)
+	}
+	return t, nil
+}
+
+// loadVectorTraces loads the expected traces of the vector, if it carries
+// SelectorExpectedTraces; it returns nil otherwise.
+func loadVectorTraces(ctx context.Context, bs blockstore.Blockstore, vector *schema.TestVector) (*ExpectedTraces, error) {
+	s, ok := vector.Selector[SelectorExpectedTraces]
+	if !ok {
+		return nil, nil
+	}
+	c, err := cid.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s selector: %w", SelectorExpectedTraces, err)
+	}
+	return LoadExpectedTraces(ctx, bs, c)
+}
+
+// traceAt returns the i-th expected trace, or nil if there are none.
+func (t *ExpectedTraces) traceAt(i int) *CallTree {
+	if t == nil || i >= len(t.Traces) {
+		return nil
+	}
+	return &t.Traces[i]
+}
@@ -0,0 +1,52 @@
+// stm: #unit
+package conformance
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestCallTrees(t *testing.T) {
+	a, _ := address.NewIDAddress(100)
+	b, _ := address.NewIDAddress(101)
+	trace := &types.ExecutionTrace{
+		Msg:    &types.Message{To: a, Method: 2},
+		MsgRct: &types.MessageReceipt{ExitCode: exitcode.Ok},
+		Subcalls: []types.ExecutionTrace{
+			{Msg: &types.Message{To: b, Method: 3}, MsgRct: &types.MessageReceipt{ExitCode: exitcode.ErrForbidden}},
+		},
+	}
+	expected := NormalizeTrace(trace)
+	if d := DiffCallTrees(&expected, &expected); d != "" {
+		t.Errorf("unexpected difference between identical call trees: %s", d)
+	}
+
+	// the sub-call failing differently is caught, although the outcome of
+	// the message is the same.
+	actual := NormalizeTrace(trace)
+	actual.Subcalls[0].ExitCode = exitcode.ErrIllegalArgument
+	if d := DiffCallTrees(&expected, &actual); !strings.HasPrefix(d, "root.0: exit code") {
+		t.Errorf("unexpected difference: %q", d)
+	}
+
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	c, err := (&ExpectedTraces{Traces: []CallTree{expected}}).Store(ctx, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadExpectedTraces(ctx, bs, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := DiffCallTrees(&expected, loaded.traceAt(0)); d != "" {
+		t.Errorf("call tree changed in the round trip: %s", d)
+	}
+}
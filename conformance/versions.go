@@ -0,0 +1,134 @@
+package conformance
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/manifest"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/stmgr"
+)
+
+// useVectorBundle switches to the network bundle of the built-in actors the
+// state tree at root was created with, so that the actor code CIDs of vectors
+// extracted from other networks (e.g. calibrationnet vectors in a mainnet
+// build) resolve to their actors. It returns a function restoring the bundle
+// in use before, which the caller MUST invoke.
+//
+// State trees created with the actors of specs-actors (before nv16) share the
+// same code CIDs across networks, and leave the bundle untouched.
+func useVectorBundle(bs blockstore.Blockstore, root cid.Cid) (restore func(), err error) {
+	restore = func() {}
+
+	st, err := state.LoadStateTree(cbor.NewCborStore(bs), root)
+	if err != nil {
+		return restore, fmt.Errorf("failed to load state tree: %w", err)
+	}
+	sys, err := st.GetActor(builtin.SystemActorAddr)
+	if err != nil {
+		return restore, fmt.Errorf("failed to load system actor: %w", err)
+	}
+
+	netw, ok := bundleNetworkOf(sys.Code)
+	if !ok || netw == build.NetworkBundle {
+		return restore, nil
+	}
+
+	prev := build.NetworkBundle
+	if err := build.UseNetworkBundle(netw); err != nil {
+		return restore, fmt.Errorf("failed to switch to the %s bundle: %w", netw, err)
+	}
+	return func() {
+		// the previous bundle was loaded successfully before.
+		_ = build.UseNetworkBundle(prev)
+	}, nil
+}
+
+// bundleNetworkOf returns the network of the embedded bundle the system actor
+// code belongs to, preferring the network of the current bundle if several
+// ship the same code.
+func bundleNetworkOf(systemCode cid.Cid) (string, bool) {
+	var found []string
+	for _, meta := range build.EmbeddedBuiltinActorsMetadata {
+		if meta.Actors[manifest.SystemKey] == systemCode {
+			found = append(found, meta.Network)
+		}
+	}
+	for _, netw := range found {
+		if netw == build.NetworkBundle {
+			return netw, true
+		}
+	}
+	if len(found) == 0 {
+		return "", false
+	}
+	return found[0], true
+}
+
+// useVectorVersions is like useVectorBundle, but additionally adjusts the gas
+// pricing to that of the network version, if the default upgrade schedule
+// yields another one at the epoch; see upgradeScheduleFor. It returns a
+// function reverting both, which the caller MUST invoke.
+func useVectorVersions(bs blockstore.Blockstore, root cid.Cid, epoch abi.ChainEpoch, nv network.Version) (restore func(), err error) {
+	restoreBundle, err := useVectorBundle(bs, root)
+	if err != nil {
+		return restoreBundle, err
+	}
+	if nv == 0 || networkVersionAt(filcns.DefaultUpgradeSchedule(), epoch) == nv {
+		return restoreBundle, nil
+	}
+	revertPricing := adjustGasPricing(epoch, nv)
+	return func() {
+		revertPricing()
+		restoreBundle()
+	}, nil
+}
+
+// DefaultNetworkVersionAt returns the network version the default upgrade
+// schedule of the build yields at the epoch.
+func DefaultNetworkVersionAt(epoch abi.ChainEpoch) network.Version {
+	return networkVersionAt(filcns.DefaultUpgradeSchedule(), epoch)
+}
+
+// networkVersionAt returns the network version the upgrade schedule yields at
+// the epoch.
+func networkVersionAt(us stmgr.UpgradeSchedule, epoch abi.ChainEpoch) network.Version {
+	nv := build.GenesisNetworkVersion
+	for _, u := range us {
+		if epoch <= u.Height {
+			break
+		}
+		nv = u.Network
+	}
+	return nv
+}
+
+// upgradeScheduleFor returns the upgrade schedule to execute tipsets at the
+// epoch with. It's the default upgrade schedule of the build, unless it
+// yields a network version other than nv at the epoch, as is the case for
+// vectors extracted from other networks. In that case, the network version is
+// pinned to nv, and no migrations run.
+func upgradeScheduleFor(epoch abi.ChainEpoch, nv network.Version) stmgr.UpgradeSchedule {
+	us := filcns.DefaultUpgradeSchedule()
+	if nv == 0 || networkVersionAt(us, epoch) == nv {
+		return us
+	}
+
+	var pinned stmgr.UpgradeSchedule
+	for _, u := range us {
+		if u.Network > nv {
+			break
+		}
+		pinned = append(pinned, stmgr.Upgrade{Network: u.Network, Height: -1})
+	}
+	return pinned
+}
@@ -0,0 +1,55 @@
+// stm: #unit
+package conformance
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/manifest"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+)
+
+func TestUpgradeScheduleFor(t *testing.T) {
+	us := filcns.DefaultUpgradeSchedule()
+	var upgrade = us[len(us)-1]
+	for _, u := range us {
+		if u.Height > 0 && u.Network > 1 {
+			upgrade = u
+			break
+		}
+	}
+	epoch := upgrade.Height + 1
+
+	if nv := networkVersionAt(us, epoch); nv != upgrade.Network {
+		t.Fatalf("expected network version %d at epoch %d, got %d", upgrade.Network, epoch, nv)
+	}
+	if got := upgradeScheduleFor(epoch, upgrade.Network); len(got) != len(us) {
+		t.Fatal("expected the default upgrade schedule when it yields the network version")
+	}
+
+	pinned := upgradeScheduleFor(epoch, upgrade.Network-1)
+	for _, e := range []int64{0, int64(epoch), 1 << 40} {
+		if nv := networkVersionAt(pinned, abi.ChainEpoch(e)); nv != upgrade.Network-1 {
+			t.Fatalf("expected pinned network version %d at epoch %d, got %d", upgrade.Network-1, e, nv)
+		}
+	}
+	for _, u := range pinned {
+		if u.Migration != nil || u.Height >= 0 {
+			t.Fatalf("expected no migrations in pinned schedule, got one into nv%d", u.Network)
+		}
+	}
+}
+
+func TestBundleNetworkOf(t *testing.T) {
+	for _, meta := range build.EmbeddedBuiltinActorsMetadata {
+		netw, ok := bundleNetworkOf(meta.Actors[manifest.SystemKey])
+		if !ok {
+			t.Fatalf("no network found for the system actor of %s v%d", meta.Network, meta.Version)
+		}
+		if meta.Network == build.NetworkBundle && netw != build.NetworkBundle {
+			t.Fatalf("expected the current network %s for the system actor of v%d, got %s", build.NetworkBundle, meta.Version, netw)
+		}
+	}
+}
@@ -57,6 +57,7 @@ type TargetAPI interface {
 	ChainNotify(context.Context) (<-chan []*api.HeadChange, error)
 	ChainGetPath(ctx context.Context, from, to types.TipSetKey) ([]*api.HeadChange, error)
 	ChainReadObj(context.Context, cid.Cid) ([]byte, error)
+	ChainReadObjMany(context.Context, []cid.Cid) ([][]byte, error)
 	ChainPutObj(context.Context, blocks.Block) error
 	ChainGetGenesis(context.Context) (*types.TipSet, error)
 	GasEstimateMessageGas(ctx context.Context, msg *types.Message, spec *api.MessageSendSpec, tsk types.TipSetKey) (*types.Message, error)
@@ -335,6 +336,16 @@ func (gw *Node) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) {
 	return gw.target.ChainReadObj(ctx, c)
 }
 
+func (gw *Node) ChainReadObjMany(ctx context.Context, cs []cid.Cid) ([][]byte, error) {
+	// reading many objects costs as much as reading them one by one.
+	for range cs {
+		if err := gw.limit(ctx, chainRateLimitTokens); err != nil {
+			return nil, err
+		}
+	}
+	return gw.target.ChainReadObjMany(ctx, cs)
+}
+
 func (gw *Node) ChainPutObj(context.Context, blocks.Block) error {
 	return xerrors.New("not supported")
 }
@@ -7,6 +7,7 @@ import (
 	"go.opencensus.io/tag"
 
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
 	"github.com/filecoin-project/lotus/metrics"
 )
 
@@ -22,6 +23,12 @@ func MetricedFullAPI(a api.FullNode) api.FullNode {
 	return &out
 }
 
+func MetricedFullAPIV0(a v0api.FullNode) v0api.FullNode {
+	var out v0api.FullNodeStruct
+	proxy(a, &out)
+	return &out
+}
+
 func MetricedWorkerAPI(a api.Worker) api.Worker {
 	var out api.WorkerStruct
 	proxy(a, &out)
@@ -251,6 +251,9 @@ func ConfigFullNode(c interface{}) Option {
 			Override(new(*modules.RPCHandler), modules.NewRPCHandler),
 			Override(GoRPCServer, modules.NewRPCServer),
 		),
+		If(cfg.Tvx.EnableExtract,
+			Override(new(dtypes.TvxExtractEnabled), dtypes.TvxExtractEnabled(true)),
+		),
 	)
 }
 
@@ -378,6 +378,12 @@ see https://lotus.filecoin.io/storage-providers/advanced-configurations/market/#
 			Name: "Cluster",
 			Type: "UserRaftConfig",
 
+			Comment: ``,
+		},
+		{
+			Name: "Tvx",
+			Type: "TvxConfig",
+
 			Comment: ``,
 		},
 	},
@@ -1262,6 +1268,17 @@ Default is 20 (about once a week).`,
 			Comment: ``,
 		},
 	},
+	"TvxConfig": []DocField{
+		{
+			Name: "EnableExtract",
+			Type: "bool",
+
+			Comment: `EXPERIMENTAL. EnableExtract exposes the TvxExtractMessage API method,
+which extracts test vectors in-process, sparing remote tvx clients the
+round trips of fetching the state through the API. Extractions are
+expensive, so this should only be enabled on nodes dedicated to them.`,
+		},
+	},
 	"UserRaftConfig": []DocField{
 		{
 			Name: "ClusterModeEnabled",
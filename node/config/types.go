@@ -27,6 +27,7 @@ type FullNode struct {
 	Fees       FeeConfig
 	Chainstore Chainstore
 	Cluster    UserRaftConfig
+	Tvx        TvxConfig
 }
 
 // // Common
@@ -658,3 +659,11 @@ type UserRaftConfig struct {
 	// Tracing enables propagation of contexts across binary boundaries.
 	Tracing bool
 }
+
+type TvxConfig struct {
+	// EXPERIMENTAL. EnableExtract exposes the TvxExtractMessage API method,
+	// which extracts test vectors in-process, sparing remote tvx clients the
+	// round trips of fetching the state through the API. Extractions are
+	// expensive, so this should only be enabled on nodes dedicated to them.
+	EnableExtract bool
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"time"
 
+	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/libp2p/go-libp2p/core/peer"
 
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
 	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/node/impl/client"
 	"github.com/filecoin-project/lotus/node/impl/common"
@@ -35,6 +37,7 @@ type FullNodeAPI struct {
 	full.WalletAPI
 	full.SyncAPI
 	full.RaftAPI
+	full.TvxAPI
 
 	DS          dtypes.MetadataDS
 	NetworkName dtypes.NetworkName
@@ -126,4 +129,8 @@ func (n *FullNodeAPI) RaftLeader(ctx context.Context) (peer.ID, error) {
 	return n.RaftAPI.Leader(ctx)
 }
 
+func (n *FullNodeAPI) TvxExtractMessage(ctx context.Context, msg cid.Cid, opts api.TvxExtractOpts) (<-chan api.TvxExtractEvent, error) {
+	return n.TvxAPI.ExtractMessage(ctx, &v0api.WrapperV1Full{FullNode: n}, msg, opts)
+}
+
 var _ api.FullNode = &FullNodeAPI{}
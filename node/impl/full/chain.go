@@ -50,6 +50,7 @@ type ChainModuleAPI interface {
 	ChainGetTipSetByHeight(ctx context.Context, h abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error)
 	ChainGetTipSetAfterHeight(ctx context.Context, h abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error)
 	ChainReadObj(context.Context, cid.Cid) ([]byte, error)
+	ChainReadObjMany(context.Context, []cid.Cid) ([][]byte, error)
 	ChainGetPath(ctx context.Context, from, to types.TipSetKey) ([]*api.HeadChange, error)
 }
 
@@ -260,6 +261,22 @@ func (m *ChainModule) ChainReadObj(ctx context.Context, obj cid.Cid) ([]byte, er
 	return blk.RawData(), nil
 }
 
+func (m *ChainModule) ChainReadObjMany(ctx context.Context, objs []cid.Cid) ([][]byte, error) {
+	if len(objs) > api.ChainReadObjManyLimit {
+		return nil, xerrors.Errorf("too many objects requested: %d (max %d)", len(objs), api.ChainReadObjManyLimit)
+	}
+
+	out := make([][]byte, len(objs))
+	for i, obj := range objs {
+		blk, err := m.ExposedBlockstore.Get(ctx, obj)
+		if err != nil {
+			return nil, xerrors.Errorf("blockstore get %s: %w", obj, err)
+		}
+		out[i] = blk.RawData()
+	}
+	return out, nil
+}
+
 func (a *ChainAPI) ChainPutObj(ctx context.Context, obj blocks.Block) error {
 	return a.ExposedBlockstore.Put(ctx, obj)
 }
@@ -0,0 +1,117 @@
+package full
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"go.uber.org/fx"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/test-vectors/schema"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/conformance/extractor"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+)
+
+// tvxVectorChunkSize is the size of the chunks vectors are streamed back in,
+// so that large vectors don't exceed the message size limits of the RPC.
+const tvxVectorChunkSize = 1 << 20
+
+type TvxAPI struct {
+	fx.In
+
+	Enabled dtypes.TvxExtractEnabled `optional:"true"`
+}
+
+// ExtractMessage extracts a message vector through the supplied node, which
+// is the node serving the API, so that the state is read in-process.
+func (a *TvxAPI) ExtractMessage(ctx context.Context, node v0api.FullNode, msg cid.Cid, opts api.TvxExtractOpts) (<-chan api.TvxExtractEvent, error) {
+	if !a.Enabled {
+		return nil, xerrors.Errorf("tvx extraction not enabled. Please check your configuration")
+	}
+
+	out := make(chan api.TvxExtractEvent, 16)
+	send := func(e api.TvxExtractEvent) bool {
+		select {
+		case out <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	xopts := extractor.Options{
+		Class:              string(schema.ClassMessage),
+		ID:                 opts.ID,
+		CID:                msg.String(),
+		Retain:             opts.Retain,
+		Precursor:          opts.Precursor,
+		EmbedPrecursors:    opts.EmbedPrecursors,
+		IgnorePrecursors:   opts.IgnorePrecursors,
+		MaxPrecursors:      opts.MaxPrecursors,
+		Selectors:          opts.Selectors,
+		Hints:              opts.Hints,
+		RecordSyscalls:     opts.RecordSyscalls,
+		IgnoreSanityChecks: opts.IgnoreSanityChecks,
+		Force:              opts.Force,
+		Hooks: extractor.Hooks{
+			OnEvent: func(event string, kvs ...interface{}) {
+				fields := make(map[string]interface{}, len(kvs)/2)
+				for i := 0; i+1 < len(kvs); i += 2 {
+					fields[fmt.Sprint(kvs[i])] = kvs[i+1]
+				}
+				send(api.TvxExtractEvent{Event: event, Fields: fields})
+			},
+		},
+	}
+	if opts.Block.Defined() {
+		xopts.Block = opts.Block.String()
+	}
+	if xopts.Retain == "" {
+		xopts.Retain = "accessed-cids"
+	}
+	if xopts.Precursor == "" {
+		xopts.Precursor = extractor.PrecursorSelectParticipants
+	}
+
+	go func() {
+		defer close(out)
+
+		vector, err := extractor.Extract(ctx, node, xopts)
+		if err != nil {
+			send(api.TvxExtractEvent{Event: "error", Error: err.Error()})
+			return
+		}
+		data, err := json.Marshal(vector)
+		if err != nil {
+			send(api.TvxExtractEvent{Event: "error", Error: fmt.Sprintf("failed to encode vector: %s", err)})
+			return
+		}
+		for _, chunk := range tvxVectorChunks(data) {
+			if !send(api.TvxExtractEvent{Event: "vector", Data: chunk}) {
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// tvxVectorChunks splits the encoded vector into the chunks it's streamed
+// back in, of tvxVectorChunkSize bytes at most.
+func tvxVectorChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := tvxVectorChunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
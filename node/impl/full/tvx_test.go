@@ -0,0 +1,51 @@
+// stm: #unit
+package full
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+)
+
+func TestTvxExtractDisabled(t *testing.T) {
+	a := &TvxAPI{}
+	_, err := a.ExtractMessage(context.Background(), new(v0api.FullNodeStub), cid.Undef, api.TvxExtractOpts{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "not enabled")
+}
+
+func TestTvxExtractEnabled(t *testing.T) {
+	msg, err := cid.Decode("bafy2bzacea3wsdh6y3a36tb3skempjoxqpuyompjbmfeyf34fi3uy6uue42v4")
+	require.NoError(t, err)
+
+	// the stub node supports no method, so the extraction fails as soon as it
+	// reads the chain; the failure is streamed back as the last event.
+	a := &TvxAPI{Enabled: true}
+	events, err := a.ExtractMessage(context.Background(), new(v0api.FullNodeStub), msg, api.TvxExtractOpts{})
+	require.NoError(t, err)
+
+	var last api.TvxExtractEvent
+	for e := range events {
+		last = e
+	}
+	require.Equal(t, "error", last.Event)
+	require.NotEmpty(t, last.Error)
+}
+
+func TestTvxVectorChunks(t *testing.T) {
+	require.Empty(t, tvxVectorChunks(nil))
+
+	data := bytes.Repeat([]byte{'v'}, 2*tvxVectorChunkSize+1)
+	chunks := tvxVectorChunks(data)
+	require.Len(t, chunks, 3)
+	require.Len(t, chunks[0], tvxVectorChunkSize)
+	require.Len(t, chunks[1], tvxVectorChunkSize)
+	require.Len(t, chunks[2], 1)
+	require.Equal(t, data, bytes.Join(chunks, nil))
+}
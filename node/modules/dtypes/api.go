@@ -12,3 +12,7 @@ type APIAlg jwt.HMACSHA
 type APIEndpoint multiaddr.Multiaddr
 
 type NodeStartTime time.Time
+
+// TvxExtractEnabled is whether the Tvx API methods, extracting test vectors
+// in-process, are enabled.
+type TvxExtractEnabled bool